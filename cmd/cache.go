@@ -0,0 +1,134 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
+
+	"github.com/spf13/cobra"
+)
+
+// Section defining all the command that we have in cache feature
+var cacheCmd = &cobra.Command{
+	Use:               "cache",
+	Short:             "Manage local cache of files in a mounted container",
+	Long:              "Manage local cache of files in a mounted container. Cache classes set here apply to the mount till it is unmounted, use cache-classes in config to set them for every mount.",
+	SuggestFor:        []string{"cach", "cahce"},
+	Example:           "blobfuse2 cache pin /mnt/blobfuse/models",
+	FlagErrorHandling: cobra.ExitOnError,
+}
+
+var cachePinCmd = &cobra.Command{
+	Use:               "pin <path>...",
+	Short:             "Pin files or directories in the local cache so that they are never evicted",
+	Long:              "Pin files or directories in the local cache so that they are never evicted. Pinning a directory pins everything under it.",
+	Example:           "blobfuse2 cache pin /mnt/blobfuse/models /mnt/blobfuse/tables/lookup.csv",
+	Args:              cobra.MinimumNArgs(1),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setCacheClass(args, cache_class.Pin)
+	},
+}
+
+var cacheUnpinCmd = &cobra.Command{
+	Use:               "unpin <path>...",
+	Short:             "Remove the cache class set on files or directories",
+	Long:              "Remove the cache class set on files or directories with pin or class commands, cache-classes in config decide their class again.",
+	Example:           "blobfuse2 cache unpin /mnt/blobfuse/models",
+	Args:              cobra.MinimumNArgs(1),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, path := range args {
+			err := syscall.Removexattr(path, common.CacheClassXattr)
+			if err != nil && err != syscall.ENODATA {
+				return fmt.Errorf("failed to unpin %s [%s]", path, err.Error())
+			}
+		}
+		return nil
+	},
+}
+
+var cacheClassCmd = &cobra.Command{
+	Use:               "class <path> [pin|normal|evict-first|no-cache]",
+	Short:             "Get or set the cache class of a file or directory",
+	Long:              "Get or set the cache class of a file or directory. Pinned files are never evicted, evict-first files are evicted before others when the cache is running out of space and no-cache files are not kept in the local cache once they are closed.",
+	Example:           "blobfuse2 cache class /mnt/blobfuse/scratch evict-first",
+	Args:              cobra.RangeArgs(1, 2),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 2 {
+			class, err := cache_class.Parse(args[1])
+			if err != nil {
+				return err
+			}
+			return setCacheClass(args[:1], class)
+		}
+
+		class, err := getXattrValue(args[0], common.CacheClassXattr)
+		if err != nil {
+			return fmt.Errorf("failed to get cache class of %s [%s]", args[0], err.Error())
+		}
+
+		if class == "" {
+			return fmt.Errorf("cache class not available, path is not in a blobfuse2 mount with file-cache or block-cache")
+		}
+
+		fmt.Println(args[0], "=", class)
+		return nil
+	},
+}
+
+//--------------- command section ends
+
+// setCacheClass : Set the cache class of the given paths through the mount
+func setCacheClass(paths []string, class cache_class.Class) error {
+	for _, path := range paths {
+		err := syscall.Setxattr(path, common.CacheClassXattr, []byte(class.String()), 0)
+		if err != nil {
+			return fmt.Errorf("failed to set cache class of %s to %s [%s]", path, class, err.Error())
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cachePinCmd)
+	cacheCmd.AddCommand(cacheUnpinCmd)
+	cacheCmd.AddCommand(cacheClassCmd)
+}
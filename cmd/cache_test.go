@@ -0,0 +1,206 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type cacheCmdTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	dir    string
+}
+
+func (suite *cacheCmdTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+
+	warmOpts = warmOptions{}
+	suite.dir = suite.T().TempDir()
+}
+
+func TestCacheCommand(t *testing.T) {
+	suite.Run(t, new(cacheCmdTestSuite))
+}
+
+func (suite *cacheCmdTestSuite) createFile(name string, size int) string {
+	path := filepath.Join(suite.dir, name)
+	suite.assert.NoError(os.MkdirAll(filepath.Dir(path), 0777))
+	suite.assert.NoError(os.WriteFile(path, make([]byte, size), 0666))
+	return path
+}
+
+func (suite *cacheCmdTestSuite) TestHelp() {
+	_, err := executeCommandC(rootCmd, "cache", "-h")
+	suite.assert.Nil(err)
+
+	_, err = executeCommandC(rootCmd, "cache", "warm", "-h")
+	suite.assert.Nil(err)
+
+	// Help flag sticks to the command once set
+	_ = cacheWarmCmd.Flags().Set("help", "false")
+}
+
+func (suite *cacheCmdTestSuite) TestPinNotExistent() {
+	_, err := executeCommandC(rootCmd, "cache", "pin", "/tmp/cache_test_does_not_exist")
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "failed to set cache class")
+}
+
+func (suite *cacheCmdTestSuite) TestClassInvalid() {
+	_, err := executeCommandC(rootCmd, "cache", "class", "/tmp/cache_test_does_not_exist", "sticky")
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "invalid cache class")
+}
+
+func (suite *cacheCmdTestSuite) TestClassNotMounted() {
+	path := suite.createFile("file", 10)
+
+	// Files outside a blobfuse2 mount do not have the cache class attribute
+	_, err := executeCommandC(rootCmd, "cache", "class", path)
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "cache class not available")
+}
+
+func (suite *cacheCmdTestSuite) TestWarmNotMounted() {
+	_, err := executeCommandC(rootCmd, "cache", "warm", "--mount-path", suite.dir, "file")
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "is not a blobfuse2 mount")
+}
+
+func (suite *cacheCmdTestSuite) TestWarmConfigWithoutCache() {
+	cfg := filepath.Join(suite.dir, "config.yaml")
+	suite.assert.NoError(os.WriteFile(cfg, []byte("components:\n  - libfuse\n  - attr_cache\n  - azstorage\n"), 0666))
+
+	_, err := executeCommandC(rootCmd, "cache", "warm", "--config-file", cfg, "file")
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "neither file_cache nor block_cache")
+}
+
+func (suite *cacheCmdTestSuite) TestExpandWarmArgs() {
+	a := suite.createFile("data/a.bin", 10)
+	b := suite.createFile("data/sub/b.bin", 10)
+	c := suite.createFile("models/c.idx", 10)
+	d := suite.createFile("models/d.bin", 10)
+
+	list := filepath.Join(suite.T().TempDir(), "list.txt")
+	suite.assert.NoError(os.WriteFile(list, []byte("# models to warm\n\nmodels/d.bin\n"+c+"\n"), 0666))
+
+	// Directories are walked, globs are matched from the mount path and duplicates are dropped
+	files, err := expandWarmArgs(suite.dir, []string{"data", "models/*.idx", "@" + list, "data/a.bin"})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]string{a, b, c, d}, files)
+}
+
+func (suite *cacheCmdTestSuite) TestExpandWarmArgsErrors() {
+	suite.createFile("data/a.bin", 10)
+
+	_, err := expandWarmArgs(suite.dir, []string{"/etc/hosts"})
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "is not in mount")
+
+	_, err = expandWarmArgs(suite.dir, []string{"../a.bin"})
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "is not in mount")
+
+	_, err = expandWarmArgs(suite.dir, []string{"data/*.idx"})
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "no files match")
+
+	_, err = expandWarmArgs(suite.dir, []string{"data/b.bin"})
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "failed to list")
+
+	_, err = expandWarmArgs(suite.dir, []string{"@" + filepath.Join(suite.dir, "missing.txt")})
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "failed to open list file")
+}
+
+func (suite *cacheCmdTestSuite) TestRateLimiter() {
+	suite.Nil(newRateLimiter(0))
+	newRateLimiter(0).wait(common.MbToBytes)
+
+	// First read goes right away, the next ones wait for their share of the bandwidth
+	limiter := newRateLimiter(10)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.wait(common.MbToBytes)
+	}
+	suite.assert.GreaterOrEqual(time.Since(start), 190*time.Millisecond)
+}
+
+func (suite *cacheCmdTestSuite) TestWarmFiles() {
+	files := []string{
+		suite.createFile("a", 3*warmReadSize+10),
+		suite.createFile("b", 10),
+		suite.createFile("c", 0),
+	}
+
+	out := &bytes.Buffer{}
+	stats := warmFiles(files, warmOptions{Concurrency: 2, Quiet: true}, out)
+	suite.assert.EqualValues(3, stats.files.Load())
+	suite.assert.EqualValues(3*warmReadSize+20, stats.bytes.Load())
+
+	// Files outside a mount have no cache status, so they all count as misses
+	suite.assert.EqualValues(3, stats.misses.Load())
+	suite.assert.Zero(stats.hits.Load())
+	suite.assert.Zero(stats.failed.Load())
+
+	stats.print(out)
+	suite.assert.Contains(out.String(), "Warmed 3 files")
+	suite.assert.Contains(out.String(), "misses   : 3")
+}
+
+func (suite *cacheCmdTestSuite) TestWarmFilesFailed() {
+	files := []string{suite.createFile("a", 10), filepath.Join(suite.dir, "missing")}
+
+	out := &bytes.Buffer{}
+	stats := warmFiles(files, warmOptions{Concurrency: 1, Quiet: true}, out)
+	suite.assert.EqualValues(1, stats.failed.Load())
+	suite.assert.Contains(out.String(), "failed to warm")
+}
@@ -0,0 +1,440 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+
+	"github.com/spf13/cobra"
+)
+
+type warmOptions struct {
+	ConfigFile  string
+	MountPath   string
+	Concurrency int
+	Bandwidth   float64
+	Quiet       bool
+}
+
+var warmOpts warmOptions
+
+// Size of each read issued on a file being warmed
+const warmReadSize = 1024 * 1024
+
+var cacheWarmCmd = &cobra.Command{
+	Use:   "warm <path|glob|@listfile>...",
+	Short: "Download files to the local cache of a running mount ahead of their use",
+	Long: "Download files to the local cache of file-cache or block-cache of a running mount ahead of their use, e.g. before a training job starts.\n" +
+		"Files are read through the mount so that the data lands in the cache that the mount serves reads from. Paths and globs are relative to the mount path unless absolute, " +
+		"directories are warmed recursively and @file reads one path or glob per line from the file.\n" +
+		"Mount path is taken from --mount-path, mount-path in the config file or the only blobfuse2 mount on the host. Files already in the cache are counted as hits and are not read again.",
+	Example:           "blobfuse2 cache warm --config-file=config.yaml --concurrency=16 --max-bandwidth-mb=200 datasets/train 'models/*.bin' @files.txt",
+	Args:              cobra.MinimumNArgs(1),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mountPath, err := warmMountPath()
+		if err != nil {
+			return err
+		}
+
+		files, err := expandWarmArgs(mountPath, args)
+		if err != nil {
+			return err
+		}
+
+		if len(files) == 0 {
+			return errors.New("no files to warm")
+		}
+
+		stats := warmFiles(files, warmOpts, cmd.OutOrStdout())
+		stats.print(cmd.OutOrStdout())
+
+		if stats.failed.Load() > 0 {
+			return fmt.Errorf("failed to warm %d of %d files", stats.failed.Load(), len(files))
+		}
+		return nil
+	},
+}
+
+// warmMountPath : Mount path of the running mount whose cache is to be warmed
+func warmMountPath() (string, error) {
+	mountPath := common.ExpandPath(warmOpts.MountPath)
+
+	if warmOpts.ConfigFile != "" {
+		options.ConfigFile = warmOpts.ConfigFile
+		err := parseConfig()
+		if err != nil {
+			return "", err
+		}
+
+		err = validateWarmConfig()
+		if err != nil {
+			return "", err
+		}
+
+		if mountPath == "" {
+			_ = config.UnmarshalKey("mount-path", &mountPath)
+			mountPath = common.ExpandPath(mountPath)
+		}
+	}
+
+	if mountPath == "" {
+		mounts, err := common.ListMountPoints()
+		if err != nil {
+			return "", fmt.Errorf("failed to list mount points [%s]", err.Error())
+		}
+
+		if len(mounts) != 1 {
+			return "", fmt.Errorf("found %d blobfuse2 mounts, use --mount-path to choose the one to warm", len(mounts))
+		}
+		mountPath = mounts[0]
+	}
+
+	mountPath = filepath.Clean(mountPath)
+	if !common.IsDirectoryMounted(mountPath) {
+		return "", fmt.Errorf("%s is not a blobfuse2 mount, cache can be warmed only through a running mount", mountPath)
+	}
+
+	return mountPath, nil
+}
+
+// validateWarmConfig : Warming makes sense only if the mount caches data on local disk
+func validateWarmConfig() error {
+	var components []string
+	_ = config.UnmarshalKey("components", &components)
+
+	for _, comp := range components {
+		switch comp {
+		case "file_cache":
+			var timeout uint32
+			if config.IsSet("file_cache.timeout-sec") {
+				_ = config.UnmarshalKey("file_cache.timeout-sec", &timeout)
+				if timeout == 0 {
+					fmt.Println("WARNING: file_cache timeout-sec is 0, files are removed from the cache as soon as they are warmed")
+				}
+			}
+			return nil
+
+		case "block_cache":
+			var path string
+			_ = config.UnmarshalKey("block_cache.path", &path)
+			if path == "" {
+				return errors.New("block_cache has no disk cache path, nothing can be warmed")
+			}
+			return nil
+		}
+	}
+
+	return errors.New("config has neither file_cache nor block_cache, nothing can be warmed")
+}
+
+// expandWarmArgs : Files to be warmed given paths, globs and list files. Paths have to be in the mount.
+func expandWarmArgs(mountPath string, args []string) ([]string, error) {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			err := expandWarmPath(mountPath, arg, add)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		f, err := os.Open(common.ExpandPath(arg[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open list file %s [%s]", arg[1:], err.Error())
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			err = expandWarmPath(mountPath, line, add)
+			if err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			err = scanner.Err()
+		}
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// expandWarmPath : Add the files matching a path or glob, directories are walked recursively
+func expandWarmPath(mountPath string, arg string, add func(string)) error {
+	path := arg
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(mountPath, path)
+	}
+	path = filepath.Clean(path)
+
+	if path != mountPath && !strings.HasPrefix(path, mountPath+"/") {
+		return fmt.Errorf("%s is not in mount %s", arg, mountPath)
+	}
+
+	matches := []string{path}
+	if strings.ContainsAny(arg, "*?[") {
+		var err error
+		matches, err = filepath.Glob(path)
+		if err != nil {
+			return fmt.Errorf("invalid glob %s [%s]", arg, err.Error())
+		}
+
+		if len(matches) == 0 {
+			return fmt.Errorf("no files match %s", arg)
+		}
+	}
+
+	for _, match := range matches {
+		err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.Type().IsRegular() {
+				add(path)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to list %s [%s]", match, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// rateLimiter : Spaces out reads so that together they do not go over the given bytes per second
+type rateLimiter struct {
+	sync.Mutex
+	rate float64   // Bytes per second
+	next time.Time // Time at which the next read may start
+}
+
+func newRateLimiter(mbps float64) *rateLimiter {
+	if mbps <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: mbps * common.MbToBytes}
+}
+
+// wait : Block till n bytes can be read
+func (l *rateLimiter) wait(n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.Unlock()
+
+	time.Sleep(delay)
+}
+
+type warmStats struct {
+	files    atomic.Int64 // Files done so far
+	hits     atomic.Int64 // Files which were in the cache already
+	misses   atomic.Int64 // Files which had to be downloaded
+	partial  atomic.Int64 // Misses which were partly in the cache
+	uncached atomic.Int64 // Files not fully in the cache after being read, e.g. cache is too small to hold them
+	failed   atomic.Int64
+	bytes    atomic.Int64
+	total    int
+	start    time.Time
+}
+
+func (s *warmStats) rate() float64 {
+	return float64(s.bytes.Load()) / common.MbToBytes / max(time.Since(s.start).Seconds(), 0.001)
+}
+
+func (s *warmStats) print(out io.Writer) {
+	fmt.Fprintf(out, "Warmed %d files, read %.1f MB in %v (%.1f MB/s)\n",
+		s.total, float64(s.bytes.Load())/common.MbToBytes, time.Since(s.start).Round(time.Millisecond), s.rate())
+	fmt.Fprintf(out, "  hits     : %d (already in cache)\n", s.hits.Load())
+	fmt.Fprintf(out, "  misses   : %d (%d partly in cache)\n", s.misses.Load(), s.partial.Load())
+	if s.uncached.Load() > 0 {
+		fmt.Fprintf(out, "  uncached : %d (not fully in cache after warming, cache may be too small or files may be marked no-cache)\n", s.uncached.Load())
+	}
+	if s.failed.Load() > 0 {
+		fmt.Fprintf(out, "  failed   : %d\n", s.failed.Load())
+	}
+}
+
+// warmFiles : Read the files through the mount with the given concurrency, progress is written to out once a second
+func warmFiles(files []string, opts warmOptions, out io.Writer) *warmStats {
+	stats := &warmStats{total: len(files), start: time.Now()}
+	limiter := newRateLimiter(opts.Bandwidth)
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				err := warmFile(path, limiter, stats)
+				if err != nil {
+					stats.failed.Add(1)
+					fmt.Fprintf(out, "failed to warm %s [%s]\n", path, err.Error())
+				}
+				stats.files.Add(1)
+			}
+		}()
+	}
+
+	done := make(chan bool)
+	if !opts.Quiet {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					fmt.Fprintf(out, "%d/%d files, %.1f MB, %.1f MB/s\n",
+						stats.files.Load(), stats.total, float64(stats.bytes.Load())/common.MbToBytes, stats.rate())
+				}
+			}
+		}()
+	}
+
+	for _, path := range files {
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
+	close(done)
+
+	return stats
+}
+
+// warmFile : Read the file through the mount unless it is in the cache already
+func warmFile(path string, limiter *rateLimiter, stats *warmStats) error {
+	status, _ := getXattrValue(path, common.CacheStatusXattr)
+	if status == common.CacheStatusCached {
+		stats.hits.Add(1)
+		return nil
+	}
+
+	stats.misses.Add(1)
+	if status == common.CacheStatusPartial {
+		stats.partial.Add(1)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// File-cache downloads the whole file on open, there is nothing more to read
+	status, _ = getXattrValue(path, common.CacheStatusXattr)
+	if status == common.CacheStatusCached {
+		info, err := f.Stat()
+		if err == nil {
+			stats.bytes.Add(info.Size())
+			limiter.wait(info.Size())
+		}
+		return nil
+	}
+
+	buf := make([]byte, warmReadSize)
+	for {
+		limiter.wait(warmReadSize)
+		n, err := f.Read(buf)
+		stats.bytes.Add(int64(n))
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	status, _ = getXattrValue(path, common.CacheStatusXattr)
+	if status != "" && status != common.CacheStatusCached {
+		stats.uncached.Add(1)
+	}
+	return nil
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheWarmCmd)
+
+	cacheWarmCmd.Flags().StringVar(&warmOpts.ConfigFile, "config-file", "", "Config file of the mount, used to find its mount path and check that it caches data on disk.")
+	cacheWarmCmd.Flags().StringVar(&warmOpts.MountPath, "mount-path", "", "Path of the running mount to warm.")
+	cacheWarmCmd.Flags().IntVar(&warmOpts.Concurrency, "concurrency", 8, "Number of files read in parallel.")
+	cacheWarmCmd.Flags().Float64Var(&warmOpts.Bandwidth, "max-bandwidth-mb", 0, "Limit on MB per second read through the mount, 0 for no limit.")
+	cacheWarmCmd.Flags().BoolVar(&warmOpts.Quiet, "quiet", false, "Do not print progress while files are warmed.")
+}
@@ -37,9 +37,12 @@ import (
 	_ "github.com/Azure/azure-storage-fuse/v2/component/attr_cache"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/azstorage"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/block_cache"
+	_ "github.com/Azure/azure-storage-fuse/v2/component/chaos"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/custom"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/entry_cache"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/file_cache"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/libfuse"
 	_ "github.com/Azure/azure-storage-fuse/v2/component/loopback"
+	_ "github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	_ "github.com/Azure/azure-storage-fuse/v2/component/s3storage"
 )
@@ -0,0 +1,184 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/metadata_index"
+
+	"github.com/spf13/cobra"
+)
+
+type indexOptions struct {
+	ConfigFile  string
+	Parallelism uint32
+}
+
+var indexOpts indexOptions
+
+// Section defining all the command that we have in index feature
+var indexCmd = &cobra.Command{
+	Use:               "index",
+	Short:             "Manage the metadata index of a container",
+	Long:              "Manage the metadata index of a container. The index is a snapshot of the paths in the container kept on disk at metadata-index path in config, attr-cache and entry-cache serve lookups and listings from it so that a mount does not have to list the container first.",
+	SuggestFor:        []string{"indx", "idx"},
+	Example:           "blobfuse2 index build --config-file=config.yaml",
+	FlagErrorHandling: cobra.ExitOnError,
+}
+
+var indexBuildCmd = &cobra.Command{
+	Use:               "build",
+	Short:             "List the whole container and write a new metadata index",
+	Long:              "List the whole container and write a new metadata index to metadata-index path in config, replacing the existing one.",
+	Example:           "blobfuse2 index build --config-file=config.yaml --parallelism=64",
+	Args:              cobra.NoArgs,
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, storage, err := startIndexStorage()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = storage.Stop() }()
+
+		idx := metadata_index.New(metadata_index.SourceID())
+		err = idx.Refresh(context.Background(), storage, "", int(opts.Parallelism))
+		if err != nil {
+			return fmt.Errorf("failed to build index [%s]", err.Error())
+		}
+
+		return saveIndex(cmd, idx, opts)
+	},
+}
+
+var indexRefreshCmd = &cobra.Command{
+	Use:               "refresh [path]...",
+	Short:             "List directories of the container again and update the metadata index",
+	Long:              "List the given directories of the container, relative to the container root, again and update them in the metadata index. The whole container is listed if no directory is given.",
+	Example:           "blobfuse2 index refresh --config-file=config.yaml datasets/train",
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, storage, err := startIndexStorage()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = storage.Stop() }()
+
+		if len(args) == 0 {
+			args = []string{""}
+		}
+
+		idx := metadata_index.Open(opts, metadata_index.SourceID())
+		for _, path := range args {
+			err = idx.Refresh(context.Background(), storage, strings.Trim(path, "/"), int(opts.Parallelism))
+			if err != nil {
+				return fmt.Errorf("failed to refresh %s in index [%s]", path, err.Error())
+			}
+		}
+
+		return saveIndex(cmd, idx, opts)
+	},
+}
+
+//--------------- command section ends
+
+// startIndexStorage : Read the config and start the storage component the index is listed from
+func startIndexStorage() (metadata_index.Options, internal.Component, error) {
+	options.ConfigFile = indexOpts.ConfigFile
+	err := parseConfig()
+	if err != nil {
+		return metadata_index.Options{}, nil, err
+	}
+
+	opts, found, err := metadata_index.ReadOptions()
+	if err != nil {
+		return opts, nil, err
+	}
+	if !found {
+		return opts, nil, fmt.Errorf("%s is not set in config file", metadata_index.ConfigKey)
+	}
+
+	if indexOpts.Parallelism > 0 {
+		opts.Parallelism = indexOpts.Parallelism
+	}
+
+	components := make([]string, 0)
+	err = config.UnmarshalKey("components", &components)
+	if err != nil || len(components) == 0 {
+		return opts, nil, errors.New("components are not set in config file")
+	}
+
+	storage := internal.GetComponent(components[len(components)-1])
+	if storage == nil {
+		return opts, nil, fmt.Errorf("component %s does not exist", components[len(components)-1])
+	}
+
+	err = storage.Configure(true)
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to configure %s [%s]", storage.Name(), err.Error())
+	}
+
+	err = storage.Start(context.Background())
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to start %s [%s]", storage.Name(), err.Error())
+	}
+
+	return opts, storage, nil
+}
+
+func saveIndex(cmd *cobra.Command, idx *metadata_index.Index, opts metadata_index.Options) error {
+	err := idx.Save(opts.Path)
+	if err != nil {
+		return fmt.Errorf("failed to save index to %s [%s]", opts.Path, err.Error())
+	}
+
+	dirs, entries := idx.Len()
+	fmt.Fprintf(cmd.OutOrStdout(), "Saved %d entries of %d directories to %s\n", entries, dirs, opts.Path)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.AddCommand(indexBuildCmd)
+	indexCmd.AddCommand(indexRefreshCmd)
+
+	indexCmd.PersistentFlags().StringVar(&indexOpts.ConfigFile, "config-file", "config.yaml", "Config file of the mount, storage and metadata-index settings are read from it.")
+	_ = indexCmd.MarkPersistentFlagFilename("config-file", "yaml")
+	indexCmd.PersistentFlags().Uint32Var(&indexOpts.Parallelism, "parallelism", 0, "Number of directories listed in parallel, overrides metadata-index parallelism in config.")
+}
@@ -0,0 +1,158 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal/metadata_index"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type indexCmdTestSuite struct {
+	suite.Suite
+	assert    *assert.Assertions
+	container string
+	index     string
+	cfg       string
+}
+
+func (suite *indexCmdTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+
+	config.ResetConfig()
+	indexOpts = indexOptions{}
+
+	dir := suite.T().TempDir()
+	suite.container = filepath.Join(dir, "container")
+	suite.index = filepath.Join(dir, "index", "container.idx")
+	suite.cfg = filepath.Join(dir, "config.yaml")
+
+	cfg := fmt.Sprintf("components:\n  - libfuse\n  - attr_cache\n  - loopbackfs\nloopbackfs:\n  path: %s\nmetadata-index:\n  path: %s\n", suite.container, suite.index)
+	suite.assert.NoError(os.WriteFile(suite.cfg, []byte(cfg), 0644))
+}
+
+func TestIndexCommand(t *testing.T) {
+	suite.Run(t, new(indexCmdTestSuite))
+}
+
+func (suite *indexCmdTestSuite) createFile(name string) {
+	path := filepath.Join(suite.container, name)
+	suite.assert.NoError(os.MkdirAll(filepath.Dir(path), 0777))
+	suite.assert.NoError(os.WriteFile(path, []byte("data"), 0666))
+}
+
+func (suite *indexCmdTestSuite) load() *metadata_index.Index {
+	idx, err := metadata_index.Load(suite.index, metadata_index.SourceID())
+	suite.assert.NoError(err)
+	return idx
+}
+
+func (suite *indexCmdTestSuite) TestHelp() {
+	_, err := executeCommandC(rootCmd, "index", "-h")
+	suite.assert.Nil(err)
+}
+
+func (suite *indexCmdTestSuite) TestNotConfigured() {
+	suite.assert.NoError(os.WriteFile(suite.cfg, []byte("components:\n  - libfuse\n  - loopbackfs\n"), 0644))
+
+	_, err := executeCommandC(rootCmd, "index", "build", "--config-file", suite.cfg)
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "metadata-index is not set")
+}
+
+func (suite *indexCmdTestSuite) TestBuild() {
+	suite.createFile("a.txt")
+	suite.createFile("dir/b.txt")
+
+	out, err := executeCommandC(rootCmd, "index", "build", "--config-file", suite.cfg, "--parallelism", "2")
+	suite.assert.Nil(err)
+	suite.assert.Contains(out, "Saved 3 entries of 2 directories")
+
+	attr, _, known := suite.load().GetAttr("dir/b.txt")
+	suite.assert.True(known)
+	suite.assert.EqualValues(4, attr.Size)
+}
+
+func (suite *indexCmdTestSuite) TestBuildFailed() {
+	// Container root can not be listed
+	suite.assert.NoError(os.WriteFile(suite.container, nil, 0666))
+	_, err := executeCommandC(rootCmd, "index", "build", "--config-file", suite.cfg)
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "failed to build index")
+
+	_, err = os.Stat(suite.index)
+	suite.assert.True(os.IsNotExist(err))
+}
+
+func (suite *indexCmdTestSuite) TestRefresh() {
+	suite.createFile("dir/a.txt")
+	suite.createFile("other/b.txt")
+
+	_, err := executeCommandC(rootCmd, "index", "build", "--config-file", suite.cfg)
+	suite.assert.Nil(err)
+
+	suite.createFile("dir/c.txt")
+	suite.createFile("other/d.txt")
+
+	// Only the given directories are listed again
+	_, err = executeCommandC(rootCmd, "index", "refresh", "--config-file", suite.cfg, "/dir/")
+	suite.assert.Nil(err)
+
+	idx := suite.load()
+	attr, _, known := idx.GetAttr("dir/c.txt")
+	suite.assert.True(known)
+	suite.assert.NotNil(attr)
+	attr, _, known = idx.GetAttr("other/d.txt")
+	suite.assert.True(known)
+	suite.assert.Nil(attr)
+
+	_, err = executeCommandC(rootCmd, "index", "refresh", "--config-file", suite.cfg)
+	suite.assert.Nil(err)
+
+	attr, _, _ = suite.load().GetAttr("other/d.txt")
+	suite.assert.NotNil(attr)
+}
@@ -310,7 +310,11 @@ var mountCmd = &cobra.Command{
 				pipeline = append(pipeline, "attr_cache")
 			}
 
-			pipeline = append(pipeline, "azstorage")
+			if config.IsSet("s3storage.bucket-name") {
+				pipeline = append(pipeline, "s3storage")
+			} else {
+				pipeline = append(pipeline, "azstorage")
+			}
 			options.Components = pipeline
 		}
 
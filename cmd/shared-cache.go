@@ -0,0 +1,137 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal/shared_cache"
+
+	"github.com/spf13/cobra"
+)
+
+type sharedCacheOptions struct {
+	socket  string
+	memSize uint64
+}
+
+var sharedCacheOpts sharedCacheOptions
+
+var sharedCacheCmd = &cobra.Command{
+	Use:               "shared-cache",
+	Short:             "Run a block cache shared by all blobfuse2 mounts on this host",
+	Long:              "Run a block cache shared by all blobfuse2 mounts on this host. Mounts attach to it by setting block_cache.shared-cache to its socket,\nblocks of a blob read through any of them are cached once and memory used by all of them together stays within mem-size-mb.",
+	SuggestFor:        []string{"shared", "share"},
+	Example:           "blobfuse2 shared-cache start --socket=/run/blobfuse2/cache.sock --mem-size-mb=8192",
+	FlagErrorHandling: cobra.ExitOnError,
+}
+
+var sharedCacheStartCmd = &cobra.Command{
+	Use:               "start",
+	Short:             "Start the shared block cache in foreground",
+	Long:              "Start the shared block cache in foreground, it runs till it is interrupted or terminated",
+	SuggestFor:        []string{"strt", "run"},
+	Example:           "blobfuse2 shared-cache start --socket=/run/blobfuse2/cache.sock --mem-size-mb=8192",
+	Args:              cobra.ExactArgs(0),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if sharedCacheOpts.memSize == 0 {
+			return fmt.Errorf("mem-size-mb has to be more than 0")
+		}
+
+		err := log.SetDefaultLogger("syslog", common.LogConfig{
+			Level: common.ELogLevel.LOG_INFO(),
+			Tag:   "blobfuse2",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize logger [%s]", err.Error())
+		}
+
+		server := shared_cache.NewServer(common.ExpandPath(sharedCacheOpts.socket), sharedCacheOpts.memSize*1024*1024)
+		err = server.Start()
+		if err != nil {
+			return fmt.Errorf("failed to start shared cache [%s]", err.Error())
+		}
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+
+		server.Stop()
+		return nil
+	},
+}
+
+var sharedCacheStatusCmd = &cobra.Command{
+	Use:               "status",
+	Short:             "Show usage of the shared block cache",
+	Long:              "Show usage of the shared block cache",
+	SuggestFor:        []string{"stat", "stats"},
+	Example:           "blobfuse2 shared-cache status --socket=/run/blobfuse2/cache.sock",
+	Args:              cobra.ExactArgs(0),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := shared_cache.NewClient(common.ExpandPath(sharedCacheOpts.socket), 1)
+		defer client.Close()
+
+		stats, err := client.Stats()
+		if err != nil {
+			return fmt.Errorf("failed to get status of shared cache [%s]", err.Error())
+		}
+
+		fmt.Println("Capacity  :", stats.Capacity)
+		fmt.Println("Used      :", stats.Used)
+		fmt.Println("Blocks    :", stats.Blocks)
+		fmt.Println("Hits      :", stats.Hits)
+		fmt.Println("Misses    :", stats.Misses)
+		fmt.Println("Puts      :", stats.Puts)
+		fmt.Println("Evictions :", stats.Evictions)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sharedCacheCmd)
+	sharedCacheCmd.AddCommand(sharedCacheStartCmd)
+	sharedCacheCmd.AddCommand(sharedCacheStatusCmd)
+
+	sharedCacheCmd.PersistentFlags().StringVar(&sharedCacheOpts.socket, "socket", filepath.Join(common.DefaultWorkDir, "shared-cache.sock"), "Unix socket of the shared cache")
+	sharedCacheStartCmd.Flags().Uint64Var(&sharedCacheOpts.memSize, "mem-size-mb", 4096, "Memory to be used by the shared cache across all mounts in MB")
+}
@@ -0,0 +1,129 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+
+	"github.com/spf13/cobra"
+)
+
+// Section defining all the command that we have in tier feature
+var tierCmd = &cobra.Command{
+	Use:               "tier",
+	Short:             "Get / Set access tier of files in a mounted container",
+	Long:              "Get / Set access tier of files in a mounted container. Moving an archived file to an online tier starts its rehydration.",
+	SuggestFor:        []string{"tir", "tire"},
+	Example:           "blobfuse2 tier get /mnt/blobfuse/file.txt",
+	FlagErrorHandling: cobra.ExitOnError,
+}
+
+var tierGetCmd = &cobra.Command{
+	Use:               "get <path>",
+	Short:             "Get access tier of a file in a mounted container",
+	Long:              "Get access tier of a file in a mounted container, along with status of its rehydration if it is archived",
+	SuggestFor:        []string{"g", "get"},
+	Example:           "blobfuse2 tier get /mnt/blobfuse/file.txt",
+	Args:              cobra.ExactArgs(1),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tier, err := getXattrValue(args[0], common.TierXattr)
+		if err != nil {
+			return fmt.Errorf("failed to get tier of %s [%s]", args[0], err.Error())
+		}
+
+		if tier == "" {
+			return errors.New("tier not available, path is not a file in a blobfuse2 mount")
+		}
+
+		status, err := getXattrValue(args[0], common.ArchiveStatusXattr)
+		if err != nil {
+			return fmt.Errorf("failed to get archive status of %s [%s]", args[0], err.Error())
+		}
+
+		if status != "" {
+			fmt.Println(args[0], "=", tier, "("+status+")")
+		} else {
+			fmt.Println(args[0], "=", tier)
+		}
+		return nil
+	},
+}
+
+var tierSetCmd = &cobra.Command{
+	Use:               "set <path> <tier>",
+	Short:             "Change access tier of a file in a mounted container",
+	Long:              "Change access tier of a file in a mounted container. Valid tiers are hot, cool, cold and archive.\nRehydration of an archived file may take several hours, the file can be read once it is complete.",
+	SuggestFor:        []string{"s", "set"},
+	Example:           "blobfuse2 tier set /mnt/blobfuse/file.txt cool",
+	Args:              cobra.ExactArgs(2),
+	FlagErrorHandling: cobra.ExitOnError,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := syscall.Setxattr(args[0], common.TierXattr, []byte(args[1]), 0)
+		if err == syscall.EINVAL {
+			return fmt.Errorf("invalid tier %s", args[1])
+		} else if err == syscall.EAGAIN {
+			return fmt.Errorf("%s is being rehydrated, retry once it is complete", args[0])
+		} else if err != nil {
+			return fmt.Errorf("failed to set tier of %s [%s]", args[0], err.Error())
+		}
+
+		return nil
+	},
+}
+
+//--------------- command section ends
+
+// getXattrValue : Read an extended attribute of the path, empty if the path does not have it
+func getXattrValue(path string, name string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, name, buf)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return string(buf[:n]), nil
+}
+
+func init() {
+	rootCmd.AddCommand(tierCmd)
+	tierCmd.AddCommand(tierGetCmd)
+	tierCmd.AddCommand(tierSetCmd)
+}
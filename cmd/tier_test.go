@@ -0,0 +1,101 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type tierTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func (suite *tierTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+}
+
+func TestTierCommand(t *testing.T) {
+	suite.Run(t, new(tierTestSuite))
+}
+
+func (suite *tierTestSuite) TestHelp() {
+	_, err := executeCommandC(rootCmd, "tier", "-h")
+	suite.assert.Nil(err)
+}
+
+func (suite *tierTestSuite) TestGetNoPath() {
+	_, err := executeCommandC(rootCmd, "tier", "get")
+	suite.assert.NotNil(err)
+}
+
+func (suite *tierTestSuite) TestGetNotExistent() {
+	_, err := executeCommandC(rootCmd, "tier", "get", "/tmp/tier_test_does_not_exist")
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "failed to get tier")
+}
+
+func (suite *tierTestSuite) TestGetNotMounted() {
+	f, err := os.CreateTemp("", "tier*")
+	suite.assert.Nil(err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// Files outside a blobfuse2 mount do not have the tier attribute
+	_, err = executeCommandC(rootCmd, "tier", "get", f.Name())
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "tier not available")
+}
+
+func (suite *tierTestSuite) TestSetNoTier() {
+	_, err := executeCommandC(rootCmd, "tier", "set", "/tmp/tier_test_does_not_exist")
+	suite.assert.NotNil(err)
+}
+
+func (suite *tierTestSuite) TestSetNotExistent() {
+	_, err := executeCommandC(rootCmd, "tier", "set", "/tmp/tier_test_does_not_exist", "cool")
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "failed to set tier")
+}
@@ -44,6 +44,7 @@ type LockMapItem struct {
 	exLocked     bool
 	mtx          sync.Mutex
 	downloadTime time.Time
+	etag         string
 }
 
 // Map holding locks for all the files
@@ -117,3 +118,13 @@ func (l *LockMapItem) SetDownloadTime() {
 func (l *LockMapItem) DownloadTime() time.Time {
 	return l.downloadTime
 }
+
+// Set the ETag of the blob the local copy of the file is based on
+func (l *LockMapItem) SetETag(etag string) {
+	l.etag = etag
+}
+
+// Get the ETag of the blob the local copy of the file is based on
+func (l *LockMapItem) ETag() string {
+	return l.etag
+}
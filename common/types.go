@@ -67,11 +67,25 @@ const (
 	GbToBytes  = 1024 * MbToBytes
 	BfuseStats = "blobfuse_stats"
 
+	// Extended attributes serving the access tier of a blob and status of its rehydration
+	TierXattr          = "user.blobfuse2.tier"
+	ArchiveStatusXattr = "user.blobfuse2.archive-status"
+
+	// Extended attribute serving the class of a path in the local cache (pin, normal, evict-first, no-cache)
+	CacheClassXattr = "user.blobfuse2.cache-class"
+
+	// Extended attribute serving how much of a file is in the local cache, one of the cache status values below
+	CacheStatusXattr   = "user.blobfuse2.cache-status"
+	CacheStatusCached  = "cached"
+	CacheStatusPartial = "partial"
+	CacheStatusNone    = "none"
+
 	FuseAllowedFlags = "invalid FUSE options. Allowed FUSE configurations are: `-o attr_timeout=TIMEOUT`, `-o negative_timeout=TIMEOUT`, `-o entry_timeout=TIMEOUT` `-o allow_other`, `-o allow_root`, `-o umask=PERMISSIONS -o default_permissions`, `-o ro`"
 
 	UserAgentHeader = "User-Agent"
 
-	BlockCacheRWErrMsg = "Notice: The random write flow using block cache is temporarily blocked due to potential data integrity issues. This is a precautionary measure. \nIf you see this message, contact blobfusedev@microsoft.com or create a GitHub issue. We're working on a fix. More details: https://aka.ms/blobfuse2warnings."
+	// Largest range of a read for which storage returns MD5 of the data, longer reads get one MD5 for each such range
+	MaxChecksumRange = 4 * MbToBytes
 )
 
 func FuseIgnoredFlags() []string {
@@ -197,6 +211,7 @@ type BlockOffsetList struct {
 	BlockIdLength int64
 	Size          int64
 	Mtime         time.Time
+	ETag          string // ETag of the blob when the block list was retrieved
 }
 
 // Dirty : Handle is dirty or not
@@ -38,6 +38,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/md5"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -500,3 +501,13 @@ func WriteToFile(filename string, data string, options WriteToFileOptions) error
 
 	return nil
 }
+
+// GetRangeChecksums : MD5 of every MaxChecksumRange bytes of the data, as storage reports them on a read
+func GetRangeChecksums(data []byte) [][]byte {
+	sums := make([][]byte, 0, (len(data)+MaxChecksumRange-1)/MaxChecksumRange)
+	for start := 0; start < len(data); start += MaxChecksumRange {
+		sum := md5.Sum(data[start:min(start+MaxChecksumRange, len(data))])
+		sums = append(sums, sum[:])
+	}
+	return sums
+}
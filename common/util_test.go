@@ -35,6 +35,7 @@ package common
 
 import (
 	"bytes"
+	"crypto/md5"
 	"crypto/rand"
 	"fmt"
 	"os"
@@ -202,6 +203,20 @@ func (suite *utilTestSuite) TestMonitorBfs() {
 	suite.assert.False(monitor)
 }
 
+func (suite *utilTestSuite) TestGetRangeChecksums() {
+	suite.assert.Empty(GetRangeChecksums(nil))
+
+	data := make([]byte, 2*MaxChecksumRange+1)
+	sums := GetRangeChecksums(data)
+	suite.assert.Len(sums, 3)
+
+	first := md5.Sum(data[:MaxChecksumRange])
+	last := md5.Sum(data[:1])
+	suite.assert.Equal(first[:], sums[0])
+	suite.assert.Equal(first[:], sums[1])
+	suite.assert.Equal(last[:], sums[2])
+}
+
 func (suite *utilTestSuite) TestExpandPath() {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
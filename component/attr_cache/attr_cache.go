@@ -34,18 +34,24 @@
 package attr_cache
 
 import (
+	"container/list"
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
+	"github.com/Azure/azure-storage-fuse/v2/internal/metadata_index"
+	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 )
 
 // By default attr cache is valid for 120 seconds
@@ -57,8 +63,15 @@ type AttrCache struct {
 	cacheTimeout uint32
 	noSymlinks   bool
 	maxFiles     int
+	maxMemory    int64 // Approximate memory the cached attributes can take, 0 for no limit
 	cacheMap     map[string]*attrCacheItem
 	cacheLock    sync.RWMutex
+
+	lruLock sync.Mutex
+	lru     *list.List // Items in the cache, most recently used in front
+	memUsed int64      // Approximate memory taken by the items in the cache
+
+	index *metadata_index.Index // Snapshot of the container answering for paths not in the cache
 }
 
 // Structure defining your config parameters
@@ -70,16 +83,21 @@ type AttrCacheOptions struct {
 	//maximum file attributes overall to be cached
 	MaxFiles int `config:"max-files" yaml:"max-files,omitempty"`
 
+	// maximum memory the cached attributes can take, least recently used ones are evicted beyond it
+	MaxMemoryMB float64 `config:"max-memory-mb" yaml:"max-memory-mb,omitempty"`
+
 	// support v1
 	CacheOnList bool `config:"cache-on-list"`
 }
 
 const compName = "attr_cache"
 
-// caching only first 5 mil files by default
+// caching only 5 mil files by default, least recently used ones are evicted beyond it
 // caching more means increased memory usage of the process
 const defaultMaxFiles = 5000000 // 5 million max files overall to be cached
 
+var attrCacheStatsCollector *stats_manager.StatsCollector
+
 // Verification to check satisfaction criteria with Component Interface
 var _ internal.Component = &AttrCache{}
 
@@ -107,6 +125,22 @@ func (ac *AttrCache) Start(ctx context.Context) error {
 
 	// AttrCache : start code goes here
 	ac.cacheMap = make(map[string]*attrCacheItem)
+	ac.lru = list.New()
+	ac.memUsed = 0
+
+	attrCacheStatsCollector = stats_manager.NewStatsCollector(ac.Name())
+
+	err := invalidation.Subscribe(ac.Name(), ac.onChange)
+	if err != nil {
+		log.Err("AttrCache::Start : Failed to subscribe to change events [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", ac.Name(), err.Error())
+	}
+
+	ac.index, err = metadata_index.Acquire(ac.Name(), ac.NextComponent())
+	if err != nil {
+		log.Err("AttrCache::Start : Failed to open metadata index [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", ac.Name(), err.Error())
+	}
 
 	return nil
 }
@@ -115,6 +149,11 @@ func (ac *AttrCache) Start(ctx context.Context) error {
 func (ac *AttrCache) Stop() error {
 	log.Trace("AttrCache::Stop : Stopping component %s", ac.Name())
 
+	invalidation.Unsubscribe(ac.Name())
+	metadata_index.Release(ac.Name())
+	ac.index = nil
+	attrCacheStatsCollector.Destroy()
+
 	return nil
 }
 
@@ -159,8 +198,14 @@ func (ac *AttrCache) Configure(_ bool) error {
 		ac.noSymlinks = conf.NoSymlinks
 	}
 
-	log.Crit("AttrCache::Configure : cache-timeout %d, symlink %t, max-files %d",
-		ac.cacheTimeout, ac.noSymlinks, ac.maxFiles)
+	if conf.MaxMemoryMB < 0 {
+		log.Err("AttrCache::Configure : config error [invalid max-memory-mb]")
+		return fmt.Errorf("config error in %s [invalid max-memory-mb %v]", ac.Name(), conf.MaxMemoryMB)
+	}
+	ac.maxMemory = int64(conf.MaxMemoryMB * MB)
+
+	log.Crit("AttrCache::Configure : cache-timeout %d, symlink %t, max-files %d, max-memory-mb %v",
+		ac.cacheTimeout, ac.noSymlinks, ac.maxFiles, conf.MaxMemoryMB)
 
 	return nil
 }
@@ -189,6 +234,7 @@ func (ac *AttrCache) deleteDirectory(path string, time time.Time) {
 			value.markDeleted(time)
 		}
 	}
+	ac.index.ForgetDir(path)
 
 	// We need to delete the path itself since we only handle children above.
 	ac.deletePath(path, time)
@@ -201,6 +247,7 @@ func (ac *AttrCache) deletePath(path string, time time.Time) {
 	if found {
 		value.markDeleted(time)
 	}
+	ac.index.Forget(path)
 }
 
 // invalidateDirectory: recursively marks a directory invalid
@@ -218,6 +265,7 @@ func (ac *AttrCache) invalidateDirectory(path string) {
 			value.invalidate()
 		}
 	}
+	ac.index.ForgetDir(path)
 
 	// We need to invalidate the path itself since we only handle children above.
 	ac.invalidatePath(path)
@@ -230,6 +278,98 @@ func (ac *AttrCache) invalidatePath(path string) {
 	if found {
 		value.invalidate()
 	}
+	ac.index.Forget(path)
+}
+
+// onChange: invalidates a path changed in the container by someone else
+func (ac *AttrCache) onChange(event invalidation.Event) {
+	ac.cacheLock.RLock()
+	defer ac.cacheLock.RUnlock()
+
+	if event.Dir {
+		ac.invalidateDirectory(event.Path)
+	} else {
+		ac.invalidatePath(event.Path)
+	}
+
+	// Directories above the path may exist now even if they were cached as deleted
+	for dir := path.Dir(event.Path); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		value, found := ac.cacheMap[dir]
+		if found && value.isDeleted() {
+			value.invalidate()
+		}
+	}
+}
+
+// cachePath: adds or replaces the item of a path, evicting the least recently used items if the cache is over its limits.
+// Caller shall hold cacheLock for write.
+func (ac *AttrCache) cachePath(path string, item *attrCacheItem) {
+	item.name = path
+	item.size = itemSize(path, item.attr)
+
+	ac.lruLock.Lock()
+	defer ac.lruLock.Unlock()
+
+	if old, found := ac.cacheMap[path]; found {
+		ac.unlink(old)
+	}
+
+	item.elem = ac.lru.PushFront(item)
+	ac.cacheMap[path] = item
+	ac.memUsed += item.size
+
+	evicted := 0
+	for ac.lru.Len() > 1 && (ac.lru.Len() > ac.maxFiles || (ac.maxMemory > 0 && ac.memUsed > ac.maxMemory)) {
+		victim := ac.lru.Back().Value.(*attrCacheItem)
+		ac.unlink(victim)
+		if ac.cacheMap[victim.name] == victim {
+			delete(ac.cacheMap, victim.name)
+		}
+		evicted++
+	}
+
+	if evicted > 0 {
+		log.Debug("AttrCache::cachePath : Evicted %d items, %d items of %d bytes in cache", evicted, ac.lru.Len(), ac.memUsed)
+		attrCacheStatsCollector.UpdateStats(stats_manager.Increment, evictedItems, int64(evicted))
+	}
+}
+
+// unlink: removes the item from the lru list, caller shall hold lruLock
+func (ac *AttrCache) unlink(item *attrCacheItem) {
+	if item.elem != nil {
+		ac.lru.Remove(item.elem)
+		item.elem = nil
+		ac.memUsed -= item.size
+	}
+}
+
+// touch: marks the item as most recently used
+func (ac *AttrCache) touch(item *attrCacheItem) {
+	ac.lruLock.Lock()
+	defer ac.lruLock.Unlock()
+
+	if item.elem != nil {
+		ac.lru.MoveToFront(item.elem)
+	}
+}
+
+// usage: number of items in the cache and the approximate memory taken by them
+func (ac *AttrCache) usage() (int, int64) {
+	ac.lruLock.Lock()
+	defer ac.lruLock.Unlock()
+
+	return ac.lru.Len(), ac.memUsed
+}
+
+// updateUsageStats: reports the number of items in the cache and the memory taken by them
+func (ac *AttrCache) updateUsageStats() {
+	if !common.MonitorBfs() {
+		return
+	}
+
+	items, mem := ac.usage()
+	attrCacheStatsCollector.UpdateStats(stats_manager.Replace, cachedItems, int64(items))
+	attrCacheStatsCollector.UpdateStats(stats_manager.Replace, cacheMemory, fmt.Sprintf("%f MB", float64(mem)/MB))
 }
 
 // ------------------------- Methods implemented by this component -------------------------------------------
@@ -295,16 +435,21 @@ func (ac *AttrCache) cacheAttributes(pathList []*internal.ObjAttr) {
 		currTime := time.Now()
 
 		for _, attr := range pathList {
-			if len(ac.cacheMap) > ac.maxFiles {
-				log.Debug("AttrCache::cacheAttributes : %s skipping adding path to attribute cache because it is full", pathList)
-				break
-			}
+			path := internal.TruncateDirName(attr.Path)
 
 			ac.cacheLock.Lock()
-			ac.cacheMap[internal.TruncateDirName(attr.Path)] = newAttrCacheItem(attr, true, currTime)
+			value, found := ac.cacheMap[path]
+			changed := found && value.valid() && value.changed(attr, nil)
+			ac.cachePath(path, newAttrCacheItem(attr, true, currTime))
 			ac.cacheLock.Unlock()
+
+			if changed {
+				// Kernel may have cached the old attributes as well
+				invalidation.Notify(invalidation.Event{Path: path, Dir: attr.IsDir()})
+			}
 		}
 
+		ac.updateUsageStats()
 	}
 }
 
@@ -412,6 +557,7 @@ func (ac *AttrCache) TruncateFile(options internal.TruncateFileOptions) error {
 		if found && value.valid() && value.exists() {
 			value.setSize(options.Size)
 		}
+		ac.index.Forget(options.Name)
 	}
 	return err
 }
@@ -443,6 +589,30 @@ func (ac *AttrCache) CopyFromFile(options internal.CopyFromFileOptions) error {
 	return err
 }
 
+// CopyToFile : Mark the file invalid if it changed since its ETag was read
+func (ac *AttrCache) CopyToFile(options internal.CopyToFileOptions) error {
+	log.Trace("AttrCache::CopyToFile : %s", options.Name)
+
+	err := ac.NextComponent().CopyToFile(options)
+	if err == syscall.ESTALE {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+		ac.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// ReadInBuffer : Mark the file invalid if it changed since its ETag was read
+func (ac *AttrCache) ReadInBuffer(options internal.ReadInBufferOptions) (int, error) {
+	n, err := ac.NextComponent().ReadInBuffer(options)
+	if err == syscall.ESTALE && options.Handle != nil {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+		ac.invalidatePath(options.Handle.Path)
+	}
+	return n, err
+}
+
 func (ac *AttrCache) SyncFile(options internal.SyncFileOptions) error {
 	log.Trace("AttrCache::SyncFile : %s", options.Handle.Path)
 
@@ -478,6 +648,9 @@ func (ac *AttrCache) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr
 
 	// Try to serve the request from the attribute cache
 	if found && value.valid() && time.Since(value.cachedAt).Seconds() < float64(ac.cacheTimeout) {
+		ac.touch(value)
+		attrCacheStatsCollector.UpdateStats(stats_manager.Increment, cacheHits, int64(1))
+
 		if value.isDeleted() {
 			log.Debug("AttrCache::GetAttr : %s served from cache", options.Name)
 			// no entry if path does not exist
@@ -488,24 +661,55 @@ func (ac *AttrCache) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr
 		}
 	}
 
+	// Index answers like the cache, as of the time the directory was listed. An answer newer than the cached one is
+	// cached even if it is too old to be served, so that a change since it was listed is still noticed below.
+	if !options.RetrieveMetadata {
+		attr, listedAt, known := ac.index.GetAttr(truncatedPath)
+		if known && (!found || !value.valid() || value.cachedAt.Before(listedAt)) {
+			ac.cacheLock.Lock()
+			if attr == nil {
+				ac.cachePath(truncatedPath, newAttrCacheItem(&internal.ObjAttr{}, false, listedAt))
+			} else {
+				ac.cachePath(truncatedPath, newAttrCacheItem(attr, true, listedAt))
+			}
+			ac.cacheLock.Unlock()
+
+			// Metadata is not kept in the index
+			if time.Since(listedAt).Seconds() < float64(ac.cacheTimeout) {
+				attrCacheStatsCollector.UpdateStats(stats_manager.Increment, indexHits, int64(1))
+				log.Debug("AttrCache::GetAttr : %s served from index", options.Name)
+				if attr == nil {
+					return &internal.ObjAttr{}, syscall.ENOENT
+				}
+				return attr, nil
+			}
+		}
+	}
+
 	// Get the attributes from next component and cache them
+	attrCacheStatsCollector.UpdateStats(stats_manager.Increment, cacheMisses, int64(1))
 	pathAttr, err := ac.NextComponent().GetAttr(options)
 
 	ac.cacheLock.Lock()
-	defer ac.cacheLock.Unlock()
-
+	// Expired item tells whether the path changed in the container since it was cached, the kernel may have it cached too
+	value, found = ac.cacheMap[truncatedPath]
+	changed := found && value.valid() && value.changed(pathAttr, err)
+	dir := changed && (value.getAttr().IsDir() || (err == nil && pathAttr.IsDir()))
 	if err == nil {
 		// Retrieved attributes so cache them
-		if len(ac.cacheMap) < ac.maxFiles {
-			ac.cacheMap[truncatedPath] = newAttrCacheItem(pathAttr, true, time.Now())
-		} else {
-			log.Debug("AttrCache::GetAttr : %s skipping adding to attribute cache because it is full", options.Name)
-		}
+		ac.cachePath(truncatedPath, newAttrCacheItem(pathAttr, true, time.Now()))
 	} else if err == syscall.ENOENT {
 		// Path does not exist so cache a no-entry item
-		ac.cacheMap[truncatedPath] = newAttrCacheItem(&internal.ObjAttr{}, false, time.Now())
+		ac.cachePath(truncatedPath, newAttrCacheItem(&internal.ObjAttr{}, false, time.Now()))
+	}
+	ac.cacheLock.Unlock()
+
+	if changed {
+		log.Debug("AttrCache::GetAttr : %s changed in container", options.Name)
+		invalidation.Notify(invalidation.Event{Path: truncatedPath, Dir: dir})
 	}
 
+	ac.updateUsageStats()
 	return pathAttr, err
 }
 
@@ -550,19 +754,80 @@ func (ac *AttrCache) Chmod(options internal.ChmodOptions) error {
 
 		value, found := ac.cacheMap[internal.TruncateDirName(options.Name)]
 		if found && value.valid() && value.exists() {
-			value.setMode(options.Mode)
+			if value.attr.IsModeDefault() {
+				// Storage did not report a mode earlier, fetch it again to know whether the new mode is persisted
+				value.invalidate()
+			} else {
+				value.setMode(options.Mode)
+			}
 		}
+		ac.index.Forget(options.Name)
 	}
 
 	return err
 }
 
-// Chown : Update the file with its new owner and group (when datalake chown is implemented)
+// Chown : Mark the path invalid as the owner reported by storage may have changed
 func (ac *AttrCache) Chown(options internal.ChownOptions) error {
 	log.Trace("AttrCache::Chown : Change owner of file/directory %s", options.Name)
 
 	err := ac.NextComponent().Chown(options)
-	// TODO: Implement when datalake chown is supported.
+
+	if err == nil {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+		ac.invalidatePath(options.Name)
+	}
+
+	return err
+}
+
+// SetAttr : Update the file with its new access and modification times
+func (ac *AttrCache) SetAttr(options internal.SetAttrOptions) error {
+	log.Trace("AttrCache::SetAttr : Change times of file/directory %s", options.Name)
+
+	err := ac.NextComponent().SetAttr(options)
+
+	if err == nil {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+
+		value, found := ac.cacheMap[internal.TruncateDirName(options.Name)]
+		if found && value.valid() && value.exists() {
+			value.setTimes(options.Attr.Atime, options.Attr.Mtime)
+		}
+		ac.index.Forget(options.Name)
+	}
+
+	return err
+}
+
+// SetXattr : Mark the path invalid as its metadata has changed
+func (ac *AttrCache) SetXattr(options internal.SetXattrOptions) error {
+	log.Trace("AttrCache::SetXattr : Set %s of file/directory %s", options.Attr, options.Name)
+
+	err := ac.NextComponent().SetXattr(options)
+	if err == nil {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+
+		ac.invalidatePath(options.Name)
+	}
+
+	return err
+}
+
+// RemoveXattr : Mark the path invalid as its metadata has changed
+func (ac *AttrCache) RemoveXattr(options internal.RemoveXattrOptions) error {
+	log.Trace("AttrCache::RemoveXattr : Remove %s of file/directory %s", options.Attr, options.Name)
+
+	err := ac.NextComponent().RemoveXattr(options)
+	if err == nil {
+		ac.cacheLock.RLock()
+		defer ac.cacheLock.RUnlock()
+
+		ac.invalidatePath(options.Name)
+	}
 
 	return err
 }
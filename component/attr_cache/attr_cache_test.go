@@ -49,8 +49,11 @@ import (
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/loopback"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
+	"github.com/Azure/azure-storage-fuse/v2/internal/metadata_index"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -244,6 +247,104 @@ func (suite *attrCacheTestSuite) TestConfigZero() {
 	suite.assert.Equal(suite.attrCache.noSymlinks, true)
 }
 
+func (suite *attrCacheTestSuite) TestConfigMaxMemory() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	suite.setupTestHelper("attr_cache:\n  max-memory-mb: 1.5")
+	suite.assert.EqualValues(1.5*MB, suite.attrCache.maxMemory)
+
+	attrCache := NewAttrCacheComponent()
+	_ = config.ReadConfigFromReader(strings.NewReader("attr_cache:\n  max-memory-mb: -1"))
+	err := attrCache.Configure(true)
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "max-memory-mb")
+}
+
+// Tests that least recently used items are evicted once max-files is reached
+func (suite *attrCacheTestSuite) TestEvictMaxFiles() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	suite.setupTestHelper("attr_cache:\n  max-files: 3")
+
+	getAttr := func(path string) {
+		_, err := suite.attrCache.GetAttr(internal.GetAttrOptions{Name: path})
+		suite.assert.Nil(err)
+	}
+
+	for _, path := range []string{"a", "b", "c"} {
+		suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: path}).Return(getPathAttr(path, defaultSize, fs.FileMode(defaultMode), false), nil)
+		getAttr(path)
+	}
+
+	// Hit makes a the most recently used, so b is evicted
+	getAttr("a")
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: "d"}).Return(getPathAttr("d", defaultSize, fs.FileMode(defaultMode), false), nil)
+	getAttr("d")
+
+	suite.assert.Len(suite.attrCache.cacheMap, 3)
+	suite.assert.NotContains(suite.attrCache.cacheMap, "b")
+	assertUntouched(suite, "a")
+	assertUntouched(suite, "c")
+	assertUntouched(suite, "d")
+
+	// Paths not found count as well
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: "e"}).Return(&internal.ObjAttr{}, syscall.ENOENT)
+	_, err := suite.attrCache.GetAttr(internal.GetAttrOptions{Name: "e"})
+	suite.assert.Equal(syscall.ENOENT, err)
+	suite.assert.Len(suite.attrCache.cacheMap, 3)
+	suite.assert.NotContains(suite.attrCache.cacheMap, "c")
+
+	// Listing larger than the cache keeps the last entries
+	suite.mock.EXPECT().ReadDir(internal.ReadDirOptions{Name: "dir"}).Return(generateNestedPathAttr("dir", defaultSize, fs.FileMode(defaultMode)), nil)
+	_, err = suite.attrCache.ReadDir(internal.ReadDirOptions{Name: "dir"})
+	suite.assert.Nil(err)
+	suite.assert.Len(suite.attrCache.cacheMap, 3)
+	suite.assert.Contains(suite.attrCache.cacheMap, "dir/c1/gc1")
+
+	items, _ := suite.attrCache.usage()
+	suite.assert.Equal(3, items)
+}
+
+// Tests that least recently used items are evicted to keep the memory within max-memory-mb
+func (suite *attrCacheTestSuite) TestEvictMaxMemory() {
+	defer suite.cleanupTest()
+	suite.cleanupTest() // clean up the default attr cache generated
+	suite.setupTestHelper("attr_cache:\n  max-memory-mb: 0.01")
+
+	// Items with large metadata take more of the memory
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("file%d", i)
+		attr := getPathAttr(path, defaultSize, fs.FileMode(defaultMode), true)
+		attr.Metadata = map[string]*string{"key": &value}
+
+		suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: path}).Return(attr, nil)
+		_, err := suite.attrCache.GetAttr(internal.GetAttrOptions{Name: path})
+		suite.assert.Nil(err)
+	}
+
+	items, mem := suite.attrCache.usage()
+	suite.assert.LessOrEqual(mem, suite.attrCache.maxMemory)
+	suite.assert.Less(items, 10)
+	suite.assert.Len(suite.attrCache.cacheMap, items)
+	suite.assert.NotContains(suite.attrCache.cacheMap, "file0")
+	suite.assert.Contains(suite.attrCache.cacheMap, "file19")
+
+	// Accounted memory is the sum of the items in cache, replacing an item does not count it twice
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: "file19"}).Return(getPathAttr("file19", defaultSize, fs.FileMode(defaultMode), false), nil)
+	suite.attrCache.cacheMap["file19"].invalidate()
+	_, err := suite.attrCache.GetAttr(internal.GetAttrOptions{Name: "file19"})
+	suite.assert.Nil(err)
+
+	total := int64(0)
+	for _, item := range suite.attrCache.cacheMap {
+		total += item.size
+	}
+	_, mem = suite.attrCache.usage()
+	suite.assert.Equal(total, mem)
+	suite.assert.Greater(itemSize("file19", &internal.ObjAttr{Metadata: map[string]*string{"key": &value}}), itemSize("file19", nil)+1024)
+}
+
 // Tests Create Directory
 func (suite *attrCacheTestSuite) TestCreateDir() {
 	defer suite.cleanupTest()
@@ -1104,10 +1205,24 @@ func (suite *attrCacheTestSuite) TestChmod() {
 	}
 }
 
+// Tests Chmod when storage does not report the mode of the path
+func (suite *attrCacheTestSuite) TestChmodModeDefault() {
+	defer suite.cleanupTest()
+	path := "a"
+	options := internal.ChmodOptions{Name: path, Mode: fs.FileMode(0755)}
+
+	addPathToCache(suite.assert, suite.attrCache, path, false)
+	suite.attrCache.cacheMap[path].attr.Flags.Set(internal.PropFlagModeDefault)
+	suite.mock.EXPECT().Chmod(options).Return(nil)
+
+	err := suite.attrCache.Chmod(options)
+	suite.assert.Nil(err)
+	assertInvalid(suite, path)
+}
+
 // Tests Chown
 func (suite *attrCacheTestSuite) TestChown() {
 	defer suite.cleanupTest()
-	// TODO: Implement when datalake chown is supported.
 	owner := 0
 	group := 0
 	var paths = []string{"a", "a/"}
@@ -1141,11 +1256,320 @@ func (suite *attrCacheTestSuite) TestChown() {
 
 			err = suite.attrCache.Chown(options)
 			suite.assert.Nil(err)
-			assertUntouched(suite, truncatedPath)
+			assertInvalid(suite, truncatedPath)
+		})
+	}
+}
+
+// Tests SetAttr
+func (suite *attrCacheTestSuite) TestSetAttr() {
+	defer suite.cleanupTest()
+	atime := time.Now().Add(-2 * time.Hour)
+	mtime := time.Now().Add(-1 * time.Hour)
+	var paths = []string{"a", "a/"}
+
+	for _, path := range paths {
+		// This is a little janky but required since testify suite does not support running setup or clean up for subtests.
+		suite.cleanupTest()
+		suite.SetupTest()
+		suite.Run(path, func() {
+			truncatedPath := internal.TruncateDirName(path)
+			options := internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Atime: atime, Mtime: mtime}}
+
+			// Error
+			suite.mock.EXPECT().SetAttr(options).Return(errors.New("Failed to set times"))
+
+			err := suite.attrCache.SetAttr(options)
+			suite.assert.NotNil(err)
+			suite.assert.NotContains(suite.attrCache.cacheMap, truncatedPath)
+
+			// Success
+			// Entry Does Not Already Exist
+			suite.mock.EXPECT().SetAttr(options).Return(nil)
+
+			err = suite.attrCache.SetAttr(options)
+			suite.assert.Nil(err)
+			suite.assert.NotContains(suite.attrCache.cacheMap, truncatedPath)
+
+			// Entry Already Exists
+			addPathToCache(suite.assert, suite.attrCache, path, false)
+			suite.mock.EXPECT().SetAttr(options).Return(nil)
+
+			err = suite.attrCache.SetAttr(options)
+			suite.assert.Nil(err)
+			suite.assert.Contains(suite.attrCache.cacheMap, truncatedPath)
+			suite.assert.EqualValues(suite.attrCache.cacheMap[truncatedPath].attr.Size, defaultSize)
+			suite.assert.True(atime.Equal(suite.attrCache.cacheMap[truncatedPath].attr.Atime))
+			suite.assert.True(mtime.Equal(suite.attrCache.cacheMap[truncatedPath].attr.Mtime))
+			suite.assert.True(suite.attrCache.cacheMap[truncatedPath].valid())
+			suite.assert.True(suite.attrCache.cacheMap[truncatedPath].exists())
+
+			// Only access time changed
+			options = internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Atime: time.Now()}}
+			suite.mock.EXPECT().SetAttr(options).Return(nil)
+
+			err = suite.attrCache.SetAttr(options)
+			suite.assert.Nil(err)
+			suite.assert.True(options.Attr.Atime.Equal(suite.attrCache.cacheMap[truncatedPath].attr.Atime))
+			suite.assert.True(mtime.Equal(suite.attrCache.cacheMap[truncatedPath].attr.Mtime))
 		})
 	}
 }
 
+// Tests SetXattr
+func (suite *attrCacheTestSuite) TestSetXattr() {
+	defer suite.cleanupTest()
+	var paths = []string{"a", "a/"}
+
+	for _, path := range paths {
+		// This is a little janky but required since testify suite does not support running setup or clean up for subtests.
+		suite.cleanupTest()
+		suite.SetupTest()
+		suite.Run(path, func() {
+			truncatedPath := internal.TruncateDirName(path)
+			options := internal.SetXattrOptions{Name: path, Attr: "user.tag", Value: []byte("blue")}
+
+			// Error
+			suite.mock.EXPECT().SetXattr(options).Return(errors.New("Failed to set xattr"))
+
+			err := suite.attrCache.SetXattr(options)
+			suite.assert.NotNil(err)
+			suite.assert.NotContains(suite.attrCache.cacheMap, truncatedPath)
+
+			// Success
+			// Entry Does Not Already Exist
+			suite.mock.EXPECT().SetXattr(options).Return(nil)
+
+			err = suite.attrCache.SetXattr(options)
+			suite.assert.Nil(err)
+			suite.assert.NotContains(suite.attrCache.cacheMap, truncatedPath)
+
+			// Entry Already Exists
+			addPathToCache(suite.assert, suite.attrCache, path, true)
+			suite.mock.EXPECT().SetXattr(options).Return(nil)
+
+			err = suite.attrCache.SetXattr(options)
+			suite.assert.Nil(err)
+			assertInvalid(suite, truncatedPath)
+		})
+	}
+}
+
+// Tests RemoveXattr
+func (suite *attrCacheTestSuite) TestRemoveXattr() {
+	defer suite.cleanupTest()
+	var paths = []string{"a", "a/"}
+
+	for _, path := range paths {
+		// This is a little janky but required since testify suite does not support running setup or clean up for subtests.
+		suite.cleanupTest()
+		suite.SetupTest()
+		suite.Run(path, func() {
+			truncatedPath := internal.TruncateDirName(path)
+			options := internal.RemoveXattrOptions{Name: path, Attr: "user.tag"}
+
+			// Error
+			suite.mock.EXPECT().RemoveXattr(options).Return(syscall.ENODATA)
+
+			err := suite.attrCache.RemoveXattr(options)
+			suite.assert.Equal(syscall.ENODATA, err)
+			suite.assert.NotContains(suite.attrCache.cacheMap, truncatedPath)
+
+			// Entry Already Exists
+			addPathToCache(suite.assert, suite.attrCache, path, true)
+			suite.mock.EXPECT().RemoveXattr(options).Return(nil)
+
+			err = suite.attrCache.RemoveXattr(options)
+			suite.assert.Nil(err)
+			assertInvalid(suite, truncatedPath)
+		})
+	}
+}
+
+// Tests invalidation on change events from the container
+func (suite *attrCacheTestSuite) TestOnChange() {
+	defer suite.cleanupTest()
+
+	a, ab, ac := addDirectoryToCache(suite.assert, suite.attrCache, "a", false)
+
+	// File changed, only the file is invalidated
+	suite.attrCache.onChange(invalidation.Event{Path: "a/c1/gc1"})
+	assertInvalid(suite, "a/c1/gc1")
+	assertUntouched(suite, "a/c1")
+	assertUntouched(suite, "a")
+
+	// Directory changed, everything under it is invalidated
+	suite.attrCache.onChange(invalidation.Event{Path: "a", Dir: true})
+	for p := a.Front(); p != nil; p = p.Next() {
+		assertInvalid(suite, p.Value.(string))
+	}
+	ab.PushBackList(ac)
+	for p := ab.Front(); p != nil; p = p.Next() {
+		assertUntouched(suite, p.Value.(string))
+	}
+}
+
+// Tests that a file created in a directory cached as deleted makes the directory visible again
+func (suite *attrCacheTestSuite) TestOnChangeDeletedParent() {
+	defer suite.cleanupTest()
+
+	addPathToCache(suite.assert, suite.attrCache, "x", false)
+	addPathToCache(suite.assert, suite.attrCache, "x/y", false)
+	suite.attrCache.cacheMap["x"].markDeleted(time.Now())
+	suite.attrCache.cacheMap["x/y"].markDeleted(time.Now())
+	addPathToCache(suite.assert, suite.attrCache, "z", false)
+
+	suite.attrCache.onChange(invalidation.Event{Path: "x/y/file"})
+	assertInvalid(suite, "x")
+	assertInvalid(suite, "x/y")
+	assertUntouched(suite, "z")
+}
+
+// indexStorage : Storage of a container holding a/b and f to build a metadata index from
+func (suite *attrCacheTestSuite) indexStorage() internal.Component {
+	container := suite.T().TempDir()
+	suite.assert.NoError(os.Mkdir(filepath.Join(container, "a"), 0777))
+	suite.assert.NoError(os.WriteFile(filepath.Join(container, "a", "b"), make([]byte, 20), 0666))
+	suite.assert.NoError(os.WriteFile(filepath.Join(container, "f"), make([]byte, 10), 0666))
+
+	_ = config.ReadConfigFromReader(strings.NewReader("loopbackfs:\n  path: " + container + "\n"))
+	storage := loopback.NewLoopbackFSComponent()
+	suite.assert.NoError(storage.Configure(true))
+	return storage
+}
+
+// Tests that paths missing from the cache are served from the metadata index
+func (suite *attrCacheTestSuite) TestGetAttrFromIndex() {
+	defer suite.cleanupTest()
+
+	idx := metadata_index.New("")
+	suite.assert.NoError(idx.Refresh(context.Background(), suite.indexStorage(), "", 1))
+	path := filepath.Join(suite.T().TempDir(), "container.idx")
+	suite.assert.NoError(idx.Save(path))
+
+	suite.cleanupTest()
+	suite.setupTestHelper(fmt.Sprintf("metadata-index:\n  path: %s\n  refresh-sec: 0\n", path))
+
+	// Known paths are answered without going to storage, including the ones that do not exist
+	attr, err := suite.attrCache.GetAttr(internal.GetAttrOptions{Name: "a/b"})
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(20, attr.Size)
+
+	attr, err = suite.attrCache.GetAttr(internal.GetAttrOptions{Name: "a/"})
+	suite.assert.NoError(err)
+	suite.assert.True(attr.IsDir())
+
+	_, err = suite.attrCache.GetAttr(internal.GetAttrOptions{Name: "a/missing"})
+	suite.assert.Equal(syscall.ENOENT, err)
+
+	// Answers of the index are cached as of the time they were listed
+	_, listedAt, _ := suite.attrCache.index.GetAttr("a/missing")
+	suite.assert.Contains(suite.attrCache.cacheMap, "a/missing")
+	suite.assert.False(suite.attrCache.cacheMap["a/missing"].exists())
+	suite.assert.Equal(listedAt, suite.attrCache.cacheMap["a/missing"].cachedAt)
+
+	// Metadata is not in the index
+	options := internal.GetAttrOptions{Name: "f", RetrieveMetadata: true}
+	suite.mock.EXPECT().GetAttr(options).Return(getPathAttr("f", 10, fs.FileMode(defaultMode), true), nil)
+	_, err = suite.attrCache.GetAttr(options)
+	suite.assert.NoError(err)
+
+	// Paths changed through the mount are not served from the index any more, but from the cache updated by the change
+	truncate := internal.TruncateFileOptions{Name: "a/b", Size: 5}
+	suite.mock.EXPECT().TruncateFile(truncate).Return(nil)
+	suite.assert.NoError(suite.attrCache.TruncateFile(truncate))
+
+	_, _, known := suite.attrCache.index.GetAttr("a/b")
+	suite.assert.False(known)
+	attr, err = suite.attrCache.GetAttr(internal.GetAttrOptions{Name: "a/b"})
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(5, attr.Size)
+
+	// Nor are paths next to a directory changed in the container
+	suite.attrCache.onChange(invalidation.Event{Path: "a", Dir: true})
+	options = internal.GetAttrOptions{Name: "g"}
+	suite.mock.EXPECT().GetAttr(options).Return(getPathAttr("g", 10, fs.FileMode(defaultMode), false), nil)
+	_, err = suite.attrCache.GetAttr(options)
+	suite.assert.NoError(err)
+}
+
+// Tests that answers of the metadata index older than the cache timeout go to storage, and changes since are noticed
+func (suite *attrCacheTestSuite) TestGetAttrFromStaleIndex() {
+	defer suite.cleanupTest()
+
+	idx := metadata_index.New("")
+	suite.assert.NoError(idx.Refresh(context.Background(), suite.indexStorage(), "", 1))
+	path := filepath.Join(suite.T().TempDir(), "container.idx")
+	suite.assert.NoError(idx.Save(path))
+
+	suite.cleanupTest()
+	suite.setupTestHelper(fmt.Sprintf("metadata-index:\n  path: %s\n  refresh-sec: 0\n", path))
+	suite.attrCache.cacheTimeout = 0
+
+	var notified []invalidation.Event
+	invalidation.SetNotifier(func(e invalidation.Event) { notified = append(notified, e) })
+	defer invalidation.SetNotifier(nil)
+
+	// File grew in the container since the index was built
+	options := internal.GetAttrOptions{Name: "a/b"}
+	suite.mock.EXPECT().GetAttr(options).Return(getPathAttr("a/b", 30, fs.FileMode(defaultMode), false), nil)
+	attr, err := suite.attrCache.GetAttr(options)
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(30, attr.Size)
+	suite.assert.Equal([]invalidation.Event{{Path: "a/b"}}, notified)
+
+	// Paths missing from a stale index are looked up in storage as well
+	options = internal.GetAttrOptions{Name: "a/missing"}
+	suite.mock.EXPECT().GetAttr(options).Return(getPathAttr("a/missing", 10, fs.FileMode(defaultMode), false), nil)
+	_, err = suite.attrCache.GetAttr(options)
+	suite.assert.NoError(err)
+}
+
+// Tests that the kernel is notified of paths found changed in the container when refreshing their attributes
+func (suite *attrCacheTestSuite) TestGetAttrNotifiesChange() {
+	defer suite.cleanupTest()
+	suite.attrCache.cacheTimeout = 0
+
+	var notified []invalidation.Event
+	invalidation.SetNotifier(func(e invalidation.Event) { notified = append(notified, e) })
+	defer invalidation.SetNotifier(nil)
+
+	options := internal.GetAttrOptions{Name: "a"}
+	dir := getPathAttr("a", 4096, fs.FileMode(defaultMode), false)
+	dir.Flags = internal.NewDirBitMap()
+	results := []struct {
+		attr     *internal.ObjAttr
+		err      error
+		notified []invalidation.Event
+	}{
+		{getPathAttr("a", 10, fs.FileMode(defaultMode), false), nil, nil},
+		{getPathAttr("a", 10, fs.FileMode(defaultMode), false), nil, nil},
+		{getPathAttr("a", 20, fs.FileMode(defaultMode), false), nil, []invalidation.Event{{Path: "a"}}},
+		{&internal.ObjAttr{}, syscall.ENOENT, []invalidation.Event{{Path: "a"}}},
+		{&internal.ObjAttr{}, syscall.ENOENT, nil},
+		{dir, nil, []invalidation.Event{{Path: "a", Dir: true}}},
+		{&internal.ObjAttr{}, os.ErrPermission, nil},
+	}
+
+	for _, r := range results {
+		notified = nil
+		suite.mock.EXPECT().GetAttr(options).Return(r.attr, r.err)
+		_, _ = suite.attrCache.GetAttr(options)
+		suite.assert.Equal(r.notified, notified)
+	}
+
+	// Changes seen in a listing are notified as well, paths changed through the mount are not
+	notified = nil
+	suite.attrCache.cacheAttributes([]*internal.ObjAttr{getPathAttr("b", 10, fs.FileMode(defaultMode), false)})
+	suite.attrCache.cacheAttributes([]*internal.ObjAttr{getPathAttr("b", 30, fs.FileMode(defaultMode), false)})
+	suite.assert.Equal([]invalidation.Event{{Path: "b"}}, notified)
+
+	notified = nil
+	suite.attrCache.invalidatePath("b")
+	suite.attrCache.cacheAttributes([]*internal.ObjAttr{getPathAttr("b", 40, fs.FileMode(defaultMode), false)})
+	suite.assert.Empty(notified)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestAttrCacheTestSuite(t *testing.T) {
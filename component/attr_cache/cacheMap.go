@@ -34,8 +34,11 @@
 package attr_cache
 
 import (
+	"container/list"
 	"os"
+	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
@@ -53,6 +56,32 @@ type attrCacheItem struct {
 	attr     *internal.ObjAttr
 	cachedAt time.Time
 	attrFlag common.BitMap16
+
+	name string        // Path the item is cached for
+	size int64         // Approximate memory taken by the item
+	elem *list.Element // Position of the item in the lru list
+}
+
+// Memory taken by an item apart from the strings and metadata it refers to, including its map entry and list element
+var itemOverhead = int64(unsafe.Sizeof(attrCacheItem{}) + unsafe.Sizeof(internal.ObjAttr{}) + unsafe.Sizeof(list.Element{}) + 48)
+
+// itemSize : Approximate memory taken by the item of a path with the given attributes
+func itemSize(path string, attr *internal.ObjAttr) int64 {
+	size := itemOverhead + int64(len(path))
+	if attr == nil {
+		return size
+	}
+
+	// Name is usually part of Path so it is not counted
+	size += int64(len(attr.Path) + len(attr.ETag) + len(attr.MD5))
+	for key, value := range attr.Metadata {
+		size += int64(len(key)) + 48
+		if value != nil {
+			size += int64(len(*value))
+		}
+	}
+
+	return size
 }
 
 func newAttrCacheItem(attr *internal.ObjAttr, exists bool, cachedAt time.Time) *attrCacheItem {
@@ -98,6 +127,23 @@ func (value *attrCacheItem) isDeleted() bool {
 	return !value.exists()
 }
 
+// changed : Whether the path was modified, deleted or created in the container going by the result of fetching
+// its attributes again. ETag is compared only if known on both sides as local changes do not know the new one.
+func (value *attrCacheItem) changed(attr *internal.ObjAttr, err error) bool {
+	if err == syscall.ENOENT {
+		return value.exists()
+	} else if err != nil {
+		return false
+	}
+
+	if !value.exists() {
+		return true
+	}
+
+	return value.attr.Size != attr.Size ||
+		(value.attr.ETag != "" && attr.ETag != "" && value.attr.ETag != attr.ETag)
+}
+
 func (value *attrCacheItem) setSize(size int64) {
 	value.attr.Mtime = time.Now()
 	value.attr.Size = size
@@ -107,5 +153,19 @@ func (value *attrCacheItem) setSize(size int64) {
 func (value *attrCacheItem) setMode(mode os.FileMode) {
 	value.attr.Mode = mode
 	value.attr.Ctime = time.Now()
+	// Blob has a new ETag now which is not known
+	value.attr.ETag = ""
+	value.cachedAt = time.Now()
+}
+
+func (value *attrCacheItem) setTimes(atime time.Time, mtime time.Time) {
+	if !atime.IsZero() {
+		value.attr.Atime = atime
+	}
+	if !mtime.IsZero() {
+		value.attr.Mtime = mtime
+	}
+	value.attr.Ctime = time.Now()
+	value.attr.ETag = ""
 	value.cachedAt = time.Now()
 }
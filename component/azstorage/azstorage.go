@@ -35,7 +35,10 @@ package azstorage
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"os"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -58,6 +61,7 @@ type AzStorage struct {
 	stConfig    AzStorageConfig
 	startTime   time.Time
 	listBlocked bool
+	snapshotIDs snapshotIDCache
 }
 
 const compName = "azstorage"
@@ -197,6 +201,10 @@ func (az *AzStorage) ListContainers() ([]string, error) {
 func (az *AzStorage) CreateDir(options internal.CreateDirOptions) error {
 	log.Trace("AzStorage::CreateDir : %s", options.Name)
 
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
 	err := az.storage.CreateDirectory(internal.TruncateDirName(options.Name))
 
 	if err == nil {
@@ -210,6 +218,10 @@ func (az *AzStorage) CreateDir(options internal.CreateDirOptions) error {
 func (az *AzStorage) DeleteDir(options internal.DeleteDirOptions) error {
 	log.Trace("AzStorage::DeleteDir : %s", options.Name)
 
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
 	err := az.storage.DeleteDirectory(internal.TruncateDirName(options.Name))
 
 	if err == nil {
@@ -233,7 +245,7 @@ func formatListDirName(path string) string {
 
 func (az *AzStorage) IsDirEmpty(options internal.IsDirEmptyOptions) bool {
 	log.Trace("AzStorage::IsDirEmpty : %s", options.Name)
-	list, _, err := az.storage.List(formatListDirName(options.Name), nil, 1)
+	list, _, err := az.list(formatListDirName(options.Name), nil, 1)
 	if err != nil {
 		log.Err("AzStorage::IsDirEmpty : error listing [%s]", err)
 		return false
@@ -263,7 +275,7 @@ func (az *AzStorage) ReadDir(options internal.ReadDirOptions) ([]*internal.ObjAt
 	var iteration int = 0
 	var marker *string = nil
 	for {
-		new_list, new_marker, err := az.storage.List(path, marker, common.MaxDirListCount)
+		new_list, new_marker, err := az.list(path, marker, common.MaxDirListCount)
 		if err != nil {
 			log.Err("AzStorage::ReadDir : Failed to read dir [%s]", err)
 			return blobList, err
@@ -297,7 +309,7 @@ func (az *AzStorage) StreamDir(options internal.StreamDirOptions) ([]*internal.O
 
 	path := formatListDirName(options.Name)
 
-	new_list, new_marker, err := az.storage.List(path, &options.Token, options.Count)
+	new_list, new_marker, err := az.list(path, &options.Token, options.Count)
 	if err != nil {
 		log.Err("AzStorage::StreamDir : Failed to read dir [%s]", err)
 		return new_list, "", err
@@ -336,6 +348,11 @@ func (az *AzStorage) StreamDir(options internal.StreamDirOptions) ([]*internal.O
 
 func (az *AzStorage) RenameDir(options internal.RenameDirOptions) error {
 	log.Trace("AzStorage::RenameDir : %s to %s", options.Src, options.Dst)
+
+	if az.isSnapshotPath(options.Src) || az.isSnapshotPath(options.Dst) {
+		return syscall.EROFS
+	}
+
 	options.Src = internal.TruncateDirName(options.Src)
 	options.Dst = internal.TruncateDirName(options.Dst)
 
@@ -352,6 +369,10 @@ func (az *AzStorage) RenameDir(options internal.RenameDirOptions) error {
 func (az *AzStorage) CreateFile(options internal.CreateFileOptions) (*handlemap.Handle, error) {
 	log.Trace("AzStorage::CreateFile : %s", options.Name)
 
+	if az.isSnapshotPath(options.Name) {
+		return nil, syscall.EROFS
+	}
+
 	// Create a handle object for the file being created
 	// This handle will be added to handlemap by the first component in pipeline
 	handle := handlemap.NewHandle(options.Name)
@@ -360,7 +381,7 @@ func (az *AzStorage) CreateFile(options internal.CreateFileOptions) (*handlemap.
 		return nil, syscall.EFAULT
 	}
 
-	err := az.storage.CreateFile(options.Name, options.Mode)
+	err := az.storage.CreateFile(options)
 	if err != nil {
 		return nil, err
 	}
@@ -377,7 +398,11 @@ func (az *AzStorage) CreateFile(options internal.CreateFileOptions) (*handlemap.
 func (az *AzStorage) OpenFile(options internal.OpenFileOptions) (*handlemap.Handle, error) {
 	log.Trace("AzStorage::OpenFile : %s", options.Name)
 
-	attr, err := az.storage.GetAttr(options.Name)
+	if az.isSnapshotPath(options.Name) && options.Flags&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+		return nil, syscall.EROFS
+	}
+
+	attr, err := az.getAttr(options.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -410,11 +435,17 @@ func (az *AzStorage) CloseFile(options internal.CloseFileOptions) error {
 func (az *AzStorage) DeleteFile(options internal.DeleteFileOptions) error {
 	log.Trace("AzStorage::DeleteFile : %s", options.Name)
 
-	err := az.storage.DeleteFile(options.Name)
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.DeleteFile(options.Name, &options.ETag)
 
 	if err == nil {
 		azStatsCollector.PushEvents(deleteFile, options.Name, nil)
 		azStatsCollector.UpdateStats(stats_manager.Increment, deleteFile, (int64)(1))
+	} else if err == syscall.ESTALE {
+		az.pushETagConflict(options.Name)
 	}
 
 	return err
@@ -423,18 +454,28 @@ func (az *AzStorage) DeleteFile(options internal.DeleteFileOptions) error {
 func (az *AzStorage) RenameFile(options internal.RenameFileOptions) error {
 	log.Trace("AzStorage::RenameFile : %s to %s", options.Src, options.Dst)
 
+	if az.isSnapshotPath(options.Src) || az.isSnapshotPath(options.Dst) {
+		return syscall.EROFS
+	}
+
 	err := az.storage.RenameFile(options.Src, options.Dst)
 
 	if err == nil {
 		azStatsCollector.PushEvents(renameFile, options.Src, map[string]interface{}{src: options.Src, dest: options.Dst})
 		azStatsCollector.UpdateStats(stats_manager.Increment, renameFile, (int64)(1))
+	} else if err == syscall.ESTALE {
+		az.pushETagConflict(options.Src)
 	}
 	return err
 }
 
 func (az *AzStorage) ReadFile(options internal.ReadFileOptions) (data []byte, err error) {
 	//log.Trace("AzStorage::ReadFile : Read %s", h.Path)
-	return az.storage.ReadBuffer(options.Handle.Path, 0, 0)
+	data, err = az.readBuffer(options.Handle.Path, 0, 0)
+	if err == syscall.ENODATA {
+		err = az.rehydrate(options.Handle.Path)
+	}
+	return data, err
 }
 
 func (az *AzStorage) ReadInBuffer(options internal.ReadInBufferOptions) (length int, err error) {
@@ -453,7 +494,19 @@ func (az *AzStorage) ReadInBuffer(options internal.ReadInBufferOptions) (length
 		return 0, nil
 	}
 
-	err = az.storage.ReadInBuffer(options.Handle.Path, options.Offset, dataLen, options.Data)
+	var etag *string
+	if options.ETag != "" {
+		etag = &options.ETag
+	}
+
+	if options.Checksums != nil && !az.isSnapshotPath(options.Handle.Path) {
+		*options.Checksums, err = az.storage.ReadInBufferWithMD5(options.Handle.Path, options.Offset, dataLen, options.Data, etag)
+	} else {
+		err = az.readInBuffer(options.Handle.Path, options.Offset, dataLen, options.Data, etag)
+	}
+	if err == syscall.ENODATA {
+		err = az.rehydrate(options.Handle.Path)
+	}
 	if err != nil {
 		log.Err("AzStorage::ReadInBuffer : Failed to read %s [%s]", options.Handle.Path, err.Error())
 	}
@@ -463,6 +516,10 @@ func (az *AzStorage) ReadInBuffer(options internal.ReadInBufferOptions) (length
 }
 
 func (az *AzStorage) WriteFile(options internal.WriteFileOptions) (int, error) {
+	if az.isSnapshotPath(options.Handle.Path) {
+		return 0, syscall.EROFS
+	}
+
 	err := az.storage.Write(options)
 	return len(options.Data), err
 }
@@ -474,6 +531,11 @@ func (az *AzStorage) GetFileBlockOffsets(options internal.GetFileBlockOffsetsOpt
 
 func (az *AzStorage) TruncateFile(options internal.TruncateFileOptions) error {
 	log.Trace("AzStorage::TruncateFile : %s to %d bytes", options.Name, options.Size)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
 	err := az.storage.TruncateFile(options.Name, options.Size)
 
 	if err == nil {
@@ -485,17 +547,47 @@ func (az *AzStorage) TruncateFile(options internal.TruncateFileOptions) error {
 
 func (az *AzStorage) CopyToFile(options internal.CopyToFileOptions) error {
 	log.Trace("AzStorage::CopyToFile : Read file %s", options.Name)
-	return az.storage.ReadToFile(options.Name, options.Offset, options.Count, options.File)
+
+	var etag *string
+	if options.ETag != "" {
+		etag = &options.ETag
+	}
+
+	err := az.readToFile(options.Name, options.Offset, options.Count, options.File, etag)
+	if err == syscall.ENODATA {
+		err = az.rehydrate(options.Name)
+	}
+	return err
 }
 
 func (az *AzStorage) CopyFromFile(options internal.CopyFromFileOptions) error {
 	log.Trace("AzStorage::CopyFromFile : Upload file %s", options.Name)
-	return az.storage.WriteFromFile(options.Name, options.Metadata, options.File)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.WriteFromFile(options.Name, options.Metadata, options.File, options.ETag)
+	if err == syscall.ESTALE {
+		az.pushETagConflict(options.Name)
+	}
+	return err
+}
+
+// pushETagConflict : Record a write that was rejected because the blob was modified by someone else
+func (az *AzStorage) pushETagConflict(name string) {
+	azStatsCollector.PushEvents(etagConflict, name, nil)
+	azStatsCollector.UpdateStats(stats_manager.Increment, etagConflict, (int64)(1))
 }
 
 // Symlink operations
 func (az *AzStorage) CreateLink(options internal.CreateLinkOptions) error {
 	log.Trace("AzStorage::CreateLink : Create symlink %s -> %s", options.Name, options.Target)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
 	err := az.storage.CreateLink(options.Name, options.Target)
 
 	if err == nil {
@@ -508,7 +600,7 @@ func (az *AzStorage) CreateLink(options internal.CreateLinkOptions) error {
 
 func (az *AzStorage) ReadLink(options internal.ReadLinkOptions) (string, error) {
 	log.Trace("AzStorage::ReadLink : Read symlink %s", options.Name)
-	data, err := az.storage.ReadBuffer(options.Name, 0, options.Size)
+	data, err := az.readBuffer(options.Name, 0, options.Size)
 
 	if err != nil {
 		azStatsCollector.PushEvents(readLink, options.Name, nil)
@@ -521,12 +613,17 @@ func (az *AzStorage) ReadLink(options internal.ReadLinkOptions) (string, error)
 // Attribute operations
 func (az *AzStorage) GetAttr(options internal.GetAttrOptions) (attr *internal.ObjAttr, err error) {
 	//log.Trace("AzStorage::GetAttr : Get attributes of file %s", name)
-	return az.storage.GetAttr(options.Name)
+	return az.getAttr(options.Name)
 }
 
 func (az *AzStorage) Chmod(options internal.ChmodOptions) error {
 	log.Trace("AzStorage::Chmod : Change mod of file %s", options.Name)
-	err := az.storage.ChangeMod(options.Name, options.Mode)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.ChangeMod(options.Name, options.Mode, options.ETag)
 
 	if err == nil {
 		azStatsCollector.PushEvents(chmod, options.Name, map[string]interface{}{mode: options.Mode.String()})
@@ -538,12 +635,337 @@ func (az *AzStorage) Chmod(options internal.ChmodOptions) error {
 
 func (az *AzStorage) Chown(options internal.ChownOptions) error {
 	log.Trace("AzStorage::Chown : Change ownership of file %s to %d-%d", options.Name, options.Owner, options.Group)
-	return az.storage.ChangeOwner(options.Name, options.Owner, options.Group)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.ChangeOwner(options.Name, options.Owner, options.Group, options.ETag)
+
+	if err == nil {
+		azStatsCollector.PushEvents(chown, options.Name, map[string]interface{}{owner: options.Owner, group: options.Group})
+		azStatsCollector.UpdateStats(stats_manager.Increment, chown, (int64)(1))
+	}
+
+	return err
+}
+
+func (az *AzStorage) SetAttr(options internal.SetAttrOptions) error {
+	log.Trace("AzStorage::SetAttr : Change times of %s to %v-%v", options.Name, options.Attr.Atime, options.Attr.Mtime)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	attr, err := az.storage.GetAttr(options.Name)
+	if err != nil {
+		return err
+	}
+
+	// Updating metadata changes last modified time of the blob, so the time which is not being changed
+	// also needs to be persisted to keep it intact
+	atime := options.Attr.Atime
+	if atime.IsZero() {
+		atime = attr.Atime
+	}
+
+	mtime := options.Attr.Mtime
+	if mtime.IsZero() {
+		mtime = attr.Mtime
+	}
+
+	metadata := make(map[string]*string)
+	for k, v := range attr.Metadata {
+		metadata[k] = v
+	}
+	setTimeMetadata(metadata, atimeKey, atime)
+	setTimeMetadata(metadata, mtimeKey, mtime)
+
+	newETag, err := az.storage.SetMetadata(options.Name, metadata)
+	if err == syscall.ENOENT && attr.IsDir() {
+		// Virtual directory without a marker blob has nowhere to hold the times
+		log.Info("AzStorage::SetAttr : %s has no marker blob, times are not persisted", options.Name)
+		return nil
+	}
+
+	if err == nil {
+		refreshETag(options.ETag, attr.ETag, newETag)
+		azStatsCollector.PushEvents(setAttr, options.Name, map[string]interface{}{accessTime: atime, modTime: mtime})
+		azStatsCollector.UpdateStats(stats_manager.Increment, setAttr, (int64)(1))
+	}
+
+	return err
+}
+
+// Extended attribute operations
+func (az *AzStorage) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	log.Trace("AzStorage::GetXattr : Get %s of %s", options.Attr, options.Name)
+
+	if options.Attr == tierXattr || options.Attr == archiveStatusXattr {
+		return az.getTier(options.Name, options.Attr)
+	}
+
+	key, err := getXattrKey(options.Attr)
+	if err != nil {
+		// Attributes outside user namespace are never persisted
+		return nil, syscall.ENODATA
+	}
+
+	attr, err := az.getAttr(options.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	k, found := getMetadataKey(attr.Metadata, key)
+	if !found || attr.Metadata[k] == nil {
+		return nil, syscall.ENODATA
+	}
+
+	value, err := base64.StdEncoding.DecodeString(*attr.Metadata[k])
+	if err != nil {
+		log.Err("AzStorage::GetXattr : Failed to decode %s of %s [%s]", options.Attr, options.Name, err.Error())
+		return nil, syscall.EIO
+	}
+
+	return value, nil
+}
+
+func (az *AzStorage) SetXattr(options internal.SetXattrOptions) error {
+	log.Trace("AzStorage::SetXattr : Set %s of %s", options.Attr, options.Name)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	if options.Attr == tierXattr {
+		return az.setTier(options.Name, string(options.Value))
+	} else if options.Attr == archiveStatusXattr {
+		return syscall.ENOTSUP
+	}
+
+	key, err := getXattrKey(options.Attr)
+	if err != nil {
+		log.Err("AzStorage::SetXattr : Unsupported attribute %s for %s", options.Attr, options.Name)
+		return err
+	}
+
+	attr, err := az.storage.GetAttr(options.Name)
+	if err != nil {
+		return err
+	}
+
+	// Set metadata replaces the whole set so start from what exists on the path right now
+	metadata := make(map[string]*string)
+	for k, v := range attr.Metadata {
+		metadata[k] = v
+	}
+
+	k, found := getMetadataKey(metadata, key)
+	if found && options.Flags&internal.XattrCreate != 0 {
+		return syscall.EEXIST
+	} else if !found && options.Flags&internal.XattrReplace != 0 {
+		return syscall.ENODATA
+	}
+
+	delete(metadata, k)
+	metadata[key] = to.Ptr(base64.StdEncoding.EncodeToString(options.Value))
+
+	newETag, err := az.storage.SetMetadata(options.Name, metadata)
+	if err == nil {
+		refreshETag(options.ETag, attr.ETag, newETag)
+		azStatsCollector.PushEvents(setXattr, options.Name, map[string]interface{}{xattr: options.Attr})
+		azStatsCollector.UpdateStats(stats_manager.Increment, setXattr, (int64)(1))
+	}
+
+	return err
+}
+
+func (az *AzStorage) ListXattr(options internal.ListXattrOptions) ([]string, error) {
+	log.Trace("AzStorage::ListXattr : List attributes of %s", options.Name)
+
+	attr, err := az.getAttr(options.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for k := range attr.Metadata {
+		if name, ok := getXattrName(k); ok {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func (az *AzStorage) RemoveXattr(options internal.RemoveXattrOptions) error {
+	log.Trace("AzStorage::RemoveXattr : Remove %s of %s", options.Attr, options.Name)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	if options.Attr == tierXattr || options.Attr == archiveStatusXattr {
+		return syscall.ENOTSUP
+	}
+
+	key, err := getXattrKey(options.Attr)
+	if err != nil {
+		return syscall.ENODATA
+	}
+
+	attr, err := az.storage.GetAttr(options.Name)
+	if err != nil {
+		return err
+	}
+
+	k, found := getMetadataKey(attr.Metadata, key)
+	if !found {
+		return syscall.ENODATA
+	}
+
+	metadata := make(map[string]*string)
+	for mk, v := range attr.Metadata {
+		if mk != k {
+			metadata[mk] = v
+		}
+	}
+
+	newETag, err := az.storage.SetMetadata(options.Name, metadata)
+	if err == nil {
+		refreshETag(options.ETag, attr.ETag, newETag)
+		azStatsCollector.PushEvents(removeXattr, options.Name, map[string]interface{}{xattr: options.Attr})
+		azStatsCollector.UpdateStats(stats_manager.Increment, removeXattr, (int64)(1))
+	}
+
+	return err
+}
+
+// getTier : Serve the tier attributes from the properties of the blob
+func (az *AzStorage) getTier(name string, attr string) ([]byte, error) {
+	if az.isSnapshotPath(name) {
+		return nil, syscall.ENODATA
+	}
+
+	blobTier, archiveStatus, err := az.storage.GetTier(name)
+	if err == syscall.ENOENT {
+		// Directories without a marker blob have no tier
+		return nil, syscall.ENODATA
+	} else if err != nil {
+		return nil, err
+	}
+
+	value := blobTier
+	if attr == archiveStatusXattr {
+		value = archiveStatus
+	}
+
+	if value == "" {
+		return nil, syscall.ENODATA
+	}
+	return []byte(value), nil
+}
+
+// setTier : Change access tier of a blob, moving an archived blob to an online tier starts its rehydration
+func (az *AzStorage) setTier(name string, value string) error {
+	log.Trace("AzStorage::setTier : Set tier of %s to %s", name, value)
+
+	blobTier := getAccessTierType(strings.TrimRight(value, "\x00\n "))
+	if blobTier == nil {
+		log.Err("AzStorage::setTier : Invalid tier %s for %s", value, name)
+		return syscall.EINVAL
+	}
+
+	err := az.storage.SetTier(name, blobTier)
+	if err == nil {
+		azStatsCollector.PushEvents(setTier, name, map[string]interface{}{tier: string(*blobTier)})
+		azStatsCollector.UpdateStats(stats_manager.Increment, setTier, (int64)(1))
+	}
+
+	return err
+}
+
+// rehydrate : Data of an archived blob can not be read till it is rehydrated to an online tier.
+// Rehydration is started if a tier is configured for it. EAGAIN is returned while rehydration is pending and
+// ENODATA if the blob stays archived.
+func (az *AzStorage) rehydrate(name string) error {
+	blobTier, archiveStatus, err := az.storage.GetTier(name)
+	if err != nil {
+		return syscall.ENODATA
+	}
+
+	if archiveStatus != "" {
+		log.Info("AzStorage::rehydrate : %s is archived, %s", name, archiveStatus)
+		azStatsCollector.PushEvents(rehydrate, name, map[string]interface{}{tier: blobTier, status: archiveStatus})
+		return syscall.EAGAIN
+	}
+
+	if az.stConfig.rehydrateTier == nil {
+		log.Err("AzStorage::rehydrate : %s is archived, change its tier or set rehydrate-tier to read it", name)
+		return syscall.ENODATA
+	}
+
+	err = az.storage.SetTier(name, az.stConfig.rehydrateTier)
+	if err != nil {
+		log.Err("AzStorage::rehydrate : Failed to rehydrate %s [%s]", name, err.Error())
+		return syscall.ENODATA
+	}
+
+	log.Info("AzStorage::rehydrate : Started rehydration of %s to %s", name, *az.stConfig.rehydrateTier)
+	azStatsCollector.PushEvents(rehydrate, name, map[string]interface{}{tier: string(*az.stConfig.rehydrateTier), status: "rehydrate-started"})
+	azStatsCollector.UpdateStats(stats_manager.Increment, rehydrate, (int64)(1))
+
+	return syscall.EAGAIN
+}
+
+func (az *AzStorage) AcquireLease(options internal.AcquireLeaseOptions) error {
+	log.Trace("AzStorage::AcquireLease : Lease %s for %d seconds", options.Name, options.Duration)
+
+	if az.isSnapshotPath(options.Name) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.AcquireLease(options.Name, options.Duration)
+	if err == nil {
+		azStatsCollector.PushEvents(acquireLease, options.Name, nil)
+		azStatsCollector.UpdateStats(stats_manager.Increment, acquireLease, (int64)(1))
+	} else if err == syscall.EBUSY {
+		azStatsCollector.PushEvents(leaseConflict, options.Name, nil)
+		azStatsCollector.UpdateStats(stats_manager.Increment, leaseConflict, (int64)(1))
+	}
+
+	return err
+}
+
+func (az *AzStorage) RenewLease(options internal.RenewLeaseOptions) error {
+	log.Trace("AzStorage::RenewLease : Renew lease of %s", options.Name)
+	return az.storage.RenewLease(options.Name)
+}
+
+func (az *AzStorage) ReleaseLease(options internal.ReleaseLeaseOptions) error {
+	log.Trace("AzStorage::ReleaseLease : Release lease of %s", options.Name)
+
+	err := az.storage.ReleaseLease(options.Name)
+	if err == nil {
+		azStatsCollector.PushEvents(releaseLease, options.Name, nil)
+		azStatsCollector.UpdateStats(stats_manager.Increment, releaseLease, (int64)(1))
+	}
+
+	return err
 }
 
 func (az *AzStorage) FlushFile(options internal.FlushFileOptions) error {
 	log.Trace("AzStorage::FlushFile : Flush file %s", options.Handle.Path)
-	return az.storage.StageAndCommit(options.Handle.Path, options.Handle.CacheObj.BlockOffsetList)
+
+	if az.isSnapshotPath(options.Handle.Path) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.StageAndCommit(options.Handle.Path, options.Handle.CacheObj.BlockOffsetList)
+	if err == syscall.ESTALE {
+		az.pushETagConflict(options.Handle.Path)
+	}
+	return err
 }
 
 func (az *AzStorage) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
@@ -551,15 +973,26 @@ func (az *AzStorage) GetCommittedBlockList(name string) (*internal.CommittedBloc
 }
 
 func (az *AzStorage) StageData(opt internal.StageDataOptions) error {
-	return az.storage.StageBlock(opt.Name, opt.Data, opt.Id)
+	if az.isSnapshotPath(opt.Name) {
+		return syscall.EROFS
+	}
+
+	return az.storage.StageBlock(opt.Name, opt.Data, opt.Id, opt.Checksum)
 }
 
 func (az *AzStorage) CommitData(opt internal.CommitDataOptions) error {
-	return az.storage.CommitBlocks(opt.Name, opt.List)
+	if az.isSnapshotPath(opt.Name) {
+		return syscall.EROFS
+	}
+
+	err := az.storage.CommitBlocks(opt.Name, opt.List, opt.ETag)
+	if err == syscall.ESTALE {
+		az.pushETagConflict(opt.Name)
+	}
+	return err
 }
 
 // TODO : Below methods are pending to be implemented
-// SetAttr(string, internal.ObjAttr) error
 // UnlinkFile(string) error
 // ReleaseFile(*handlemap.Handle) error
 // FlushFile(*handlemap.Handle) error
@@ -665,6 +1098,18 @@ func init() {
 	preserveACL := config.AddBoolFlag("preserve-acl", false, "Preserve ACL and Permissions set on file during updates")
 	config.BindPFlag(compName+".preserve-acl", preserveACL)
 
+	posixMetadata := config.AddBoolFlag("posix-metadata", false, "Persist owner, group and mode of files in blob metadata for block blob accounts.")
+	config.BindPFlag(compName+".posix-metadata", posixMetadata)
+
+	optimisticConcurrency := config.AddBoolFlag("optimistic-concurrency", false, "Fail uploads and renames with ESTALE if the blob was modified by someone else since it was opened.")
+	config.BindPFlag(compName+".optimistic-concurrency", optimisticConcurrency)
+
+	snapshotBrowsing := config.AddBoolFlag("snapshot-browsing", false, "Present snapshots and earlier versions of blobs read only under .snapshots directory.")
+	config.BindPFlag(compName+".snapshot-browsing", snapshotBrowsing)
+
+	rehydrateTier := config.AddStringFlag("rehydrate-tier", "", "Start rehydration of archived blobs to this tier when they are opened. Valid values are hot, cool and cold.")
+	config.BindPFlag(compName+".rehydrate-tier", rehydrateTier)
+
 	config.RegisterFlagCompletionFunc("container-name", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		return nil, cobra.ShellCompDirectiveNoFileComp
 	})
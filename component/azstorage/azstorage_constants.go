@@ -40,17 +40,27 @@ const (
 	uploadProgress   = "UploadProgress"
 	bytesTfrd        = "Bytes Transferred"
 
-	createDir    = "CreateDir"
-	deleteDir    = "DeleteDir"
-	streamDir    = "StreamDir"
-	renameDir    = "RenameDir"
-	createFile   = "CreateFile"
-	deleteFile   = "DeleteFile"
-	renameFile   = "RenameFile"
-	truncateFile = "TruncateFile"
-	createLink   = "CreateLink"
-	readLink     = "ReadLink"
-	chmod        = "Chmod"
+	createDir     = "CreateDir"
+	deleteDir     = "DeleteDir"
+	streamDir     = "StreamDir"
+	renameDir     = "RenameDir"
+	createFile    = "CreateFile"
+	deleteFile    = "DeleteFile"
+	renameFile    = "RenameFile"
+	truncateFile  = "TruncateFile"
+	createLink    = "CreateLink"
+	readLink      = "ReadLink"
+	chmod         = "Chmod"
+	chown         = "Chown"
+	setAttr       = "SetAttr"
+	setXattr      = "SetXattr"
+	removeXattr   = "RemoveXattr"
+	etagConflict  = "ETagConflict"
+	acquireLease  = "AcquireLease"
+	releaseLease  = "ReleaseLease"
+	leaseConflict = "LeaseConflict"
+	setTier       = "SetTier"
+	rehydrate     = "Rehydrate"
 
 	openHandles = "OpenFileHandles"
 	mode        = "Mode"
@@ -59,6 +69,13 @@ const (
 	dest        = "Dest"
 	size        = "Size"
 	target      = "Target"
+	xattr       = "Xattr"
+	modTime     = "Mtime"
+	accessTime  = "Atime"
+	owner       = "Owner"
+	group       = "Group"
+	tier        = "Tier"
+	status      = "Status"
 )
 
 // headers which should be logged and not redacted
@@ -39,19 +39,24 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
@@ -62,6 +67,11 @@ import (
 const (
 	folderKey           = "hdi_isfolder"
 	symlinkKey          = "is_symlink"
+	mtimeKey            = "blobfuse_mtime"
+	atimeKey            = "blobfuse_atime"
+	uidKey              = "blobfuse_uid"
+	gidKey              = "blobfuse_gid"
+	modeKey             = "blobfuse_mode"
 	max_context_timeout = 5
 )
 
@@ -74,6 +84,8 @@ type BlockBlob struct {
 	downloadOptions *blob.DownloadFileOptions
 	listDetails     container.ListBlobsInclude
 	blockLocks      common.KeyedMutex
+	leases          sync.Map // lease id of the blobs leased by this mount
+	versions        sync.Map // blob and id pairs where the id is a version id and not a snapshot
 }
 
 // Verify that BlockBlob implements AzConnection interface
@@ -229,10 +241,15 @@ func (bb *BlockBlob) SetPrefixPath(path string) error {
 }
 
 // CreateFile : Create a new file in the container/virtual directory
-func (bb *BlockBlob) CreateFile(name string, mode os.FileMode) error {
-	log.Trace("BlockBlob::CreateFile : name %s", name)
+func (bb *BlockBlob) CreateFile(options internal.CreateFileOptions) error {
+	log.Trace("BlockBlob::CreateFile : name %s", options.Name)
 	var data []byte
-	return bb.WriteFromBuffer(name, nil, data)
+	// New file starts with no metadata of its own other than its owner and mode
+	metadata := make(map[string]*string)
+	if bb.Config.posixMetadata {
+		metadata = newPosixMetadata(options)
+	}
+	return bb.WriteFromBuffer(options.Name, metadata, data)
 }
 
 // CreateDirectory : Create a new directory in the container/virtual directory
@@ -256,12 +273,13 @@ func (bb *BlockBlob) CreateLink(source string, target string) error {
 }
 
 // DeleteFile : Delete a blob in the container/virtual directory
-func (bb *BlockBlob) DeleteFile(name string) (err error) {
+func (bb *BlockBlob) DeleteFile(name string, etag *string) (err error) {
 	log.Trace("BlockBlob::DeleteFile : name %s", name)
 
 	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
 	_, err = blobClient.Delete(context.Background(), &blob.DeleteOptions{
-		DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+		DeleteSnapshots:  to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+		AccessConditions: bb.getAccessConditions(name, etag),
 	})
 	if err != nil {
 		serr := storeBlobErrToErr(err)
@@ -270,13 +288,18 @@ func (bb *BlockBlob) DeleteFile(name string) (err error) {
 			return syscall.ENOENT
 		} else if serr == BlobIsUnderLease {
 			log.Err("BlockBlob::DeleteFile : %s is under lease [%s]", name, err.Error())
-			return syscall.EIO
+			return syscall.EBUSY
+		} else if serr == ErrConditionNotMet {
+			log.Warn("BlockBlob::DeleteFile : %s was modified by someone else, can not delete [%s]", name, err.Error())
+			return syscall.ESTALE
 		} else {
 			log.Err("BlockBlob::DeleteFile : Failed to delete blob %s [%s]", name, err.Error())
 			return err
 		}
 	}
 
+	// Lease goes away along with the blob
+	bb.leases.Delete(name)
 	return nil
 }
 
@@ -296,14 +319,14 @@ func (bb *BlockBlob) DeleteDirectory(name string) (err error) {
 
 		// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
 		for _, blobInfo := range listBlobResp.Segment.BlobItems {
-			err = bb.DeleteFile(split(bb.Config.prefixPath, *blobInfo.Name))
+			err = bb.DeleteFile(split(bb.Config.prefixPath, *blobInfo.Name), nil)
 			if err != nil {
 				log.Err("BlockBlob::DeleteDirectory : Failed to delete file %s [%s]", *blobInfo.Name, err.Error())
 			}
 		}
 	}
 
-	err = bb.DeleteFile(name)
+	err = bb.DeleteFile(name, nil)
 	// libfuse deletes the files in the directory before this method is called.
 	// If the marker blob for directory is not present, ignore the ENOENT error.
 	if err == syscall.ENOENT {
@@ -320,10 +343,35 @@ func (bb *BlockBlob) RenameFile(source string, target string) error {
 	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, source))
 	newBlobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, target))
 
+	// With optimistic concurrency the copy and the delete of source are tied to the same version of the source,
+	// so that a write done on the source by someone else in between is not lost silently
+	var srcETag *string
+	var srcMatch *azcore.ETag
+	if bb.Config.optimisticConcurrency {
+		prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+			CPKInfo: bb.blobCPKOpt,
+		})
+		if err != nil {
+			serr := storeBlobErrToErr(err)
+			if serr == ErrFileNotFound {
+				log.Err("BlockBlob::RenameFile : Src Blob doesn't Exist %s [%s]", source, err.Error())
+				return syscall.ENOENT
+			}
+			log.Err("BlockBlob::RenameFile : Failed to get properties of %s [%s]", source, err.Error())
+			return err
+		}
+		srcETag = to.Ptr(etagToString(prop.ETag))
+		srcMatch = prop.ETag
+	}
+
 	// not specifying source blob metadata, since passing empty metadata headers copies
 	// the source blob metadata to destination blob
 	startCopy, err := newBlobClient.StartCopyFromURL(context.Background(), blobClient.URL(), &blob.StartCopyFromURLOptions{
 		Tier: bb.Config.defaultTier,
+		SourceModifiedAccessConditions: &blob.SourceModifiedAccessConditions{
+			SourceIfMatch: srcMatch,
+		},
+		AccessConditions: bb.getAccessConditions(target, nil),
 	})
 
 	if err != nil {
@@ -333,6 +381,9 @@ func (bb *BlockBlob) RenameFile(source string, target string) error {
 			//before making the call for RenameFile
 			log.Err("BlockBlob::RenameFile : Src Blob doesn't Exist %s [%s]", source, err.Error())
 			return syscall.ENOENT
+		} else if serr == ErrConditionNotMet {
+			log.Warn("BlockBlob::RenameFile : %s was modified by someone else, can not rename [%s]", source, err.Error())
+			return syscall.ESTALE
 		}
 		log.Err("BlockBlob::RenameFile : Failed to start copy of file %s [%s]", source, err.Error())
 		return err
@@ -353,14 +404,14 @@ func (bb *BlockBlob) RenameFile(source string, target string) error {
 	log.Trace("BlockBlob::RenameFile : %s -> %s done", source, target)
 
 	// Copy of the file is done so now delete the older file
-	err = bb.DeleteFile(source)
+	err = bb.DeleteFile(source, srcETag)
 	for retry := 0; retry < 3 && err == syscall.ENOENT; retry++ {
 		// Sometimes backend is able to copy source file to destination but when we try to delete the
 		// source files it returns back with ENOENT. If file was just created on backend it might happen
 		// that it has not been synced yet at all layers and hence delete is not able to find the source file
 		log.Trace("BlockBlob::RenameFile : %s -> %s, unable to find source. Retrying %d", source, target, retry)
 		time.Sleep(1 * time.Second)
-		err = bb.DeleteFile(source)
+		err = bb.DeleteFile(source, srcETag)
 	}
 
 	if err == syscall.ENOENT {
@@ -441,8 +492,14 @@ func (bb *BlockBlob) getAttrUsingRest(name string) (attr *internal.ObjAttr, err
 		}
 	}
 
+	return bb.newAttrFromProperties(name, &prop), nil
+}
+
+// newAttrFromProperties : Convert properties of a blob to its attributes
+func (bb *BlockBlob) newAttrFromProperties(name string, prop *blob.GetPropertiesResponse) *internal.ObjAttr {
 	// Since block blob does not support acls, we set mode to 0 and FlagModeDefault to true so the fuse layer can return the default permission.
-	attr = &internal.ObjAttr{
+	// If posix metadata is enabled and the mode was persisted earlier, that is used instead.
+	attr := &internal.ObjAttr{
 		Path:   name, // We don't need to strip the prefixPath here since we pass the input name
 		Name:   filepath.Base(name),
 		Size:   *prop.ContentLength,
@@ -453,13 +510,13 @@ func (bb *BlockBlob) getAttrUsingRest(name string) (attr *internal.ObjAttr, err
 		Crtime: *prop.CreationTime,
 		Flags:  internal.NewFileBitMap(),
 		MD5:    prop.ContentMD5,
+		ETag:   etagToString(prop.ETag),
 	}
 
 	parseMetadata(attr, prop.Metadata)
+	bb.parsePosixMetadata(attr)
 
-	attr.Flags.Set(internal.PropFlagModeDefault)
-
-	return attr, nil
+	return attr
 }
 
 func (bb *BlockBlob) getAttrUsingList(name string) (attr *internal.ObjAttr, err error) {
@@ -565,14 +622,6 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 		return blobList, nil, err
 	}
 
-	dereferenceTime := func(input *time.Time, defaultTime time.Time) time.Time {
-		if input == nil {
-			return defaultTime
-		} else {
-			return *input
-		}
-	}
-
 	// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
 	// Since block blob does not support acls, we set mode to 0 and FlagModeDefault to true so the fuse layer can return the default permission.
 
@@ -588,20 +637,7 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 				return blobList, nil, err
 			}
 		} else {
-			attr = &internal.ObjAttr{
-				Path:   split(bb.Config.prefixPath, *blobInfo.Name),
-				Name:   filepath.Base(*blobInfo.Name),
-				Size:   *blobInfo.Properties.ContentLength,
-				Mode:   0,
-				Mtime:  *blobInfo.Properties.LastModified,
-				Atime:  dereferenceTime(blobInfo.Properties.LastAccessedOn, *blobInfo.Properties.LastModified),
-				Ctime:  *blobInfo.Properties.LastModified,
-				Crtime: dereferenceTime(blobInfo.Properties.CreationTime, *blobInfo.Properties.LastModified),
-				Flags:  internal.NewFileBitMap(),
-				MD5:    blobInfo.Properties.ContentMD5,
-			}
-			parseMetadata(attr, blobInfo.Metadata)
-			attr.Flags.Set(internal.PropFlagModeDefault)
+			attr = bb.newAttrFromBlobItem(blobInfo)
 		}
 		blobList = append(blobList, attr)
 
@@ -628,19 +664,7 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 			if err == syscall.ENOENT {
 				// For these dirs we get only the name and no other properties so hardcoding time to current time
 				name := strings.TrimSuffix(*blobInfo.Name, "/")
-				attr := &internal.ObjAttr{
-					Path:  split(bb.Config.prefixPath, name),
-					Name:  filepath.Base(name),
-					Size:  4096,
-					Mode:  os.ModeDir,
-					Mtime: time.Now(),
-					Flags: internal.NewDirBitMap(),
-				}
-				attr.Atime = attr.Mtime
-				attr.Crtime = attr.Mtime
-				attr.Ctime = attr.Mtime
-				attr.Flags.Set(internal.PropFlagModeDefault)
-				blobList = append(blobList, attr)
+				blobList = append(blobList, newDirAttr(split(bb.Config.prefixPath, name)))
 			}
 		}
 	}
@@ -653,6 +677,52 @@ func (bb *BlockBlob) List(prefix string, marker *string, count int32) ([]*intern
 	return blobList, listBlob.NextMarker, nil
 }
 
+// newAttrFromBlobItem : Convert a blob returned by list to its attributes
+func (bb *BlockBlob) newAttrFromBlobItem(blobInfo *container.BlobItem) *internal.ObjAttr {
+	dereferenceTime := func(input *time.Time, defaultTime time.Time) time.Time {
+		if input == nil {
+			return defaultTime
+		} else {
+			return *input
+		}
+	}
+
+	attr := &internal.ObjAttr{
+		Path:   split(bb.Config.prefixPath, *blobInfo.Name),
+		Name:   filepath.Base(*blobInfo.Name),
+		Size:   *blobInfo.Properties.ContentLength,
+		Mode:   0,
+		Mtime:  *blobInfo.Properties.LastModified,
+		Atime:  dereferenceTime(blobInfo.Properties.LastAccessedOn, *blobInfo.Properties.LastModified),
+		Ctime:  *blobInfo.Properties.LastModified,
+		Crtime: dereferenceTime(blobInfo.Properties.CreationTime, *blobInfo.Properties.LastModified),
+		Flags:  internal.NewFileBitMap(),
+		MD5:    blobInfo.Properties.ContentMD5,
+		ETag:   etagToString(blobInfo.Properties.ETag),
+	}
+	parseMetadata(attr, blobInfo.Metadata)
+	bb.parsePosixMetadata(attr)
+
+	return attr
+}
+
+// newDirAttr : Attributes of a directory which has no marker blob, so only its name is known
+func newDirAttr(path string) *internal.ObjAttr {
+	attr := &internal.ObjAttr{
+		Path:  path,
+		Name:  filepath.Base(path),
+		Size:  4096,
+		Mode:  os.ModeDir,
+		Mtime: time.Now(),
+		Flags: internal.NewDirBitMap(),
+	}
+	attr.Atime = attr.Mtime
+	attr.Crtime = attr.Mtime
+	attr.Ctime = attr.Mtime
+	attr.Flags.Set(internal.PropFlagModeDefault)
+	return attr
+}
+
 // track the progress of download of blobs where every 100MB of data downloaded is being tracked. It also tracks the completion of download
 func trackDownload(name string, bytesTransferred int64, count int64, downloadPtr *int64) {
 	if bytesTransferred >= (*downloadPtr)*100*common.MbToBytes || bytesTransferred == count {
@@ -665,7 +735,7 @@ func trackDownload(name string, bytesTransferred int64, count int64, downloadPtr
 }
 
 // ReadToFile : Download a blob to a local file
-func (bb *BlockBlob) ReadToFile(name string, offset int64, count int64, fi *os.File) (err error) {
+func (bb *BlockBlob) ReadToFile(name string, offset int64, count int64, fi *os.File, etag *string) (err error) {
 	log.Trace("BlockBlob::ReadToFile : name %s, offset : %d, count %d", name, offset, count)
 	//defer exectime.StatTimeCurrentBlock("BlockBlob::ReadToFile")()
 
@@ -686,6 +756,7 @@ func (bb *BlockBlob) ReadToFile(name string, offset int64, count int64, fi *os.F
 		Offset: offset,
 		Count:  count,
 	}
+	dlOpts.AccessConditions = getReadConditions(etag)
 
 	_, err = blobClient.DownloadFile(context.Background(), fi, &dlOpts)
 
@@ -693,6 +764,12 @@ func (bb *BlockBlob) ReadToFile(name string, offset int64, count int64, fi *os.F
 		e := storeBlobErrToErr(err)
 		if e == ErrFileNotFound {
 			return syscall.ENOENT
+		} else if e == ErrConditionNotMet {
+			log.Warn("BlockBlob::ReadToFile : %s was modified since its ETag was read [%s]", name, err.Error())
+			return syscall.ESTALE
+		} else if e == ErrBlobArchived {
+			log.Err("BlockBlob::ReadToFile : Blob %s is archived [%s]", name, err.Error())
+			return syscall.ENODATA
 		} else {
 			log.Err("BlockBlob::ReadToFile : Failed to download blob %s [%s]", name, err.Error())
 			return err
@@ -763,6 +840,9 @@ func (bb *BlockBlob) ReadBuffer(name string, offset int64, len int64) ([]byte, e
 			return buff, syscall.ENOENT
 		} else if e == InvalidRange {
 			return buff, syscall.ERANGE
+		} else if e == ErrBlobArchived {
+			log.Err("BlockBlob::ReadBuffer : Blob %s is archived [%s]", name, err.Error())
+			return buff, syscall.ENODATA
 		}
 
 		log.Err("BlockBlob::ReadBuffer : Failed to download blob %s [%s]", name, err.Error())
@@ -772,8 +852,9 @@ func (bb *BlockBlob) ReadBuffer(name string, offset int64, len int64) ([]byte, e
 	return buff, nil
 }
 
-// ReadInBuffer : Download specific range from a file to a user provided buffer
-func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []byte) error {
+// ReadInBuffer : Download specific range from a file to a user provided buffer, only if the blob still has the
+// given etag when it is set
+func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []byte, etag *string) error {
 	// log.Trace("BlockBlob::ReadInBuffer : name %s", name)
 	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
 	opt := (blob.DownloadBufferOptions)(*bb.downloadOptions)
@@ -782,6 +863,7 @@ func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []b
 		Offset: offset,
 		Count:  len,
 	}
+	opt.AccessConditions = getReadConditions(etag)
 
 	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
 	defer cancel()
@@ -789,20 +871,80 @@ func (bb *BlockBlob) ReadInBuffer(name string, offset int64, len int64, data []b
 	_, err := blobClient.DownloadBuffer(ctx, data, &opt)
 
 	if err != nil {
-		e := storeBlobErrToErr(err)
-		if e == ErrFileNotFound {
-			return syscall.ENOENT
-		} else if e == InvalidRange {
-			return syscall.ERANGE
-		}
-
-		log.Err("BlockBlob::ReadInBuffer : Failed to download blob %s [%s]", name, err.Error())
-		return err
+		return readInBufferErr("BlockBlob::ReadInBuffer", name, err)
 	}
 
 	return nil
 }
 
+// ReadInBufferWithMD5 : Download specific range from a file to a user provided buffer, along with MD5 of every
+// MaxChecksumRange bytes of it as computed by storage. Storage returns MD5 only for ranges up to that size,
+// so each of them is downloaded in a request of its own. Blob has to have the given etag if it is set.
+func (bb *BlockBlob) ReadInBufferWithMD5(name string, offset int64, len int64, data []byte, etag *string) ([][]byte, error) {
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+
+	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
+	defer cancel()
+
+	count := (len + common.MaxChecksumRange - 1) / common.MaxChecksumRange
+	sums := make([][]byte, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := int64(0); i < count; i++ {
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+
+			start := i * common.MaxChecksumRange
+			end := min(start+common.MaxChecksumRange, len)
+
+			resp, err := blobClient.DownloadStream(ctx, &blob.DownloadStreamOptions{
+				Range:              blob.HTTPRange{Offset: offset + start, Count: end - start},
+				RangeGetContentMD5: to.Ptr(true),
+				CPKInfo:            bb.blobCPKOpt,
+				AccessConditions:   getReadConditions(etag),
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer resp.Body.Close()
+
+			_, errs[i] = io.ReadFull(resp.Body, data[start:end])
+			sums[i] = resp.ContentMD5
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, readInBufferErr("BlockBlob::ReadInBufferWithMD5", name, err)
+		}
+	}
+
+	return sums, nil
+}
+
+// readInBufferErr : Convert error of a range download to the error to be returned to the caller
+func readInBufferErr(caller string, name string, err error) error {
+	e := storeBlobErrToErr(err)
+	if e == ErrFileNotFound {
+		return syscall.ENOENT
+	} else if e == InvalidRange {
+		return syscall.ERANGE
+	} else if e == ErrBlobArchived {
+		log.Err("%s : Blob %s is archived [%s]", caller, name, err.Error())
+		return syscall.ENODATA
+	} else if e == ErrConditionNotMet {
+		log.Warn("%s : Blob %s was modified by someone else [%s]", caller, name, err.Error())
+		return syscall.ESTALE
+	}
+
+	log.Err("%s : Failed to download blob %s [%s]", caller, name, err.Error())
+	return err
+}
+
 func (bb *BlockBlob) calculateBlockSize(name string, fileSize int64) (blockSize int64, err error) {
 	// If bufferSize > (BlockBlobMaxStageBlockBytes * BlockBlobMaxBlocks), then error
 	if fileSize > MaxBlobSize {
@@ -853,8 +995,36 @@ func trackUpload(name string, bytesTransferred int64, count int64, uploadPtr *in
 	}
 }
 
+// retainedMetadata : Metadata to upload or commit a blob with, and the ETag to condition the upload on. Both replace the
+// whole metadata of the blob, so if the caller does not have it the metadata of the blob is fetched to keep user attributes,
+// owner, group and mode, and the upload fails if it can not be fetched. Unless the caller knows the ETag of the blob already,
+// the upload is conditioned on the one metadata was fetched with so that a change of metadata meanwhile is not lost.
+// Times are dropped as the blob gets new ones on upload.
+func (bb *BlockBlob) retainedMetadata(name string, metadata map[string]*string, etag *string) (map[string]*string, *string, error) {
+	if metadata == nil {
+		attr, err := bb.GetAttr(name)
+		if err == nil {
+			metadata = attr.Metadata
+			if etag == nil || *etag == "" {
+				etag = to.Ptr(attr.ETag)
+			}
+		} else if err != syscall.ENOENT {
+			log.Err("BlockBlob::retainedMetadata : Failed to get metadata of %s [%s]", name, err.Error())
+			return nil, nil, err
+		}
+	}
+
+	metadata = removeTimeMetadata(metadata)
+	err := checkMetadataSize(name, metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return metadata, etag, nil
+}
+
 // WriteFromFile : Upload local file to blob
-func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi *os.File) (err error) {
+func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi *os.File, etag *string) (err error) {
 	log.Trace("BlockBlob::WriteFromFile : name %s", name)
 	//defer exectime.StatTimeCurrentBlock("WriteFromFile::WriteFromFile")()
 
@@ -893,6 +1063,11 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 		}
 	}
 
+	metadata, condition, err := bb.retainedMetadata(name, metadata, etag)
+	if err != nil {
+		return err
+	}
+
 	uploadOptions := &blockblob.UploadFileOptions{
 		BlockSize:   blockSize,
 		Concurrency: bb.Config.maxConcurrency,
@@ -902,7 +1077,8 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 			BlobContentType: to.Ptr(getContentType(name)),
 			BlobContentMD5:  md5sum,
 		},
-		CPKInfo: bb.blobCPKOpt,
+		CPKInfo:          bb.blobCPKOpt,
+		AccessConditions: bb.getAccessConditions(name, condition),
 	}
 	if common.MonitorBfs() && stat.Size() > 0 {
 		uploadOptions.Progress = func(bytesTransferred int64) {
@@ -910,13 +1086,16 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 		}
 	}
 
-	_, err = blobClient.UploadFile(context.Background(), fi, uploadOptions)
+	resp, err := blobClient.UploadFile(context.Background(), fi, uploadOptions)
 
 	if err != nil {
 		serr := storeBlobErrToErr(err)
 		if serr == BlobIsUnderLease {
 			log.Err("BlockBlob::WriteFromFile : %s is under a lease, can not update file [%s]", name, err.Error())
-			return syscall.EIO
+			return syscall.EBUSY
+		} else if serr == ErrConditionNotMet {
+			log.Warn("BlockBlob::WriteFromFile : %s was modified by someone else, can not update file [%s]", name, err.Error())
+			return syscall.ESTALE
 		} else if serr == InvalidPermission {
 			log.Err("BlockBlob::WriteFromFile : Insufficient permissions for %s [%s]", name, err.Error())
 			return syscall.EACCES
@@ -926,6 +1105,9 @@ func (bb *BlockBlob) WriteFromFile(name string, metadata map[string]*string, fi
 		return err
 	} else {
 		log.Debug("BlockBlob::WriteFromFile : Upload complete of blob %v", name)
+		if etag != nil {
+			*etag = etagToString(resp.ETag)
+		}
 
 		// store total bytes uploaded so far
 		if stat.Size() > 0 {
@@ -943,7 +1125,12 @@ func (bb *BlockBlob) WriteFromBuffer(name string, metadata map[string]*string, d
 
 	defer log.TimeTrack(time.Now(), "BlockBlob::WriteFromBuffer", name)
 
-	_, err := blobClient.UploadBuffer(context.Background(), data, &blockblob.UploadBufferOptions{
+	metadata, condition, err := bb.retainedMetadata(name, metadata, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = blobClient.UploadBuffer(context.Background(), data, &blockblob.UploadBufferOptions{
 		BlockSize:   bb.Config.blockSize,
 		Concurrency: bb.Config.maxConcurrency,
 		Metadata:    metadata,
@@ -951,10 +1138,15 @@ func (bb *BlockBlob) WriteFromBuffer(name string, metadata map[string]*string, d
 		HTTPHeaders: &blob.HTTPHeaders{
 			BlobContentType: to.Ptr(getContentType(name)),
 		},
-		CPKInfo: bb.blobCPKOpt,
+		CPKInfo:          bb.blobCPKOpt,
+		AccessConditions: bb.getAccessConditions(name, condition),
 	})
 
 	if err != nil {
+		if storeBlobErrToErr(err) == ErrConditionNotMet {
+			log.Warn("BlockBlob::WriteFromBuffer : %s was modified by someone else, can not update file [%s]", name, err.Error())
+			return syscall.ESTALE
+		}
 		log.Err("BlockBlob::WriteFromBuffer : Failed to upload blob %s [%s]", name, err.Error())
 		return err
 	}
@@ -974,6 +1166,7 @@ func (bb *BlockBlob) GetFileBlockOffsets(name string) (*common.BlockOffsetList,
 		log.Err("BlockBlob::GetFileBlockOffsets : Failed to get block list %s ", name, err.Error())
 		return &common.BlockOffsetList{}, err
 	}
+	blockList.ETag = etagToString(storageBlockList.ETag)
 
 	// if block list empty its a small file
 	if len(storageBlockList.CommittedBlocks) == 0 {
@@ -990,7 +1183,6 @@ func (bb *BlockBlob) GetFileBlockOffsets(name string) (*common.BlockOffsetList,
 		blockOffset += *block.Size
 		blockList.BlockList = append(blockList.BlockList, blk)
 	}
-	// blockList.Etag = storageBlockList.ETag()
 	blockList.BlockIdLength = common.GetIdLength(blockList.BlockList[0].Id)
 	return &blockList, nil
 }
@@ -1050,7 +1242,7 @@ func (bb *BlockBlob) removeBlocks(blockList *common.BlockOffsetList, size int64,
 		blk.Data = make([]byte, blk.EndIndex-blk.StartIndex)
 		blk.Flags.Set(common.DirtyBlock)
 
-		err := bb.ReadInBuffer(name, blk.StartIndex, blk.EndIndex-blk.StartIndex, blk.Data)
+		err := bb.ReadInBuffer(name, blk.StartIndex, blk.EndIndex-blk.StartIndex, blk.Data, nil)
 		if err != nil {
 			log.Err("BlockBlob::removeBlocks : Failed to remove blocks %s [%s]", name, err.Error())
 		}
@@ -1095,7 +1287,8 @@ func (bb *BlockBlob) TruncateFile(name string, size int64) error {
 						id,
 						streaming.NopCloser(bytes.NewReader(data)),
 						&blockblob.StageBlockOptions{
-							CPKInfo: bb.blobCPKOpt,
+							CPKInfo:               bb.blobCPKOpt,
+							LeaseAccessConditions: bb.getLeaseConditions(name),
 						})
 					if err != nil {
 						log.Err("BlockBlob::TruncateFile : Failed to stage block for %s [%s]", name, err.Error())
@@ -1106,7 +1299,7 @@ func (bb *BlockBlob) TruncateFile(name string, size int64) error {
 				size -= blkSize
 			}
 
-			err = bb.CommitBlocks(blobName, blkList)
+			err = bb.CommitBlocks(blobName, blkList, nil)
 			if err != nil {
 				log.Err("BlockBlob::TruncateFile : Failed to commit blocks for %s [%s]", name, err.Error())
 				return err
@@ -1174,12 +1367,12 @@ func (bb *BlockBlob) HandleSmallFile(name string, size int64, originalSize int64
 	var data = make([]byte, size)
 	var err error
 	if size > originalSize {
-		err = bb.ReadInBuffer(name, 0, 0, data)
+		err = bb.ReadInBuffer(name, 0, 0, data, nil)
 		if err != nil {
 			log.Err("BlockBlob::TruncateFile : Failed to read small file %s", name, err.Error())
 		}
 	} else {
-		err = bb.ReadInBuffer(name, 0, size, data)
+		err = bb.ReadInBuffer(name, 0, size, data, nil)
 		if err != nil {
 			log.Err("BlockBlob::TruncateFile : Failed to read small file %s", name, err.Error())
 		}
@@ -1254,7 +1447,7 @@ func (bb *BlockBlob) Write(options internal.WriteFileOptions) error {
 		oldDataBuffer := make([]byte, oldDataSize+newBufferSize)
 		if !appendOnly {
 			// fetch the blocks that will be impacted by the new changes so we can overwrite them
-			err = bb.ReadInBuffer(name, fileOffsets.BlockList[index].StartIndex, oldDataSize, oldDataBuffer)
+			err = bb.ReadInBuffer(name, fileOffsets.BlockList[index].StartIndex, oldDataSize, oldDataBuffer, nil)
 			if err != nil {
 				log.Err("BlockBlob::Write : Failed to read data in buffer %s [%s]", name, err.Error())
 			}
@@ -1280,7 +1473,8 @@ func (bb *BlockBlob) stageAndCommitModifiedBlocks(name string, data []byte, offs
 				blk.Id,
 				streaming.NopCloser(bytes.NewReader(data[blockOffset:(blk.EndIndex-blk.StartIndex)+blockOffset])),
 				&blockblob.StageBlockOptions{
-					CPKInfo: bb.blobCPKOpt,
+					CPKInfo:               bb.blobCPKOpt,
+					LeaseAccessConditions: bb.getLeaseConditions(name),
 				})
 
 			if err != nil {
@@ -1290,17 +1484,28 @@ func (bb *BlockBlob) stageAndCommitModifiedBlocks(name string, data []byte, offs
 			blockOffset = (blk.EndIndex - blk.StartIndex) + blockOffset
 		}
 	}
-	_, err := blobClient.CommitBlockList(context.Background(),
+	metadata, condition, err := bb.retainedMetadata(name, nil, &offsetList.ETag)
+	if err != nil {
+		return err
+	}
+
+	_, err = blobClient.CommitBlockList(context.Background(),
 		blockIDList,
 		&blockblob.CommitBlockListOptions{
 			HTTPHeaders: &blob.HTTPHeaders{
 				BlobContentType: to.Ptr(getContentType(name)),
 			},
-			Tier:    bb.Config.defaultTier,
-			CPKInfo: bb.blobCPKOpt,
+			Metadata:         metadata,
+			Tier:             bb.Config.defaultTier,
+			CPKInfo:          bb.blobCPKOpt,
+			AccessConditions: bb.getAccessConditions(name, condition),
 		})
 
 	if err != nil {
+		if storeBlobErrToErr(err) == ErrConditionNotMet {
+			log.Warn("BlockBlob::stageAndCommitModifiedBlocks : %s was modified by someone else, can not commit [%s]", name, err.Error())
+			return syscall.ESTALE
+		}
 		log.Err("BlockBlob::stageAndCommitModifiedBlocks : Failed to commit block list to blob %s [%s]", name, err.Error())
 		return err
 	}
@@ -1329,7 +1534,8 @@ func (bb *BlockBlob) StageAndCommit(name string, bol *common.BlockOffsetList) er
 				blk.Id,
 				streaming.NopCloser(bytes.NewReader(data)),
 				&blockblob.StageBlockOptions{
-					CPKInfo: bb.blobCPKOpt,
+					CPKInfo:               bb.blobCPKOpt,
+					LeaseAccessConditions: bb.getLeaseConditions(name),
 				})
 			if err != nil {
 				log.Err("BlockBlob::StageAndCommit : Failed to stage to blob %s with ID %s at block %v [%s]", name, blk.Id, blk.StartIndex, err.Error())
@@ -1342,30 +1548,46 @@ func (bb *BlockBlob) StageAndCommit(name string, bol *common.BlockOffsetList) er
 		}
 	}
 	if staged {
-		_, err := blobClient.CommitBlockList(context.Background(),
+		metadata, condition, err := bb.retainedMetadata(name, nil, &bol.ETag)
+		if err != nil {
+			return err
+		}
+
+		resp, err := blobClient.CommitBlockList(context.Background(),
 			blockIDList,
 			&blockblob.CommitBlockListOptions{
 				HTTPHeaders: &blob.HTTPHeaders{
 					BlobContentType: to.Ptr(getContentType(name)),
 				},
-				Tier:    bb.Config.defaultTier,
-				CPKInfo: bb.blobCPKOpt,
-				// AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: bol.Etag}},
+				Metadata:         metadata,
+				Tier:             bb.Config.defaultTier,
+				CPKInfo:          bb.blobCPKOpt,
+				AccessConditions: bb.getAccessConditions(name, condition),
 			})
 		if err != nil {
+			if storeBlobErrToErr(err) == ErrConditionNotMet {
+				log.Warn("BlockBlob::StageAndCommit : %s was modified by someone else, can not commit [%s]", name, err.Error())
+				return syscall.ESTALE
+			}
 			log.Err("BlockBlob::StageAndCommit : Failed to commit block list to blob %s [%s]", name, err.Error())
 			return err
 		}
 		// update the etag
-		// bol.Etag = resp.ETag()
+		bol.ETag = etagToString(resp.ETag)
 	}
 	return nil
 }
 
 // ChangeMod : Change mode of a blob
-func (bb *BlockBlob) ChangeMod(name string, _ os.FileMode) error {
+func (bb *BlockBlob) ChangeMod(name string, mode os.FileMode, etag *string) error {
 	log.Trace("BlockBlob::ChangeMod : name %s", name)
 
+	if bb.Config.posixMetadata {
+		return bb.setPosixMetadata(name, map[string]string{
+			modeKey: strconv.FormatUint(uint64(mode&posixModeMask), 8),
+		}, etag)
+	}
+
 	if bb.Config.ignoreAccessModifiers {
 		// for operations like git clone where transaction fails if chmod is not successful
 		// return success instead of ENOSYS
@@ -1377,9 +1599,21 @@ func (bb *BlockBlob) ChangeMod(name string, _ os.FileMode) error {
 }
 
 // ChangeOwner : Change owner of a blob
-func (bb *BlockBlob) ChangeOwner(name string, _ int, _ int) error {
+func (bb *BlockBlob) ChangeOwner(name string, owner int, group int, etag *string) error {
 	log.Trace("BlockBlob::ChangeOwner : name %s", name)
 
+	if bb.Config.posixMetadata {
+		if owner < 0 || group < 0 {
+			log.Err("BlockBlob::ChangeOwner : Invalid owner %d or group %d for %s", owner, group, name)
+			return syscall.EINVAL
+		}
+
+		return bb.setPosixMetadata(name, map[string]string{
+			uidKey: strconv.Itoa(owner),
+			gidKey: strconv.Itoa(group),
+		}, etag)
+	}
+
 	if bb.Config.ignoreAccessModifiers {
 		// for operations like git clone where transaction fails if chown is not successful
 		// return success instead of ENOSYS
@@ -1390,6 +1624,292 @@ func (bb *BlockBlob) ChangeOwner(name string, _ int, _ int) error {
 	return syscall.ENOTSUP
 }
 
+// parsePosixMetadata : Populate mode and owner of the blob from metadata if posix metadata is enabled,
+// otherwise mark the mode as default so that the fuse layer can return the default permission
+func (bb *BlockBlob) parsePosixMetadata(attr *internal.ObjAttr) {
+	if bb.Config.posixMetadata && parsePosixMetadata(attr) {
+		return
+	}
+
+	attr.Flags.Set(internal.PropFlagModeDefault)
+}
+
+// setPosixMetadata : Persist the given posix attributes in metadata of the blob, keeping the rest of the metadata as is
+func (bb *BlockBlob) setPosixMetadata(name string, values map[string]string, etag *string) error {
+	attr, err := bb.GetAttr(name)
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]*string)
+	for k, v := range attr.Metadata {
+		metadata[k] = v
+	}
+
+	for key, value := range values {
+		if k, found := getMetadataKey(metadata, key); found {
+			delete(metadata, k)
+		}
+		metadata[key] = to.Ptr(value)
+	}
+
+	// Updating metadata changes last modified time of the blob, persist the current one to keep it intact
+	if _, found := getMetadataKey(metadata, mtimeKey); !found {
+		setTimeMetadata(metadata, mtimeKey, attr.Mtime)
+	}
+
+	newETag, err := bb.SetMetadata(name, metadata)
+	if err == syscall.ENOENT && attr.IsDir() {
+		// Directory has no marker blob, so there is nothing to store its attributes on
+		log.Info("BlockBlob::setPosixMetadata : %s has no marker blob, attributes not persisted", name)
+		return nil
+	} else if err == nil {
+		refreshETag(etag, attr.ETag, newETag)
+	}
+
+	return err
+}
+
+// SetMetadata : Replace user defined metadata of a blob, returns the new ETag of the blob
+func (bb *BlockBlob) SetMetadata(name string, metadata map[string]*string) (string, error) {
+	log.Trace("BlockBlob::SetMetadata : name %s", name)
+
+	err := checkMetadataSize(name, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	resp, err := blobClient.SetMetadata(context.Background(), metadata, &blob.SetMetadataOptions{
+		CPKInfo:          bb.blobCPKOpt,
+		AccessConditions: bb.getAccessConditions(name, nil),
+	})
+
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::SetMetadata : %s does not exist", name)
+			return "", syscall.ENOENT
+		} else if serr == BlobIsUnderLease {
+			log.Err("BlockBlob::SetMetadata : %s is under lease [%s]", name, err.Error())
+			return "", syscall.EBUSY
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::SetMetadata : Insufficient permissions for %s [%s]", name, err.Error())
+			return "", syscall.EACCES
+		} else {
+			log.Err("BlockBlob::SetMetadata : Failed to set metadata of %s [%s]", name, err.Error())
+			return "", err
+		}
+	}
+
+	return etagToString(resp.ETag), nil
+}
+
+// GetTier : Get access tier of the blob and status of its rehydration if it is archived
+func (bb *BlockBlob) GetTier(name string) (string, string, error) {
+	log.Trace("BlockBlob::GetTier : name %s", name)
+
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	prop, err := blobClient.GetProperties(context.Background(), &blob.GetPropertiesOptions{
+		CPKInfo: bb.blobCPKOpt,
+	})
+
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			return "", "", syscall.ENOENT
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::GetTier : Insufficient permissions for %s [%s]", name, err.Error())
+			return "", "", syscall.EACCES
+		} else {
+			log.Err("BlockBlob::GetTier : Failed to get properties of %s [%s]", name, err.Error())
+			return "", "", err
+		}
+	}
+
+	tier, archiveStatus := "", ""
+	if prop.AccessTier != nil {
+		tier = *prop.AccessTier
+	}
+	if prop.ArchiveStatus != nil {
+		archiveStatus = *prop.ArchiveStatus
+	}
+
+	return tier, archiveStatus, nil
+}
+
+// SetTier : Change access tier of the blob, moving an archived blob to an online tier starts its rehydration
+func (bb *BlockBlob) SetTier(name string, tier *blob.AccessTier) error {
+	log.Trace("BlockBlob::SetTier : name %s, tier %s", name, *tier)
+
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	_, err := blobClient.SetTier(context.Background(), *tier, &blob.SetTierOptions{
+		RehydratePriority: bb.Config.rehydratePriority,
+	})
+
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::SetTier : %s does not exist", name)
+			return syscall.ENOENT
+		} else if serr == ErrBlobArchived {
+			log.Err("BlockBlob::SetTier : %s is being rehydrated [%s]", name, err.Error())
+			return syscall.EAGAIN
+		} else if serr == BlobIsUnderLease {
+			log.Err("BlockBlob::SetTier : %s is under lease [%s]", name, err.Error())
+			return syscall.EBUSY
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::SetTier : Insufficient permissions for %s [%s]", name, err.Error())
+			return syscall.EACCES
+		} else {
+			log.Err("BlockBlob::SetTier : Failed to set tier of %s [%s]", name, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AcquireLease : Take a lease on the blob so that no one else can modify or delete it till the lease is released
+func (bb *BlockBlob) AcquireLease(name string, duration int32) error {
+	log.Trace("BlockBlob::AcquireLease : name %s, duration %d", name, duration)
+
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	leaseClient, err := lease.NewBlobClient(blobClient, nil)
+	if err != nil {
+		log.Err("BlockBlob::AcquireLease : Failed to create lease client for %s [%s]", name, err.Error())
+		return err
+	}
+
+	resp, err := leaseClient.AcquireLease(context.Background(), duration, nil)
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::AcquireLease : %s does not exist", name)
+			return syscall.ENOENT
+		} else if serr == ErrLeaseConflict {
+			log.Warn("BlockBlob::AcquireLease : %s is leased by someone else [%s]", name, err.Error())
+			return syscall.EBUSY
+		} else if serr == InvalidPermission {
+			log.Err("BlockBlob::AcquireLease : Insufficient permissions for %s [%s]", name, err.Error())
+			return syscall.EACCES
+		}
+		log.Err("BlockBlob::AcquireLease : Failed to acquire lease on %s [%s]", name, err.Error())
+		return err
+	}
+
+	bb.leases.Store(name, *resp.LeaseID)
+	return nil
+}
+
+// RenewLease : Extend the lease held on the blob by this mount
+func (bb *BlockBlob) RenewLease(name string) error {
+	log.Trace("BlockBlob::RenewLease : name %s", name)
+
+	leaseClient, err := bb.getLeaseClient(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = leaseClient.RenewLease(context.Background(), nil)
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound {
+			log.Err("BlockBlob::RenewLease : %s does not exist", name)
+			bb.leases.Delete(name)
+			return syscall.ENOENT
+		} else if serr == ErrLeaseConflict {
+			// Lease expired and someone else has taken it or modified the blob meanwhile
+			log.Err("BlockBlob::RenewLease : Lease on %s is lost [%s]", name, err.Error())
+			bb.leases.Delete(name)
+			return syscall.EBUSY
+		}
+		log.Err("BlockBlob::RenewLease : Failed to renew lease on %s [%s]", name, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseLease : Release the lease held on the blob by this mount so that others can write to it
+func (bb *BlockBlob) ReleaseLease(name string) error {
+	log.Trace("BlockBlob::ReleaseLease : name %s", name)
+
+	leaseClient, err := bb.getLeaseClient(name)
+	if err != nil {
+		// Nothing to release
+		return nil
+	}
+	bb.leases.Delete(name)
+
+	_, err = leaseClient.ReleaseLease(context.Background(), nil)
+	if err != nil {
+		serr := storeBlobErrToErr(err)
+		if serr == ErrFileNotFound || serr == ErrLeaseConflict {
+			// Blob is deleted or the lease has already expired, either way this mount does not hold it anymore
+			log.Info("BlockBlob::ReleaseLease : Lease on %s is already gone [%s]", name, err.Error())
+			return nil
+		}
+		log.Err("BlockBlob::ReleaseLease : Failed to release lease on %s [%s]", name, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// getLeaseClient : Lease client for the lease held on the blob by this mount
+func (bb *BlockBlob) getLeaseClient(name string) (*lease.BlobClient, error) {
+	id, found := bb.leases.Load(name)
+	if !found {
+		log.Err("BlockBlob::getLeaseClient : No lease held on %s", name)
+		return nil, syscall.ENOLCK
+	}
+
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	return lease.NewBlobClient(blobClient, &lease.BlobClientOptions{LeaseID: to.Ptr(id.(string))})
+}
+
+// getAccessConditions : Conditions for a write on the blob. If this mount holds a lease on the blob its id is sent,
+// and if optimistic concurrency is enabled the write succeeds only if the blob has not changed since the given ETag was seen.
+// Returns nil when there is no condition to apply, e.g. for a new file.
+func (bb *BlockBlob) getAccessConditions(name string, etag *string) *blob.AccessConditions {
+	leaseConditions := bb.getLeaseConditions(name)
+	if leaseConditions == nil && (!bb.Config.optimisticConcurrency || etag == nil || *etag == "") {
+		return nil
+	}
+
+	conditions := &blob.AccessConditions{LeaseAccessConditions: leaseConditions}
+	if bb.Config.optimisticConcurrency && etag != nil && *etag != "" {
+		conditions.ModifiedAccessConditions = &blob.ModifiedAccessConditions{
+			IfMatch: to.Ptr(azcore.ETag(*etag)),
+		}
+	}
+
+	return conditions
+}
+
+// getReadConditions : Conditions for a read of the blob, it succeeds only if the blob still has the given ETag.
+// Unlike writes this does not depend on optimistic concurrency, as the caller pins the version it reads.
+func getReadConditions(etag *string) *blob.AccessConditions {
+	if etag == nil || *etag == "" {
+		return nil
+	}
+
+	return &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: to.Ptr(azcore.ETag(*etag))},
+	}
+}
+
+// getLeaseConditions : Lease id to send with writes on the blob, nil if this mount does not hold a lease on it
+func (bb *BlockBlob) getLeaseConditions(name string) *blob.LeaseAccessConditions {
+	id, found := bb.leases.Load(name)
+	if !found {
+		return nil
+	}
+
+	return &blob.LeaseAccessConditions{LeaseID: to.Ptr(id.(string))}
+}
+
 // GetCommittedBlockList : Get the list of committed blocks
 func (bb *BlockBlob) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
 	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
@@ -1422,9 +1942,15 @@ func (bb *BlockBlob) GetCommittedBlockList(name string) (*internal.CommittedBloc
 }
 
 // StageBlock : stages a block and returns its blockid
-func (bb *BlockBlob) StageBlock(name string, data []byte, id string) error {
+func (bb *BlockBlob) StageBlock(name string, data []byte, id string, md5 []byte) error {
 	log.Trace("BlockBlob::StageBlock : name %s, ID %v, length %v", name, id, len(data))
 
+	var validation blob.TransferValidationType
+	if md5 != nil {
+		// Storage verifies the block it received against this and fails the request on mismatch
+		validation = blob.TransferValidationTypeMD5(md5)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
 	defer cancel()
 
@@ -1433,7 +1959,9 @@ func (bb *BlockBlob) StageBlock(name string, data []byte, id string) error {
 		id,
 		streaming.NopCloser(bytes.NewReader(data)),
 		&blockblob.StageBlockOptions{
-			CPKInfo: bb.blobCPKOpt,
+			CPKInfo:                 bb.blobCPKOpt,
+			LeaseAccessConditions:   bb.getLeaseConditions(name),
+			TransactionalValidation: validation,
 		})
 
 	if err != nil {
@@ -1445,27 +1973,293 @@ func (bb *BlockBlob) StageBlock(name string, data []byte, id string) error {
 }
 
 // CommitBlocks : persists the block list
-func (bb *BlockBlob) CommitBlocks(name string, blockList []string) error {
+func (bb *BlockBlob) CommitBlocks(name string, blockList []string, etag *string) error {
 	log.Trace("BlockBlob::CommitBlocks : name %s", name)
 
 	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
 	defer cancel()
 
+	metadata, condition, err := bb.retainedMetadata(name, nil, etag)
+	if err != nil {
+		return err
+	}
+
 	blobClient := bb.Container.NewBlockBlobClient(filepath.Join(bb.Config.prefixPath, name))
-	_, err := blobClient.CommitBlockList(ctx,
+	resp, err := blobClient.CommitBlockList(ctx,
 		blockList,
 		&blockblob.CommitBlockListOptions{
 			HTTPHeaders: &blob.HTTPHeaders{
 				BlobContentType: to.Ptr(getContentType(name)),
 			},
-			Tier:    bb.Config.defaultTier,
-			CPKInfo: bb.blobCPKOpt,
+			Metadata:         metadata,
+			Tier:             bb.Config.defaultTier,
+			CPKInfo:          bb.blobCPKOpt,
+			AccessConditions: bb.getAccessConditions(name, condition),
 		})
 
 	if err != nil {
+		if storeBlobErrToErr(err) == ErrConditionNotMet {
+			log.Warn("BlockBlob::CommitBlocks : %s was modified by someone else, can not commit [%s]", name, err.Error())
+			return syscall.ESTALE
+		}
 		log.Err("BlockBlob::CommitBlocks : Failed to commit block list to blob %s [%s]", name, err.Error())
 		return err
 	}
 
+	if etag != nil {
+		*etag = etagToString(resp.ETag)
+	}
+
+	return nil
+}
+
+// ------------------------- Snapshots and versions -------------------------------------------
+
+// snapshotID : Id of the snapshot or earlier version a listed blob belongs to, empty for the current blob
+func snapshotID(blobInfo *container.BlobItem) string {
+	if blobInfo.Snapshot != nil && *blobInfo.Snapshot != "" {
+		return *blobInfo.Snapshot
+	}
+
+	if blobInfo.VersionID != nil && (blobInfo.IsCurrentVersion == nil || !*blobInfo.IsCurrentVersion) {
+		return *blobInfo.VersionID
+	}
+
+	return ""
+}
+
+// getSnapshotClient : Client to the blob as it was at the given snapshot or version
+func (bb *BlockBlob) getSnapshotClient(name string, id string) (*blob.Client, error) {
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	if _, found := bb.versions.Load(name + "@" + id); found {
+		return blobClient.WithVersionID(id)
+	}
+	return blobClient.WithSnapshot(id)
+}
+
+// ListSnapshotIDs : Get the ids of the newest snapshots and earlier versions of blobs under the given prefix, at most
+// limit of them. Older ids are dropped as the listing goes on, and it stops after maxSnapshotListPages pages.
+func (bb *BlockBlob) ListSnapshotIDs(prefix string, limit int) ([]string, error) {
+	log.Trace("BlockBlob::ListSnapshotIDs : prefix %s", prefix)
+
+	listPath := filepath.Join(bb.Config.prefixPath, prefix)
+	if (prefix != "" && prefix[len(prefix)-1] == '/') || (prefix == "" && bb.Config.prefixPath != "") {
+		listPath += "/"
+	}
+
+	// Snapshots of blobs at any depth are needed so the container is listed flat
+	pager := bb.Container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		MaxResults: to.Ptr(int32(common.MaxDirListCount)),
+		Prefix:     &listPath,
+		Include:    container.ListBlobsInclude{Snapshots: true, Versions: true},
+	})
+
+	found := make(map[string]bool)
+	dropped := false
+	for pages := 0; pager.More(); pages++ {
+		if pages == maxSnapshotListPages {
+			log.Warn("BlockBlob::ListSnapshotIDs : Stopped listing the container with the prefix %s after %d pages, snapshots and versions of the blobs after are not presented", prefix, pages)
+			break
+		}
+
+		listBlob, err := pager.NextPage(context.Background())
+		if err != nil {
+			log.Err("BlockBlob::ListSnapshotIDs : Failed to list the container with the prefix %s [%s]", prefix, err.Error())
+			return nil, err
+		}
+
+		for _, blobInfo := range listBlob.Segment.BlobItems {
+			if id := snapshotID(blobInfo); id != "" {
+				found[id] = true
+			}
+		}
+
+		if len(found) > 2*limit {
+			keepNewestSnapshotIDs(found, limit)
+			dropped = true
+		}
+	}
+
+	if dropped || len(found) > limit {
+		log.Warn("BlockBlob::ListSnapshotIDs : Found more than %d snapshots and versions with the prefix %s, only newest %d are presented", limit, prefix, limit)
+	}
+
+	return keepNewestSnapshotIDs(found, limit), nil
+}
+
+// ListSnapshot : Get a list of blobs matching the given prefix as they were at the given snapshot or version
+// This fetches the list using a marker so the caller code should handle marker logic
+func (bb *BlockBlob) ListSnapshot(prefix string, id string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
+	log.Trace("BlockBlob::ListSnapshot : prefix %s, id %s", prefix, id)
+
+	blobList := make([]*internal.ObjAttr, 0)
+
+	if count == 0 {
+		count = common.MaxDirListCount
+	}
+
+	listPath := filepath.Join(bb.Config.prefixPath, prefix)
+	if (prefix != "" && prefix[len(prefix)-1] == '/') || (prefix == "" && bb.Config.prefixPath != "") {
+		listPath += "/"
+	}
+
+	pager := bb.Container.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+		Marker:     marker,
+		MaxResults: &count,
+		Prefix:     &listPath,
+		Include:    container.ListBlobsInclude{Metadata: true, Snapshots: true, Versions: true},
+	})
+
+	listBlob, err := pager.NextPage(context.Background())
+	if err != nil {
+		log.Err("BlockBlob::ListSnapshot : Failed to list the container with the prefix %s [%s]", prefix, err.Error())
+		return blobList, nil, err
+	}
+
+	for _, blobInfo := range listBlob.Segment.BlobItems {
+		if snapshotID(blobInfo) != id {
+			continue
+		}
+
+		attr := bb.newAttrFromBlobItem(blobInfo)
+		if attr.IsDir() {
+			// Directories are taken from the prefixes below
+			continue
+		}
+
+		if blobInfo.Snapshot == nil || *blobInfo.Snapshot == "" {
+			bb.versions.Store(attr.Path+"@"+id, true)
+		}
+		blobList = append(blobList, attr)
+	}
+
+	// Directory may not have any blob of this snapshot, but listing them all is far cheaper than walking each of them
+	for _, blobInfo := range listBlob.Segment.BlobPrefixes {
+		name := strings.TrimSuffix(*blobInfo.Name, "/")
+		blobList = append(blobList, newDirAttr(split(bb.Config.prefixPath, name)))
+	}
+
+	return blobList, listBlob.NextMarker, nil
+}
+
+// GetSnapshotAttr : Retrieve attributes of the blob as it was at the given snapshot or version
+func (bb *BlockBlob) GetSnapshotAttr(name string, id string) (*internal.ObjAttr, error) {
+	log.Trace("BlockBlob::GetSnapshotAttr : name %s, id %s", name, id)
+
+	blobClient := bb.Container.NewBlobClient(filepath.Join(bb.Config.prefixPath, name))
+	snapshotClient, err := blobClient.WithSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &blob.GetPropertiesOptions{CPKInfo: bb.blobCPKOpt}
+	prop, err := snapshotClient.GetProperties(context.Background(), opts)
+	if storeBlobErrToErr(err) == ErrFileNotFound {
+		// Id may belong to an earlier version of the blob instead
+		versionClient, verr := blobClient.WithVersionID(id)
+		if verr != nil {
+			return nil, verr
+		}
+
+		prop, err = versionClient.GetProperties(context.Background(), opts)
+		if err == nil {
+			bb.versions.Store(name+"@"+id, true)
+		}
+	}
+
+	if err != nil {
+		e := storeBlobErrToErr(err)
+		if e == ErrFileNotFound {
+			return bb.getSnapshotDirAttr(name, id)
+		} else if e == InvalidPermission {
+			log.Err("BlockBlob::GetSnapshotAttr : Insufficient permissions for %s [%s]", name, err.Error())
+			return nil, syscall.EACCES
+		}
+		log.Err("BlockBlob::GetSnapshotAttr : Failed to get properties of %s at %s [%s]", name, id, err.Error())
+		return nil, err
+	}
+
+	return bb.newAttrFromProperties(name, &prop), nil
+}
+
+// getSnapshotDirAttr : Path which is not a blob is a directory if any blob of the snapshot is under it
+func (bb *BlockBlob) getSnapshotDirAttr(name string, id string) (*internal.ObjAttr, error) {
+	var marker *string
+	for {
+		blobs, newMarker, err := bb.ListSnapshot(name+"/", id, marker, bb.Config.maxResultsForList)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(blobs) > 0 {
+			return newDirAttr(name), nil
+		}
+
+		if newMarker == nil || *newMarker == "" {
+			return nil, syscall.ENOENT
+		}
+		marker = newMarker
+	}
+}
+
+// ReadSnapshotToFile : Download the blob as it was at the given snapshot or version to a local file
+func (bb *BlockBlob) ReadSnapshotToFile(name string, id string, offset int64, count int64, fi *os.File) error {
+	log.Trace("BlockBlob::ReadSnapshotToFile : name %s, id %s, offset : %d, count %d", name, id, offset, count)
+
+	blobClient, err := bb.getSnapshotClient(name, id)
+	if err != nil {
+		return err
+	}
+
+	dlOpts := *bb.downloadOptions
+	dlOpts.Progress = nil
+	dlOpts.Range = blob.HTTPRange{
+		Offset: offset,
+		Count:  count,
+	}
+
+	_, err = blobClient.DownloadFile(context.Background(), fi, &dlOpts)
+	if err != nil {
+		if storeBlobErrToErr(err) == ErrFileNotFound {
+			return syscall.ENOENT
+		}
+		log.Err("BlockBlob::ReadSnapshotToFile : Failed to download %s at %s [%s]", name, id, err.Error())
+		return err
+	}
+
+	azStatsCollector.UpdateStats(stats_manager.Increment, bytesDownloaded, count)
+	return nil
+}
+
+// ReadSnapshotInBuffer : Download specific range of the blob as it was at the given snapshot or version to a user provided buffer
+func (bb *BlockBlob) ReadSnapshotInBuffer(name string, id string, offset int64, len int64, data []byte) error {
+	blobClient, err := bb.getSnapshotClient(name, id)
+	if err != nil {
+		return err
+	}
+
+	opt := (blob.DownloadBufferOptions)(*bb.downloadOptions)
+	opt.Progress = nil
+	opt.BlockSize = len
+	opt.Range = blob.HTTPRange{
+		Offset: offset,
+		Count:  len,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), max_context_timeout*time.Minute)
+	defer cancel()
+
+	_, err = blobClient.DownloadBuffer(ctx, data, &opt)
+	if err != nil {
+		e := storeBlobErrToErr(err)
+		if e == ErrFileNotFound {
+			return syscall.ENOENT
+		} else if e == InvalidRange {
+			return syscall.ERANGE
+		}
+		log.Err("BlockBlob::ReadSnapshotInBuffer : Failed to download %s at %s [%s]", name, id, err.Error())
+		return err
+	}
+
 	return nil
 }
@@ -1129,6 +1129,89 @@ func (s *blockBlobTestSuite) TestRenameFileMetadataConservation() {
 	s.assert.True(checkMetadata(props.Metadata, "foo", "bar"))
 }
 
+func (s *blockBlobTestSuite) TestXattrKeptOnUpload() {
+	defer s.cleanupTest()
+	// Setup
+	name := generateFileName()
+	h, _ := s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	err := s.az.SetXattr(internal.SetXattrOptions{Name: name, Attr: "user.test", Value: []byte("value")})
+	s.assert.Nil(err)
+
+	checkXattr := func() {
+		value, err := s.az.GetXattr(internal.GetXattrOptions{Name: name, Attr: "user.test"})
+		s.assert.Nil(err)
+		s.assert.EqualValues("value", value)
+	}
+
+	// Write through stream
+	_, err = s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+	s.assert.Nil(err)
+	checkXattr()
+
+	// Upload from file-cache
+	homeDir, _ := os.UserHomeDir()
+	f, _ := os.CreateTemp(homeDir, name+".tmp")
+	defer os.Remove(f.Name())
+	_, _ = f.Write([]byte("new data"))
+	err = s.az.CopyFromFile(internal.CopyFromFileOptions{Name: name, File: f})
+	s.assert.Nil(err)
+	checkXattr()
+
+	// Commit from block-cache
+	id := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+	err = s.az.StageData(internal.StageDataOptions{Name: name, Id: id, Data: []byte("block data")})
+	s.assert.Nil(err)
+	err = s.az.CommitData(internal.CommitDataOptions{Name: name, List: []string{id}, BlockSize: 1})
+	s.assert.Nil(err)
+	checkXattr()
+
+	// Truncate
+	err = s.az.TruncateFile(internal.TruncateFileOptions{Name: name, Size: 5})
+	s.assert.Nil(err)
+	checkXattr()
+}
+
+func (s *blockBlobTestSuite) TestPosixMetadataOnCreate() {
+	defer s.cleanupTest()
+	// Setup
+	s.az.storage.(*BlockBlob).Config.posixMetadata = true
+	name := generateFileName()
+	h, err := s.az.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0640, Uid: 1001, Gid: 1002, OwnerSet: true})
+	s.assert.Nil(err)
+
+	checkPosix := func() {
+		attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+		s.assert.Nil(err)
+		s.assert.True(attr.IsOwnerSet())
+		s.assert.EqualValues(1001, attr.Uid)
+		s.assert.EqualValues(1002, attr.Gid)
+		s.assert.EqualValues(0640, attr.Mode&posixModeMask)
+	}
+	checkPosix()
+
+	// Write through stream
+	_, err = s.az.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+	s.assert.Nil(err)
+	checkPosix()
+
+	// Upload from file-cache
+	homeDir, _ := os.UserHomeDir()
+	f, _ := os.CreateTemp(homeDir, name+".tmp")
+	defer os.Remove(f.Name())
+	_, _ = f.Write([]byte("new data"))
+	err = s.az.CopyFromFile(internal.CopyFromFileOptions{Name: name, File: f})
+	s.assert.Nil(err)
+	checkPosix()
+
+	// Commit from block-cache
+	id := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+	err = s.az.StageData(internal.StageDataOptions{Name: name, Id: id, Data: []byte("block data")})
+	s.assert.Nil(err)
+	err = s.az.CommitData(internal.CommitDataOptions{Name: name, List: []string{id}, BlockSize: 1})
+	s.assert.Nil(err)
+	checkPosix()
+}
+
 func (s *blockBlobTestSuite) TestRenameFileError() {
 	defer s.cleanupTest()
 	// Setup
@@ -1812,6 +1895,88 @@ func (s *blockBlobTestSuite) TestCopyFromFile() {
 	s.assert.EqualValues(testData, output)
 }
 
+func (s *blockBlobTestSuite) setupOptimisticConcurrency() {
+	config := fmt.Sprintf("azstorage:\n  account-name: %s\n  endpoint: https://%s.blob.core.windows.net/\n  type: block\n  account-key: %s\n  mode: key\n  container: %s\n  fail-unsupported-op: true\n  optimistic-concurrency: true",
+		storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockAccount, storageTestConfigurationParameters.BlockKey, s.container)
+	s.tearDownTestHelper(false)
+	s.setupTestHelper(config, s.container, true)
+}
+
+func (s *blockBlobTestSuite) TestCopyFromFileETag() {
+	defer s.cleanupTest()
+	s.setupOptimisticConcurrency()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.NotEmpty(attr.ETag)
+
+	homeDir, _ := os.UserHomeDir()
+	f, _ := os.CreateTemp(homeDir, name+".tmp")
+	defer os.Remove(f.Name())
+	f.Write([]byte("test data"))
+
+	etag := attr.ETag
+	err = s.az.CopyFromFile(internal.CopyFromFileOptions{Name: name, File: f, ETag: &etag})
+	s.assert.Nil(err)
+	s.assert.NotEqual(attr.ETag, etag)
+
+	// ETag returned by the upload is the current one
+	attr, err = s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+	s.assert.EqualValues(attr.ETag, etag)
+}
+
+func (s *blockBlobTestSuite) TestCopyFromFileETagConflict() {
+	defer s.cleanupTest()
+	s.setupOptimisticConcurrency()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+
+	// Someone else updates the blob
+	_, err = s.containerClient.NewBlockBlobClient(name).UploadBuffer(ctx, []byte("other data"), nil)
+	s.assert.Nil(err)
+
+	homeDir, _ := os.UserHomeDir()
+	f, _ := os.CreateTemp(homeDir, name+".tmp")
+	defer os.Remove(f.Name())
+	f.Write([]byte("test data"))
+
+	etag := attr.ETag
+	err = s.az.CopyFromFile(internal.CopyFromFileOptions{Name: name, File: f, ETag: &etag})
+	s.assert.Equal(syscall.ESTALE, err)
+
+	// Blob should still have the data of the other writer
+	resp, err := s.containerClient.NewBlobClient(name).DownloadStream(ctx, nil)
+	s.assert.Nil(err)
+	output, _ := io.ReadAll(resp.Body)
+	s.assert.EqualValues("other data", output)
+}
+
+func (s *blockBlobTestSuite) TestDeleteFileETagConflict() {
+	defer s.cleanupTest()
+	s.setupOptimisticConcurrency()
+	// Setup
+	name := generateFileName()
+	s.az.CreateFile(internal.CreateFileOptions{Name: name})
+	attr, err := s.az.GetAttr(internal.GetAttrOptions{Name: name})
+	s.assert.Nil(err)
+
+	_, err = s.containerClient.NewBlockBlobClient(name).UploadBuffer(ctx, []byte("other data"), nil)
+	s.assert.Nil(err)
+
+	err = s.az.DeleteFile(internal.DeleteFileOptions{Name: name, ETag: attr.ETag})
+	s.assert.Equal(syscall.ESTALE, err)
+
+	// Without an ETag the delete is unconditional
+	err = s.az.DeleteFile(internal.DeleteFileOptions{Name: name})
+	s.assert.Nil(err)
+}
+
 func (s *blockBlobTestSuite) TestCreateLink() {
 	defer s.cleanupTest()
 	// Setup
@@ -2377,7 +2542,7 @@ func (s *blockBlobTestSuite) TestFlushFileUpdateChunkedFile() {
 	updatedBlock := make([]byte, 2*MB)
 	rand.Read(updatedBlock)
 	h.CacheObj.BlockOffsetList.BlockList[1].Data = make([]byte, blockSize)
-	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize), h.CacheObj.BlockOffsetList.BlockList[1].Data)
+	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize), h.CacheObj.BlockOffsetList.BlockList[1].Data, nil)
 	copy(h.CacheObj.BlockOffsetList.BlockList[1].Data[MB:2*MB+MB], updatedBlock)
 	h.CacheObj.BlockOffsetList.BlockList[1].Flags.Set(common.DirtyBlock)
 
@@ -2414,7 +2579,7 @@ func (s *blockBlobTestSuite) TestFlushFileTruncateUpdateChunkedFile() {
 	// truncate block
 	h.CacheObj.BlockOffsetList.BlockList[1].Data = make([]byte, blockSize/2)
 	h.CacheObj.BlockOffsetList.BlockList[1].EndIndex = int64(blockSize + blockSize/2)
-	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize)/2, h.CacheObj.BlockOffsetList.BlockList[1].Data)
+	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize)/2, h.CacheObj.BlockOffsetList.BlockList[1].Data, nil)
 	h.CacheObj.BlockOffsetList.BlockList[1].Flags.Set(common.DirtyBlock)
 
 	// remove 2 blocks
@@ -2817,7 +2982,7 @@ func (s *blockBlobTestSuite) TestMD5SetOnUpload() {
 			s.assert.EqualValues(n, blockblob.MaxUploadBlobBytes+1)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 
 			prop, err := s.az.storage.GetAttr(name)
@@ -2829,7 +2994,7 @@ func (s *blockBlobTestSuite) TestMD5SetOnUpload() {
 			s.assert.Nil(err)
 			s.assert.EqualValues(localMD5, prop.MD5)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = f.Close()
 			_ = os.Remove(name)
 		})
@@ -2870,14 +3035,14 @@ func (s *blockBlobTestSuite) TestMD5NotSetOnUpload() {
 			s.assert.EqualValues(n, blockblob.MaxUploadBlobBytes+1)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 
 			prop, err := s.az.storage.GetAttr(name)
 			s.assert.Nil(err)
 			s.assert.Empty(prop.MD5)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = f.Close()
 			_ = os.Remove(name)
 		})
@@ -2918,7 +3083,7 @@ func (s *blockBlobTestSuite) TestMD5AutoSetOnUpload() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 
 			prop, err := s.az.storage.GetAttr(name)
@@ -2930,7 +3095,7 @@ func (s *blockBlobTestSuite) TestMD5AutoSetOnUpload() {
 			s.assert.Nil(err)
 			s.assert.EqualValues(localMD5, prop.MD5)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = f.Close()
 			_ = os.Remove(name)
 		})
@@ -2971,7 +3136,7 @@ func (s *blockBlobTestSuite) TestInvalidateMD5PostUpload() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 
 			blobClient := s.containerClient.NewBlobClient(name)
@@ -2986,7 +3151,7 @@ func (s *blockBlobTestSuite) TestInvalidateMD5PostUpload() {
 			s.assert.Nil(err)
 			s.assert.NotEqualValues(localMD5, prop.MD5)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = f.Close()
 			_ = os.Remove(name)
 		})
@@ -3027,7 +3192,7 @@ func (s *blockBlobTestSuite) TestValidateAutoMD5OnRead() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3040,10 +3205,10 @@ func (s *blockBlobTestSuite) TestValidateAutoMD5OnRead() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, 100, f)
+			err = s.az.storage.ReadToFile(name, 0, 100, f, nil)
 			s.assert.Nil(err)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = os.Remove(name)
 		})
 	}
@@ -3083,7 +3248,7 @@ func (s *blockBlobTestSuite) TestValidateManualMD5OnRead() {
 			s.assert.EqualValues(n, blockblob.MaxUploadBlobBytes+1)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3096,10 +3261,10 @@ func (s *blockBlobTestSuite) TestValidateManualMD5OnRead() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, blockblob.MaxUploadBlobBytes+1, f)
+			err = s.az.storage.ReadToFile(name, 0, blockblob.MaxUploadBlobBytes+1, f, nil)
 			s.assert.Nil(err)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = os.Remove(name)
 		})
 	}
@@ -3139,7 +3304,7 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnRead() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3155,11 +3320,11 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnRead() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, 100, f)
+			err = s.az.storage.ReadToFile(name, 0, 100, f, nil)
 			s.assert.NotNil(err)
 			s.assert.Contains(err.Error(), "md5 sum mismatch on download")
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = os.Remove(name)
 		})
 	}
@@ -3199,7 +3364,7 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnReadNoVaildate() {
 			s.assert.EqualValues(n, 100)
 			_, _ = f.Seek(0, 0)
 
-			err = s.az.storage.WriteFromFile(name, nil, f)
+			err = s.az.storage.WriteFromFile(name, nil, f, nil)
 			s.assert.Nil(err)
 			_ = f.Close()
 			_ = os.Remove(name)
@@ -3215,10 +3380,10 @@ func (s *blockBlobTestSuite) TestInvalidMD5OnReadNoVaildate() {
 			s.assert.Nil(err)
 			s.assert.NotNil(f)
 
-			err = s.az.storage.ReadToFile(name, 0, 100, f)
+			err = s.az.storage.ReadToFile(name, 0, 100, f, nil)
 			s.assert.Nil(err)
 
-			_ = s.az.storage.DeleteFile(name)
+			_ = s.az.storage.DeleteFile(name, nil)
 			_ = os.Remove(name)
 		})
 	}
@@ -3252,21 +3417,21 @@ func (s *blockBlobTestSuite) TestDownloadBlobWithCPKEnabled() {
 	s.assert.Nil(err)
 	s.assert.NotNil(f)
 
-	err = s.az.storage.ReadToFile(name, 0, int64(len(data)), f)
+	err = s.az.storage.ReadToFile(name, 0, int64(len(data)), f, nil)
 	s.assert.Nil(err)
 	fileData, err := os.ReadFile(name)
 	s.assert.Nil(err)
 	s.assert.EqualValues(data, fileData)
 
 	buf := make([]byte, len(data))
-	err = s.az.storage.ReadInBuffer(name, 0, int64(len(data)), buf)
+	err = s.az.storage.ReadInBuffer(name, 0, int64(len(data)), buf, nil)
 	s.assert.Nil(err)
 	s.assert.EqualValues(data, buf)
 
 	rbuf, err := s.az.storage.ReadBuffer(name, 0, int64(len(data)))
 	s.assert.Nil(err)
 	s.assert.EqualValues(data, rbuf)
-	_ = s.az.storage.DeleteFile(name)
+	_ = s.az.storage.DeleteFile(name, nil)
 	_ = os.Remove(name)
 }
 
@@ -3296,7 +3461,7 @@ func (s *blockBlobTestSuite) TestUploadBlobWithCPKEnabled() {
 	s.assert.Nil(err)
 	_, _ = f.Seek(0, 0)
 
-	err = s.az.storage.WriteFromFile(name1, nil, f)
+	err = s.az.storage.WriteFromFile(name1, nil, f, nil)
 	s.assert.Nil(err)
 
 	file := s.containerClient.NewBlobClient(name1)
@@ -3335,8 +3500,8 @@ func (s *blockBlobTestSuite) TestUploadBlobWithCPKEnabled() {
 	s.assert.Nil(err)
 	s.assert.NotNil(resp.RequestID)
 
-	_ = s.az.storage.DeleteFile(name1)
-	_ = s.az.storage.DeleteFile(name2)
+	_ = s.az.storage.DeleteFile(name1, nil)
+	_ = s.az.storage.DeleteFile(name2, nil)
 	_ = os.Remove(name1)
 }
 
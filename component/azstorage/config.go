@@ -39,6 +39,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
@@ -185,6 +186,11 @@ type AzStorageOptions struct {
 	CPKEncryptionKey        string `config:"cpk-encryption-key" yaml:"cpk-encryption-key"`
 	CPKEncryptionKeySha256  string `config:"cpk-encryption-key-sha256" yaml:"cpk-encryption-key-sha256"`
 	PreserveACL             bool   `config:"preserve-acl" yaml:"preserve-acl"`
+	PosixMetadata           bool   `config:"posix-metadata" yaml:"posix-metadata"`
+	OptimisticConcurrency   bool   `config:"optimistic-concurrency" yaml:"optimistic-concurrency"`
+	SnapshotBrowsing        bool   `config:"snapshot-browsing" yaml:"snapshot-browsing"`
+	RehydrateTier           string `config:"rehydrate-tier" yaml:"rehydrate-tier,omitempty"`
+	RehydratePriority       string `config:"rehydrate-priority" yaml:"rehydrate-priority,omitempty"`
 
 	// v1 support
 	UseAdls        bool   `config:"use-adls" yaml:"-"`
@@ -501,6 +507,30 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 
 	az.stConfig.preserveACL = opt.PreserveACL
 
+	az.stConfig.posixMetadata = opt.PosixMetadata
+	if az.stConfig.posixMetadata && az.stConfig.authConfig.AccountType == EAccountType.ADLS() {
+		log.Warn("ParseAndValidateConfig : posix-metadata is not applicable for ADLS accounts, permissions are managed through ACLs")
+		az.stConfig.posixMetadata = false
+	}
+
+	az.stConfig.optimisticConcurrency = opt.OptimisticConcurrency
+	az.stConfig.snapshotBrowsing = opt.SnapshotBrowsing
+
+	// Archived blobs can only be rehydrated to an online tier
+	if opt.RehydrateTier != "" {
+		az.stConfig.rehydrateTier = getAccessTierType(opt.RehydrateTier)
+		if az.stConfig.rehydrateTier == nil || *az.stConfig.rehydrateTier == blob.AccessTierArchive {
+			return errors.New("invalid rehydrate tier, must be one of hot, cool or cold")
+		}
+	}
+
+	if opt.RehydratePriority != "" {
+		az.stConfig.rehydratePriority = getRehydratePriorityType(opt.RehydratePriority)
+		if az.stConfig.rehydratePriority == nil {
+			return errors.New("invalid rehydrate priority, must be standard or high")
+		}
+	}
+
 	log.Crit("ParseAndValidateConfig : account %s, container %s, account-type %s, auth %s, prefix %s, endpoint %s, MD5 %v %v, virtual-directory %v, disable-compression %v, CPK %v",
 		az.stConfig.authConfig.AccountName, az.stConfig.container, az.stConfig.authConfig.AccountType, az.stConfig.authConfig.AuthMode,
 		az.stConfig.prefixPath, az.stConfig.authConfig.Endpoint, az.stConfig.validateMD5, az.stConfig.updateMD5, az.stConfig.virtualDirectory, az.stConfig.disableCompression, az.stConfig.cpkEnabled)
@@ -508,7 +538,8 @@ func ParseAndValidateConfig(az *AzStorage, opt AzStorageOptions) error {
 	log.Crit("ParseAndValidateConfig : Retry Config: retry-count %d, max-timeout %d, backoff-time %d, max-delay %d, preserve-acl: %v",
 		az.stConfig.maxRetries, az.stConfig.maxTimeout, az.stConfig.backoffTime, az.stConfig.maxRetryDelay, az.stConfig.preserveACL)
 
-	log.Crit("ParseAndValidateConfig : Telemetry : %s, honour-ACL %v, disable-symlink %v", az.stConfig.telemetry, az.stConfig.honourACL, az.stConfig.disableSymlink)
+	log.Crit("ParseAndValidateConfig : Telemetry : %s, honour-ACL %v, disable-symlink %v, posix-metadata %v, optimistic-concurrency %v, snapshot-browsing %v, rehydrate-tier %s",
+		az.stConfig.telemetry, az.stConfig.honourACL, az.stConfig.disableSymlink, az.stConfig.posixMetadata, az.stConfig.optimisticConcurrency, az.stConfig.snapshotBrowsing, opt.RehydrateTier)
 
 	return nil
 }
@@ -77,6 +77,17 @@ type AzStorageConfig struct {
 	honourACL      bool
 	disableSymlink bool
 	preserveACL    bool
+	posixMetadata  bool
+
+	// Send conditional requests using ETag of the blob when writing it back
+	optimisticConcurrency bool
+
+	// Present snapshots and earlier versions of blobs under a read only directory
+	snapshotBrowsing bool
+
+	// tier to rehydrate archived blobs to when they are opened, nil to not rehydrate
+	rehydrateTier     *blob.AccessTier
+	rehydratePriority *blob.RehydratePriority
 
 	// CPK related config
 	cpkEnabled             bool
@@ -100,11 +111,11 @@ type AzConnection interface {
 	// This is just for test, shall not be used otherwise
 	SetPrefixPath(string) error
 
-	CreateFile(name string, mode os.FileMode) error
+	CreateFile(options internal.CreateFileOptions) error
 	CreateDirectory(name string) error
 	CreateLink(source string, target string) error
 
-	DeleteFile(name string) error
+	DeleteFile(name string, etag *string) error
 	DeleteDirectory(name string) error
 
 	RenameFile(string, string) error
@@ -115,23 +126,40 @@ type AzConnection interface {
 	// Standard operations to be supported by any account type
 	List(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error)
 
-	ReadToFile(name string, offset int64, count int64, fi *os.File) error
+	ReadToFile(name string, offset int64, count int64, fi *os.File, etag *string) error
 	ReadBuffer(name string, offset int64, len int64) ([]byte, error)
-	ReadInBuffer(name string, offset int64, len int64, data []byte) error
+	ReadInBuffer(name string, offset int64, len int64, data []byte, etag *string) error
+	ReadInBufferWithMD5(name string, offset int64, len int64, data []byte, etag *string) ([][]byte, error)
+
+	// Read only access to snapshots and earlier versions of blobs, identified by the snapshot or version id
+	ListSnapshotIDs(prefix string, limit int) ([]string, error)
+	ListSnapshot(prefix string, id string, marker *string, count int32) ([]*internal.ObjAttr, *string, error)
+	GetSnapshotAttr(name string, id string) (*internal.ObjAttr, error)
+	ReadSnapshotToFile(name string, id string, offset int64, count int64, fi *os.File) error
+	ReadSnapshotInBuffer(name string, id string, offset int64, len int64, data []byte) error
 
-	WriteFromFile(name string, metadata map[string]*string, fi *os.File) error
+	WriteFromFile(name string, metadata map[string]*string, fi *os.File, etag *string) error
 	WriteFromBuffer(name string, metadata map[string]*string, data []byte) error
 	Write(options internal.WriteFileOptions) error
 	GetFileBlockOffsets(name string) (*common.BlockOffsetList, error)
 
-	ChangeMod(string, os.FileMode) error
-	ChangeOwner(string, int, int) error
+	// etag, if it is the ETag of the blob before the change, is updated with the new ETag of the blob
+	ChangeMod(name string, mode os.FileMode, etag *string) error
+	ChangeOwner(name string, owner int, group int, etag *string) error
+	// Returns the new ETag of the blob
+	SetMetadata(name string, metadata map[string]*string) (string, error)
+	GetTier(name string) (tier string, archiveStatus string, err error)
+	SetTier(name string, tier *blob.AccessTier) error
+
+	AcquireLease(name string, duration int32) error
+	RenewLease(name string) error
+	ReleaseLease(name string) error
 	TruncateFile(string, int64) error
 	StageAndCommit(name string, bol *common.BlockOffsetList) error
 
 	GetCommittedBlockList(string) (*internal.CommittedBlockList, error)
-	StageBlock(string, []byte, string) error
-	CommitBlocks(string, []string) error
+	StageBlock(string, []byte, string, []byte) error
+	CommitBlocks(string, []string, *string) error
 
 	UpdateServiceClient(_, _ string) error
 }
@@ -50,6 +50,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/directory"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/file"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/filesystem"
@@ -213,16 +214,16 @@ func (dl *Datalake) SetPrefixPath(path string) error {
 }
 
 // CreateFile : Create a new file in the filesystem/directory
-func (dl *Datalake) CreateFile(name string, mode os.FileMode) error {
-	log.Trace("Datalake::CreateFile : name %s", name)
-	err := dl.BlockBlob.CreateFile(name, mode)
+func (dl *Datalake) CreateFile(options internal.CreateFileOptions) error {
+	log.Trace("Datalake::CreateFile : name %s", options.Name)
+	err := dl.BlockBlob.CreateFile(options)
 	if err != nil {
-		log.Err("Datalake::CreateFile : Failed to create file %s [%s]", name, err.Error())
+		log.Err("Datalake::CreateFile : Failed to create file %s [%s]", options.Name, err.Error())
 		return err
 	}
-	err = dl.ChangeMod(name, mode)
+	err = dl.ChangeMod(options.Name, options.Mode, nil)
 	if err != nil {
-		log.Err("Datalake::CreateFile : Failed to set permissions on file %s [%s]", name, err.Error())
+		log.Err("Datalake::CreateFile : Failed to set permissions on file %s [%s]", options.Name, err.Error())
 		return err
 	}
 
@@ -267,10 +268,28 @@ func (dl *Datalake) CreateLink(source string, target string) error {
 }
 
 // DeleteFile : Delete a file in the filesystem/directory
-func (dl *Datalake) DeleteFile(name string) (err error) {
+func (dl *Datalake) DeleteFile(name string, etag *string) (err error) {
 	log.Trace("Datalake::DeleteFile : name %s", name)
 	fileClient := dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
-	_, err = fileClient.Delete(context.Background(), nil)
+
+	var deleteOptions *file.DeleteOptions
+	if conditions := dl.BlockBlob.getAccessConditions(name, etag); conditions != nil {
+		deleteOptions = &file.DeleteOptions{
+			AccessConditions: &file.AccessConditions{},
+		}
+		if conditions.ModifiedAccessConditions != nil {
+			deleteOptions.AccessConditions.ModifiedAccessConditions = &file.ModifiedAccessConditions{
+				IfMatch: conditions.ModifiedAccessConditions.IfMatch,
+			}
+		}
+		if conditions.LeaseAccessConditions != nil {
+			deleteOptions.AccessConditions.LeaseAccessConditions = &file.LeaseAccessConditions{
+				LeaseID: conditions.LeaseAccessConditions.LeaseID,
+			}
+		}
+	}
+
+	_, err = fileClient.Delete(context.Background(), deleteOptions)
 	if err != nil {
 		serr := storeDatalakeErrToErr(err)
 		if serr == ErrFileNotFound {
@@ -278,7 +297,10 @@ func (dl *Datalake) DeleteFile(name string) (err error) {
 			return syscall.ENOENT
 		} else if serr == BlobIsUnderLease {
 			log.Err("Datalake::DeleteFile : %s is under lease [%s]", name, err.Error())
-			return syscall.EIO
+			return syscall.EBUSY
+		} else if serr == ErrConditionNotMet {
+			log.Warn("Datalake::DeleteFile : %s was modified by someone else, can not delete [%s]", name, err.Error())
+			return syscall.ESTALE
 		} else if serr == InvalidPermission {
 			log.Err("Datalake::DeleteFile : Insufficient permissions for %s [%s]", name, err.Error())
 			return syscall.EACCES
@@ -288,6 +310,8 @@ func (dl *Datalake) DeleteFile(name string) (err error) {
 		}
 	}
 
+	// Lease goes away along with the file
+	dl.BlockBlob.leases.Delete(name)
 	return nil
 }
 
@@ -394,6 +418,7 @@ func (dl *Datalake) GetAttr(name string) (attr *internal.ObjAttr, err error) {
 		Ctime:  *prop.LastModified,
 		Crtime: *prop.LastModified,
 		Flags:  internal.NewFileBitMap(),
+		ETag:   etagToString(prop.ETag),
 	}
 	parseMetadata(attr, prop.Metadata)
 
@@ -537,8 +562,8 @@ func (dl *Datalake) List(prefix string, marker *string, count int32) ([]*interna
 }
 
 // ReadToFile : Download a file to a local file
-func (dl *Datalake) ReadToFile(name string, offset int64, count int64, fi *os.File) (err error) {
-	return dl.BlockBlob.ReadToFile(name, offset, count, fi)
+func (dl *Datalake) ReadToFile(name string, offset int64, count int64, fi *os.File, etag *string) (err error) {
+	return dl.BlockBlob.ReadToFile(name, offset, count, fi, etag)
 }
 
 // ReadBuffer : Download a specific range from a file to a buffer
@@ -547,12 +572,17 @@ func (dl *Datalake) ReadBuffer(name string, offset int64, len int64) ([]byte, er
 }
 
 // ReadInBuffer : Download specific range from a file to a user provided buffer
-func (dl *Datalake) ReadInBuffer(name string, offset int64, len int64, data []byte) error {
-	return dl.BlockBlob.ReadInBuffer(name, offset, len, data)
+func (dl *Datalake) ReadInBuffer(name string, offset int64, len int64, data []byte, etag *string) error {
+	return dl.BlockBlob.ReadInBuffer(name, offset, len, data, etag)
+}
+
+// ReadInBufferWithMD5 : Download specific range from a file to a user provided buffer along with MD5 of its parts
+func (dl *Datalake) ReadInBufferWithMD5(name string, offset int64, len int64, data []byte, etag *string) ([][]byte, error) {
+	return dl.BlockBlob.ReadInBufferWithMD5(name, offset, len, data, etag)
 }
 
 // WriteFromFile : Upload local file to file
-func (dl *Datalake) WriteFromFile(name string, metadata map[string]*string, fi *os.File) (err error) {
+func (dl *Datalake) WriteFromFile(name string, metadata map[string]*string, fi *os.File, etag *string) (err error) {
 	// File in DataLake may have permissions and ACL set. Just uploading the file will override them.
 	// So, we need to get the existing permissions and ACL and set them back after uploading the file.
 
@@ -570,19 +600,22 @@ func (dl *Datalake) WriteFromFile(name string, metadata map[string]*string, fi *
 	}
 
 	// Upload the file, which will override the permissions and ACL
-	retCode := dl.BlockBlob.WriteFromFile(name, metadata, fi)
+	retCode := dl.BlockBlob.WriteFromFile(name, metadata, fi, etag)
 
 	if acl != "" {
 		// Cannot set both permissions and ACL in one call. ACL includes permission as well so just setting those back
 		// Just setting up the permissions will delete existing ACLs applied on the blob so do not convert this code to
 		// just set the permissions.
-		_, err := fileClient.SetAccessControl(context.Background(), &file.SetAccessControlOptions{
+		resp, err := fileClient.SetAccessControl(context.Background(), &file.SetAccessControlOptions{
 			ACL: &acl,
 		})
 
 		if err != nil {
 			// Earlier code was ignoring this so it might break customer cases where they do not have auth to update ACL
 			log.Err("Datalake::WriteFromFile : Failed to set ACL for %s [%s]", name, err.Error())
+		} else if retCode == nil && etag != nil {
+			// Setting the ACL changes the ETag of the file
+			*etag = etagToString(resp.ETag)
 		}
 	}
 
@@ -612,10 +645,19 @@ func (dl *Datalake) TruncateFile(name string, size int64) error {
 }
 
 // ChangeMod : Change mode of a path
-func (dl *Datalake) ChangeMod(name string, mode os.FileMode) error {
+func (dl *Datalake) ChangeMod(name string, mode os.FileMode, etag *string) error {
 	log.Trace("Datalake::ChangeMod : Change mode of file %s to %s", name, mode)
 	fileClient := dl.Filesystem.NewFileClient(filepath.Join(dl.Config.prefixPath, name))
 
+	// Setting the ACL changes the ETag of the file, which can be handed back only if it was known before the change
+	before := ""
+	if etag != nil && *etag != "" {
+		attr, err := dl.GetAttr(name)
+		if err == nil {
+			before = attr.ETag
+		}
+	}
+
 	/*
 		// If we need to call the ACL set api then we need to get older acl string here
 		// and create new string with the username included in the string
@@ -632,7 +674,7 @@ func (dl *Datalake) ChangeMod(name string, mode os.FileMode) error {
 	*/
 
 	newPerm := getACLPermissions(mode)
-	_, err := fileClient.SetAccessControl(context.Background(), &file.SetAccessControlOptions{
+	resp, err := fileClient.SetAccessControl(context.Background(), &file.SetAccessControlOptions{
 		Permissions: &newPerm,
 	})
 	if err != nil {
@@ -647,11 +689,12 @@ func (dl *Datalake) ChangeMod(name string, mode os.FileMode) error {
 		}
 	}
 
+	refreshETag(etag, before, etagToString(resp.ETag))
 	return nil
 }
 
 // ChangeOwner : Change owner of a path
-func (dl *Datalake) ChangeOwner(name string, _ int, _ int) error {
+func (dl *Datalake) ChangeOwner(name string, _ int, _ int, _ *string) error {
 	log.Trace("Datalake::ChangeOwner : name %s", name)
 
 	if dl.Config.ignoreAccessModifiers {
@@ -675,17 +718,72 @@ func (dl *Datalake) ChangeOwner(name string, _ int, _ int) error {
 	return syscall.ENOTSUP
 }
 
+// SetMetadata : Replace user defined metadata of a path
+func (dl *Datalake) SetMetadata(name string, metadata map[string]*string) (string, error) {
+	return dl.BlockBlob.SetMetadata(name, metadata)
+}
+
+// GetTier : Get access tier of the file and status of its rehydration if it is archived
+func (dl *Datalake) GetTier(name string) (string, string, error) {
+	return dl.BlockBlob.GetTier(name)
+}
+
+// SetTier : Change access tier of the file
+func (dl *Datalake) SetTier(name string, tier *blob.AccessTier) error {
+	return dl.BlockBlob.SetTier(name, tier)
+}
+
 // GetCommittedBlockList : Get the list of committed blocks
 func (dl *Datalake) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
 	return dl.BlockBlob.GetCommittedBlockList(name)
 }
 
+// AcquireLease : Take a lease on the file
+func (dl *Datalake) AcquireLease(name string, duration int32) error {
+	return dl.BlockBlob.AcquireLease(name, duration)
+}
+
+// RenewLease : Extend the lease held on the file
+func (dl *Datalake) RenewLease(name string) error {
+	return dl.BlockBlob.RenewLease(name)
+}
+
+// ReleaseLease : Release the lease held on the file
+func (dl *Datalake) ReleaseLease(name string) error {
+	return dl.BlockBlob.ReleaseLease(name)
+}
+
+// ListSnapshotIDs : Get the ids of the newest snapshots and earlier versions of files under the given prefix
+func (dl *Datalake) ListSnapshotIDs(prefix string, limit int) ([]string, error) {
+	return dl.BlockBlob.ListSnapshotIDs(prefix, limit)
+}
+
+// ListSnapshot : Get a list of files matching the given prefix as they were at the given snapshot or version
+func (dl *Datalake) ListSnapshot(prefix string, id string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
+	return dl.BlockBlob.ListSnapshot(prefix, id, marker, count)
+}
+
+// GetSnapshotAttr : Retrieve attributes of the file as it was at the given snapshot or version
+func (dl *Datalake) GetSnapshotAttr(name string, id string) (*internal.ObjAttr, error) {
+	return dl.BlockBlob.GetSnapshotAttr(name, id)
+}
+
+// ReadSnapshotToFile : Download the file as it was at the given snapshot or version to a local file
+func (dl *Datalake) ReadSnapshotToFile(name string, id string, offset int64, count int64, fi *os.File) error {
+	return dl.BlockBlob.ReadSnapshotToFile(name, id, offset, count, fi)
+}
+
+// ReadSnapshotInBuffer : Download specific range of the file as it was at the given snapshot or version
+func (dl *Datalake) ReadSnapshotInBuffer(name string, id string, offset int64, len int64, data []byte) error {
+	return dl.BlockBlob.ReadSnapshotInBuffer(name, id, offset, len, data)
+}
+
 // StageBlock : stages a block and returns its blockid
-func (dl *Datalake) StageBlock(name string, data []byte, id string) error {
-	return dl.BlockBlob.StageBlock(name, data, id)
+func (dl *Datalake) StageBlock(name string, data []byte, id string, md5 []byte) error {
+	return dl.BlockBlob.StageBlock(name, data, id, md5)
 }
 
 // CommitBlocks : persists the block list
-func (dl *Datalake) CommitBlocks(name string, blockList []string) error {
-	return dl.BlockBlob.CommitBlocks(name, blockList)
+func (dl *Datalake) CommitBlocks(name string, blockList []string, etag *string) error {
+	return dl.BlockBlob.CommitBlocks(name, blockList, etag)
 }
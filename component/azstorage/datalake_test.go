@@ -2034,7 +2034,7 @@ func (s *datalakeTestSuite) TestFlushFileUpdateChunkedFile() {
 	updatedBlock := make([]byte, 2*MB)
 	rand.Read(updatedBlock)
 	h.CacheObj.BlockOffsetList.BlockList[1].Data = make([]byte, blockSize)
-	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize), h.CacheObj.BlockOffsetList.BlockList[1].Data)
+	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize), h.CacheObj.BlockOffsetList.BlockList[1].Data, nil)
 	copy(h.CacheObj.BlockOffsetList.BlockList[1].Data[MB:2*MB+MB], updatedBlock)
 	h.CacheObj.BlockOffsetList.BlockList[1].Flags.Set(common.DirtyBlock)
 
@@ -2072,7 +2072,7 @@ func (s *datalakeTestSuite) TestFlushFileTruncateUpdateChunkedFile() {
 	// truncate block
 	h.CacheObj.BlockOffsetList.BlockList[1].Data = make([]byte, blockSize/2)
 	h.CacheObj.BlockOffsetList.BlockList[1].EndIndex = int64(blockSize + blockSize/2)
-	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize)/2, h.CacheObj.BlockOffsetList.BlockList[1].Data)
+	s.az.storage.ReadInBuffer(name, int64(blockSize), int64(blockSize)/2, h.CacheObj.BlockOffsetList.BlockList[1].Data, nil)
 	h.CacheObj.BlockOffsetList.BlockList[1].Flags.Set(common.DirtyBlock)
 
 	// remove 2 blocks
@@ -2482,21 +2482,21 @@ func (s *datalakeTestSuite) TestDownloadWithCPKEnabled() {
 	s.assert.Nil(err)
 	s.assert.NotNil(f)
 
-	err = s.az.storage.ReadToFile(name, 0, int64(len(data)), f)
+	err = s.az.storage.ReadToFile(name, 0, int64(len(data)), f, nil)
 	s.assert.Nil(err)
 	fileData, err := os.ReadFile(name)
 	s.assert.Nil(err)
 	s.assert.EqualValues(data, fileData)
 
 	buf := make([]byte, len(data))
-	err = s.az.storage.ReadInBuffer(name, 0, int64(len(data)), buf)
+	err = s.az.storage.ReadInBuffer(name, 0, int64(len(data)), buf, nil)
 	s.assert.Nil(err)
 	s.assert.EqualValues(data, buf)
 
 	rbuf, err := s.az.storage.ReadBuffer(name, 0, int64(len(data)))
 	s.assert.Nil(err)
 	s.assert.EqualValues(data, rbuf)
-	_ = s.az.storage.DeleteFile(name)
+	_ = s.az.storage.DeleteFile(name, nil)
 	_ = os.Remove(name)
 }
 
@@ -2526,7 +2526,7 @@ func (s *datalakeTestSuite) TestUploadWithCPKEnabled() {
 	s.assert.Nil(err)
 	_, _ = f.Seek(0, 0)
 
-	err = s.az.storage.WriteFromFile(name1, nil, f)
+	err = s.az.storage.WriteFromFile(name1, nil, f, nil)
 	s.assert.Nil(err)
 
 	// Blob should have updated data
@@ -2566,8 +2566,8 @@ func (s *datalakeTestSuite) TestUploadWithCPKEnabled() {
 	s.assert.Nil(err)
 	s.assert.NotNil(resp.RequestID)
 
-	_ = s.az.storage.DeleteFile(name1)
-	_ = s.az.storage.DeleteFile(name2)
+	_ = s.az.storage.DeleteFile(name1, nil)
+	_ = s.az.storage.DeleteFile(name2, nil)
 	_ = os.Remove(name1)
 }
 
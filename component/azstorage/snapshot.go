@@ -0,0 +1,280 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package azstorage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+)
+
+// Snapshots and earlier versions of blobs are presented read only under this directory at the root of the mount.
+// Each snapshot or version id is a directory holding the blobs as they were at that snapshot or version,
+// e.g. .snapshots/2024-01-31T10:15:42.1234567Z/dir/file
+const snapshotDirName = ".snapshots"
+
+// Finding the snapshot and version ids needs a flat listing of the whole container, so the ids found are reused for
+// listings of the snapshot directory till they expire. Only the newest ids are kept if there are too many of them,
+// and the listing stops after a number of pages so that a huge container is not listed in full.
+const (
+	snapshotIDTimeout    = 5 * time.Minute
+	maxSnapshotIDs       = 10000
+	maxSnapshotListPages = 20
+)
+
+// snapshotIDCache : Snapshot and version ids found by the last listing of the container, sorted oldest first
+type snapshotIDCache struct {
+	sync.Mutex
+	ids      []string
+	listedAt time.Time
+}
+
+// splitSnapshotPath : Split a path under the snapshot directory into the snapshot or version id and the path of the blob in it.
+// ok is false if the path is not under the snapshot directory or browsing of snapshots is disabled.
+func (az *AzStorage) splitSnapshotPath(name string) (id string, path string, ok bool) {
+	if !az.stConfig.snapshotBrowsing {
+		return "", "", false
+	}
+
+	if name != snapshotDirName && !strings.HasPrefix(name, snapshotDirName+"/") {
+		return "", "", false
+	}
+
+	id, path, _ = strings.Cut(strings.TrimPrefix(name[len(snapshotDirName):], "/"), "/")
+	return id, path, true
+}
+
+// isSnapshotPath : Whether the path is under the read only snapshot directory
+func (az *AzStorage) isSnapshotPath(name string) bool {
+	_, _, ok := az.splitSnapshotPath(name)
+	return ok
+}
+
+// validSnapshotID : Snapshot and version ids are both timestamps, anything else can not name a snapshot
+func validSnapshotID(id string) bool {
+	_, err := time.Parse(time.RFC3339Nano, id)
+	return err == nil
+}
+
+// toSnapshotAttr : Move attributes of a blob in a snapshot under the snapshot directory
+func toSnapshotAttr(id string, attr *internal.ObjAttr) *internal.ObjAttr {
+	attr.Path = filepath.Join(snapshotDirName, id, attr.Path)
+	return attr
+}
+
+// getAttr : Get attributes of a path, paths under the snapshot directory are served from the snapshot or version they belong to
+func (az *AzStorage) getAttr(name string) (*internal.ObjAttr, error) {
+	id, path, ok := az.splitSnapshotPath(name)
+	if !ok {
+		return az.storage.GetAttr(name)
+	}
+
+	if id == "" {
+		return newDirAttr(snapshotDirName), nil
+	}
+
+	if !validSnapshotID(id) {
+		return nil, syscall.ENOENT
+	}
+
+	if path == "" {
+		return newDirAttr(filepath.Join(snapshotDirName, id)), nil
+	}
+
+	attr, err := az.storage.GetSnapshotAttr(path, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return toSnapshotAttr(id, attr), nil
+}
+
+// list : List a directory, directories under the snapshot directory are listed from the snapshot or version they belong to
+func (az *AzStorage) list(prefix string, marker *string, count int32) ([]*internal.ObjAttr, *string, error) {
+	id, path, ok := az.splitSnapshotPath(prefix)
+	if !ok {
+		return az.storage.List(prefix, marker, count)
+	}
+
+	if id == "" {
+		// Snapshot directory holds one directory for each snapshot or version id, the marker is the last id returned
+		ids, err := az.getSnapshotIDs(marker == nil || *marker == "")
+		if err != nil {
+			log.Err("AzStorage::list : Failed to list snapshots [%s]", err.Error())
+			return nil, nil, err
+		}
+
+		page, newMarker := pageSnapshotIDs(ids, marker, count)
+		list := make([]*internal.ObjAttr, 0, len(page))
+		for _, id := range page {
+			list = append(list, newDirAttr(filepath.Join(snapshotDirName, id)))
+		}
+		return list, newMarker, nil
+	}
+
+	if !validSnapshotID(id) {
+		return nil, nil, syscall.ENOENT
+	}
+
+	list, newMarker, err := az.storage.ListSnapshot(path, id, marker, count)
+	if err != nil {
+		return list, nil, err
+	}
+
+	for _, attr := range list {
+		toSnapshotAttr(id, attr)
+	}
+	return list, newMarker, nil
+}
+
+// getSnapshotIDs : Get the snapshot and version ids in the container. They are listed again only when a listing of the
+// snapshot directory starts and the ids at hand have expired, so the pages of one listing come from the same ids.
+func (az *AzStorage) getSnapshotIDs(start bool) ([]string, error) {
+	az.snapshotIDs.Lock()
+	defer az.snapshotIDs.Unlock()
+
+	if az.snapshotIDs.ids != nil && (!start || time.Since(az.snapshotIDs.listedAt) < snapshotIDTimeout) {
+		return az.snapshotIDs.ids, nil
+	}
+
+	ids, err := az.storage.ListSnapshotIDs("", maxSnapshotIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	az.snapshotIDs.ids = ids
+	az.snapshotIDs.listedAt = time.Now()
+	return ids, nil
+}
+
+// keepNewestSnapshotIDs : Drop all but the newest limit ids from the set and return the ones kept, oldest first.
+// Snapshot and version ids are times so they sort by age.
+func keepNewestSnapshotIDs(found map[string]bool, limit int) []string {
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if len(ids) > limit {
+		for _, id := range ids[:len(ids)-limit] {
+			delete(found, id)
+		}
+		ids = ids[len(ids)-limit:]
+	}
+	return ids
+}
+
+// pageSnapshotIDs : Get the ids following the marker, at most count of them. The returned marker is empty after the last page.
+func pageSnapshotIDs(ids []string, marker *string, count int32) ([]string, *string) {
+	if count <= 0 {
+		count = common.MaxDirListCount
+	}
+
+	start := 0
+	if marker != nil && *marker != "" {
+		start = sort.SearchStrings(ids, *marker)
+		if start < len(ids) && ids[start] == *marker {
+			start++
+		}
+	}
+
+	end := start + int(count)
+	if end >= len(ids) {
+		return ids[start:], nil
+	}
+
+	newMarker := ids[end-1]
+	return ids[start:end], &newMarker
+}
+
+// readBuffer : Read a range of a blob, paths under the snapshot directory are read from the snapshot or version they belong to.
+// len 0 reads till the end of the blob.
+func (az *AzStorage) readBuffer(name string, offset int64, len int64) ([]byte, error) {
+	id, path, ok := az.splitSnapshotPath(name)
+	if !ok {
+		return az.storage.ReadBuffer(name, offset, len)
+	}
+
+	if path == "" || !validSnapshotID(id) {
+		return nil, syscall.EISDIR
+	}
+
+	if len == 0 {
+		attr, err := az.storage.GetSnapshotAttr(path, id)
+		if err != nil {
+			return nil, err
+		}
+		len = attr.Size - offset
+	}
+
+	data := make([]byte, len)
+	err := az.storage.ReadSnapshotInBuffer(path, id, offset, len, data)
+	return data, err
+}
+
+// readInBuffer : Read a range of a blob in the given buffer, paths under the snapshot directory are read from the snapshot or version they belong to.
+// Snapshots and versions never change, so etag only applies to the current blob.
+func (az *AzStorage) readInBuffer(name string, offset int64, len int64, data []byte, etag *string) error {
+	id, path, ok := az.splitSnapshotPath(name)
+	if !ok {
+		return az.storage.ReadInBuffer(name, offset, len, data, etag)
+	}
+
+	if path == "" || !validSnapshotID(id) {
+		return syscall.EISDIR
+	}
+	return az.storage.ReadSnapshotInBuffer(path, id, offset, len, data)
+}
+
+// readToFile : Download a blob to a local file, paths under the snapshot directory are read from the snapshot or version they belong to
+func (az *AzStorage) readToFile(name string, offset int64, count int64, fi *os.File, etag *string) error {
+	id, path, ok := az.splitSnapshotPath(name)
+	if !ok {
+		return az.storage.ReadToFile(name, offset, count, fi, etag)
+	}
+
+	if path == "" || !validSnapshotID(id) {
+		return syscall.EISDIR
+	}
+	return az.storage.ReadSnapshotToFile(path, id, offset, count, fi)
+}
@@ -35,6 +35,7 @@ package azstorage
 
 import (
 	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -44,13 +45,16 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	azlog "github.com/Azure/azure-sdk-for-go/sdk/azcore/log"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
@@ -238,6 +242,9 @@ const (
 	InvalidRange
 	BlobIsUnderLease
 	InvalidPermission
+	ErrConditionNotMet
+	ErrLeaseConflict
+	ErrBlobArchived
 )
 
 // For detailed error list refer below link,
@@ -255,10 +262,17 @@ func storeBlobErrToErr(err error) uint16 {
 			return ErrFileNotFound
 		case bloberror.InvalidRange:
 			return InvalidRange
-		case bloberror.LeaseIDMissing:
+		case bloberror.LeaseIDMissing, bloberror.LeaseIDMismatchWithBlobOperation:
 			return BlobIsUnderLease
+		case bloberror.LeaseAlreadyPresent, bloberror.LeaseIDMismatchWithLeaseOperation, bloberror.LeaseNotPresentWithLeaseOperation,
+			bloberror.LeaseLost, bloberror.LeaseIsBreakingAndCannotBeAcquired, bloberror.LeaseIsBrokenAndCannotBeRenewed:
+			return ErrLeaseConflict
 		case bloberror.InsufficientAccountPermissions, bloberror.AuthorizationPermissionMismatch:
 			return InvalidPermission
+		case bloberror.ConditionNotMet, bloberror.SourceConditionNotMet:
+			return ErrConditionNotMet
+		case bloberror.BlobArchived, bloberror.BlobBeingRehydrated:
+			return ErrBlobArchived
 		default:
 			return ErrUnknown
 		}
@@ -283,6 +297,8 @@ func storeDatalakeErrToErr(err error) uint16 {
 			return BlobIsUnderLease
 		case datalakeerror.AuthorizationPermissionMismatch:
 			return InvalidPermission
+		case datalakeerror.ConditionNotMet, datalakeerror.SourceConditionNotMet:
+			return ErrConditionNotMet
 		default:
 			return ErrUnknown
 		}
@@ -290,6 +306,14 @@ func storeDatalakeErrToErr(err error) uint16 {
 	return ErrNoErr
 }
 
+// etagToString : Convert ETag returned by storage to the string kept in attributes
+func etagToString(etag *azcore.ETag) string {
+	if etag == nil {
+		return ""
+	}
+	return string(*etag)
+}
+
 //	----------- Metadata handling  ---------------
 //
 // parseMetadata : Parse the metadata of a given path and populate its attributes
@@ -304,11 +328,182 @@ func parseMetadata(attr *internal.ObjAttr, metadata map[string]*string) {
 			} else if strings.ToLower(k) == symlinkKey && *v == "true" {
 				attr.Flags = internal.NewSymlinkBitMap()
 				attr.Mode = attr.Mode | os.ModeSymlink
+			} else if strings.ToLower(k) == mtimeKey {
+				if t, err := time.Parse(time.RFC3339Nano, *v); err == nil {
+					attr.Mtime = t
+				}
+			} else if strings.ToLower(k) == atimeKey {
+				if t, err := time.Parse(time.RFC3339Nano, *v); err == nil {
+					attr.Atime = t
+				}
 			}
 		}
 	}
 }
 
+// setTimeMetadata : Store the given time against the key, replacing any existing entry
+func setTimeMetadata(metadata map[string]*string, key string, t time.Time) {
+	if k, found := getMetadataKey(metadata, key); found {
+		delete(metadata, k)
+	}
+	metadata[key] = to.Ptr(t.UTC().Format(time.RFC3339Nano))
+}
+
+// refreshETag : A change made by this mount gave the blob a new ETag. Hand it to the caller only if the caller knew the
+// blob as it was right before the change, otherwise a change made by someone else would go unnoticed.
+func refreshETag(etag *string, before string, after string) {
+	if etag != nil && *etag != "" && *etag == before {
+		*etag = after
+	}
+}
+
+// removeTimeMetadata : Times set through utimens belong to the content they were set on.
+// When content is uploaded again those shall not be carried forward, so return a copy of metadata without them.
+func removeTimeMetadata(metadata map[string]*string) map[string]*string {
+	_, mtimeFound := getMetadataKey(metadata, mtimeKey)
+	_, atimeFound := getMetadataKey(metadata, atimeKey)
+	if !mtimeFound && !atimeFound {
+		return metadata
+	}
+
+	newMetadata := make(map[string]*string)
+	for k, v := range metadata {
+		if !strings.EqualFold(k, mtimeKey) && !strings.EqualFold(k, atimeKey) {
+			newMetadata[k] = v
+		}
+	}
+	return newMetadata
+}
+
+// Largest total size of names and values of the metadata of a blob accepted by the service
+const maxMetadataSize = 8 * 1024
+
+// checkMetadataSize : Metadata larger than the service accepts fails with E2BIG, like an xattr too large for the filesystem
+func checkMetadataSize(name string, metadata map[string]*string) error {
+	size := 0
+	for k, v := range metadata {
+		size += len(k)
+		if v != nil {
+			size += len(*v)
+		}
+	}
+
+	if size > maxMetadataSize {
+		log.Err("checkMetadataSize : Metadata of %s is %d bytes, more than %d allowed", name, size, maxMetadataSize)
+		return syscall.E2BIG
+	}
+	return nil
+}
+
+// Permission bits persisted in metadata, including setuid, setgid and sticky bits
+const posixModeMask = os.FileMode(0o7777)
+
+// parsePosixMetadata : Populate mode and owner of the object from metadata.
+// Returns whether the mode was found, as otherwise the default permission shall be used.
+func parsePosixMetadata(attr *internal.ObjAttr) bool {
+	uid, uidFound := parseIdMetadata(attr.Metadata, uidKey)
+	gid, gidFound := parseIdMetadata(attr.Metadata, gidKey)
+	if uidFound && gidFound {
+		attr.Uid = uid
+		attr.Gid = gid
+		attr.Flags.Set(internal.PropFlagOwnerSet)
+	}
+
+	k, found := getMetadataKey(attr.Metadata, modeKey)
+	if !found || attr.Metadata[k] == nil {
+		return false
+	}
+
+	mode, err := strconv.ParseUint(*attr.Metadata[k], 8, 32)
+	if err != nil {
+		log.Warn("parsePosixMetadata : Invalid mode %s for %s [%s]", *attr.Metadata[k], attr.Path, err.Error())
+		return false
+	}
+
+	attr.Mode = (attr.Mode &^ posixModeMask) | (os.FileMode(mode) & posixModeMask)
+	return true
+}
+
+// newPosixMetadata : Metadata holding the mode of a new file, and its owner and group when those are known
+func newPosixMetadata(options internal.CreateFileOptions) map[string]*string {
+	metadata := map[string]*string{
+		modeKey: to.Ptr(strconv.FormatUint(uint64(options.Mode&posixModeMask), 8)),
+	}
+	if options.OwnerSet {
+		metadata[uidKey] = to.Ptr(strconv.FormatUint(uint64(options.Uid), 10))
+		metadata[gidKey] = to.Ptr(strconv.FormatUint(uint64(options.Gid), 10))
+	}
+	return metadata
+}
+
+// parseIdMetadata : Parse a user or group id stored in the given metadata key
+func parseIdMetadata(metadata map[string]*string, key string) (uint32, bool) {
+	k, found := getMetadataKey(metadata, key)
+	if !found || metadata[k] == nil {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(*metadata[k], 10, 32)
+	if err != nil {
+		log.Warn("parseIdMetadata : Invalid value %s for %s [%s]", *metadata[k], key, err.Error())
+		return 0, false
+	}
+
+	return uint32(id), true
+}
+
+//	----------- Extended attribute handling  ---------------
+//
+// Only the "user." namespace is persisted. Each attribute is stored as a metadata key made of xattrKeyPrefix
+// followed by the hex encoded attribute name, as metadata keys must be valid C# identifiers and are case-insensitive.
+// Values are stored base64 encoded as metadata values have to be valid http header values.
+const (
+	xattrNamespace = "user."
+	xattrKeyPrefix = "xattr_"
+)
+
+// Access tier of a blob is not metadata, these attributes are served from the blob properties instead.
+// Setting the tier attribute changes the tier of the blob, archive status is read only.
+const (
+	tierXattr          = common.TierXattr
+	archiveStatusXattr = common.ArchiveStatusXattr
+)
+
+// getXattrKey : Convert an extended attribute name to the metadata key holding it
+func getXattrKey(name string) (string, error) {
+	if !strings.HasPrefix(name, xattrNamespace) || len(name) == len(xattrNamespace) {
+		return "", syscall.ENOTSUP
+	}
+
+	return xattrKeyPrefix + hex.EncodeToString([]byte(name[len(xattrNamespace):])), nil
+}
+
+// getXattrName : Convert a metadata key back to the extended attribute name, false if key does not hold an attribute
+func getXattrName(key string) (string, bool) {
+	key = strings.ToLower(key)
+	if !strings.HasPrefix(key, xattrKeyPrefix) {
+		return "", false
+	}
+
+	name, err := hex.DecodeString(key[len(xattrKeyPrefix):])
+	if err != nil || len(name) == 0 {
+		return "", false
+	}
+
+	return xattrNamespace + string(name), true
+}
+
+// getMetadataKey : Find the key as returned by the service, sdk may change the case of the key we have set
+func getMetadataKey(metadata map[string]*string, key string) (string, bool) {
+	for k := range metadata {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
 //    ----------- Content-type handling  ---------------
 
 // ContentTypeMap : Store file extension to content-type mapping
@@ -429,6 +624,24 @@ func getAccessTierType(name string) *blob.AccessTier {
 	return nil
 }
 
+// RehydratePriorities : Store config to rehydrate priority mapping
+var RehydratePriorities = map[string]blob.RehydratePriority{
+	"standard": blob.RehydratePriorityStandard,
+	"high":     blob.RehydratePriorityHigh,
+}
+
+func getRehydratePriorityType(name string) *blob.RehydratePriority {
+	if name == "" {
+		return nil
+	}
+
+	value, found := RehydratePriorities[strings.ToLower(name)]
+	if found {
+		return &value
+	}
+	return nil
+}
+
 // Called by x method
 func getACLPermissions(mode os.FileMode) string {
 	// Format for ACL and Permission string is different
@@ -37,12 +37,19 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azdatalake/datalakeerror"
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -533,6 +540,336 @@ func (s *utilsTestSuite) TestRemoveLeadingSlashes() {
 	}
 }
 
+func (s *utilsTestSuite) TestXattrKey() {
+	assert := assert.New(s.T())
+	var inputs = []struct {
+		name string
+		key  string
+	}{
+		{name: "user.tag", key: "xattr_746167"},
+		{name: "user.Mixed-Case.name", key: "xattr_4d697865642d436173652e6e616d65"},
+	}
+
+	for _, i := range inputs {
+		key, err := getXattrKey(i.name)
+		assert.Nil(err)
+		assert.Equal(i.key, key)
+
+		// service may hand back keys with a different case
+		name, ok := getXattrName(strings.ToUpper(key[:1]) + key[1:])
+		assert.True(ok)
+		assert.Equal(i.name, name)
+	}
+
+	_, err := getXattrKey("security.selinux")
+	assert.NotNil(err)
+
+	_, err = getXattrKey("user.")
+	assert.NotNil(err)
+
+	_, ok := getXattrName(folderKey)
+	assert.False(ok)
+
+	_, ok = getXattrName("xattr_zz")
+	assert.False(ok)
+}
+
+func (s *utilsTestSuite) TestGetMetadataKey() {
+	assert := assert.New(s.T())
+
+	metadata := map[string]*string{"Hdi_isfolder": to.Ptr("true")}
+
+	key, found := getMetadataKey(metadata, folderKey)
+	assert.True(found)
+	assert.Equal("Hdi_isfolder", key)
+
+	_, found = getMetadataKey(metadata, symlinkKey)
+	assert.False(found)
+}
+
+func (s *utilsTestSuite) TestTimeMetadata() {
+	assert := assert.New(s.T())
+
+	mtime := time.Date(2020, time.January, 2, 3, 4, 5, 6, time.UTC)
+	metadata := map[string]*string{"Blobfuse_mtime": to.Ptr("stale"), "owner": to.Ptr("alice")}
+
+	setTimeMetadata(metadata, mtimeKey, mtime)
+	assert.Len(metadata, 2)
+	assert.Equal("2020-01-02T03:04:05.000000006Z", *metadata[mtimeKey])
+
+	attr := &internal.ObjAttr{}
+	parseMetadata(attr, metadata)
+	assert.True(mtime.Equal(attr.Mtime))
+
+	newMetadata := removeTimeMetadata(metadata)
+	assert.Len(newMetadata, 1)
+	assert.Contains(newMetadata, "owner")
+	assert.Len(metadata, 2)
+}
+
+func (s *utilsTestSuite) TestCheckMetadataSize() {
+	assert := assert.New(s.T())
+
+	metadata := map[string]*string{"key": to.Ptr(strings.Repeat("a", maxMetadataSize-len("key")-len("empty"))), "empty": nil}
+	assert.NoError(checkMetadataSize("a.txt", metadata))
+	assert.NoError(checkMetadataSize("a.txt", nil))
+
+	metadata["more"] = to.Ptr("b")
+	assert.Equal(syscall.E2BIG, checkMetadataSize("a.txt", metadata))
+}
+
+func (s *utilsTestSuite) TestParsePosixMetadata() {
+	assert := assert.New(s.T())
+
+	attr := &internal.ObjAttr{
+		Mode:     os.ModeDir,
+		Metadata: map[string]*string{"Blobfuse_uid": to.Ptr("1001"), "blobfuse_gid": to.Ptr("1002"), "Blobfuse_mode": to.Ptr("4750")},
+	}
+	assert.True(parsePosixMetadata(attr))
+	assert.True(attr.IsOwnerSet())
+	assert.EqualValues(1001, attr.Uid)
+	assert.EqualValues(1002, attr.Gid)
+	assert.Equal(os.ModeDir|os.FileMode(0o4750), attr.Mode)
+
+	// Owner is used only when both the ids are present
+	attr = &internal.ObjAttr{Metadata: map[string]*string{uidKey: to.Ptr("1001")}}
+	assert.False(parsePosixMetadata(attr))
+	assert.False(attr.IsOwnerSet())
+
+	// Invalid values are ignored
+	attr = &internal.ObjAttr{Metadata: map[string]*string{uidKey: to.Ptr("abc"), gidKey: to.Ptr("1"), modeKey: to.Ptr("999")}}
+	assert.False(parsePosixMetadata(attr))
+	assert.False(attr.IsOwnerSet())
+	assert.EqualValues(0, attr.Mode)
+}
+
+func (s *utilsTestSuite) TestNewPosixMetadata() {
+	assert := assert.New(s.T())
+
+	metadata := newPosixMetadata(internal.CreateFileOptions{Name: "file", Mode: 0o100640, Uid: 1001, Gid: 1002, OwnerSet: true})
+	assert.Len(metadata, 3)
+	assert.Equal("640", *metadata[modeKey])
+	assert.Equal("1001", *metadata[uidKey])
+	assert.Equal("1002", *metadata[gidKey])
+
+	attr := &internal.ObjAttr{Metadata: metadata}
+	assert.True(parsePosixMetadata(attr))
+	assert.True(attr.IsOwnerSet())
+	assert.Equal(os.FileMode(0o640), attr.Mode)
+
+	// Owner is not stored when the caller is not known
+	metadata = newPosixMetadata(internal.CreateFileOptions{Name: "file", Mode: 0o644})
+	assert.Len(metadata, 1)
+	assert.Equal("644", *metadata[modeKey])
+}
+
+func (s *utilsTestSuite) TestRefreshETag() {
+	assert := assert.New(s.T())
+
+	etag := "old"
+	refreshETag(&etag, "old", "new")
+	assert.Equal("new", etag)
+
+	// Blob was changed by someone else before, so the caller keeps its ETag
+	etag = "old"
+	refreshETag(&etag, "other", "new")
+	assert.Equal("old", etag)
+
+	// Nothing to refresh when the caller does not know the ETag
+	etag = ""
+	refreshETag(&etag, "", "new")
+	assert.Equal("", etag)
+	refreshETag(nil, "old", "new")
+}
+
+func (s *utilsTestSuite) TestConditionNotMetError() {
+	assert := assert.New(s.T())
+
+	err := &azcore.ResponseError{ErrorCode: string(bloberror.ConditionNotMet)}
+	assert.EqualValues(ErrConditionNotMet, storeBlobErrToErr(err))
+
+	err = &azcore.ResponseError{ErrorCode: string(bloberror.SourceConditionNotMet)}
+	assert.EqualValues(ErrConditionNotMet, storeBlobErrToErr(err))
+
+	err = &azcore.ResponseError{ErrorCode: string(datalakeerror.ConditionNotMet)}
+	assert.EqualValues(ErrConditionNotMet, storeDatalakeErrToErr(err))
+}
+
+func (s *utilsTestSuite) TestETagToString() {
+	assert := assert.New(s.T())
+
+	assert.Equal("", etagToString(nil))
+	assert.Equal("\"0x8D\"", etagToString(to.Ptr(azcore.ETag("\"0x8D\""))))
+}
+
+func (s *utilsTestSuite) TestSplitSnapshotPath() {
+	assert := assert.New(s.T())
+
+	az := &AzStorage{}
+	_, _, ok := az.splitSnapshotPath(".snapshots/2024-01-31T10:15:42.1234567Z/dir/file")
+	assert.False(ok)
+	assert.False(az.isSnapshotPath(".snapshots"))
+
+	az.stConfig.snapshotBrowsing = true
+	id, path, ok := az.splitSnapshotPath(".snapshots/2024-01-31T10:15:42.1234567Z/dir/file")
+	assert.True(ok)
+	assert.Equal("2024-01-31T10:15:42.1234567Z", id)
+	assert.Equal("dir/file", path)
+
+	id, path, ok = az.splitSnapshotPath(".snapshots/2024-01-31T10:15:42.1234567Z/")
+	assert.True(ok)
+	assert.Equal("2024-01-31T10:15:42.1234567Z", id)
+	assert.Equal("", path)
+
+	id, path, ok = az.splitSnapshotPath(".snapshots")
+	assert.True(ok)
+	assert.Equal("", id)
+	assert.Equal("", path)
+
+	assert.False(az.isSnapshotPath(".snapshotsdir/file"))
+	assert.False(az.isSnapshotPath("dir/.snapshots"))
+	assert.True(az.isSnapshotPath(".snapshots/"))
+}
+
+func (s *utilsTestSuite) TestSnapshotDirAttr() {
+	assert := assert.New(s.T())
+
+	assert.True(validSnapshotID("2024-01-31T10:15:42.1234567Z"))
+	assert.False(validSnapshotID("latest"))
+
+	az := &AzStorage{}
+	az.stConfig.snapshotBrowsing = true
+
+	attr, err := az.getAttr(".snapshots")
+	assert.Nil(err)
+	assert.True(attr.IsDir())
+	assert.Equal(".snapshots", attr.Path)
+
+	attr, err = az.getAttr(".snapshots/2024-01-31T10:15:42.1234567Z")
+	assert.Nil(err)
+	assert.True(attr.IsDir())
+	assert.Equal(".snapshots/2024-01-31T10:15:42.1234567Z", attr.Path)
+
+	_, err = az.getAttr(".snapshots/latest")
+	assert.Equal(syscall.ENOENT, err)
+
+	_, err = az.readBuffer(".snapshots/2024-01-31T10:15:42.1234567Z", 0, 0)
+	assert.Equal(syscall.EISDIR, err)
+
+	attr = toSnapshotAttr("2024-01-31T10:15:42.1234567Z", &internal.ObjAttr{Path: "dir/file", Name: "file"})
+	assert.Equal(".snapshots/2024-01-31T10:15:42.1234567Z/dir/file", attr.Path)
+	assert.Equal("file", attr.Name)
+}
+
+func (s *utilsTestSuite) TestSnapshotIDPages() {
+	assert := assert.New(s.T())
+
+	ids := []string{"2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"}
+	page, marker := pageSnapshotIDs(ids, nil, 2)
+	assert.Equal(ids[:2], page)
+	assert.NotNil(marker)
+	assert.Equal(ids[1], *marker)
+
+	page, marker = pageSnapshotIDs(ids, marker, 2)
+	assert.Equal(ids[2:], page)
+	assert.Nil(marker)
+
+	page, marker = pageSnapshotIDs(ids, nil, 0)
+	assert.Equal(ids, page)
+	assert.Nil(marker)
+
+	// Ids listed before are served without listing the container again while they are fresh
+	az := &AzStorage{}
+	az.stConfig.snapshotBrowsing = true
+	az.snapshotIDs.ids = ids
+	az.snapshotIDs.listedAt = time.Now()
+
+	list, marker, err := az.list(".snapshots/", nil, 2)
+	assert.Nil(err)
+	assert.Len(list, 2)
+	assert.Equal(".snapshots/2024-01-01T00:00:00Z", list[0].Path)
+	assert.True(list[0].IsDir())
+	assert.NotNil(marker)
+
+	// Rest of an ongoing listing is served from the same ids even once they expire
+	az.snapshotIDs.listedAt = time.Now().Add(-2 * snapshotIDTimeout)
+	list, marker, err = az.list(".snapshots/", marker, 2)
+	assert.Nil(err)
+	assert.Len(list, 1)
+	assert.Equal(".snapshots/2024-03-01T00:00:00Z", list[0].Path)
+	assert.Nil(marker)
+}
+
+func (s *utilsTestSuite) TestKeepNewestSnapshotIDs() {
+	assert := assert.New(s.T())
+
+	found := map[string]bool{
+		"2024-03-01T00:00:00Z":         true,
+		"2024-01-01T00:00:00Z":         true,
+		"2024-02-01T10:15:42.1234567Z": true,
+	}
+	ids := keepNewestSnapshotIDs(found, 5)
+	assert.Equal([]string{"2024-01-01T00:00:00Z", "2024-02-01T10:15:42.1234567Z", "2024-03-01T00:00:00Z"}, ids)
+
+	// Oldest ids are dropped from the set as well, so that it stays small while the listing goes on
+	ids = keepNewestSnapshotIDs(found, 2)
+	assert.Equal([]string{"2024-02-01T10:15:42.1234567Z", "2024-03-01T00:00:00Z"}, ids)
+	assert.Len(found, 2)
+	assert.False(found["2024-01-01T00:00:00Z"])
+}
+
+func (s *utilsTestSuite) TestSnapshotReadOnly() {
+	assert := assert.New(s.T())
+
+	az := &AzStorage{}
+	az.stConfig.snapshotBrowsing = true
+
+	assert.Equal(syscall.EROFS, az.CreateDir(internal.CreateDirOptions{Name: ".snapshots/2024-01-31T10:15:42.1234567Z/dir"}))
+	assert.Equal(syscall.EROFS, az.DeleteFile(internal.DeleteFileOptions{Name: ".snapshots/2024-01-31T10:15:42.1234567Z/file"}))
+	assert.Equal(syscall.EROFS, az.RenameFile(internal.RenameFileOptions{Src: "file", Dst: ".snapshots/2024-01-31T10:15:42.1234567Z/file"}))
+	assert.Equal(syscall.EROFS, az.TruncateFile(internal.TruncateFileOptions{Name: ".snapshots/2024-01-31T10:15:42.1234567Z/file"}))
+
+	_, err := az.CreateFile(internal.CreateFileOptions{Name: ".snapshots/2024-01-31T10:15:42.1234567Z/file"})
+	assert.Equal(syscall.EROFS, err)
+
+	_, err = az.OpenFile(internal.OpenFileOptions{Name: ".snapshots/2024-01-31T10:15:42.1234567Z/file", Flags: os.O_RDWR})
+	assert.Equal(syscall.EROFS, err)
+}
+
+func (s *utilsTestSuite) TestGetRehydratePriorityType() {
+	assert := assert.New(s.T())
+
+	assert.Nil(getRehydratePriorityType(""))
+	assert.Nil(getRehydratePriorityType("urgent"))
+	assert.EqualValues(blob.RehydratePriorityHigh, *getRehydratePriorityType("High"))
+	assert.EqualValues(blob.RehydratePriorityStandard, *getRehydratePriorityType("standard"))
+}
+
+func (s *utilsTestSuite) TestBlobArchivedError() {
+	assert := assert.New(s.T())
+
+	err := &azcore.ResponseError{ErrorCode: string(bloberror.BlobArchived)}
+	assert.EqualValues(ErrBlobArchived, storeBlobErrToErr(err))
+
+	err = &azcore.ResponseError{ErrorCode: string(bloberror.BlobBeingRehydrated)}
+	assert.EqualValues(ErrBlobArchived, storeBlobErrToErr(err))
+}
+
+func (s *utilsTestSuite) TestTierXattr() {
+	assert := assert.New(s.T())
+
+	az := &AzStorage{}
+
+	// Tier attributes are never stored in metadata
+	assert.Equal(syscall.ENOTSUP, az.SetXattr(internal.SetXattrOptions{Name: "file", Attr: archiveStatusXattr, Value: []byte("rehydrate-pending-to-hot")}))
+	assert.Equal(syscall.ENOTSUP, az.RemoveXattr(internal.RemoveXattrOptions{Name: "file", Attr: tierXattr}))
+	assert.Equal(syscall.ENOTSUP, az.RemoveXattr(internal.RemoveXattrOptions{Name: "file", Attr: archiveStatusXattr}))
+
+	// Invalid tiers are rejected before reaching storage
+	assert.Equal(syscall.EINVAL, az.SetXattr(internal.SetXattrOptions{Name: "file", Attr: tierXattr, Value: []byte("warm")}))
+	assert.Equal(syscall.EINVAL, az.SetXattr(internal.SetXattrOptions{Name: "file", Attr: tierXattr, Value: []byte("")}))
+}
+
 func TestUtilsTestSuite(t *testing.T) {
 	suite.Run(t, new(utilsTestSuite))
 }
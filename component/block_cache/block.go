@@ -49,6 +49,7 @@ const (
 	BlockFlagDirty              // Block has been written and data is not persisted yet
 	BlockFlagSynced             // Block has been written and data is persisted
 	BlockFlagFailed             // Block upload/download has failed
+	BlockFlagStale              // Block download failed as the blob changed since its ETag was read
 )
 
 // Flags to denote the status of upload/download of a block
@@ -34,8 +34,10 @@
 package block_cache
 
 import (
+	"bytes"
 	"container/list"
 	"context"
+	"crypto/md5"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -46,6 +48,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -53,7 +56,10 @@ import (
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/shared_cache"
+	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 	"github.com/vibhansa-msft/tlru"
 )
 
@@ -68,37 +74,52 @@ import (
 type BlockCache struct {
 	internal.BaseComponent
 
-	blockSize       uint64          // Size of each block to be cached
-	memSize         uint64          // Mem size to be used for caching at the startup
-	mntPath         string          // Mount path
-	tmpPath         string          // Disk path where these blocks will be cached
-	diskSize        uint64          // Size of disk space allocated for the caching
-	diskTimeout     uint32          // Timeout for which disk blocks will be cached
-	workers         uint32          // Number of threads working to fetch the blocks
-	prefetch        uint32          // Number of blocks to be prefetched
-	diskPolicy      *tlru.TLRU      // Disk cache eviction policy
-	blockPool       *BlockPool      // Pool of blocks
-	threadPool      *ThreadPool     // Pool of threads
-	fileLocks       *common.LockMap // Locks for each file_blockid to avoid multiple threads to fetch same block
-	fileNodeMap     sync.Map        // Map holding files that are there in our cache
-	maxDiskUsageHit bool            // Flag to indicate if we have hit max disk usage
-	noPrefetch      bool            // Flag to indicate if prefetch is disabled
-	prefetchOnOpen  bool            // Start prefetching on file open call instead of waiting for first read
-	stream          *Stream
-	lazyWrite       bool           // Flag to indicate if lazy write is enabled
-	fileCloseOpt    sync.WaitGroup // Wait group to wait for all async close operations to complete
+	blockSize        uint64          // Size of each block to be cached
+	memSize          uint64          // Mem size to be used for caching at the startup
+	mntPath          string          // Mount path
+	tmpPath          string          // Disk path where these blocks will be cached
+	diskSize         uint64          // Size of disk space allocated for the caching
+	diskTimeout      uint32          // Timeout for which disk blocks will be cached
+	workers          uint32          // Number of threads working to fetch the blocks
+	prefetch         uint32          // Number of blocks to be prefetched
+	diskPolicy       *tlru.TLRU      // Disk cache eviction policy
+	blockPool        *BlockPool      // Pool of blocks
+	threadPool       *ThreadPool     // Pool of threads
+	fileLocks        *common.LockMap // Locks for each file_blockid to avoid multiple threads to fetch same block
+	fileNodeMap      sync.Map        // Map holding files that are there in our cache
+	maxDiskUsageHit  bool            // Flag to indicate if we have hit max disk usage
+	noPrefetch       bool            // Flag to indicate if prefetch is disabled
+	prefetchOnOpen   bool            // Start prefetching on file open call instead of waiting for first read
+	stream           *Stream
+	lazyWrite        bool                    // Flag to indicate if lazy write is enabled
+	fileCloseOpt     sync.WaitGroup          // Wait group to wait for all async close operations to complete
+	leaseLock        bool                    // Flag to indicate if files opened for write shall be leased in storage
+	leases           *internal.LeaseKeeper   // Leases held on files opened for write
+	diskIndex        *diskIndex              // Index of blocks on disk, kept across mounts if disk-persist is set
+	sharedCache      *shared_cache.Client    // Host level cache shared with other mounts
+	sharedNamespace  string                  // Prefix of block keys in shared cache, same for all mounts of a container
+	validateChecksum bool                    // Flag to indicate if blocks are checksummed on upload and verified on download
+	journal          *journal                // Journal of uploads pending with lazy-write
+	classes          *cache_class.Classifier // Classes of paths which are pinned, evicted first or not cached
+	pinnedBlocks     sync.Map                // Disk blocks of pinned files, kept out of the disk policy so they are not evicted
 }
 
 // Structure defining your config parameters
 type BlockCacheOptions struct {
-	BlockSize      float64 `config:"block-size-mb" yaml:"block-size-mb,omitempty"`
-	MemSize        uint64  `config:"mem-size-mb" yaml:"mem-size-mb,omitempty"`
-	TmpPath        string  `config:"path" yaml:"path,omitempty"`
-	DiskSize       uint64  `config:"disk-size-mb" yaml:"disk-size-mb,omitempty"`
-	DiskTimeout    uint32  `config:"disk-timeout-sec" yaml:"timeout-sec,omitempty"`
-	PrefetchCount  uint32  `config:"prefetch" yaml:"prefetch,omitempty"`
-	Workers        uint32  `config:"parallelism" yaml:"parallelism,omitempty"`
-	PrefetchOnOpen bool    `config:"prefetch-on-open" yaml:"prefetch-on-open,omitempty"`
+	BlockSize        float64 `config:"block-size-mb" yaml:"block-size-mb,omitempty"`
+	MemSize          uint64  `config:"mem-size-mb" yaml:"mem-size-mb,omitempty"`
+	TmpPath          string  `config:"path" yaml:"path,omitempty"`
+	DiskSize         uint64  `config:"disk-size-mb" yaml:"disk-size-mb,omitempty"`
+	DiskTimeout      uint32  `config:"disk-timeout-sec" yaml:"timeout-sec,omitempty"`
+	PrefetchCount    uint32  `config:"prefetch" yaml:"prefetch,omitempty"`
+	Workers          uint32  `config:"parallelism" yaml:"parallelism,omitempty"`
+	PrefetchOnOpen   bool    `config:"prefetch-on-open" yaml:"prefetch-on-open,omitempty"`
+	LeaseLock        bool    `config:"lease-lock" yaml:"lease-lock,omitempty"`
+	DiskPersist      bool    `config:"disk-persist" yaml:"disk-persist,omitempty"`
+	SharedCache      string  `config:"shared-cache" yaml:"shared-cache,omitempty"`
+	SharedNamespace  string  `config:"shared-cache-namespace" yaml:"shared-cache-namespace,omitempty"`
+	ValidateChecksum bool    `config:"validate-checksum" yaml:"validate-checksum,omitempty"`
+	JournalPath      string  `config:"journal-path" yaml:"journal-path,omitempty"`
 }
 
 const (
@@ -117,6 +138,8 @@ const (
 // Verification to check satisfaction criteria with Component Interface
 var _ internal.Component = &BlockCache{}
 
+var blockCacheStatsCollector *stats_manager.StatsCollector
+
 func (bc *BlockCache) Name() string {
 	return compName
 }
@@ -145,8 +168,27 @@ func (bc *BlockCache) Start(ctx context.Context) error {
 			log.Err("BlockCache::Start : failed to start diskpolicy [%s]", err.Error())
 			return fmt.Errorf("failed to start  disk-policy for block-cache")
 		}
+
+		if bc.diskIndex != nil {
+			// Blocks left behind by last mount which are still valid go back under the eviction policy
+			for _, fileName := range bc.diskIndex.load() {
+				bc.fileNodeMap.Store(fileName, bc.diskPolicy.Add(fileName))
+			}
+			bc.diskIndex.Start()
+		}
 	}
 
+	if bc.leaseLock {
+		bc.leases = internal.NewLeaseKeeper(bc.NextComponent())
+		bc.leases.Start()
+	}
+
+	blockCacheStatsCollector = stats_manager.NewStatsCollector(bc.Name())
+
+	if bc.journal != nil {
+		// Uploads left pending by last mount go to storage before anything else is done with those files
+		bc.replayJournal()
+	}
 	return nil
 }
 
@@ -160,15 +202,29 @@ func (bc *BlockCache) Stop() error {
 		bc.fileCloseOpt.Wait()
 	}
 
+	if bc.leases != nil {
+		bc.leases.Stop()
+	}
+
 	// Wait for thread pool to stop
 	bc.threadPool.Stop()
 
-	// Clear the disk cache on exit
+	if bc.sharedCache != nil {
+		bc.sharedCache.Close()
+	}
+
+	// Clear the disk cache on exit, unless it is to be used by next mount
 	if bc.tmpPath != "" {
-		_ = bc.diskPolicy.Stop()
-		_ = common.TempCacheCleanup(bc.tmpPath)
+		if bc.diskIndex != nil {
+			bc.diskIndex.Stop()
+			_ = bc.diskPolicy.Stop()
+		} else {
+			_ = bc.diskPolicy.Stop()
+			_ = common.TempCacheCleanup(bc.tmpPath)
+		}
 	}
 
+	blockCacheStatsCollector.Destroy()
 	return nil
 }
 
@@ -238,6 +294,8 @@ func (bc *BlockCache) Configure(_ bool) error {
 	}
 
 	bc.prefetchOnOpen = conf.PrefetchOnOpen
+	bc.leaseLock = conf.LeaseLock
+	bc.validateChecksum = conf.ValidateChecksum
 	bc.prefetch = uint32(math.Max((MIN_PREFETCH*2)+1, (float64)(2*runtime.NumCPU())))
 	bc.noPrefetch = false
 
@@ -294,7 +352,9 @@ func (bc *BlockCache) Configure(_ bool) error {
 			}
 		}
 
-		if !common.IsDirectoryEmpty(bc.tmpPath) {
+		// Directory can have blocks of last mount only if they are to be persisted
+		_, err = os.Stat(filepath.Join(bc.tmpPath, diskIndexName))
+		if !common.IsDirectoryEmpty(bc.tmpPath) && !(conf.DiskPersist && err == nil) {
 			log.Err("BlockCache: config error %s directory is not empty", bc.tmpPath)
 			return fmt.Errorf("config error in %s [%s]", bc.Name(), "temp directory not empty")
 		}
@@ -305,6 +365,25 @@ func (bc *BlockCache) Configure(_ bool) error {
 		}
 	}
 
+	bc.classes, err = cache_class.NewFromConfig()
+	if err != nil {
+		log.Err("BlockCache::Configure : config error [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", bc.Name(), err.Error())
+	}
+
+	if conf.JournalPath != "" {
+		err = bc.configureJournal(common.ExpandPath(conf.JournalPath))
+		if err != nil {
+			log.Err("BlockCache::Configure : config error [%s]", err.Error())
+			return fmt.Errorf("config error in %s [%s]", bc.Name(), err.Error())
+		}
+	}
+
+	if conf.SharedCache != "" && !config.IsSet(compName+".mem-size-mb") {
+		// Blocks are cached in the shared cache, each mount only needs blocks for prefetch and writes in progress
+		bc.memSize = min(bc.memSize, uint64(2*max(bc.prefetch, MIN_PREFETCH*2+1)+1)*bc.blockSize)
+	}
+
 	if (uint64(bc.prefetch) * uint64(bc.blockSize)) > bc.memSize {
 		log.Err("BlockCache::Configure : config error [memory limit too low for configured prefetch]")
 		return fmt.Errorf("config error in %s [memory limit too low for configured prefetch]", bc.Name())
@@ -328,10 +407,25 @@ func (bc *BlockCache) Configure(_ bool) error {
 			log.Err("BlockCache::Configure : fail to create LRU for memory nodes [%s]", err.Error())
 			return fmt.Errorf("config error in %s [%s]", bc.Name(), err.Error())
 		}
+
+		if conf.DiskPersist {
+			bc.diskIndex = newDiskIndex(bc.tmpPath, bc.blockSize)
+		}
+	} else if conf.DiskPersist {
+		log.Err("BlockCache::Configure : config error [disk-persist requires disk cache path]")
+		return fmt.Errorf("config error in %s [disk-persist requires disk cache path]", bc.Name())
+	}
+
+	if conf.SharedCache != "" {
+		bc.sharedNamespace = conf.SharedNamespace
+		if bc.sharedNamespace == "" {
+			bc.sharedNamespace = getSharedNamespace()
+		}
+		bc.sharedCache = shared_cache.NewClient(common.ExpandPath(conf.SharedCache), bc.workers)
 	}
 
-	log.Crit("BlockCache::Configure : block size %v, mem size %v, worker %v, prefetch %v, disk path %v, max size %v, disk timeout %v, prefetch-on-open %t, maxDiskUsageHit %v, noPrefetch %v",
-		bc.blockSize, bc.memSize, bc.workers, bc.prefetch, bc.tmpPath, bc.diskSize, bc.diskTimeout, bc.prefetchOnOpen, bc.maxDiskUsageHit, bc.noPrefetch)
+	log.Crit("BlockCache::Configure : block size %v, mem size %v, worker %v, prefetch %v, disk path %v, max size %v, disk timeout %v, prefetch-on-open %t, maxDiskUsageHit %v, noPrefetch %v, lease-lock %t, disk-persist %t, shared-cache %v, shared-cache-namespace %v, validate-checksum %t, journal %v",
+		bc.blockSize, bc.memSize, bc.workers, bc.prefetch, bc.tmpPath, bc.diskSize, bc.diskTimeout, bc.prefetchOnOpen, bc.maxDiskUsageHit, bc.noPrefetch, bc.leaseLock, bc.diskIndex != nil, conf.SharedCache, bc.sharedNamespace, bc.validateChecksum, conf.JournalPath)
 
 	return nil
 }
@@ -369,10 +463,26 @@ func (bc *BlockCache) CreateFile(options internal.CreateFileOptions) (*handlemap
 		return nil, err
 	}
 
+	if bc.diskIndex != nil {
+		// Blocks of an earlier file by this name are of no use now
+		bc.diskIndex.forget(options.Name)
+		bc.removeDiskBlocks(options.Name)
+	}
+
 	handle := handlemap.NewHandle(options.Name)
 	handle.Size = 0
 	handle.Mtime = time.Now()
 
+	if bc.leaseLock {
+		// File is created in storage so it can be locked right away, failing to do so is not fatal
+		err = bc.leases.Acquire(options.Name)
+		if err != nil {
+			log.Warn("BlockCache::CreateFile : Failed to lease %s [%s]", options.Name, err.Error())
+		} else {
+			handle.Flags.Set(handlemap.HandleFlagLeased)
+		}
+	}
+
 	// As file is created on storage as well there is no need to mark this as dirty
 	// Any write operation to file will mark it dirty and flush will then reupload
 	// handle.Flags.Set(handlemap.HandleFlagDirty)
@@ -384,19 +494,48 @@ func (bc *BlockCache) CreateFile(options internal.CreateFileOptions) (*handlemap
 func (bc *BlockCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Handle, error) {
 	log.Trace("BlockCache::OpenFile : name=%s, flags=%d, mode=%s", options.Name, options.Flags, options.Mode)
 
+	// Lock the file across mounts before reading its properties so that no one else modifies it while it is open for write
+	leased, opened := false, false
+	if bc.leaseLock && options.Flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		err := bc.leases.Acquire(options.Name)
+		if err != nil {
+			log.Err("BlockCache::OpenFile : Failed to lease %s [%s]", options.Name, err.Error())
+			return nil, err
+		}
+
+		leased = true
+		defer func() {
+			if !opened {
+				_ = bc.leases.Release(options.Name)
+			}
+		}()
+	}
+
 	attr, err := bc.NextComponent().GetAttr(internal.GetAttrOptions{Name: options.Name})
 	if err != nil {
 		log.Err("BlockCache::OpenFile : Failed to get attr of %s [%s]", options.Name, err.Error())
 		return nil, err
 	}
 
+	if bc.diskIndex != nil && !bc.diskIndex.validate(options.Name, attr.ETag, attr.Mtime, attr.Size) {
+		// Blob has changed since its blocks were cached on disk
+		log.Debug("BlockCache::OpenFile : Removing blocks of %s cached on disk", options.Name)
+		bc.removeDiskBlocks(options.Name)
+	}
+
 	handle := handlemap.NewHandle(options.Name)
+	if leased {
+		handle.Flags.Set(handlemap.HandleFlagLeased)
+	}
 	handle.Mtime = attr.Mtime
 	handle.Size = attr.Size
 
 	log.Debug("BlockCache::OpenFile : Size of file handle.Size %v", handle.Size)
 	bc.prepareHandleForBlockCache(handle)
 
+	// Commit of this handle shall succeed only if the blob is still the one seen at open
+	setHandleETag(handle, attr.ETag)
+
 	if options.Flags&os.O_TRUNC != 0 || (options.Flags&os.O_WRONLY != 0 && options.Flags&os.O_APPEND == 0) {
 		// If file is opened in truncate or wronly mode then we need to wipe out the data consider current file size as 0
 		log.Debug("BlockCache::OpenFile : Truncate %v to 0", options.Name)
@@ -424,11 +563,13 @@ func (bc *BlockCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Han
 			// File is small and can fit in one block itself
 			_ = bc.refreshBlock(handle, 0, false)
 		} else if bc.prefetchOnOpen && !bc.noPrefetch {
-			// Prefetch to start on open
+			// Prefetch to start on open, as if the first read is at offset 0
+			getPrefetcher(handle).observe(0)
 			_ = bc.startPrefetch(handle, 0, false)
 		}
 	}
 
+	opened = true
 	return handle, nil
 }
 
@@ -467,9 +608,8 @@ func (bc *BlockCache) prepareHandleForBlockCache(handle *handlemap.Handle) {
 	listMap := make(map[int64]*blockInfo, 0)
 	handle.SetValue("blockList", listMap)
 
-	// Set next offset to download as 0
-	// We may not download this if first read starts with some other offset
-	handle.SetValue("#", (uint64)(0))
+	// Access pattern detector deciding what to prefetch for this handle
+	handle.SetValue("prefetcher", newPrefetcher())
 }
 
 // FlushFile: Flush the local file to storage
@@ -505,6 +645,15 @@ func (bc *BlockCache) CloseFile(options internal.CloseFileOptions) error {
 		return bc.closeFileInternal(options)
 	}
 
+	if bc.journal != nil && options.Handle.Dirty() {
+		// Data has to be durable before close returns, as it goes to storage only after that
+		err := bc.journalHandle(options.Handle)
+		if err != nil {
+			log.Err("BlockCache::CloseFile : Failed to journal %s, uploading it before close returns [%s]", options.Handle.Path, err.Error())
+			return bc.closeFileInternal(options)
+		}
+	}
+
 	// Async close is called so schedule the upload and return here
 	go bc.closeFileInternal(options) //nolint
 	return nil
@@ -516,15 +665,37 @@ func (bc *BlockCache) closeFileInternal(options internal.CloseFileOptions) error
 
 	defer bc.fileCloseOpt.Done()
 
+	journalID, journaled := options.Handle.GetValue("journal")
+
+	// Lease is released once the last commit is done, even if it fails, so that the file is not locked forever
+	if options.Handle.Leased() {
+		defer func() {
+			err := bc.leases.Release(options.Handle.Path)
+			if err != nil {
+				log.Err("BlockCache::CloseFile : failed to release lease on %s [%s]", options.Handle.Path, err.Error())
+			}
+		}()
+	}
+
 	if options.Handle.Dirty() {
 		log.Info("BlockCache::CloseFile : name=%s, handle=%d dirty. Flushing the file.", options.Handle.Path, options.Handle.ID)
 		err := bc.FlushFile(internal.FlushFileOptions{Handle: options.Handle, CloseInProgress: true}) //nolint
 		if err != nil {
 			log.Err("BlockCache::CloseFile : failed to flush file %s", options.Handle.Path)
+			if journaled {
+				log.Err("BlockCache::CloseFile : %s is kept in journal as %s, it will be uploaded on next mount", options.Handle.Path, journalID)
+			}
 			return err
 		}
 	}
 
+	if journaled {
+		err := bc.journal.remove(journalID.(string))
+		if err != nil {
+			log.Err("BlockCache::CloseFile : Failed to remove journal entry %s of %s [%s]", journalID, options.Handle.Path, err.Error())
+		}
+	}
+
 	// Release the blocks that are in use and wipe out handle map
 	options.Handle.Cleanup()
 
@@ -591,6 +762,7 @@ func (bc *BlockCache) ReadInBuffer(options internal.ReadInBufferOptions) (int, e
 		blockSize := bc.getBlockSize(uint64(options.Handle.Size), block)
 
 		bytesRead := copy(options.Data[dataRead:], block.data[readOffset:blockSize])
+		getPrefetcher(options.Handle).account(bytesRead)
 
 		// Move offset forward in case we need to copy more data
 		options.Offset += int64(bytesRead)
@@ -645,6 +817,12 @@ func (bc *BlockCache) getBlock(handle *handlemap.Handle, readoffset uint64) (*Bl
 
 	// Check the given block index is already available or not
 	index := bc.getBlockIndex(readoffset)
+
+	// Track the access pattern to know what to prefetch next
+	pf := getPrefetcher(handle)
+	pf.observe(int64(index))
+	bc.reportPattern(handle, pf)
+
 	node, found := handle.GetValue(fmt.Sprintf("%v", index))
 	if !found {
 
@@ -661,29 +839,13 @@ func (bc *BlockCache) getBlock(handle *handlemap.Handle, readoffset uint64) (*Bl
 			}
 		}
 
-		// If this is the first read request then prefetch all required nodes
-		val, _ := handle.GetValue("#")
-		if !bc.noPrefetch && val.(uint64) == 0 {
-			log.Debug("BlockCache::getBlock : Starting the prefetch %v=>%s (offset %v, index %v)", handle.ID, handle.Path, readoffset, index)
-
-			// This is the first read for this file handle so start prefetching all the nodes
-			err := bc.startPrefetch(handle, index, false)
-			if err != nil && err != io.EOF {
-				log.Err("BlockCache::getBlock : Unable to start prefetch  %v=>%s (offset %v, index %v) [%s]", handle.ID, handle.Path, readoffset, index, err.Error())
-				return nil, err
-			}
-		} else {
-			// This is a case of random read so increment the random read count
-			handle.OptCnt++
-
-			log.Debug("BlockCache::getBlock : Unable to get block %v=>%s (offset %v, index %v) Random %v", handle.ID, handle.Path, readoffset, index, handle.OptCnt)
+		log.Debug("BlockCache::getBlock : Unable to get block %v=>%s (offset %v, index %v) pattern %s", handle.ID, handle.Path, readoffset, index, pf.pattern)
 
-			// This block is not present even after prefetch so lets download it now
-			err := bc.startPrefetch(handle, index, false)
-			if err != nil && err != io.EOF {
-				log.Err("BlockCache::getBlock : Unable to start prefetch  %v=>%s (offset %v, index %v) [%s]", handle.ID, handle.Path, readoffset, index, err.Error())
-				return nil, err
-			}
+		// This block is not present even after prefetch so lets download it now, along with the blocks to be read next
+		err := bc.startPrefetch(handle, index, false)
+		if err != nil && err != io.EOF {
+			log.Err("BlockCache::getBlock : Unable to start prefetch  %v=>%s (offset %v, index %v) [%s]", handle.ID, handle.Path, readoffset, index, err.Error())
+			return nil, err
 		}
 
 		// This node was not found so above logic should have queued it up, retry searching now
@@ -709,13 +871,9 @@ func (bc *BlockCache) getBlock(handle *handlemap.Handle, readoffset uint64) (*Bl
 
 			block.flags.Clear(BlockFlagDownloading)
 
-			// Download complete and you are first reader of this block
-			if !bc.noPrefetch && handle.OptCnt <= MIN_RANDREAD {
-				// So far this file has been read sequentially so prefetch more
-				val, _ := handle.GetValue("#")
-				if int64(val.(uint64)*bc.blockSize) < handle.Size {
-					_ = bc.startPrefetch(handle, val.(uint64), true)
-				}
+			// Download complete and you are first reader of this block, so prefetch more for the stream reading it
+			if !bc.noPrefetch && !pf.random() {
+				_ = bc.startPrefetch(handle, index, true)
 			}
 
 			// This block was moved to in-process queue as download is complete lets move it back to normal queue
@@ -733,8 +891,7 @@ func (bc *BlockCache) getBlock(handle *handlemap.Handle, readoffset uint64) (*Bl
 			log.Err("BlockCache::getBlock : Failed to download block %v for %v=>%s (read offset %v)", index, handle.ID, handle.Path, readoffset)
 
 			// Remove this node from handle so that next read retries to download the block again
-			bc.releaseDownloadFailedBlock(handle, block)
-			return nil, fmt.Errorf("failed to download block")
+			return nil, bc.releaseFailedDownload(handle, block)
 
 		case BlockStatusUploadFailed:
 			// Local data is still valid so continue using this buffer
@@ -754,117 +911,208 @@ func (bc *BlockCache) getBlockIndex(offset uint64) uint64 {
 	return offset / bc.blockSize
 }
 
-// startPrefetch: Start prefetchign the blocks from given offset. Same method is used to download currently required block as well
+// startPrefetch: Start prefetchign the blocks for the stream being read. Same method is used to download currently required block as well
 func (bc *BlockCache) startPrefetch(handle *handlemap.Handle, index uint64, prefetch bool) error {
+	pf := getPrefetcher(handle)
+
 	// Calculate how many buffers we have in free and in-process queue
 	currentCnt := handle.Buffers.Cooked.Len() + handle.Buffers.Cooking.Len()
-	cnt := uint32(0)
-
-	if handle.OptCnt > MIN_RANDREAD {
-		// This handle has been read randomly and we have reached the threshold to declare a random read case
-
-		if currentCnt > MIN_PREFETCH {
-			// As this file is in random read mode now, release the excess buffers. Just keep 5 buffers for it to work
-			log.Info("BlockCache::startPrefetch : Cleanup excessive blocks  %v=>%s index %v", handle.ID, handle.Path, index)
-
-			// As this is random read move all in process blocks to free list
-			nodeList := handle.Buffers.Cooking
-			currentCnt = nodeList.Len()
-			node := nodeList.Front()
-
-			for i := 0; node != nil && i < currentCnt; node = nodeList.Front() {
-				// Test whether this block is already downloaded or still under download
-				block := handle.Buffers.Cooking.Remove(node).(*Block)
-				block.node = nil
-				i++
-				//This list may contain dirty blocks which are yet to be committed.
-				select {
-				case _, ok := <-block.state:
-					// As we are first reader of this block here its important to unblock any future readers on this block
-					if ok {
-						block.flags.Clear(BlockFlagDownloading)
-						block.Unblock()
-						// Block is downloaded so it's safe to ready it for reuse
-						block.node = handle.Buffers.Cooked.PushBack(block)
-					} else {
-						block.node = handle.Buffers.Cooking.PushBack(block)
-					}
+	budget := bc.prefetchBudget()
 
-				default:
-					// Block is still under download so can not reuse this
-					block.node = handle.Buffers.Cooking.PushBack(block)
-				}
-			}
+	if !bc.noPrefetch && (pf.random() || uint32(currentCnt) > budget) && currentCnt > MIN_PREFETCH {
+		// Either this handle is being read randomly or the block pool is running out, release the excess buffers.
+		// Just keep 5 buffers for it to work
+		log.Info("BlockCache::startPrefetch : Cleanup excessive blocks  %v=>%s index %v pattern %s", handle.ID, handle.Path, index, pf.pattern)
+		bc.releaseBuffers(handle, MIN_PREFETCH)
+		currentCnt = handle.Buffers.Cooked.Len() + handle.Buffers.Cooking.Len()
+	}
 
-			// Now remove excess blocks from cooked list
-			nodeList = handle.Buffers.Cooked
-			currentCnt = nodeList.Len()
-			node = nodeList.Front()
+	stream := pf.current
+	if bc.noPrefetch || pf.random() || stream == nil || !pf.active(stream) {
+		// As we were asked to download a block, download only the requested block as there is nothing known to be read next
+		if prefetch {
+			return nil
+		}
+		return bc.lineupBlock(handle, index, false)
+	}
 
-			for ; node != nil && currentCnt > MIN_PREFETCH; node = nodeList.Front() {
-				block := node.Value.(*Block)
-				_ = nodeList.Remove(node)
+	// Allocate more buffers if required until we hit the prefetch budget
+	for cnt := 0; uint32(currentCnt) < budget && cnt < MIN_PREFETCH; currentCnt++ {
+		block := bc.blockPool.TryGet()
+		if block == nil {
+			break
+		}
+		block.node = handle.Buffers.Cooked.PushFront(block)
+		cnt++
+	}
 
-				// Remove entry of this block from map so that no one can find it
-				handle.RemoveValue(fmt.Sprintf("%v", block.id))
-				block.node = nil
+	if !prefetch {
+		err := bc.lineupBlock(handle, index, false)
+		if err != nil {
+			return err
+		}
+	}
 
-				// Submit this block back to pool for reuse
-				block.ReUse()
-				bc.blockPool.Release(block)
+	// Lineup the blocks this stream is going to read next till it has depth number of blocks ahead of the reader.
+	// Once all buffers are in use this becomes a sliding window where consumed blocks are reused for next blocks.
+	depth := int64(pf.depth(stream, budget))
+	lined := 0
+	for (stream.next-stream.last)/stream.stride <= depth {
+		next := stream.next
+		if next < 0 || next*int64(bc.blockSize) >= handle.Size {
+			break
+		}
 
-				currentCnt--
+		if _, found := handle.GetValue(fmt.Sprintf("%v", next)); !found {
+			err := bc.lineupBlock(handle, uint64(next), true)
+			if err != nil {
+				return err
 			}
-		}
-		// As we were asked to download a block, for random read case download only the requested block
-		// This is where prefetching is blocked now as we download just the block which is requested
-		cnt = 1
-	} else {
-		// This handle is having sequential reads so far
-		// Allocate more buffers if required until we hit the prefetch count limit
-		for ; currentCnt < int(bc.prefetch) && cnt < MIN_PREFETCH; currentCnt++ {
-			block := bc.blockPool.TryGet()
-			if block != nil {
-				block.node = handle.Buffers.Cooked.PushFront(block)
-				cnt++
+
+			if _, found = handle.GetValue(fmt.Sprintf("%v", next)); !found {
+				// No free buffer left for now
+				break
 			}
+			lined++
 		}
 
-		// If no new buffers were allocated then we have all buffers allocated to this handle already
-		// time to switch to a sliding window where we remove one block and lineup a new block for download
-		if cnt == 0 {
-			cnt = 1
+		stream.next += stream.stride
+	}
+
+	if lined > 0 {
+		blockCacheStatsCollector.UpdateStats(stats_manager.Increment, prefetchedBlocks, int64(lined))
+	}
+
+	return nil
+}
+
+// lineupBlock: Download the block, committing the staged blocks first if it is one of them
+func (bc *BlockCache) lineupBlock(handle *handlemap.Handle, index uint64, prefetch bool) error {
+	if _, found := handle.GetValue(fmt.Sprintf("%v", index)); found {
+		return nil
+	}
+
+	// Check if the block is an uncommitted block or not
+	// For uncommitted block we need to commit the block first
+	shouldCommit, _ := shouldCommitAndDownload(int64(index), handle)
+	if shouldCommit && prefetch {
+		// Do not commit the file just because the block may be read
+		return nil
+	} else if shouldCommit {
+		// This shall happen only for the first uncommitted block and shall flush all the uncommitted blocks to storage
+		log.Debug("BlockCache::lineupBlock : Fetching an uncommitted block %v, so committing all the staged blocks for %v=>%s", index, handle.ID, handle.Path)
+		err := bc.commitBlocks(handle)
+		if err != nil {
+			log.Err("BlockCache::lineupBlock : Failed to commit blocks for %v=>%s [%s]", handle.ID, handle.Path, err.Error())
+			return err
 		}
 	}
 
-	for i := uint32(0); i < cnt; i++ {
-		// Check if the block exists in the local cache or not
-		// If not, download the block from storage
-		_, found := handle.GetValue(fmt.Sprintf("%v", index))
-		if !found {
-			// Check if the block is an uncommitted block or not
-			// For uncommitted block we need to commit the block first
-			shouldCommit, _ := shouldCommitAndDownload(int64(index), handle)
-			if shouldCommit {
-				// This shall happen only for the first uncommitted block and shall flush all the uncommitted blocks to storage
-				log.Debug("BlockCache::startPrefetch : Fetching an uncommitted block %v, so committing all the staged blocks for %v=>%s", index, handle.ID, handle.Path)
-				err := bc.commitBlocks(handle)
-				if err != nil {
-					log.Err("BlockCache::startPrefetch : Failed to commit blocks for %v=>%s [%s]", handle.ID, handle.Path, err.Error())
-					return err
-				}
-			}
+	// push the block for download
+	return bc.refreshBlock(handle, index, prefetch)
+}
 
-			// push the block for download
-			err := bc.refreshBlock(handle, index, prefetch || i > 0)
-			if err != nil {
-				return err
+// prefetchBudget: Number of buffers a handle can use, cut down when the block pool is running out of buffers
+func (bc *BlockCache) prefetchBudget() uint32 {
+	if bc.blockPool.Usage() >= MAX_POOL_USAGE {
+		return MIN_PREFETCH
+	}
+	return bc.prefetch
+}
+
+// getPrefetcher: Access pattern detector of the handle
+func getPrefetcher(handle *handlemap.Handle) *prefetcher {
+	val, found := handle.GetValue("prefetcher")
+	if !found {
+		pf := newPrefetcher()
+		handle.SetValue("prefetcher", pf)
+		return pf
+	}
+	return val.(*prefetcher)
+}
+
+// reportPattern: Publish the access pattern of the handle when it changes
+func (bc *BlockCache) reportPattern(handle *handlemap.Handle, pf *prefetcher) {
+	pattern := pf.classify()
+	if pattern == pf.pattern {
+		return
+	}
+
+	budget := bc.prefetchBudget()
+	log.Debug("BlockCache::reportPattern : %v=>%s is read in %s pattern, %v streams, prefetch budget %v", handle.ID, handle.Path, pattern, len(pf.streams), budget)
+	pf.pattern = pattern
+
+	blockCacheStatsCollector.PushEvents(patternChanged, handle.Path, map[string]interface{}{patternKey: pattern.String(), streamsKey: len(pf.streams), budgetKey: budget})
+	blockCacheStatsCollector.UpdateStats(stats_manager.Increment, fmt.Sprintf("%s %s", pattern, patternDetected), (int64)(1))
+}
+
+// releaseBuffers: Give the buffers of this handle back to the pool, keeping the given number of them
+func (bc *BlockCache) releaseBuffers(handle *handlemap.Handle, keep int) {
+	// Move all the blocks which are downloaded to free list
+	nodeList := handle.Buffers.Cooking
+	currentCnt := nodeList.Len()
+	node := nodeList.Front()
+
+	for i := 0; node != nil && i < currentCnt; node = nodeList.Front() {
+		// Test whether this block is already downloaded or still under download
+		block := handle.Buffers.Cooking.Remove(node).(*Block)
+		block.node = nil
+		i++
+		//This list may contain dirty blocks which are yet to be committed.
+		select {
+		case _, ok := <-block.state:
+			// As we are first reader of this block here its important to unblock any future readers on this block
+			if ok {
+				block.flags.Clear(BlockFlagDownloading)
+				block.Unblock()
+				// Block is downloaded so it's safe to ready it for reuse
+				block.node = handle.Buffers.Cooked.PushBack(block)
+			} else {
+				block.node = handle.Buffers.Cooking.PushBack(block)
 			}
-			index++
+
+		default:
+			// Block is still under download so can not reuse this
+			block.node = handle.Buffers.Cooking.PushBack(block)
 		}
 	}
 
-	return nil
+	// Now remove excess blocks from cooked list
+	nodeList = handle.Buffers.Cooked
+	currentCnt = nodeList.Len()
+	node = nodeList.Front()
+
+	for ; node != nil && currentCnt > keep; node = nodeList.Front() {
+		block := node.Value.(*Block)
+		_ = nodeList.Remove(node)
+
+		// Block may still be under upload and its buffer can not be reused till the upload is over
+		if block.flags.IsSet(BlockFlagUploading) {
+			_, ok := <-block.state
+			if ok {
+				block.Unblock()
+			}
+			block.flags.Clear(BlockFlagUploading)
+
+			if block.IsFailed() {
+				// Data is not staged, keep the block so that next commit retries the upload
+				log.Err("BlockCache::releaseBuffers : Failed to upload block, posting back to cooking list %v=>%s (index %v, offset %v)", handle.ID, handle.Path, block.id, block.offset)
+				block.node = handle.Buffers.Cooking.PushBack(block)
+				currentCnt--
+				continue
+			}
+		}
+
+		// Remove entry of this block from map so that no one can find it
+		handle.RemoveValue(fmt.Sprintf("%v", block.id))
+		block.node = nil
+
+		// Submit this block back to pool for reuse
+		block.ReUse()
+		bc.blockPool.Release(block)
+
+		currentCnt--
+	}
 }
 
 // refreshBlock: Get a block from the list and prepare it for download
@@ -918,7 +1166,6 @@ func (bc *BlockCache) refreshBlock(handle *handlemap.Handle, index uint64, prefe
 
 		// Add this entry to handle map so that others can refer to the same block if required
 		handle.SetValue(fmt.Sprintf("%v", index), block)
-		handle.SetValue("#", (index + 1))
 
 		bc.lineupDownload(handle, block, prefetch)
 	}
@@ -928,6 +1175,19 @@ func (bc *BlockCache) refreshBlock(handle *handlemap.Handle, index uint64, prefe
 
 // lineupDownload : Create a work item and schedule the download
 func (bc *BlockCache) lineupDownload(handle *handlemap.Handle, block *Block, prefetch bool) {
+	// Remove this block from free block list and add to in-process list
+	bc.addToCooking(handle, block)
+
+	block.flags.Set(BlockFlagDownloading)
+
+	if isHole(block.id, handle) {
+		// Nothing to download as storage does not have this part of the file yet
+		log.Debug("BlockCache::lineupDownload : Block %v of %v=>%s is in a hole, reading as zero", block.id, handle.ID, handle.Path)
+		clear(block.data)
+		block.Ready(BlockStatusDownloaded)
+		return
+	}
+
 	item := &workItem{
 		handle:   handle,
 		block:    block,
@@ -936,10 +1196,12 @@ func (bc *BlockCache) lineupDownload(handle *handlemap.Handle, block *Block, pre
 		upload:   false,
 	}
 
-	// Remove this block from free block list and add to in-process list
-	bc.addToCooking(handle, block)
-
-	block.flags.Set(BlockFlagDownloading)
+	if val, found := handle.GetValue("ETag"); found {
+		item.etag = val.(string)
+	}
+	if val, found := handle.GetValue("ETagRef"); found {
+		item.current = val.(*atomic.Pointer[string])
+	}
 
 	// Send the work item to worker pool to schedule download
 	bc.threadPool.Schedule(!prefetch, item)
@@ -955,19 +1217,12 @@ func (bc *BlockCache) download(item *workItem) {
 	flock.Lock()
 	defer flock.Unlock()
 
-	var diskNode any
-	found := false
 	localPath := ""
+	diskCache := bc.tmpPath != "" && bc.classes.Classify(item.handle.Path) != cache_class.NoCache
 
-	if bc.tmpPath != "" {
+	if diskCache {
 		// Update diskpolicy to reflect the new file
-		diskNode, found = bc.fileNodeMap.Load(fileName)
-		if !found {
-			diskNode = bc.diskPolicy.Add(fileName)
-			bc.fileNodeMap.Store(fileName, diskNode)
-		} else {
-			bc.diskPolicy.Refresh(diskNode.(*list.Element))
-		}
+		bc.touchDiskBlock(item.handle.Path, fileName)
 
 		// Check local file exists for this offset and file combination or not
 		localPath = filepath.Join(bc.tmpPath, fileName)
@@ -982,19 +1237,14 @@ func (bc *BlockCache) download(item *workItem) {
 				_ = os.Remove(localPath)
 			} else {
 				var successfulRead bool = true
-				n, err := f.Read(item.block.data)
+				n, err := bc.readDiskBlock(f, item)
+				clear(item.block.data[max(n, 0):])
 				if err != nil {
 					log.Err("BlockCache::download : Failed to read data from disk cache %s [%s]", fileName, err.Error())
 					successfulRead = false
 					_ = os.Remove(localPath)
 				}
 
-				if n != int(bc.blockSize) && item.block.offset+uint64(n) != uint64(item.handle.Size) {
-					log.Err("BlockCache::download : Local data retrieved from disk size mismatch, Expected %v, OnDisk %v, fileSize %v", bc.getBlockSize(uint64(item.handle.Size), item.block), n, item.handle.Size)
-					successfulRead = false
-					_ = os.Remove(localPath)
-				}
-
 				f.Close()
 				// We have read the data from disk so there is no need to go over network
 				// Just mark the block that download is complete
@@ -1006,12 +1256,55 @@ func (bc *BlockCache) download(item *workItem) {
 		}
 	}
 
+	// Another mount on this host may have read this version of the block already
+	if bc.sharedCache != nil && item.etag != "" {
+		n, found := bc.getSharedBlock(item)
+		if found && n > 0 {
+			clear(item.block.data[n:])
+			blockCacheStatsCollector.UpdateStats(stats_manager.Increment, sharedCacheHits, (int64)(1))
+			item.block.Ready(BlockStatusDownloaded)
+			return
+		}
+	}
+
 	// If file does not exists then download the block from the container
-	n, err := bc.NextComponent().ReadInBuffer(internal.ReadInBufferOptions{
+	var checksums [][]byte
+	options := internal.ReadInBufferOptions{
 		Handle: item.handle,
 		Offset: int64(item.block.offset),
 		Data:   item.block.data,
-	})
+		ETag:   item.etag,
+	}
+	if bc.validateChecksum {
+		options.Checksums = &checksums
+	}
+
+	n, err := bc.NextComponent().ReadInBuffer(options)
+	if err == nil && n > 0 && bc.validateChecksum && !verifyChecksums(item.block.data[:n], checksums) {
+		// Data got corrupted on the way, retry and fail the read if it does not go through
+		log.Err("BlockCache::download : Checksum mismatch for %v=>%s (index %v, offset %v, attempt %v)", item.handle.ID, item.handle.Path, item.block.id, item.block.offset, item.failCnt+1)
+		blockCacheStatsCollector.PushEvents(checksumMismatch, item.handle.Path, map[string]interface{}{blockKey: item.block.id, offsetKey: item.block.offset, attemptKey: item.failCnt + 1})
+		blockCacheStatsCollector.UpdateStats(stats_manager.Increment, checksumMismatches, (int64)(1))
+		err = syscall.EIO
+	}
+
+	if err == syscall.ESTALE {
+		if item.current != nil {
+			if etag := *item.current.Load(); etag != item.etag {
+				// Handle changed the blob through this mount after the download was lined up, read the new version
+				item.etag = etag
+				bc.threadPool.Schedule(false, item)
+				return
+			}
+		}
+
+		// Blob changed since the handle read its ETag, retrying would only fail the same way
+		log.Err("BlockCache::download : %v=>%s changed since it was opened (index %v, offset %v)", item.handle.ID, item.handle.Path, item.block.id, item.block.offset)
+		item.block.flags.Set(BlockFlagStale)
+		item.block.Failed()
+		item.block.Ready(BlockStatusDownloadFailed)
+		return
+	}
 
 	if item.failCnt > MAX_FAIL_CNT {
 		// If we failed to read the data 3 times then just give up
@@ -1035,7 +1328,19 @@ func (bc *BlockCache) download(item *workItem) {
 		return
 	}
 
-	if bc.tmpPath != "" {
+	// Buffer may hold data of the block it was last used for, anything after the end of file has to read as zero
+	// as a later write past the end of file may leave it in place
+	clear(item.block.data[n:])
+
+	// Block is copied for the shared cache before it is marked ready, after that it may be reused for another block
+	if bc.sharedCache != nil && item.etag != "" {
+		bc.sharedCache.PutAsync(bc.sharedKey(item), item.block.data[:n])
+		if bc.validateChecksum {
+			bc.sharedCache.PutAsync(bc.sharedKey(item)+"::md5", blockChecksum(item.block.data[:n]))
+		}
+	}
+
+	if diskCache {
 		err := os.MkdirAll(filepath.Dir(localPath), 0777)
 		if err != nil {
 			log.Err("BlockCache::download : error creating directory structure for file %s [%s]", localPath, err.Error())
@@ -1045,14 +1350,16 @@ func (bc *BlockCache) download(item *workItem) {
 		// Dump this block to local disk cache
 		f, err := os.Create(localPath)
 		if err == nil {
-			_, err := f.Write(item.block.data[:n])
+			size, err := bc.writeDiskBlock(f, item.block.data[:n])
 			if err != nil {
 				log.Err("BlockCache::download : Failed to write %s to disk [%v]", localPath, err.Error())
 				_ = os.Remove(localPath)
+			} else if bc.diskIndex != nil {
+				bc.indexBlock(f, item, uint64(size))
 			}
 
 			f.Close()
-			bc.diskPolicy.Refresh(diskNode.(*list.Element))
+			bc.touchDiskBlock(item.handle.Path, fileName)
 		}
 	}
 
@@ -1069,6 +1376,13 @@ func (bc *BlockCache) WriteFile(options internal.WriteFileOptions) (int, error)
 
 	// log.Debug("BlockCache::WriteFile : Writing handle %v=>%v: offset %v, %v bytes", options.Handle.ID, options.Handle.Path, options.Offset, len(options.Data))
 
+	size := options.Handle.Size
+	err := bc.extendLastBlock(options.Handle, uint64(options.Offset)+uint64(len(options.Data)))
+	if err != nil {
+		log.Err("BlockCache::WriteFile : Unable to extend last block of %s [%s]", options.Handle.Path, err.Error())
+		return 0, err
+	}
+
 	// Keep getting next blocks until you read the request amount of data
 	dataWritten := int(0)
 	for dataWritten < len(options.Data) {
@@ -1076,6 +1390,10 @@ func (bc *BlockCache) WriteFile(options internal.WriteFileOptions) (int, error)
 		if err != nil {
 			// Failed to get block for writing
 			log.Err("BlockCache::WriteFile : Unable to allocate block for %s [%s]", options.Handle.Path, err.Error())
+			if dataWritten > 0 {
+				size = max(size, options.Offset)
+			}
+			bc.restoreSize(options.Handle, size)
 			return dataWritten, err
 		}
 
@@ -1101,6 +1419,47 @@ func (bc *BlockCache) WriteFile(options internal.WriteFileOptions) (int, error)
 	return dataWritten, nil
 }
 
+// extendLastBlock: When a write goes past the last block of the file, the last block becomes a full block in the middle of the file.
+// The version of it recorded in the block list holds only the data till the old end of file, so get the block back and mark it
+// dirty for it to be staged again with full size. Data after the old end of file is zero in the block buffer.
+func (bc *BlockCache) extendLastBlock(handle *handlemap.Handle, writeEnd uint64) error {
+	size := uint64(handle.Size)
+	if size == 0 || size%bc.blockSize == 0 || writeEnd <= (bc.getBlockIndex(size-1)+1)*bc.blockSize {
+		// Last block is already full or write does not go beyond it
+		return nil
+	}
+
+	index := bc.getBlockIndex(size - 1)
+	log.Debug("BlockCache::extendLastBlock : Extending block %v of %v=>%s from size %v", index, handle.ID, handle.Path, size-index*bc.blockSize)
+
+	block, err := bc.getOrCreateBlock(handle, index*bc.blockSize)
+	if err != nil {
+		return err
+	}
+
+	// Move the end of file right away, block may be picked for upload while rest of the write is in progress
+	// and it has to be staged with its full size then. WriteFile takes it back if the write fails.
+	block.Dirty()
+	handle.Flags.Set(handlemap.HandleFlagDirty)
+	handle.Size = int64(writeEnd)
+	return nil
+}
+
+// restoreSize : Take back the end of file moved by extendLastBlock when the write fails before all of its data is written.
+// Size passed covers the data that was written, blocks staged meanwhile are covered with the size they were staged with.
+func (bc *BlockCache) restoreSize(handle *handlemap.Handle, size int64) {
+	if lst, ok := handle.GetValue("blockList"); ok {
+		for index, info := range lst.(map[int64]*blockInfo) {
+			size = max(size, index*int64(bc.blockSize)+int64(info.size))
+		}
+	}
+
+	if size < handle.Size {
+		log.Info("BlockCache::restoreSize : Restoring size of %v=>%s to %v from %v", handle.ID, handle.Path, size, handle.Size)
+		handle.Size = size
+	}
+}
+
 func (bc *BlockCache) getOrCreateBlock(handle *handlemap.Handle, offset uint64) (*Block, error) {
 	// Check the given block index is already available or not
 	index := bc.getBlockIndex(offset)
@@ -1162,8 +1521,7 @@ func (bc *BlockCache) getOrCreateBlock(handle *handlemap.Handle, offset uint64)
 					log.Err("BlockCache::getOrCreateBlock : Failed to download block %v for %v=>%s", block.id, handle.ID, handle.Path)
 
 					// Remove this node from handle so that next read retries to download the block again
-					bc.releaseDownloadFailedBlock(handle, block)
-					return nil, fmt.Errorf("failed to download block")
+					return nil, bc.releaseFailedDownload(handle, block)
 				}
 			} else {
 				log.Debug("BlockCache::getOrCreateBlock : push block %v to the cooking list for %v=>%v", block.id, handle.ID, handle.Path)
@@ -1205,8 +1563,7 @@ func (bc *BlockCache) getOrCreateBlock(handle *handlemap.Handle, offset uint64)
 				log.Err("BlockCache::getOrCreateBlock : Failed to download block %v for %v=>%s", block.id, handle.ID, handle.Path)
 
 				// Remove this node from handle so that next read retries to download the block again
-				bc.releaseDownloadFailedBlock(handle, block)
-				return nil, fmt.Errorf("failed to download block")
+				return nil, bc.releaseFailedDownload(handle, block)
 			}
 		} else if block.flags.IsSet(BlockFlagUploading) {
 			// If the block is being staged, then wait till it is uploaded,
@@ -1266,6 +1623,31 @@ func (bc *BlockCache) releaseDownloadFailedBlock(handle *handlemap.Handle, block
 	bc.blockPool.Release(block)
 }
 
+// releaseFailedDownload : Release a block whose download has failed and return the error for it
+func (bc *BlockCache) releaseFailedDownload(handle *handlemap.Handle, block *Block) error {
+	stale := block.flags.IsSet(BlockFlagStale)
+	bc.releaseDownloadFailedBlock(handle, block)
+
+	if stale {
+		return syscall.ESTALE
+	}
+	return fmt.Errorf("failed to download block")
+}
+
+// setHandleETag : Record the ETag of the blob the handle knows. Downloads in flight for the handle follow it, as the
+// handle moves to a newer ETag only when the blob is changed through this mount.
+func setHandleETag(handle *handlemap.Handle, etag string) {
+	handle.SetValue("ETag", etag)
+	if val, found := handle.GetValue("ETagRef"); found {
+		val.(*atomic.Pointer[string]).Store(&etag)
+		return
+	}
+
+	ref := &atomic.Pointer[string]{}
+	ref.Store(&etag)
+	handle.SetValue("ETagRef", ref)
+}
+
 func (bc *BlockCache) printCooking(handle *handlemap.Handle) { //nolint
 	nodeList := handle.Buffers.Cooking
 	node := nodeList.Front()
@@ -1313,17 +1695,35 @@ func shouldCommitAndDownload(blockID int64, handle *handlemap.Handle) (bool, boo
 	}
 }
 
+// isHole is used to check if the given block lies in a hole left by a write past the end of file, which is not committed yet.
+// File with pending writes has all its committed and staged blocks in the block list, anything else within its size is a hole.
+func isHole(blockID int64, handle *handlemap.Handle) bool {
+	if !handle.Dirty() {
+		return false
+	}
+
+	lst, ok := handle.GetValue("blockList")
+	if !ok {
+		return false
+	}
+
+	_, found := lst.(map[int64]*blockInfo)[blockID]
+	return !found
+}
+
 // lineupUpload : Create a work item and schedule the upload
 func (bc *BlockCache) lineupUpload(handle *handlemap.Handle, block *Block, listMap map[int64]*blockInfo) {
 
+	// Size is fixed here as the file may grow while the upload is pending, data staged has to match what the block list records
 	id := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+	size := bc.getBlockSize(uint64(handle.Size), block)
 	listMap[block.id] = &blockInfo{
 		id:        id,
 		committed: false,
-		size:      bc.getBlockSize(uint64(handle.Size), block),
+		size:      size,
 	}
 
-	log.Debug("BlockCache::lineupUpload : block %v, size %v for %v=>%s, blockId %v", block.id, bc.getBlockSize(uint64(handle.Size), block), handle.ID, handle.Path, id)
+	log.Debug("BlockCache::lineupUpload : block %v, size %v for %v=>%s, blockId %v", block.id, size, handle.ID, handle.Path, id)
 	item := &workItem{
 		handle:   handle,
 		block:    block,
@@ -1331,6 +1731,7 @@ func (bc *BlockCache) lineupUpload(handle *handlemap.Handle, block *Block, listM
 		failCnt:  0,
 		upload:   true,
 		blockId:  id,
+		size:     size,
 	}
 
 	block.Uploading()
@@ -1399,13 +1800,19 @@ func (bc *BlockCache) upload(item *workItem) {
 	flock := bc.fileLocks.Get(fileName)
 	flock.Lock()
 	defer flock.Unlock()
-	blockSize := bc.getBlockSize(uint64(item.handle.Size), item.block)
+	blockSize := item.size
 	// This block is updated so we need to stage it now
-	err := bc.NextComponent().StageData(internal.StageDataOptions{
+	options := internal.StageDataOptions{
 		Name:   item.handle.Path,
 		Data:   item.block.data[0:blockSize],
 		Offset: uint64(item.block.offset),
-		Id:     item.blockId})
+		Id:     item.blockId}
+	if bc.validateChecksum {
+		sum := md5.Sum(options.Data)
+		options.Checksum = sum[:]
+	}
+
+	err := bc.NextComponent().StageData(options)
 	if err != nil {
 		// Fail to write the data so just reschedule this request
 		log.Err("BlockCache::upload : Failed to write %v=>%s from offset %v [%s]", item.handle.ID, item.handle.Path, item.block.id, err.Error())
@@ -1423,9 +1830,18 @@ func (bc *BlockCache) upload(item *workItem) {
 		return
 	}
 
-	if bc.tmpPath != "" {
+	if bc.tmpPath != "" && bc.classes.Classify(item.handle.Path) != cache_class.NoCache {
 		localPath := filepath.Join(bc.tmpPath, fileName)
 
+		if bc.diskIndex != nil && bc.diskIndex.forget(item.handle.Path) {
+			// Saved index shall not refer to blocks of this file before any of them is overwritten
+			err := bc.diskIndex.save()
+			if err != nil {
+				log.Err("BlockCache::upload : Failed to save disk index, skipping disk cache for %s [%s]", localPath, err.Error())
+				goto return_safe
+			}
+		}
+
 		err := os.MkdirAll(filepath.Dir(localPath), 0777)
 		if err != nil {
 			log.Err("BlockCache::upload : error creating directory structure for file %s [%s]", localPath, err.Error())
@@ -1435,7 +1851,7 @@ func (bc *BlockCache) upload(item *workItem) {
 		// Dump this block to local disk cache
 		f, err := os.Create(localPath)
 		if err == nil {
-			_, err := f.Write(item.block.data[0:blockSize])
+			_, err := bc.writeDiskBlock(f, item.block.data[0:blockSize])
 			if err != nil {
 				log.Err("BlockCache::upload : Failed to write %s to disk [%v]", localPath, err.Error())
 				_ = os.Remove(localPath)
@@ -1443,13 +1859,7 @@ func (bc *BlockCache) upload(item *workItem) {
 			}
 
 			f.Close()
-			diskNode, found := bc.fileNodeMap.Load(fileName)
-			if !found {
-				diskNode = bc.diskPolicy.Add(fileName)
-				bc.fileNodeMap.Store(fileName, diskNode)
-			} else {
-				bc.diskPolicy.Refresh(diskNode.(*list.Element))
-			}
+			bc.touchDiskBlock(item.handle.Path, fileName)
 		}
 	}
 
@@ -1502,11 +1912,17 @@ func (bc *BlockCache) commitBlocks(handle *handlemap.Handle) error {
 	log.Debug("BlockCache::commitBlocks : Committing blocks for %s", handle.Path)
 
 	// Commit the block list now
-	err = bc.NextComponent().CommitData(internal.CommitDataOptions{Name: handle.Path, List: blockIDList, BlockSize: bc.blockSize})
+	etag := ""
+	if val, found := handle.GetValue("ETag"); found {
+		etag = val.(string)
+	}
+
+	err = bc.NextComponent().CommitData(internal.CommitDataOptions{Name: handle.Path, List: blockIDList, BlockSize: bc.blockSize, ETag: &etag})
 	if err != nil {
 		log.Err("BlockCache::commitBlocks : Failed to commit blocks for %s [%s]", handle.Path, err.Error())
 		return err
 	}
+	setHandleETag(handle, etag)
 
 	// set all the blocks as committed
 	list, _ := handle.GetValue("blockList")
@@ -1539,10 +1955,10 @@ func (bc *BlockCache) getBlockIDList(handle *handlemap.Handle) ([]string, error)
 
 	for i < len(offsets) {
 		if index == offsets[i] {
-			// TODO: when a staged block (not last block) has data less than block size
+			// Blocks are extended before file grows past them, so this is never expected to happen
 			if i != len(offsets)-1 && listMap[offsets[i]].size != bc.blockSize {
-				log.Err("BlockCache::getBlockIDList : Staged block %v has less data %v for %v=>%s\n%v", offsets[i], listMap[offsets[i]].size, handle.ID, handle.Path, common.BlockCacheRWErrMsg)
-				return nil, fmt.Errorf("staged block %v has less data %v for %v=>%s\n%v", offsets[i], listMap[offsets[i]].size, handle.ID, handle.Path, common.BlockCacheRWErrMsg)
+				log.Err("BlockCache::getBlockIDList : Staged block %v has less data %v for %v=>%s", offsets[i], listMap[offsets[i]].size, handle.ID, handle.Path)
+				return nil, fmt.Errorf("staged block %v has less data %v for %v=>%s", offsets[i], listMap[offsets[i]].size, handle.ID, handle.Path)
 			}
 
 			blockIDList = append(blockIDList, listMap[offsets[i]].id)
@@ -1619,10 +2035,411 @@ func (bc *BlockCache) diskEvict(node *list.Element) {
 
 	bc.fileNodeMap.Delete(fileName)
 
+	if name, _, ok := parseBlockName(fileName); ok && bc.classes.Classify(name) == cache_class.Pin {
+		// File was pinned after this block was cached, it stays on disk out of the policy
+		bc.pinnedBlocks.Store(fileName, true)
+		return
+	}
+
+	if bc.diskIndex != nil {
+		if bc.diskIndex.Stopped() {
+			// Block is kept on disk for next mount
+			return
+		}
+
+		if name, id, ok := parseBlockName(fileName); ok {
+			bc.diskIndex.removeBlock(name, id)
+		}
+	}
+
 	localPath := filepath.Join(bc.tmpPath, fileName)
 	_ = os.Remove(localPath)
 }
 
+// touchDiskBlock : Put a block cached on disk under the disk eviction policy, or refresh it if it is already there.
+// Blocks of pinned files are kept out of the policy so they are never evicted.
+func (bc *BlockCache) touchDiskBlock(path string, fileName string) {
+	if node, found := bc.fileNodeMap.Load(fileName); found {
+		bc.diskPolicy.Refresh(node.(*list.Element))
+		return
+	}
+
+	if bc.classes.Classify(path) == cache_class.Pin {
+		bc.pinnedBlocks.Store(fileName, true)
+		return
+	}
+
+	bc.pinnedBlocks.Delete(fileName)
+	bc.fileNodeMap.Store(fileName, bc.diskPolicy.Add(fileName))
+}
+
+// releasePinned : Put blocks of files which are no longer pinned back under the disk eviction policy
+func (bc *BlockCache) releasePinned() {
+	bc.pinnedBlocks.Range(func(key, _ any) bool {
+		fileName := key.(string)
+		name, _, ok := parseBlockName(fileName)
+		if ok && bc.classes.Classify(name) == cache_class.Pin {
+			return true
+		}
+
+		flock := bc.fileLocks.Get(fileName)
+		flock.Lock()
+		bc.pinnedBlocks.Delete(fileName)
+		if _, found := bc.fileNodeMap.Load(fileName); !found {
+			bc.fileNodeMap.Store(fileName, bc.diskPolicy.Add(fileName))
+		}
+		flock.Unlock()
+		return true
+	})
+}
+
+// evictFirst : Remove blocks of evict-first files from disk ahead of the eviction policy, returns the number
+// of blocks removed. Their nodes stay in the policy till they expire, a block read again meanwhile is downloaded again.
+func (bc *BlockCache) evictFirst() int {
+	count := 0
+	bc.fileNodeMap.Range(func(key, _ any) bool {
+		fileName := key.(string)
+		name, id, ok := parseBlockName(fileName)
+		if !ok || bc.classes.Classify(name) != cache_class.EvictFirst || bc.fileLocks.Locked(fileName) {
+			return true
+		}
+
+		flock := bc.fileLocks.Get(fileName)
+		flock.Lock()
+		defer flock.Unlock()
+
+		if bc.diskIndex != nil {
+			bc.diskIndex.removeBlock(name, id)
+		}
+
+		if os.Remove(filepath.Join(bc.tmpPath, fileName)) == nil {
+			count++
+		}
+		return true
+	})
+
+	if count > 0 {
+		log.Info("BlockCache::evictFirst : Removed %d blocks of evict-first files from disk", count)
+	}
+	return count
+}
+
+// indexBlock : Record a block written to disk in the index so that it can be used after a remount
+func (bc *BlockCache) indexBlock(f *os.File, item *workItem, size uint64) {
+	// Index can refer to the block only once it is durable
+	err := f.Sync()
+	if err != nil {
+		log.Err("BlockCache::indexBlock : Failed to sync block %v of %s [%s]", item.block.id, item.handle.Path, err.Error())
+		return
+	}
+
+	bc.diskIndex.addBlock(item.handle.Path, item.etag, item.handle.Mtime, item.block.id, size)
+}
+
+// blockChecksum : MD5 of a block, kept along with the block in disk cache and shared cache when checksums are validated
+func blockChecksum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+// writeDiskBlock : Save the block to disk cache followed by its MD5 if checksums are validated, returns size of the file
+func (bc *BlockCache) writeDiskBlock(f *os.File, data []byte) (int, error) {
+	n, err := f.Write(data)
+	if err != nil || !bc.validateChecksum {
+		return n, err
+	}
+
+	m, err := f.Write(blockChecksum(data))
+	return n + m, err
+}
+
+// readDiskBlock : Read the block of the work item from disk cache, returns size of the data read.
+// Block saved with its MD5 is used only if the data still matches it. When checksums are validated a block saved
+// without one can not be trusted, so it is read again from storage.
+func (bc *BlockCache) readDiskBlock(f *os.File, item *workItem) (int, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Last block of the file may be shorter than the block size
+	validSize := func(n int64) bool {
+		return n >= 0 && (uint64(n) == bc.blockSize || item.block.offset+uint64(n) == uint64(item.handle.Size))
+	}
+
+	size := info.Size()
+	if validSize(size - md5.Size) {
+		var sum [md5.Size]byte
+		n, err := io.ReadFull(f, item.block.data[:size-md5.Size])
+		if err == nil {
+			_, err = io.ReadFull(f, sum[:])
+		}
+		if err != nil {
+			return n, err
+		}
+
+		if !bytes.Equal(sum[:], blockChecksum(item.block.data[:n])) {
+			blockCacheStatsCollector.UpdateStats(stats_manager.Increment, checksumMismatches, (int64)(1))
+			return 0, fmt.Errorf("checksum mismatch in disk cache")
+		}
+		return n, nil
+	}
+
+	if !validSize(size) {
+		return 0, fmt.Errorf("size mismatch, expected %v, on disk %v, file size %v", bc.getBlockSize(uint64(item.handle.Size), item.block), size, item.handle.Size)
+	}
+
+	if bc.validateChecksum {
+		return 0, fmt.Errorf("no checksum in disk cache")
+	}
+	return io.ReadFull(f, item.block.data[:size])
+}
+
+// getSharedBlock : Read the block of the work item from shared cache. When checksums are validated the MD5 is kept
+// in shared cache next to the block, and the block is used only if both are there and match.
+func (bc *BlockCache) getSharedBlock(item *workItem) (int, bool) {
+	key := bc.sharedKey(item)
+
+	var sum [md5.Size]byte
+	if bc.validateChecksum {
+		n, found := bc.sharedCache.Get(key+"::md5", sum[:])
+		if !found || n != md5.Size {
+			return 0, false
+		}
+	}
+
+	n, found := bc.sharedCache.Get(key, item.block.data)
+	if !found || !bc.validateChecksum {
+		return n, found
+	}
+
+	if !bytes.Equal(sum[:], blockChecksum(item.block.data[:n])) {
+		log.Err("BlockCache::getSharedBlock : Checksum mismatch in shared cache for %v=>%s (index %v)", item.handle.ID, item.handle.Path, item.block.id)
+		blockCacheStatsCollector.UpdateStats(stats_manager.Increment, checksumMismatches, (int64)(1))
+		return 0, false
+	}
+	return n, true
+}
+
+// verifyChecksums : Check the data read against MD5 reported by storage for it, nothing to check if storage did not report any
+func verifyChecksums(data []byte, checksums [][]byte) bool {
+	if len(checksums) == 0 {
+		return true
+	}
+
+	computed := common.GetRangeChecksums(data)
+	if len(computed) != len(checksums) {
+		return false
+	}
+
+	for i := range computed {
+		if checksums[i] != nil && !bytes.Equal(computed[i], checksums[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedKey : Key of the block in shared cache, blocks of a blob are shared only while it has the same etag
+func (bc *BlockCache) sharedKey(item *workItem) string {
+	return fmt.Sprintf("%s/%s::%s::%v::%v", bc.sharedNamespace, item.handle.Path, item.etag, bc.blockSize, item.block.id)
+}
+
+// getSharedNamespace : Mounts of the same container share blocks, if the container is not known blocks are shared
+// only with mounts on the same path
+func getSharedNamespace() string {
+	var account, container, mntPath string
+	_ = config.UnmarshalKey("azstorage.account-name", &account)
+	_ = config.UnmarshalKey("azstorage.container", &container)
+	if account != "" && container != "" {
+		return account + "/" + container
+	}
+
+	_ = config.UnmarshalKey("mount-path", &mntPath)
+	log.Info("BlockCache::getSharedNamespace : Storage account not known, blocks are shared only with mounts on %s", mntPath)
+	return mntPath
+}
+
+// removeDiskBlocks : Remove all blocks of the file from disk cache
+func (bc *BlockCache) removeDiskBlocks(name string) {
+	files, err := filepath.Glob(filepath.Join(bc.tmpPath, name) + "::*")
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		_ = os.Remove(f)
+	}
+}
+
+// configureJournal : Set up journal of pending uploads in the given directory
+func (bc *BlockCache) configureJournal(path string) error {
+	if !bc.lazyWrite {
+		return fmt.Errorf("journal-path requires lazy-write")
+	}
+
+	if path == bc.tmpPath || path == bc.mntPath {
+		return fmt.Errorf("journal-path can not be same as disk cache path or mount path")
+	}
+
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return err
+	}
+
+	bc.journal = newJournal(path)
+	return nil
+}
+
+// journalHandle : Record data of the handle which is not committed yet, so that it is not lost if the mount goes away
+// before it is uploaded. Blocks already in storage are recorded by their id, rest of them with their data.
+func (bc *BlockCache) journalHandle(handle *handlemap.Handle) error {
+	handle.Lock()
+	defer handle.Unlock()
+
+	lst, _ := handle.GetValue("blockList")
+	listMap := lst.(map[int64]*blockInfo)
+
+	inMemory := make(map[int64]*Block)
+	for _, blockList := range []*list.List{handle.Buffers.Cooking, handle.Buffers.Cooked} {
+		for node := blockList.Front(); node != nil; node = node.Next() {
+			block := node.Value.(*Block)
+			inMemory[block.id] = block
+		}
+	}
+
+	entry := &journalEntry{
+		Path:      handle.Path,
+		Size:      handle.Size,
+		BlockSize: bc.blockSize,
+		Closed:    time.Now(),
+	}
+	if val, found := handle.GetValue("ETag"); found {
+		entry.ETag = val.(string)
+	}
+
+	data := make(map[int][]byte)
+	count := (uint64(handle.Size) + bc.blockSize - 1) / bc.blockSize
+	for i := uint64(0); i < count; i++ {
+		info := listMap[int64(i)]
+		block := inMemory[int64(i)]
+		size := min(bc.blockSize, uint64(handle.Size)-i*bc.blockSize)
+
+		// Block being uploaded has an entry which is not committed, so flags are checked only for the ones not in flight
+		if block != nil && (info == nil || !info.committed || block.IsDirty()) {
+			entry.Blocks = append(entry.Blocks, journalBlock{Data: true, Size: size})
+			data[int(i)] = block.data[:size]
+		} else if info != nil {
+			entry.Blocks = append(entry.Blocks, journalBlock{Id: info.id, Size: info.size})
+		} else {
+			// Hole left by a write past the end of file
+			entry.Blocks = append(entry.Blocks, journalBlock{Size: size})
+		}
+	}
+
+	id, err := bc.journal.record(handle.ID, entry, data)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("BlockCache::journalHandle : %v=>%s journaled as %s with %v blocks of data", handle.ID, handle.Path, id, len(data))
+	handle.SetValue("journal", id)
+	return nil
+}
+
+// replayJournal : Commit files left in journal by last mount, files changed in storage since then are rolled back
+func (bc *BlockCache) replayJournal() {
+	ids, err := bc.journal.entries()
+	if err != nil {
+		log.Err("BlockCache::replayJournal : Failed to read journal %s [%s]", bc.journal.path, err.Error())
+		return
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	recovered, rolledBack, pending := 0, 0, 0
+	for _, id := range ids {
+		entry, err := bc.journal.load(id)
+		if err != nil {
+			log.Err("BlockCache::replayJournal : Rolling back unreadable journal entry %s [%s]", id, err.Error())
+			_ = bc.journal.remove(id)
+			rolledBack++
+			continue
+		}
+
+		rollback, err := bc.replayEntry(id, entry)
+		if err == nil {
+			log.Crit("BlockCache::replayJournal : Recovered %s, %v bytes closed at %v", entry.Path, entry.Size, entry.Closed)
+			_ = bc.journal.remove(id)
+			recovered++
+		} else if rollback {
+			log.Crit("BlockCache::replayJournal : Rolled back %s closed at %v [%s]", entry.Path, entry.Closed, err.Error())
+			_ = bc.journal.remove(id)
+			rolledBack++
+		} else {
+			log.Err("BlockCache::replayJournal : Failed to recover %s, keeping it for next mount [%s]", entry.Path, err.Error())
+			pending++
+		}
+	}
+
+	log.Crit("BlockCache::replayJournal : Journal replayed, %v files recovered, %v rolled back, %v kept for retry", recovered, rolledBack, pending)
+}
+
+// replayEntry : Stage the blocks recorded with data and commit the block list, if the blob is still the one the
+// changes were made on. Returns whether the entry is to be rolled back when it can not be replayed.
+func (bc *BlockCache) replayEntry(id string, entry *journalEntry) (bool, error) {
+	attr, err := bc.NextComponent().GetAttr(internal.GetAttrOptions{Name: entry.Path})
+	if err == syscall.ENOENT || os.IsNotExist(err) {
+		return true, fmt.Errorf("file is deleted")
+	} else if err != nil {
+		return false, err
+	}
+
+	// File created by the mount is empty in storage till its first commit
+	if (entry.ETag != "" && attr.ETag != entry.ETag) || (entry.ETag == "" && attr.Size != 0) {
+		return true, fmt.Errorf("file is modified since it was closed")
+	}
+
+	blockIDList := make([]string, 0, len(entry.Blocks))
+	for i, block := range entry.Blocks {
+		if block.Id != "" {
+			blockIDList = append(blockIDList, block.Id)
+			continue
+		}
+
+		var data []byte
+		if block.Data {
+			data, err = bc.journal.blockData(id, i, block.Size)
+			if err != nil {
+				return true, err
+			}
+		} else {
+			data = make([]byte, block.Size)
+		}
+
+		blockID := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+		err = bc.NextComponent().StageData(internal.StageDataOptions{
+			Name:   entry.Path,
+			Id:     blockID,
+			Data:   data,
+			Offset: uint64(i) * entry.BlockSize,
+		})
+		if err != nil {
+			return false, err
+		}
+		blockIDList = append(blockIDList, blockID)
+	}
+
+	etag := entry.ETag
+	err = bc.NextComponent().CommitData(internal.CommitDataOptions{Name: entry.Path, List: blockIDList, BlockSize: entry.BlockSize, ETag: &etag})
+	if err == syscall.ESTALE {
+		return true, fmt.Errorf("file is modified since it was closed")
+	}
+	return false, err
+}
+
 // checkDiskUsage : Callback to check usage of disk and decide whether eviction is needed
 func (bc *BlockCache) checkDiskUsage() bool {
 	data, _ := common.GetUsage(bc.tmpPath)
@@ -1630,13 +2447,14 @@ func (bc *BlockCache) checkDiskUsage() bool {
 
 	if bc.maxDiskUsageHit {
 		if usage >= MIN_POOL_USAGE {
-			return true
+			// Blocks of evict-first files go before the least recently used block
+			return bc.evictFirst() == 0
 		}
 		bc.maxDiskUsageHit = false
 	} else {
 		if usage >= MAX_POOL_USAGE {
 			bc.maxDiskUsageHit = true
-			return true
+			return bc.evictFirst() == 0
 		}
 	}
 
@@ -1653,6 +2471,10 @@ func (bc *BlockCache) invalidateDirectory(name string) {
 		return
 	}
 
+	if bc.diskIndex != nil {
+		bc.diskIndex.forgetDir(name)
+	}
+
 	localPath := filepath.Join(bc.tmpPath, name)
 	_ = os.RemoveAll(localPath)
 }
@@ -1699,6 +2521,15 @@ func (bc *BlockCache) DeleteFile(options internal.DeleteFileOptions) error {
 		return err
 	}
 
+	if bc.leaseLock {
+		// Lease goes away along with the blob
+		bc.leases.Forget(options.Name)
+	}
+
+	if bc.diskIndex != nil {
+		bc.diskIndex.forget(options.Name)
+	}
+
 	localPath := filepath.Join(bc.tmpPath, options.Name)
 	files, err := filepath.Glob(localPath + "*")
 	if err == nil {
@@ -1730,6 +2561,16 @@ func (bc *BlockCache) RenameFile(options internal.RenameFileOptions) error {
 		return err
 	}
 
+	if bc.leaseLock {
+		bc.leases.Forget(options.Src)
+	}
+
+	if bc.diskIndex != nil {
+		// Blocks moved to destination are not known to be of its current version, next open shall remove them
+		bc.diskIndex.forget(options.Src)
+		bc.diskIndex.forget(options.Dst)
+	}
+
 	localSrcPath := filepath.Join(bc.tmpPath, options.Src)
 	localDstPath := filepath.Join(bc.tmpPath, options.Dst)
 
@@ -1758,6 +2599,185 @@ func (bc *BlockCache) SyncFile(options internal.SyncFileOptions) error {
 	return nil
 }
 
+// GetXattr : Serve the cache class and cache status of the path, other attributes come from storage
+func (bc *BlockCache) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	if options.Attr == common.CacheClassXattr {
+		return []byte(bc.classes.Classify(options.Name).String()), nil
+	} else if options.Attr == common.CacheStatusXattr {
+		status, err := bc.cacheStatus(options.Name)
+		return []byte(status), err
+	}
+
+	return bc.NextComponent().GetXattr(options)
+}
+
+// cacheStatus : How many blocks of the file are in the disk cache
+func (bc *BlockCache) cacheStatus(name string) (string, error) {
+	if bc.tmpPath == "" {
+		return common.CacheStatusNone, nil
+	}
+
+	attr, err := bc.NextComponent().GetAttr(internal.GetAttrOptions{Name: name})
+	if err != nil {
+		return "", err
+	}
+
+	blocks := (uint64(attr.Size) + bc.blockSize - 1) / bc.blockSize
+	cached := uint64(0)
+	for i := uint64(0); i < blocks; i++ {
+		_, err := os.Stat(filepath.Join(bc.tmpPath, fmt.Sprintf("%s::%v", name, i)))
+		if err == nil {
+			cached++
+		}
+	}
+
+	if cached == blocks {
+		return common.CacheStatusCached, nil
+	} else if cached > 0 {
+		return common.CacheStatusPartial, nil
+	}
+	return common.CacheStatusNone, nil
+}
+
+// Chmod : Mode may be kept in metadata of the blob, which changes its ETag
+func (bc *BlockCache) Chmod(options internal.ChmodOptions) error {
+	return bc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return bc.NextComponent().Chmod(options)
+	})
+}
+
+// Chown : Owner may be kept in metadata of the blob, which changes its ETag
+func (bc *BlockCache) Chown(options internal.ChownOptions) error {
+	return bc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return bc.NextComponent().Chown(options)
+	})
+}
+
+// SetAttr : Times are kept in metadata of the blob, which changes its ETag
+func (bc *BlockCache) SetAttr(options internal.SetAttrOptions) error {
+	return bc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return bc.NextComponent().SetAttr(options)
+	})
+}
+
+// changeMetadata : Updating metadata of a blob changes its ETag. Make the change with the ETag the open handles of the
+// file know and move them over to the new one, so that their downloads and commits are not taken as conflicts.
+// Storage hands back the new ETag only if no one else changed the blob before, otherwise the handles keep the old one.
+func (bc *BlockCache) changeMetadata(name string, change func(etag *string) error) error {
+	handles := make([]*handlemap.Handle, 0)
+	handlemap.GetHandles().Range(func(_, value any) bool {
+		if handle := value.(*handlemap.Handle); handle.Path == name {
+			handles = append(handles, handle)
+		}
+		return true
+	})
+
+	etag := ""
+	for _, handle := range handles {
+		handle.RLock()
+		if val, found := handle.GetValue("ETag"); found {
+			etag = val.(string)
+		}
+		handle.RUnlock()
+
+		if etag != "" {
+			break
+		}
+	}
+
+	before := etag
+	err := change(&etag)
+	if err != nil || etag == before {
+		return err
+	}
+
+	for _, handle := range handles {
+		handle.Lock()
+		if val, found := handle.GetValue("ETag"); found && val.(string) == before {
+			setHandleETag(handle, etag)
+		}
+		handle.Unlock()
+	}
+
+	return nil
+}
+
+// SetXattr : Set the cache class of the path in this mount, other attributes go to storage
+func (bc *BlockCache) SetXattr(options internal.SetXattrOptions) error {
+	if options.Attr == common.CacheStatusXattr {
+		return syscall.EPERM
+	} else if options.Attr != common.CacheClassXattr {
+		return bc.changeMetadata(options.Name, func(etag *string) error {
+			options.ETag = etag
+			return bc.NextComponent().SetXattr(options)
+		})
+	}
+
+	class, err := cache_class.Parse(string(options.Value))
+	if err != nil {
+		log.Err("BlockCache::SetXattr : %s [%s]", options.Name, err.Error())
+		return syscall.EINVAL
+	}
+
+	log.Info("BlockCache::SetXattr : Cache class of %s set to %s", options.Name, class)
+	bc.classes.Set(options.Name, class)
+	bc.releasePinned()
+	return nil
+}
+
+// RemoveXattr : Remove the cache class set on the path in this mount, other attributes are removed from storage
+func (bc *BlockCache) RemoveXattr(options internal.RemoveXattrOptions) error {
+	if options.Attr == common.CacheStatusXattr {
+		return syscall.EPERM
+	} else if options.Attr != common.CacheClassXattr {
+		return bc.changeMetadata(options.Name, func(etag *string) error {
+			options.ETag = etag
+			return bc.NextComponent().RemoveXattr(options)
+		})
+	}
+
+	if !bc.classes.Clear(options.Name) {
+		return syscall.ENODATA
+	}
+
+	log.Info("BlockCache::RemoveXattr : Cache class of %s is back to %s", options.Name, bc.classes.Classify(options.Name))
+	bc.releasePinned()
+	return nil
+}
+
+// AcquireLease : Lock the file across mounts on behalf of the application, e.g. for flock
+func (bc *BlockCache) AcquireLease(options internal.AcquireLeaseOptions) error {
+	log.Trace("BlockCache::AcquireLease : %s", options.Name)
+
+	if !bc.leaseLock {
+		return syscall.ENOTSUP
+	}
+
+	return bc.leases.Acquire(options.Name)
+}
+
+// RenewLease : Leases taken by block cache are renewed in background
+func (bc *BlockCache) RenewLease(options internal.RenewLeaseOptions) error {
+	if !bc.leaseLock {
+		return syscall.ENOTSUP
+	}
+	return nil
+}
+
+// ReleaseLease : Unlock the file locked through AcquireLease
+func (bc *BlockCache) ReleaseLease(options internal.ReleaseLeaseOptions) error {
+	log.Trace("BlockCache::ReleaseLease : %s", options.Name)
+
+	if !bc.leaseLock {
+		return syscall.ENOTSUP
+	}
+
+	return bc.leases.Release(options.Name)
+}
+
 // ------------------------- Factory -------------------------------------------
 // Pipeline will call this method to create your object, initialize your variables here
 // << DO NOT DELETE ANY AUTO GENERATED CODE HERE >>
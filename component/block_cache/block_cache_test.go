@@ -47,6 +47,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -54,7 +55,9 @@ import (
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/component/loopback"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -793,6 +796,53 @@ func (suite *blockCacheTestSuite) TestCreateFile() {
 	suite.assert.Contains(err.Error(), "Failed to create file")
 }
 
+func (suite *blockCacheTestSuite) TestLeaseLock() {
+	cfg := "block_cache:\n  block-size-mb: 1\n  mem-size-mb: 20\n  prefetch: 12\n  parallelism: 10\n  lease-lock: true"
+	tobj, err := setupPipeline(cfg)
+	defer tobj.cleanupPipeline()
+
+	suite.assert.Nil(err)
+	suite.assert.True(tobj.blockCache.leaseLock)
+
+	// Created file is locked till the handle is closed
+	path := getTestFileName(suite.T().Name())
+	h, err := tobj.blockCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.True(h.Leased())
+	suite.assert.True(tobj.blockCache.leases.IsHeld(path))
+	suite.assert.Nil(tobj.blockCache.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.False(tobj.blockCache.leases.IsHeld(path))
+
+	// Open for read does not lock the file
+	h, err = tobj.blockCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDONLY, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.False(h.Leased())
+	suite.assert.Nil(tobj.blockCache.CloseFile(internal.CloseFileOptions{Handle: h}))
+
+	// Open for write locks it, a second writer shares the lease of this mount
+	h, err = tobj.blockCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDWR, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.True(h.Leased())
+
+	h2, err := tobj.blockCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_WRONLY, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.True(h2.Leased())
+
+	suite.assert.Nil(tobj.blockCache.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.True(tobj.blockCache.leases.IsHeld(path))
+	suite.assert.Nil(tobj.blockCache.CloseFile(internal.CloseFileOptions{Handle: h2}))
+	suite.assert.False(tobj.blockCache.leases.IsHeld(path))
+}
+
+func (suite *blockCacheTestSuite) TestLeaseLockDisabled() {
+	tobj, err := setupPipeline("")
+	defer tobj.cleanupPipeline()
+
+	suite.assert.Nil(err)
+	err = tobj.blockCache.AcquireLease(internal.AcquireLeaseOptions{Name: "file"})
+	suite.assert.Equal(syscall.ENOTSUP, err)
+}
+
 func (suite *blockCacheTestSuite) TestOpenWithTruncate() {
 	tobj, err := setupPipeline("")
 	defer tobj.cleanupPipeline()
@@ -2611,6 +2661,86 @@ func (suite *blockCacheTestSuite) TestReadWriteBlockInParallel() {
 	suite.assert.Equal(fs.Size(), int64(62*_1MB))
 }
 
+func (suite *blockCacheTestSuite) TestMetadataChangeMovesHandleETag() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\n  optimistic-concurrency: true\nblock_cache:\n  block-size-mb: 1\n  mem-size-mb: 20\n  prefetch: 12\n  parallelism: 10\n"))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.Nil(store.Configure(true))
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(store)
+	suite.assert.Nil(bc.Configure(true))
+	suite.assert.Nil(bc.Start(context.Background()))
+	defer bc.Stop()
+
+	path := getTestFileName(suite.T().Name())
+	h, err := bc.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("test data")})
+	suite.assert.Nil(err)
+	suite.assert.Nil(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+
+	h, err = bc.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDWR})
+	suite.assert.Nil(err)
+	handlemap.Add(h)
+	defer handlemap.Delete(h.ID)
+
+	// Changes made through this mount move the handle to the new ETag of the blob
+	suite.assert.Nil(bc.SetAttr(internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Mtime: time.Now()}}))
+	suite.assert.Nil(bc.SetXattr(internal.SetXattrOptions{Name: path, Attr: "user.tag", Value: []byte("blue")}))
+	attr, err := store.GetAttr(internal.GetAttrOptions{Name: path})
+	suite.assert.Nil(err)
+	etag, _ := h.GetValue("ETag")
+	suite.assert.Equal(attr.ETag, etag)
+
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("new")})
+	suite.assert.Nil(err)
+	suite.assert.Nil(bc.FlushFile(internal.FlushFileOptions{Handle: h}))
+
+	// Change made by someone else is still a conflict
+	suite.assert.Nil(store.SetAttr(internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Mtime: time.Now()}}))
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("old")})
+	suite.assert.Nil(err)
+	suite.assert.NotNil(bc.FlushFile(internal.FlushFileOptions{Handle: h}))
+}
+
+func (suite *blockCacheTestSuite) TestReadAfterBlobChanged() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\nblock_cache:\n  block-size-mb: 1\n  mem-size-mb: 20\n  prefetch: 12\n  parallelism: 10\n"))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.Nil(store.Configure(true))
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(store)
+	suite.assert.Nil(bc.Configure(true))
+	suite.assert.Nil(bc.Start(context.Background()))
+	defer bc.Stop()
+
+	path := getTestFileName(suite.T().Name())
+	h, err := bc.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: dataBuff[:3*_1MB]})
+	suite.assert.Nil(err)
+	suite.assert.Nil(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+
+	h, err = bc.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDONLY})
+	suite.assert.Nil(err)
+
+	// Blocks of a version other than the one seen at open are not mixed into the reads of the handle
+	_, err = store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(path), Offset: 0, Data: []byte("new data")})
+	suite.assert.Nil(err)
+	data := make([]byte, 10)
+	_, err = bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: data})
+	suite.assert.Equal(syscall.ESTALE, err)
+	suite.assert.Nil(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+
+	h, err = bc.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDONLY})
+	suite.assert.Nil(err)
+	n, err := bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: data})
+	suite.assert.Nil(err)
+	suite.assert.Equal(len(data), n)
+	suite.assert.Equal([]byte("new data"), data[:8])
+	suite.assert.Nil(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+}
+
 func (suite *blockCacheTestSuite) TestZZZZZStreamToBlockCacheConfig() {
 	common.IsStream = true
 	config := "read-only: true\n\nstream:\n  block-size-mb: 2\n  max-buffers: 30\n  buffer-size-mb: 8\n"
@@ -0,0 +1,226 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type cacheClassTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	store  internal.Component
+	bc     *BlockCache
+	dir    string
+}
+
+func (suite *cacheClassTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+
+	suite.dir = suite.T().TempDir()
+	cfg := fmt.Sprintf("block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n  path: %s\n  disk-size-mb: 10\n  disk-timeout-sec: 120\n"+
+		"cache-classes:\n  - path: models\n    class: pin\n  - path: scratch\n    class: evict-first\n  - path: tmp\n    class: no-cache\n", suite.dir)
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+
+	suite.bc = NewBlockCacheComponent().(*BlockCache)
+	suite.bc.SetNextComponent(suite.store)
+	suite.assert.NoError(suite.bc.Configure(true))
+	suite.assert.NoError(suite.bc.Start(context.Background()))
+}
+
+func (suite *cacheClassTestSuite) TearDownTest() {
+	_ = suite.bc.Stop()
+}
+
+// readBlob : Write a blob of one block to storage and read it through block cache
+func (suite *cacheClassTestSuite) readBlob(name string) {
+	_, err := suite.store.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = suite.store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(name), Data: make([]byte, 1024)})
+	suite.assert.NoError(err)
+
+	h, err := suite.bc.OpenFile(internal.OpenFileOptions{Name: name, Flags: os.O_RDONLY})
+	suite.assert.NoError(err)
+	n, err := suite.bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: make([]byte, 1024)})
+	if err == io.EOF {
+		err = nil
+	}
+	suite.assert.NoError(err)
+	suite.assert.Equal(1024, n)
+	suite.assert.NoError(suite.bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+}
+
+func (suite *cacheClassTestSuite) onDisk(name string) bool {
+	_, err := os.Stat(filepath.Join(suite.dir, name+"::0"))
+	return err == nil
+}
+
+func (suite *cacheClassTestSuite) inPolicy(name string) bool {
+	_, found := suite.bc.fileNodeMap.Load(name + "::0")
+	return found
+}
+
+func (suite *cacheClassTestSuite) pinned(name string) bool {
+	_, found := suite.bc.pinnedBlocks.Load(name + "::0")
+	return found
+}
+
+func (suite *cacheClassTestSuite) TestPinned() {
+	suite.readBlob("models/a")
+	suite.readBlob("data")
+
+	suite.assert.True(suite.onDisk("models/a"))
+	suite.assert.True(suite.pinned("models/a"))
+	suite.assert.False(suite.inPolicy("models/a"))
+
+	suite.assert.True(suite.inPolicy("data"))
+	suite.assert.False(suite.pinned("data"))
+
+	// Evicting a block of a file pinned after it was cached keeps it on disk
+	err := suite.bc.SetXattr(internal.SetXattrOptions{Name: "data", Attr: common.CacheClassXattr, Value: []byte("pin")})
+	suite.assert.NoError(err)
+	node, _ := suite.bc.fileNodeMap.Load("data::0")
+	suite.bc.diskEvict(node.(*list.Element))
+	suite.assert.True(suite.onDisk("data"))
+	suite.assert.True(suite.pinned("data"))
+}
+
+func (suite *cacheClassTestSuite) TestUnpin() {
+	suite.readBlob("models/a")
+	suite.assert.True(suite.pinned("models/a"))
+
+	err := suite.bc.SetXattr(internal.SetXattrOptions{Name: "models", Attr: common.CacheClassXattr, Value: []byte("normal")})
+	suite.assert.NoError(err)
+
+	suite.assert.False(suite.pinned("models/a"))
+	suite.assert.True(suite.inPolicy("models/a"))
+	suite.assert.True(suite.onDisk("models/a"))
+}
+
+func (suite *cacheClassTestSuite) TestNoCache() {
+	suite.readBlob("tmp/a")
+
+	suite.assert.False(suite.onDisk("tmp/a"))
+	suite.assert.False(suite.inPolicy("tmp/a"))
+}
+
+func (suite *cacheClassTestSuite) TestEvictFirst() {
+	suite.readBlob("scratch/a")
+	suite.readBlob("data")
+	suite.assert.True(suite.onDisk("scratch/a"))
+
+	suite.assert.Equal(1, suite.bc.evictFirst())
+	suite.assert.False(suite.onDisk("scratch/a"))
+	suite.assert.True(suite.onDisk("data"))
+
+	// Nothing left to remove ahead of the policy
+	suite.assert.Equal(0, suite.bc.evictFirst())
+}
+
+func (suite *cacheClassTestSuite) TestXattr() {
+	value, err := suite.bc.GetXattr(internal.GetXattrOptions{Name: "models/a", Attr: common.CacheClassXattr})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("pin"), value)
+
+	err = suite.bc.SetXattr(internal.SetXattrOptions{Name: "data", Attr: common.CacheClassXattr, Value: []byte("sticky")})
+	suite.assert.Equal(syscall.EINVAL, err)
+
+	err = suite.bc.SetXattr(internal.SetXattrOptions{Name: "data", Attr: common.CacheClassXattr, Value: []byte("evict-first")})
+	suite.assert.NoError(err)
+	value, err = suite.bc.GetXattr(internal.GetXattrOptions{Name: "data", Attr: common.CacheClassXattr})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("evict-first"), value)
+
+	suite.assert.NoError(suite.bc.RemoveXattr(internal.RemoveXattrOptions{Name: "data", Attr: common.CacheClassXattr}))
+	suite.assert.Equal(syscall.ENODATA, suite.bc.RemoveXattr(internal.RemoveXattrOptions{Name: "data", Attr: common.CacheClassXattr}))
+
+	// Other attributes go to storage
+	_, err = suite.store.CreateFile(internal.CreateFileOptions{Name: "data", Mode: 0644})
+	suite.assert.NoError(err)
+	suite.assert.NoError(suite.bc.SetXattr(internal.SetXattrOptions{Name: "data", Attr: "user.tag", Value: []byte("blue")}))
+	value, err = suite.store.GetXattr(internal.GetXattrOptions{Name: "data", Attr: "user.tag"})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("blue"), value)
+}
+
+func (suite *cacheClassTestSuite) TestCacheStatus() {
+	status := func(name string) string {
+		value, err := suite.bc.GetXattr(internal.GetXattrOptions{Name: name, Attr: common.CacheStatusXattr})
+		suite.assert.NoError(err)
+		return string(value)
+	}
+
+	suite.readBlob("data")
+	suite.assert.Equal(common.CacheStatusCached, status("data"))
+
+	suite.readBlob("tmp/a")
+	suite.assert.Equal(common.CacheStatusNone, status("tmp/a"))
+
+	// Second block of a file is not read yet
+	_, err := suite.store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle("data"), Offset: 64 * 1024, Data: make([]byte, 10)})
+	suite.assert.NoError(err)
+	suite.assert.Equal(common.CacheStatusPartial, status("data"))
+
+	_, err = suite.bc.GetXattr(internal.GetXattrOptions{Name: "missing", Attr: common.CacheStatusXattr})
+	suite.assert.Equal(syscall.ENOENT, err)
+	suite.assert.Equal(syscall.EPERM, suite.bc.RemoveXattr(internal.RemoveXattrOptions{Name: "data", Attr: common.CacheStatusXattr}))
+}
+
+func TestCacheClassTestSuite(t *testing.T) {
+	suite.Run(t, new(cacheClassTestSuite))
+}
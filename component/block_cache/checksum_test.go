@@ -0,0 +1,274 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/shared_cache"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// corruptor : Flips a byte of the data read from or staged to the next component, for the given number of calls
+type corruptor struct {
+	internal.BaseComponent
+	reads  int32
+	stages int32
+}
+
+func (c *corruptor) ReadInBuffer(options internal.ReadInBufferOptions) (int, error) {
+	n, err := c.NextComponent().ReadInBuffer(options)
+	if n > 0 && atomic.AddInt32(&c.reads, -1) >= 0 {
+		options.Data[n/2] ^= 0xff
+	}
+	return n, err
+}
+
+func (c *corruptor) StageData(options internal.StageDataOptions) error {
+	if len(options.Data) > 0 && atomic.AddInt32(&c.stages, -1) >= 0 {
+		options.Data = bytes.Clone(options.Data)
+		options.Data[0] ^= 0xff
+	}
+	return c.NextComponent().StageData(options)
+}
+
+type checksumTestSuite struct {
+	suite.Suite
+	assert    *assert.Assertions
+	store     internal.Component
+	corruptor *corruptor
+	bc        *BlockCache
+}
+
+func (suite *checksumTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\n"))
+
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+
+	suite.corruptor = &corruptor{}
+	suite.corruptor.SetNextComponent(suite.store)
+	suite.startCache("")
+}
+
+// startCache : Start block cache over the corruptor with checksums validated and the given extra config
+func (suite *checksumTestSuite) startCache(extra string) {
+	cfg := "block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n  validate-checksum: true\n" + extra
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	suite.bc = NewBlockCacheComponent().(*BlockCache)
+	suite.bc.SetNextComponent(suite.corruptor)
+	suite.assert.NoError(suite.bc.Configure(true))
+	suite.assert.NoError(suite.bc.Start(context.Background()))
+}
+
+func (suite *checksumTestSuite) TearDownTest() {
+	_ = suite.bc.Stop()
+}
+
+func (suite *checksumTestSuite) writeBlob(name string, data []byte) {
+	_, err := suite.store.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = suite.store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(name), Data: data})
+	suite.assert.NoError(err)
+}
+
+func (suite *checksumTestSuite) readFile(name string, size int) ([]byte, error) {
+	h, err := suite.bc.OpenFile(internal.OpenFileOptions{Name: name, Flags: os.O_RDONLY})
+	suite.assert.NoError(err)
+	defer func() { _ = suite.bc.CloseFile(internal.CloseFileOptions{Handle: h}) }()
+
+	data := make([]byte, size)
+	n, err := suite.bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: data})
+	if err == io.EOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+func (suite *checksumTestSuite) TestVerifyChecksums() {
+	data := bytes.Repeat([]byte{'a'}, common.MaxChecksumRange+10)
+	sums := common.GetRangeChecksums(data)
+	suite.assert.Len(sums, 2)
+
+	suite.assert.True(verifyChecksums(data, sums))
+	suite.assert.True(verifyChecksums(data, nil))
+	suite.assert.True(verifyChecksums(data, [][]byte{nil, sums[1]}))
+
+	suite.assert.False(verifyChecksums(data, sums[:1]))
+	data[common.MaxChecksumRange] = 'b'
+	suite.assert.False(verifyChecksums(data, sums))
+	suite.assert.True(verifyChecksums(data, [][]byte{sums[0], nil}))
+}
+
+func (suite *checksumTestSuite) TestCorruptReadRetried() {
+	data := make([]byte, 3*64*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	suite.writeBlob("data.bin", data)
+
+	atomic.StoreInt32(&suite.corruptor.reads, 2)
+	read, err := suite.readFile("data.bin", len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.LessOrEqual(atomic.LoadInt32(&suite.corruptor.reads), int32(0))
+}
+
+func (suite *checksumTestSuite) TestCorruptReadFails() {
+	data := bytes.Repeat([]byte{'a'}, 64*1024)
+	suite.writeBlob("data.bin", data)
+
+	atomic.StoreInt32(&suite.corruptor.reads, 100)
+	_, err := suite.readFile("data.bin", len(data))
+	suite.assert.Error(err)
+
+	// Block is downloaded again once the data comes through intact
+	atomic.StoreInt32(&suite.corruptor.reads, 0)
+	read, err := suite.readFile("data.bin", len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+}
+
+func (suite *checksumTestSuite) TestCorruptUploadRetried() {
+	data := bytes.Repeat([]byte{'a'}, 2*64*1024)
+
+	h, err := suite.bc.CreateFile(internal.CreateFileOptions{Name: "data.bin", Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = suite.bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+	suite.assert.NoError(err)
+
+	// Storage rejects the corrupted block and it goes through on retry
+	atomic.StoreInt32(&suite.corruptor.stages, 1)
+	suite.assert.NoError(suite.bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+
+	stored, err := suite.store.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle("data.bin")})
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, stored))
+}
+
+func (suite *checksumTestSuite) TestCorruptDiskBlockRefetched() {
+	_ = suite.bc.Stop()
+	dir := suite.T().TempDir()
+	suite.startCache(fmt.Sprintf("  path: %s\n", dir))
+
+	data := make([]byte, 64*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	suite.writeBlob("data.bin", data)
+
+	read, err := suite.readFile("data.bin", len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+
+	// Blocks are saved on disk with their MD5
+	localPath := filepath.Join(dir, "data.bin::0")
+	saved, err := os.ReadFile(localPath)
+	suite.assert.NoError(err)
+	suite.assert.Len(saved, 64*1024+16)
+	suite.assert.Equal(blockChecksum(data[:64*1024]), saved[64*1024:])
+
+	// Block gone bad on disk is read again from storage
+	saved[10] ^= 0xff
+	suite.assert.NoError(os.WriteFile(localPath, saved, 0644))
+	read, err = suite.readFile("data.bin", len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+
+	saved, err = os.ReadFile(localPath)
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data[:64*1024], saved[:64*1024]))
+}
+
+func (suite *checksumTestSuite) TestCorruptSharedBlockRefetched() {
+	dir, err := os.MkdirTemp("", "sc")
+	suite.assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "cache.sock")
+	server := shared_cache.NewServer(socket, 4*1024*1024)
+	suite.assert.NoError(server.Start())
+	defer server.Stop()
+
+	_ = suite.bc.Stop()
+	suite.startCache(fmt.Sprintf("  shared-cache: %s\n  shared-cache-namespace: test\n", socket))
+
+	data := bytes.Repeat([]byte{'a'}, 64*1024)
+	suite.writeBlob("data.bin", data)
+
+	read, err := suite.readFile("data.bin", len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(suite.bc.Stop())
+
+	// Replace the block in shared cache with bad data, its MD5 stays as it was
+	attr, err := suite.store.GetAttr(internal.GetAttrOptions{Name: "data.bin"})
+	suite.assert.NoError(err)
+	key := suite.bc.sharedKey(&workItem{handle: handlemap.NewHandle("data.bin"), block: &Block{id: 0}, etag: attr.ETag})
+	client := shared_cache.NewClient(socket, 1)
+	defer client.Close()
+	client.Put(key, bytes.Repeat([]byte{'b'}, 64*1024))
+
+	suite.startCache(fmt.Sprintf("  shared-cache: %s\n  shared-cache-namespace: test\n", socket))
+	read, err = suite.readFile("data.bin", len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+}
+
+func TestChecksumTestSuite(t *testing.T) {
+	suite.Run(t, new(checksumTestSuite))
+}
@@ -0,0 +1,359 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+const (
+	// Index is kept at the root of the disk cache. Blocks are stored as <blob path>::<block index>
+	// so this name never collides with a block.
+	diskIndexName     = ".blobfuse2_block_index"
+	diskIndexVersion  = 1
+	diskIndexInterval = 30 * time.Second
+)
+
+// diskIndexEntry : Version of a blob whose blocks are on disk along with the list of those blocks
+type diskIndexEntry struct {
+	ETag   string           `json:"etag,omitempty"`
+	LMT    time.Time        `json:"lmt"`
+	Size   int64            `json:"size"`
+	Blocks map[int64]uint64 `json:"blocks"` // Block index to size of its data on disk
+}
+
+// diskIndexData : Layout of the index file
+type diskIndexData struct {
+	Version   int                        `json:"version"`
+	BlockSize uint64                     `json:"block-size"`
+	Files     map[string]*diskIndexEntry `json:"files"`
+}
+
+// diskIndex : Records which version of a blob each block in the disk cache belongs to, so that blocks still valid
+// can be used after a remount. A block is added only once it is fully written and synced to disk, and a blob is
+// dropped from the saved index before any of its blocks is overwritten, so a crash never leaves a stale block behind.
+type diskIndex struct {
+	sync.Mutex
+	path      string
+	blockSize uint64
+	files     map[string]*diskIndexEntry
+	dirty     bool
+	stopped   bool
+	stop      chan bool
+	wg        sync.WaitGroup
+}
+
+func newDiskIndex(dir string, blockSize uint64) *diskIndex {
+	return &diskIndex{
+		path:      filepath.Join(dir, diskIndexName),
+		blockSize: blockSize,
+		files:     make(map[string]*diskIndexEntry),
+	}
+}
+
+// load : Read the index saved by last mount and remove the blocks from disk which are not in it.
+// Returns names of the blocks which are kept.
+func (di *diskIndex) load() []string {
+	di.Lock()
+	defer di.Unlock()
+
+	data, err := os.ReadFile(di.path)
+	if err == nil {
+		index := diskIndexData{}
+		err = json.Unmarshal(data, &index)
+		if err != nil {
+			log.Err("diskIndex::load : Failed to parse %s [%s]", di.path, err.Error())
+		} else if index.Version != diskIndexVersion || index.BlockSize != di.blockSize {
+			log.Info("diskIndex::load : Index version %v, block size %v does not match, ignoring cached blocks", index.Version, index.BlockSize)
+		} else if index.Files != nil {
+			di.files = index.Files
+		}
+	} else if !os.IsNotExist(err) {
+		log.Err("diskIndex::load : Failed to read %s [%s]", di.path, err.Error())
+	}
+
+	dir := filepath.Dir(di.path)
+	found := make(map[string]map[int64]bool)
+	names := make([]string, 0)
+
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		name, _ := filepath.Rel(dir, path)
+		if name == diskIndexName || name == diskIndexName+".tmp" {
+			return nil
+		}
+
+		file, id, ok := parseBlockName(name)
+		if ok {
+			entry := di.files[file]
+			if entry != nil {
+				size, listed := entry.Blocks[id]
+				info, err := d.Info()
+				if listed && err == nil && uint64(info.Size()) == size {
+					if found[file] == nil {
+						found[file] = make(map[int64]bool)
+					}
+					found[file][id] = true
+					names = append(names, name)
+					return nil
+				}
+			}
+		}
+
+		// Block is not known to be of the current version of its blob
+		_ = os.Remove(path)
+		return nil
+	})
+
+	// Forget the blocks which are no more on disk
+	for file, entry := range di.files {
+		for id := range entry.Blocks {
+			if !found[file][id] {
+				delete(entry.Blocks, id)
+			}
+		}
+
+		if len(entry.Blocks) == 0 {
+			delete(di.files, file)
+		}
+	}
+
+	di.dirty = true
+	log.Info("diskIndex::load : %v blocks of %v files reused from %s", len(names), len(di.files), dir)
+	return names
+}
+
+// parseBlockName : Split the name of a block on disk into blob path and block index
+func parseBlockName(name string) (string, int64, bool) {
+	i := strings.LastIndex(name, "::")
+	if i < 0 {
+		return "", 0, false
+	}
+
+	id, err := strconv.ParseInt(name[i+2:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return filepath.ToSlash(name[:i]), id, true
+}
+
+// save : Write the index to disk if it has changed, index is replaced atomically so a crash leaves either the old or the new one
+func (di *diskIndex) save() error {
+	di.Lock()
+	defer di.Unlock()
+
+	if !di.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(diskIndexData{
+		Version:   diskIndexVersion,
+		BlockSize: di.blockSize,
+		Files:     di.files,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmpPath := di.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, di.path)
+	if err != nil {
+		return err
+	}
+
+	// Make the rename itself durable
+	if d, err := os.Open(filepath.Dir(di.path)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+
+	di.dirty = false
+	return nil
+}
+
+// Start : Save the index in background at regular interval
+func (di *diskIndex) Start() {
+	di.stop = make(chan bool)
+	di.wg.Add(1)
+	go di.saver()
+}
+
+// Stop : Stop the background saving and save the index one last time
+func (di *diskIndex) Stop() {
+	di.Lock()
+	di.stopped = true
+	di.Unlock()
+
+	close(di.stop)
+	di.wg.Wait()
+
+	err := di.save()
+	if err != nil {
+		log.Err("diskIndex::Stop : Failed to save %s [%s]", di.path, err.Error())
+	}
+}
+
+// Stopped : Whether the cache is going down, blocks on disk are retained for next mount then
+func (di *diskIndex) Stopped() bool {
+	di.Lock()
+	defer di.Unlock()
+	return di.stopped
+}
+
+func (di *diskIndex) saver() {
+	defer di.wg.Done()
+
+	ticker := time.NewTicker(diskIndexInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-di.stop:
+			return
+		case <-ticker.C:
+			err := di.save()
+			if err != nil {
+				log.Err("diskIndex::saver : Failed to save %s [%s]", di.path, err.Error())
+			}
+		}
+	}
+}
+
+// validate : Check the blocks on disk belong to the given version of the blob. If not the blob is recorded
+// with this version and no blocks, and caller shall remove the blocks from disk.
+func (di *diskIndex) validate(name string, etag string, lmt time.Time, size int64) bool {
+	di.Lock()
+	defer di.Unlock()
+
+	entry := di.files[name]
+	if entry != nil && entry.ETag == etag && entry.LMT.Equal(lmt) && entry.Size == size {
+		return true
+	}
+
+	di.files[name] = &diskIndexEntry{
+		ETag:   etag,
+		LMT:    lmt,
+		Size:   size,
+		Blocks: make(map[int64]uint64),
+	}
+	di.dirty = true
+	return false
+}
+
+// addBlock : Record a block written to disk, if the blob is still at the version the block was read from
+func (di *diskIndex) addBlock(name string, etag string, lmt time.Time, id int64, size uint64) {
+	di.Lock()
+	defer di.Unlock()
+
+	entry := di.files[name]
+	if entry == nil || entry.ETag != etag || !entry.LMT.Equal(lmt) {
+		return
+	}
+
+	entry.Blocks[id] = size
+	di.dirty = true
+}
+
+// removeBlock : Forget a block evicted from disk
+func (di *diskIndex) removeBlock(name string, id int64) {
+	di.Lock()
+	defer di.Unlock()
+
+	entry := di.files[name]
+	if entry == nil {
+		return
+	}
+
+	if _, found := entry.Blocks[id]; found {
+		delete(entry.Blocks, id)
+		di.dirty = true
+	}
+}
+
+// forget : Drop the blob from the index, returns true if it was there
+func (di *diskIndex) forget(name string) bool {
+	di.Lock()
+	defer di.Unlock()
+
+	if _, found := di.files[name]; !found {
+		return false
+	}
+
+	delete(di.files, name)
+	di.dirty = true
+	return true
+}
+
+// forgetDir : Drop all the blobs under the given directory from the index
+func (di *diskIndex) forgetDir(name string) {
+	di.Lock()
+	defer di.Unlock()
+
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for file := range di.files {
+		if strings.HasPrefix(file, prefix) {
+			delete(di.files, file)
+			di.dirty = true
+		}
+	}
+}
@@ -0,0 +1,326 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/chaos"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type diskIndexTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	dir    string
+}
+
+func (suite *diskIndexTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+	suite.dir = getFakeStoragePath("disk_index")
+}
+
+func (suite *diskIndexTestSuite) TearDownTest() {
+	_ = os.RemoveAll(suite.dir)
+}
+
+func (suite *diskIndexTestSuite) writeBlock(name string, size int) {
+	path := filepath.Join(suite.dir, name)
+	suite.assert.NoError(os.MkdirAll(filepath.Dir(path), 0777))
+	suite.assert.NoError(os.WriteFile(path, make([]byte, size), 0644))
+}
+
+func (suite *diskIndexTestSuite) blockExists(name string) bool {
+	_, err := os.Stat(filepath.Join(suite.dir, name))
+	return err == nil
+}
+
+func (suite *diskIndexTestSuite) TestParseBlockName() {
+	name, id, ok := parseBlockName("dir/a::b::12")
+	suite.assert.True(ok)
+	suite.assert.Equal("dir/a::b", name)
+	suite.assert.EqualValues(12, id)
+
+	_, _, ok = parseBlockName("dir/file")
+	suite.assert.False(ok)
+
+	_, _, ok = parseBlockName("dir/file::x")
+	suite.assert.False(ok)
+}
+
+func (suite *diskIndexTestSuite) TestSaveAndLoad() {
+	lmt := time.Now()
+	di := newDiskIndex(suite.dir, 16)
+
+	suite.writeBlock("dir/a::0", 16)
+	suite.writeBlock("dir/a::1", 5)
+	suite.writeBlock("b::0", 16)
+	suite.writeBlock("orphan::0", 16)
+
+	suite.assert.False(di.validate("dir/a", "etag1", lmt, 21))
+	di.addBlock("dir/a", "etag1", lmt, 0, 16)
+	di.addBlock("dir/a", "etag1", lmt, 1, 5)
+	suite.assert.False(di.validate("b", "", lmt, 16))
+	di.addBlock("b", "", lmt, 0, 16)
+	di.addBlock("b", "", lmt, 1, 16)
+	suite.assert.NoError(di.save())
+
+	di = newDiskIndex(suite.dir, 16)
+	names := di.load()
+	suite.assert.ElementsMatch([]string{"dir/a::0", "dir/a::1", "b::0"}, names)
+	suite.assert.False(suite.blockExists("orphan::0"))
+
+	// Block 1 of b was never on disk so it is dropped
+	suite.assert.Len(di.files["b"].Blocks, 1)
+	suite.assert.True(di.validate("dir/a", "etag1", lmt, 21))
+	suite.assert.True(di.validate("b", "", lmt, 16))
+}
+
+func (suite *diskIndexTestSuite) TestLoadRemovesInvalidBlocks() {
+	lmt := time.Now()
+	di := newDiskIndex(suite.dir, 16)
+
+	suite.writeBlock("a::0", 16)
+	suite.writeBlock("a::1", 16)
+	di.validate("a", "etag1", lmt, 32)
+	di.addBlock("a", "etag1", lmt, 0, 16)
+	di.addBlock("a", "etag1", lmt, 1, 16)
+	suite.assert.NoError(di.save())
+
+	// Block cut short by a crash while it was written
+	suite.writeBlock("a::1", 10)
+
+	di = newDiskIndex(suite.dir, 16)
+	suite.assert.ElementsMatch([]string{"a::0"}, di.load())
+	suite.assert.False(suite.blockExists("a::1"))
+
+	// Index of some other block size is of no use
+	suite.assert.NoError(di.save())
+	di = newDiskIndex(suite.dir, 32)
+	suite.assert.Empty(di.load())
+	suite.assert.False(suite.blockExists("a::0"))
+}
+
+func (suite *diskIndexTestSuite) TestLoadCorruptIndex() {
+	suite.writeBlock("a::0", 16)
+	suite.assert.NoError(os.WriteFile(filepath.Join(suite.dir, diskIndexName), []byte("{corrupt"), 0644))
+
+	di := newDiskIndex(suite.dir, 16)
+	suite.assert.Empty(di.load())
+	suite.assert.False(suite.blockExists("a::0"))
+}
+
+func (suite *diskIndexTestSuite) TestVersionChange() {
+	lmt := time.Now()
+	di := newDiskIndex(suite.dir, 16)
+
+	suite.assert.False(di.validate("a", "etag1", lmt, 16))
+	di.addBlock("a", "etag1", lmt, 0, 16)
+	suite.assert.True(di.validate("a", "etag1", lmt, 16))
+
+	suite.assert.False(di.validate("a", "etag2", lmt, 16))
+	suite.assert.Empty(di.files["a"].Blocks)
+
+	// Block read from the older version is not recorded
+	di.addBlock("a", "etag1", lmt, 0, 16)
+	suite.assert.Empty(di.files["a"].Blocks)
+
+	suite.assert.False(di.validate("a", "etag2", lmt.Add(time.Second), 16))
+	suite.assert.False(di.validate("a", "etag2", lmt.Add(time.Second), 32))
+}
+
+func (suite *diskIndexTestSuite) TestForget() {
+	lmt := time.Now()
+	di := newDiskIndex(suite.dir, 16)
+
+	di.validate("dir/a", "", lmt, 16)
+	di.validate("dir/sub/b", "", lmt, 16)
+	di.validate("dirx", "", lmt, 16)
+	di.validate("c", "", lmt, 16)
+
+	suite.assert.True(di.forget("c"))
+	suite.assert.False(di.forget("c"))
+
+	di.forgetDir("dir")
+	suite.assert.Len(di.files, 1)
+	suite.assert.Contains(di.files, "dirx")
+}
+
+// Mount with disk-persist, unmount and mount again, blocks on disk shall be used without going to storage
+func (suite *diskIndexTestSuite) startBlockCache(store internal.Component, faulty bool) *BlockCache {
+	cfg := fmt.Sprintf("block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n  path: %s\n  disk-persist: true\n", suite.dir)
+	if faulty {
+		cfg += "chaos:\n  rules:\n    - operations: [ReadInBuffer]\n      error: EIO\n"
+	}
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+	config.Set("mount-path", mountpoint)
+
+	next := store
+	if faulty {
+		next = chaos.NewChaosComponent()
+		suite.assert.NoError(next.Configure(true))
+		next.SetNextComponent(store)
+	}
+
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(next)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.NoError(bc.Start(context.Background()))
+	return bc
+}
+
+func (suite *diskIndexTestSuite) readFile(bc *BlockCache, name string, size int) ([]byte, error) {
+	h, err := bc.OpenFile(internal.OpenFileOptions{Name: name, Flags: os.O_RDONLY})
+	suite.assert.NoError(err)
+	defer func() { _ = bc.CloseFile(internal.CloseFileOptions{Handle: h}) }()
+
+	data := make([]byte, size)
+	n, err := bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: data})
+	if err == io.EOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+func (suite *diskIndexTestSuite) TestRemount() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\n"))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.NoError(store.Configure(true))
+
+	name := "dir/data.bin"
+	data := make([]byte, 3*64*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	_, err := store.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(name), Data: data})
+	suite.assert.NoError(err)
+
+	bc := suite.startBlockCache(store, false)
+	read, err := suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+
+	suite.assert.True(suite.blockExists(diskIndexName))
+	suite.assert.True(suite.blockExists(name + "::3"))
+
+	// Storage can not be read now so data has to come from disk
+	bc = suite.startBlockCache(store, true)
+	read, err = suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+
+	// Blob changed while it was not mounted so blocks on disk are dropped on open
+	changed := bytes.Repeat([]byte{'x'}, 100)
+	_, err = store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(name), Data: changed})
+	suite.assert.NoError(err)
+	copy(data, changed)
+
+	bc = suite.startBlockCache(store, true)
+	_, err = suite.readFile(bc, name, len(data))
+	suite.assert.Error(err)
+	suite.assert.False(suite.blockExists(name + "::3"))
+	suite.assert.NoError(bc.Stop())
+
+	bc = suite.startBlockCache(store, false)
+	read, err = suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+}
+
+func (suite *diskIndexTestSuite) TestRemountAfterWrite() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\n"))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.NoError(store.Configure(true))
+
+	name := "data.bin"
+	data := bytes.Repeat([]byte{'a'}, 2*64*1024)
+
+	bc := suite.startBlockCache(store, false)
+	h, err := bc.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+
+	read, err := suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+
+	// Overwrite the first block, its older copy on disk is not to be used any more
+	h, err = bc.OpenFile(internal.OpenFileOptions{Name: name, Flags: os.O_RDWR})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("bbbb")})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+	copy(data, "bbbb")
+	suite.assert.NoError(bc.Stop())
+
+	bc = suite.startBlockCache(store, false)
+	read, err = suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+}
+
+func TestDiskIndexTestSuite(t *testing.T) {
+	suite.Run(t, new(diskIndexTestSuite))
+}
@@ -0,0 +1,208 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+)
+
+const (
+	journalEntryName = "entry.json"
+	journalTmpPrefix = ".tmp-"
+)
+
+// journalBlock : One block of the file in order. Block is either already in storage with the given id, or its data
+// is in the journal to be staged on replay. A block with neither is a hole and is staged as zeros.
+type journalBlock struct {
+	Id   string `json:"id,omitempty"`
+	Data bool   `json:"data,omitempty"`
+	Size uint64 `json:"size"`
+}
+
+// journalEntry : File closed with lazy-write whose data is not committed to storage yet
+type journalEntry struct {
+	Path      string         `json:"path"`
+	ETag      string         `json:"etag,omitempty"` // ETag of the blob the changes are made on, empty for a file created by the mount
+	Size      int64          `json:"size"`
+	BlockSize uint64         `json:"block-size"`
+	Closed    time.Time      `json:"closed"`
+	Blocks    []journalBlock `json:"blocks"`
+}
+
+// journal : Write ahead log of uploads pending with lazy-write. An entry is written and synced before close
+// returns and removed once the file is committed, so entries left behind by a crash can be replayed by the next mount.
+// Each entry is a directory holding the entry itself and data of its blocks by index, it is renamed in to place
+// once complete so a partial entry is never replayed.
+type journal struct {
+	path string
+}
+
+func newJournal(path string) *journal {
+	return &journal{path: path}
+}
+
+// record : Write the entry for the handle along with data of its blocks, returns id of the entry
+func (j *journal) record(handleID handlemap.HandleID, entry *journalEntry, data map[int][]byte) (string, error) {
+	id := fmt.Sprintf("%020d_%v", time.Now().UnixNano(), handleID)
+	tmpDir := filepath.Join(j.path, journalTmpPrefix+id)
+
+	err := j.write(tmpDir, entry, data)
+	if err == nil {
+		err = os.Rename(tmpDir, filepath.Join(j.path, id))
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	syncDir(j.path)
+	return id, nil
+}
+
+func (j *journal) write(dir string, entry *journalEntry, data map[int][]byte) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	for index, buf := range data {
+		err = writeSynced(filepath.Join(dir, strconv.Itoa(index)), buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = writeSynced(filepath.Join(dir, journalEntryName), buf)
+	if err != nil {
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// remove : Drop the entry once its data is committed or it is rolled back
+func (j *journal) remove(id string) error {
+	err := os.RemoveAll(filepath.Join(j.path, id))
+	syncDir(j.path)
+	return err
+}
+
+// entries : Ids of the complete entries in the order they were recorded, partial ones are removed
+func (j *journal) entries() ([]string, error) {
+	dirents, err := os.ReadDir(j.path)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(dirents))
+	for _, d := range dirents {
+		if strings.HasPrefix(d.Name(), journalTmpPrefix) {
+			_ = os.RemoveAll(filepath.Join(j.path, d.Name()))
+		} else if d.IsDir() {
+			ids = append(ids, d.Name())
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// load : Read an entry
+func (j *journal) load(id string) (*journalEntry, error) {
+	buf, err := os.ReadFile(filepath.Join(j.path, id, journalEntryName))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &journalEntry{}
+	err = json.Unmarshal(buf, entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// blockData : Read data of a block of the entry
+func (j *journal) blockData(id string, index int, size uint64) ([]byte, error) {
+	buf, err := os.ReadFile(filepath.Join(j.path, id, strconv.Itoa(index)))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(buf)) != size {
+		return nil, fmt.Errorf("block %v has %v bytes, expected %v", index, len(buf), size)
+	}
+	return buf, nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncDir : Make changes to entries of the directory durable
+func syncDir(path string) {
+	if d, err := os.Open(path); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
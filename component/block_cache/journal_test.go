@@ -0,0 +1,241 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/chaos"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type journalTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	dir    string
+	store  internal.Component
+}
+
+func (suite *journalTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+	suite.dir = suite.T().TempDir()
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\n"))
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+}
+
+// startMount : Block cache with lazy-write and journal, storage fails all commits if faulty
+func (suite *journalTestSuite) startMount(faulty bool) *BlockCache {
+	cfg := fmt.Sprintf("lazy-write: true\nblock_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n  journal-path: %s\n", suite.dir)
+	if faulty {
+		cfg += "chaos:\n  rules:\n    - operations: [CommitData]\n      error: EIO\n"
+	}
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	next := suite.store
+	if faulty {
+		next = chaos.NewChaosComponent()
+		suite.assert.NoError(next.Configure(true))
+		next.SetNextComponent(suite.store)
+	}
+
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(next)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.NoError(bc.Start(context.Background()))
+	return bc
+}
+
+func (suite *journalTestSuite) readBlob(name string) []byte {
+	data, err := suite.store.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle(name)})
+	suite.assert.NoError(err)
+	return data
+}
+
+func (suite *journalTestSuite) journalEntries() []string {
+	ids, err := newJournal(suite.dir).entries()
+	suite.assert.NoError(err)
+	return ids
+}
+
+func (suite *journalTestSuite) TestRecordAndLoad() {
+	j := newJournal(suite.dir)
+	entry := &journalEntry{
+		Path:      "dir/a",
+		ETag:      "etag",
+		Size:      20,
+		BlockSize: 8,
+		Closed:    time.Now().Round(0),
+		Blocks:    []journalBlock{{Id: "id0", Size: 8}, {Size: 8}, {Data: true, Size: 4}},
+	}
+
+	id, err := j.record(1, entry, map[int][]byte{2: []byte("abcd")})
+	suite.assert.NoError(err)
+
+	// Entry left half written by a crash is not replayed
+	suite.assert.NoError(os.Mkdir(filepath.Join(suite.dir, journalTmpPrefix+"1"), 0700))
+	ids, err := j.entries()
+	suite.assert.NoError(err)
+	suite.assert.Equal([]string{id}, ids)
+	suite.assert.NoDirExists(filepath.Join(suite.dir, journalTmpPrefix+"1"))
+
+	loaded, err := j.load(id)
+	suite.assert.NoError(err)
+	suite.assert.True(entry.Closed.Equal(loaded.Closed))
+	loaded.Closed = entry.Closed
+	suite.assert.Equal(entry, loaded)
+
+	data, err := j.blockData(id, 2, 4)
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("abcd"), data)
+	_, err = j.blockData(id, 2, 8)
+	suite.assert.Error(err)
+
+	suite.assert.NoError(j.remove(id))
+	ids, _ = j.entries()
+	suite.assert.Empty(ids)
+}
+
+func (suite *journalTestSuite) TestReplay() {
+	data := make([]byte, 3*64*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	// Mount goes away before the file gets committed
+	bc := suite.startMount(true)
+	h, err := bc.CreateFile(internal.CreateFileOptions{Name: "dir/data.bin", Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: data})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.NoError(bc.Stop())
+
+	suite.assert.Len(suite.journalEntries(), 1)
+	suite.assert.Empty(suite.readBlob("dir/data.bin"))
+
+	// Next mount commits it
+	bc = suite.startMount(false)
+	suite.assert.True(bytes.Equal(data, suite.readBlob("dir/data.bin")))
+	suite.assert.Empty(suite.journalEntries())
+	suite.assert.NoError(bc.Stop())
+}
+
+func (suite *journalTestSuite) TestReplayWithHole() {
+	id := base64.StdEncoding.EncodeToString(common.NewUUIDWithLength(16))
+	suite.assert.NoError(suite.store.StageData(internal.StageDataOptions{Name: "data.bin", Id: id, Data: bytes.Repeat([]byte{'a'}, 64*1024)}))
+	suite.assert.NoError(suite.store.CommitData(internal.CommitDataOptions{Name: "data.bin", List: []string{id}, BlockSize: 64 * 1024}))
+
+	bc := suite.startMount(true)
+	h, err := bc.OpenFile(internal.OpenFileOptions{Name: "data.bin", Flags: os.O_RDWR})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 3 * 64 * 1024, Data: []byte("tail")})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.NoError(bc.Stop())
+
+	bc = suite.startMount(false)
+	expected := append(bytes.Repeat([]byte{'a'}, 64*1024), make([]byte, 2*64*1024)...)
+	expected = append(expected, "tail"...)
+	suite.assert.True(bytes.Equal(expected, suite.readBlob("data.bin")))
+	suite.assert.NoError(bc.Stop())
+}
+
+func (suite *journalTestSuite) TestRollback() {
+	bc := suite.startMount(true)
+	h, err := bc.CreateFile(internal.CreateFileOptions{Name: "data.bin", Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("lost")})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.NoError(bc.Stop())
+
+	// Someone else writes the file before the next mount, their data is kept
+	_, err = suite.store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle("data.bin"), Data: []byte("kept")})
+	suite.assert.NoError(err)
+
+	bc = suite.startMount(false)
+	suite.assert.Equal([]byte("kept"), suite.readBlob("data.bin"))
+	suite.assert.Empty(suite.journalEntries())
+	suite.assert.NoError(bc.Stop())
+}
+
+func (suite *journalTestSuite) TestCommittedNotJournaled() {
+	bc := suite.startMount(false)
+	h, err := bc.CreateFile(internal.CreateFileOptions{Name: "data.bin", Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: h, Offset: 0, Data: []byte("data")})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.NoError(bc.Stop())
+
+	suite.assert.Equal([]byte("data"), suite.readBlob("data.bin"))
+	suite.assert.Empty(suite.journalEntries())
+}
+
+func (suite *journalTestSuite) TestConfig() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf("block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  journal-path: %s\n", suite.dir)))
+
+	bc := NewBlockCacheComponent().(*BlockCache)
+	err := bc.Configure(true)
+	suite.assert.Error(err)
+	suite.assert.Contains(err.Error(), "journal-path requires lazy-write")
+}
+
+func TestJournalTestSuite(t *testing.T) {
+	suite.Run(t, new(journalTestSuite))
+}
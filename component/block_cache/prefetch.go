@@ -0,0 +1,283 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"time"
+)
+
+// Access pattern detected on a handle, prefetching is driven by it
+type accessPattern int
+
+const (
+	patternUnknown accessPattern = iota
+	patternSequential
+	patternReverse
+	patternStrided
+	patternInterleaved
+	patternRandom
+)
+
+func (p accessPattern) String() string {
+	return [...]string{"unknown", "sequential", "reverse", "strided", "interleaved", "random"}[p]
+}
+
+const (
+	maxReadStreams = 4                  // Number of streams of reads tracked on a handle
+	maxStride      = 16                 // Largest distance in blocks between two reads of a stream
+	activeReads    = 4 * maxReadStreams // A stream is active if it was read within these many block changes
+	rateInterval   = 100 * time.Millisecond
+)
+
+// readStream : A stream of reads moving through the file, a block at a time in either direction or skipping a fixed number of blocks
+type readStream struct {
+	last    int64     // Block read last
+	stride  int64     // Distance to the next block to be read, 0 till it is known
+	hits    uint32    // Number of reads which followed the stride
+	run     uint32    // Reads in a row which followed the stride, reset by a read not following any stream
+	assumed bool      // Stride is assumed and not yet seen, first read of a handle is taken as sequential
+	next    int64     // Next block to be prefetched for this stream
+	used    uint64    // Read sequence when this stream was read last
+	rate    float64   // Bytes read per second, moving average
+	bytes   uint64    // Bytes read since rate was last computed
+	mark    time.Time // When rate was last computed
+}
+
+// prefetcher : Detects the access pattern of a handle and decides which blocks to prefetch for it.
+// It is used only by the reader holding the handle lock so it needs no locking of its own.
+type prefetcher struct {
+	streams []*readStream
+	current *readStream   // Stream of the last read
+	misses  uint32        // Reads not following a known stride since the last one which did
+	seq     uint64        // Number of times reads moved to a different block
+	pattern accessPattern // Last reported pattern
+}
+
+func newPrefetcher() *prefetcher {
+	return &prefetcher{
+		streams: make([]*readStream, 0, maxReadStreams),
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// observe : Record a read of the given block and find the stream it belongs to
+func (pf *prefetcher) observe(index int64) {
+	// Reads within the same block are part of the stream reading it
+	for _, s := range pf.streams {
+		if s.last == index {
+			pf.current = s
+			return
+		}
+	}
+
+	pf.seq++
+
+	// Reader is following the stride of a stream, it may skip a block it does not need
+	for _, s := range pf.streams {
+		if s.stride == 0 {
+			continue
+		}
+
+		d := index - s.last
+		if d%s.stride == 0 && d/s.stride >= 1 && d/s.stride <= 2 {
+			s.hits++
+			s.run++
+			s.assumed = false
+			pf.follow(s, index)
+
+			// Once declared random, lucky hits scattered between other reads are not enough to go back to prefetching
+			if !pf.random() || s.run >= 2 {
+				pf.misses = 0
+			}
+			return
+		}
+	}
+
+	pf.misses++
+	for _, s := range pf.streams {
+		s.run = 0
+	}
+
+	// Second read of a stream close to the first one sets its stride
+	var closest *readStream
+	for _, s := range pf.streams {
+		if s.hits != 0 {
+			continue
+		}
+
+		d := abs(index - s.last)
+		if d <= maxStride && (closest == nil || d < abs(index-closest.last)) {
+			closest = s
+		}
+	}
+
+	if closest != nil {
+		closest.stride = index - closest.last
+		closest.assumed = false
+		pf.follow(closest, index)
+		return
+	}
+
+	// Start a new stream, in place of the least recently read one if there are too many
+	s := &readStream{
+		last: index,
+		next: index + 1,
+		used: pf.seq,
+		mark: time.Now(),
+	}
+
+	if len(pf.streams) == 0 {
+		// Files are mostly read from start to end, so prefetch right away for the first read
+		s.stride = 1
+		s.assumed = true
+	}
+
+	if len(pf.streams) < maxReadStreams {
+		pf.streams = append(pf.streams, s)
+	} else {
+		lru := 0
+		for i := range pf.streams {
+			if pf.streams[i].used < pf.streams[lru].used {
+				lru = i
+			}
+		}
+		pf.streams[lru] = s
+	}
+
+	pf.current = s
+}
+
+// follow : Move the stream to the block read now
+func (pf *prefetcher) follow(s *readStream, index int64) {
+	s.last = index
+	s.used = pf.seq
+	pf.current = s
+
+	// Blocks prefetched so far are behind the reader now
+	if (s.next-index)*s.stride <= 0 {
+		s.next = index + s.stride
+	}
+}
+
+// account : Add the data read by current stream to its read rate
+func (pf *prefetcher) account(bytes int) {
+	s := pf.current
+	if s == nil {
+		return
+	}
+
+	s.bytes += uint64(bytes)
+	elapsed := time.Since(s.mark)
+	if elapsed < rateInterval {
+		return
+	}
+
+	rate := float64(s.bytes) / elapsed.Seconds()
+	if s.rate == 0 {
+		s.rate = rate
+	} else {
+		s.rate = (s.rate + rate) / 2
+	}
+
+	s.bytes = 0
+	s.mark = time.Now()
+}
+
+// random : Reads have not been following any stream for long
+func (pf *prefetcher) random() bool {
+	return pf.misses > MIN_RANDREAD
+}
+
+// active : Stream is being read and its direction is known
+func (pf *prefetcher) active(s *readStream) bool {
+	return !pf.random() && s.stride != 0 && (s.hits > 0 || s.assumed) && pf.seq-s.used <= activeReads
+}
+
+// depth : Number of blocks to keep prefetched for the stream. Handle gets budget number of blocks
+// which are shared by its active streams in proportion to their read rate.
+func (pf *prefetcher) depth(s *readStream, budget uint32) uint32 {
+	total, count := float64(0), 0
+	for _, st := range pf.streams {
+		if pf.active(st) {
+			total += st.rate
+			count++
+		}
+	}
+
+	depth := budget
+	if count > 1 {
+		if total > 0 && s.rate > 0 {
+			depth = uint32(float64(budget) * s.rate / total)
+		} else {
+			depth = budget / uint32(count)
+		}
+	}
+
+	return max(depth, 1)
+}
+
+// classify : Pattern the handle is being read in
+func (pf *prefetcher) classify() accessPattern {
+	if pf.random() {
+		return patternRandom
+	}
+
+	var stream *readStream
+	count := 0
+	for _, s := range pf.streams {
+		if pf.active(s) && !s.assumed {
+			stream = s
+			count++
+		}
+	}
+
+	switch {
+	case count > 1:
+		return patternInterleaved
+	case count == 0:
+		return patternUnknown
+	case stream.stride == 1:
+		return patternSequential
+	case stream.stride == -1:
+		return patternReverse
+	default:
+		return patternStrided
+	}
+}
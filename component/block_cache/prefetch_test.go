@@ -0,0 +1,267 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type prefetchTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func (suite *prefetchTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+}
+
+func observeAll(pf *prefetcher, blocks ...int64) {
+	for _, b := range blocks {
+		pf.observe(b)
+	}
+}
+
+func (suite *prefetchTestSuite) TestSequential() {
+	pf := newPrefetcher()
+
+	pf.observe(0)
+	suite.assert.True(pf.active(pf.current))
+	suite.assert.Equal(patternUnknown, pf.classify())
+
+	observeAll(pf, 0, 1, 1, 2, 3)
+	suite.assert.Equal(patternSequential, pf.classify())
+	suite.assert.EqualValues(1, pf.current.stride)
+	suite.assert.EqualValues(4, pf.current.next)
+
+	// Reader skipping a block is still the same stream
+	pf.observe(5)
+	suite.assert.Equal(patternSequential, pf.classify())
+	suite.assert.Len(pf.streams, 1)
+}
+
+func (suite *prefetchTestSuite) TestReverse() {
+	pf := newPrefetcher()
+
+	observeAll(pf, 99, 98)
+	suite.assert.EqualValues(-1, pf.current.stride)
+	suite.assert.EqualValues(97, pf.current.next)
+
+	observeAll(pf, 97, 96)
+	suite.assert.Equal(patternReverse, pf.classify())
+	suite.assert.True(pf.active(pf.current))
+}
+
+func (suite *prefetchTestSuite) TestStrided() {
+	pf := newPrefetcher()
+
+	observeAll(pf, 10, 14, 18, 22)
+	suite.assert.Equal(patternStrided, pf.classify())
+	suite.assert.EqualValues(4, pf.current.stride)
+	suite.assert.EqualValues(26, pf.current.next)
+}
+
+func (suite *prefetchTestSuite) TestInterleaved() {
+	pf := newPrefetcher()
+
+	// Two columns of a file read alternately
+	for i := int64(0); i < 5; i++ {
+		observeAll(pf, i, 100+i)
+	}
+	suite.assert.Equal(patternInterleaved, pf.classify())
+	suite.assert.Len(pf.streams, 2)
+	suite.assert.False(pf.random())
+
+	// Stream reading faster gets more of the blocks
+	pf.streams[0].rate = 300
+	pf.streams[1].rate = 100
+	suite.assert.EqualValues(9, pf.depth(pf.streams[0], 12))
+	suite.assert.EqualValues(3, pf.depth(pf.streams[1], 12))
+
+	pf.streams[0].rate = 0
+	suite.assert.EqualValues(6, pf.depth(pf.streams[0], 12))
+}
+
+func (suite *prefetchTestSuite) TestRandom() {
+	pf := newPrefetcher()
+
+	observeAll(pf, 500, 40, 900, 260, 700, 120, 980, 330, 610, 10, 450, 800)
+	suite.assert.True(pf.random())
+	suite.assert.Equal(patternRandom, pf.classify())
+	suite.assert.False(pf.active(pf.current))
+	suite.assert.LessOrEqual(len(pf.streams), maxReadStreams)
+
+	// Single lucky hit does not end random mode
+	observeAll(pf, 801, 802)
+	suite.assert.True(pf.random())
+
+	observeAll(pf, 803)
+	suite.assert.False(pf.random())
+	suite.assert.Equal(patternSequential, pf.classify())
+}
+
+func (suite *prefetchTestSuite) TestAccount() {
+	pf := newPrefetcher()
+	pf.account(100)
+
+	pf.observe(0)
+	pf.current.mark = pf.current.mark.Add(-rateInterval)
+	pf.account(1000)
+	suite.assert.Greater(pf.current.rate, float64(0))
+	suite.assert.Zero(pf.current.bytes)
+}
+
+// Blocks read through block cache in various patterns, prefetch shall keep the blocks to be read next ready
+func (suite *prefetchTestSuite) setupBlockCache(data []byte) (*BlockCache, *handlemap.Handle) {
+	cfg := "block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n"
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	store := memstore.NewMemStoreComponent()
+	suite.assert.NoError(store.Configure(true))
+
+	_, err := store.CreateFile(internal.CreateFileOptions{Name: "data", Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle("data"), Data: data})
+	suite.assert.NoError(err)
+
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(store)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.NoError(bc.Start(context.Background()))
+
+	h, err := bc.OpenFile(internal.OpenFileOptions{Name: "data"})
+	suite.assert.NoError(err)
+	return bc, h
+}
+
+func (suite *prefetchTestSuite) readBlocks(bc *BlockCache, h *handlemap.Handle, data []byte, blocks ...int64) {
+	for _, b := range blocks {
+		buf := make([]byte, 1000)
+		n, err := bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: b * rwBlockSize, Data: buf})
+		if err != io.EOF {
+			suite.assert.NoError(err)
+		}
+		suite.assert.True(bytes.Equal(data[b*rwBlockSize:b*rwBlockSize+int64(n)], buf[:n]))
+	}
+}
+
+func (suite *prefetchTestSuite) lined(h *handlemap.Handle, index int64) bool {
+	_, found := h.GetValue(fmt.Sprintf("%v", index))
+	return found
+}
+
+func (suite *prefetchTestSuite) TestReversePrefetch() {
+	data := make([]byte, 64*rwBlockSize)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+
+	bc, h := suite.setupBlockCache(data)
+	defer func() { _ = bc.Stop() }()
+
+	suite.readBlocks(bc, h, data, 63, 62, 61, 60)
+	pf := getPrefetcher(h)
+	suite.assert.Equal(patternReverse, pf.pattern)
+	suite.assert.True(suite.lined(h, 59))
+	suite.assert.True(suite.lined(h, 55))
+	suite.assert.LessOrEqual(h.Buffers.Cooked.Len()+h.Buffers.Cooking.Len(), 12)
+
+	suite.readBlocks(bc, h, data, 59, 58, 57, 56, 55, 54, 53)
+	suite.assert.True(suite.lined(h, 50))
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+}
+
+func (suite *prefetchTestSuite) TestInterleavedPrefetch() {
+	data := make([]byte, 64*rwBlockSize)
+	for i := range data {
+		data[i] = byte(i % 241)
+	}
+
+	bc, h := suite.setupBlockCache(data)
+	defer func() { _ = bc.Stop() }()
+
+	for i := int64(0); i < 6; i++ {
+		suite.readBlocks(bc, h, data, i, 32+i)
+	}
+
+	pf := getPrefetcher(h)
+	suite.assert.Equal(patternInterleaved, pf.pattern)
+	suite.assert.True(suite.lined(h, 6))
+	suite.assert.True(suite.lined(h, 38))
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+}
+
+func (suite *prefetchTestSuite) TestPoolPressure() {
+	data := make([]byte, 64*rwBlockSize)
+	bc, h := suite.setupBlockCache(data)
+	defer func() { _ = bc.Stop() }()
+
+	suite.assert.EqualValues(12, bc.prefetchBudget())
+
+	// Take away most of the pool as if other handles are using it
+	taken := make([]*Block, 0)
+	for bc.blockPool.Usage() < MAX_POOL_USAGE {
+		taken = append(taken, bc.blockPool.MustGet())
+	}
+	suite.assert.EqualValues(MIN_PREFETCH, bc.prefetchBudget())
+
+	suite.readBlocks(bc, h, data, 0, 1, 2, 3, 4, 5)
+	suite.assert.LessOrEqual(h.Buffers.Cooked.Len()+h.Buffers.Cooking.Len(), MIN_PREFETCH+1)
+
+	for _, b := range taken {
+		bc.blockPool.Release(b)
+	}
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: h}))
+}
+
+func TestPrefetchTestSuite(t *testing.T) {
+	suite.Run(t, new(prefetchTestSuite))
+}
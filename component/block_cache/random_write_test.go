@@ -0,0 +1,271 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// Property based tests for random writes. A random sequence of writes, reads, flushes and reopens is applied
+// to a file through block cache and to a local reference file, both must hold the same data at every step.
+// memstore is used as storage as it follows block blob semantics for staged and committed block lists.
+
+type randomWriteTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	bc     *BlockCache
+	store  internal.Component
+}
+
+const rwBlockSize = 64 * 1024
+
+func (suite *randomWriteTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+
+	cfg := "block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n"
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+
+	suite.bc = NewBlockCacheComponent().(*BlockCache)
+	suite.bc.SetNextComponent(suite.store)
+	suite.assert.NoError(suite.bc.Configure(true))
+	suite.assert.NoError(suite.bc.Start(context.Background()))
+}
+
+func (suite *randomWriteTestSuite) TearDownTest() {
+	_ = suite.bc.Stop()
+}
+
+// rwModel : File under test along with its reference copy
+type rwModel struct {
+	suite  *randomWriteTestSuite
+	rand   *rand.Rand
+	name   string
+	handle *handlemap.Handle
+	ref    *os.File
+	size   int64
+	trace  []string
+}
+
+func (m *rwModel) fail(msg string) {
+	m.suite.T().Fatalf("%s\nsteps:\n  %s", msg, strings.Join(m.trace, "\n  "))
+}
+
+func (m *rwModel) step(format string, args ...any) {
+	m.trace = append(m.trace, fmt.Sprintf(format, args...))
+}
+
+func (m *rwModel) open() {
+	m.step("open")
+	h, err := m.suite.bc.OpenFile(internal.OpenFileOptions{Name: m.name, Flags: os.O_RDWR})
+	if err != nil {
+		m.fail(fmt.Sprintf("open failed [%v]", err))
+	}
+	if h.Size != m.size {
+		m.fail(fmt.Sprintf("size on open %v, expected %v", h.Size, m.size))
+	}
+	m.handle = h
+}
+
+func (m *rwModel) close() {
+	m.step("close")
+	err := m.suite.bc.CloseFile(internal.CloseFileOptions{Handle: m.handle})
+	if err != nil {
+		m.fail(fmt.Sprintf("close failed [%v]", err))
+	}
+	m.handle = nil
+	m.verifyStorage()
+}
+
+func (m *rwModel) write(offset int64, length int) {
+	m.step("write offset %v, length %v", offset, length)
+	data := make([]byte, length)
+	_, _ = m.rand.Read(data)
+
+	n, err := m.suite.bc.WriteFile(internal.WriteFileOptions{Handle: m.handle, Offset: offset, Data: data})
+	if err != nil || n != length {
+		m.fail(fmt.Sprintf("write failed [%v bytes, %v]", n, err))
+	}
+	_, _ = m.ref.WriteAt(data, offset)
+	m.size = max(m.size, offset+int64(length))
+}
+
+func (m *rwModel) read(offset int64, length int) {
+	m.step("read offset %v, length %v", offset, length)
+	data := make([]byte, length)
+	n, err := m.suite.bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: m.handle, Offset: offset, Data: data})
+
+	expected := make([]byte, length)
+	en, _ := m.ref.ReadAt(expected, offset)
+	if n != en {
+		m.fail(fmt.Sprintf("read %v bytes, expected %v [%v]", n, en, err))
+	}
+	if !bytes.Equal(data[:n], expected[:en]) {
+		m.fail("read data mismatch")
+	}
+}
+
+func (m *rwModel) flush() {
+	m.step("flush")
+	err := m.suite.bc.FlushFile(internal.FlushFileOptions{Handle: m.handle})
+	if err != nil {
+		m.fail(fmt.Sprintf("flush failed [%v]", err))
+	}
+	m.verifyStorage()
+}
+
+// verifyStorage : Committed blob has to match the reference file
+func (m *rwModel) verifyStorage() {
+	stored, err := m.suite.store.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle(m.name)})
+	if err != nil {
+		m.fail(fmt.Sprintf("failed to read blob [%v]", err))
+	}
+	expected, _ := os.ReadFile(m.ref.Name())
+	if !bytes.Equal(stored, expected) {
+		diff := 0
+		for diff < min(len(stored), len(expected)) && stored[diff] == expected[diff] {
+			diff++
+		}
+		m.fail(fmt.Sprintf("blob of %v bytes does not match reference of %v bytes at offset %v", len(stored), len(expected), diff))
+	}
+}
+
+func (suite *randomWriteTestSuite) runModel(seed int64, steps int) {
+	m := &rwModel{
+		suite: suite,
+		rand:  rand.New(rand.NewSource(seed)),
+		name:  fmt.Sprintf("file_%v", seed),
+	}
+
+	var err error
+	m.ref, err = os.Create(filepath.Join(suite.T().TempDir(), m.name))
+	suite.assert.NoError(err)
+	defer m.ref.Close()
+
+	m.handle, err = suite.bc.CreateFile(internal.CreateFileOptions{Name: m.name, Mode: 0777})
+	suite.assert.NoError(err)
+	m.step("seed %v, create", seed)
+
+	for i := 0; i < steps; i++ {
+		switch op := m.rand.Intn(100); {
+		case op < 55:
+			// Overwrite, extend or write past the end leaving a hole, sizes are around the block size
+			offset := m.rand.Int63n(m.size + 3*rwBlockSize + 1)
+			if m.rand.Intn(4) == 0 {
+				// Align to block boundary to hit full block overwrites
+				offset -= offset % rwBlockSize
+			}
+			m.write(offset, 1+m.rand.Intn(2*rwBlockSize))
+		case op < 80:
+			if m.size > 0 {
+				offset := m.rand.Int63n(m.size)
+				m.read(offset, 1+m.rand.Intn(2*rwBlockSize))
+			}
+		case op < 90:
+			m.flush()
+		default:
+			m.close()
+			m.open()
+		}
+	}
+
+	m.close()
+}
+
+func (suite *randomWriteTestSuite) TestRandomWrites() {
+	for seed := int64(1); seed <= 40; seed++ {
+		suite.runModel(seed, 60)
+	}
+}
+
+// Many small writes scattered over a larger file, evicting and re-fetching staged blocks
+func (suite *randomWriteTestSuite) TestRandomWritesLong() {
+	for seed := int64(100); seed <= 104; seed++ {
+		suite.runModel(seed, 400)
+	}
+}
+
+// Write that fails after the last block was extended must not leave the file extended
+func (suite *randomWriteTestSuite) TestFailedWriteRestoresSize() {
+	m := &rwModel{
+		suite: suite,
+		rand:  rand.New(rand.NewSource(1)),
+		name:  "file_failed_write",
+	}
+
+	var err error
+	m.ref, err = os.Create(filepath.Join(suite.T().TempDir(), m.name))
+	suite.assert.NoError(err)
+	defer m.ref.Close()
+
+	m.handle, err = suite.bc.CreateFile(internal.CreateFileOptions{Name: m.name, Mode: 0777})
+	suite.assert.NoError(err)
+	m.write(0, rwBlockSize+100)
+	m.flush()
+
+	// Block beyond the limit can not be allocated, so nothing gets written
+	n, err := suite.bc.WriteFile(internal.WriteFileOptions{Handle: m.handle, Offset: MAX_BLOCKS * rwBlockSize, Data: make([]byte, 10)})
+	suite.assert.Error(err)
+	suite.assert.Equal(0, n)
+	suite.assert.EqualValues(rwBlockSize+100, m.handle.Size)
+
+	m.write(rwBlockSize+50, 100)
+	m.close()
+}
+
+func TestRandomWriteTestSuite(t *testing.T) {
+	suite.Run(t, new(randomWriteTestSuite))
+}
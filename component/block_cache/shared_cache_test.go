@@ -0,0 +1,225 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package block_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/chaos"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/shared_cache"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type sharedCacheTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	dir    string
+	server *shared_cache.Server
+	store  internal.Component
+}
+
+func (suite *sharedCacheTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+
+	// Unix socket paths are limited in length so keep it short
+	suite.dir, err = os.MkdirTemp("", "sc")
+	suite.assert.NoError(err)
+
+	suite.server = shared_cache.NewServer(filepath.Join(suite.dir, "cache.sock"), 4*1024*1024)
+	suite.assert.NoError(suite.server.Start())
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("memstore:\n  latency-ms: 0\n"))
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+}
+
+func (suite *sharedCacheTestSuite) TearDownTest() {
+	suite.server.Stop()
+	_ = os.RemoveAll(suite.dir)
+}
+
+// startMount : Block cache attached to the shared cache, storage fails all reads if faulty
+func (suite *sharedCacheTestSuite) startMount(faulty bool) *BlockCache {
+	cfg := fmt.Sprintf("block_cache:\n  block-size-mb: 0.0625\n  mem-size-mb: 4\n  prefetch: 12\n  parallelism: 4\n  shared-cache: %s\n  shared-cache-namespace: test\n",
+		filepath.Join(suite.dir, "cache.sock"))
+	if faulty {
+		cfg += "chaos:\n  rules:\n    - operations: [ReadInBuffer]\n      error: EIO\n"
+	}
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	next := suite.store
+	if faulty {
+		next = chaos.NewChaosComponent()
+		suite.assert.NoError(next.Configure(true))
+		next.SetNextComponent(suite.store)
+	}
+
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(next)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.NoError(bc.Start(context.Background()))
+	return bc
+}
+
+func (suite *sharedCacheTestSuite) readFile(bc *BlockCache, name string, size int) ([]byte, error) {
+	h, err := bc.OpenFile(internal.OpenFileOptions{Name: name, Flags: os.O_RDONLY})
+	suite.assert.NoError(err)
+	defer func() { _ = bc.CloseFile(internal.CloseFileOptions{Handle: h}) }()
+
+	data := make([]byte, size)
+	n, err := bc.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: data})
+	if err == io.EOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+func (suite *sharedCacheTestSuite) writeBlob(name string, data []byte) {
+	_, err := suite.store.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = suite.store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(name), Data: data})
+	suite.assert.NoError(err)
+}
+
+func (suite *sharedCacheTestSuite) TestReadThroughOtherMount() {
+	name := "dir/data.bin"
+	data := make([]byte, 3*64*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	suite.writeBlob(name, data)
+
+	bc := suite.startMount(false)
+	read, err := suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+
+	stats := suite.server.Stats()
+	suite.assert.Equal(4, stats.Blocks)
+	suite.assert.EqualValues(len(data), stats.Used)
+
+	// Storage can not be read now so data has to come from the blocks cached by the other mount
+	bc = suite.startMount(true)
+	read, err = suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+
+	// Same blocks are not cached again
+	suite.assert.EqualValues(len(data), suite.server.Stats().Used)
+	suite.assert.EqualValues(4, suite.server.Stats().Hits)
+
+	// Blob changed so blocks of the older version are not to be used
+	suite.writeBlob(name, bytes.Repeat([]byte{'x'}, len(data)))
+	bc = suite.startMount(true)
+	_, err = suite.readFile(bc, name, len(data))
+	suite.assert.Error(err)
+	suite.assert.NoError(bc.Stop())
+}
+
+func (suite *sharedCacheTestSuite) TestCacheNotRunning() {
+	suite.server.Stop()
+	suite.server = shared_cache.NewServer(filepath.Join(suite.dir, "other.sock"), 1024)
+	suite.assert.NoError(suite.server.Start())
+
+	name := "data.bin"
+	data := bytes.Repeat([]byte{'a'}, 2*64*1024)
+	suite.writeBlob(name, data)
+
+	// Mount works with storage alone when shared cache is not there
+	bc := suite.startMount(false)
+	read, err := suite.readFile(bc, name, len(data))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, read))
+	suite.assert.NoError(bc.Stop())
+}
+
+func (suite *sharedCacheTestSuite) TestMemSize() {
+	// Mount keeps only the blocks it is working on when blocks are cached in the shared cache
+	cfg := fmt.Sprintf("block_cache:\n  block-size-mb: 0.0625\n  prefetch: 12\n  parallelism: 4\n  shared-cache: %s\n",
+		filepath.Join(suite.dir, "cache.sock"))
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	bc := NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(suite.store)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.EqualValues((2*12+1)*64*1024, bc.memSize)
+	bc.blockPool.Terminate()
+
+	// Memory set in config is used as is
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg + "  mem-size-mb: 4\n"))
+
+	bc = NewBlockCacheComponent().(*BlockCache)
+	bc.SetNextComponent(suite.store)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.EqualValues(4*1024*1024, bc.memSize)
+	bc.blockPool.Terminate()
+}
+
+func (suite *sharedCacheTestSuite) TestNamespace() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("mount-path: /mnt/a\nazstorage:\n  account-name: acc\n  container: cont\n"))
+	suite.assert.Equal("acc/cont", getSharedNamespace())
+
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader("mount-path: /mnt/a\n"))
+	suite.assert.Equal("/mnt/a", getSharedNamespace())
+}
+
+func TestSharedCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(sharedCacheTestSuite))
+}
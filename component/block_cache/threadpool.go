@@ -35,6 +35,7 @@ package block_cache
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
 )
@@ -63,12 +64,15 @@ type ThreadPool struct {
 
 // One workitem to be scheduled
 type workItem struct {
-	handle   *handlemap.Handle // Handle to which this item belongs
-	block    *Block            // Block to hold data for this item
-	prefetch bool              // Flag marking this is a prefetch request or not
-	failCnt  int32             // How many times this item has failed to download
-	upload   bool              // Flag marking this is a upload request or not
-	blockId  string            // BlockId of the block
+	handle   *handlemap.Handle       // Handle to which this item belongs
+	block    *Block                  // Block to hold data for this item
+	prefetch bool                    // Flag marking this is a prefetch request or not
+	failCnt  int32                   // How many times this item has failed to download
+	upload   bool                    // Flag marking this is a upload request or not
+	blockId  string                  // BlockId of the block
+	size     uint64                  // Size of the data to be uploaded
+	etag     string                  // ETag of the blob the block is downloaded from
+	current  *atomic.Pointer[string] // ETag the handle knows now, moves on when the handle changes the blob
 }
 
 // newThreadPool creates a new thread pool
@@ -0,0 +1,524 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+)
+
+// Chaos component Config specifications:
+//
+//	chaos:
+//		seed: <seed for probabilistic faults>
+//		rules:
+//			- operations: <list of calls the rule applies to>
+//			  path: <glob of paths the rule applies to>
+//			  error: <errno to fail the call with>
+//			  probability: <percentage of matching calls to fault>
+//			  skip: <number of matching calls to let through first>
+//			  count: <maximum number of faults to inject>
+//			  per-path: <track skip and count per path>
+//			  latency-ms: <delay added to faulted calls>
+//			  partial-read: <return only part of the data asked for>
+//			  short-write: <write only part of the data given>
+//
+// Chaos passes every call to the next component, injecting the configured faults on the way. It can be placed
+// anywhere in the pipeline to exercise the error handling of the components above it.
+
+const compName = "chaos"
+
+type Chaos struct {
+	internal.BaseComponent
+
+	rules    []*rule
+	rand     *rand.Rand
+	randLock sync.Mutex
+}
+
+var _ internal.Component = &Chaos{}
+
+type ChaosOptions struct {
+	Seed  int64         `config:"seed" yaml:"seed,omitempty"`
+	Rules []RuleOptions `config:"rules" yaml:"rules,omitempty"`
+}
+
+func (c *Chaos) Name() string {
+	return compName
+}
+
+func (c *Chaos) SetName(name string) {
+	c.BaseComponent.SetName(name)
+}
+
+func (c *Chaos) SetNextComponent(nc internal.Component) {
+	c.BaseComponent.SetNextComponent(nc)
+}
+
+func (c *Chaos) Priority() internal.ComponentPriority {
+	return internal.EComponentPriority.Any()
+}
+
+// Configure : Pipeline will call this method after constructor so that you can read config and initialize yourself
+func (c *Chaos) Configure(_ bool) error {
+	log.Trace("Chaos::Configure : %s", c.Name())
+
+	conf := ChaosOptions{}
+	err := config.UnmarshalKey(c.Name(), &conf)
+	if err != nil {
+		log.Err("Chaos::Configure : config error [invalid config attributes]")
+		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+	}
+
+	c.rules = make([]*rule, 0, len(conf.Rules))
+	for i, opt := range conf.Rules {
+		r, err := newRule(opt)
+		if err != nil {
+			log.Err("Chaos::Configure : config error [rule %d: %s]", i, err.Error())
+			return fmt.Errorf("config error in %s [rule %d: %s]", c.Name(), i, err.Error())
+		}
+		c.rules = append(c.rules, r)
+	}
+
+	seed := conf.Seed
+	if !config.IsSet(compName + ".seed") {
+		seed = time.Now().UnixNano()
+	}
+	c.rand = rand.New(rand.NewSource(seed))
+
+	log.Crit("Chaos::Configure : %d rules, seed %v", len(c.rules), seed)
+	return nil
+}
+
+func (c *Chaos) Start(ctx context.Context) error {
+	log.Trace("Chaos::Start : Starting component %s", c.Name())
+	return nil
+}
+
+func (c *Chaos) Stop() error {
+	log.Trace("Chaos::Stop : Stopping component %s", c.Name())
+	return nil
+}
+
+func (c *Chaos) roll() float64 {
+	c.randLock.Lock()
+	defer c.randLock.Unlock()
+	return c.rand.Float64()
+}
+
+// inject : Run a call through the rules, returns the error to fail it with or whether its data shall be cut short
+func (c *Chaos) inject(op string, name string) (bool, error) {
+	short := false
+	for _, r := range c.rules {
+		if !r.matches(op, name) || !r.fire(name, c.roll) {
+			continue
+		}
+
+		if r.latency > 0 {
+			time.Sleep(r.latency)
+		}
+
+		if r.err != nil {
+			log.Warn("Chaos::inject : Failing %s of %s with %s", op, name, r.err.Error())
+			return false, r.err
+		}
+
+		if (r.partialRead && partialReadOps[op]) || (r.shortWrite && shortWriteOps[op]) {
+			log.Warn("Chaos::inject : Cutting %s of %s short", op, name)
+			short = true
+		}
+	}
+	return short, nil
+}
+
+func handlePath(handle *handlemap.Handle) string {
+	if handle == nil {
+		return ""
+	}
+	return handle.Path
+}
+
+// ------------------------- Core Operations -------------------------------------------
+
+func (c *Chaos) CreateDir(options internal.CreateDirOptions) error {
+	if _, err := c.inject("CreateDir", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().CreateDir(options)
+}
+
+func (c *Chaos) DeleteDir(options internal.DeleteDirOptions) error {
+	if _, err := c.inject("DeleteDir", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().DeleteDir(options)
+}
+
+func (c *Chaos) IsDirEmpty(options internal.IsDirEmptyOptions) bool {
+	if _, err := c.inject("IsDirEmpty", options.Name); err != nil {
+		return false
+	}
+	return c.NextComponent().IsDirEmpty(options)
+}
+
+func (c *Chaos) DeleteEmptyDirs(options internal.DeleteDirOptions) (bool, error) {
+	if _, err := c.inject("DeleteEmptyDirs", options.Name); err != nil {
+		return false, err
+	}
+	return c.NextComponent().DeleteEmptyDirs(options)
+}
+
+func (c *Chaos) OpenDir(options internal.OpenDirOptions) error {
+	if _, err := c.inject("OpenDir", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().OpenDir(options)
+}
+
+func (c *Chaos) ReadDir(options internal.ReadDirOptions) ([]*internal.ObjAttr, error) {
+	if _, err := c.inject("ReadDir", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().ReadDir(options)
+}
+
+func (c *Chaos) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
+	if _, err := c.inject("StreamDir", options.Name); err != nil {
+		return nil, "", err
+	}
+	return c.NextComponent().StreamDir(options)
+}
+
+func (c *Chaos) CloseDir(options internal.CloseDirOptions) error {
+	if _, err := c.inject("CloseDir", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().CloseDir(options)
+}
+
+func (c *Chaos) RenameDir(options internal.RenameDirOptions) error {
+	if _, err := c.inject("RenameDir", options.Src); err != nil {
+		return err
+	}
+	return c.NextComponent().RenameDir(options)
+}
+
+func (c *Chaos) CreateFile(options internal.CreateFileOptions) (*handlemap.Handle, error) {
+	if _, err := c.inject("CreateFile", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().CreateFile(options)
+}
+
+func (c *Chaos) DeleteFile(options internal.DeleteFileOptions) error {
+	if _, err := c.inject("DeleteFile", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().DeleteFile(options)
+}
+
+func (c *Chaos) OpenFile(options internal.OpenFileOptions) (*handlemap.Handle, error) {
+	if _, err := c.inject("OpenFile", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().OpenFile(options)
+}
+
+func (c *Chaos) CloseFile(options internal.CloseFileOptions) error {
+	if _, err := c.inject("CloseFile", handlePath(options.Handle)); err != nil {
+		return err
+	}
+	return c.NextComponent().CloseFile(options)
+}
+
+func (c *Chaos) RenameFile(options internal.RenameFileOptions) error {
+	if _, err := c.inject("RenameFile", options.Src); err != nil {
+		return err
+	}
+	return c.NextComponent().RenameFile(options)
+}
+
+// ReadFile : A partial read returns the first half of the data
+func (c *Chaos) ReadFile(options internal.ReadFileOptions) ([]byte, error) {
+	short, err := c.inject("ReadFile", handlePath(options.Handle))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.NextComponent().ReadFile(options)
+	if err == nil && short && len(data) > 1 {
+		data = data[:len(data)/2]
+	}
+	return data, err
+}
+
+// ReadInBuffer : A partial read asks the next component for only the first half of the buffer
+func (c *Chaos) ReadInBuffer(options internal.ReadInBufferOptions) (int, error) {
+	short, err := c.inject("ReadInBuffer", handlePath(options.Handle))
+	if err != nil {
+		return 0, err
+	}
+
+	if short && len(options.Data) > 1 {
+		options.Data = options.Data[:len(options.Data)/2]
+	}
+	return c.NextComponent().ReadInBuffer(options)
+}
+
+// WriteFile : A short write gives the next component only the first half of the data
+func (c *Chaos) WriteFile(options internal.WriteFileOptions) (int, error) {
+	short, err := c.inject("WriteFile", handlePath(options.Handle))
+	if err != nil {
+		return 0, err
+	}
+
+	if short && len(options.Data) > 1 {
+		options.Data = options.Data[:len(options.Data)/2]
+	}
+	return c.NextComponent().WriteFile(options)
+}
+
+func (c *Chaos) TruncateFile(options internal.TruncateFileOptions) error {
+	if _, err := c.inject("TruncateFile", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().TruncateFile(options)
+}
+
+func (c *Chaos) CopyToFile(options internal.CopyToFileOptions) error {
+	if _, err := c.inject("CopyToFile", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().CopyToFile(options)
+}
+
+func (c *Chaos) CopyFromFile(options internal.CopyFromFileOptions) error {
+	if _, err := c.inject("CopyFromFile", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().CopyFromFile(options)
+}
+
+func (c *Chaos) SyncDir(options internal.SyncDirOptions) error {
+	if _, err := c.inject("SyncDir", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().SyncDir(options)
+}
+
+func (c *Chaos) SyncFile(options internal.SyncFileOptions) error {
+	if _, err := c.inject("SyncFile", handlePath(options.Handle)); err != nil {
+		return err
+	}
+	return c.NextComponent().SyncFile(options)
+}
+
+func (c *Chaos) FlushFile(options internal.FlushFileOptions) error {
+	if _, err := c.inject("FlushFile", handlePath(options.Handle)); err != nil {
+		return err
+	}
+	return c.NextComponent().FlushFile(options)
+}
+
+func (c *Chaos) ReleaseFile(options internal.ReleaseFileOptions) error {
+	if _, err := c.inject("ReleaseFile", handlePath(options.Handle)); err != nil {
+		return err
+	}
+	return c.NextComponent().ReleaseFile(options)
+}
+
+func (c *Chaos) UnlinkFile(options internal.UnlinkFileOptions) error {
+	if _, err := c.inject("UnlinkFile", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().UnlinkFile(options)
+}
+
+func (c *Chaos) CreateLink(options internal.CreateLinkOptions) error {
+	if _, err := c.inject("CreateLink", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().CreateLink(options)
+}
+
+func (c *Chaos) ReadLink(options internal.ReadLinkOptions) (string, error) {
+	if _, err := c.inject("ReadLink", options.Name); err != nil {
+		return "", err
+	}
+	return c.NextComponent().ReadLink(options)
+}
+
+func (c *Chaos) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr, error) {
+	if _, err := c.inject("GetAttr", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().GetAttr(options)
+}
+
+func (c *Chaos) SetAttr(options internal.SetAttrOptions) error {
+	if _, err := c.inject("SetAttr", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().SetAttr(options)
+}
+
+func (c *Chaos) Chmod(options internal.ChmodOptions) error {
+	if _, err := c.inject("Chmod", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().Chmod(options)
+}
+
+func (c *Chaos) Chown(options internal.ChownOptions) error {
+	if _, err := c.inject("Chown", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().Chown(options)
+}
+
+func (c *Chaos) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	if _, err := c.inject("GetXattr", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().GetXattr(options)
+}
+
+func (c *Chaos) SetXattr(options internal.SetXattrOptions) error {
+	if _, err := c.inject("SetXattr", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().SetXattr(options)
+}
+
+func (c *Chaos) ListXattr(options internal.ListXattrOptions) ([]string, error) {
+	if _, err := c.inject("ListXattr", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().ListXattr(options)
+}
+
+func (c *Chaos) RemoveXattr(options internal.RemoveXattrOptions) error {
+	if _, err := c.inject("RemoveXattr", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().RemoveXattr(options)
+}
+
+func (c *Chaos) AcquireLease(options internal.AcquireLeaseOptions) error {
+	if _, err := c.inject("AcquireLease", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().AcquireLease(options)
+}
+
+func (c *Chaos) RenewLease(options internal.RenewLeaseOptions) error {
+	if _, err := c.inject("RenewLease", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().RenewLease(options)
+}
+
+func (c *Chaos) ReleaseLease(options internal.ReleaseLeaseOptions) error {
+	if _, err := c.inject("ReleaseLease", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().ReleaseLease(options)
+}
+
+func (c *Chaos) GetFileBlockOffsets(options internal.GetFileBlockOffsetsOptions) (*common.BlockOffsetList, error) {
+	if _, err := c.inject("GetFileBlockOffsets", options.Name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().GetFileBlockOffsets(options)
+}
+
+func (c *Chaos) FileUsed(name string) error {
+	if _, err := c.inject("FileUsed", name); err != nil {
+		return err
+	}
+	return c.NextComponent().FileUsed(name)
+}
+
+func (c *Chaos) StatFs() (*syscall.Statfs_t, bool, error) {
+	if _, err := c.inject("StatFs", ""); err != nil {
+		return nil, false, err
+	}
+	return c.NextComponent().StatFs()
+}
+
+func (c *Chaos) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
+	if _, err := c.inject("GetCommittedBlockList", name); err != nil {
+		return nil, err
+	}
+	return c.NextComponent().GetCommittedBlockList(name)
+}
+
+func (c *Chaos) StageData(options internal.StageDataOptions) error {
+	if _, err := c.inject("StageData", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().StageData(options)
+}
+
+func (c *Chaos) CommitData(options internal.CommitDataOptions) error {
+	if _, err := c.inject("CommitData", options.Name); err != nil {
+		return err
+	}
+	return c.NextComponent().CommitData(options)
+}
+
+// ------------------------- Factory -------------------------------------------
+
+// Pipeline will call this method to create your object, initialize your variables here
+// << DO NOT DELETE ANY AUTO GENERATED CODE HERE >>
+func NewChaosComponent() internal.Component {
+	comp := &Chaos{}
+	comp.SetName(compName)
+	return comp
+}
+
+// On init register this component to pipeline and supply your constructor
+func init() {
+	internal.AddComponent(compName, NewChaosComponent)
+}
@@ -0,0 +1,319 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package chaos
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/block_cache"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type chaosTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	chaos  *Chaos
+	store  internal.Component
+}
+
+func newTestChaos(next internal.Component, configuration string) (*Chaos, error) {
+	_ = config.ReadConfigFromReader(strings.NewReader(configuration))
+	c := NewChaosComponent()
+	c.SetNextComponent(next)
+	err := c.Configure(true)
+	return c.(*Chaos), err
+}
+
+func (suite *chaosTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+	suite.setupTestHelper("")
+}
+
+func (suite *chaosTestSuite) setupTestHelper(configuration string) {
+	suite.assert = assert.New(suite.T())
+
+	_ = config.ReadConfigFromReader(strings.NewReader(configuration))
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+
+	var err error
+	suite.chaos, err = newTestChaos(suite.store, configuration)
+	suite.assert.NoError(err)
+	_ = suite.chaos.Start(context.Background())
+}
+
+func (suite *chaosTestSuite) cleanupTest() {
+	_ = suite.chaos.Stop()
+}
+
+func (suite *chaosTestSuite) TestDefault() {
+	defer suite.cleanupTest()
+	suite.assert.Equal("chaos", suite.chaos.Name())
+	suite.assert.Equal(internal.EComponentPriority.Any(), suite.chaos.Priority())
+	suite.assert.Empty(suite.chaos.rules)
+
+	// Without rules every call goes through as is
+	suite.assert.NoError(suite.chaos.CreateDir(internal.CreateDirOptions{Name: "dir"}))
+	_, err := suite.chaos.GetAttr(internal.GetAttrOptions{Name: "dir"})
+	suite.assert.NoError(err)
+}
+
+func (suite *chaosTestSuite) TestConfig() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("chaos:\n  seed: 5\n  rules:\n    - operations: [readinbuffer, WriteFile]\n      path: dir/*.bin\n      error: eio\n      probability: 10\n      skip: 2\n      count: 3\n      per-path: true\n      latency-ms: 5\n")
+	suite.assert.Len(suite.chaos.rules, 1)
+
+	r := suite.chaos.rules[0]
+	suite.assert.Equal(map[string]bool{"ReadInBuffer": true, "WriteFile": true}, r.ops)
+	suite.assert.Equal("dir/*.bin", r.path)
+	suite.assert.Equal(syscall.EIO, r.err)
+	suite.assert.EqualValues(10, r.probability)
+	suite.assert.EqualValues(2, r.skip)
+	suite.assert.EqualValues(3, r.count)
+	suite.assert.True(r.perPath)
+	suite.assert.Equal(5*time.Millisecond, r.latency)
+
+	invalid := map[string]string{
+		"unknown operation Mount":         "    - operations: [Mount]\n      error: EIO\n",
+		"unknown error EWHAT":             "    - error: EWHAT\n",
+		"probability must be between":     "    - error: EIO\n      probability: 150\n",
+		"invalid path":                    "    - error: EIO\n      path: \"[\"\n",
+		"rule has no error":               "    - path: dir\n",
+		"partial-read needs ReadFile":     "    - operations: [WriteFile]\n      partial-read: true\n",
+		"short-write needs WriteFile":     "    - operations: [ReadInBuffer]\n      short-write: true\n",
+		"config error in chaos [rule 0: ": "    - operations: [Mount]\n",
+	}
+	for msg, rules := range invalid {
+		_, err := newTestChaos(suite.store, "chaos:\n  rules:\n"+rules)
+		suite.assert.ErrorContains(err, msg)
+	}
+}
+
+func (suite *chaosTestSuite) TestPathMatch() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("chaos:\n  rules:\n    - operations: [CreateDir]\n      path: data/*\n      error: EACCES\n    - operations: [GetAttr]\n      path: \"*.bin\"\n      error: ENOENT\n")
+
+	suite.assert.NoError(suite.chaos.CreateDir(internal.CreateDirOptions{Name: "data"}))
+	suite.assert.Equal(syscall.EACCES, suite.chaos.CreateDir(internal.CreateDirOptions{Name: "data/a"}))
+	// The glob also covers everything under a matching directory
+	suite.assert.Equal(syscall.EACCES, suite.chaos.CreateDir(internal.CreateDirOptions{Name: "data/a/b"}))
+	suite.assert.NoError(suite.chaos.CreateDir(internal.CreateDirOptions{Name: "other"}))
+
+	_, err := suite.chaos.GetAttr(internal.GetAttrOptions{Name: "file.bin"})
+	suite.assert.Equal(syscall.ENOENT, err)
+	_, err = suite.chaos.GetAttr(internal.GetAttrOptions{Name: "data"})
+	suite.assert.NoError(err)
+}
+
+func (suite *chaosTestSuite) TestSkipAndCount() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("chaos:\n  rules:\n    - operations: [GetAttr]\n      error: EAGAIN\n      skip: 1\n      count: 2\n")
+	suite.assert.NoError(suite.chaos.CreateDir(internal.CreateDirOptions{Name: "dir"}))
+
+	results := make([]error, 5)
+	for i := range results {
+		_, results[i] = suite.chaos.GetAttr(internal.GetAttrOptions{Name: "dir"})
+	}
+	suite.assert.Equal([]error{nil, syscall.EAGAIN, syscall.EAGAIN, nil, nil}, results)
+}
+
+// A throttled path fails a few times and then recovers, independent of other paths
+func (suite *chaosTestSuite) TestPerPath() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("chaos:\n  rules:\n    - operations: [CreateDir]\n      error: EBUSY\n      count: 2\n      per-path: true\n")
+
+	for _, name := range []string{"a", "b"} {
+		suite.assert.Equal(syscall.EBUSY, suite.chaos.CreateDir(internal.CreateDirOptions{Name: name}))
+		suite.assert.Equal(syscall.EBUSY, suite.chaos.CreateDir(internal.CreateDirOptions{Name: name}))
+		suite.assert.NoError(suite.chaos.CreateDir(internal.CreateDirOptions{Name: name}))
+	}
+}
+
+func (suite *chaosTestSuite) TestProbability() {
+	defer suite.cleanupTest()
+
+	failures := func() []bool {
+		suite.setupTestHelper("chaos:\n  seed: 11\n  rules:\n    - operations: [StatFs]\n      error: EIO\n      probability: 50\n")
+		res := make([]bool, 20)
+		for i := range res {
+			_, _, err := suite.chaos.StatFs()
+			res[i] = err != nil
+		}
+		return res
+	}
+
+	// Same seed fails the same calls
+	first := failures()
+	suite.assert.Equal(first, failures())
+	suite.assert.Contains(first, true)
+	suite.assert.Contains(first, false)
+}
+
+func (suite *chaosTestSuite) TestLatency() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("chaos:\n  rules:\n    - operations: [GetAttr]\n      latency-ms: 20\n")
+
+	start := time.Now()
+	_, err := suite.chaos.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.Equal(syscall.ENOENT, err)
+	suite.assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+}
+
+func (suite *chaosTestSuite) TestPartialReadShortWrite() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("chaos:\n  rules:\n    - operations: [ReadInBuffer, ReadFile, WriteFile]\n      path: short\n      partial-read: true\n      short-write: true\n")
+
+	for _, name := range []string{"short", "full"} {
+		handle, err := suite.chaos.CreateFile(internal.CreateFileOptions{Name: name})
+		suite.assert.NoError(err)
+
+		n, err := suite.chaos.WriteFile(internal.WriteFileOptions{Handle: handle, Data: []byte("0123456789")})
+		suite.assert.NoError(err)
+
+		data := make([]byte, 10)
+		read, err := suite.chaos.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Data: data})
+		suite.assert.NoError(err)
+
+		all, err := suite.chaos.ReadFile(internal.ReadFileOptions{Handle: handle})
+		suite.assert.NoError(err)
+
+		if name == "short" {
+			suite.assert.Equal(5, n)
+			suite.assert.Equal(5, read)
+			suite.assert.Equal([]byte("01"), all)
+		} else {
+			suite.assert.Equal(10, n)
+			suite.assert.Equal(10, read)
+			suite.assert.Equal([]byte("0123456789"), all)
+		}
+	}
+}
+
+func (suite *chaosTestSuite) setupBlockCache(rules string) internal.Component {
+	suite.setupTestHelper("block_cache:\n  block-size-mb: 1\n  mem-size-mb: 20\n  prefetch: 12\n  parallelism: 10\nchaos:\n  rules:\n" + rules)
+
+	bc := block_cache.NewBlockCacheComponent()
+	bc.SetNextComponent(suite.chaos)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.NoError(bc.Start(context.Background()))
+	return bc
+}
+
+func (suite *chaosTestSuite) writeThrough(bc internal.Component, data []byte) (*handlemap.Handle, error) {
+	handle, err := bc.CreateFile(internal.CreateFileOptions{Name: "file", Mode: 0777})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: data})
+	suite.assert.NoError(err)
+	return handle, bc.FlushFile(internal.FlushFileOptions{Handle: handle})
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	return data
+}
+
+// Block cache retries staging of a block, so a storage throttling a few calls does not fail the write
+func (suite *chaosTestSuite) TestBlockCacheTransientStageFailure() {
+	defer suite.cleanupTest()
+	bc := suite.setupBlockCache("    - operations: [StageData]\n      error: EBUSY\n      count: 2\n")
+	defer func() { _ = bc.Stop() }()
+
+	data := randomData(int(3 * common.MbToBytes))
+	handle, err := suite.writeThrough(bc, data)
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: handle}))
+	suite.assert.EqualValues(2, suite.chaos.rules[0].counter.injected)
+
+	stored, err := suite.store.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle("file")})
+	suite.assert.NoError(err)
+	suite.assert.Equal(data, stored)
+}
+
+// A block which can not be staged has to fail the flush and must not leave a partial blob behind
+func (suite *chaosTestSuite) TestBlockCacheStageFailure() {
+	defer suite.cleanupTest()
+	bc := suite.setupBlockCache("    - operations: [StageData]\n      error: EIO\n")
+	defer func() { _ = bc.Stop() }()
+
+	handle, err := suite.writeThrough(bc, randomData(int(3*common.MbToBytes)))
+	suite.assert.Error(err)
+	_ = bc.CloseFile(internal.CloseFileOptions{Handle: handle})
+
+	list, err := suite.store.GetCommittedBlockList("file")
+	suite.assert.NoError(err)
+	suite.assert.Nil(list)
+}
+
+// A failed commit fails the flush, the staged blocks are committed when the flush is retried
+func (suite *chaosTestSuite) TestBlockCacheCommitFailure() {
+	defer suite.cleanupTest()
+	bc := suite.setupBlockCache("    - operations: [CommitData]\n      error: EIO\n      count: 1\n")
+	defer func() { _ = bc.Stop() }()
+
+	data := randomData(int(3 * common.MbToBytes))
+	handle, err := suite.writeThrough(bc, data)
+	suite.assert.Error(err)
+
+	list, err := suite.store.GetCommittedBlockList("file")
+	suite.assert.NoError(err)
+	suite.assert.Nil(list)
+
+	suite.assert.NoError(bc.FlushFile(internal.FlushFileOptions{Handle: handle}))
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	stored, err := suite.store.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle("file")})
+	suite.assert.NoError(err)
+	suite.assert.Equal(data, stored)
+}
+
+func TestChaosTestSuite(t *testing.T) {
+	suite.Run(t, new(chaosTestSuite))
+}
@@ -0,0 +1,243 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package chaos
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RuleOptions : One fault to inject, all conditions of a rule have to match for it to fire
+type RuleOptions struct {
+	Operations  []string `config:"operations" yaml:"operations,omitempty"`
+	Path        string   `config:"path" yaml:"path,omitempty"`
+	Error       string   `config:"error" yaml:"error,omitempty"`
+	Probability *float64 `config:"probability" yaml:"probability,omitempty"`
+	Skip        uint64   `config:"skip" yaml:"skip,omitempty"`
+	Count       uint64   `config:"count" yaml:"count,omitempty"`
+	PerPath     bool     `config:"per-path" yaml:"per-path,omitempty"`
+	LatencyMs   uint64   `config:"latency-ms" yaml:"latency-ms,omitempty"`
+	PartialRead bool     `config:"partial-read" yaml:"partial-read,omitempty"`
+	ShortWrite  bool     `config:"short-write" yaml:"short-write,omitempty"`
+}
+
+// Errors which can be injected, by name
+var errorNames = map[string]syscall.Errno{
+	"EIO":       syscall.EIO,
+	"ENOENT":    syscall.ENOENT,
+	"EEXIST":    syscall.EEXIST,
+	"EACCES":    syscall.EACCES,
+	"EPERM":     syscall.EPERM,
+	"ENOSPC":    syscall.ENOSPC,
+	"EAGAIN":    syscall.EAGAIN,
+	"EBUSY":     syscall.EBUSY,
+	"ESTALE":    syscall.ESTALE,
+	"ETIMEDOUT": syscall.ETIMEDOUT,
+	"ENOTEMPTY": syscall.ENOTEMPTY,
+	"ERANGE":    syscall.ERANGE,
+	"EROFS":     syscall.EROFS,
+	"EINVAL":    syscall.EINVAL,
+}
+
+// Calls of the component interface which can be faulted
+var chaosOps = []string{
+	"CreateDir", "DeleteDir", "IsDirEmpty", "DeleteEmptyDirs", "OpenDir", "ReadDir", "StreamDir", "CloseDir", "RenameDir",
+	"CreateFile", "DeleteFile", "OpenFile", "CloseFile", "RenameFile",
+	"ReadFile", "ReadInBuffer", "WriteFile", "TruncateFile", "CopyToFile", "CopyFromFile",
+	"SyncDir", "SyncFile", "FlushFile", "ReleaseFile", "UnlinkFile",
+	"CreateLink", "ReadLink", "GetAttr", "SetAttr", "Chmod", "Chown",
+	"GetXattr", "SetXattr", "ListXattr", "RemoveXattr",
+	"AcquireLease", "RenewLease", "ReleaseLease",
+	"GetFileBlockOffsets", "FileUsed", "StatFs", "GetCommittedBlockList", "StageData", "CommitData",
+}
+
+// Calls which move data and so can be cut short
+var partialReadOps = map[string]bool{"ReadFile": true, "ReadInBuffer": true}
+var shortWriteOps = map[string]bool{"WriteFile": true}
+
+type ruleCounter struct {
+	calls    uint64
+	injected uint64
+}
+
+type rule struct {
+	ops         map[string]bool
+	path        string
+	err         error
+	probability float64
+	skip        uint64
+	count       uint64
+	perPath     bool
+	latency     time.Duration
+	partialRead bool
+	shortWrite  bool
+
+	sync.Mutex
+	counter  ruleCounter
+	counters map[string]*ruleCounter
+}
+
+// newRule : Validate the options of a rule and convert them to a rule
+func newRule(opt RuleOptions) (*rule, error) {
+	r := &rule{
+		ops:         make(map[string]bool),
+		path:        strings.Trim(opt.Path, "/"),
+		probability: 100,
+		skip:        opt.Skip,
+		count:       opt.Count,
+		perPath:     opt.PerPath,
+		latency:     time.Duration(opt.LatencyMs) * time.Millisecond,
+		partialRead: opt.PartialRead,
+		shortWrite:  opt.ShortWrite,
+		counters:    make(map[string]*ruleCounter),
+	}
+
+	for _, op := range opt.Operations {
+		known := false
+		for _, o := range chaosOps {
+			if strings.EqualFold(o, op) {
+				r.ops[o] = true
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown operation %s", op)
+		}
+	}
+
+	if r.path != "" {
+		if _, err := path.Match(r.path, ""); err != nil {
+			return nil, fmt.Errorf("invalid path %s [%s]", opt.Path, err.Error())
+		}
+	}
+
+	if opt.Error != "" {
+		errno, found := errorNames[strings.ToUpper(opt.Error)]
+		if !found {
+			return nil, fmt.Errorf("unknown error %s", opt.Error)
+		}
+		r.err = errno
+	}
+
+	if opt.Probability != nil {
+		if *opt.Probability < 0 || *opt.Probability > 100 {
+			return nil, fmt.Errorf("probability must be between 0 and 100")
+		}
+		r.probability = *opt.Probability
+	}
+
+	if r.err == nil && r.latency == 0 && !r.partialRead && !r.shortWrite {
+		return nil, fmt.Errorf("rule has no error, latency, partial-read or short-write to inject")
+	}
+
+	if r.partialRead && !r.appliesToAny(partialReadOps) {
+		return nil, fmt.Errorf("partial-read needs ReadFile or ReadInBuffer in operations")
+	}
+
+	if r.shortWrite && !r.appliesToAny(shortWriteOps) {
+		return nil, fmt.Errorf("short-write needs WriteFile in operations")
+	}
+
+	return r, nil
+}
+
+// appliesToAny : Check whether the rule covers at least one of the given operations
+func (r *rule) appliesToAny(ops map[string]bool) bool {
+	if len(r.ops) == 0 {
+		return true
+	}
+	for op := range ops {
+		if r.ops[op] {
+			return true
+		}
+	}
+	return false
+}
+
+// matches : Check the operation and path of a call against the rule.
+// The path glob matches the object itself or any directory above it, so "dir" covers everything under dir.
+func (r *rule) matches(op string, name string) bool {
+	if len(r.ops) > 0 && !r.ops[op] {
+		return false
+	}
+
+	if r.path == "" {
+		return true
+	}
+
+	name = strings.Trim(name, "/")
+	for {
+		if ok, _ := path.Match(r.path, name); ok {
+			return true
+		}
+		idx := strings.LastIndex(name, "/")
+		if idx < 0 {
+			return false
+		}
+		name = name[:idx]
+	}
+}
+
+// fire : Count a matching call and decide whether the fault is injected in to it
+func (r *rule) fire(name string, roll func() float64) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	counter := &r.counter
+	if r.perPath {
+		counter = r.counters[name]
+		if counter == nil {
+			counter = &ruleCounter{}
+			r.counters[name] = counter
+		}
+	}
+
+	counter.calls++
+	if counter.calls <= r.skip {
+		return false
+	}
+	if r.count > 0 && counter.injected >= r.count {
+		return false
+	}
+	if r.probability < 100 && roll()*100 >= r.probability {
+		return false
+	}
+
+	counter.injected++
+	return true
+}
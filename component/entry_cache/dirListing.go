@@ -0,0 +1,125 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package entry_cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+)
+
+// listPage : One page of a directory listing along with the token to list the page after it
+type listPage struct {
+	children  []*internal.ObjAttr
+	nextToken string
+}
+
+// dirListing : Pages of a directory listed so far, keyed by the token each page was listed with.
+// A page is cached only if it follows a page already cached so that the pages served from cache
+// always make one continuous listing of the directory.
+type dirListing struct {
+	sync.Mutex
+	pages map[string]*listPage
+	node  *list.Element // Node of the listing in the LRU, the listing expires as a whole
+
+	fetchLock   sync.Mutex  // Pages are listed from next component one at a time
+	prefetching atomic.Bool // Next page is being listed in background
+	stale       atomic.Bool // Listing is invalidated or expired, pages listed from now on are not cached
+}
+
+func newDirListing() *dirListing {
+	return &dirListing{
+		pages: make(map[string]*listPage),
+	}
+}
+
+// page : Cached page listed with the token
+func (l *dirListing) page(token string) (*listPage, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	page, found := l.pages[token]
+	return page, found
+}
+
+// add : Cache the page listed with the token, if it continues the listing. An empty directory is not cached.
+func (l *dirListing) add(token string, page *listPage) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.stale.Load() {
+		return false
+	}
+
+	if token == "" {
+		if len(page.children) == 0 && page.nextToken == "" {
+			return false
+		}
+	} else if !l.continues(token) {
+		return false
+	}
+
+	l.pages[token] = page
+	return true
+}
+
+// continues : Whether a cached page is followed by the page of the token, caller holds the lock
+func (l *dirListing) continues(token string) bool {
+	for _, page := range l.pages {
+		if page.nextToken == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *dirListing) empty() bool {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.pages) == 0
+}
+
+func (l *dirListing) setNode(node *list.Element) {
+	l.Lock()
+	defer l.Unlock()
+	l.node = node
+}
+
+func (l *dirListing) isNode(node *list.Element) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.node == node
+}
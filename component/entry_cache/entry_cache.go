@@ -37,12 +37,16 @@ import (
 	"container/list"
 	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
+	"github.com/Azure/azure-storage-fuse/v2/internal/metadata_index"
 	"github.com/vibhansa-msft/tlru"
 )
 
@@ -50,14 +54,10 @@ import (
 type EntryCache struct {
 	internal.BaseComponent
 	cacheTimeout uint32
-	pathLocks    *common.LockMap
 	pathLRU      *tlru.TLRU
-	pathMap      sync.Map
-}
-
-type pathCacheItem struct {
-	children  []*internal.ObjAttr
-	nextToken string
+	pathMap      sync.Map       // Directory name to its *dirListing
+	prefetchWg   sync.WaitGroup // Pages being listed in background
+	index        *metadata_index.Index
 }
 
 // By default entry cache is valid for 30 seconds
@@ -97,6 +97,18 @@ func (c *EntryCache) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start LRU for path caching [%s]", err.Error())
 	}
 
+	c.index, err = metadata_index.Acquire(c.Name(), c.NextComponent())
+	if err != nil {
+		log.Err("EntryCache::Start : Failed to open metadata index [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+	}
+
+	err = invalidation.Subscribe(c.Name(), c.onChange)
+	if err != nil {
+		log.Err("EntryCache::Start : Failed to subscribe to change events [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+	}
+
 	return nil
 }
 
@@ -104,11 +116,17 @@ func (c *EntryCache) Start(ctx context.Context) error {
 func (c *EntryCache) Stop() error {
 	log.Trace("EntryCache::Stop : Stopping component %s", c.Name())
 
+	invalidation.Unsubscribe(c.Name())
+	c.prefetchWg.Wait()
+
 	err := c.pathLRU.Stop()
 	if err != nil {
 		log.Err("EntryCache::Stop : fail to stop LRU for path caching [%s]", err.Error())
 	}
 
+	metadata_index.Release(c.Name())
+	c.index = nil
+
 	return nil
 }
 
@@ -118,21 +136,9 @@ func (c *EntryCache) Stop() error {
 func (c *EntryCache) Configure(_ bool) error {
 	log.Trace("EntryCache::Configure : %s", c.Name())
 
-	var readonly bool
-	err := config.UnmarshalKey("read-only", &readonly)
-	if err != nil {
-		log.Err("EntryCache::Configure : config error [unable to obtain read-only]")
-		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
-	}
-
-	if !readonly {
-		log.Err("EntryCache::Configure : EntryCache component should be used only in read-only mode")
-		return fmt.Errorf("EntryCache component should be used in only in read-only mode")
-	}
-
 	// >> If you do not need any config parameters remove below code and return nil
 	conf := EntryCacheOptions{}
-	err = config.UnmarshalKey(c.Name(), &conf)
+	err := config.UnmarshalKey(c.Name(), &conf)
 	if err != nil {
 		log.Err("EntryCache::Configure : config error [invalid config attributes]")
 		return fmt.Errorf("EntryCache: config error [invalid config attributes]")
@@ -149,50 +155,319 @@ func (c *EntryCache) Configure(_ bool) error {
 		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
 	}
 
-	c.pathLocks = common.NewLockMap()
-
 	return nil
 }
 
-// StreamDir : Optionally cache entries of the list
+// StreamDir : Serve pages of the directory listing from cache, listing the page after the one served in background
 func (c *EntryCache) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
-	log.Trace("AttrCache::StreamDir : %s", options.Name)
+	log.Trace("EntryCache::StreamDir : %s, token %s", options.Name, options.Token)
+
+	// Directories present in the metadata index are listed in full from it, in a single page, while the listing is
+	// no older than the ones kept in cache
+	if options.Token == "" {
+		pathList, listedAt, found := c.index.List(options.Name)
+		if found && time.Since(listedAt).Seconds() < float64(c.cacheTimeout) {
+			log.Debug("EntryCache::StreamDir : Serving list from index for path: %s", options.Name)
+			return pathList, "", nil
+		}
+	}
 
-	pathKey := fmt.Sprintf("%s##%s", options.Name, options.Token)
-	flock := c.pathLocks.Get(pathKey)
-	flock.Lock()
-	defer flock.Unlock()
+	name := internal.TruncateDirName(options.Name)
+	listing := c.getListing(name)
 
-	pathEntry, found := c.pathMap.Load(pathKey)
-	if !found {
-		log.Debug("EntryCache::StreamDir : Cache not valid, fetch new list for path: %s, token %s", options.Name, options.Token)
-		pathList, token, err := c.NextComponent().StreamDir(options)
-		if err == nil && len(pathList) > 0 {
-			item := pathCacheItem{
-				children:  pathList,
-				nextToken: token,
-			}
-			c.pathMap.Store(pathKey, item)
-			c.pathLRU.Add(pathKey)
-		}
-		return pathList, token, err
-	} else {
+	page, found := listing.page(options.Token)
+	if found {
 		log.Debug("EntryCache::StreamDir : Serving list from cache for path: %s, token %s", options.Name, options.Token)
-		item := pathEntry.(pathCacheItem)
-		return item.children, item.nextToken, nil
+	} else {
+		var err error
+		page, err = c.fetch(listing, options)
+		if listing.empty() {
+			// Nothing to serve from this listing, do not hold it till it expires
+			c.dropListing(name, listing)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	c.prefetch(listing, options, page)
+	return page.children, page.nextToken, nil
+}
+
+// getListing : Cached listing of the directory, a new one is created if there is none
+func (c *EntryCache) getListing(name string) *dirListing {
+	value, found := c.pathMap.Load(name)
+	if found {
+		return value.(*dirListing)
+	}
+
+	value, found = c.pathMap.LoadOrStore(name, newDirListing())
+	listing := value.(*dirListing)
+	if !found {
+		listing.setNode(c.pathLRU.Add(name))
+	}
+	return listing
+}
+
+// fetch : List the page from next component and cache it if it continues the listing
+func (c *EntryCache) fetch(listing *dirListing, options internal.StreamDirOptions) (*listPage, error) {
+	listing.fetchLock.Lock()
+	defer listing.fetchLock.Unlock()
+
+	// Page may have been listed in background while waiting for the lock
+	page, found := listing.page(options.Token)
+	if found {
+		return page, nil
+	}
+
+	log.Debug("EntryCache::fetch : Cache not valid, fetch new list for path: %s, token %s", options.Name, options.Token)
+	children, token, err := c.NextComponent().StreamDir(options)
+	if err != nil {
+		return nil, err
+	}
+
+	page = &listPage{
+		children:  children,
+		nextToken: token,
+	}
+
+	if !listing.add(options.Token, page) {
+		log.Debug("EntryCache::fetch : Not caching list for path: %s, token %s", options.Name, options.Token)
 	}
+	return page, nil
+}
+
+// prefetch : List the page following the given one in background while the current page is being consumed
+func (c *EntryCache) prefetch(listing *dirListing, options internal.StreamDirOptions, page *listPage) {
+	if page.nextToken == "" || listing.stale.Load() {
+		return
+	}
+
+	if _, found := listing.page(page.nextToken); found {
+		return
+	}
+
+	if !listing.prefetching.CompareAndSwap(false, true) {
+		return
+	}
+
+	options.Token = page.nextToken
+	c.prefetchWg.Add(1)
+	go func() {
+		defer c.prefetchWg.Done()
+		defer listing.prefetching.Store(false)
+
+		_, err := c.fetch(listing, options)
+		if err != nil {
+			log.Warn("EntryCache::prefetch : Failed to list path: %s, token %s [%s]", options.Name, options.Token, err.Error())
+		}
+	}()
+}
+
+// dropListing : Remove the listing from cache, pages being listed for it are not cached any more
+func (c *EntryCache) dropListing(name string, listing *dirListing) {
+	listing.stale.Store(true)
+	c.pathMap.CompareAndDelete(name, listing)
 }
 
 // pathEvict : Callback when a node from cache expires
 func (c *EntryCache) pathEvict(node *list.Element) {
-	pathKey := node.Value.(string)
+	name := node.Value.(string)
 
-	flock := c.pathLocks.Get(pathKey)
-	flock.Lock()
-	defer flock.Unlock()
+	value, found := c.pathMap.Load(name)
+	if found && value.(*dirListing).isNode(node) {
+		log.Debug("EntryCache::pathEvict : Expiry for path %s", name)
+		c.dropListing(name, value.(*dirListing))
+	}
+}
 
-	log.Debug("EntryCache::pathEvict : Expiry for path %s", pathKey)
-	c.pathMap.Delete(pathKey)
+// invalidateDir : Drop the listing of the directory
+func (c *EntryCache) invalidateDir(name string) {
+	name = internal.TruncateDirName(name)
+	value, found := c.pathMap.Load(name)
+	if found {
+		c.dropListing(name, value.(*dirListing))
+	}
+}
+
+// invalidatePath : Drop the listing of the directory holding the path
+func (c *EntryCache) invalidatePath(name string) {
+	c.invalidateDir(parentDir(name))
+	c.index.Forget(name)
+}
+
+// invalidateTree : Drop the listings of the directory holding the path and of everything under the path
+func (c *EntryCache) invalidateTree(name string) {
+	name = internal.TruncateDirName(name)
+	c.invalidateDir(parentDir(name))
+
+	prefix := internal.ExtendDirName(name)
+	c.pathMap.Range(func(key, value any) bool {
+		if name == "" || key.(string) == name || strings.HasPrefix(key.(string), prefix) {
+			c.dropListing(key.(string), value.(*dirListing))
+		}
+		return true
+	})
+	c.index.ForgetDir(name)
+}
+
+// onChange : Drop the listings holding a path changed in the container by someone else
+func (c *EntryCache) onChange(event invalidation.Event) {
+	if event.Dir {
+		c.invalidateTree(event.Path)
+	} else {
+		c.invalidatePath(event.Path)
+	}
+}
+
+// parentDir : Directory holding the path, empty for paths at the root
+func parentDir(name string) string {
+	name = internal.TruncateDirName(name)
+	idx := strings.LastIndex(name, "/")
+	if idx < 0 {
+		return ""
+	}
+	return name[:idx]
+}
+
+// ------------------------- Listing changes -------------------------------------------
+
+// CreateDir : Drop the listing of the parent directory
+func (c *EntryCache) CreateDir(options internal.CreateDirOptions) error {
+	err := c.NextComponent().CreateDir(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// DeleteDir : Drop the listings of the parent directory and of everything under the directory
+func (c *EntryCache) DeleteDir(options internal.DeleteDirOptions) error {
+	err := c.NextComponent().DeleteDir(options)
+	if err == nil {
+		c.invalidateTree(options.Name)
+	}
+	return err
+}
+
+// RenameDir : Drop the listings of both parent directories and of everything under the source and destination
+func (c *EntryCache) RenameDir(options internal.RenameDirOptions) error {
+	err := c.NextComponent().RenameDir(options)
+	if err == nil {
+		c.invalidateTree(options.Src)
+		c.invalidateTree(options.Dst)
+	}
+	return err
+}
+
+// CreateFile : Drop the listing of the parent directory
+func (c *EntryCache) CreateFile(options internal.CreateFileOptions) (*handlemap.Handle, error) {
+	h, err := c.NextComponent().CreateFile(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return h, err
+}
+
+// DeleteFile : Drop the listing of the parent directory
+func (c *EntryCache) DeleteFile(options internal.DeleteFileOptions) error {
+	err := c.NextComponent().DeleteFile(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// RenameFile : Drop the listings of both parent directories
+func (c *EntryCache) RenameFile(options internal.RenameFileOptions) error {
+	err := c.NextComponent().RenameFile(options)
+	if err == nil {
+		c.invalidatePath(options.Src)
+		c.invalidatePath(options.Dst)
+	}
+	return err
+}
+
+// CreateLink : Drop the listing of the parent directory
+func (c *EntryCache) CreateLink(options internal.CreateLinkOptions) error {
+	err := c.NextComponent().CreateLink(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// FlushFile : Drop the listing of the parent directory if the file had data to upload, as its size in the listing has changed
+func (c *EntryCache) FlushFile(options internal.FlushFileOptions) error {
+	dirty := options.Handle.Dirty()
+	err := c.NextComponent().FlushFile(options)
+	if err == nil && dirty {
+		c.invalidatePath(options.Handle.Path)
+	}
+	return err
+}
+
+// TruncateFile : Drop the listing of the parent directory as the size of the file has changed
+func (c *EntryCache) TruncateFile(options internal.TruncateFileOptions) error {
+	err := c.NextComponent().TruncateFile(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// CopyFromFile : Drop the listing of the parent directory as the file is uploaded again
+func (c *EntryCache) CopyFromFile(options internal.CopyFromFileOptions) error {
+	err := c.NextComponent().CopyFromFile(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// Chmod : Drop the listing of the parent directory as the mode of the path has changed
+func (c *EntryCache) Chmod(options internal.ChmodOptions) error {
+	err := c.NextComponent().Chmod(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// SetAttr : Drop the listing of the parent directory as the times of the path have changed
+func (c *EntryCache) SetAttr(options internal.SetAttrOptions) error {
+	err := c.NextComponent().SetAttr(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// Chown : Drop the listing of the parent directory as the owner of the path has changed
+func (c *EntryCache) Chown(options internal.ChownOptions) error {
+	err := c.NextComponent().Chown(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// SetXattr : Drop the listing of the parent directory as the metadata and ETag of the path have changed
+func (c *EntryCache) SetXattr(options internal.SetXattrOptions) error {
+	err := c.NextComponent().SetXattr(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
+}
+
+// RemoveXattr : Drop the listing of the parent directory as the metadata and ETag of the path have changed
+func (c *EntryCache) RemoveXattr(options internal.RemoveXattrOptions) error {
+	err := c.NextComponent().RemoveXattr(options)
+	if err == nil {
+		c.invalidatePath(options.Name)
+	}
+	return err
 }
 
 // ------------------------- Factory -------------------------------------------
@@ -39,7 +39,9 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -48,6 +50,8 @@ import (
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/component/loopback"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
+	"github.com/Azure/azure-storage-fuse/v2/internal/metadata_index"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -136,7 +140,7 @@ func (suite *entryCacheTestSuite) TestEmpty() {
 	suite.assert.NotNil(objs)
 	suite.assert.Equal(token, "")
 
-	_, found := suite.entryCache.pathMap.Load("##")
+	_, found := suite.entryCache.pathMap.Load("")
 	suite.assert.False(found)
 
 	objs, token, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "ABCD", Token: ""})
@@ -160,11 +164,11 @@ func (suite *entryCacheTestSuite) TestWithEntry() {
 	suite.assert.NotNil(objs)
 	suite.assert.Equal(token, "")
 
-	cachedObjs, found := suite.entryCache.pathMap.Load("##")
+	cachedObjs, found := suite.entryCache.pathMap.Load("")
 	suite.assert.True(found)
 	suite.assert.Equal(len(objs), 1)
 
-	suite.assert.Equal(objs, cachedObjs.(pathCacheItem).children)
+	suite.assert.Equal(objs, cachedObjs.(*dirListing).pages[""].children)
 }
 
 func (suite *entryCacheTestSuite) TestCachedEntry() {
@@ -182,11 +186,11 @@ func (suite *entryCacheTestSuite) TestCachedEntry() {
 	suite.assert.NotNil(objs)
 	suite.assert.Equal(token, "")
 
-	cachedObjs, found := suite.entryCache.pathMap.Load("##")
+	cachedObjs, found := suite.entryCache.pathMap.Load("")
 	suite.assert.True(found)
 	suite.assert.Equal(len(objs), 1)
 
-	suite.assert.Equal(objs, cachedObjs.(pathCacheItem).children)
+	suite.assert.Equal(objs, cachedObjs.(*dirListing).pages[""].children)
 
 	filePath = filepath.Join(suite.fake_storage_path, "testfile2")
 	h, err = os.Create(filePath)
@@ -201,7 +205,7 @@ func (suite *entryCacheTestSuite) TestCachedEntry() {
 	suite.assert.Equal(len(objs), 1)
 
 	time.Sleep(40 * time.Second)
-	_, found = suite.entryCache.pathMap.Load("##")
+	_, found = suite.entryCache.pathMap.Load("")
 	suite.assert.False(found)
 
 	objs, token, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "", Token: ""})
@@ -212,6 +216,252 @@ func (suite *entryCacheTestSuite) TestCachedEntry() {
 
 }
 
+func (suite *entryCacheTestSuite) TestListFromIndex() {
+	defer suite.cleanupTest()
+
+	suite.assert.Nil(os.MkdirAll(filepath.Join(suite.fake_storage_path, "dir"), 0777))
+	suite.assert.Nil(os.WriteFile(filepath.Join(suite.fake_storage_path, "dir", "testfile1"), []byte("data"), 0666))
+
+	idx := metadata_index.New("")
+	suite.assert.Nil(idx.Refresh(context.Background(), suite.loopback, "", 1))
+	indexPath := filepath.Join(suite.T().TempDir(), "container.idx")
+	suite.assert.Nil(idx.Save(indexPath))
+
+	suite.cleanupTest()
+	suite.setupTestHelper(fmt.Sprintf("read-only: true\n\nloopbackfs:\n  path: %s\n\nmetadata-index:\n  path: %s\n  refresh-sec: 0\n",
+		suite.fake_storage_path, indexPath))
+
+	// Directories in the index are listed from it and not cached again
+	objs, token, err := suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir", Token: ""})
+	suite.assert.Nil(err)
+	suite.assert.Equal("", token)
+	suite.assert.Equal(1, len(objs))
+	suite.assert.Equal("dir/testfile1", objs[0].Path)
+	suite.assert.EqualValues(4, objs[0].Size)
+
+	_, found := suite.entryCache.pathMap.Load("dir")
+	suite.assert.False(found)
+
+	// Others are listed from storage
+	suite.assert.Nil(os.MkdirAll(filepath.Join(suite.fake_storage_path, "other"), 0777))
+	suite.assert.Nil(os.WriteFile(filepath.Join(suite.fake_storage_path, "other", "testfile2"), nil, 0666))
+	objs, _, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "other", Token: ""})
+	suite.assert.Nil(err)
+	suite.assert.Equal(1, len(objs))
+
+	_, found = suite.entryCache.pathMap.Load("other")
+	suite.assert.True(found)
+
+	// Listings of the index older than the cache timeout are listed from storage again
+	suite.assert.Nil(os.MkdirAll(filepath.Join(suite.fake_storage_path, "dir"), 0777))
+	suite.assert.Nil(os.WriteFile(filepath.Join(suite.fake_storage_path, "dir", "testfile1"), []byte("data"), 0666))
+	suite.assert.Nil(os.WriteFile(filepath.Join(suite.fake_storage_path, "dir", "testfile3"), nil, 0666))
+	suite.entryCache.cacheTimeout = 0
+	objs, _, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir", Token: ""})
+	suite.assert.Nil(err)
+	suite.assert.Equal(2, len(objs))
+}
+
+// pagedStorage : Storage listing every directory in pages of pageSize entries, tokens are the index of the page
+type pagedStorage struct {
+	internal.BaseComponent
+	sync.Mutex
+	entries  int
+	pageSize int
+	calls    []string
+}
+
+func (p *pagedStorage) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
+	p.Lock()
+	p.calls = append(p.calls, options.Token)
+	p.Unlock()
+
+	page := 0
+	if options.Token != "" {
+		page, _ = strconv.Atoi(options.Token)
+	}
+
+	list := make([]*internal.ObjAttr, 0)
+	for i := page * p.pageSize; i < min((page+1)*p.pageSize, p.entries); i++ {
+		name := fmt.Sprintf("file%03d", i)
+		list = append(list, &internal.ObjAttr{Name: name, Path: filepath.Join(options.Name, name)})
+	}
+
+	token := ""
+	if (page+1)*p.pageSize < p.entries {
+		token = strconv.Itoa(page + 1)
+	}
+	return list, token, nil
+}
+
+func (p *pagedStorage) listed() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string{}, p.calls...)
+}
+
+func (suite *entryCacheTestSuite) setupPaged(entries int, pageSize int) *pagedStorage {
+	suite.cleanupTest()
+
+	storage := &pagedStorage{entries: entries, pageSize: pageSize}
+	suite.loopback = storage
+	suite.entryCache = newEntryCache(storage)
+	suite.assert.Nil(suite.entryCache.Start(context.Background()))
+	return storage
+}
+
+func (suite *entryCacheTestSuite) cachedTokens(name string) []string {
+	value, found := suite.entryCache.pathMap.Load(name)
+	if !found {
+		return nil
+	}
+
+	listing := value.(*dirListing)
+	listing.Lock()
+	defer listing.Unlock()
+
+	tokens := make([]string, 0)
+	for token := range listing.pages {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func (suite *entryCacheTestSuite) TestMultiPage() {
+	defer suite.cleanupTest()
+	storage := suite.setupPaged(25, 10)
+
+	// Page after the one served is listed in background
+	objs, token, err := suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir/", Token: ""})
+	suite.assert.Nil(err)
+	suite.assert.Equal(10, len(objs))
+	suite.assert.Equal("1", token)
+	suite.assert.Eventually(func() bool { return len(suite.cachedTokens("dir")) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	objs, token, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir/", Token: "1"})
+	suite.assert.Nil(err)
+	suite.assert.Equal("file010", objs[0].Name)
+	suite.assert.Equal("2", token)
+	suite.assert.Eventually(func() bool { return len(suite.cachedTokens("dir")) == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	objs, token, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir/", Token: "2"})
+	suite.assert.Nil(err)
+	suite.assert.Equal(5, len(objs))
+	suite.assert.Equal("", token)
+
+	// Listing again, from the start or from any page, is served from memory
+	for _, token := range []string{"", "2", "1", ""} {
+		_, _, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir", Token: token})
+		suite.assert.Nil(err)
+	}
+	suite.assert.Equal([]string{"", "1", "2"}, storage.listed())
+}
+
+func (suite *entryCacheTestSuite) TestPageContinuity() {
+	defer suite.cleanupTest()
+	storage := suite.setupPaged(50, 10)
+
+	// Page which does not follow a cached page is listed, but neither cached nor followed by a prefetch
+	objs, token, err := suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir", Token: "3"})
+	suite.assert.Nil(err)
+	suite.assert.Equal("file030", objs[0].Name)
+	suite.assert.Equal("4", token)
+	suite.assert.Empty(suite.cachedTokens("dir"))
+	suite.assert.Equal([]string{"3"}, storage.listed())
+
+	// Empty directories are not cached
+	storage.entries = 0
+	objs, token, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "empty", Token: ""})
+	suite.assert.Nil(err)
+	suite.assert.Empty(objs)
+	suite.assert.Equal("", token)
+	_, found := suite.entryCache.pathMap.Load("empty")
+	suite.assert.False(found)
+}
+
+func (suite *entryCacheTestSuite) TestInvalidate() {
+	defer suite.cleanupTest()
+	suite.setupPaged(5, 10)
+
+	list := func(names ...string) {
+		for _, name := range names {
+			_, _, err := suite.entryCache.StreamDir(internal.StreamDirOptions{Name: name})
+			suite.assert.Nil(err)
+		}
+	}
+	cached := func(name string) bool {
+		_, found := suite.entryCache.pathMap.Load(name)
+		return found
+	}
+
+	list("", "a", "a/b", "a/b/c", "ab", "x")
+
+	suite.entryCache.invalidatePath("a/b/file")
+	suite.assert.False(cached("a/b"))
+	suite.assert.True(cached("a/b/c"))
+	suite.assert.True(cached("a"))
+
+	// Directory changes drop its parent and everything under it
+	list("a/b")
+	suite.entryCache.invalidateTree("a/")
+	suite.assert.False(cached(""))
+	suite.assert.False(cached("a"))
+	suite.assert.False(cached("a/b"))
+	suite.assert.False(cached("a/b/c"))
+	suite.assert.True(cached("ab"))
+	suite.assert.True(cached("x"))
+
+	suite.entryCache.onChange(invalidation.Event{Path: "x/file"})
+	suite.assert.False(cached("x"))
+	suite.entryCache.onChange(invalidation.Event{Path: "", Dir: true})
+	suite.assert.False(cached("ab"))
+
+	// Changes to the owner or metadata of a path drop the listing holding it
+	list("a", "ab", "x")
+	suite.assert.Nil(suite.entryCache.Chown(internal.ChownOptions{Name: "a/file", Owner: 1, Group: 1}))
+	suite.assert.False(cached("a"))
+	suite.assert.Nil(suite.entryCache.SetXattr(internal.SetXattrOptions{Name: "ab/file", Attr: "user.key", Value: []byte("value")}))
+	suite.assert.False(cached("ab"))
+	suite.assert.Nil(suite.entryCache.RemoveXattr(internal.RemoveXattrOptions{Name: "x/file", Attr: "user.key"}))
+	suite.assert.False(cached("x"))
+}
+
+func (suite *entryCacheTestSuite) TestInvalidateOnChange() {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+	suite.setupTestHelper(fmt.Sprintf("loopbackfs:\n  path: %s", suite.fake_storage_path))
+
+	suite.assert.Nil(os.MkdirAll(filepath.Join(suite.fake_storage_path, "dir"), 0777))
+	suite.assert.Nil(os.WriteFile(filepath.Join(suite.fake_storage_path, "dir", "testfile1"), nil, 0666))
+
+	count := func(name string) int {
+		objs, _, err := suite.entryCache.StreamDir(internal.StreamDirOptions{Name: name})
+		suite.assert.Nil(err)
+		return len(objs)
+	}
+
+	// Listings are cached without read-only mode and dropped on changes made through the mount
+	suite.assert.Equal(1, count("dir"))
+	h, err := suite.entryCache.CreateFile(internal.CreateFileOptions{Name: "dir/testfile2", Mode: 0666})
+	suite.assert.Nil(err)
+	suite.assert.Nil(suite.entryCache.CloseFile(internal.CloseFileOptions{Handle: h}))
+	suite.assert.Equal(2, count("dir"))
+
+	suite.assert.Nil(suite.entryCache.RenameFile(internal.RenameFileOptions{Src: "dir/testfile2", Dst: "testfile2"}))
+	suite.assert.Equal(1, count("dir"))
+	suite.assert.Equal(2, count(""))
+
+	suite.assert.Nil(suite.entryCache.DeleteFile(internal.DeleteFileOptions{Name: "testfile2"}))
+	suite.assert.Equal(1, count(""))
+
+	suite.assert.Nil(suite.entryCache.CreateDir(internal.CreateDirOptions{Name: "dir/sub", Mode: 0777}))
+	suite.assert.Equal(2, count("dir"))
+	suite.assert.Nil(suite.entryCache.RenameDir(internal.RenameDirOptions{Src: "dir", Dst: "dir2"}))
+	suite.assert.Equal(2, count("dir2"))
+	_, _, err = suite.entryCache.StreamDir(internal.StreamDirOptions{Name: "dir"})
+	suite.assert.NotNil(err)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestEntryCacheTestSuite(t *testing.T) {
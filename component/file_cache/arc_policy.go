@@ -0,0 +1,164 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"container/list"
+)
+
+// Lists of the adaptive replacement cache, front of each list is the most recently used
+const (
+	arcRecent        = iota // T1 : Files used once since they came in the cache
+	arcFrequent             // T2 : Files used more than once
+	arcRecentGhost          // B1 : Files evicted from T1, only names are kept
+	arcFrequentGhost        // B2 : Files evicted from T2
+)
+
+type arcEntry struct {
+	name string
+	list int
+}
+
+// arcOrder : Adaptive replacement cache. Files used once and files used again are kept in separate lists
+// and the share of each list is adjusted using the files evicted recently, so a scan through many files
+// does not push out files which are used again and again.
+type arcOrder struct {
+	lists   [4]*list.List
+	entries map[string]*list.Element
+
+	// Target number of files in T1, raised when files evicted from T1 are used again and lowered for T2
+	target int
+}
+
+var _ evictionOrder = &arcOrder{}
+
+func newARCOrder() *arcOrder {
+	o := &arcOrder{
+		entries: make(map[string]*list.Element),
+	}
+	for i := range o.lists {
+		o.lists[i] = list.New()
+	}
+	return o
+}
+
+func NewARCPolicy(cfg cachePolicyConfig) cachePolicy {
+	return newOrderedPolicy(cfg, "arc", newARCOrder())
+}
+
+// cached : Number of files in the cache, the ghost lists are kept within this size
+func (o *arcOrder) cached() int {
+	return o.lists[arcRecent].Len() + o.lists[arcFrequent].Len()
+}
+
+func (o *arcOrder) move(elem *list.Element, to int) {
+	e := elem.Value.(*arcEntry)
+	o.lists[e.list].Remove(elem)
+	e.list = to
+	o.entries[e.name] = o.lists[to].PushFront(e)
+}
+
+func (o *arcOrder) access(name string) {
+	elem, found := o.entries[name]
+	if !found {
+		o.entries[name] = o.lists[arcRecent].PushFront(&arcEntry{name: name, list: arcRecent})
+		o.trimGhosts()
+		return
+	}
+
+	recentGhosts, frequentGhosts := o.lists[arcRecentGhost].Len(), o.lists[arcFrequentGhost].Len()
+
+	switch elem.Value.(*arcEntry).list {
+	case arcRecentGhost:
+		// T1 was too small to keep this file till it was used again
+		o.target = min(o.target+max(frequentGhosts/recentGhosts, 1), o.cached()+1)
+	case arcFrequentGhost:
+		o.target = max(o.target-max(recentGhosts/frequentGhosts, 1), 0)
+	}
+
+	o.move(elem, arcFrequent)
+	o.trimGhosts()
+}
+
+func (o *arcOrder) remove(name string, evicted bool) {
+	elem, found := o.entries[name]
+	if !found {
+		return
+	}
+
+	e := elem.Value.(*arcEntry)
+	if evicted && (e.list == arcRecent || e.list == arcFrequent) {
+		o.move(elem, e.list+arcRecentGhost)
+		o.trimGhosts()
+		return
+	}
+
+	o.lists[e.list].Remove(elem)
+	delete(o.entries, name)
+}
+
+// trimGhosts : Drop the oldest ghosts once the ghost lists hold more names than there are files in the cache.
+// Like T1 and T2 together, T1 and B1 together are kept within the number of files in the cache.
+func (o *arcOrder) trimGhosts() {
+	for o.lists[arcRecentGhost].Len()+o.lists[arcFrequentGhost].Len() > max(o.cached(), 1) {
+		l := o.lists[arcFrequentGhost]
+		if o.lists[arcRecentGhost].Len() > o.lists[arcFrequent].Len() || l.Len() == 0 {
+			l = o.lists[arcRecentGhost]
+		}
+
+		elem := l.Back()
+		l.Remove(elem)
+		delete(o.entries, elem.Value.(*arcEntry).name)
+	}
+}
+
+func (o *arcOrder) victims(n int, _ func(string) int64) []string {
+	names := make([]string, 0, min(n, o.cached()))
+
+	// Evict from the back of T1 while it is above its target share and from the back of T2 otherwise
+	recent, frequent := o.lists[arcRecent].Back(), o.lists[arcFrequent].Back()
+	recentLen := o.lists[arcRecent].Len()
+	for len(names) < n && (recent != nil || frequent != nil) {
+		if recent != nil && (recentLen > o.target || frequent == nil) {
+			names = append(names, recent.Value.(*arcEntry).name)
+			recent = recent.Prev()
+			recentLen--
+		} else {
+			names = append(names, frequent.Value.(*arcEntry).name)
+			frequent = frequent.Prev()
+		}
+	}
+
+	return names
+}
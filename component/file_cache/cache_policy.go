@@ -36,9 +36,11 @@ package file_cache
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
 	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 )
 
@@ -56,6 +58,14 @@ type cachePolicyConfig struct {
 	fileLocks *common.LockMap
 
 	policyTrace bool
+
+	// Classes of paths which are pinned, evicted first or not cached
+	classes *cache_class.Classifier
+}
+
+// classOf : Cache class of a file in the local cache
+func (c *cachePolicyConfig) classOf(name string) cache_class.Class {
+	return c.classes.Classify(strings.TrimPrefix(name, c.tmpPath))
 }
 
 type cachePolicy interface {
@@ -73,6 +83,22 @@ type cachePolicy interface {
 	Name() string // The name of the policy
 }
 
+// newCachePolicy : Create the eviction policy of the given name, lru if no name is given
+func newCachePolicy(name string, cfg cachePolicyConfig) (cachePolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "lru":
+		return NewLRUPolicy(cfg), nil
+	case "lfu":
+		return NewLFUPolicy(cfg), nil
+	case "arc":
+		return NewARCPolicy(cfg), nil
+	case "size":
+		return NewSizePolicy(cfg), nil
+	default:
+		return nil, fmt.Errorf("invalid cache policy %s, supported policies are lru, lfu, arc and size", name)
+	}
+}
+
 // getUsagePercentage:  The current cache usage as a percentage of the maxSize
 func getUsagePercentage(path string, maxSize float64) float64 {
 	var currSize float64
@@ -130,3 +156,43 @@ func deleteFile(name string) error {
 
 	return nil
 }
+
+// deleteCachedFile : Delete a file evicted from the cache unless it is open or being downloaded.
+// Returns false if the file was in use and has to stay in the cache.
+func deleteCachedFile(tmpPath string, fileLocks *common.LockMap, name string) bool {
+	azPath := strings.TrimPrefix(name, tmpPath)
+	if azPath == "" {
+		log.Err("cachePolicy::deleteCachedFile : Empty file name formed name : %s, tmpPath : %s", name, tmpPath)
+		return true
+	}
+
+	if azPath[0] == '/' {
+		azPath = azPath[1:]
+	}
+
+	flock := fileLocks.Get(azPath)
+	if fileLocks.Locked(azPath) {
+		log.Warn("cachePolicy::deleteCachedFile : File in under download %s", azPath)
+		return false
+	}
+
+	flock.Lock()
+	defer flock.Unlock()
+
+	// Check if there are any open handles to this file or not
+	if flock.Count() > 0 {
+		log.Warn("cachePolicy::deleteCachedFile : File in use %s", name)
+		return false
+	}
+
+	// There are no open handles for this file so its safe to remove this
+	err := deleteFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		log.Err("cachePolicy::deleteCachedFile : failed to delete local file %s [%s]", name, err.Error())
+	}
+
+	// File was deleted so try clearing its parent directory
+	// TODO: Delete directories up the path recursively that are "safe to delete". Ensure there is no race between this code and code that creates directories (like OpenFile)
+	// This might require something like hierarchical locking.
+	return true
+}
@@ -50,7 +50,9 @@ import (
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
 	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 
 	"github.com/spf13/cobra"
@@ -70,6 +72,8 @@ type FileCache struct {
 	cleanupOnStart  bool
 	policyTrace     bool
 	missedChmodList sync.Map
+	missedTimesList sync.Map
+	missedChownList sync.Map
 	mountPath       string
 	allowOther      bool
 	offloadIO       bool
@@ -85,6 +89,18 @@ type FileCache struct {
 
 	lazyWrite    bool
 	fileCloseOpt sync.WaitGroup
+
+	leaseLock bool
+	leases    *internal.LeaseKeeper
+
+	classes *cache_class.Classifier // Classes of paths which are pinned, evicted first or not cached
+
+	sparseDownload  bool
+	sparseBlockSize int64
+	sparseFill      bool
+	sparseFiles     sync.Map // Files downloaded block by block as they are read, keyed by path of the blob
+
+	staleFiles sync.Map // Files changed in the container while open, downloaded again on next open
 }
 
 // Structure defining your config parameters
@@ -104,8 +120,9 @@ type FileCacheOptions struct {
 	AllowNonEmpty   bool `config:"allow-non-empty-temp" yaml:"allow-non-empty-temp,omitempty"`
 	CleanupOnStart  bool `config:"cleanup-on-start" yaml:"cleanup-on-start,omitempty"`
 
-	EnablePolicyTrace bool `config:"policy-trace" yaml:"policy-trace,omitempty"`
-	OffloadIO         bool `config:"offload-io" yaml:"offload-io,omitempty"`
+	EnablePolicyTrace bool   `config:"policy-trace" yaml:"policy-trace,omitempty"`
+	PolicyTraceFile   string `config:"policy-trace-file" yaml:"policy-trace-file,omitempty"`
+	OffloadIO         bool   `config:"offload-io" yaml:"offload-io,omitempty"`
 
 	// v1 support
 	V1Timeout     uint32 `config:"file-cache-timeout-in-seconds" yaml:"-"`
@@ -115,6 +132,12 @@ type FileCacheOptions struct {
 
 	RefreshSec uint32 `config:"refresh-sec" yaml:"refresh-sec,omitempty"`
 	HardLimit  bool   `config:"hard-limit" yaml:"hard-limit,omitempty"`
+
+	LeaseLock bool `config:"lease-lock" yaml:"lease-lock,omitempty"`
+
+	SparseDownload    bool    `config:"sparse-download" yaml:"sparse-download,omitempty"`
+	SparseBlockSizeMB float64 `config:"sparse-block-size-mb" yaml:"sparse-block-size-mb,omitempty"`
+	SparseFill        bool    `config:"sparse-background-fill" yaml:"sparse-background-fill,omitempty"`
 }
 
 const (
@@ -124,6 +147,8 @@ const (
 	defaultMinThreshold     = 60
 	defaultFileCacheTimeout = 120
 	defaultCacheUpdateCount = 100
+	defaultSparseBlockSize  = 4
+	maxStaleDownloadRetries = 3
 	MB                      = 1024 * 1024
 )
 
@@ -170,9 +195,20 @@ func (c *FileCache) Start(ctx context.Context) error {
 		return fmt.Errorf("config error in %s error [fail to start policy]", c.Name())
 	}
 
+	if c.leaseLock {
+		c.leases = internal.NewLeaseKeeper(c.NextComponent())
+		c.leases.Start()
+	}
+
 	// create stats collector for file cache
 	fileCacheStatsCollector = stats_manager.NewStatsCollector(c.Name())
 
+	err = invalidation.Subscribe(c.Name(), c.onChange)
+	if err != nil {
+		log.Err("FileCache::Start : Failed to subscribe to change events [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+	}
+
 	return nil
 }
 
@@ -180,12 +216,18 @@ func (c *FileCache) Start(ctx context.Context) error {
 func (c *FileCache) Stop() error {
 	log.Trace("Stopping component : %s", c.Name())
 
+	invalidation.Unsubscribe(c.Name())
+
 	// Wait for all async upload to complete if any
 	if c.lazyWrite {
 		log.Info("FileCache::Stop : Waiting for async close to complete")
 		c.fileCloseOpt.Wait()
 	}
 
+	if c.leases != nil {
+		c.leases.Stop()
+	}
+
 	_ = c.policy.ShutdownPolicy()
 	_ = common.TempCacheCleanup(c.tmpPath)
 
@@ -260,6 +302,18 @@ func (c *FileCache) Configure(_ bool) error {
 	c.syncToDelete = !conf.SyncNoOp
 	c.refreshSec = conf.RefreshSec
 	c.hardLimit = conf.HardLimit
+	c.leaseLock = conf.LeaseLock
+
+	c.sparseDownload = conf.SparseDownload
+	c.sparseFill = conf.SparseFill
+	c.sparseBlockSize = defaultSparseBlockSize * MB
+	if config.IsSet(compName + ".sparse-block-size-mb") {
+		if conf.SparseBlockSizeMB <= 0 {
+			log.Err("FileCache: config error [invalid sparse-block-size-mb]")
+			return fmt.Errorf("config error in %s [invalid sparse-block-size-mb %v]", c.Name(), conf.SparseBlockSizeMB)
+		}
+		c.sparseBlockSize = int64(conf.SparseBlockSizeMB * MB)
+	}
 
 	err = config.UnmarshalKey("lazy-write", &c.lazyWrite)
 	if err != nil {
@@ -267,6 +321,12 @@ func (c *FileCache) Configure(_ bool) error {
 		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
 	}
 
+	c.classes, err = cache_class.NewFromConfig()
+	if err != nil {
+		log.Err("FileCache: config error [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+	}
+
 	c.tmpPath = common.ExpandPath(conf.TmpPath)
 	if c.tmpPath == "" {
 		log.Err("FileCache: config error [tmp-path not set]")
@@ -325,11 +385,18 @@ func (c *FileCache) Configure(_ bool) error {
 	}
 
 	cacheConfig := c.GetPolicyConfig(conf)
-	c.policy = NewLRUPolicy(cacheConfig)
+	c.policy, err = newCachePolicy(conf.Policy, cacheConfig)
+	if err != nil {
+		log.Err("FileCache::Configure : failed to create cache eviction policy [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+	}
 
-	if c.policy == nil {
-		log.Err("FileCache::Configure : failed to create cache eviction policy")
-		return fmt.Errorf("config error in %s [%s]", c.Name(), "failed to create cache policy")
+	if conf.PolicyTraceFile != "" {
+		c.policy, err = newTracePolicy(c.policy, c.tmpPath, common.ExpandPath(conf.PolicyTraceFile))
+		if err != nil {
+			log.Err("FileCache::Configure : failed to open policy trace file [%s]", err.Error())
+			return fmt.Errorf("config error in %s [%s]", c.Name(), err.Error())
+		}
 	}
 
 	if config.IsSet(compName + ".background-download") {
@@ -350,6 +417,14 @@ func (c *FileCache) Configure(_ bool) error {
 		c.diskHighWaterMark = (((conf.MaxSizeMB * MB) * float64(cacheConfig.highThreshold)) / 100)
 	}
 
+	if c.leaseLock {
+		log.Info("FileCache::Configure : Files opened for write will be leased in storage to lock them across mounts")
+	}
+
+	if c.sparseDownload {
+		log.Info("FileCache::Configure : Files will be downloaded in blocks of %v bytes as they are read, background-fill %t", c.sparseBlockSize, c.sparseFill)
+	}
+
 	log.Crit("FileCache::Configure : create-empty %t, cache-timeout %d, tmp-path %s, max-size-mb %d, high-mark %d, low-mark %d, refresh-sec %v, max-eviction %v, hard-limit %v, policy %s, allow-non-empty-temp %t, cleanup-on-start %t, policy-trace %t, offload-io %t, sync-to-flush %t, ignore-sync %t, defaultPermission %v, diskHighWaterMark %v, maxCacheSize %v, mountPath %v",
 		c.createEmptyFile, int(c.cacheTimeout), c.tmpPath, int(cacheConfig.maxSizeMB), int(cacheConfig.highThreshold), int(cacheConfig.lowThreshold), c.refreshSec, cacheConfig.maxEviction, c.hardLimit, conf.Policy, c.allowNonEmpty, c.cleanupOnStart, c.policyTrace, c.offloadIO, c.syncToFlush, c.syncToDelete, c.defaultPermission, c.diskHighWaterMark, c.maxCacheSize, c.mountPath)
 
@@ -424,6 +499,7 @@ func (c *FileCache) GetPolicyConfig(conf FileCacheOptions) cachePolicyConfig {
 		maxSizeMB:     conf.MaxSizeMB,
 		fileLocks:     c.fileLocks,
 		policyTrace:   conf.EnablePolicyTrace,
+		classes:       c.classes,
 	}
 
 	return cacheConfig
@@ -457,6 +533,9 @@ func (fc *FileCache) invalidateDirectory(name string) {
 			log.Debug("FileCache::invalidateDirectory : %s (%d) getting removed from cache", path, d.IsDir())
 			if !d.IsDir() {
 				fc.policy.CachePurge(path)
+				if name, err := filepath.Rel(fc.tmpPath, path); err == nil {
+					fc.dropSparse(name)
+				}
 			} else {
 				_ = deleteFile(path)
 			}
@@ -472,6 +551,21 @@ func (fc *FileCache) invalidateDirectory(name string) {
 	_ = deleteFile(localPath)
 }
 
+// onChange: Drops the local copy of a path changed in the container by someone else
+func (fc *FileCache) onChange(event invalidation.Event) {
+	log.Debug("FileCache::onChange : %s changed in container", event.Path)
+
+	if event.Dir {
+		fc.invalidateDirectory(event.Path)
+		return
+	}
+
+	// A file which is open can not be removed, so it is marked to be downloaded again once it is closed
+	fc.staleFiles.Store(event.Path, true)
+	fc.policy.CachePurge(filepath.Join(fc.tmpPath, event.Path))
+	fc.dropSparse(event.Path)
+}
+
 // Note: The primary purpose of the file cache is to keep track of files that are opened by the user.
 // So we do not need to support some APIs like Create Directory since the file cache will manage
 // creating local directories as needed.
@@ -731,6 +825,7 @@ func (fc *FileCache) CreateFile(options internal.CreateFileOptions) (*handlemap.
 	// Create the file in local cache
 	localPath := filepath.Join(fc.tmpPath, options.Name)
 	fc.policy.CacheValid(localPath)
+	fc.dropSparse(options.Name)
 
 	err := os.MkdirAll(filepath.Dir(localPath), fc.defaultPermission)
 	if err != nil {
@@ -748,9 +843,16 @@ func (fc *FileCache) CreateFile(options internal.CreateFileOptions) (*handlemap.
 	if options.Mode != common.DefaultFilePermissionBits {
 		fc.missedChmodList.LoadOrStore(options.Name, true)
 	}
+	// Owner of the file can be set in storage only once it is uploaded
+	if !fc.createEmptyFile && options.OwnerSet {
+		fc.missedChownList.Store(options.Name, internal.ChownOptions{Name: options.Name, Owner: int(options.Uid), Group: int(options.Gid)})
+	} else {
+		fc.missedChownList.Delete(options.Name)
+	}
 
 	// Increment the handle count in this lock item as there is one handle open for this now
 	flock.Inc()
+	flock.SetETag("")
 
 	handle := handlemap.NewHandle(options.Name)
 	handle.UnixFD = uint64(f.Fd())
@@ -765,6 +867,9 @@ func (fc *FileCache) CreateFile(options internal.CreateFileOptions) (*handlemap.
 	// If an empty file is created in storage then there is no need to upload if FlushFile is called immediately after CreateFile.
 	if !fc.createEmptyFile {
 		handle.Flags.Set(handlemap.HandleFlagDirty)
+	} else if fc.leaseLock {
+		// Blob exists in storage so lock it right away, otherwise it will be locked after the first upload
+		fc.acquireLease(handle)
 	}
 
 	return handle, nil
@@ -814,12 +919,19 @@ func (fc *FileCache) DeleteFile(options internal.DeleteFileOptions) error {
 	flock.Lock()
 	defer flock.Unlock()
 
+	// Delete only the version of the file this cache has seen
+	options.ETag = flock.ETag()
 	err := fc.NextComponent().DeleteFile(options)
 	err = fc.validateStorageError(options.Name, err, "DeleteFile", false)
 	if err != nil {
 		log.Err("FileCache::DeleteFile : error  %s [%s]", options.Name, err.Error())
 		return err
 	}
+	flock.SetETag("")
+	if fc.leaseLock {
+		// Lease goes away along with the blob
+		fc.leases.Forget(options.Name)
+	}
 
 	localPath := filepath.Join(fc.tmpPath, options.Name)
 	err = deleteFile(localPath)
@@ -828,10 +940,40 @@ func (fc *FileCache) DeleteFile(options internal.DeleteFileOptions) error {
 	}
 
 	fc.policy.CachePurge(localPath)
+	fc.dropSparse(options.Name)
 
 	return nil
 }
 
+// downloadFile : Download the blob pinned to the ETag it was seen with, so the ETag kept for the local copy is the
+// one of the data downloaded. If the blob changed since, it is stat'ed again and the newer version is downloaded.
+func (fc *FileCache) downloadFile(name string, f *os.File, attr *internal.ObjAttr) (*internal.ObjAttr, error) {
+	for retry := 0; ; retry++ {
+		err := fc.NextComponent().CopyToFile(
+			internal.CopyToFileOptions{
+				Name:   name,
+				Offset: 0,
+				Count:  attr.Size,
+				File:   f,
+				ETag:   attr.ETag,
+			})
+		if err != syscall.ESTALE || retry == maxStaleDownloadRetries {
+			return attr, err
+		}
+
+		log.Info("FileCache::downloadFile : %s changed since it was stat'ed, downloading it again", name)
+		attr, err = fc.NextComponent().GetAttr(internal.GetAttrOptions{Name: name, RetrieveMetadata: true})
+		if err != nil {
+			return nil, err
+		}
+
+		err = f.Truncate(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 // isDownloadRequired: Whether or not the file needs to be downloaded to local cache.
 func (fc *FileCache) isDownloadRequired(localPath string, blobPath string, flock *common.LockMapItem) (bool, bool, *internal.ObjAttr, error) {
 	fileExists := false
@@ -875,6 +1017,11 @@ func (fc *FileCache) isDownloadRequired(localPath string, blobPath string, flock
 		downloadRequired = true
 	}
 
+	if _, stale := fc.staleFiles.Load(blobPath); stale {
+		log.Debug("FileCache::isDownloadRequired : %s changed in container", blobPath)
+		downloadRequired = true
+	}
+
 	if fileExists && flock.Count() > 0 {
 		// file exists in local cache and there is already an handle open for it
 		// In this case we can not redownload the file from container
@@ -903,6 +1050,9 @@ func (fc *FileCache) isDownloadRequired(localPath string, blobPath string, flock
 				blobPath, attr.Mtime, lmt, attr.Size, stat.Size)
 			downloadRequired = true
 
+			// Kernel may hold the old data and attributes of the file as well
+			invalidation.Notify(invalidation.Event{Path: blobPath})
+
 			// As we have decided to continue using old file, we reset the timer to check again after refresh time interval
 			flock.SetDownloadTime()
 		} else {
@@ -925,6 +1075,24 @@ func (fc *FileCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 	flock.Lock()
 	defer flock.Unlock()
 
+	// Lock the file across mounts before it is downloaded so that no one else modifies it while it is open for write.
+	// If the blob does not exist yet the lease is taken once the file is uploaded.
+	leased, opened := false, false
+	if fc.leaseLock && options.Flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		err = fc.leases.Acquire(options.Name)
+		if err == nil {
+			leased = true
+			defer func() {
+				if !opened {
+					_ = fc.leases.Release(options.Name)
+				}
+			}()
+		} else if err != syscall.ENOENT {
+			log.Err("FileCache::OpenFile : Failed to lease %s [%s]", options.Name, err.Error())
+			return nil, err
+		}
+	}
+
 	fc.policy.CacheValid(localPath)
 	downloadRequired, fileExists, attr, err := fc.isDownloadRequired(localPath, options.Name, flock)
 
@@ -935,6 +1103,8 @@ func (fc *FileCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 
 	if downloadRequired {
 		log.Debug("FileCache::OpenFile : Need to re-download %s", options.Name)
+		// Changes made in the container from here on need another download
+		fc.staleFiles.Delete(options.Name)
 
 		fileSize := int64(0)
 		if attr != nil {
@@ -968,7 +1138,24 @@ func (fc *FileCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 			fileSize = 0
 		}
 
-		if fileSize > 0 {
+		// Local copy is being replaced so whatever was downloaded of the older one is of no use
+		fc.dropSparse(options.Name)
+
+		if fileSize > 0 && fc.sparseDownload && fileSize > fc.sparseBlockSize {
+			// Blocks are downloaded as they are read, till then the local file has holes in their place
+			err = f.Truncate(fileSize)
+			if err != nil {
+				log.Err("FileCache::OpenFile : error resizing file %s [%s]", options.Name, err.Error())
+				_ = f.Close()
+				_ = os.Remove(localPath)
+				return nil, err
+			}
+			etag := ""
+			if attr != nil {
+				etag = attr.ETag
+			}
+			fc.sparseFiles.Store(options.Name, newSparseFile(options.Name, localPath, etag, fileSize, fc.sparseBlockSize))
+		} else if fileSize > 0 {
 			if fc.diskHighWaterMark != 0 {
 				currSize, err := common.GetUsage(fc.tmpPath)
 				if err != nil {
@@ -982,13 +1169,7 @@ func (fc *FileCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 
 			}
 			// Download/Copy the file from storage to the local file.
-			err = fc.NextComponent().CopyToFile(
-				internal.CopyToFileOptions{
-					Name:   options.Name,
-					Offset: 0,
-					Count:  fileSize,
-					File:   f,
-				})
+			attr, err = fc.downloadFile(options.Name, f, attr)
 			if err != nil {
 				// File was created locally and now download has failed so we need to delete it back from local cache
 				log.Err("FileCache::OpenFile : error downloading file from storage %s [%s]", options.Name, err.Error())
@@ -998,8 +1179,13 @@ func (fc *FileCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 			}
 		}
 
-		// Update the last download time of this file
+		// Update the last download time of this file and remember which version of the blob was downloaded
 		flock.SetDownloadTime()
+		if attr != nil {
+			flock.SetETag(attr.ETag)
+		} else {
+			flock.SetETag("")
+		}
 
 		log.Debug("FileCache::OpenFile : Download of %s is complete", options.Name)
 		f.Close()
@@ -1048,13 +1234,29 @@ func (fc *FileCache) OpenFile(options internal.OpenFileOptions) (*handlemap.Hand
 	}
 
 	handle.UnixFD = uint64(f.Fd())
-	if !fc.offloadIO {
+
+	sparse := fc.sparseFileOf(options.Name)
+	if sparse != nil && options.Flags&os.O_TRUNC != 0 {
+		sparse.truncate(0)
+	}
+
+	if sparse != nil && !sparse.complete() {
+		// Reads have to come here to download the blocks they need
+		handle.SetValue(sparseFileKey, sparse)
+		if fc.sparseFill && sparse.startFill() {
+			go fc.fillSparse(sparse)
+		}
+	} else if !fc.offloadIO {
 		handle.Flags.Set(handlemap.HandleFlagCached)
 	}
+	if leased {
+		handle.Flags.Set(handlemap.HandleFlagLeased)
+	}
 
 	log.Info("FileCache::OpenFile : file=%s, fd=%d", options.Name, f.Fd())
 	handle.SetFileObject(f)
 
+	opened = true
 	return handle, nil
 }
 
@@ -1085,6 +1287,16 @@ func (fc *FileCache) closeFileInternal(options internal.CloseFileOptions, flock
 	defer flock.Unlock()
 	defer fc.fileCloseOpt.Done()
 
+	// Lease is released once the last upload is done, even if it fails, so that the file is not locked forever
+	if options.Handle.Leased() {
+		defer func() {
+			err := fc.leases.Release(options.Handle.Path)
+			if err != nil {
+				log.Err("FileCache::closeFileInternal : failed to release lease on %s [%s]", options.Handle.Path, err.Error())
+			}
+		}()
+	}
+
 	localPath := filepath.Join(fc.tmpPath, options.Handle.Path)
 
 	err := fc.FlushFile(internal.FlushFileOptions{Handle: options.Handle, CloseInProgress: true}) //nolint
@@ -1093,6 +1305,11 @@ func (fc *FileCache) closeFileInternal(options internal.CloseFileOptions, flock
 		return err
 	}
 
+	// Times set while the file was open have been applied by the flush above, if there was anything to upload
+	if flock.Count() <= 1 {
+		fc.missedTimesList.Delete(options.Handle.Path)
+	}
+
 	f := options.Handle.GetFileObject()
 	if f == nil {
 		log.Err("FileCache::closeFileInternal : error [missing fd in handle object] %s", options.Handle.Path)
@@ -1117,6 +1334,7 @@ func (fc *FileCache) closeFileInternal(options internal.CloseFileOptions, flock
 		}
 
 		fc.policy.CachePurge(localPath)
+		fc.dropSparse(options.Handle.Path)
 		return nil
 	}
 
@@ -1142,6 +1360,11 @@ func (fc *FileCache) ReadFile(options internal.ReadFileOptions) ([]byte, error)
 		log.Err("FileCache::ReadFile : error stat %s [%s] ", options.Handle.Path, err.Error())
 		return nil, err
 	}
+
+	err = fc.fetchSparse(options.Handle, 0, info.Size())
+	if err != nil {
+		return nil, err
+	}
 	data := make([]byte, info.Size())
 	bytesRead, err := f.Read(data)
 
@@ -1173,6 +1396,11 @@ func (fc *FileCache) ReadInBuffer(options internal.ReadInBufferOptions) (int, er
 		fc.policy.CacheValid(localPath)
 	}
 
+	err := fc.fetchSparse(options.Handle, options.Offset, int64(len(options.Data)))
+	if err != nil {
+		return 0, err
+	}
+
 	// Removing f.ReadAt as it involves lot of house keeping and then calls syscall.Pread
 	// Instead we will call syscall directly for better perf
 	return syscall.Pread(options.Handle.FD(), options.Data, options.Offset)
@@ -1210,6 +1438,12 @@ func (fc *FileCache) WriteFile(options internal.WriteFileOptions) (int, error) {
 		fc.policy.CacheValid(localPath)
 	}
 
+	// Blocks being written are downloaded first so that the rest of their data is in place for the upload
+	err := fc.fetchSparse(options.Handle, options.Offset, int64(len(options.Data)))
+	if err != nil {
+		return 0, err
+	}
+
 	// Removing f.WriteAt as it involves lot of house keeping and then calls syscall.Pwrite
 	// Instead we will call syscall directly for better perf
 	bytesWritten, err := syscall.Pwrite(options.Handle.FD(), options.Data, options.Offset)
@@ -1284,6 +1518,13 @@ func (fc *FileCache) FlushFile(options internal.FlushFileOptions) error {
 			return syscall.EBADF
 		}
 
+		// Whole file is uploaded so the blocks not read yet have to be downloaded first
+		err := fc.fetchSparse(options.Handle, 0, math.MaxInt64)
+		if err != nil {
+			log.Err("FileCache::FlushFile : error [failed to download rest of the file] %s [%s]", options.Handle.Path, err.Error())
+			return err
+		}
+
 		// Flush all data to disk that has been buffered by the kernel.
 		// We cannot close the incoming handle since the user called flush, note close and flush can be called on the same handle multiple times.
 		// To ensure the data is flushed to disk before writing to storage, we duplicate the handle and close that handle.
@@ -1325,10 +1566,14 @@ func (fc *FileCache) FlushFile(options internal.FlushFileOptions) error {
 				return err
 			}
 		}
+		// Upload only if the blob is still the one that was downloaded, storage returns the new ETag on success
+		flock := fc.fileLocks.Get(options.Handle.Path)
+		etag := flock.ETag()
 		err = fc.NextComponent().CopyFromFile(
 			internal.CopyFromFileOptions{
 				Name: options.Handle.Path,
 				File: uploadHandle,
+				ETag: &etag,
 			})
 
 		uploadHandle.Close()
@@ -1347,6 +1592,11 @@ func (fc *FileCache) FlushFile(options internal.FlushFileOptions) error {
 
 		options.Handle.Flags.Clear(handlemap.HandleFlagDirty)
 
+		// File did not exist in storage when it was opened, lock it now that it is uploaded
+		if fc.leaseLock && !options.Handle.Leased() && !options.CloseInProgress {
+			fc.acquireLease(options.Handle)
+		}
+
 		// If chmod was done on the file before it was uploaded to container then setting up mode would have been missed
 		// Such file names are added to this map and here post upload we try to set the mode correctly
 		_, found := fc.missedChmodList.Load(options.Handle.Path)
@@ -1360,7 +1610,7 @@ func (fc *FileCache) FlushFile(options internal.FlushFileOptions) error {
 			localPath := filepath.Join(fc.tmpPath, options.Handle.Path)
 			info, err := os.Lstat(localPath)
 			if err == nil {
-				err = fc.Chmod(internal.ChmodOptions{Name: options.Handle.Path, Mode: info.Mode()})
+				err = fc.NextComponent().Chmod(internal.ChmodOptions{Name: options.Handle.Path, Mode: info.Mode(), ETag: &etag})
 				if err != nil {
 					// chmod was missed earlier for this file and doing it now also
 					// resulted in error so ignore this one and proceed for flush handling
@@ -1368,6 +1618,32 @@ func (fc *FileCache) FlushFile(options internal.FlushFileOptions) error {
 				}
 			}
 		}
+
+		// Owner of a file created locally is set once the file is uploaded for the first time
+		item, found := fc.missedChownList.LoadAndDelete(options.Handle.Path)
+		if found {
+			chown := item.(internal.ChownOptions)
+			chown.ETag = &etag
+			err = fc.NextComponent().Chown(chown)
+			if err != nil && err != syscall.ENOTSUP {
+				log.Err("FileCache::FlushFile : %s chown failed [%s]", options.Handle.Path, err.Error())
+			}
+		}
+
+		// Uploading the file resets its times in storage, so apply the ones set by the user while the file was open
+		item, found = fc.missedTimesList.Load(options.Handle.Path)
+		// The entry is kept till the last handle is closed as the file may be uploaded again
+		if found {
+			times := item.(internal.SetAttrOptions)
+			times.ETag = &etag
+			err = fc.NextComponent().SetAttr(times)
+			if err != nil {
+				log.Err("FileCache::FlushFile : %s failed to set times [%s]", options.Handle.Path, err.Error())
+			}
+		}
+
+		// Metadata changes above have moved the ETag of the blob along
+		flock.SetETag(etag)
 	}
 
 	return nil
@@ -1450,6 +1726,13 @@ func (fc *FileCache) RenameFile(options internal.RenameFileOptions) error {
 		return err
 	}
 
+	// Destination is a new copy of the blob in storage, so its ETag is not known any more
+	sflock.SetETag("")
+	dflock.SetETag("")
+	if fc.leaseLock {
+		fc.leases.Forget(options.Src)
+	}
+
 	localSrcPath := filepath.Join(fc.tmpPath, options.Src)
 	localDstPath := filepath.Join(fc.tmpPath, options.Dst)
 
@@ -1462,6 +1745,19 @@ func (fc *FileCache) RenameFile(options internal.RenameFileOptions) error {
 		log.Err("FileCache::RenameFile : %s failed to rename local file %s [%s]", localSrcPath, err.Error())
 	}
 
+	// Blocks of the source not downloaded yet now come from the destination blob
+	fc.dropSparse(options.Dst)
+	if v, found := fc.sparseFiles.LoadAndDelete(options.Src); found {
+		sparse := v.(*sparseFile)
+		if err == nil {
+			sparse.rename(options.Dst, localDstPath)
+			fc.sparseFiles.Store(options.Dst, sparse)
+			fc.refreshSparse(options.Dst)
+		} else {
+			sparse.drop()
+		}
+	}
+
 	if err != nil {
 		// If there was a problem in local rename then delete the destination file
 		// it might happen that dest file was already there and local rename failed
@@ -1525,6 +1821,11 @@ func (fc *FileCache) TruncateFile(options internal.TruncateFileOptions) error {
 	if err == nil || os.IsExist(err) {
 		fc.policy.CacheValid(localPath)
 
+		if sparse := fc.sparseFileOf(options.Name); sparse != nil {
+			sparse.truncate(options.Size)
+			fc.refreshSparse(options.Name)
+		}
+
 		if info.Size() != options.Size {
 			err = os.Truncate(localPath, options.Size)
 			if err != nil {
@@ -1542,7 +1843,10 @@ func (fc *FileCache) Chmod(options internal.ChmodOptions) error {
 	log.Trace("FileCache::Chmod : Change mode of path %s", options.Name)
 
 	// Update the file in storage
-	err := fc.NextComponent().Chmod(options)
+	err := fc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return fc.NextComponent().Chmod(options)
+	})
 	err = fc.validateStorageError(options.Name, err, "Chmod", false)
 	if err != nil {
 		if err != syscall.EIO {
@@ -1576,7 +1880,10 @@ func (fc *FileCache) Chown(options internal.ChownOptions) error {
 	log.Trace("FileCache::Chown : Change owner of path %s", options.Name)
 
 	// Update the file in storage
-	err := fc.NextComponent().Chown(options)
+	err := fc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return fc.NextComponent().Chown(options)
+	})
 	err = fc.validateStorageError(options.Name, err, "Chown", false)
 	if err != nil {
 		log.Err("FileCache::Chown : %s failed to change owner [%s]", options.Name, err.Error())
@@ -1590,7 +1897,10 @@ func (fc *FileCache) Chown(options internal.ChownOptions) error {
 		fc.policy.CacheValid(localPath)
 
 		err = os.Chown(localPath, options.Owner, options.Group)
-		if err != nil {
+		if os.IsPermission(err) {
+			// Owner of the cached copy is not used for access checks, so a non-root mount can live without it
+			log.Warn("FileCache::Chown : not permitted to change owner on the cached path %s [%s]", localPath, err.Error())
+		} else if err != nil {
 			log.Err("FileCache::Chown : error changing owner on the cached path %s [%s]", localPath, err.Error())
 			return err
 		}
@@ -1599,6 +1909,235 @@ func (fc *FileCache) Chown(options internal.ChownOptions) error {
 	return nil
 }
 
+// SetAttr : Update the file with its new access and modification times
+func (fc *FileCache) SetAttr(options internal.SetAttrOptions) error {
+	log.Trace("FileCache::SetAttr : Change times of path %s", options.Name)
+
+	// Update the file in storage
+	err := fc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return fc.NextComponent().SetAttr(options)
+	})
+	err = fc.validateStorageError(options.Name, err, "SetAttr", false)
+	if err != nil && err != syscall.EIO {
+		log.Err("FileCache::SetAttr : %s failed to change times [%s]", options.Name, err.Error())
+		return err
+	}
+
+	// If the file is not uploaded yet or is open for writing, the next upload will replace the times in storage.
+	// Remember them so that they can be applied again once the upload completes
+	if err == syscall.EIO || fc.fileLocks.Get(options.Name).Count() > 0 {
+		pending := internal.SetAttrOptions{
+			Name: options.Name,
+			Attr: &internal.ObjAttr{Atime: options.Attr.Atime, Mtime: options.Attr.Mtime},
+		}
+		if item, found := fc.missedTimesList.Load(options.Name); found {
+			last := item.(internal.SetAttrOptions)
+			if pending.Attr.Atime.IsZero() {
+				pending.Attr.Atime = last.Attr.Atime
+			}
+			if pending.Attr.Mtime.IsZero() {
+				pending.Attr.Mtime = last.Attr.Mtime
+			}
+		}
+		fc.missedTimesList.Store(options.Name, pending)
+	}
+
+	// Update the times of the file in the local cache
+	localPath := filepath.Join(fc.tmpPath, options.Name)
+	_, err = os.Stat(localPath)
+	if err == nil || os.IsExist(err) {
+		fc.policy.CacheValid(localPath)
+
+		err = os.Chtimes(localPath, options.Attr.Atime, options.Attr.Mtime)
+		if err != nil {
+			log.Err("FileCache::SetAttr : error changing times on the cached path %s [%s]", localPath, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetXattr : Get extended attribute of the file from storage
+func (fc *FileCache) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	log.Trace("FileCache::GetXattr : Get %s of path %s", options.Attr, options.Name)
+
+	if options.Attr == common.CacheClassXattr {
+		return []byte(fc.classes.Classify(options.Name).String()), nil
+	} else if options.Attr == common.CacheStatusXattr {
+		return []byte(fc.cacheStatus(options.Name)), nil
+	}
+
+	value, err := fc.NextComponent().GetXattr(options)
+	if err == syscall.ENOENT && fc.existsOnlyInCache(options.Name) {
+		// File is not uploaded yet so it can not have any attributes
+		return nil, syscall.ENODATA
+	}
+
+	return value, err
+}
+
+// cacheStatus : How much of the file is in local cache
+func (fc *FileCache) cacheStatus(name string) string {
+	localPath := filepath.Join(fc.tmpPath, name)
+	_, err := os.Stat(localPath)
+	if err != nil || !fc.policy.IsCached(localPath) {
+		return common.CacheStatusNone
+	}
+
+	if fc.sparseFileOf(name) != nil {
+		return common.CacheStatusPartial
+	}
+	return common.CacheStatusCached
+}
+
+// ListXattr : List extended attributes of the file from storage
+func (fc *FileCache) ListXattr(options internal.ListXattrOptions) ([]string, error) {
+	log.Trace("FileCache::ListXattr : List attributes of path %s", options.Name)
+
+	names, err := fc.NextComponent().ListXattr(options)
+	if err == syscall.ENOENT && fc.existsOnlyInCache(options.Name) {
+		return []string{}, nil
+	}
+
+	return names, err
+}
+
+// SetXattr : Update extended attribute of the file in storage
+func (fc *FileCache) SetXattr(options internal.SetXattrOptions) error {
+	log.Trace("FileCache::SetXattr : Set %s of path %s", options.Attr, options.Name)
+
+	if options.Attr == common.CacheClassXattr {
+		// Class is kept only in this mount, it is not saved in storage
+		class, err := cache_class.Parse(string(options.Value))
+		if err != nil {
+			log.Err("FileCache::SetXattr : %s [%s]", options.Name, err.Error())
+			return syscall.EINVAL
+		}
+
+		log.Info("FileCache::SetXattr : Cache class of %s set to %s", options.Name, class)
+		fc.classes.Set(options.Name, class)
+		return nil
+	} else if options.Attr == common.CacheStatusXattr {
+		return syscall.EPERM
+	}
+
+	err := fc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return fc.NextComponent().SetXattr(options)
+	})
+	err = fc.validateStorageError(options.Name, err, "SetXattr", false)
+	if err != nil {
+		log.Err("FileCache::SetXattr : %s failed to set %s [%s]", options.Name, options.Attr, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RemoveXattr : Remove extended attribute of the file from storage
+func (fc *FileCache) RemoveXattr(options internal.RemoveXattrOptions) error {
+	log.Trace("FileCache::RemoveXattr : Remove %s of path %s", options.Attr, options.Name)
+
+	if options.Attr == common.CacheClassXattr {
+		if !fc.classes.Clear(options.Name) {
+			return syscall.ENODATA
+		}
+
+		log.Info("FileCache::RemoveXattr : Cache class of %s is back to %s", options.Name, fc.classes.Classify(options.Name))
+		return nil
+	} else if options.Attr == common.CacheStatusXattr {
+		return syscall.EPERM
+	}
+
+	err := fc.changeMetadata(options.Name, func(etag *string) error {
+		options.ETag = etag
+		return fc.NextComponent().RemoveXattr(options)
+	})
+	err = fc.validateStorageError(options.Name, err, "RemoveXattr", false)
+	if err != nil {
+		log.Err("FileCache::RemoveXattr : %s failed to remove %s [%s]", options.Name, options.Attr, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// acquireLease : Lock the file across mounts for the handle, failing to do so is not fatal for the handle
+func (fc *FileCache) acquireLease(handle *handlemap.Handle) {
+	err := fc.leases.Acquire(handle.Path)
+	if err != nil {
+		log.Warn("FileCache::acquireLease : failed to lease %s [%s]", handle.Path, err.Error())
+		return
+	}
+	handle.Flags.Set(handlemap.HandleFlagLeased)
+}
+
+// AcquireLease : Lock the file across mounts on behalf of the application, e.g. for flock
+func (fc *FileCache) AcquireLease(options internal.AcquireLeaseOptions) error {
+	log.Trace("FileCache::AcquireLease : %s", options.Name)
+
+	if !fc.leaseLock {
+		return syscall.ENOTSUP
+	}
+
+	err := fc.leases.Acquire(options.Name)
+	if err == syscall.ENOENT && fc.existsOnlyInCache(options.Name) {
+		// No one else can see the file yet
+		return nil
+	}
+
+	return err
+}
+
+// RenewLease : Leases taken by file cache are renewed in background
+func (fc *FileCache) RenewLease(options internal.RenewLeaseOptions) error {
+	if !fc.leaseLock {
+		return syscall.ENOTSUP
+	}
+	return nil
+}
+
+// ReleaseLease : Unlock the file locked through AcquireLease
+func (fc *FileCache) ReleaseLease(options internal.ReleaseLeaseOptions) error {
+	log.Trace("FileCache::ReleaseLease : %s", options.Name)
+
+	if !fc.leaseLock {
+		return syscall.ENOTSUP
+	}
+
+	return fc.leases.Release(options.Name)
+}
+
+// changeMetadata : Updating metadata of a blob changes its ETag. The change is made under the lock of the file with the
+// ETag the local copy is based on, which storage moves to the new ETag unless someone else changed the blob before.
+// In that case the old ETag is kept so that the next conditional upload or delete of the file still detects it.
+func (fc *FileCache) changeMetadata(name string, change func(etag *string) error) error {
+	flock := fc.fileLocks.Get(name)
+	flock.Lock()
+	defer flock.Unlock()
+
+	etag := flock.ETag()
+	err := change(&etag)
+	if err == nil {
+		flock.SetETag(etag)
+		fc.refreshSparse(name)
+	}
+
+	return err
+}
+
+// existsOnlyInCache : Whether the file was created locally and is yet to reach storage
+func (fc *FileCache) existsOnlyInCache(name string) bool {
+	if fc.createEmptyFile {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(fc.tmpPath, name))
+	return err == nil
+}
+
 func (fc *FileCache) FileUsed(name string) error {
 	// Update the owner and group of the file in the local cache
 	localPath := filepath.Join(fc.tmpPath, name)
@@ -38,4 +38,5 @@ const (
 	usgPer      = "Usage Percent"
 	dlFiles     = "Files Downloaded"
 	cacheServed = "Files served from cache"
+	dlBlocks    = "Sparse Blocks Downloaded"
 )
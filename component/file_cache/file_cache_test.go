@@ -37,6 +37,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"math"
 	"math/rand"
@@ -52,10 +53,14 @@ import (
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/attr_cache"
 	"github.com/Azure/azure-storage-fuse/v2/component/loopback"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
 
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -1598,6 +1603,112 @@ func (suite *fileCacheTestSuite) TestChmodCase2() {
 	suite.assert.EqualValues(attr.Mode, newMode)
 }
 
+func (suite *fileCacheTestSuite) TestSetAttrInCache() {
+	defer suite.cleanupTest()
+	// Setup
+	path := "file43"
+	createHandle, _ := suite.fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0666})
+	suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: createHandle})
+	openHandle, _ := suite.fileCache.OpenFile(internal.OpenFileOptions{Name: path, Mode: 0666})
+
+	// SetAttr
+	mtime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	err := suite.fileCache.SetAttr(internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Mtime: mtime}})
+	suite.assert.Nil(err)
+	// Path in fake storage and file cache should be updated
+	info, _ := os.Stat(suite.cache_path + "/" + path)
+	suite.assert.True(mtime.Equal(info.ModTime()))
+	info, _ = os.Stat(suite.fake_storage_path + "/" + path)
+	suite.assert.True(mtime.Equal(info.ModTime()))
+
+	suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: openHandle})
+}
+
+func (suite *fileCacheTestSuite) TestSetAttrBeforeUpload() {
+	defer suite.cleanupTest()
+	// Setup
+	path := "file44"
+	handle, err := suite.fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0666})
+	suite.assert.Nil(err)
+	_, err = suite.fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("test data")})
+	suite.assert.Nil(err)
+
+	// File is not in storage yet so the times shall be applied once it is uploaded
+	mtime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	err = suite.fileCache.SetAttr(internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Mtime: mtime}})
+	suite.assert.Nil(err)
+
+	err = suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle})
+	suite.assert.Nil(err)
+
+	info, err := os.Stat(suite.fake_storage_path + "/" + path)
+	suite.assert.Nil(err)
+	suite.assert.True(mtime.Equal(info.ModTime()))
+
+	_, found := suite.fileCache.missedTimesList.Load(path)
+	suite.assert.False(found)
+}
+
+func (suite *fileCacheTestSuite) TestLeaseLock() {
+	defer suite.cleanupTest()
+	// Setup
+	suite.cleanupTest()
+	config := fmt.Sprintf("file_cache:\n  path: %s\n  offload-io: true\n  timeout-sec: 0\n  lease-lock: true\n\nloopbackfs:\n  path: %s",
+		suite.cache_path, suite.fake_storage_path)
+	suite.setupTestHelper(config)
+
+	path := "file45"
+	handle, _ := suite.loopback.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.loopback.CloseFile(internal.CloseFileOptions{Handle: handle})
+
+	// Open for read does not lock the file
+	handle, err := suite.fileCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDONLY, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.False(handle.Leased())
+	suite.assert.False(suite.fileCache.leases.IsHeld(path))
+	suite.assert.Nil(suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	// Open for write locks it till the handle is closed
+	handle, err = suite.fileCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDWR, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.True(handle.Leased())
+	suite.assert.True(suite.fileCache.leases.IsHeld(path))
+
+	_, err = suite.fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("test data")})
+	suite.assert.Nil(err)
+	suite.assert.Nil(suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+	suite.assert.False(suite.fileCache.leases.IsHeld(path))
+}
+
+func (suite *fileCacheTestSuite) TestLeaseLockNewFile() {
+	defer suite.cleanupTest()
+	// Setup
+	suite.cleanupTest()
+	config := fmt.Sprintf("file_cache:\n  path: %s\n  offload-io: true\n  timeout-sec: 0\n  lease-lock: true\n\nloopbackfs:\n  path: %s",
+		suite.cache_path, suite.fake_storage_path)
+	suite.setupTestHelper(config)
+
+	// File is locked once it reaches storage
+	path := "file46"
+	handle, err := suite.fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.False(handle.Leased())
+
+	err = suite.fileCache.FlushFile(internal.FlushFileOptions{Handle: handle})
+	suite.assert.Nil(err)
+	suite.assert.True(handle.Leased())
+
+	suite.assert.Nil(suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+	suite.assert.False(suite.fileCache.leases.IsHeld(path))
+}
+
+func (suite *fileCacheTestSuite) TestLeaseLockDisabled() {
+	defer suite.cleanupTest()
+
+	err := suite.fileCache.AcquireLease(internal.AcquireLeaseOptions{Name: "file47"})
+	suite.assert.Equal(syscall.ENOTSUP, err)
+}
+
 func (suite *fileCacheTestSuite) TestChownNotInCache() {
 	defer suite.cleanupTest()
 	// Setup
@@ -1693,6 +1804,292 @@ func (suite *fileCacheTestSuite) TestChownCase2() {
 	suite.assert.True(os.IsNotExist(err))
 }
 
+func (suite *fileCacheTestSuite) TestChownOnFirstUpload() {
+	defer suite.cleanupTest()
+	mockCtrl := gomock.NewController(suite.T())
+	defer mockCtrl.Finish()
+	mock := internal.NewMockComponent(mockCtrl)
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf("file_cache:\n  path: %s\n  timeout-sec: 0\n", suite.cache_path)))
+	fileCache := newTestFileCache(mock)
+	suite.assert.Nil(fileCache.Start(context.Background()))
+	defer fileCache.Stop()
+
+	// Owner given on create is set in storage after the file is uploaded
+	path := "file40"
+	handle, err := fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: common.DefaultFilePermissionBits, Uid: 1001, Gid: 1002, OwnerSet: true})
+	suite.assert.Nil(err)
+	mock.EXPECT().CopyFromFile(gomock.Any()).Return(nil)
+	mock.EXPECT().Chown(gomock.Any()).DoAndReturn(func(options internal.ChownOptions) error {
+		suite.assert.Equal(path, options.Name)
+		suite.assert.Equal(1001, options.Owner)
+		suite.assert.Equal(1002, options.Group)
+		return nil
+	})
+	suite.assert.Nil(fileCache.FlushFile(internal.FlushFileOptions{Handle: handle}))
+
+	// Later uploads keep the owner in storage, so it is set only once
+	handle.Flags.Set(handlemap.HandleFlagDirty)
+	mock.EXPECT().CopyFromFile(gomock.Any()).Return(nil)
+	suite.assert.Nil(fileCache.FlushFile(internal.FlushFileOptions{Handle: handle}))
+}
+
+func (suite *fileCacheTestSuite) TestXattr() {
+	defer suite.cleanupTest()
+	// Setup
+	path := "file39"
+	handle, _ := suite.fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle})
+
+	err := suite.fileCache.SetXattr(internal.SetXattrOptions{Name: path, Attr: "user.tag", Value: []byte("blue")})
+	suite.assert.Nil(err)
+
+	value, err := suite.fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: "user.tag"})
+	suite.assert.Nil(err)
+	suite.assert.Equal([]byte("blue"), value)
+
+	names, err := suite.fileCache.ListXattr(internal.ListXattrOptions{Name: path})
+	suite.assert.Nil(err)
+	suite.assert.Contains(names, "user.tag")
+
+	err = suite.fileCache.RemoveXattr(internal.RemoveXattrOptions{Name: path, Attr: "user.tag"})
+	suite.assert.Nil(err)
+
+	_, err = suite.fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: "user.tag"})
+	suite.assert.Equal(syscall.ENODATA, err)
+}
+
+func (suite *fileCacheTestSuite) TestXattrCase2() {
+	defer suite.cleanupTest()
+	// Default is to not create empty files on create file to support immutable storage.
+	path := "file40"
+	suite.fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+
+	_, err := suite.fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: "user.tag"})
+	suite.assert.Equal(syscall.ENODATA, err)
+
+	names, err := suite.fileCache.ListXattr(internal.ListXattrOptions{Name: path})
+	suite.assert.Nil(err)
+	suite.assert.Empty(names)
+
+	err = suite.fileCache.SetXattr(internal.SetXattrOptions{Name: path, Attr: "user.tag", Value: []byte("blue")})
+	suite.assert.Equal(syscall.EIO, err)
+}
+
+// Uploading a file replaces the metadata of the blob, attributes set earlier shall survive it
+func (suite *fileCacheTestSuite) TestXattrKeptOnFlush() {
+	defer suite.cleanupTest()
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf("memstore:\n  latency-ms: 0\nfile_cache:\n  path: %s\n  timeout-sec: 0\n", suite.cache_path)))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.Nil(store.Configure(true))
+	fileCache := newTestFileCache(store)
+	suite.assert.Nil(fileCache.Start(context.Background()))
+	defer fileCache.Stop()
+
+	path := "file41"
+	handle, err := fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.Nil(fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	err = fileCache.SetXattr(internal.SetXattrOptions{Name: path, Attr: "user.tag", Value: []byte("blue")})
+	suite.assert.Nil(err)
+
+	handle, err = fileCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDWR, Mode: 0777})
+	suite.assert.Nil(err)
+	_, err = fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("test data")})
+	suite.assert.Nil(err)
+	suite.assert.Nil(fileCache.FlushFile(internal.FlushFileOptions{Handle: handle}))
+
+	value, err := fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: "user.tag"})
+	suite.assert.Nil(err)
+	suite.assert.Equal([]byte("blue"), value)
+	suite.assert.Nil(fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+}
+
+func (suite *fileCacheTestSuite) TestMetadataChangeMovesETag() {
+	defer suite.cleanupTest()
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf("memstore:\n  latency-ms: 0\n  optimistic-concurrency: true\nfile_cache:\n  path: %s\n  timeout-sec: 0\n", suite.cache_path)))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.Nil(store.Configure(true))
+	fileCache := newTestFileCache(store)
+	suite.assert.Nil(fileCache.Start(context.Background()))
+	defer fileCache.Stop()
+
+	path := "file42"
+	handle, err := fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	suite.assert.Nil(fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	handle, err = fileCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDWR, Mode: 0777})
+	suite.assert.Nil(err)
+
+	// Times set while the file is open are applied again after each upload
+	err = fileCache.SetAttr(internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Mtime: time.Now().Add(-time.Hour)}})
+	suite.assert.Nil(err)
+	err = fileCache.SetXattr(internal.SetXattrOptions{Name: path, Attr: "user.tag", Value: []byte("blue")})
+	suite.assert.Nil(err)
+	for i := 0; i < 2; i++ {
+		_, err = fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("test data")})
+		suite.assert.Nil(err)
+		suite.assert.Nil(fileCache.FlushFile(internal.FlushFileOptions{Handle: handle}))
+	}
+
+	// Change made by someone else is still a conflict
+	err = store.SetAttr(internal.SetAttrOptions{Name: path, Attr: &internal.ObjAttr{Mtime: time.Now()}})
+	suite.assert.Nil(err)
+	_, err = fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("old data")})
+	suite.assert.Nil(err)
+	suite.assert.Equal(syscall.ESTALE, fileCache.FlushFile(internal.FlushFileOptions{Handle: handle}))
+}
+
+func (suite *fileCacheTestSuite) TestOpenAfterCachedAttrIsStale() {
+	defer suite.cleanupTest()
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf("memstore:\n  latency-ms: 0\n  optimistic-concurrency: true\nattr_cache:\n  timeout-sec: 120\nfile_cache:\n  path: %s\n  timeout-sec: 0\n", suite.cache_path)))
+	store := memstore.NewMemStoreComponent()
+	suite.assert.Nil(store.Configure(true))
+	attrCache := attr_cache.NewAttrCacheComponent()
+	attrCache.SetNextComponent(store)
+	suite.assert.Nil(attrCache.Configure(true))
+	suite.assert.Nil(attrCache.Start(context.Background()))
+	defer attrCache.Stop()
+	fileCache := newTestFileCache(attrCache)
+	suite.assert.Nil(fileCache.Start(context.Background()))
+	defer fileCache.Stop()
+
+	path := "file43"
+	handle, err := fileCache.CreateFile(internal.CreateFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+	_, err = fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("old data")})
+	suite.assert.Nil(err)
+	suite.assert.Nil(fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	// Blob is overwritten by someone else while its attributes are cached
+	_, err = fileCache.GetAttr(internal.GetAttrOptions{Name: path})
+	suite.assert.Nil(err)
+	_, err = store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(path), Offset: 0, Data: []byte("new data!")})
+	suite.assert.Nil(err)
+
+	// Open downloads the new version and keeps its ETag, so the upload is not taken as a conflict
+	handle, err = fileCache.OpenFile(internal.OpenFileOptions{Name: path, Flags: os.O_RDWR, Mode: 0777})
+	suite.assert.Nil(err)
+	data := make([]byte, 20)
+	n, err := fileCache.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: 0, Data: data})
+	suite.assert.True(err == nil || err == io.EOF)
+	suite.assert.Equal("new data!", string(data[:n]))
+
+	_, err = fileCache.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("our")})
+	suite.assert.Nil(err)
+	suite.assert.Nil(fileCache.FlushFile(internal.FlushFileOptions{Handle: handle}))
+	suite.assert.Nil(fileCache.CloseFile(internal.CloseFileOptions{Handle: handle}))
+}
+
+func (suite *fileCacheTestSuite) TestCacheClassXattr() {
+	defer suite.cleanupTest()
+	path := "dir41/file41"
+
+	value, err := suite.fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: common.CacheClassXattr})
+	suite.assert.Nil(err)
+	suite.assert.Equal([]byte("normal"), value)
+
+	err = suite.fileCache.SetXattr(internal.SetXattrOptions{Name: path, Attr: common.CacheClassXattr, Value: []byte("sticky")})
+	suite.assert.Equal(syscall.EINVAL, err)
+
+	// Class set on a directory applies to the files under it
+	err = suite.fileCache.SetXattr(internal.SetXattrOptions{Name: "dir41", Attr: common.CacheClassXattr, Value: []byte("pin")})
+	suite.assert.Nil(err)
+
+	value, err = suite.fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: common.CacheClassXattr})
+	suite.assert.Nil(err)
+	suite.assert.Equal([]byte("pin"), value)
+
+	err = suite.fileCache.RemoveXattr(internal.RemoveXattrOptions{Name: "dir41", Attr: common.CacheClassXattr})
+	suite.assert.Nil(err)
+
+	err = suite.fileCache.RemoveXattr(internal.RemoveXattrOptions{Name: "dir41", Attr: common.CacheClassXattr})
+	suite.assert.Equal(syscall.ENODATA, err)
+
+	value, err = suite.fileCache.GetXattr(internal.GetXattrOptions{Name: path, Attr: common.CacheClassXattr})
+	suite.assert.Nil(err)
+	suite.assert.Equal([]byte("normal"), value)
+}
+
+func (suite *fileCacheTestSuite) TestCacheClassConfig() {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+
+	config.ResetConfig()
+	configuration := fmt.Sprintf("file_cache:\n  path: %s\n  timeout-sec: 120\n\nloopbackfs:\n  path: %s\n\ncache-classes:\n  - path: models\n    class: pin\n",
+		suite.cache_path, suite.fake_storage_path)
+	suite.setupTestHelper(configuration)
+
+	value, err := suite.fileCache.GetXattr(internal.GetXattrOptions{Name: "models/a.bin", Attr: common.CacheClassXattr})
+	suite.assert.Nil(err)
+	suite.assert.Equal([]byte("pin"), value)
+
+	fileCache := NewFileCacheComponent()
+	config.ReadConfigFromReader(strings.NewReader(configuration + "  - path: tmp\n    class: never\n"))
+	err = fileCache.Configure(true)
+	suite.assert.NotNil(err)
+	suite.assert.Contains(err.Error(), "cache-classes")
+}
+
+func (suite *fileCacheTestSuite) TestOnChange() {
+	defer suite.cleanupTest()
+	suite.cleanupTest()
+
+	config.ResetConfig()
+	configuration := fmt.Sprintf("file_cache:\n  path: %s\n  timeout-sec: 120\n\nloopbackfs:\n  path: %s",
+		suite.cache_path, suite.fake_storage_path)
+	suite.setupTestHelper(configuration)
+
+	path := "dir42/file42"
+	_ = os.MkdirAll(filepath.Join(suite.fake_storage_path, "dir42"), 0777)
+	_ = os.WriteFile(filepath.Join(suite.fake_storage_path, path), []byte("old data"), 0777)
+
+	readAll := func() string {
+		handle, err := suite.fileCache.OpenFile(internal.OpenFileOptions{Name: path, Mode: 0777})
+		suite.assert.Nil(err)
+		defer suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle})
+
+		data, err := suite.fileCache.ReadFile(internal.ReadFileOptions{Handle: handle})
+		suite.assert.Nil(err)
+		return string(data)
+	}
+
+	suite.assert.Equal("old data", readAll())
+
+	// Changed by someone else, cached copy is used till the change is reported
+	_ = os.WriteFile(filepath.Join(suite.fake_storage_path, path), []byte("new data"), 0777)
+	suite.assert.Equal("old data", readAll())
+
+	suite.fileCache.onChange(invalidation.Event{Path: path})
+	suite.assert.Equal("new data", readAll())
+
+	// Changed while open, downloaded again only once it is closed
+	handle, err := suite.fileCache.OpenFile(internal.OpenFileOptions{Name: path, Mode: 0777})
+	suite.assert.Nil(err)
+
+	_ = os.WriteFile(filepath.Join(suite.fake_storage_path, path), []byte("newer data"), 0777)
+	suite.fileCache.onChange(invalidation.Event{Path: path})
+	suite.assert.Equal("new data", readAll())
+
+	err = suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: handle})
+	suite.assert.Nil(err)
+	suite.assert.Equal("newer data", readAll())
+
+	// Directory changed, everything under it is removed from the cache
+	suite.fileCache.onChange(invalidation.Event{Path: "dir42", Dir: true})
+	_, err = os.Stat(filepath.Join(suite.cache_path, path))
+	for i := 0; i < 10 && !os.IsNotExist(err); i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, err = os.Stat(filepath.Join(suite.cache_path, path))
+	}
+	suite.assert.True(os.IsNotExist(err))
+}
+
 func (suite *fileCacheTestSuite) TestZZMountPathConflict() {
 	defer suite.cleanupTest()
 	cacheTimeout := 1
@@ -1805,6 +2202,10 @@ func (suite *fileCacheTestSuite) TestReadFileWithRefresh() {
 		suite.cache_path, createEmptyFile, suite.fake_storage_path)
 	suite.setupTestHelper(config) // setup a new file cache with a custom config (teardown will occur after the test as usual)
 
+	var notified []invalidation.Event
+	invalidation.SetNotifier(func(e invalidation.Event) { notified = append(notified, e) })
+	defer invalidation.SetNotifier(nil)
+
 	path := "file42"
 	err := os.WriteFile(suite.fake_storage_path+"/"+path, []byte("test data"), 0777)
 	suite.assert.Nil(err)
@@ -1837,6 +2238,7 @@ func (suite *fileCacheTestSuite) TestReadFileWithRefresh() {
 	// Now wait for 5 seconds and we shall get the updated content on next read
 	err = os.WriteFile(suite.fake_storage_path+"/"+path, []byte("test data123456"), 0777)
 	suite.assert.Nil(err)
+	suite.assert.Empty(notified)
 	time.Sleep(12 * time.Second)
 	f, err = suite.fileCache.OpenFile(options)
 	suite.assert.Nil(err)
@@ -1844,6 +2246,9 @@ func (suite *fileCacheTestSuite) TestReadFileWithRefresh() {
 	n, err = suite.fileCache.ReadInBuffer(internal.ReadInBufferOptions{Handle: f, Offset: 0, Data: data})
 	suite.assert.Nil(err)
 	suite.assert.Equal(15, n)
+
+	// Kernel is told to drop the old data as well
+	suite.assert.Equal([]invalidation.Event{{Path: path}}, notified)
 	err = suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: f})
 	suite.assert.Nil(err)
 }
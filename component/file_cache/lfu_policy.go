@@ -0,0 +1,106 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"sort"
+)
+
+type lfuEntry struct {
+	count uint64 // Number of uses while in the cache
+	key   uint64 // Priority, the lowest is evicted first
+	seq   uint64 // Use sequence of the last use, breaks ties between equal keys
+}
+
+// lfuOrder : Least frequently used files are evicted first. Dynamic aging adds priority of the last evicted
+// file to the files used after it, so files which were popular long ago do not stay in the cache forever.
+type lfuOrder struct {
+	entries map[string]*lfuEntry
+	age     uint64 // Priority of the last evicted file
+	seq     uint64
+}
+
+var _ evictionOrder = &lfuOrder{}
+
+func newLFUOrder() *lfuOrder {
+	return &lfuOrder{
+		entries: make(map[string]*lfuEntry),
+	}
+}
+
+func NewLFUPolicy(cfg cachePolicyConfig) cachePolicy {
+	return newOrderedPolicy(cfg, "lfu", newLFUOrder())
+}
+
+func (o *lfuOrder) access(name string) {
+	e, found := o.entries[name]
+	if !found {
+		e = &lfuEntry{}
+		o.entries[name] = e
+	}
+
+	o.seq++
+	e.count++
+	e.key = o.age + e.count
+	e.seq = o.seq
+}
+
+func (o *lfuOrder) remove(name string, evicted bool) {
+	e, found := o.entries[name]
+	if !found {
+		return
+	}
+
+	if evicted {
+		o.age = max(o.age, e.key)
+	}
+	delete(o.entries, name)
+}
+
+func (o *lfuOrder) victims(n int, _ func(string) int64) []string {
+	names := make([]string, 0, len(o.entries))
+	for name := range o.entries {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, b := o.entries[names[i]], o.entries[names[j]]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		return a.seq < b.seq
+	})
+
+	return names[:min(n, len(names))]
+}
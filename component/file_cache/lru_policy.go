@@ -34,13 +34,12 @@
 package file_cache
 
 import (
-	"os"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
 )
 
 type lruNode struct {
@@ -185,8 +184,20 @@ func (p *lruPolicy) CacheInvalidate(name string) {
 	// since there are other open handles. When the last close comes in, the map
 	// will be clean so we we need to try deleting the file.
 	_, found := p.nodeMap.Load(name)
-	if p.cacheTimeout == 0 || !found {
+	if !found {
 		p.CachePurge(name)
+		return
+	}
+
+	// Pinned files stay even with timeout 0 while files not to be cached go right away
+	switch p.classOf(name) {
+	case cache_class.Pin:
+	case cache_class.NoCache:
+		p.CachePurge(name)
+	default:
+		if p.cacheTimeout == 0 {
+			p.CachePurge(name)
+		}
 	}
 }
 
@@ -294,6 +305,10 @@ func (p *lruPolicy) clearCache() {
 			// File cache timeout has not occurred so just monitor the cache usage
 			cleanupCount := 0
 			pUsage := getUsagePercentage(p.tmpPath, p.maxSizeMB)
+			if pUsage > p.highThreshold && p.deleteEvictFirst() > 0 {
+				pUsage = getUsagePercentage(p.tmpPath, p.maxSizeMB)
+			}
+
 			if pUsage > p.highThreshold {
 				continueDeletion := true
 				for continueDeletion {
@@ -416,6 +431,11 @@ func (p *lruPolicy) deleteExpiredNodes() {
 	for _, item := range delItems {
 		if item.deleted {
 			p.removeNode(item.name)
+			if p.classOf(item.name) == cache_class.Pin {
+				// Pinned files are never evicted, they go back to the head of the list
+				p.CacheValid(item.name)
+				continue
+			}
 			p.deleteItem(item.name)
 		}
 	}
@@ -423,45 +443,37 @@ func (p *lruPolicy) deleteExpiredNodes() {
 	log.Debug("lruPolicy::deleteExpiredNodes : Ends")
 }
 
-func (p *lruPolicy) deleteItem(name string) {
-	log.Trace("lruPolicy::deleteItem : Deleting %s", name)
-
-	azPath := strings.TrimPrefix(name, p.tmpPath)
-	if azPath == "" {
-		log.Err("lruPolicy::DeleteItem : Empty file name formed name : %s, tmpPath : %s", name, p.tmpPath)
-		return
+// deleteEvictFirst : Delete files of evict-first class, before any other file is evicted. Returns the number of files deleted.
+func (p *lruPolicy) deleteEvictFirst() int {
+	if p.classes == nil {
+		return 0
 	}
 
-	if azPath[0] == '/' {
-		azPath = azPath[1:]
-	}
+	names := make([]string, 0)
+	p.nodeMap.Range(func(key, _ any) bool {
+		name := key.(string)
+		if p.classOf(name) == cache_class.EvictFirst {
+			names = append(names, name)
+		}
+		return uint32(len(names)) < p.maxEviction
+	})
 
-	flock := p.fileLocks.Get(azPath)
-	if p.fileLocks.Locked(azPath) {
-		log.Warn("lruPolicy::DeleteItem : File in under download %s", azPath)
-		p.CacheValid(name)
-		return
+	log.Info("lruPolicy::deleteEvictFirst : Deleting %d evict-first files", len(names))
+	for _, name := range names {
+		p.removeNode(name)
+		p.deleteItem(name)
 	}
 
-	flock.Lock()
-	defer flock.Unlock()
+	return len(names)
+}
 
-	// Check if there are any open handles to this file or not
-	if flock.Count() > 0 {
-		log.Warn("lruPolicy::DeleteItem : File in use %s", name)
-		p.CacheValid(name)
-		return
-	}
+func (p *lruPolicy) deleteItem(name string) {
+	log.Trace("lruPolicy::deleteItem : Deleting %s", name)
 
-	// There are no open handles for this file so its safe to remove this
-	err := deleteFile(name)
-	if err != nil && !os.IsNotExist(err) {
-		log.Err("lruPolicy::DeleteItem : failed to delete local file %s [%s]", name, err.Error())
+	if !deleteCachedFile(p.tmpPath, p.fileLocks, name) {
+		// File is open or being downloaded, keep it in the cache as a recent use
+		p.CacheValid(name)
 	}
-
-	// File was deleted so try clearing its parent directory
-	// TODO: Delete directories up the path recursively that are "safe to delete". Ensure there is no race between this code and code that creates directories (like OpenFile)
-	// This might require something like hierarchical locking.
 }
 
 func (p *lruPolicy) printNodes() {
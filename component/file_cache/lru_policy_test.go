@@ -42,6 +42,7 @@ import (
 	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -270,6 +271,97 @@ func (suite *lruPolicyTestSuite) TestMaxEviction() {
 	}
 }
 
+func (suite *lruPolicyTestSuite) setupClasses(timeout uint32) {
+	suite.cleanupTest()
+	os.Mkdir(cache_path, fs.FileMode(0777))
+
+	classes, err := cache_class.New([]cache_class.RuleOptions{
+		{Path: "models", Class: "pin"},
+		{Path: "scratch", Class: "evict-first"},
+		{Path: "tmp", Class: "no-cache"},
+	})
+	suite.assert.NoError(err)
+
+	config := cachePolicyConfig{
+		tmpPath:       cache_path,
+		cacheTimeout:  timeout,
+		maxEviction:   defaultMaxEviction,
+		highThreshold: defaultMaxThreshold,
+		lowThreshold:  defaultMinThreshold,
+		fileLocks:     &common.LockMap{},
+		classes:       classes,
+	}
+	suite.setupTestHelper(config)
+}
+
+func (suite *lruPolicyTestSuite) TestCacheInvalidateClasses() {
+	defer suite.cleanupTest()
+	suite.setupClasses(0)
+
+	// Pinned file stays even with timeout 0
+	suite.policy.CacheValid("models/a")
+	suite.policy.CacheInvalidate("models/a")
+	suite.assert.True(suite.policy.IsCached("models/a"))
+
+	suite.policy.CacheValid("data")
+	suite.policy.CacheInvalidate("data")
+	suite.assert.False(suite.policy.IsCached("data"))
+
+	// Purge still removes a pinned file, e.g. on delete
+	suite.policy.CachePurge("models/a")
+	suite.assert.False(suite.policy.IsCached("models/a"))
+}
+
+func (suite *lruPolicyTestSuite) TestCacheInvalidateNoCache() {
+	defer suite.cleanupTest()
+	suite.setupClasses(120)
+
+	suite.policy.CacheValid("tmp/a")
+	suite.policy.CacheInvalidate("tmp/a")
+	suite.assert.False(suite.policy.IsCached("tmp/a"))
+
+	suite.policy.CacheValid("data")
+	suite.policy.CacheInvalidate("data")
+	suite.assert.True(suite.policy.IsCached("data"))
+}
+
+func (suite *lruPolicyTestSuite) TestPinnedNotExpired() {
+	defer suite.cleanupTest()
+	suite.setupClasses(120)
+
+	suite.policy.CacheValid("models/a")
+	suite.policy.CacheValid("data")
+
+	// Both files fall behind the last marker as if two timeouts passed
+	suite.policy.updateMarker()
+	suite.policy.updateMarker()
+	suite.policy.deleteExpiredNodes()
+
+	suite.assert.True(suite.policy.IsCached("models/a"))
+	suite.assert.False(suite.policy.IsCached("data"))
+	suite.assert.Equal("models/a", suite.policy.head.name)
+}
+
+func (suite *lruPolicyTestSuite) TestDeleteEvictFirst() {
+	defer suite.cleanupTest()
+	suite.setupClasses(120)
+
+	f, _ := os.Create(filepath.Join(cache_path, "scratch"))
+	f.Close()
+
+	suite.policy.CacheValid(filepath.Join(cache_path, "scratch"))
+	suite.policy.CacheValid(filepath.Join(cache_path, "data"))
+	suite.policy.CacheValid(filepath.Join(cache_path, "models"))
+
+	suite.assert.Equal(1, suite.policy.deleteEvictFirst())
+	suite.assert.False(suite.policy.IsCached(filepath.Join(cache_path, "scratch")))
+	suite.assert.True(suite.policy.IsCached(filepath.Join(cache_path, "data")))
+	suite.assert.True(suite.policy.IsCached(filepath.Join(cache_path, "models")))
+
+	_, err := os.Stat(filepath.Join(cache_path, "scratch"))
+	suite.assert.True(os.IsNotExist(err))
+}
+
 func TestLRUPolicyTestSuite(t *testing.T) {
 	suite.Run(t, new(lruPolicyTestSuite))
 }
@@ -0,0 +1,336 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
+)
+
+// evictionOrder : Decides which cached files go first when the cache has to be shrunk.
+// Implementations are not safe for concurrent use, the policy holding them serializes the calls.
+type evictionOrder interface {
+	access(name string)                              // File was opened, read or written
+	remove(name string, evicted bool)                // File left the cache, either evicted or purged
+	victims(n int, size func(string) int64) []string // Up to n cached files in the order they should be evicted
+}
+
+// orderedPolicy : Cache policy which expires files on timeout like lru but, when disk usage goes over the
+// high threshold, evicts files in the order given by an evictionOrder (lfu, arc, size)
+type orderedPolicy struct {
+	sync.Mutex
+	cachePolicyConfig
+
+	name  string
+	order evictionOrder
+
+	// Files in the cache and when they were used last
+	lastUsed map[string]time.Time
+
+	// Channel to close main channel select loop
+	closeSignal chan int
+
+	// Channel to contain files that needs to be deleted immediately
+	deleteEvent chan string
+
+	// Channel to check disk usage is within the limits configured or not
+	diskUsageMonitor <-chan time.Time
+
+	// Channel to check for file eviction based on file-cache timeout
+	cacheTimeoutMonitor <-chan time.Time
+
+	// DU utility was found on the path or not
+	duPresent bool
+}
+
+var _ cachePolicy = &orderedPolicy{}
+
+func newOrderedPolicy(cfg cachePolicyConfig, name string, order evictionOrder) *orderedPolicy {
+	return &orderedPolicy{
+		cachePolicyConfig: cfg,
+		name:              name,
+		order:             order,
+		lastUsed:          make(map[string]time.Time),
+	}
+}
+
+func (p *orderedPolicy) StartPolicy() error {
+	log.Trace("orderedPolicy::StartPolicy : %s", p.name)
+
+	p.closeSignal = make(chan int)
+	p.deleteEvent = make(chan string, 1000)
+
+	_, err := common.GetUsage(p.tmpPath)
+	if err == nil {
+		p.duPresent = true
+	} else {
+		log.Err("orderedPolicy::StartPolicy : 'du' command not found, disabling disk usage checks")
+	}
+
+	if p.duPresent {
+		p.diskUsageMonitor = time.Tick(time.Duration(DiskUsageCheckInterval * time.Minute))
+	}
+
+	// If timeout is 0 files are deleted on invalidate so there is nothing to expire
+	log.Info("orderedPolicy::StartPolicy : %s policy set with %v timeout", p.name, p.cacheTimeout)
+	if p.cacheTimeout != 0 {
+		p.cacheTimeoutMonitor = time.Tick(time.Duration(p.cacheTimeout) * time.Second)
+	}
+
+	go p.clearCache()
+	return nil
+}
+
+func (p *orderedPolicy) ShutdownPolicy() error {
+	log.Trace("orderedPolicy::ShutdownPolicy : %s", p.name)
+	p.closeSignal <- 1
+	return nil
+}
+
+func (p *orderedPolicy) UpdateConfig(c cachePolicyConfig) error {
+	log.Trace("orderedPolicy::UpdateConfig : %s", p.name)
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.maxSizeMB = c.maxSizeMB
+	p.highThreshold = c.highThreshold
+	p.lowThreshold = c.lowThreshold
+	p.maxEviction = c.maxEviction
+	p.policyTrace = c.policyTrace
+	return nil
+}
+
+func (p *orderedPolicy) CacheValid(name string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.lastUsed[name] = time.Now()
+	p.order.access(name)
+}
+
+func (p *orderedPolicy) CacheInvalidate(name string) {
+	log.Trace("orderedPolicy::CacheInvalidate : %s", name)
+
+	// A file not known to the policy was purged while other handles were open and this is the last one to close
+	if !p.IsCached(name) {
+		p.CachePurge(name)
+		return
+	}
+
+	// With timeout 0 the file goes as soon as it is closed, unless it is pinned
+	switch p.classOf(name) {
+	case cache_class.Pin:
+	case cache_class.NoCache:
+		p.CachePurge(name)
+	default:
+		if p.cacheTimeout == 0 {
+			p.CachePurge(name)
+		}
+	}
+}
+
+func (p *orderedPolicy) CachePurge(name string) {
+	log.Trace("orderedPolicy::CachePurge : %s", name)
+
+	p.Lock()
+	if _, found := p.lastUsed[name]; found {
+		delete(p.lastUsed, name)
+		p.order.remove(name, false)
+	}
+	p.Unlock()
+
+	p.deleteEvent <- name
+}
+
+func (p *orderedPolicy) IsCached(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	_, found := p.lastUsed[name]
+	log.Trace("orderedPolicy::IsCached : %s, found %t", name, found)
+	return found
+}
+
+func (p *orderedPolicy) Name() string {
+	return p.name
+}
+
+func (p *orderedPolicy) clearCache() {
+	log.Trace("orderedPolicy::clearCache")
+
+	for {
+		select {
+		case name := <-p.deleteEvent:
+			// we are asked to delete file explicitly
+			if !deleteCachedFile(p.tmpPath, p.fileLocks, name) {
+				p.CacheValid(name)
+			}
+
+		case <-p.cacheTimeoutMonitor:
+			p.evictExpired()
+
+		case <-p.diskUsageMonitor:
+			p.Lock()
+			highThreshold, lowThreshold, maxSizeMB := p.highThreshold, p.lowThreshold, p.maxSizeMB
+			p.Unlock()
+
+			pUsage := getUsagePercentage(p.tmpPath, maxSizeMB)
+			for cleanupCount := 0; pUsage > highThreshold && cleanupCount < 3; cleanupCount++ {
+				log.Info("orderedPolicy::clearCache : High threshold reached %f > %f", pUsage, highThreshold)
+
+				if p.evictOrdered(lowThreshold, maxSizeMB) == 0 {
+					break
+				}
+
+				pUsage = getUsagePercentage(p.tmpPath, maxSizeMB)
+				if pUsage < lowThreshold {
+					log.Info("orderedPolicy::clearCache : Threshold stabilized %f < %f", pUsage, lowThreshold)
+				}
+			}
+
+		case <-p.closeSignal:
+			return
+		}
+	}
+}
+
+// evictExpired : Delete files not used for the cache timeout
+func (p *orderedPolicy) evictExpired() {
+	expiry := time.Now().Add(-time.Duration(p.cacheTimeout) * time.Second)
+
+	p.Lock()
+	expired := make(map[string]time.Time)
+	for name, used := range p.lastUsed {
+		if used.Before(expiry) && p.classOf(name) != cache_class.Pin {
+			expired[name] = used
+			if uint32(len(expired)) >= p.maxEviction {
+				log.Debug("orderedPolicy::evictExpired : Max deletion count hit")
+				break
+			}
+		}
+	}
+	p.Unlock()
+
+	log.Debug("orderedPolicy::evictExpired : %d files expired", len(expired))
+	for name, used := range expired {
+		p.evict(name, used)
+	}
+}
+
+// evictOrdered : Delete files in eviction order, in a batch of max-eviction files, till usage goes below
+// the low threshold. Files of evict-first class go before the others and pinned files are left out.
+// Returns the number of files deleted.
+func (p *orderedPolicy) evictOrdered(lowThreshold float64, maxSizeMB float64) int {
+	p.Lock()
+	victims := make([]string, 0)
+	for name := range p.lastUsed {
+		if p.classOf(name) == cache_class.EvictFirst {
+			victims = append(victims, name)
+		}
+	}
+
+	for _, name := range p.order.victims(len(p.lastUsed), fileSize) {
+		if class := p.classOf(name); class != cache_class.Pin && class != cache_class.EvictFirst {
+			victims = append(victims, name)
+		}
+	}
+	victims = victims[:min(len(victims), int(p.maxEviction))]
+
+	used := make([]time.Time, len(victims))
+	for i, name := range victims {
+		used[i] = p.lastUsed[name]
+	}
+	p.Unlock()
+
+	p.printVictims(victims)
+
+	count := 0
+	for i, name := range victims {
+		if !p.evict(name, used[i]) {
+			continue
+		}
+
+		// Usage is costly to compute so check it once every few files
+		count++
+		if count%16 == 0 && getUsagePercentage(p.tmpPath, maxSizeMB) < lowThreshold {
+			break
+		}
+	}
+
+	return count
+}
+
+// evict : Delete a cached file unless it is in use or was used again since it was picked
+func (p *orderedPolicy) evict(name string, used time.Time) bool {
+	if !deleteCachedFile(p.tmpPath, p.fileLocks, name) {
+		p.CacheValid(name)
+		return false
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if last, found := p.lastUsed[name]; found && last.Equal(used) {
+		delete(p.lastUsed, name)
+		p.order.remove(name, true)
+	}
+	return true
+}
+
+func (p *orderedPolicy) printVictims(victims []string) {
+	if !p.policyTrace {
+		return
+	}
+
+	log.Debug("orderedPolicy::printVictims : %s Starts", p.name)
+	for i, name := range victims {
+		log.Debug(" ==> (%d) %s", i, name)
+	}
+	log.Debug("orderedPolicy::printVictims : Ends")
+}
+
+// fileSize : Size of a file in the local cache, 0 if it is not there
+func fileSize(name string) int64 {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
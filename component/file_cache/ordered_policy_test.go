@@ -0,0 +1,357 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal/cache_class"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type orderedPolicyTestSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	policy  *orderedPolicy
+	classes *cache_class.Classifier
+}
+
+func (suite *orderedPolicyTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+	suite.assert = assert.New(suite.T())
+
+	os.Mkdir(cache_path, fs.FileMode(0777))
+}
+
+func (suite *orderedPolicyTestSuite) setupPolicy(name string, timeout uint32) {
+	config := cachePolicyConfig{
+		tmpPath:       cache_path,
+		cacheTimeout:  timeout,
+		maxEviction:   defaultMaxEviction,
+		highThreshold: defaultMaxThreshold,
+		lowThreshold:  defaultMinThreshold,
+		fileLocks:     &common.LockMap{},
+		classes:       suite.classes,
+	}
+
+	policy, err := newCachePolicy(name, config)
+	suite.assert.NoError(err)
+	suite.policy = policy.(*orderedPolicy)
+	suite.assert.NoError(suite.policy.StartPolicy())
+}
+
+func (suite *orderedPolicyTestSuite) TearDownTest() {
+	if suite.policy != nil {
+		_ = suite.policy.ShutdownPolicy()
+		suite.policy = nil
+	}
+	suite.classes = nil
+	os.RemoveAll(cache_path)
+}
+
+func (suite *orderedPolicyTestSuite) createFile(name string, size int) string {
+	path := filepath.Join(cache_path, name)
+	suite.assert.NoError(os.WriteFile(path, make([]byte, size), 0666))
+	return path
+}
+
+func (suite *orderedPolicyTestSuite) TestNewCachePolicy() {
+	for name, expected := range map[string]string{"": "lru", "LRU": "lru", "lfu": "lfu", "arc": "arc", "Size": "size"} {
+		policy, err := newCachePolicy(name, cachePolicyConfig{})
+		suite.assert.NoError(err)
+		suite.assert.Equal(expected, policy.Name())
+	}
+
+	_, err := newCachePolicy("mru", cachePolicyConfig{})
+	suite.assert.Error(err)
+}
+
+func (suite *orderedPolicyTestSuite) TestLFUOrder() {
+	o := newLFUOrder()
+	for _, name := range []string{"a", "b", "b", "c", "c", "c", "a"} {
+		o.access(name)
+	}
+	// Ties go to the file used least recently
+	suite.assert.Equal([]string{"b", "a", "c"}, o.victims(3, nil))
+	suite.assert.Equal([]string{"b"}, o.victims(1, nil))
+
+	// Files used after an eviction start from the priority of the evicted file
+	o.remove("a", true)
+	o.remove("b", true)
+	o.access("d")
+	suite.assert.EqualValues(3, o.entries["d"].key)
+	suite.assert.Equal([]string{"c", "d"}, o.victims(2, nil))
+
+	// Purged files do not age the others
+	o.remove("c", false)
+	suite.assert.EqualValues(2, o.age)
+	suite.assert.Len(o.entries, 1)
+}
+
+func (suite *orderedPolicyTestSuite) TestARCOrder() {
+	o := newARCOrder()
+	for _, name := range []string{"a", "b", "c", "a"} {
+		o.access(name)
+	}
+	suite.assert.Equal(2, o.lists[arcRecent].Len())
+	suite.assert.Equal(1, o.lists[arcFrequent].Len())
+
+	// Files used once go first
+	suite.assert.Equal([]string{"b", "c", "a"}, o.victims(5, nil))
+
+	// Reuse of a file evicted from T1 makes room for more files used once
+	o.remove("b", true)
+	suite.assert.Equal(arcRecentGhost, o.entries["b"].Value.(*arcEntry).list)
+	o.access("b")
+	suite.assert.Equal(1, o.target)
+	suite.assert.Equal(arcFrequent, o.entries["b"].Value.(*arcEntry).list)
+
+	// T1 is within its target so T2 gives files till T1 is the only one left
+	suite.assert.Equal([]string{"a", "b", "c"}, o.victims(5, nil))
+
+	o.access("d")
+	suite.assert.Equal([]string{"c", "a", "b", "d"}, o.victims(5, nil))
+
+	// Purged files leave no ghost
+	o.remove("a", false)
+	_, found := o.entries["a"]
+	suite.assert.False(found)
+}
+
+func (suite *orderedPolicyTestSuite) TestARCGhostsBounded() {
+	o := newARCOrder()
+	for i := 0; i < 100; i++ {
+		name := string(rune('A' + i%50))
+		o.access(name)
+		if i%3 == 0 {
+			o.remove(name, true)
+		}
+		suite.assert.LessOrEqual(o.lists[arcRecentGhost].Len()+o.lists[arcFrequentGhost].Len(), max(o.cached(), 1))
+		suite.assert.Len(o.entries, o.lists[0].Len()+o.lists[1].Len()+o.lists[2].Len()+o.lists[3].Len())
+	}
+}
+
+func (suite *orderedPolicyTestSuite) TestSizeOrder() {
+	sizes := map[string]int64{"small": 10, "large": 1000, "empty": 0}
+	size := func(name string) int64 { return sizes[name] }
+
+	o := newSizeOrder()
+	o.access("large")
+	o.access("small")
+	o.access("empty")
+	suite.assert.Equal([]string{"large", "small", "empty"}, o.victims(3, size))
+
+	// A large file in use is kept over small files which were not used for long
+	for i := 0; i < 200; i++ {
+		o.access("empty")
+	}
+	o.access("large")
+	suite.assert.Equal([]string{"small", "large", "empty"}, o.victims(3, size))
+
+	o.remove("small", true)
+	suite.assert.Equal([]string{"large"}, o.victims(1, size))
+}
+
+func (suite *orderedPolicyTestSuite) TestCacheValid() {
+	suite.setupPolicy("lfu", 0)
+
+	suite.policy.CacheValid("temp")
+	suite.assert.True(suite.policy.IsCached("temp"))
+	suite.assert.Equal([]string{"temp"}, suite.policy.order.victims(1, nil))
+}
+
+func (suite *orderedPolicyTestSuite) TestCacheInvalidate() {
+	suite.setupPolicy("arc", 0)
+	path := suite.createFile("temp", 10)
+
+	suite.policy.CacheValid(path)
+	suite.policy.CacheInvalidate(path) // timeout 0 so the file goes right away
+	suite.assert.False(suite.policy.IsCached(path))
+	suite.assert.Eventually(func() bool {
+		_, err := os.Stat(path)
+		return os.IsNotExist(err)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (suite *orderedPolicyTestSuite) TestCachePurgeInUse() {
+	suite.setupPolicy("size", 120)
+	path := suite.createFile("temp", 10)
+
+	flock := suite.policy.fileLocks.Get("temp")
+	flock.Inc()
+
+	suite.policy.CacheValid(path)
+	suite.policy.CachePurge(path)
+
+	// File is open so it stays and is counted as used
+	suite.assert.Eventually(func() bool {
+		return suite.policy.IsCached(path)
+	}, time.Second, 10*time.Millisecond)
+	_, err := os.Stat(path)
+	suite.assert.NoError(err)
+
+	flock.Dec()
+}
+
+func (suite *orderedPolicyTestSuite) TestEvictExpired() {
+	suite.setupPolicy("lfu", 1)
+	old := suite.createFile("old", 10)
+	recent := suite.createFile("recent", 10)
+
+	suite.policy.CacheValid(old)
+	suite.policy.Lock()
+	suite.policy.lastUsed[old] = time.Now().Add(-2 * time.Second)
+	suite.policy.Unlock()
+	suite.policy.CacheValid(recent)
+
+	suite.policy.evictExpired()
+
+	suite.assert.False(suite.policy.IsCached(old))
+	suite.assert.True(suite.policy.IsCached(recent))
+	_, err := os.Stat(old)
+	suite.assert.True(os.IsNotExist(err))
+	suite.assert.NotContains(suite.policy.order.victims(2, nil), old)
+}
+
+func (suite *orderedPolicyTestSuite) TestEvictOrdered() {
+	suite.setupPolicy("size", 120)
+	small := suite.createFile("small", 1024)
+	large := suite.createFile("large", 1024*1024)
+	suite.policy.CacheValid(large)
+	suite.policy.CacheValid(small)
+
+	// Cache is 2MB with 1MB and 1KB in it, going below 10% needs only the large file to go
+	suite.policy.maxEviction = 1
+	suite.assert.Equal(1, suite.policy.evictOrdered(10, 2))
+
+	suite.assert.False(suite.policy.IsCached(large))
+	suite.assert.True(suite.policy.IsCached(small))
+	_, err := os.Stat(large)
+	suite.assert.True(os.IsNotExist(err))
+}
+
+func (suite *orderedPolicyTestSuite) TestEvictUsedAgain() {
+	suite.setupPolicy("lfu", 120)
+	path := suite.createFile("temp", 10)
+	suite.policy.CacheValid(path)
+
+	// File used again after it was picked for eviction is deleted but policy keeps it till it is not found
+	suite.assert.True(suite.policy.evict(path, time.Now().Add(-time.Second)))
+	suite.assert.True(suite.policy.IsCached(path))
+}
+
+func (suite *orderedPolicyTestSuite) setupClasses() {
+	var err error
+	suite.classes, err = cache_class.New([]cache_class.RuleOptions{
+		{Path: "pinned", Class: "pin"},
+		{Path: "scratch", Class: "evict-first"},
+		{Path: "tmp", Class: "no-cache"},
+	})
+	suite.assert.NoError(err)
+}
+
+func (suite *orderedPolicyTestSuite) TestCacheInvalidateClasses() {
+	suite.setupClasses()
+	suite.setupPolicy("lfu", 120)
+	pinned := suite.createFile("pinned", 10)
+	tmp := suite.createFile("tmp", 10)
+	data := suite.createFile("data", 10)
+
+	suite.policy.CacheValid(pinned)
+	suite.policy.CacheValid(tmp)
+	suite.policy.CacheValid(data)
+
+	suite.policy.CacheInvalidate(pinned)
+	suite.policy.CacheInvalidate(tmp)
+	suite.policy.CacheInvalidate(data)
+
+	suite.assert.True(suite.policy.IsCached(pinned))
+	suite.assert.False(suite.policy.IsCached(tmp))
+	suite.assert.True(suite.policy.IsCached(data))
+}
+
+func (suite *orderedPolicyTestSuite) TestEvictExpiredPinned() {
+	suite.setupClasses()
+	suite.setupPolicy("arc", 1)
+	pinned := suite.createFile("pinned", 10)
+
+	suite.policy.CacheValid(pinned)
+	suite.policy.Lock()
+	suite.policy.lastUsed[pinned] = time.Now().Add(-2 * time.Second)
+	suite.policy.Unlock()
+
+	suite.policy.evictExpired()
+
+	suite.assert.True(suite.policy.IsCached(pinned))
+	_, err := os.Stat(pinned)
+	suite.assert.NoError(err)
+}
+
+func (suite *orderedPolicyTestSuite) TestEvictOrderedClasses() {
+	suite.setupClasses()
+	suite.setupPolicy("size", 120)
+	pinned := suite.createFile("pinned", 1024*1024)
+	scratch := suite.createFile("scratch", 1024)
+	data := suite.createFile("data", 512*1024)
+	suite.policy.CacheValid(pinned)
+	suite.policy.CacheValid(scratch)
+	suite.policy.CacheValid(data)
+
+	// Size order alone would pick the pinned file first, evict-first files go ahead of the larger one
+	suite.policy.maxEviction = 1
+	suite.assert.Equal(1, suite.policy.evictOrdered(0, 2))
+	suite.assert.False(suite.policy.IsCached(scratch))
+	suite.assert.True(suite.policy.IsCached(data))
+
+	// Pinned files are never picked
+	suite.policy.maxEviction = defaultMaxEviction
+	suite.assert.Equal(1, suite.policy.evictOrdered(0, 2))
+	suite.assert.False(suite.policy.IsCached(data))
+	suite.assert.True(suite.policy.IsCached(pinned))
+}
+
+func TestOrderedPolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(orderedPolicyTestSuite))
+}
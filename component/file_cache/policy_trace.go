@@ -0,0 +1,174 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// Calls made to the cache policy as recorded in a policy trace
+const (
+	traceValid      = 'V'
+	traceInvalidate = 'I'
+	tracePurge      = 'P'
+)
+
+// traceRecord : One call to the cache policy, recorded as a line "<unix time ms> <op> <size> <path>" where
+// path is relative to the cache directory and size is the size of the cached file at the time of the call
+type traceRecord struct {
+	time time.Time
+	op   byte
+	size int64
+	name string
+}
+
+// tracePolicy : Records every call made to the cache policy in a trace file, so the workload can be replayed
+// against other policies to compare their hit ratios
+type tracePolicy struct {
+	cachePolicy
+
+	tmpPath string
+
+	sync.Mutex
+	file *os.File
+	out  *bufio.Writer
+}
+
+func newTracePolicy(policy cachePolicy, tmpPath string, path string) (*tracePolicy, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracePolicy{
+		cachePolicy: policy,
+		tmpPath:     tmpPath,
+		file:        file,
+		out:         bufio.NewWriter(file),
+	}, nil
+}
+
+func (p *tracePolicy) ShutdownPolicy() error {
+	err := p.cachePolicy.ShutdownPolicy()
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.file != nil {
+		_ = p.out.Flush()
+		_ = p.file.Close()
+		p.file = nil
+	}
+	return err
+}
+
+func (p *tracePolicy) CacheValid(name string) {
+	p.record(traceValid, name)
+	p.cachePolicy.CacheValid(name)
+}
+
+func (p *tracePolicy) CacheInvalidate(name string) {
+	p.record(traceInvalidate, name)
+	p.cachePolicy.CacheInvalidate(name)
+}
+
+func (p *tracePolicy) CachePurge(name string) {
+	p.record(tracePurge, name)
+	p.cachePolicy.CachePurge(name)
+}
+
+func (p *tracePolicy) record(op byte, name string) {
+	size := fileSize(name)
+	path := strings.TrimPrefix(strings.TrimPrefix(name, p.tmpPath), "/")
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.file == nil {
+		return
+	}
+
+	_, err := fmt.Fprintf(p.out, "%d %c %d %s\n", time.Now().UnixMilli(), op, size, path)
+	if err == nil && op != traceValid {
+		// Purge and invalidate are rare, flush on them so the trace is of use even if the mount is not stopped cleanly
+		err = p.out.Flush()
+	}
+
+	if err != nil {
+		log.Err("tracePolicy::record : failed to write policy trace, stopping trace [%s]", err.Error())
+		_ = p.file.Close()
+		p.file = nil
+	}
+}
+
+// readPolicyTrace : Parse a trace written by tracePolicy
+func readPolicyTrace(r io.Reader) ([]traceRecord, error) {
+	records := make([]traceRecord, 0)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) != 4 || len(fields[1]) != 1 {
+			return nil, fmt.Errorf("invalid trace record at line %d", line)
+		}
+
+		ms, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time at line %d [%s]", line, err.Error())
+		}
+
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size at line %d [%s]", line, err.Error())
+		}
+
+		records = append(records, traceRecord{
+			time: time.UnixMilli(ms),
+			op:   fields[1][0],
+			size: size,
+			name: fields[3],
+		})
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,398 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"container/list"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// Trace replay harness, compares hit ratios of the eviction policies on workloads recorded with policy-trace-file:
+//
+//	go test ./component/file_cache -run '^$' -bench PolicyReplay -policy-traces=/tmp/a.trace,/tmp/b.trace -replay-cache-mb=512
+//
+// Synthetic workloads are replayed when no trace is given.
+var (
+	policyTraces  = flag.String("policy-traces", "", "comma separated policy trace files to replay in BenchmarkPolicyReplay")
+	replayCacheMB = flag.Float64("replay-cache-mb", 0, "cache size to replay traces with, default is a tenth of the data in the trace")
+)
+
+// lruOrder : Least recently used files go first, models the lru policy for replay
+type lruOrder struct {
+	files   *list.List
+	entries map[string]*list.Element
+}
+
+func newLRUOrder() *lruOrder {
+	return &lruOrder{
+		files:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (o *lruOrder) access(name string) {
+	if elem, found := o.entries[name]; found {
+		o.files.MoveToFront(elem)
+		return
+	}
+	o.entries[name] = o.files.PushFront(name)
+}
+
+func (o *lruOrder) remove(name string, _ bool) {
+	if elem, found := o.entries[name]; found {
+		o.files.Remove(elem)
+		delete(o.entries, name)
+	}
+}
+
+func (o *lruOrder) victims(n int, _ func(string) int64) []string {
+	names := make([]string, 0, min(n, o.files.Len()))
+	for elem := o.files.Back(); elem != nil && len(names) < n; elem = elem.Prev() {
+		names = append(names, elem.Value.(string))
+	}
+	return names
+}
+
+var replayOrders = []struct {
+	name  string
+	order func() evictionOrder
+}{
+	{"lru", func() evictionOrder { return newLRUOrder() }},
+	{"lfu", func() evictionOrder { return newLFUOrder() }},
+	{"arc", func() evictionOrder { return newARCOrder() }},
+	{"size", func() evictionOrder { return newSizeOrder() }},
+}
+
+type replayResult struct {
+	uses      uint64 // Calls marking a file as used
+	hits      uint64 // Uses of files still in the cache
+	missBytes int64  // Data which had to be downloaded again
+	evictions uint64
+}
+
+func (r replayResult) hitRatio() float64 {
+	if r.uses == 0 {
+		return 0
+	}
+	return float64(r.hits) / float64(r.uses)
+}
+
+// replayTrace : Replay a trace against a cache of the given size in bytes which evicts in the given order.
+// Like file cache going over its high threshold, files are evicted as soon as the cache goes over its size
+// till usage is back to the low threshold in proportion. Timeouts are not modelled.
+func replayTrace(records []traceRecord, order evictionOrder, capacity int64) replayResult {
+	var result replayResult
+	var used int64
+
+	low := capacity * defaultMinThreshold / defaultMaxThreshold
+
+	cached := make(map[string]int64)
+	size := func(name string) int64 { return cached[name] }
+
+	for _, r := range records {
+		switch r.op {
+		case traceValid:
+			result.uses++
+			if old, found := cached[r.name]; found {
+				result.hits++
+				used -= old
+			} else {
+				result.missBytes += r.size
+			}
+
+			cached[r.name] = r.size
+			used += r.size
+			order.access(r.name)
+
+			if used <= capacity {
+				continue
+			}
+
+			// File in use can not be evicted
+			for _, name := range order.victims(len(cached), size) {
+				if name == r.name {
+					continue
+				}
+
+				used -= cached[name]
+				delete(cached, name)
+				order.remove(name, true)
+				result.evictions++
+
+				if used <= low {
+					break
+				}
+			}
+
+		case tracePurge:
+			used -= cached[r.name]
+			delete(cached, r.name)
+			order.remove(r.name, false)
+		}
+	}
+
+	return result
+}
+
+// Synthetic workloads for the replay harness
+
+// zipfTrace : Files used as per a zipf distribution, a few are used most of the time
+func zipfTrace(r *rand.Rand) []traceRecord {
+	zipf := rand.NewZipf(r, 1.2, 1, 4999)
+	records := make([]traceRecord, 0, 50000)
+	for i := 0; i < cap(records); i++ {
+		id := zipf.Uint64()
+		records = append(records, traceRecord{op: traceValid, size: 4096 + int64(id%64)*4096, name: fmt.Sprintf("zipf/%d", id)})
+	}
+	return records
+}
+
+// scanTrace : Set of files used again and again, with reads through large directories in between
+func scanTrace(r *rand.Rand) []traceRecord {
+	records := make([]traceRecord, 0, 40000)
+	scanned := 0
+	for i := 0; i < cap(records); i++ {
+		if i%5000 < 2000 {
+			records = append(records, traceRecord{op: traceValid, size: 65536, name: fmt.Sprintf("hot/%d", r.Intn(800))})
+		} else {
+			records = append(records, traceRecord{op: traceValid, size: 65536, name: fmt.Sprintf("scan/%d", scanned)})
+			scanned++
+		}
+	}
+	return records
+}
+
+// sizeTrace : Many small files used often along with large files used now and then
+func sizeTrace(r *rand.Rand) []traceRecord {
+	records := make([]traceRecord, 0, 40000)
+	for i := 0; i < cap(records); i++ {
+		if r.Intn(20) == 0 {
+			records = append(records, traceRecord{op: traceValid, size: 64 << 20, name: fmt.Sprintf("large/%d", r.Intn(200))})
+		} else {
+			records = append(records, traceRecord{op: traceValid, size: 256 << 10, name: fmt.Sprintf("small/%d", r.Intn(2000))})
+		}
+	}
+	return records
+}
+
+// traceData : Total size of the distinct files in a trace
+func traceData(records []traceRecord) int64 {
+	sizes := make(map[string]int64)
+	for _, r := range records {
+		sizes[r.name] = max(sizes[r.name], r.size)
+	}
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return total
+}
+
+type replayWorkload struct {
+	name     string
+	records  []traceRecord
+	capacity int64
+}
+
+func replayWorkloads(tb testing.TB) []replayWorkload {
+	workloads := make([]replayWorkload, 0)
+
+	if *policyTraces != "" {
+		for _, path := range strings.Split(*policyTraces, ",") {
+			f, err := os.Open(path)
+			if err != nil {
+				tb.Fatalf("failed to open trace %s [%v]", path, err)
+			}
+			records, err := readPolicyTrace(f)
+			f.Close()
+			if err != nil {
+				tb.Fatalf("failed to read trace %s [%v]", path, err)
+			}
+			workloads = append(workloads, replayWorkload{name: filepath.Base(path), records: records})
+		}
+	} else {
+		r := rand.New(rand.NewSource(1))
+		workloads = append(workloads,
+			replayWorkload{name: "zipf", records: zipfTrace(r)},
+			replayWorkload{name: "scan", records: scanTrace(r)},
+			replayWorkload{name: "size", records: sizeTrace(r)},
+		)
+	}
+
+	for i := range workloads {
+		workloads[i].capacity = int64(*replayCacheMB * common.MbToBytes)
+		if workloads[i].capacity == 0 {
+			workloads[i].capacity = traceData(workloads[i].records) / 10
+		}
+	}
+	return workloads
+}
+
+func BenchmarkPolicyReplay(b *testing.B) {
+	for _, w := range replayWorkloads(b) {
+		for _, o := range replayOrders {
+			b.Run(w.name+"/"+o.name, func(b *testing.B) {
+				var result replayResult
+				for i := 0; i < b.N; i++ {
+					result = replayTrace(w.records, o.order(), w.capacity)
+				}
+				b.ReportMetric(result.hitRatio(), "hit-ratio")
+				b.ReportMetric(float64(result.missBytes)/common.MbToBytes, "miss-MB")
+			})
+		}
+	}
+}
+
+type policyTraceTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func (suite *policyTraceTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+	suite.assert = assert.New(suite.T())
+	os.Mkdir(cache_path, fs.FileMode(0777))
+}
+
+func (suite *policyTraceTestSuite) TearDownTest() {
+	os.RemoveAll(cache_path)
+}
+
+func (suite *policyTraceTestSuite) TestRecordAndRead() {
+	tracePath := filepath.Join(suite.T().TempDir(), "policy.trace")
+	policy, err := newTracePolicy(newOrderedPolicy(cachePolicyConfig{tmpPath: cache_path, fileLocks: &common.LockMap{}}, "lfu", newLFUOrder()), cache_path, tracePath)
+	suite.assert.NoError(err)
+	suite.assert.NoError(policy.StartPolicy())
+
+	path := filepath.Join(cache_path, "dir", "file name")
+	suite.assert.NoError(os.MkdirAll(filepath.Dir(path), 0777))
+	suite.assert.NoError(os.WriteFile(path, make([]byte, 100), 0666))
+
+	policy.CacheValid(path)
+	suite.assert.True(policy.IsCached(path))
+	policy.CacheInvalidate(path)
+	policy.CachePurge(path)
+	suite.assert.NoError(policy.ShutdownPolicy())
+
+	// Calls after shutdown are not recorded
+	policy.CacheValid(path)
+
+	f, err := os.Open(tracePath)
+	suite.assert.NoError(err)
+	defer f.Close()
+
+	records, err := readPolicyTrace(f)
+	suite.assert.NoError(err)
+	suite.assert.Len(records, 3)
+	for i, op := range []byte{traceValid, traceInvalidate, tracePurge} {
+		suite.assert.Equal(op, records[i].op)
+		suite.assert.Equal("dir/file name", records[i].name)
+		suite.assert.EqualValues(100, records[i].size)
+		suite.assert.False(records[i].time.IsZero())
+	}
+}
+
+func (suite *policyTraceTestSuite) TestReadInvalid() {
+	for _, trace := range []string{"1 V 10\n", "x V 10 a\n", "1 V x a\n", "1 VV 10 a\n"} {
+		_, err := readPolicyTrace(strings.NewReader(trace))
+		suite.assert.Error(err, trace)
+	}
+
+	records, err := readPolicyTrace(strings.NewReader(""))
+	suite.assert.NoError(err)
+	suite.assert.Empty(records)
+}
+
+func (suite *policyTraceTestSuite) TestReplay() {
+	records := []traceRecord{
+		{op: traceValid, size: 40, name: "a"},
+		{op: traceValid, size: 40, name: "b"},
+		{op: traceValid, size: 40, name: "a"},
+		{op: traceValid, size: 40, name: "c"}, // b and a go to get within 75 bytes
+		{op: traceValid, size: 40, name: "a"},
+		{op: tracePurge, name: "c"},
+		{op: traceValid, size: 40, name: "b"},
+	}
+
+	result := replayTrace(records, newLRUOrder(), 100)
+	suite.assert.EqualValues(6, result.uses)
+	suite.assert.EqualValues(1, result.hits)
+	suite.assert.EqualValues(2, result.evictions)
+	suite.assert.EqualValues(200, result.missBytes)
+}
+
+// Each policy has to do better than lru on the workload it is meant for
+func (suite *policyTraceTestSuite) TestReplayWorkloads() {
+	r := rand.New(rand.NewSource(1))
+	expected := map[string][]traceRecord{
+		"lfu":  zipfTrace(r),
+		"arc":  scanTrace(r),
+		"size": sizeTrace(r),
+	}
+
+	for name, records := range expected {
+		capacity := traceData(records) / 10
+		lru := replayTrace(records, newLRUOrder(), capacity)
+
+		for _, o := range replayOrders {
+			if o.name != name {
+				continue
+			}
+			result := replayTrace(records, o.order(), capacity)
+			suite.T().Logf("%s : hit ratio %.3f, lru %.3f", name, result.hitRatio(), lru.hitRatio())
+			suite.assert.Greater(result.hitRatio(), lru.hitRatio(), name)
+		}
+	}
+}
+
+func TestPolicyTraceTestSuite(t *testing.T) {
+	suite.Run(t, new(policyTraceTestSuite))
+}
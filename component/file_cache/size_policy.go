@@ -0,0 +1,98 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"sort"
+)
+
+// sizeOrder : Large files which were not used for long are evicted first. A file is scored by its size
+// times the number of uses of other files since it was used last, so a few large cold files make room
+// in place of many small ones.
+type sizeOrder struct {
+	lastSeq map[string]uint64 // Use sequence of the last use of each file
+	seq     uint64
+}
+
+var _ evictionOrder = &sizeOrder{}
+
+func newSizeOrder() *sizeOrder {
+	return &sizeOrder{
+		lastSeq: make(map[string]uint64),
+	}
+}
+
+func NewSizePolicy(cfg cachePolicyConfig) cachePolicy {
+	return newOrderedPolicy(cfg, "size", newSizeOrder())
+}
+
+func (o *sizeOrder) access(name string) {
+	o.seq++
+	o.lastSeq[name] = o.seq
+}
+
+func (o *sizeOrder) remove(name string, _ bool) {
+	delete(o.lastSeq, name)
+}
+
+func (o *sizeOrder) victims(n int, size func(string) int64) []string {
+	type scored struct {
+		name  string
+		score float64
+		seq   uint64
+	}
+
+	files := make([]scored, 0, len(o.lastSeq))
+	for name, seq := range o.lastSeq {
+		// Empty files still take an inode, count them as a byte so they are ordered by idle time
+		files = append(files, scored{
+			name:  name,
+			score: float64(max(size(name), 1)) * float64(o.seq-seq+1),
+			seq:   seq,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].score != files[j].score {
+			return files[i].score > files[j].score
+		}
+		return files[i].seq < files[j].seq
+	})
+
+	names := make([]string, 0, min(n, len(files)))
+	for _, f := range files[:min(n, len(files))] {
+		names = append(names, f.name)
+	}
+	return names
+}
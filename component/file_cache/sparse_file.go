@@ -0,0 +1,440 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
+)
+
+// Key under which the sparse file of an open handle is saved in the handle
+const sparseFileKey = "sparse-file"
+
+// sparseFile : Local copy of a blob which is downloaded block by block as it is read.
+// Each bit of the bitmap tells whether the block has been downloaded, till then the local file has a hole in its place.
+// Data past the size of the blob at the time it was opened (or truncated to) belongs to the local file only.
+// Blocks are read only from the version of the blob downloaded at open, so the local file never mixes data of two versions.
+type sparseFile struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	name      string // Path of the blob in storage
+	localPath string // Path of the file in local cache
+	etag      string // ETag of the blob at the time it was opened, blocks are read only while it is unchanged
+	size      int64  // Size of the blob, blocks after this are never downloaded
+	blockSize int64
+
+	present  []uint64       // Bitmap of blocks downloaded to the local file
+	missing  int64          // Count of blocks not downloaded yet
+	fetching map[int64]bool // Blocks being downloaded right now
+	dropped  bool           // Local file is no longer tracked, e.g. deleted or downloaded again
+	stale    bool           // Blob changed in storage, missing blocks can not be read anymore
+	filling  bool           // Rest of the file is being downloaded in background
+}
+
+func newSparseFile(name string, localPath string, etag string, size int64, blockSize int64) *sparseFile {
+	s := &sparseFile{
+		name:      name,
+		localPath: localPath,
+		etag:      etag,
+		size:      size,
+		blockSize: blockSize,
+		fetching:  make(map[int64]bool),
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+
+	blocks := s.blockCount()
+	s.present = make([]uint64, (blocks+63)/64)
+	s.missing = blocks
+	return s
+}
+
+// blockCount : Number of blocks to be downloaded, lock shall be held by the caller
+func (s *sparseFile) blockCount() int64 {
+	return (s.size + s.blockSize - 1) / s.blockSize
+}
+
+func (s *sparseFile) isPresent(block int64) bool {
+	return s.present[block/64]&(1<<(block%64)) != 0
+}
+
+func (s *sparseFile) setPresent(block int64) {
+	if !s.isPresent(block) {
+		s.present[block/64] |= 1 << (block % 64)
+		s.missing--
+	}
+}
+
+// complete : Whether the whole blob has been downloaded to the local file
+func (s *sparseFile) complete() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.missing == 0
+}
+
+// blockRange : Blocks of the blob overlapping the given range of the file, lock shall be held by the caller
+func (s *sparseFile) blockRange(offset int64, length int64) (int64, int64) {
+	end := s.size
+	if length < end-offset {
+		end = offset + length
+	}
+	if offset >= end {
+		return 0, 0
+	}
+	return offset / s.blockSize, (end + s.blockSize - 1) / s.blockSize
+}
+
+// missingSize : Bytes of the blocks overlapping the given range which are yet to be downloaded
+func (s *sparseFile) missingSize(offset int64, length int64) int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.missing == 0 {
+		return 0
+	}
+
+	size := int64(0)
+	first, last := s.blockRange(offset, length)
+	for block := first; block < last; block++ {
+		if !s.isPresent(block) {
+			size += min(s.blockSize, s.size-block*s.blockSize)
+		}
+	}
+	return size
+}
+
+// ensure : Download the blocks overlapping the given range which are not in the local file yet.
+// A block being downloaded by another reader is waited upon rather than downloaded again.
+func (s *sparseFile) ensure(next internal.Component, offset int64, length int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	first, last := s.blockRange(offset, length)
+	for block := first; block < last; block++ {
+		for s.fetching[block] {
+			s.cond.Wait()
+		}
+
+		if s.dropped {
+			// File was deleted while it was open, its blob is gone as well
+			return syscall.ENOENT
+		}
+
+		if s.stale {
+			return syscall.ESTALE
+		}
+
+		// File may have been truncated while waiting
+		if block >= s.blockCount() || s.isPresent(block) {
+			continue
+		}
+
+		s.fetching[block] = true
+		s.Unlock()
+		data, err := s.download(next, block)
+		s.Lock()
+
+		if err == nil {
+			err = s.write(block, data)
+		} else if err == syscall.ESTALE {
+			s.stale = true
+		}
+
+		delete(s.fetching, block)
+		s.cond.Broadcast()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// download : Read a block of the blob from storage
+func (s *sparseFile) download(next internal.Component, block int64) ([]byte, error) {
+	s.Lock()
+	offset := block * s.blockSize
+	handle := handlemap.NewHandle(s.name)
+	handle.Size = s.size
+	etag := s.etag
+	s.Unlock()
+
+	if offset >= handle.Size {
+		// File got truncated before the block could be downloaded
+		return nil, nil
+	}
+
+	data := make([]byte, min(s.blockSize, handle.Size-offset))
+	n, err := next.ReadInBuffer(internal.ReadInBufferOptions{
+		Handle: handle,
+		Offset: offset,
+		Data:   data,
+		ETag:   etag,
+	})
+	if err == syscall.ESTALE {
+		log.Err("FileCache::sparseFile : %s changed in storage since it was opened, can not download block %d", handle.Path, block)
+		return nil, err
+	} else if err != nil && err != io.EOF {
+		log.Err("FileCache::sparseFile : Failed to download block %d of %s [%s]", block, handle.Path, err.Error())
+		return nil, err
+	}
+
+	if n != len(data) {
+		log.Err("FileCache::sparseFile : Short read of block %d of %s, expected %d got %d", block, handle.Path, len(data), n)
+		return nil, syscall.EIO
+	}
+
+	fileCacheStatsCollector.UpdateStats(stats_manager.Increment, dlBlocks, (int64)(1))
+	return data, nil
+}
+
+// write : Write a downloaded block to the local file, lock shall be held by the caller so that it does not
+// race with a truncate. Data past the current size is dropped as the file got truncated meanwhile.
+func (s *sparseFile) write(block int64, data []byte) error {
+	offset := block * s.blockSize
+	if s.dropped {
+		return syscall.ENOENT
+	}
+
+	if offset >= s.size {
+		return nil
+	}
+	data = data[:min(int64(len(data)), s.size-offset)]
+
+	f, err := os.OpenFile(s.localPath, os.O_WRONLY, 0)
+	if err != nil {
+		log.Err("FileCache::sparseFile : Failed to open %s [%s]", s.localPath, err.Error())
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteAt(data, offset)
+	if err != nil {
+		log.Err("FileCache::sparseFile : Failed to write block %d of %s [%s]", block, s.localPath, err.Error())
+		return err
+	}
+
+	s.setPresent(block)
+	return nil
+}
+
+// truncate : Data after the new size no longer comes from the blob
+func (s *sparseFile) truncate(size int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	if size >= s.size {
+		return
+	}
+
+	s.size = size
+	s.missing = 0
+	for block := int64(0); block < s.blockCount(); block++ {
+		if !s.isPresent(block) {
+			s.missing++
+		}
+	}
+}
+
+// rename : Blob was renamed in storage along with the local file
+func (s *sparseFile) rename(name string, localPath string) {
+	s.Lock()
+	defer s.Unlock()
+	s.name = name
+	s.localPath = localPath
+}
+
+// setETag : Blob was changed by this mount without touching its data, missing blocks now come from the new version
+func (s *sparseFile) setETag(etag string) {
+	s.Lock()
+	defer s.Unlock()
+	s.etag = etag
+}
+
+// drop : Local file is no longer tracked, any background download in progress stops
+func (s *sparseFile) drop() {
+	s.Lock()
+	defer s.Unlock()
+	s.dropped = true
+}
+
+// startFill : Returns true if the caller shall download the rest of the file in background
+func (s *sparseFile) startFill() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.filling || s.dropped || s.missing == 0 {
+		return false
+	}
+	s.filling = true
+	return true
+}
+
+// fill : Download the blocks which are not in the local file yet, one at a time so that reads get in between
+func (s *sparseFile) fill(next internal.Component) error {
+	defer func() {
+		s.Lock()
+		s.filling = false
+		s.Unlock()
+	}()
+
+	for block := int64(0); ; block++ {
+		s.Lock()
+		done := s.dropped || block >= s.blockCount()
+		offset := block * s.blockSize
+		s.Unlock()
+
+		if done {
+			return nil
+		}
+
+		err := s.ensure(next, offset, 1)
+		if err != nil {
+			s.Lock()
+			dropped := s.dropped
+			s.Unlock()
+
+			if dropped {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// sparseFileOf : Sparse file of the given path, nil if the whole blob is in the local file
+func (fc *FileCache) sparseFileOf(name string) *sparseFile {
+	v, found := fc.sparseFiles.Load(name)
+	if !found {
+		return nil
+	}
+
+	sparse := v.(*sparseFile)
+	if sparse.complete() {
+		fc.sparseFiles.CompareAndDelete(name, sparse)
+		return nil
+	}
+	return sparse
+}
+
+// refreshSparse : ETag of the blob changes with a rename, truncate or metadata update of this mount, the sparse file of the
+// blob moves to the new ETag so that its reads do not fail. Caller holds the lock of the file.
+func (fc *FileCache) refreshSparse(name string) {
+	sparse := fc.sparseFileOf(name)
+	if sparse == nil {
+		return
+	}
+
+	attr, err := fc.NextComponent().GetAttr(internal.GetAttrOptions{Name: name})
+	if err != nil {
+		log.Err("FileCache::refreshSparse : Failed to get ETag of %s [%s]", name, err.Error())
+		sparse.Lock()
+		sparse.stale = true
+		sparse.Unlock()
+		fc.staleSparse(sparse)
+		return
+	}
+	sparse.setETag(attr.ETag)
+}
+
+// dropSparse : Local copy of the file is deleted or replaced, blocks downloaded so far are of no use
+func (fc *FileCache) dropSparse(name string) {
+	if v, found := fc.sparseFiles.LoadAndDelete(name); found {
+		v.(*sparseFile).drop()
+	}
+}
+
+// fetchSparse : Download the blocks of an open file overlapping the given range before it is read or written
+func (fc *FileCache) fetchSparse(handle *handlemap.Handle, offset int64, length int64) error {
+	v, found := handle.GetValue(sparseFileKey)
+	if !found {
+		return nil
+	}
+
+	sparse := v.(*sparseFile)
+	size := sparse.missingSize(offset, length)
+	if size == 0 {
+		return nil
+	}
+
+	if fc.diskHighWaterMark != 0 {
+		currSize, err := common.GetUsage(fc.tmpPath)
+		if err != nil {
+			log.Err("FileCache::fetchSparse : error getting current usage of cache [%s]", err.Error())
+		} else if (currSize + float64(size)) > fc.diskHighWaterMark {
+			log.Err("FileCache::fetchSparse : cache size limit reached [%f] failed to download %s", fc.maxCacheSize, handle.Path)
+			return syscall.ENOSPC
+		}
+	}
+
+	err := sparse.ensure(fc.NextComponent(), offset, length)
+	if err == syscall.ESTALE {
+		fc.staleSparse(sparse)
+	}
+	return err
+}
+
+// staleSparse : Blob of the sparse file changed in storage, so the rest of it can not be downloaded anymore.
+// Handles open on the file keep failing reads of missing blocks, next open once they are closed downloads the file again.
+func (fc *FileCache) staleSparse(sparse *sparseFile) {
+	sparse.Lock()
+	name, localPath := sparse.name, sparse.localPath
+	sparse.Unlock()
+
+	fc.staleFiles.Store(name, true)
+	fc.policy.CachePurge(localPath)
+}
+
+// fillSparse : Download the rest of the file in background
+func (fc *FileCache) fillSparse(sparse *sparseFile) {
+	err := sparse.fill(fc.NextComponent())
+	if err == syscall.ESTALE {
+		fc.staleSparse(sparse)
+	}
+	if err != nil {
+		sparse.Lock()
+		name := sparse.name
+		sparse.Unlock()
+		log.Err("FileCache::fillSparse : Failed to download %s in background [%s]", name, err.Error())
+	}
+}
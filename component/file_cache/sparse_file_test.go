@@ -0,0 +1,378 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package file_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/memstore"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const sparseTestBlockSize = 64 * 1024
+
+type sparseFileTestSuite struct {
+	suite.Suite
+	assert    *assert.Assertions
+	store     internal.Component
+	fileCache *FileCache
+	cachePath string
+}
+
+func (suite *sparseFileTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	suite.assert.NoError(err)
+
+	suite.cachePath = filepath.Join(suite.T().TempDir(), "cache")
+	suite.setup("")
+}
+
+func (suite *sparseFileTestSuite) setup(extra string) {
+	cfg := fmt.Sprintf("memstore:\n  latency-ms: 0\nfile_cache:\n  path: %s\n  timeout-sec: 120\n  allow-non-empty-temp: true\n  sparse-download: true\n  sparse-block-size-mb: 0.0625\n%s",
+		suite.cachePath, extra)
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(cfg))
+
+	suite.store = memstore.NewMemStoreComponent()
+	suite.assert.NoError(suite.store.Configure(true))
+
+	suite.fileCache = newTestFileCache(suite.store)
+	suite.assert.NoError(suite.fileCache.Start(context.Background()))
+}
+
+func (suite *sparseFileTestSuite) TearDownTest() {
+	_ = suite.fileCache.Stop()
+}
+
+// writeBlob : Put a blob of random data in storage
+func (suite *sparseFileTestSuite) writeBlob(name string, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(int64(size))).Read(data)
+
+	_, err := suite.store.CreateFile(internal.CreateFileOptions{Name: name, Mode: 0644})
+	suite.assert.NoError(err)
+	_, err = suite.store.WriteFile(internal.WriteFileOptions{Handle: handlemap.NewHandle(name), Data: data})
+	suite.assert.NoError(err)
+	return data
+}
+
+func (suite *sparseFileTestSuite) readBlob(name string) []byte {
+	data, err := suite.store.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle(name)})
+	suite.assert.NoError(err)
+	return data
+}
+
+func (suite *sparseFileTestSuite) open(name string, flags int) (*handlemap.Handle, *sparseFile) {
+	h, err := suite.fileCache.OpenFile(internal.OpenFileOptions{Name: name, Flags: flags, Mode: 0644})
+	suite.assert.NoError(err)
+
+	v, found := h.GetValue(sparseFileKey)
+	if !found {
+		return h, nil
+	}
+	return h, v.(*sparseFile)
+}
+
+func (suite *sparseFileTestSuite) read(h *handlemap.Handle, offset int64, length int) []byte {
+	data := make([]byte, length)
+	n, err := suite.fileCache.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: offset, Data: data})
+	suite.assert.NoError(err)
+	return data[:n]
+}
+
+func (suite *sparseFileTestSuite) close(h *handlemap.Handle) {
+	suite.assert.NoError(suite.fileCache.CloseFile(internal.CloseFileOptions{Handle: h}))
+}
+
+func (suite *sparseFileTestSuite) TestReadDownloadsBlocks() {
+	data := suite.writeBlob("big", 4*sparseTestBlockSize+1000)
+
+	h, sparse := suite.open("big", os.O_RDONLY)
+	defer suite.close(h)
+
+	// Reads come to file cache till the whole file is downloaded
+	suite.assert.NotNil(sparse)
+	suite.assert.False(h.Cached())
+	suite.assert.EqualValues(5, sparse.missing)
+
+	info, err := os.Stat(filepath.Join(suite.cachePath, "big"))
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(len(data), info.Size())
+
+	// Read spanning two blocks downloads only those
+	offset := int64(2*sparseTestBlockSize - 10)
+	suite.assert.Equal(data[offset:offset+100], suite.read(h, offset, 100))
+	suite.assert.EqualValues(3, sparse.missing)
+	suite.assert.True(sparse.isPresent(1))
+	suite.assert.True(sparse.isPresent(2))
+
+	// Last block is shorter than the block size
+	suite.assert.Equal(data[len(data)-500:], suite.read(h, int64(len(data)-500), 1000))
+	suite.assert.EqualValues(2, sparse.missing)
+	suite.assert.Zero(sparse.missingSize(offset, 100))
+	suite.assert.EqualValues(2*sparseTestBlockSize, sparse.missingSize(0, int64(len(data))))
+}
+
+func (suite *sparseFileTestSuite) TestSmallFile() {
+	data := suite.writeBlob("small", sparseTestBlockSize)
+
+	h, sparse := suite.open("small", os.O_RDONLY)
+	defer suite.close(h)
+
+	// Files of one block are downloaded on open
+	suite.assert.Nil(sparse)
+	suite.assert.True(h.Cached())
+	suite.assert.Equal(data, suite.read(h, 0, len(data)))
+}
+
+func (suite *sparseFileTestSuite) TestReopen() {
+	data := suite.writeBlob("big", 3*sparseTestBlockSize)
+
+	h, sparse := suite.open("big", os.O_RDONLY)
+	suite.read(h, 0, 10)
+	suite.close(h)
+
+	// Blocks read earlier are served from the local file
+	h, reopened := suite.open("big", os.O_RDONLY)
+	suite.assert.Same(sparse, reopened)
+	suite.assert.EqualValues(2, reopened.missing)
+	suite.assert.Equal(data[sparseTestBlockSize:sparseTestBlockSize+10], suite.read(h, sparseTestBlockSize, 10))
+	suite.assert.Equal(data, suite.read(h, 0, len(data)))
+	suite.close(h)
+
+	// Once everything is downloaded the file is read like any other cached file
+	h, sparse = suite.open("big", os.O_RDONLY)
+	suite.assert.Nil(sparse)
+	suite.assert.True(h.Cached())
+	suite.close(h)
+}
+
+func (suite *sparseFileTestSuite) TestBlobChanged() {
+	suite.writeBlob("big", 3*sparseTestBlockSize)
+
+	h, sparse := suite.open("big", os.O_RDONLY)
+	suite.read(h, 0, 10)
+
+	// Changes made through this mount do not stop the rest of the blob from being read
+	suite.assert.NoError(suite.fileCache.Chmod(internal.ChmodOptions{Name: "big", Mode: 0600}))
+	suite.read(h, sparseTestBlockSize, 10)
+
+	// Blob is overwritten by someone else between two reads, its blocks can not be mixed with the ones read already
+	data := suite.writeBlob("big", 4*sparseTestBlockSize)
+	_, err := suite.fileCache.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 2 * sparseTestBlockSize, Data: make([]byte, 10)})
+	suite.assert.Equal(syscall.ESTALE, err)
+	suite.assert.EqualValues(1, sparse.missing)
+	suite.close(h)
+
+	// Next open downloads the new version of the blob
+	h, reopened := suite.open("big", os.O_RDONLY)
+	suite.assert.NotSame(sparse, reopened)
+	suite.assert.Equal(data, suite.read(h, 0, len(data)))
+	suite.close(h)
+}
+
+func (suite *sparseFileTestSuite) TestWriteAndFlush() {
+	data := suite.writeBlob("big", 5*sparseTestBlockSize)
+
+	h, sparse := suite.open("big", os.O_RDWR)
+	patch := []byte("hello world")
+	n, err := suite.fileCache.WriteFile(internal.WriteFileOptions{Handle: h, Offset: sparseTestBlockSize + 5, Data: patch})
+	suite.assert.NoError(err)
+	suite.assert.Equal(len(patch), n)
+
+	// Block being written is downloaded first so the rest of it is not lost
+	suite.assert.EqualValues(4, sparse.missing)
+	copy(data[sparseTestBlockSize+5:], patch)
+
+	// Whole file is uploaded on flush
+	suite.close(h)
+	suite.assert.EqualValues(0, sparse.missing)
+	suite.assert.Equal(data, suite.readBlob("big"))
+}
+
+func (suite *sparseFileTestSuite) TestTruncate() {
+	data := suite.writeBlob("big", 4*sparseTestBlockSize)
+
+	h, sparse := suite.open("big", os.O_RDWR)
+	defer suite.close(h)
+
+	suite.assert.NoError(suite.fileCache.TruncateFile(internal.TruncateFileOptions{Name: "big", Size: sparseTestBlockSize + 10}))
+	suite.assert.EqualValues(2, sparse.missing)
+
+	// Data after the truncated size does not come from the blob even if the file grows again
+	suite.assert.NoError(suite.fileCache.TruncateFile(internal.TruncateFileOptions{Name: "big", Size: 3 * sparseTestBlockSize}))
+	expected := make([]byte, 3*sparseTestBlockSize)
+	copy(expected, data[:sparseTestBlockSize+10])
+	suite.assert.Equal(expected, suite.read(h, 0, len(expected)))
+	suite.assert.EqualValues(0, sparse.missing)
+}
+
+func (suite *sparseFileTestSuite) TestOpenTruncate() {
+	suite.writeBlob("big", 3*sparseTestBlockSize)
+
+	h, sparse := suite.open("big", os.O_RDONLY)
+	suite.read(h, 0, 10)
+	suite.close(h)
+
+	h, reopened := suite.open("big", os.O_RDWR|os.O_TRUNC)
+	suite.assert.Nil(reopened)
+	suite.assert.EqualValues(0, sparse.missing)
+	suite.assert.Empty(suite.read(h, 0, 10))
+	suite.close(h)
+}
+
+func (suite *sparseFileTestSuite) TestRename() {
+	data := suite.writeBlob("src", 3*sparseTestBlockSize)
+
+	h, _ := suite.open("src", os.O_RDONLY)
+	suite.read(h, 0, 10)
+	suite.close(h)
+
+	suite.assert.NoError(suite.fileCache.RenameFile(internal.RenameFileOptions{Src: "src", Dst: "dst"}))
+	suite.assert.Nil(suite.fileCache.sparseFileOf("src"))
+
+	// Blocks not read yet come from the renamed blob
+	h, sparse := suite.open("dst", os.O_RDONLY)
+	defer suite.close(h)
+	suite.assert.NotNil(sparse)
+	suite.assert.Equal(data, suite.read(h, 0, len(data)))
+}
+
+func (suite *sparseFileTestSuite) TestDeleteOpenFile() {
+	suite.writeBlob("big", 3*sparseTestBlockSize)
+
+	h, _ := suite.open("big", os.O_RDONLY)
+	defer suite.close(h)
+	suite.read(h, 0, 10)
+
+	suite.assert.NoError(suite.fileCache.DeleteFile(internal.DeleteFileOptions{Name: "big"}))
+	_, err := suite.fileCache.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 2 * sparseTestBlockSize, Data: make([]byte, 10)})
+	suite.assert.Equal(syscall.ENOENT, err)
+}
+
+func (suite *sparseFileTestSuite) TestBackgroundFill() {
+	_ = suite.fileCache.Stop()
+	suite.setup("  sparse-background-fill: true\n")
+	data := suite.writeBlob("big", 8*sparseTestBlockSize)
+
+	h, sparse := suite.open("big", os.O_RDONLY)
+	suite.assert.NotNil(sparse)
+	suite.assert.Eventually(sparse.complete, 5*time.Second, 10*time.Millisecond)
+	suite.close(h)
+
+	local, err := os.ReadFile(filepath.Join(suite.cachePath, "big"))
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, local))
+}
+
+func (suite *sparseFileTestSuite) TestConcurrentReads() {
+	data := suite.writeBlob("big", 16*sparseTestBlockSize)
+
+	// Handles of the same file share the blocks downloaded, a block is downloaded once however many read it
+	done := make(chan []byte, 8)
+	handles := make([]*handlemap.Handle, 8)
+	var sparse *sparseFile
+	for i := range handles {
+		handles[i], sparse = suite.open("big", os.O_RDONLY)
+	}
+
+	for _, h := range handles {
+		go func(h *handlemap.Handle) {
+			buf := make([]byte, len(data))
+			n, _ := suite.fileCache.ReadInBuffer(internal.ReadInBufferOptions{Handle: h, Offset: 0, Data: buf})
+			done <- buf[:n]
+		}(h)
+	}
+
+	for range handles {
+		suite.assert.Equal(data, <-done)
+	}
+	suite.assert.EqualValues(0, sparse.missing)
+
+	for _, h := range handles {
+		suite.close(h)
+	}
+}
+
+func (suite *sparseFileTestSuite) TestCacheStatus() {
+	suite.writeBlob("big", 3*sparseTestBlockSize)
+	status := func() string {
+		value, err := suite.fileCache.GetXattr(internal.GetXattrOptions{Name: "big", Attr: common.CacheStatusXattr})
+		suite.assert.NoError(err)
+		return string(value)
+	}
+
+	suite.assert.Equal(common.CacheStatusNone, status())
+
+	h, _ := suite.open("big", os.O_RDONLY)
+	suite.read(h, 0, 10)
+	suite.assert.Equal(common.CacheStatusPartial, status())
+
+	suite.read(h, 0, 3*sparseTestBlockSize)
+	suite.assert.Equal(common.CacheStatusCached, status())
+	suite.close(h)
+
+	err := suite.fileCache.SetXattr(internal.SetXattrOptions{Name: "big", Attr: common.CacheStatusXattr, Value: []byte("cached")})
+	suite.assert.Equal(syscall.EPERM, err)
+}
+
+func (suite *sparseFileTestSuite) TestInvalidBlockSize() {
+	config.ResetConfig()
+	_ = config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf("file_cache:\n  path: %s\n  allow-non-empty-temp: true\n  sparse-block-size-mb: 0\n", suite.cachePath)))
+
+	err := NewFileCacheComponent().Configure(true)
+	suite.assert.Error(err)
+	suite.assert.Contains(err.Error(), "sparse-block-size-mb")
+}
+
+func TestSparseFileTestSuite(t *testing.T) {
+	suite.Run(t, new(sparseFileTestSuite))
+}
@@ -37,11 +37,16 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/config"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
 	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 )
 
@@ -75,6 +80,17 @@ type Libfuse struct {
 	maxFuseThreads        uint32
 	directIO              bool
 	umask                 uint32
+	permissionCheck       bool
+	leaseLock             bool
+
+	// Locks held on files by processes using this mount
+	flocks     *localLocks
+	posixLocks *localLocks
+
+	// Changes reported by the pipeline, waiting to be notified to the kernel
+	notifyCh   chan invalidation.Event
+	notifyLock sync.Mutex
+	notifyWg   sync.WaitGroup
 }
 
 // To support pagination in readdir calls this structure holds a block of items for a given directory
@@ -86,6 +102,35 @@ type dirChildCache struct {
 	children []*internal.ObjAttr // Slice holding current block of children
 }
 
+// reset : Drop the current block so that children are listed again from the first block
+func (cache *dirChildCache) reset() {
+	cache.sIndex = 0
+	cache.eIndex = 0
+	cache.length = 0
+	cache.token = ""
+	cache.children = nil
+}
+
+// next : Get the block of children following the current one
+func (cache *dirChildCache) next(comp internal.Component, name string) error {
+	attrs, token, err := comp.StreamDir(internal.StreamDirOptions{
+		Name:   name,
+		Offset: cache.eIndex,
+		Token:  cache.token,
+		Count:  common.MaxDirListCount,
+	})
+	if err != nil {
+		return err
+	}
+
+	cache.sIndex = cache.eIndex
+	cache.eIndex += uint64(len(attrs))
+	cache.length = uint64(len(attrs))
+	cache.token = token
+	cache.children = attrs
+	return nil
+}
+
 // Structure defining your config parameters
 type LibfuseOptions struct {
 	mountPath               string
@@ -106,6 +151,8 @@ type LibfuseOptions struct {
 	MaxFuseThreads          uint32 `config:"max-fuse-threads" yaml:"max-fuse-threads,omitempty"`
 	DirectIO                bool   `config:"direct-io" yaml:"direct-io,omitempty"`
 	Umask                   uint32 `config:"umask" yaml:"umask,omitempty"`
+	PermissionCheck         bool   `config:"permission-check" yaml:"permission-check,omitempty"`
+	LeaseLock               bool   `config:"lease-lock" yaml:"lease-lock,omitempty"`
 }
 
 const compName = "libfuse"
@@ -113,6 +160,7 @@ const defaultEntryExpiration = 120
 const defaultAttrExpiration = 120
 const defaultNegativeEntryExpiration = 120
 const defaultMaxFuseThreads = 128
+const notifyQueueSize = 1024
 
 var fuseFS *Libfuse
 
@@ -161,6 +209,8 @@ func (lf *Libfuse) Start(ctx context.Context) error {
 	// This marks the global fuse object so shall be the first statement
 	fuseFS = lf
 
+	lf.startNotifier()
+
 	// This starts the libfuse process and hence shall always be the last statement
 	err := lf.initFuse()
 	if err != nil {
@@ -175,6 +225,7 @@ func (lf *Libfuse) Start(ctx context.Context) error {
 func (lf *Libfuse) Stop() error {
 	log.Trace("Libfuse::Stop : Stopping component %s", lf.Name())
 	_ = lf.destroyFuse()
+	lf.stopNotifier()
 	libfuseStatsCollector.Destroy()
 	return nil
 }
@@ -194,6 +245,8 @@ func (lf *Libfuse) Validate(opt *LibfuseOptions) error {
 	lf.ownerGID = opt.Gid
 	lf.ownerUID = opt.Uid
 	lf.umask = opt.Umask
+	lf.permissionCheck = opt.PermissionCheck
+	lf.leaseLock = opt.LeaseLock
 
 	if opt.allowOther {
 		lf.dirPermission = uint(common.DefaultAllowOtherPermissionBits)
@@ -277,6 +330,50 @@ func (lf *Libfuse) GenConfig() string {
 	return sb.String()
 }
 
+// startNotifier : Pass the changes found by the pipeline on to the kernel, so it drops the attributes, data
+// and directory entries it has cached for them instead of waiting for them to expire.
+// Kernel is notified from a goroutine of its own, as notifying it while serving one of its requests may deadlock.
+func (lf *Libfuse) startNotifier() {
+	lf.notifyLock.Lock()
+	defer lf.notifyLock.Unlock()
+
+	lf.notifyCh = make(chan invalidation.Event, notifyQueueSize)
+	lf.notifyWg.Add(1)
+	go func(ch chan invalidation.Event) {
+		defer lf.notifyWg.Done()
+		for event := range ch {
+			notifyKernel(event)
+		}
+	}(lf.notifyCh)
+
+	invalidation.SetNotifier(lf.queueNotify)
+}
+
+// queueNotify : Queue a change for the kernel, if the queue is full the kernel sees it once its timeouts expire
+func (lf *Libfuse) queueNotify(event invalidation.Event) {
+	select {
+	case lf.notifyCh <- event:
+	default:
+		log.Warn("Libfuse::queueNotify : Notification queue full, dropping change of %s", event.Path)
+	}
+}
+
+// stopNotifier : Stop notifying the kernel, called before the fuse instance goes away
+func (lf *Libfuse) stopNotifier() {
+	lf.notifyLock.Lock()
+	defer lf.notifyLock.Unlock()
+
+	if lf.notifyCh == nil {
+		return
+	}
+
+	// No change is queued once the notifier is removed
+	invalidation.SetNotifier(nil)
+	close(lf.notifyCh)
+	lf.notifyWg.Wait()
+	lf.notifyCh = nil
+}
+
 // Configure : Pipeline will call this method after constructor so that you can read config and initialize yourself
 //
 //	Return failure if any config is not valid to exit the process
@@ -332,18 +429,305 @@ func (lf *Libfuse) Configure(_ bool) error {
 		return fmt.Errorf("%s config error %s", lf.Name(), err.Error())
 	}
 
-	log.Crit("Libfuse::Configure : read-only %t, allow-other %t, allow-root %t, default-perm %d, entry-timeout %d, attr-time %d, negative-timeout %d, ignore-open-flags %t, nonempty %t, direct_io %t, max-fuse-threads %d, fuse-trace %t, extension %s, disable-writeback-cache %t, dirPermission %v, mountPath %v, umask %v",
-		lf.readOnly, lf.allowOther, lf.allowRoot, lf.filePermission, lf.entryExpiration, lf.attributeExpiration, lf.negativeTimeout, lf.ignoreOpenFlags, lf.nonEmptyMount, lf.directIO, lf.maxFuseThreads, lf.traceEnable, lf.extensionPath, lf.disableWritebackCache, lf.dirPermission, lf.mountPath, lf.umask)
+	if lf.leaseLock && !cacheLeaseLock() {
+		log.Err("Libfuse::Configure : config error [lease-lock requires lease-lock of file_cache or block_cache]")
+		return fmt.Errorf("config error in %s [lease-lock requires lease-lock of file_cache or block_cache]", lf.Name())
+	}
+
+	log.Crit("Libfuse::Configure : read-only %t, allow-other %t, allow-root %t, default-perm %d, entry-timeout %d, attr-time %d, negative-timeout %d, ignore-open-flags %t, nonempty %t, direct_io %t, max-fuse-threads %d, fuse-trace %t, extension %s, disable-writeback-cache %t, dirPermission %v, mountPath %v, umask %v, permission-check %t, lease-lock %t",
+		lf.readOnly, lf.allowOther, lf.allowRoot, lf.filePermission, lf.entryExpiration, lf.attributeExpiration, lf.negativeTimeout, lf.ignoreOpenFlags, lf.nonEmptyMount, lf.directIO, lf.maxFuseThreads, lf.traceEnable, lf.extensionPath, lf.disableWritebackCache, lf.dirPermission, lf.mountPath, lf.umask, lf.permissionCheck, lf.leaseLock)
 
 	return nil
 }
 
+// cacheLeaseLock : Leases for locks are held by the cache component of the pipeline, which refuses them unless its own
+// lease-lock is enabled
+func cacheLeaseLock() bool {
+	var components []string
+	_ = config.UnmarshalKey("components", &components)
+
+	for _, name := range components {
+		if name != "file_cache" && name != "block_cache" {
+			continue
+		}
+
+		enabled := false
+		_ = config.UnmarshalKey(name+".lease-lock", &enabled)
+		if enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// getOwner : Get the owner and group of a path as reported to the kernel
+func (lf *Libfuse) getOwner(name string) (uint32, uint32, error) {
+	attr, err := lf.NextComponent().GetAttr(internal.GetAttrOptions{Name: name})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if attr.IsOwnerSet() {
+		return attr.Uid, attr.Gid, nil
+	}
+	return lf.ownerUID, lf.ownerGID, nil
+}
+
+// lockFile : Lock the file for the handle by leasing it in storage, which keeps other mounts from writing to it.
+// If wait is set keep retrying till the lease held by someone else is released or expires.
+func (lf *Libfuse) lockFile(handle *handlemap.Handle, wait bool) error {
+	if handle.Locked() {
+		return nil
+	}
+
+	for {
+		err := lf.NextComponent().AcquireLease(internal.AcquireLeaseOptions{Name: handle.Path, Duration: internal.LeaseDuration})
+		if err == nil {
+			handle.Flags.Set(handlemap.HandleFlagLocked)
+			return nil
+		}
+
+		if err != syscall.EBUSY || !wait {
+			return err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// lockedElsewhere : Check whether another mount holds the lease on the file of the handle. The lease is taken and
+// released right away to find out, a lease this mount already holds only gains and loses one holder in the process.
+func (lf *Libfuse) lockedElsewhere(handle *handlemap.Handle) (bool, error) {
+	if handle.Locked() {
+		return false, nil
+	}
+
+	err := lf.NextComponent().AcquireLease(internal.AcquireLeaseOptions{Name: handle.Path, Duration: internal.LeaseDuration})
+	if err == syscall.EBUSY {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return false, lf.NextComponent().ReleaseLease(internal.ReleaseLeaseOptions{Name: handle.Path})
+}
+
+// waitUnlockedElsewhere : Check that no other mount holds the lease on the file of the handle. If wait is set keep
+// checking till the lease held by someone else is released or expires.
+func (lf *Libfuse) waitUnlockedElsewhere(handle *handlemap.Handle, wait bool) error {
+	for {
+		locked, err := lf.lockedElsewhere(handle)
+		if err != nil || !locked {
+			return err
+		}
+
+		if !wait {
+			return syscall.EBUSY
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// unlockFile : Release the lease taken by lockFile for the handle
+func (lf *Libfuse) unlockFile(handle *handlemap.Handle) error {
+	if !handle.Locked() {
+		return nil
+	}
+
+	handle.Flags.Clear(handlemap.HandleFlagLocked)
+	return lf.NextComponent().ReleaseLease(internal.ReleaseLeaseOptions{Name: handle.Path})
+}
+
+// releaseLease : Release the lease of the handle once no exclusive lock is held through it
+func (lf *Libfuse) releaseLease(handle *handlemap.Handle) error {
+	if lf.flocks.exclusive(handle.Path, handle.ID) || lf.posixLocks.exclusive(handle.Path, handle.ID) {
+		return nil
+	}
+	return lf.unlockFile(handle)
+}
+
+// setLock : Take a shared or exclusive lock on the file of the handle for the owner. Locks of other owners in this
+// mount are checked first, as the kernel leaves them to the file system. An exclusive lock is then held across mounts
+// by leasing the file, while a shared lock only has to wait till no other mount holds the lease.
+func (lf *Libfuse) setLock(locks *localLocks, handle *handlemap.Handle, owner uint64, exclusive bool, wait bool, pid uint32) error {
+	before, found := locks.held(handle.Path, owner)
+
+	err := locks.lock(handle.Path, owner, localLock{exclusive: exclusive, pid: pid, handle: handle.ID}, wait)
+	if err != nil {
+		return err
+	}
+
+	if exclusive {
+		err = lf.lockFile(handle, wait)
+	} else if handle.Locked() {
+		// Exclusive lock held through the handle may have been converted to a shared one
+		err = lf.releaseLease(handle)
+	} else {
+		err = lf.waitUnlockedElsewhere(handle, wait)
+	}
+
+	if err != nil {
+		// Owner keeps the lock it held before
+		if found {
+			_ = locks.lock(handle.Path, owner, before, false)
+		} else {
+			locks.unlock(handle.Path, owner)
+		}
+	}
+	return err
+}
+
+// clearLock : Drop the lock of the owner on the file of the handle, and the lease if nothing else needs it
+func (lf *Libfuse) clearLock(locks *localLocks, handle *handlemap.Handle, owner uint64) error {
+	locks.unlock(handle.Path, owner)
+	return lf.releaseLease(handle)
+}
+
+// testLock : Find a lock that keeps the owner from taking the lock asked for on the file of the handle, nil if there
+// is none. Locks of other owners in this mount are checked first, then the lease of other mounts.
+func (lf *Libfuse) testLock(locks *localLocks, handle *handlemap.Handle, owner uint64, exclusive bool) (*localLock, error) {
+	if holder, found := locks.conflict(handle.Path, owner, exclusive); found {
+		return &holder, nil
+	}
+
+	locked, err := lf.lockedElsewhere(handle)
+	if err != nil || !locked {
+		return nil, err
+	}
+
+	// Lease covers the whole file and its holder in the other mount is not known
+	return &localLock{exclusive: true}, nil
+}
+
+// releaseLocks : Drop the locks taken through the handle as it is closed, along with its lease
+func (lf *Libfuse) releaseLocks(handle *handlemap.Handle) error {
+	lf.flocks.release(handle.Path, handle.ID)
+	lf.posixLocks.release(handle.Path, handle.ID)
+	return lf.unlockFile(handle)
+}
+
+// localLock : Lock held on a file by an owner in this mount
+type localLock struct {
+	exclusive bool
+	pid       uint32             // Process which took the lock, reported to F_GETLK
+	handle    handlemap.HandleID // Handle the lock was taken through
+}
+
+// localLocks : Locks held on files by owners in this mount. A lease tells this mount apart from others only, so
+// locks of owners in this mount are arbitrated here. Owner of a flock is the handle and owner of a POSIX lock is
+// the lock owner given by the kernel. Like the lease, a lock covers the whole file whatever range it is taken on.
+type localLocks struct {
+	sync.Mutex
+	released *sync.Cond
+	files    map[string]map[uint64]localLock
+}
+
+func newLocalLocks() *localLocks {
+	locks := &localLocks{files: make(map[string]map[uint64]localLock)}
+	locks.released = sync.NewCond(&locks.Mutex)
+	return locks
+}
+
+// lock : Take or convert the lock of the owner on the file. If wait is set block till conflicting locks of other
+// owners are released, otherwise fail with EWOULDBLOCK. Like flock, a lock being converted is released before
+// waiting, so that owners converting their shared locks at the same time do not wait on each other.
+func (l *localLocks) lock(name string, owner uint64, lock localLock, wait bool) error {
+	l.Lock()
+	defer l.Unlock()
+
+	for {
+		if _, found := l.conflictLocked(name, owner, lock.exclusive); !found {
+			break
+		}
+
+		if !wait {
+			return syscall.EWOULDBLOCK
+		}
+		l.dropLocked(name, owner)
+		l.released.Wait()
+	}
+
+	if l.files[name] == nil {
+		l.files[name] = make(map[uint64]localLock)
+	}
+	l.files[name][owner] = lock
+	return nil
+}
+
+// unlock : Drop the lock of the owner on the file
+func (l *localLocks) unlock(name string, owner uint64) {
+	l.Lock()
+	defer l.Unlock()
+	l.dropLocked(name, owner)
+}
+
+// release : Drop the locks taken through the handle on the file
+func (l *localLocks) release(name string, handle handlemap.HandleID) {
+	l.Lock()
+	defer l.Unlock()
+
+	for owner, lock := range l.files[name] {
+		if lock.handle == handle {
+			l.dropLocked(name, owner)
+		}
+	}
+}
+
+// held : Lock the owner holds on the file
+func (l *localLocks) held(name string, owner uint64) (localLock, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	lock, found := l.files[name][owner]
+	return lock, found
+}
+
+// exclusive : Whether an exclusive lock is held on the file through the handle
+func (l *localLocks) exclusive(name string, handle handlemap.HandleID) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, lock := range l.files[name] {
+		if lock.handle == handle && lock.exclusive {
+			return true
+		}
+	}
+	return false
+}
+
+// conflict : Lock of another owner that keeps the owner from taking the lock asked for on the file
+func (l *localLocks) conflict(name string, owner uint64, exclusive bool) (localLock, bool) {
+	l.Lock()
+	defer l.Unlock()
+	return l.conflictLocked(name, owner, exclusive)
+}
+
+func (l *localLocks) conflictLocked(name string, owner uint64, exclusive bool) (localLock, bool) {
+	for other, lock := range l.files[name] {
+		if other != owner && (exclusive || lock.exclusive) {
+			return lock, true
+		}
+	}
+	return localLock{}, false
+}
+
+func (l *localLocks) dropLocked(name string, owner uint64) {
+	if _, found := l.files[name][owner]; !found {
+		return
+	}
+
+	delete(l.files[name], owner)
+	if len(l.files[name]) == 0 {
+		delete(l.files, name)
+	}
+	l.released.Broadcast()
+}
+
 // ------------------------- Factory -------------------------------------------
 
 // Pipeline will call this method to create your object, initialize your variables here
 // << DO NOT DELETE ANY AUTO GENERATED CODE HERE >>
 func NewLibfuseComponent() internal.Component {
-	comp := &Libfuse{}
+	comp := &Libfuse{flocks: newLocalLocks(), posixLocks: newLocalLocks()}
 	comp.SetName(compName)
 	return comp
 }
@@ -50,13 +50,16 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
 	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 )
 
@@ -92,6 +95,72 @@ func trimFusePath(path *C.char) string {
 	return str
 }
 
+// callerOwner returns uid and gid of the process making the current request.
+// Tests replace it as they do not call the handlers from within a fuse request.
+var callerOwner = func() (uint32, uint32, bool) {
+	var uid C.uid_t
+	var gid C.gid_t
+	if C.get_caller_uid_gid(&uid, &gid) != 0 {
+		return 0, 0, false
+	}
+	return uint32(uid), uint32(gid), true
+}
+
+// timespecToTime converts time received in utimens call, a zero time means it shall be left unchanged
+func timespecToTime(ts C.timespec_t) time.Time {
+	switch ts.tv_nsec {
+	case C.UTIME_NOW:
+		return time.Now()
+	case C.UTIME_OMIT:
+		return time.Time{}
+	default:
+		return time.Unix(int64(ts.tv_sec), int64(ts.tv_nsec))
+	}
+}
+
+// xattrErrorCode converts error of an extended attribute operation to the errno returned to libfuse
+func xattrErrorCode(err error) C.int {
+	switch err {
+	case syscall.ENODATA:
+		return -C.ENODATA
+	case syscall.EEXIST:
+		return -C.EEXIST
+	case syscall.ENOTSUP:
+		return -C.ENOTSUP
+	case syscall.ERANGE:
+		return -C.ERANGE
+	case syscall.EINVAL:
+		return -C.EINVAL
+	case syscall.EAGAIN:
+		return -C.EAGAIN
+	}
+
+	if os.IsNotExist(err) {
+		return -C.ENOENT
+	} else if os.IsPermission(err) {
+		return -C.EACCES
+	}
+	return -C.EIO
+}
+
+// lockErrorCode converts error of a lock operation to the errno returned to libfuse
+func lockErrorCode(err error) C.int {
+	switch err {
+	case syscall.EBUSY, syscall.EWOULDBLOCK:
+		// File is locked by another mount, or by another process in this mount
+		return -C.EAGAIN
+	case syscall.ENOTSUP:
+		return -C.ENOLCK
+	}
+
+	if os.IsNotExist(err) {
+		return -C.ENOENT
+	} else if os.IsPermission(err) {
+		return -C.EACCES
+	}
+	return -C.EIO
+}
+
 var fuse_opts C.fuse_options_t // nolint
 
 // convertConfig converts the config options from Go to C
@@ -112,6 +181,7 @@ func (lf *Libfuse) convertConfig() *C.fuse_options_t {
 	fuse_opts.trace_enable = C.bool(lf.traceEnable)
 	fuse_opts.non_empty = C.bool(lf.nonEmptyMount)
 	fuse_opts.umask = C.int(lf.umask)
+	fuse_opts.default_permissions = C.bool(lf.permissionCheck)
 	return fuse_opts
 }
 
@@ -149,6 +219,9 @@ func (lf *Libfuse) initFuse() error {
 		// Get our callback table
 		my_operations := C.fuse_operations_t{}
 		C.populate_callbacks(&my_operations)
+		if lf.leaseLock {
+			C.populate_lock_callbacks(&my_operations)
+		}
 
 		// Send our callback table to the extension
 		errc = C.register_callback_to_extension(&my_operations)
@@ -162,6 +235,10 @@ func (lf *Libfuse) initFuse() error {
 		// Populate our methods to be registered to libfuse
 		log.Trace("Libfuse::initFuse : Registering fuse callbacks")
 		C.populate_callbacks(&operations)
+		if lf.leaseLock {
+			log.Trace("Libfuse::initFuse : Registering lock callbacks")
+			C.populate_lock_callbacks(&operations)
+		}
 	}
 
 	log.Trace("Libfuse::initFuse : Populating fuse arguments")
@@ -209,6 +286,10 @@ func populateFuseArgs(opts *C.fuse_options_t, args *C.fuse_args_t) (*C.fuse_opti
 		options += ",allow_root"
 	}
 
+	if opts.default_permissions {
+		options += ",default_permissions"
+	}
+
 	if opts.non_empty {
 		options += ",nonempty"
 	}
@@ -302,9 +383,19 @@ func libfuse_destroy(data unsafe.Pointer) {
 	log.Trace("Libfuse::libfuse2_destroy : destroy")
 }
 
+// notifyKernel : High level api of fuse2 can not reach the kernel cache, the kernel sees the change once its timeouts expire
+func notifyKernel(_ invalidation.Event) {
+}
+
 func (lf *Libfuse) fillStat(attr *internal.ObjAttr, stbuf *C.stat_t) {
-	(*stbuf).st_uid = C.uint(lf.ownerUID)
-	(*stbuf).st_gid = C.uint(lf.ownerGID)
+	// Backing storage implementation has support for owner.
+	if attr.IsOwnerSet() {
+		(*stbuf).st_uid = C.uint(attr.Uid)
+		(*stbuf).st_gid = C.uint(attr.Gid)
+	} else {
+		(*stbuf).st_uid = C.uint(lf.ownerUID)
+		(*stbuf).st_gid = C.uint(lf.ownerGID)
+	}
 	(*stbuf).st_nlink = 1
 	(*stbuf).st_size = C.long(attr.Size)
 
@@ -432,6 +523,8 @@ func libfuse_mkdir(path *C.char, mode C.mode_t) C.int {
 			return -C.EACCES
 		} else if os.IsExist(err) {
 			return -C.EEXIST
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -500,36 +593,34 @@ func libfuse2_readdir(_ *C.char, buf unsafe.Pointer, filler C.fuse_fill_dir_t, o
 
 	off_64 := uint64(off)
 	cacheInfo := val.(*dirChildCache)
-	if off_64 == 0 ||
-		(off_64 >= cacheInfo.eIndex && cacheInfo.token != "") {
-		attrs, token, err := fuseFS.NextComponent().StreamDir(internal.StreamDirOptions{
-			Name:   handle.Path,
-			Offset: off_64,
-			Token:  cacheInfo.token,
-			Count:  common.MaxDirListCount,
-		})
 
-		if err != nil {
-			log.Err("Libfuse::libfuse2_readdir : Path %s, handle: %d, offset %d. Error in retrieval %s", handle.Path, handle.ID, off_64, err.Error())
-			if os.IsNotExist(err) {
-				return C.int(C_ENOENT)
-			} else if os.IsPermission(err) {
-				return C.int(C_EACCES)
-			} else {
-				return C.int(C_EIO)
-			}
+	// Offset before the current block comes on rewinddir or seekdir, children are then listed again from the first block.
+	// Blocks are served from memory if entry-cache is in the pipeline.
+	var err error
+	if off_64 == 0 || off_64 < cacheInfo.sIndex {
+		cacheInfo.reset()
+		err = cacheInfo.next(fuseFS.NextComponent(), handle.Path)
+		if err == nil {
+			cacheInfo.children = append([]*internal.ObjAttr{{Flags: fuseFS.lsFlags, Name: "."}, {Flags: fuseFS.lsFlags, Name: ".."}}, cacheInfo.children...)
+			cacheInfo.eIndex += 2
+			cacheInfo.length += 2
 		}
+	}
 
-		if off_64 == 0 {
-			attrs = append([]*internal.ObjAttr{{Flags: fuseFS.lsFlags, Name: "."}, {Flags: fuseFS.lsFlags, Name: ".."}}, attrs...)
-		}
+	// Blocks may come back empty with more to follow, keep listing till the offset is reached or there is nothing left
+	for err == nil && off_64 >= cacheInfo.eIndex && cacheInfo.token != "" {
+		err = cacheInfo.next(fuseFS.NextComponent(), handle.Path)
+	}
 
-		cacheInfo.sIndex = off_64
-		cacheInfo.eIndex = off_64 + uint64(len(attrs))
-		cacheInfo.length = uint64(len(attrs))
-		cacheInfo.token = token
-		cacheInfo.children = cacheInfo.children[:0]
-		cacheInfo.children = attrs
+	if err != nil {
+		log.Err("Libfuse::libfuse2_readdir : Path %s, handle: %d, offset %d. Error in retrieval %s", handle.Path, handle.ID, off_64, err.Error())
+		if os.IsNotExist(err) {
+			return C.int(C_ENOENT)
+		} else if os.IsPermission(err) {
+			return C.int(C_EACCES)
+		} else {
+			return C.int(C_EIO)
+		}
 	}
 
 	if off_64 >= cacheInfo.eIndex {
@@ -584,6 +675,8 @@ func libfuse_rmdir(path *C.char) C.int {
 		log.Err("Libfuse::libfuse2_rmdir : Failed to delete %s [%s]", name, err.Error())
 		if os.IsNotExist(err) {
 			return -C.ENOENT
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -605,13 +698,22 @@ func libfuse_create(path *C.char, mode C.mode_t, fi *C.fuse_file_info_t) C.int {
 	name = common.NormalizeObjectName(name)
 	log.Trace("Libfuse::libfuse2_create : %s", name)
 
-	handle, err := fuseFS.NextComponent().CreateFile(internal.CreateFileOptions{Name: name, Mode: fs.FileMode(uint32(mode) & 0xffffffff)})
+	options := internal.CreateFileOptions{Name: name, Mode: fs.FileMode(uint32(mode) & 0xffffffff)}
+	// Caller becomes the owner of the new file
+	options.Uid, options.Gid, options.OwnerSet = callerOwner()
+
+	handle, err := fuseFS.NextComponent().CreateFile(options)
 	if err != nil {
 		log.Err("Libfuse::libfuse2_create : Failed to create %s [%s]", name, err.Error())
 		if os.IsExist(err) {
 			return -C.EEXIST
 		} else if os.IsPermission(err) {
 			return -C.EACCES
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -665,6 +767,17 @@ func libfuse_open(path *C.char, fi *C.fuse_file_info_t) C.int {
 			return -C.ENOENT
 		} else if os.IsPermission(err) {
 			return -C.EACCES
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
+		} else if err == syscall.EAGAIN {
+			// Blob is archived and its rehydration is pending
+			return -C.EAGAIN
+		} else if err == syscall.ENODATA {
+			// Blob is archived
+			return -C.ENODATA
 		} else {
 			return -C.EIO
 		}
@@ -714,6 +827,11 @@ func libfuse_read(path *C.char, buf *C.char, size C.size_t, off C.off_t, fi *C.f
 	}
 	if err != nil {
 		log.Err("Libfuse::libfuse2_read : error reading file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+		if err == syscall.EAGAIN {
+			return -C.EAGAIN
+		} else if err == syscall.ENODATA {
+			return -C.ENODATA
+		}
 		return -C.EIO
 	}
 
@@ -770,6 +888,14 @@ func libfuse_flush(path *C.char, fi *C.fuse_file_info_t) C.int {
 			return -C.ENOENT
 		} else if err == syscall.EACCES {
 			return -C.EACCES
+		} else if err == syscall.ESTALE {
+			// File was modified by someone else since it was opened
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -792,6 +918,8 @@ func libfuse2_truncate(path *C.char, off C.off_t) C.int {
 		log.Err("Libfuse::libfuse2_truncate : error truncating file %s [%s]", name, err.Error())
 		if os.IsNotExist(err) {
 			return -C.ENOENT
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		}
 		return -C.EIO
 	}
@@ -815,13 +943,25 @@ func libfuse_release(path *C.char, fi *C.fuse_file_info_t) C.int {
 		handle.Flags.Set(handlemap.HandleFlagDirty)
 	}
 
-	err := fuseFS.NextComponent().CloseFile(internal.CloseFileOptions{Handle: handle})
+	// Locks taken through this handle go away with it
+	err := fuseFS.releaseLocks(handle)
+	if err != nil {
+		log.Err("Libfuse::libfuse2_release : error unlocking file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+	}
+
+	err = fuseFS.NextComponent().CloseFile(internal.CloseFileOptions{Handle: handle})
 	if err != nil {
 		log.Err("Libfuse::libfuse2_release : error closing file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
 		if err == syscall.ENOENT {
 			return -C.ENOENT
 		} else if err == syscall.EACCES {
 			return -C.EACCES
+		} else if err == syscall.ESTALE {
+			// File was modified by someone else since it was opened
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
 		} else {
 			return -C.EIO
 		}
@@ -851,6 +991,12 @@ func libfuse_unlink(path *C.char) C.int {
 			return -C.ENOENT
 		} else if os.IsPermission(err) {
 			return -C.EACCES
+		} else if err == syscall.ESTALE {
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		}
 		return -C.EIO
 	}
@@ -913,6 +1059,9 @@ func libfuse2_rename(src *C.char, dst *C.char) C.int {
 		err := fuseFS.NextComponent().RenameDir(internal.RenameDirOptions{Src: srcPath, Dst: dstPath})
 		if err != nil {
 			log.Err("Libfuse::libfuse2_rename : error renaming directory %s -> %s [%s]", srcPath, dstPath, err.Error())
+			if err == syscall.EROFS {
+				return -C.EROFS
+			}
 			return -C.EIO
 		}
 
@@ -923,6 +1072,13 @@ func libfuse2_rename(src *C.char, dst *C.char) C.int {
 		err := fuseFS.NextComponent().RenameFile(internal.RenameFileOptions{Src: srcPath, Dst: dstPath})
 		if err != nil {
 			log.Err("Libfuse::libfuse2_rename : error renaming file %s -> %s [%s]", srcPath, dstPath, err.Error())
+			if err == syscall.ESTALE {
+				return -C.ESTALE
+			} else if err == syscall.EBUSY {
+				return -C.EBUSY
+			} else if err == syscall.EROFS {
+				return -C.EROFS
+			}
 			return -C.EIO
 		}
 
@@ -1009,6 +1165,11 @@ func libfuse_fsync(path *C.char, datasync C.int, fi *C.fuse_file_info_t) C.int {
 	err := fuseFS.NextComponent().SyncFile(options)
 	if err != nil {
 		log.Err("Libfuse::libfuse2_fsync : error syncing file %s [%s]", handle.Path, err.Error())
+		if err == syscall.ESTALE {
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			return -C.EBUSY
+		}
 		return -C.EIO
 	}
 
@@ -1078,7 +1239,48 @@ func libfuse2_chown(path *C.char, uid C.uid_t, gid C.gid_t) C.int {
 	name := trimFusePath(path)
 	name = common.NormalizeObjectName(name)
 	log.Trace("Libfuse::libfuse2_chown : %s", name)
-	// TODO: Implement
+
+	owner, group := int(uid), int(gid)
+	if uid == ^C.uid_t(0) || gid == ^C.gid_t(0) {
+		// -1 means the id shall be left unchanged, so pick the current one
+		curUID, curGID, err := fuseFS.getOwner(name)
+		if err != nil {
+			log.Err("Libfuse::libfuse2_chown : error getting owner of %s [%s]", name, err.Error())
+			if os.IsNotExist(err) {
+				return -C.ENOENT
+			} else if os.IsPermission(err) {
+				return -C.EACCES
+			}
+			return -C.EIO
+		}
+
+		if uid == ^C.uid_t(0) {
+			owner = int(curUID)
+		}
+		if gid == ^C.gid_t(0) {
+			group = int(curGID)
+		}
+	}
+
+	err := fuseFS.NextComponent().Chown(
+		internal.ChownOptions{
+			Name:  name,
+			Owner: owner,
+			Group: group,
+		})
+	if err != nil {
+		log.Err("Libfuse::libfuse2_chown : error in chown of %s [%s]", name, err.Error())
+		if os.IsNotExist(err) {
+			return -C.ENOENT
+		} else if os.IsPermission(err) {
+			return -C.EACCES
+		}
+		return -C.EIO
+	}
+
+	libfuseStatsCollector.PushEvents(chown, name, map[string]interface{}{uidField: owner, gidField: group})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, chown, (int64)(1))
+
 	return 0
 }
 
@@ -1089,9 +1291,241 @@ func libfuse2_utimens(path *C.char, tv *C.timespec_t) C.int {
 	name := trimFusePath(path)
 	name = common.NormalizeObjectName(name)
 	log.Trace("Libfuse::libfuse2_utimens : %s", name)
-	// TODO: is the conversion from [2]timespec to *timespec ok?
-	// TODO: Implement
-	// For now this returns 0 to allow touch to work correctly
+
+	options := internal.SetAttrOptions{Name: name, Attr: &internal.ObjAttr{}}
+	if tv == nil {
+		// Both times shall be set to current time
+		options.Attr.Atime = time.Now()
+		options.Attr.Mtime = options.Attr.Atime
+	} else {
+		times := (*[2]C.timespec_t)(unsafe.Pointer(tv))
+		options.Attr.Atime = timespecToTime(times[0])
+		options.Attr.Mtime = timespecToTime(times[1])
+	}
+
+	if options.Attr.Atime.IsZero() && options.Attr.Mtime.IsZero() {
+		return 0
+	}
+
+	err := fuseFS.NextComponent().SetAttr(options)
+	if err != nil {
+		log.Err("Libfuse::libfuse2_utimens : error in setting times of %s [%s]", name, err.Error())
+		if os.IsNotExist(err) {
+			return -C.ENOENT
+		} else if os.IsPermission(err) {
+			return -C.EACCES
+		}
+		return -C.EIO
+	}
+
+	libfuseStatsCollector.PushEvents(setAttr, name, map[string]interface{}{accessTime: options.Attr.Atime, modTime: options.Attr.Mtime})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, setAttr, (int64)(1))
+
+	return 0
+}
+
+// libfuse_setxattr sets an extended attribute of a file or directory
+//
+//export libfuse_setxattr
+func libfuse_setxattr(path *C.char, attr *C.char, value *C.char, size C.size_t, flags C.int) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	xattrName := C.GoString(attr)
+	log.Trace("Libfuse::libfuse_setxattr : %s on %s", xattrName, name)
+
+	err := fuseFS.NextComponent().SetXattr(
+		internal.SetXattrOptions{
+			Name:  name,
+			Attr:  xattrName,
+			Value: C.GoBytes(unsafe.Pointer(value), C.int(size)),
+			Flags: int(flags),
+		})
+	if err != nil {
+		log.Err("Libfuse::libfuse_setxattr : error setting %s of %s [%s]", xattrName, name, err.Error())
+		return xattrErrorCode(err)
+	}
+
+	libfuseStatsCollector.PushEvents(setXattr, name, map[string]interface{}{xattr: xattrName})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, setXattr, (int64)(1))
+
+	return 0
+}
+
+// libfuse_getxattr reads an extended attribute of a file or directory
+//
+//export libfuse_getxattr
+func libfuse_getxattr(path *C.char, attr *C.char, value *C.char, size C.size_t) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	xattrName := C.GoString(attr)
+
+	// Kernel probes security.capability on every write, answer anything outside user namespace right here
+	if !strings.HasPrefix(xattrName, xattrUserNamespace) {
+		return -C.ENODATA
+	}
+
+	log.Trace("Libfuse::libfuse_getxattr : %s on %s", xattrName, name)
+
+	data, err := fuseFS.NextComponent().GetXattr(internal.GetXattrOptions{Name: name, Attr: xattrName})
+	if err != nil {
+		if err != syscall.ENODATA {
+			log.Err("Libfuse::libfuse_getxattr : error getting %s of %s [%s]", xattrName, name, err.Error())
+		}
+		return xattrErrorCode(err)
+	}
+
+	// Caller is only asking for the size of the value
+	if size == 0 {
+		return C.int(len(data))
+	}
+
+	if int(size) < len(data) {
+		return -C.ERANGE
+	}
+
+	if len(data) > 0 {
+		buf := (*[1 << 30]byte)(unsafe.Pointer(value))
+		copy(buf[:size], data)
+	}
+
+	return C.int(len(data))
+}
+
+// libfuse_listxattr lists names of extended attributes of a file or directory
+//
+//export libfuse_listxattr
+func libfuse_listxattr(path *C.char, list *C.char, size C.size_t) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	log.Trace("Libfuse::libfuse_listxattr : %s", name)
+
+	names, err := fuseFS.NextComponent().ListXattr(internal.ListXattrOptions{Name: name})
+	if err != nil {
+		log.Err("Libfuse::libfuse_listxattr : error listing attributes of %s [%s]", name, err.Error())
+		return xattrErrorCode(err)
+	}
+
+	// List is a sequence of null terminated names
+	length := 0
+	for _, n := range names {
+		length += len(n) + 1
+	}
+
+	// Caller is only asking for the size of the list
+	if size == 0 {
+		return C.int(length)
+	}
+
+	if int(size) < length {
+		return -C.ERANGE
+	}
+
+	buf := (*[1 << 30]byte)(unsafe.Pointer(list))
+	offset := 0
+	for _, n := range names {
+		offset += copy(buf[offset:size], n)
+		buf[offset] = 0
+		offset++
+	}
+
+	return C.int(length)
+}
+
+// libfuse_removexattr removes an extended attribute of a file or directory
+//
+//export libfuse_removexattr
+func libfuse_removexattr(path *C.char, attr *C.char) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	xattrName := C.GoString(attr)
+	log.Trace("Libfuse::libfuse_removexattr : %s on %s", xattrName, name)
+
+	err := fuseFS.NextComponent().RemoveXattr(internal.RemoveXattrOptions{Name: name, Attr: xattrName})
+	if err != nil {
+		log.Err("Libfuse::libfuse_removexattr : error removing %s of %s [%s]", xattrName, name, err.Error())
+		return xattrErrorCode(err)
+	}
+
+	libfuseStatsCollector.PushEvents(removeXattr, name, map[string]interface{}{xattr: xattrName})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, removeXattr, (int64)(1))
+
+	return 0
+}
+
+// libfuse_flock applies or removes a flock on the file, exclusive locks are held across mounts by leasing the file.
+// Shared locks do not keep other mounts from reading the file so there is nothing to hold for them in storage,
+// they are only kept from being taken while another mount holds the lease.
+//
+//export libfuse_flock
+func libfuse_flock(path *C.char, fi *C.fuse_file_info_t, op C.int) C.int {
+	fileHandle := (*C.file_handle_t)(unsafe.Pointer(uintptr(fi.fh)))
+	handle := (*handlemap.Handle)(unsafe.Pointer(uintptr(fileHandle.obj)))
+	log.Trace("Libfuse::libfuse2_flock : %s, handle: %d, op %d", handle.Path, handle.ID, op)
+
+	// Lock belongs to the open file, which is the handle
+	owner := uint64(handle.ID)
+
+	var err error
+	if op&C.LOCK_UN != 0 {
+		err = fuseFS.clearLock(fuseFS.flocks, handle, owner)
+	} else {
+		err = fuseFS.setLock(fuseFS.flocks, handle, owner, op&C.LOCK_EX != 0, op&C.LOCK_NB == 0, uint32(C.get_caller_pid()))
+	}
+
+	if err != nil {
+		log.Err("Libfuse::libfuse2_flock : error locking file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+		return lockErrorCode(err)
+	}
+
+	return 0
+}
+
+// libfuse_lock applies or removes a POSIX record lock on the file, write locks are held across mounts by leasing the file.
+// Lease covers the whole file so a lock on any range locks the file as a whole.
+//
+//export libfuse_lock
+func libfuse_lock(path *C.char, fi *C.fuse_file_info_t, cmd C.int, lock *C.flock_t) C.int {
+	fileHandle := (*C.file_handle_t)(unsafe.Pointer(uintptr(fi.fh)))
+	handle := (*handlemap.Handle)(unsafe.Pointer(uintptr(fileHandle.obj)))
+	log.Trace("Libfuse::libfuse2_lock : %s, handle: %d, cmd %d, type %d", handle.Path, handle.ID, cmd, lock.l_type)
+
+	owner := uint64(fi.lock_owner)
+
+	if cmd == C.F_GETLK {
+		holder, err := fuseFS.testLock(fuseFS.posixLocks, handle, owner, lock.l_type == C.F_WRLCK)
+		if err != nil {
+			log.Err("Libfuse::libfuse2_lock : error checking lock of file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+			return lockErrorCode(err)
+		}
+
+		if holder != nil {
+			// Locks cover the whole file
+			lock.l_type = C.F_RDLCK
+			if holder.exclusive {
+				lock.l_type = C.F_WRLCK
+			}
+			lock.l_whence = C.SEEK_SET
+			lock.l_start = 0
+			lock.l_len = 0
+			lock.l_pid = C.pid_t(holder.pid)
+		} else {
+			lock.l_type = C.F_UNLCK
+		}
+		return 0
+	}
+
+	var err error
+	if lock.l_type == C.F_UNLCK {
+		err = fuseFS.clearLock(fuseFS.posixLocks, handle, owner)
+	} else {
+		err = fuseFS.setLock(fuseFS.posixLocks, handle, owner, lock.l_type == C.F_WRLCK, cmd == C.F_SETLKW, uint32(C.get_caller_pid()))
+	}
+
+	if err != nil {
+		log.Err("Libfuse::libfuse2_lock : error locking file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+		return lockErrorCode(err)
+	}
+
 	return 0
 }
 
@@ -33,6 +33,8 @@
 
 package libfuse
 
+import "time"
+
 const (
 	createDir    = "CreateDir"
 	deleteDir    = "DeleteDir"
@@ -46,11 +48,26 @@ const (
 	syncFile     = "SyncFile"
 	syncDir      = "SyncDir"
 	chmod        = "Chmod"
+	chown        = "Chown"
+	setXattr     = "SetXattr"
+	removeXattr  = "RemoveXattr"
+	setAttr      = "SetAttr"
 
 	openHandles = "OpenFileHandles"
 	md          = "Mode"
+	uidField    = "Uid"
+	gidField    = "Gid"
 	size        = "Size"
 	source      = "Src"
 	dest        = "Dest"
 	trgt        = "Target"
+	xattr       = "Xattr"
+	accessTime  = "Atime"
+	modTime     = "Mtime"
 )
+
+// Interval at which a blocking lock request retries to lease a file locked by another mount
+const lockRetryInterval = time.Second
+
+// Only extended attributes in this namespace are persisted to storage
+const xattrUserNamespace = "user."
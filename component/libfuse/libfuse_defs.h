@@ -61,6 +61,7 @@ typedef struct  fuse_file_info          fuse_file_info_t;
 typedef struct  statvfs                 statvfs_t;
 typedef struct  stat                    stat_t;
 typedef struct  timespec                timespec_t;
+typedef struct  flock                   flock_t;
 typedef enum    fuse_readdir_flags      fuse_readdir_flags_t;
 typedef enum    fuse_fill_dir_flags     fuse_fill_dir_flags_t;
 
@@ -86,6 +87,7 @@ typedef struct fuse_options
     bool    trace_enable;
     bool    non_empty;
     int     umask;
+    bool    default_permissions;
 } fuse_options_t;
 
 
@@ -116,6 +118,14 @@ extern int libfuse_readlink(char *path, char *buf, size_t size);
 extern int libfuse_fsync(char *path, int, fuse_file_info_t *fi);
 extern int libfuse_fsyncdir(char *path, int, fuse_file_info_t *);
 
+extern int libfuse_setxattr(char *path, char *name, char *value, size_t size, int flags);
+extern int libfuse_getxattr(char *path, char *name, char *value, size_t size);
+extern int libfuse_listxattr(char *path, char *list, size_t size);
+extern int libfuse_removexattr(char *path, char *name);
+
+extern int libfuse_flock(char *path, fuse_file_info_t *fi, int op);
+extern int libfuse_lock(char *path, fuse_file_info_t *fi, int cmd, flock_t *lock);
+
 // chmod, chown and utimens are lib version specific so defined later
 
 #ifdef __FUSE2__
@@ -150,18 +160,12 @@ static int native_flush_file(char *path, fuse_file_info_t *fi);
 
 // extern int libfuse_mknod(char *path, mode_t mode, dev_t dev);
 // extern int libfuse_link(char *from, char *to);
-// extern int libfuse_setxattr(char *path, char *name, char *value, size_t size, int flags);
-// extern int libfuse_getxattr(char *path, char *name, char *value, size_t size);
-// extern int libfuse_listxattr(char* path, char *list, size_t size);
-// extern int libfuse_removexattr(char *path, char *name);
 // extern int libfuse_access(char *path, int mask);
-// extern int libfuse_lock
 // extern int libfuse_bmap
 // extern int libfuse_ioctl
 // extern int libfuse_poll
 // extern int libfuse_write_buf
 // extern int libfuse_read_buf
-// extern int libfuse_flock
 // extern int libfuse_fallocate
 // extern int libfuse_copyfilerange
 // extern int libfuse_lseek
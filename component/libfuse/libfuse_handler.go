@@ -51,13 +51,16 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/common/log"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
 	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
 	"github.com/Azure/azure-storage-fuse/v2/internal/stats_manager"
 )
 
@@ -92,6 +95,72 @@ func trimFusePath(path *C.char) string {
 	return str
 }
 
+// callerOwner returns uid and gid of the process making the current request.
+// Tests replace it as they do not call the handlers from within a fuse request.
+var callerOwner = func() (uint32, uint32, bool) {
+	var uid C.uid_t
+	var gid C.gid_t
+	if C.get_caller_uid_gid(&uid, &gid) != 0 {
+		return 0, 0, false
+	}
+	return uint32(uid), uint32(gid), true
+}
+
+// timespecToTime converts time received in utimens call, a zero time means it shall be left unchanged
+func timespecToTime(ts C.timespec_t) time.Time {
+	switch ts.tv_nsec {
+	case C.UTIME_NOW:
+		return time.Now()
+	case C.UTIME_OMIT:
+		return time.Time{}
+	default:
+		return time.Unix(int64(ts.tv_sec), int64(ts.tv_nsec))
+	}
+}
+
+// xattrErrorCode converts error of an extended attribute operation to the errno returned to libfuse
+func xattrErrorCode(err error) C.int {
+	switch err {
+	case syscall.ENODATA:
+		return -C.ENODATA
+	case syscall.EEXIST:
+		return -C.EEXIST
+	case syscall.ENOTSUP:
+		return -C.ENOTSUP
+	case syscall.ERANGE:
+		return -C.ERANGE
+	case syscall.EINVAL:
+		return -C.EINVAL
+	case syscall.EAGAIN:
+		return -C.EAGAIN
+	}
+
+	if os.IsNotExist(err) {
+		return -C.ENOENT
+	} else if os.IsPermission(err) {
+		return -C.EACCES
+	}
+	return -C.EIO
+}
+
+// lockErrorCode converts error of a lock operation to the errno returned to libfuse
+func lockErrorCode(err error) C.int {
+	switch err {
+	case syscall.EBUSY, syscall.EWOULDBLOCK:
+		// File is locked by another mount, or by another process in this mount
+		return -C.EAGAIN
+	case syscall.ENOTSUP:
+		return -C.ENOLCK
+	}
+
+	if os.IsNotExist(err) {
+		return -C.ENOENT
+	} else if os.IsPermission(err) {
+		return -C.EACCES
+	}
+	return -C.EIO
+}
+
 var fuse_opts C.fuse_options_t // nolint
 
 // convertConfig converts the config options from Go to C
@@ -111,6 +180,7 @@ func (lf *Libfuse) convertConfig() *C.fuse_options_t {
 	fuse_opts.allow_root = C.bool(lf.allowRoot)
 	fuse_opts.trace_enable = C.bool(lf.traceEnable)
 	fuse_opts.umask = C.int(lf.umask)
+	fuse_opts.default_permissions = C.bool(lf.permissionCheck)
 
 	return fuse_opts
 }
@@ -149,6 +219,9 @@ func (lf *Libfuse) initFuse() error {
 		// Get our callback table
 		my_operations := C.fuse_operations_t{}
 		C.populate_callbacks(&my_operations)
+		if lf.leaseLock {
+			C.populate_lock_callbacks(&my_operations)
+		}
 
 		// Send our callback table to the extension
 		errc = C.register_callback_to_extension(&my_operations)
@@ -162,6 +235,10 @@ func (lf *Libfuse) initFuse() error {
 		// Populate our methods to be registered to libfuse
 		log.Trace("Libfuse::initFuse : Registering fuse callbacks")
 		C.populate_callbacks(&operations)
+		if lf.leaseLock {
+			log.Trace("Libfuse::initFuse : Registering lock callbacks")
+			C.populate_lock_callbacks(&operations)
+		}
 	}
 
 	log.Trace("Libfuse::initFuse : Populating fuse arguments")
@@ -209,6 +286,10 @@ func populateFuseArgs(opts *C.fuse_options_t, args *C.fuse_args_t) (*C.fuse_opti
 		options += ",allow_root"
 	}
 
+	if opts.default_permissions {
+		options += ",default_permissions"
+	}
+
 	if opts.readonly {
 		options += ",ro"
 	}
@@ -264,6 +345,7 @@ func libfuse_init(conn *C.fuse_conn_info_t, cfg *C.fuse_config_t) (res unsafe.Po
 	}
 
 	C.populate_uid_gid()
+	C.save_fuse_instance()
 
 	log.Info("Libfuse::libfuse_init : Kernel Caps : %d", conn.capable)
 
@@ -343,11 +425,34 @@ func libfuse_init(conn *C.fuse_conn_info_t, cfg *C.fuse_config_t) (res unsafe.Po
 //export libfuse_destroy
 func libfuse_destroy(data unsafe.Pointer) {
 	log.Trace("Libfuse::libfuse_destroy : destroy")
+	fuseFS.stopNotifier()
+	C.clear_fuse_instance()
+}
+
+// notifyKernel : Ask the kernel to drop what it has cached for the changed path. Invalidating the entry of a
+// directory drops the entries under it as well.
+func notifyKernel(event invalidation.Event) {
+	log.Debug("Libfuse::notifyKernel : Invalidating %s", event.Path)
+
+	path := C.CString("/" + event.Path)
+	defer C.free(unsafe.Pointer(path))
+
+	err := C.invalidate_path(path)
+	if err != 0 && err != -C.ENOENT {
+		// ENOENT means the kernel has nothing cached for the path
+		log.Warn("Libfuse::notifyKernel : Failed to invalidate %s [%s]", event.Path, syscall.Errno(-err).Error())
+	}
 }
 
 func (lf *Libfuse) fillStat(attr *internal.ObjAttr, stbuf *C.stat_t) {
-	(*stbuf).st_uid = C.uint(lf.ownerUID)
-	(*stbuf).st_gid = C.uint(lf.ownerGID)
+	// Backing storage implementation has support for owner.
+	if attr.IsOwnerSet() {
+		(*stbuf).st_uid = C.uint(attr.Uid)
+		(*stbuf).st_gid = C.uint(attr.Gid)
+	} else {
+		(*stbuf).st_uid = C.uint(lf.ownerUID)
+		(*stbuf).st_gid = C.uint(lf.ownerGID)
+	}
 	(*stbuf).st_nlink = 1
 	(*stbuf).st_size = C.long(attr.Size)
 
@@ -443,6 +548,8 @@ func libfuse_mkdir(path *C.char, mode C.mode_t) C.int {
 			return -C.EACCES
 		} else if os.IsExist(err) {
 			return -C.EEXIST
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -513,37 +620,34 @@ func libfuse_readdir(_ *C.char, buf unsafe.Pointer, filler C.fuse_fill_dir_t, of
 
 	off_64 := uint64(off)
 	cacheInfo := val.(*dirChildCache)
-	if off_64 == 0 ||
-		(off_64 >= cacheInfo.eIndex && cacheInfo.token != "") {
-		attrs, token, err := fuseFS.NextComponent().StreamDir(internal.StreamDirOptions{
-			Name:   handle.Path,
-			Offset: off_64,
-			Token:  cacheInfo.token,
-			Count:  common.MaxDirListCount,
-		})
 
-		if err != nil {
-			log.Err("Libfuse::libfuse_readdir : Path %s, handle: %d, offset %d. Error in retrieval %s", handle.Path, handle.ID, off_64, err.Error())
-			if os.IsNotExist(err) {
-				return C.int(C_ENOENT)
-			} else if os.IsPermission(err) {
-				return C.int(C_EACCES)
-			} else {
-				return C.int(C_EIO)
-			}
-		}
+	// Offset before the current block comes on rewinddir or seekdir, children are then listed again from the first block.
+	// Blocks are served from memory if entry-cache is in the pipeline.
+	var err error
+	if off_64 == 0 || off_64 < cacheInfo.sIndex {
+		cacheInfo.reset()
+		err = cacheInfo.next(fuseFS.NextComponent(), handle.Path)
+	}
 
-		// TODO: Investigate why this works in fuse2 but not fuse3
-		// if off_64 == 0 {
-		// 	attrs = append([]*internal.ObjAttr{{Flags: fuseFS.lsFlags, Name: "."}, {Flags: fuseFS.lsFlags, Name: ".."}}, attrs...)
-		// }
+	// TODO: Investigate why this works in fuse2 but not fuse3
+	// if off_64 == 0 {
+	// 	attrs = append([]*internal.ObjAttr{{Flags: fuseFS.lsFlags, Name: "."}, {Flags: fuseFS.lsFlags, Name: ".."}}, attrs...)
+	// }
 
-		cacheInfo.sIndex = off_64
-		cacheInfo.eIndex = off_64 + uint64(len(attrs))
-		cacheInfo.length = uint64(len(attrs))
-		cacheInfo.token = token
-		cacheInfo.children = cacheInfo.children[:0]
-		cacheInfo.children = attrs
+	// Blocks may come back empty with more to follow, keep listing till the offset is reached or there is nothing left
+	for err == nil && off_64 >= cacheInfo.eIndex && cacheInfo.token != "" {
+		err = cacheInfo.next(fuseFS.NextComponent(), handle.Path)
+	}
+
+	if err != nil {
+		log.Err("Libfuse::libfuse_readdir : Path %s, handle: %d, offset %d. Error in retrieval %s", handle.Path, handle.ID, off_64, err.Error())
+		if os.IsNotExist(err) {
+			return C.int(C_ENOENT)
+		} else if os.IsPermission(err) {
+			return C.int(C_EACCES)
+		} else {
+			return C.int(C_EIO)
+		}
 	}
 
 	if off_64 >= cacheInfo.eIndex {
@@ -598,6 +702,8 @@ func libfuse_rmdir(path *C.char) C.int {
 		log.Err("Libfuse::libfuse_rmdir : Failed to delete %s [%s]", name, err.Error())
 		if os.IsNotExist(err) {
 			return -C.ENOENT
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -649,13 +755,22 @@ func libfuse_create(path *C.char, mode C.mode_t, fi *C.fuse_file_info_t) C.int {
 	name = common.NormalizeObjectName(name)
 	log.Trace("Libfuse::libfuse_create : %s", name)
 
-	handle, err := fuseFS.NextComponent().CreateFile(internal.CreateFileOptions{Name: name, Mode: fs.FileMode(uint32(mode) & 0xffffffff)})
+	options := internal.CreateFileOptions{Name: name, Mode: fs.FileMode(uint32(mode) & 0xffffffff)}
+	// Caller becomes the owner of the new file
+	options.Uid, options.Gid, options.OwnerSet = callerOwner()
+
+	handle, err := fuseFS.NextComponent().CreateFile(options)
 	if err != nil {
 		log.Err("Libfuse::libfuse_create : Failed to create %s [%s]", name, err.Error())
 		if os.IsExist(err) {
 			return -C.EEXIST
 		} else if os.IsPermission(err) {
 			return -C.EACCES
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -723,6 +838,17 @@ func libfuse_open(path *C.char, fi *C.fuse_file_info_t) C.int {
 			return -C.ENOENT
 		} else if os.IsPermission(err) {
 			return -C.EACCES
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
+		} else if err == syscall.EAGAIN {
+			// Blob is archived and its rehydration is pending
+			return -C.EAGAIN
+		} else if err == syscall.ENODATA {
+			// Blob is archived
+			return -C.ENODATA
 		} else {
 			return -C.EIO
 		}
@@ -773,6 +899,11 @@ func libfuse_read(path *C.char, buf *C.char, size C.size_t, off C.off_t, fi *C.f
 	}
 	if err != nil {
 		log.Err("Libfuse::libfuse_read : error reading file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+		if err == syscall.EAGAIN {
+			return -C.EAGAIN
+		} else if err == syscall.ENODATA {
+			return -C.ENODATA
+		}
 		return -C.EIO
 	}
 
@@ -829,6 +960,14 @@ func libfuse_flush(path *C.char, fi *C.fuse_file_info_t) C.int {
 			return -C.ENOENT
 		} else if err == syscall.EACCES {
 			return -C.EACCES
+		} else if err == syscall.ESTALE {
+			// File was modified by someone else since it was opened
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		} else {
 			return -C.EIO
 		}
@@ -850,6 +989,8 @@ func libfuse_truncate(path *C.char, off C.off_t, fi *C.fuse_file_info_t) C.int {
 		log.Err("Libfuse::libfuse_truncate : error truncating file %s [%s]", name, err.Error())
 		if os.IsNotExist(err) {
 			return -C.ENOENT
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		}
 		return -C.EIO
 	}
@@ -874,13 +1015,25 @@ func libfuse_release(path *C.char, fi *C.fuse_file_info_t) C.int {
 		handle.Flags.Set(handlemap.HandleFlagDirty)
 	}
 
-	err := fuseFS.NextComponent().CloseFile(internal.CloseFileOptions{Handle: handle})
+	// Locks taken through this handle go away with it
+	err := fuseFS.releaseLocks(handle)
+	if err != nil {
+		log.Err("Libfuse::libfuse_release : error unlocking file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+	}
+
+	err = fuseFS.NextComponent().CloseFile(internal.CloseFileOptions{Handle: handle})
 	if err != nil {
 		log.Err("Libfuse::libfuse_release : error closing file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
 		if err == syscall.ENOENT {
 			return -C.ENOENT
 		} else if err == syscall.EACCES {
 			return -C.EACCES
+		} else if err == syscall.ESTALE {
+			// File was modified by someone else since it was opened
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			// File is locked by another mount
+			return -C.EBUSY
 		} else {
 			return -C.EIO
 		}
@@ -910,6 +1063,12 @@ func libfuse_unlink(path *C.char) C.int {
 			return -C.ENOENT
 		} else if os.IsPermission(err) {
 			return -C.EACCES
+		} else if err == syscall.ESTALE {
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			return -C.EBUSY
+		} else if err == syscall.EROFS {
+			return -C.EROFS
 		}
 		return -C.EIO
 	}
@@ -982,6 +1141,9 @@ func libfuse_rename(src *C.char, dst *C.char, flags C.uint) C.int {
 		err := fuseFS.NextComponent().RenameDir(internal.RenameDirOptions{Src: srcPath, Dst: dstPath})
 		if err != nil {
 			log.Err("Libfuse::libfuse_rename : error renaming directory %s -> %s [%s]", srcPath, dstPath, err.Error())
+			if err == syscall.EROFS {
+				return -C.EROFS
+			}
 			return -C.EIO
 		}
 
@@ -992,6 +1154,13 @@ func libfuse_rename(src *C.char, dst *C.char, flags C.uint) C.int {
 		err := fuseFS.NextComponent().RenameFile(internal.RenameFileOptions{Src: srcPath, Dst: dstPath})
 		if err != nil {
 			log.Err("Libfuse::libfuse_rename : error renaming file %s -> %s [%s]", srcPath, dstPath, err.Error())
+			if err == syscall.ESTALE {
+				return -C.ESTALE
+			} else if err == syscall.EBUSY {
+				return -C.EBUSY
+			} else if err == syscall.EROFS {
+				return -C.EROFS
+			}
 			return -C.EIO
 		}
 
@@ -1078,6 +1247,11 @@ func libfuse_fsync(path *C.char, datasync C.int, fi *C.fuse_file_info_t) C.int {
 	err := fuseFS.NextComponent().SyncFile(options)
 	if err != nil {
 		log.Err("Libfuse::libfuse_fsync : error syncing file %s [%s]", handle.Path, err.Error())
+		if err == syscall.ESTALE {
+			return -C.ESTALE
+		} else if err == syscall.EBUSY {
+			return -C.EBUSY
+		}
 		return -C.EIO
 	}
 
@@ -1147,7 +1321,48 @@ func libfuse_chown(path *C.char, uid C.uid_t, gid C.gid_t, fi *C.fuse_file_info_
 	name := trimFusePath(path)
 	name = common.NormalizeObjectName(name)
 	log.Trace("Libfuse::libfuse_chown : %s", name)
-	// TODO: Implement
+
+	owner, group := int(uid), int(gid)
+	if uid == ^C.uid_t(0) || gid == ^C.gid_t(0) {
+		// -1 means the id shall be left unchanged, so pick the current one
+		curUID, curGID, err := fuseFS.getOwner(name)
+		if err != nil {
+			log.Err("Libfuse::libfuse_chown : error getting owner of %s [%s]", name, err.Error())
+			if os.IsNotExist(err) {
+				return -C.ENOENT
+			} else if os.IsPermission(err) {
+				return -C.EACCES
+			}
+			return -C.EIO
+		}
+
+		if uid == ^C.uid_t(0) {
+			owner = int(curUID)
+		}
+		if gid == ^C.gid_t(0) {
+			group = int(curGID)
+		}
+	}
+
+	err := fuseFS.NextComponent().Chown(
+		internal.ChownOptions{
+			Name:  name,
+			Owner: owner,
+			Group: group,
+		})
+	if err != nil {
+		log.Err("Libfuse::libfuse_chown : error in chown of %s [%s]", name, err.Error())
+		if os.IsNotExist(err) {
+			return -C.ENOENT
+		} else if os.IsPermission(err) {
+			return -C.EACCES
+		}
+		return -C.EIO
+	}
+
+	libfuseStatsCollector.PushEvents(chown, name, map[string]interface{}{uidField: owner, gidField: group})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, chown, (int64)(1))
+
 	return 0
 }
 
@@ -1158,9 +1373,241 @@ func libfuse_utimens(path *C.char, tv *C.timespec_t, fi *C.fuse_file_info_t) C.i
 	name := trimFusePath(path)
 	name = common.NormalizeObjectName(name)
 	log.Trace("Libfuse::libfuse_utimens : %s", name)
-	// TODO: is the conversion from [2]timespec to *timespec ok?
-	// TODO: Implement
-	// For now this returns 0 to allow touch to work correctly
+
+	options := internal.SetAttrOptions{Name: name, Attr: &internal.ObjAttr{}}
+	if tv == nil {
+		// Both times shall be set to current time
+		options.Attr.Atime = time.Now()
+		options.Attr.Mtime = options.Attr.Atime
+	} else {
+		times := (*[2]C.timespec_t)(unsafe.Pointer(tv))
+		options.Attr.Atime = timespecToTime(times[0])
+		options.Attr.Mtime = timespecToTime(times[1])
+	}
+
+	if options.Attr.Atime.IsZero() && options.Attr.Mtime.IsZero() {
+		return 0
+	}
+
+	err := fuseFS.NextComponent().SetAttr(options)
+	if err != nil {
+		log.Err("Libfuse::libfuse_utimens : error in setting times of %s [%s]", name, err.Error())
+		if os.IsNotExist(err) {
+			return -C.ENOENT
+		} else if os.IsPermission(err) {
+			return -C.EACCES
+		}
+		return -C.EIO
+	}
+
+	libfuseStatsCollector.PushEvents(setAttr, name, map[string]interface{}{accessTime: options.Attr.Atime, modTime: options.Attr.Mtime})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, setAttr, (int64)(1))
+
+	return 0
+}
+
+// libfuse_setxattr sets an extended attribute of a file or directory
+//
+//export libfuse_setxattr
+func libfuse_setxattr(path *C.char, attr *C.char, value *C.char, size C.size_t, flags C.int) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	xattrName := C.GoString(attr)
+	log.Trace("Libfuse::libfuse_setxattr : %s on %s", xattrName, name)
+
+	err := fuseFS.NextComponent().SetXattr(
+		internal.SetXattrOptions{
+			Name:  name,
+			Attr:  xattrName,
+			Value: C.GoBytes(unsafe.Pointer(value), C.int(size)),
+			Flags: int(flags),
+		})
+	if err != nil {
+		log.Err("Libfuse::libfuse_setxattr : error setting %s of %s [%s]", xattrName, name, err.Error())
+		return xattrErrorCode(err)
+	}
+
+	libfuseStatsCollector.PushEvents(setXattr, name, map[string]interface{}{xattr: xattrName})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, setXattr, (int64)(1))
+
+	return 0
+}
+
+// libfuse_getxattr reads an extended attribute of a file or directory
+//
+//export libfuse_getxattr
+func libfuse_getxattr(path *C.char, attr *C.char, value *C.char, size C.size_t) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	xattrName := C.GoString(attr)
+
+	// Kernel probes security.capability on every write, answer anything outside user namespace right here
+	if !strings.HasPrefix(xattrName, xattrUserNamespace) {
+		return -C.ENODATA
+	}
+
+	log.Trace("Libfuse::libfuse_getxattr : %s on %s", xattrName, name)
+
+	data, err := fuseFS.NextComponent().GetXattr(internal.GetXattrOptions{Name: name, Attr: xattrName})
+	if err != nil {
+		if err != syscall.ENODATA {
+			log.Err("Libfuse::libfuse_getxattr : error getting %s of %s [%s]", xattrName, name, err.Error())
+		}
+		return xattrErrorCode(err)
+	}
+
+	// Caller is only asking for the size of the value
+	if size == 0 {
+		return C.int(len(data))
+	}
+
+	if int(size) < len(data) {
+		return -C.ERANGE
+	}
+
+	if len(data) > 0 {
+		buf := (*[1 << 30]byte)(unsafe.Pointer(value))
+		copy(buf[:size], data)
+	}
+
+	return C.int(len(data))
+}
+
+// libfuse_listxattr lists names of extended attributes of a file or directory
+//
+//export libfuse_listxattr
+func libfuse_listxattr(path *C.char, list *C.char, size C.size_t) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	log.Trace("Libfuse::libfuse_listxattr : %s", name)
+
+	names, err := fuseFS.NextComponent().ListXattr(internal.ListXattrOptions{Name: name})
+	if err != nil {
+		log.Err("Libfuse::libfuse_listxattr : error listing attributes of %s [%s]", name, err.Error())
+		return xattrErrorCode(err)
+	}
+
+	// List is a sequence of null terminated names
+	length := 0
+	for _, n := range names {
+		length += len(n) + 1
+	}
+
+	// Caller is only asking for the size of the list
+	if size == 0 {
+		return C.int(length)
+	}
+
+	if int(size) < length {
+		return -C.ERANGE
+	}
+
+	buf := (*[1 << 30]byte)(unsafe.Pointer(list))
+	offset := 0
+	for _, n := range names {
+		offset += copy(buf[offset:size], n)
+		buf[offset] = 0
+		offset++
+	}
+
+	return C.int(length)
+}
+
+// libfuse_removexattr removes an extended attribute of a file or directory
+//
+//export libfuse_removexattr
+func libfuse_removexattr(path *C.char, attr *C.char) C.int {
+	name := trimFusePath(path)
+	name = common.NormalizeObjectName(name)
+	xattrName := C.GoString(attr)
+	log.Trace("Libfuse::libfuse_removexattr : %s on %s", xattrName, name)
+
+	err := fuseFS.NextComponent().RemoveXattr(internal.RemoveXattrOptions{Name: name, Attr: xattrName})
+	if err != nil {
+		log.Err("Libfuse::libfuse_removexattr : error removing %s of %s [%s]", xattrName, name, err.Error())
+		return xattrErrorCode(err)
+	}
+
+	libfuseStatsCollector.PushEvents(removeXattr, name, map[string]interface{}{xattr: xattrName})
+	libfuseStatsCollector.UpdateStats(stats_manager.Increment, removeXattr, (int64)(1))
+
+	return 0
+}
+
+// libfuse_flock applies or removes a flock on the file, exclusive locks are held across mounts by leasing the file.
+// Shared locks do not keep other mounts from reading the file so there is nothing to hold for them in storage,
+// they are only kept from being taken while another mount holds the lease.
+//
+//export libfuse_flock
+func libfuse_flock(path *C.char, fi *C.fuse_file_info_t, op C.int) C.int {
+	fileHandle := (*C.file_handle_t)(unsafe.Pointer(uintptr(fi.fh)))
+	handle := (*handlemap.Handle)(unsafe.Pointer(uintptr(fileHandle.obj)))
+	log.Trace("Libfuse::libfuse_flock : %s, handle: %d, op %d", handle.Path, handle.ID, op)
+
+	// Lock belongs to the open file, which is the handle
+	owner := uint64(handle.ID)
+
+	var err error
+	if op&C.LOCK_UN != 0 {
+		err = fuseFS.clearLock(fuseFS.flocks, handle, owner)
+	} else {
+		err = fuseFS.setLock(fuseFS.flocks, handle, owner, op&C.LOCK_EX != 0, op&C.LOCK_NB == 0, uint32(C.get_caller_pid()))
+	}
+
+	if err != nil {
+		log.Err("Libfuse::libfuse_flock : error locking file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+		return lockErrorCode(err)
+	}
+
+	return 0
+}
+
+// libfuse_lock applies or removes a POSIX record lock on the file, write locks are held across mounts by leasing the file.
+// Lease covers the whole file so a lock on any range locks the file as a whole.
+//
+//export libfuse_lock
+func libfuse_lock(path *C.char, fi *C.fuse_file_info_t, cmd C.int, lock *C.flock_t) C.int {
+	fileHandle := (*C.file_handle_t)(unsafe.Pointer(uintptr(fi.fh)))
+	handle := (*handlemap.Handle)(unsafe.Pointer(uintptr(fileHandle.obj)))
+	log.Trace("Libfuse::libfuse_lock : %s, handle: %d, cmd %d, type %d", handle.Path, handle.ID, cmd, lock.l_type)
+
+	owner := uint64(fi.lock_owner)
+
+	if cmd == C.F_GETLK {
+		holder, err := fuseFS.testLock(fuseFS.posixLocks, handle, owner, lock.l_type == C.F_WRLCK)
+		if err != nil {
+			log.Err("Libfuse::libfuse_lock : error checking lock of file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+			return lockErrorCode(err)
+		}
+
+		if holder != nil {
+			// Locks cover the whole file
+			lock.l_type = C.F_RDLCK
+			if holder.exclusive {
+				lock.l_type = C.F_WRLCK
+			}
+			lock.l_whence = C.SEEK_SET
+			lock.l_start = 0
+			lock.l_len = 0
+			lock.l_pid = C.pid_t(holder.pid)
+		} else {
+			lock.l_type = C.F_UNLCK
+		}
+		return 0
+	}
+
+	var err error
+	if lock.l_type == C.F_UNLCK {
+		err = fuseFS.clearLock(fuseFS.posixLocks, handle, owner)
+	} else {
+		err = fuseFS.setLock(fuseFS.posixLocks, handle, owner, lock.l_type == C.F_WRLCK, cmd == C.F_SETLKW, uint32(C.get_caller_pid()))
+	}
+
+	if err != nil {
+		log.Err("Libfuse::libfuse_lock : error locking file %s, handle: %d [%s]", handle.Path, handle.ID, err.Error())
+		return lockErrorCode(err)
+	}
+
 	return 0
 }
 
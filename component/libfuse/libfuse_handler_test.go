@@ -35,9 +35,14 @@ package libfuse
 
 import (
 	"io/fs"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -83,6 +88,22 @@ func (suite *libfuseTestSuite) TestConfig() {
 	suite.assert.True(suite.libfuse.directIO)
 }
 
+func (suite *libfuseTestSuite) TestConfigLeaseLock() {
+	defer suite.cleanupTest()
+
+	// Locks can not be taken unless the cache in the pipeline leases files
+	lf := NewLibfuseComponent()
+	config.ReadConfigFromReader(strings.NewReader("components:\n  - libfuse\n  - file_cache\n  - azstorage\nlibfuse:\n  lease-lock: true\n"))
+	suite.assert.Error(lf.Configure(true))
+
+	config.ReadConfigFromReader(strings.NewReader("components:\n  - libfuse\n  - block_cache\n  - azstorage\nlibfuse:\n  lease-lock: true\nfile_cache:\n  lease-lock: true\n"))
+	suite.assert.Error(lf.Configure(true))
+
+	config.ReadConfigFromReader(strings.NewReader("components:\n  - libfuse\n  - block_cache\n  - azstorage\nlibfuse:\n  lease-lock: true\nblock_cache:\n  lease-lock: true\n"))
+	suite.assert.NoError(lf.Configure(true))
+	suite.assert.True(lf.(*Libfuse).leaseLock)
+}
+
 func (suite *libfuseTestSuite) TestConfigZero() {
 	defer suite.cleanupTest()
 	suite.cleanupTest() // clean up the default libfuse generated
@@ -163,8 +184,84 @@ func (suite *libfuseTestSuite) TestMkDirError() {
 	testMkDirError(suite)
 }
 
+func (suite *libfuseTestSuite) TestMkDirReadOnly() {
+	testMkDirReadOnly(suite)
+}
+
 // readdir
 
+// pagedDir : Directory listed in the given pages, tokens are the index of the page
+type pagedDir struct {
+	internal.BaseComponent
+	pages [][]string
+}
+
+func (p *pagedDir) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
+	page, _ := strconv.Atoi(options.Token)
+
+	attrs := make([]*internal.ObjAttr, 0)
+	for _, name := range p.pages[page] {
+		attrs = append(attrs, &internal.ObjAttr{Name: name, Path: options.Name + name})
+	}
+
+	token := ""
+	if page+1 < len(p.pages) {
+		token = strconv.Itoa(page + 1)
+	}
+	return attrs, token, nil
+}
+
+func (suite *libfuseTestSuite) TestDirChildCacheNext() {
+	defer suite.cleanupTest()
+	dir := &pagedDir{pages: [][]string{{"a", "b"}, {}, {"c"}}}
+	cache := &dirChildCache{}
+
+	suite.assert.NoError(cache.next(dir, "dir/"))
+	suite.assert.Equal(uint64(0), cache.sIndex)
+	suite.assert.Equal(uint64(2), cache.eIndex)
+	suite.assert.Equal("1", cache.token)
+
+	// Empty block keeps the offset where it was
+	suite.assert.NoError(cache.next(dir, "dir/"))
+	suite.assert.Equal(uint64(2), cache.sIndex)
+	suite.assert.Equal(uint64(2), cache.eIndex)
+	suite.assert.Equal(uint64(0), cache.length)
+
+	suite.assert.NoError(cache.next(dir, "dir/"))
+	suite.assert.Equal(uint64(2), cache.sIndex)
+	suite.assert.Equal(uint64(3), cache.eIndex)
+	suite.assert.Equal("", cache.token)
+	suite.assert.Equal("c", cache.children[0].Name)
+
+	cache.reset()
+	suite.assert.NoError(cache.next(dir, "dir/"))
+	suite.assert.Equal(uint64(0), cache.sIndex)
+	suite.assert.Equal("a", cache.children[0].Name)
+}
+
+func (suite *libfuseTestSuite) TestNotifier() {
+	defer suite.cleanupTest()
+
+	// Without a mount the kernel is not notified, nothing shall block
+	suite.libfuse.startNotifier()
+	invalidation.Notify(invalidation.Event{Path: "a"})
+	invalidation.Notify(invalidation.Event{Path: "dir", Dir: true})
+	suite.libfuse.stopNotifier()
+	suite.assert.Nil(suite.libfuse.notifyCh)
+
+	// Changes found after stop are not queued
+	invalidation.Notify(invalidation.Event{Path: "a"})
+	suite.libfuse.stopNotifier()
+
+	// Changes are dropped once the queue is full instead of blocking the pipeline
+	suite.libfuse.notifyCh = make(chan invalidation.Event, 1)
+	suite.libfuse.queueNotify(invalidation.Event{Path: "a"})
+	suite.libfuse.queueNotify(invalidation.Event{Path: "b"})
+	suite.assert.Len(suite.libfuse.notifyCh, 1)
+	suite.assert.Equal("a", (<-suite.libfuse.notifyCh).Path)
+	suite.libfuse.notifyCh = nil
+}
+
 func (suite *libfuseTestSuite) TestRmDir() {
 	testRmDir(suite)
 }
@@ -181,6 +278,10 @@ func (suite *libfuseTestSuite) TestCreate() {
 	testCreate(suite)
 }
 
+func (suite *libfuseTestSuite) TestCreateOwner() {
+	testCreateOwner(suite)
+}
+
 func (suite *libfuseTestSuite) TestCreateError() {
 	testCreateError(suite)
 }
@@ -209,6 +310,10 @@ func (suite *libfuseTestSuite) TestOpenNotExists() {
 	testOpenNotExists(suite)
 }
 
+func (suite *libfuseTestSuite) TestOpenArchived() {
+	testOpenArchived(suite)
+}
+
 func (suite *libfuseTestSuite) TestOpenError() {
 	testOpenError(suite)
 }
@@ -241,6 +346,14 @@ func (suite *libfuseTestSuite) TestUnlinkError() {
 	testUnlinkError(suite)
 }
 
+func (suite *libfuseTestSuite) TestUnlinkReadOnly() {
+	testUnlinkReadOnly(suite)
+}
+
+func (suite *libfuseTestSuite) TestUnlinkStale() {
+	testUnlinkStale(suite)
+}
+
 // rename
 
 func (suite *libfuseTestSuite) TestSymlink() {
@@ -303,10 +416,66 @@ func (suite *libfuseTestSuite) TestChown() {
 	testChown(suite)
 }
 
+func (suite *libfuseTestSuite) TestChownUnchangedOwner() {
+	testChownUnchangedOwner(suite)
+}
+
+func (suite *libfuseTestSuite) TestChownNotExists() {
+	testChownNotExists(suite)
+}
+
 func (suite *libfuseTestSuite) TestUtimens() {
 	testUtimens(suite)
 }
 
+func (suite *libfuseTestSuite) TestUtimensTimes() {
+	testUtimensTimes(suite)
+}
+
+func (suite *libfuseTestSuite) TestUtimensOmit() {
+	testUtimensOmit(suite)
+}
+
+func (suite *libfuseTestSuite) TestUtimensNotExists() {
+	testUtimensNotExists(suite)
+}
+
+func (suite *libfuseTestSuite) TestSetXattr() {
+	testSetXattr(suite)
+}
+
+func (suite *libfuseTestSuite) TestGetXattr() {
+	testGetXattr(suite)
+}
+
+func (suite *libfuseTestSuite) TestGetXattrNonUser() {
+	testGetXattrNonUser(suite)
+}
+
+func (suite *libfuseTestSuite) TestListXattr() {
+	testListXattr(suite)
+}
+
+func (suite *libfuseTestSuite) TestRemoveXattr() {
+	testRemoveXattr(suite)
+}
+
+func (suite *libfuseTestSuite) TestFlock() {
+	testFlock(suite)
+}
+
+func (suite *libfuseTestSuite) TestFlockBusy() {
+	testFlockBusy(suite)
+}
+
+func (suite *libfuseTestSuite) TestLock() {
+	testLock(suite)
+}
+
+func (suite *libfuseTestSuite) TestLocalLocks() {
+	testLocalLocks(suite)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestLibfuseTestSuite(t *testing.T) {
@@ -45,6 +45,7 @@ import (
 	"io/fs"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
@@ -99,6 +100,7 @@ func (suite *libfuseTestSuite) setupTestHelper(config string) {
 	suite.mock = internal.NewMockComponent(suite.mockCtrl)
 	suite.libfuse = newTestLibfuse(suite.mock, config)
 	fuseFS = suite.libfuse
+	callerOwner = func() (uint32, uint32, bool) { return 0, 0, false }
 	// suite.libfuse.Start(context.Background())
 }
 
@@ -133,6 +135,19 @@ func testMkDirError(suite *libfuseTestSuite) {
 	suite.assert.Equal(C.int(-C.EIO), err)
 }
 
+func testMkDirReadOnly(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := ".snapshots/2024-01-31T10:15:42.1234567Z/path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	mode := fs.FileMode(0775)
+	options := internal.CreateDirOptions{Name: name, Mode: mode}
+	suite.mock.EXPECT().CreateDir(options).Return(syscall.EROFS)
+
+	err := libfuse_mkdir(path, 0775)
+	suite.assert.Equal(C.int(-C.EROFS), err)
+}
+
 // TODO: ReadDir test
 
 func testRmDir(suite *libfuseTestSuite) {
@@ -197,6 +212,20 @@ func testCreate(suite *libfuseTestSuite) {
 	suite.assert.NotEqual(stbuf.st_mtim.tv_sec, C.long(0))
 }
 
+func testCreateOwner(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	info := &C.fuse_file_info_t{}
+	callerOwner = func() (uint32, uint32, bool) { return 1001, 1002, true }
+	options := internal.CreateFileOptions{Name: name, Mode: fs.FileMode(0644), Uid: 1001, Gid: 1002, OwnerSet: true}
+	suite.mock.EXPECT().CreateFile(options).Return(&handlemap.Handle{}, nil)
+
+	err := libfuse_create(path, 0644, info)
+	suite.assert.Equal(C.int(0), err)
+}
+
 func testCreateError(suite *libfuseTestSuite) {
 	defer suite.cleanupTest()
 	name := "path"
@@ -352,6 +381,28 @@ func testOpenNotExists(suite *libfuseTestSuite) {
 	suite.assert.Equal(C.int(-C.ENOENT), err)
 }
 
+func testOpenArchived(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	mode := fs.FileMode(fuseFS.filePermission)
+	flags := C.O_RDWR & 0xffffffff
+	info := &C.fuse_file_info_t{}
+	info.flags = C.O_RDWR
+	options := internal.OpenFileOptions{Name: name, Flags: flags, Mode: mode}
+
+	// Archived blob which is not being rehydrated
+	suite.mock.EXPECT().OpenFile(options).Return(nil, syscall.ENODATA)
+	err := libfuse_open(path, info)
+	suite.assert.Equal(C.int(-C.ENODATA), err)
+
+	// Rehydration of the blob is pending
+	suite.mock.EXPECT().OpenFile(options).Return(nil, syscall.EAGAIN)
+	err = libfuse_open(path, info)
+	suite.assert.Equal(C.int(-C.EAGAIN), err)
+}
+
 func testOpenError(suite *libfuseTestSuite) {
 	defer suite.cleanupTest()
 	name := "path"
@@ -430,6 +481,30 @@ func testUnlinkError(suite *libfuseTestSuite) {
 	suite.assert.Equal(C.int(-C.EIO), err)
 }
 
+func testUnlinkReadOnly(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := ".snapshots/2024-01-31T10:15:42.1234567Z/path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	options := internal.DeleteFileOptions{Name: name}
+	suite.mock.EXPECT().DeleteFile(options).Return(syscall.EROFS)
+
+	err := libfuse_unlink(path)
+	suite.assert.Equal(C.int(-C.EROFS), err)
+}
+
+func testUnlinkStale(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	options := internal.DeleteFileOptions{Name: name}
+	suite.mock.EXPECT().DeleteFile(options).Return(syscall.ESTALE)
+
+	err := libfuse_unlink(path)
+	suite.assert.Equal(C.int(-C.ESTALE), err)
+}
+
 // Rename
 
 func testSymlink(suite *libfuseTestSuite) {
@@ -664,17 +739,395 @@ func testChown(suite *libfuseTestSuite) {
 	defer C.free(unsafe.Pointer(path))
 	group := C.uint(5)
 	owner := C.uint(4)
+	options := internal.ChownOptions{Name: name, Owner: 4, Group: 5}
+	suite.mock.EXPECT().Chown(options).Return(nil)
 
 	err := libfuse_chown(path, owner, group, nil)
 	suite.assert.Equal(C.int(0), err)
 }
 
+func testChownUnchangedOwner(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	group := C.uint(5)
+	owner := ^C.uint(0)
+	attr := &internal.ObjAttr{Uid: 10, Gid: 11, Flags: internal.NewFileBitMap()}
+	attr.Flags.Set(internal.PropFlagOwnerSet)
+	suite.mock.EXPECT().GetAttr(internal.GetAttrOptions{Name: name}).Return(attr, nil)
+	options := internal.ChownOptions{Name: name, Owner: 10, Group: 5}
+	suite.mock.EXPECT().Chown(options).Return(nil)
+
+	err := libfuse_chown(path, owner, group, nil)
+	suite.assert.Equal(C.int(0), err)
+}
+
+func testChownNotExists(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	group := C.uint(5)
+	owner := C.uint(4)
+	options := internal.ChownOptions{Name: name, Owner: 4, Group: 5}
+	suite.mock.EXPECT().Chown(options).Return(syscall.ENOENT)
+
+	err := libfuse_chown(path, owner, group, nil)
+	suite.assert.Equal(C.int(-C.ENOENT), err)
+}
+
 func testUtimens(suite *libfuseTestSuite) {
 	defer suite.cleanupTest()
 	name := "path"
 	path := C.CString("/" + name)
 	defer C.free(unsafe.Pointer(path))
 
+	suite.mock.EXPECT().SetAttr(gomock.Any()).Return(nil)
+
+	err := libfuse_utimens(path, nil, nil)
+	suite.assert.Equal(C.int(0), err)
+}
+
+func testUtimensTimes(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	tv := make([]C.timespec_t, 2)
+	tv[0].tv_nsec = C.UTIME_OMIT
+	tv[1].tv_sec = 1577934245
+	options := internal.SetAttrOptions{Name: name, Attr: &internal.ObjAttr{Mtime: time.Unix(1577934245, 0)}}
+	suite.mock.EXPECT().SetAttr(options).Return(nil)
+
+	err := libfuse_utimens(path, &tv[0], nil)
+	suite.assert.Equal(C.int(0), err)
+}
+
+func testUtimensOmit(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	tv := make([]C.timespec_t, 2)
+	tv[0].tv_nsec = C.UTIME_OMIT
+	tv[1].tv_nsec = C.UTIME_OMIT
+
+	// Nothing to change so storage shall not be called
+	err := libfuse_utimens(path, &tv[0], nil)
+	suite.assert.Equal(C.int(0), err)
+}
+
+func testUtimensNotExists(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	suite.mock.EXPECT().SetAttr(gomock.Any()).Return(syscall.ENOENT)
+
 	err := libfuse_utimens(path, nil, nil)
+	suite.assert.Equal(C.int(-C.ENOENT), err)
+}
+
+func testSetXattr(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.tag")
+	defer C.free(unsafe.Pointer(attr))
+	value := C.CString("blue")
+	defer C.free(unsafe.Pointer(value))
+
+	options := internal.SetXattrOptions{Name: name, Attr: "user.tag", Value: []byte("blue"), Flags: internal.XattrCreate}
+	suite.mock.EXPECT().SetXattr(options).Return(nil)
+	err := libfuse_setxattr(path, attr, value, 4, C.int(internal.XattrCreate))
+	suite.assert.Equal(C.int(0), err)
+
+	suite.mock.EXPECT().SetXattr(options).Return(syscall.EEXIST)
+	err = libfuse_setxattr(path, attr, value, 4, C.int(internal.XattrCreate))
+	suite.assert.Equal(C.int(-C.EEXIST), err)
+}
+
+func testGetXattr(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.tag")
+	defer C.free(unsafe.Pointer(attr))
+	buf := (*C.char)(C.malloc(8))
+	defer C.free(unsafe.Pointer(buf))
+
+	options := internal.GetXattrOptions{Name: name, Attr: "user.tag"}
+
+	// Size query
+	suite.mock.EXPECT().GetXattr(options).Return([]byte("blue"), nil)
+	err := libfuse_getxattr(path, attr, nil, 0)
+	suite.assert.Equal(C.int(4), err)
+
+	suite.mock.EXPECT().GetXattr(options).Return([]byte("blue"), nil)
+	err = libfuse_getxattr(path, attr, buf, 8)
+	suite.assert.Equal(C.int(4), err)
+	suite.assert.Equal([]byte("blue"), C.GoBytes(unsafe.Pointer(buf), 4))
+
+	// Buffer too small
+	suite.mock.EXPECT().GetXattr(options).Return([]byte("blue"), nil)
+	err = libfuse_getxattr(path, attr, buf, 2)
+	suite.assert.Equal(C.int(-C.ERANGE), err)
+
+	suite.mock.EXPECT().GetXattr(options).Return(nil, syscall.ENODATA)
+	err = libfuse_getxattr(path, attr, buf, 8)
+	suite.assert.Equal(C.int(-C.ENODATA), err)
+}
+
+func testGetXattrNonUser(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("security.capability")
+	defer C.free(unsafe.Pointer(attr))
+
+	// No call shall reach the pipeline
+	err := libfuse_getxattr(path, attr, nil, 0)
+	suite.assert.Equal(C.int(-C.ENODATA), err)
+}
+
+func testListXattr(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	buf := (*C.char)(C.malloc(32))
+	defer C.free(unsafe.Pointer(buf))
+
+	options := internal.ListXattrOptions{Name: name}
+
+	suite.mock.EXPECT().ListXattr(options).Return([]string{"user.a", "user.bc"}, nil)
+	err := libfuse_listxattr(path, nil, 0)
+	suite.assert.Equal(C.int(15), err)
+
+	suite.mock.EXPECT().ListXattr(options).Return([]string{"user.a", "user.bc"}, nil)
+	err = libfuse_listxattr(path, buf, 32)
+	suite.assert.Equal(C.int(15), err)
+	suite.assert.Equal([]byte("user.a\x00user.bc\x00"), C.GoBytes(unsafe.Pointer(buf), 15))
+
+	suite.mock.EXPECT().ListXattr(options).Return(nil, syscall.ENOENT)
+	err = libfuse_listxattr(path, buf, 32)
+	suite.assert.Equal(C.int(-C.ENOENT), err)
+}
+
+func testRemoveXattr(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	attr := C.CString("user.tag")
+	defer C.free(unsafe.Pointer(attr))
+
+	options := internal.RemoveXattrOptions{Name: name, Attr: "user.tag"}
+	suite.mock.EXPECT().RemoveXattr(options).Return(nil)
+	err := libfuse_removexattr(path, attr)
+	suite.assert.Equal(C.int(0), err)
+
+	suite.mock.EXPECT().RemoveXattr(options).Return(errors.New("failed to remove xattr"))
+	err = libfuse_removexattr(path, attr)
+	suite.assert.Equal(C.int(-C.EIO), err)
+}
+
+// openTestFile opens a file through libfuse and returns the fuse info and the handle behind it
+func openTestFile(suite *libfuseTestSuite, name string, path *C.char) (*C.fuse_file_info_t, *handlemap.Handle) {
+	mode := fs.FileMode(fuseFS.filePermission)
+	flags := C.O_RDWR & 0xffffffff
+	info := &C.fuse_file_info_t{}
+	info.flags = C.O_RDWR
+	openOptions := internal.OpenFileOptions{Name: name, Flags: flags, Mode: mode}
+	suite.mock.EXPECT().OpenFile(openOptions).Return(handlemap.NewHandle(name), nil)
+	libfuse_open(path, info)
+
+	fobj := (*fileHandle)(unsafe.Pointer(uintptr(info.fh)))
+	return info, (*handlemap.Handle)(unsafe.Pointer(uintptr(fobj.obj)))
+}
+
+func testFlock(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	info, handle := openTestFile(suite, name, path)
+
+	// Lease is taken once for the handle however many times it is locked
+	acquireOptions := internal.AcquireLeaseOptions{Name: name, Duration: internal.LeaseDuration}
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	err := libfuse_flock(path, info, C.LOCK_EX)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.True(handle.Locked())
+	err = libfuse_flock(path, info, C.LOCK_EX)
+	suite.assert.Equal(C.int(0), err)
+
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	err = libfuse_flock(path, info, C.LOCK_UN)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.False(handle.Locked())
+
+	// Shared lock is not held in storage, it only has to find the lease free
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	err = libfuse_flock(path, info, C.LOCK_SH)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.False(handle.Locked())
+
+	// Converting the exclusive lock to a shared one releases the lease
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	err = libfuse_flock(path, info, C.LOCK_EX)
+	suite.assert.Equal(C.int(0), err)
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	err = libfuse_flock(path, info, C.LOCK_SH)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.False(handle.Locked())
+}
+
+func testFlockBusy(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	info, handle := openTestFile(suite, name, path)
+
+	acquireOptions := internal.AcquireLeaseOptions{Name: name, Duration: internal.LeaseDuration}
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(syscall.EBUSY)
+	err := libfuse_flock(path, info, C.LOCK_EX|C.LOCK_NB)
+	suite.assert.Equal(C.int(-C.EAGAIN), err)
+	suite.assert.False(handle.Locked())
+
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(syscall.ENOTSUP)
+	err = libfuse_flock(path, info, C.LOCK_EX)
+	suite.assert.Equal(C.int(-C.ENOLCK), err)
+}
+
+func testLocalLocks(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	info1, handle1 := openTestFile(suite, name, path)
+	info2, handle2 := openTestFile(suite, name, path)
+	info1.lock_owner = 1
+	info2.lock_owner = 2
+
+	// Lease is taken once by the mount, locks of the handles conflict in the mount itself
+	acquireOptions := internal.AcquireLeaseOptions{Name: name, Duration: internal.LeaseDuration}
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	err := libfuse_flock(path, info1, C.LOCK_EX|C.LOCK_NB)
+	suite.assert.Equal(C.int(0), err)
+	err = libfuse_flock(path, info2, C.LOCK_EX|C.LOCK_NB)
+	suite.assert.Equal(C.int(-C.EAGAIN), err)
+	err = libfuse_flock(path, info2, C.LOCK_SH|C.LOCK_NB)
+	suite.assert.Equal(C.int(-C.EAGAIN), err)
+	suite.assert.False(handle2.Locked())
+
+	lock := &C.flock_t{}
+	lock.l_type = C.F_WRLCK
+	err = libfuse_lock(path, info1, C.F_SETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+
+	// Write lock of another owner is reported without asking storage
+	lock.l_type = C.F_RDLCK
+	err = libfuse_lock(path, info2, C.F_GETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.EqualValues(C.F_WRLCK, lock.l_type)
+	lock.l_type = C.F_RDLCK
+	err = libfuse_lock(path, info2, C.F_SETLK, lock)
+	suite.assert.Equal(C.int(-C.EAGAIN), err)
+
+	// Blocking lock waits till the lock of the other handle is released
+	done := make(chan C.int)
+	go func() {
+		done <- libfuse_flock(path, info2, C.LOCK_EX)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		suite.assert.Fail("lock taken while held through another handle")
+	default:
+	}
+
+	// Lease stays with the first handle for its write lock
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	err = libfuse_flock(path, info1, C.LOCK_UN)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.Equal(C.int(0), <-done)
+	suite.assert.True(handle1.Locked())
+	suite.assert.True(handle2.Locked())
+
+	// Shared locks do not conflict with each other
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	err = libfuse_flock(path, info2, C.LOCK_SH|C.LOCK_NB)
+	suite.assert.Equal(C.int(0), err)
+	err = libfuse_flock(path, info1, C.LOCK_SH|C.LOCK_NB)
+	suite.assert.Equal(C.int(0), err)
+
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	suite.mock.EXPECT().CloseFile(internal.CloseFileOptions{Handle: handle1}).Return(nil)
+	err = libfuse_release(path, info1)
+	suite.assert.Equal(C.int(0), err)
+
+	// Locks of the closed handle are gone
+	lock.l_type = C.F_WRLCK
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	err = libfuse_lock(path, info2, C.F_SETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	suite.mock.EXPECT().CloseFile(internal.CloseFileOptions{Handle: handle2}).Return(nil)
+	err = libfuse_release(path, info2)
+	suite.assert.Equal(C.int(0), err)
+}
+
+func testLock(suite *libfuseTestSuite) {
+	defer suite.cleanupTest()
+	name := "path"
+	path := C.CString("/" + name)
+	defer C.free(unsafe.Pointer(path))
+	info, handle := openTestFile(suite, name, path)
+
+	// Lock is checked by taking the lease and releasing it right away
+	acquireOptions := internal.AcquireLeaseOptions{Name: name, Duration: internal.LeaseDuration}
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	lock := &C.flock_t{}
+	lock.l_type = C.F_WRLCK
+	err := libfuse_lock(path, info, C.F_GETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.EqualValues(C.F_UNLCK, lock.l_type)
+
+	// Lease held by another mount is reported as a lock on the whole file
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(syscall.EBUSY)
+	lock.l_type = C.F_RDLCK
+	lock.l_start = 10
+	lock.l_len = 20
+	err = libfuse_lock(path, info, C.F_GETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.EqualValues(C.F_WRLCK, lock.l_type)
+	suite.assert.EqualValues(0, lock.l_start)
+	suite.assert.EqualValues(0, lock.l_len)
+
+	suite.mock.EXPECT().AcquireLease(acquireOptions).Return(nil)
+	lock.l_type = C.F_WRLCK
+	err = libfuse_lock(path, info, C.F_SETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.True(handle.Locked())
+
+	// Own lock does not conflict
+	lock.l_type = C.F_WRLCK
+	err = libfuse_lock(path, info, C.F_GETLK, lock)
+	suite.assert.Equal(C.int(0), err)
+	suite.assert.EqualValues(C.F_UNLCK, lock.l_type)
+
+	// Lock held through the handle is dropped when it is closed
+	suite.mock.EXPECT().ReleaseLease(internal.ReleaseLeaseOptions{Name: name}).Return(nil)
+	suite.mock.EXPECT().CloseFile(internal.CloseFileOptions{Handle: handle}).Return(nil)
+	err = libfuse_release(path, info)
 	suite.assert.Equal(C.int(0), err)
 }
@@ -41,9 +41,11 @@
 #include <string.h>
 #include <linux/fs.h>
 #include <sys/types.h>
+#include <sys/stat.h>
 #include <errno.h>
 #include <dlfcn.h>
 #include <fcntl.h>
+#include <sys/file.h>
 #include <unistd.h>
 
 // Decide whether to add fuse2 or fuse3
@@ -93,6 +95,11 @@ static int populate_callbacks(fuse_operations_t *opt)
     opt->fsync      = (int (*)(const char *path, int, fuse_file_info_t *fi))libfuse_fsync;
     opt->fsyncdir   = (int (*)(const char *path, int, fuse_file_info_t *))libfuse_fsyncdir;
 
+    opt->setxattr   = (int (*)(const char *path, const char *name, const char *value, size_t size, int flags))libfuse_setxattr;
+    opt->getxattr   = (int (*)(const char *path, const char *name, char *value, size_t size))libfuse_getxattr;
+    opt->listxattr  = (int (*)(const char *path, char *list, size_t size))libfuse_listxattr;
+    opt->removexattr = (int (*)(const char *path, const char *name))libfuse_removexattr;
+
 
     #ifdef __FUSE2__
     opt->init       = (void *(*)(fuse_conn_info_t *))libfuse2_init;
@@ -118,6 +125,15 @@ static int populate_callbacks(fuse_operations_t *opt)
     return 0;
 }
 
+// Method to register the lock callbacks, these are served only when files can be locked across mounts
+static int populate_lock_callbacks(fuse_operations_t *opt)
+{
+    opt->flock      = (int (*)(const char *path, fuse_file_info_t *fi, int op))libfuse_flock;
+    opt->lock       = (int (*)(const char *path, fuse_file_info_t *fi, int cmd, flock_t *lock))libfuse_lock;
+
+    return 0;
+}
+
 static fuse_options_t fuse_opts;
 static bool context_populated = false;
 
@@ -150,6 +166,28 @@ static void populate_uid_gid()
     }
 }
 
+// Get uid and gid of the process making the current request, returns -1 when called outside of a request
+static int get_caller_uid_gid(uid_t *uid, gid_t *gid)
+{
+    struct fuse_context *ctx = fuse_get_context();
+    if (ctx == NULL)
+        return -1;
+
+    *uid = ctx->uid;
+    *gid = ctx->gid;
+    return 0;
+}
+
+// Get pid of the process making the current request, returns 0 when called outside of a request
+static pid_t get_caller_pid()
+{
+    struct fuse_context *ctx = fuse_get_context();
+    if (ctx == NULL)
+        return 0;
+
+    return ctx->pid;
+}
+
 // Properties for root (/) are static so just hardcoding them here
 static int get_root_properties(stat_t *stbuf)
 {
@@ -166,6 +204,31 @@ static int get_root_properties(stat_t *stbuf)
     return 0;
 }
 
+#ifndef __FUSE2__
+// Fuse instance of the mount, needed to notify the kernel of changes outside of any callback
+static struct fuse *fuse_instance = NULL;
+
+// Save the fuse instance from fuse context, called from init callback
+static void save_fuse_instance()
+{
+    fuse_instance = fuse_get_context()->fuse;
+}
+
+static void clear_fuse_instance()
+{
+    fuse_instance = NULL;
+}
+
+// Ask the kernel to drop cached attributes, data and directory entry of the path
+static int invalidate_path(const char *path)
+{
+    if (fuse_instance == NULL)
+        return -ENOENT;
+
+    return fuse_invalidate_path(fuse_instance, path);
+}
+#endif
+
 static int fill_dir_entry(fuse_fill_dir_t filler, void *buf, char *name, stat_t *stbuf, off_t off)
 {
     return filler(buf, name, stbuf, off
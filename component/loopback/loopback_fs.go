@@ -461,6 +461,65 @@ func (lfs *LoopbackFS) Chown(options internal.ChownOptions) error {
 	return os.Chown(path, options.Owner, options.Group)
 }
 
+func (lfs *LoopbackFS) SetAttr(options internal.SetAttrOptions) error {
+	log.Trace("LoopbackFS::SetAttr : name=%s", options.Name)
+	path := filepath.Join(lfs.path, options.Name)
+	return os.Chtimes(path, options.Attr.Atime, options.Attr.Mtime)
+}
+
+func (lfs *LoopbackFS) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	log.Trace("LoopbackFS::GetXattr : name=%s, attr=%s", options.Name, options.Attr)
+	path := filepath.Join(lfs.path, options.Name)
+
+	size, err := syscall.Getxattr(path, options.Attr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, options.Attr, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+func (lfs *LoopbackFS) SetXattr(options internal.SetXattrOptions) error {
+	log.Trace("LoopbackFS::SetXattr : name=%s, attr=%s", options.Name, options.Attr)
+	path := filepath.Join(lfs.path, options.Name)
+	return syscall.Setxattr(path, options.Attr, options.Value, options.Flags)
+}
+
+func (lfs *LoopbackFS) ListXattr(options internal.ListXattrOptions) ([]string, error) {
+	log.Trace("LoopbackFS::ListXattr : name=%s", options.Name)
+	path := filepath.Join(lfs.path, options.Name)
+
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (lfs *LoopbackFS) RemoveXattr(options internal.RemoveXattrOptions) error {
+	log.Trace("LoopbackFS::RemoveXattr : name=%s, attr=%s", options.Name, options.Attr)
+	path := filepath.Join(lfs.path, options.Name)
+	return syscall.Removexattr(path, options.Attr)
+}
+
 func (lfs *LoopbackFS) StageData(options internal.StageDataOptions) error {
 	log.Trace("LoopbackFS::StageData : name=%s, id=%s", options.Name, options.Id)
 	path := fmt.Sprintf("%s_%s", filepath.Join(lfs.path, options.Name), strings.ReplaceAll(options.Id, "/", "_"))
@@ -38,7 +38,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-storage-fuse/v2/common"
 	"github.com/Azure/azure-storage-fuse/v2/internal"
@@ -284,6 +286,41 @@ func (suite *LoopbackFSTestSuite) TestGetAttr() {
 	assert.Equal(attr.IsDir(), info.IsDir())
 }
 
+func (suite *LoopbackFSTestSuite) TestXattr() {
+	defer suite.cleanupTest()
+	assert := assert.New(suite.T())
+
+	err := suite.lfs.SetXattr(internal.SetXattrOptions{Name: fileLorem, Attr: "user.tag", Value: []byte("blue")})
+	assert.Nil(err)
+
+	value, err := suite.lfs.GetXattr(internal.GetXattrOptions{Name: fileLorem, Attr: "user.tag"})
+	assert.Nil(err)
+	assert.Equal([]byte("blue"), value)
+
+	names, err := suite.lfs.ListXattr(internal.ListXattrOptions{Name: fileLorem})
+	assert.Nil(err)
+	assert.Contains(names, "user.tag")
+
+	err = suite.lfs.RemoveXattr(internal.RemoveXattrOptions{Name: fileLorem, Attr: "user.tag"})
+	assert.Nil(err)
+
+	_, err = suite.lfs.GetXattr(internal.GetXattrOptions{Name: fileLorem, Attr: "user.tag"})
+	assert.Equal(syscall.ENODATA, err)
+}
+
+func (suite *LoopbackFSTestSuite) TestSetAttr() {
+	defer suite.cleanupTest()
+	assert := assert.New(suite.T())
+
+	mtime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	err := suite.lfs.SetAttr(internal.SetAttrOptions{Name: fileLorem, Attr: &internal.ObjAttr{Mtime: mtime}})
+	assert.Nil(err)
+
+	attr, err := suite.lfs.GetAttr(internal.GetAttrOptions{Name: fileLorem})
+	assert.Nil(err)
+	assert.True(mtime.Equal(attr.Mtime))
+}
+
 func (suite *LoopbackFSTestSuite) TestStageAndCommitData() {
 	defer suite.cleanupTest()
 	assert := assert.New(suite.T())
@@ -0,0 +1,1026 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+)
+
+// MemStore component Config specifications:
+//
+//	memstore:
+//		max-size-mb: <limit on data held in memory>
+//		latency-ms: <delay added to every call>
+//		failure-rate: <percentage of calls failing with EIO>
+//		fail-ops: <list of calls to inject failures in to>
+//
+// MemStore keeps blobs in memory with the semantics of a block blob container: staged and committed blocks,
+// ETags and metadata. Nothing is persisted, everything is lost when the mount goes away.
+
+const compName = "memstore"
+
+type MemStore struct {
+	internal.BaseComponent
+
+	sync.RWMutex
+	store *memStore
+
+	latency               time.Duration
+	failureRate           float64
+	failOps               map[string]bool
+	rand                  *rand.Rand
+	randLock              sync.Mutex
+	optimisticConcurrency bool
+}
+
+var _ internal.Component = &MemStore{}
+
+type MemStoreOptions struct {
+	MaxSizeMB             uint64   `config:"max-size-mb" yaml:"max-size-mb,omitempty"`
+	LatencyMs             uint64   `config:"latency-ms" yaml:"latency-ms,omitempty"`
+	FailureRate           float64  `config:"failure-rate" yaml:"failure-rate,omitempty"`
+	FailOps               []string `config:"fail-ops" yaml:"fail-ops,omitempty"`
+	FailureSeed           int64    `config:"failure-seed" yaml:"failure-seed,omitempty"`
+	OptimisticConcurrency bool     `config:"optimistic-concurrency" yaml:"optimistic-concurrency,omitempty"`
+}
+
+// Calls which touch the store and so can be delayed or failed
+var storeOps = []string{
+	"CreateDir", "DeleteDir", "IsDirEmpty", "StreamDir", "RenameDir",
+	"CreateFile", "DeleteFile", "OpenFile", "RenameFile",
+	"ReadFile", "ReadInBuffer", "WriteFile", "TruncateFile", "CopyToFile", "CopyFromFile",
+	"CreateLink", "ReadLink", "GetAttr", "SetAttr",
+	"GetXattr", "SetXattr", "ListXattr", "RemoveXattr",
+	"GetFileBlockOffsets", "GetCommittedBlockList", "StageData", "CommitData",
+}
+
+func (ms *MemStore) Name() string {
+	return compName
+}
+
+func (ms *MemStore) Priority() internal.ComponentPriority {
+	return internal.EComponentPriority.Consumer()
+}
+
+// Configure : Pipeline will call this method after constructor so that you can read config and initialize yourself
+func (ms *MemStore) Configure(_ bool) error {
+	log.Trace("MemStore::Configure : %s", ms.Name())
+
+	conf := MemStoreOptions{}
+	err := config.UnmarshalKey(ms.Name(), &conf)
+	if err != nil {
+		log.Err("MemStore::Configure : config error [invalid config attributes]")
+		return fmt.Errorf("config error in %s [%s]", ms.Name(), err.Error())
+	}
+
+	if conf.FailureRate < 0 || conf.FailureRate > 100 {
+		log.Err("MemStore::Configure : config error [failure-rate %v is not a percentage]", conf.FailureRate)
+		return fmt.Errorf("config error in %s [failure-rate must be between 0 and 100]", ms.Name())
+	}
+
+	ms.failOps = make(map[string]bool)
+	for _, op := range conf.FailOps {
+		known := false
+		for _, o := range storeOps {
+			if strings.EqualFold(o, op) {
+				ms.failOps[o] = true
+				known = true
+			}
+		}
+		if !known {
+			log.Err("MemStore::Configure : config error [unknown operation %s in fail-ops]", op)
+			return fmt.Errorf("config error in %s [unknown operation %s in fail-ops]", ms.Name(), op)
+		}
+	}
+
+	seed := conf.FailureSeed
+	if !config.IsSet(compName + ".failure-seed") {
+		seed = time.Now().UnixNano()
+	}
+
+	ms.store = newMemStore(int64(conf.MaxSizeMB * common.MbToBytes))
+	ms.latency = time.Duration(conf.LatencyMs) * time.Millisecond
+	ms.failureRate = conf.FailureRate
+	ms.rand = rand.New(rand.NewSource(seed))
+	ms.optimisticConcurrency = conf.OptimisticConcurrency
+
+	log.Crit("MemStore::Configure : max-size %v MB, latency %v, failure-rate %v%%, fail-ops %v, seed %v, optimistic-concurrency %v",
+		conf.MaxSizeMB, ms.latency, ms.failureRate, conf.FailOps, seed, ms.optimisticConcurrency)
+	return nil
+}
+
+func (ms *MemStore) Start(ctx context.Context) error {
+	log.Trace("MemStore::Start : Starting component %s", ms.Name())
+	return nil
+}
+
+func (ms *MemStore) Stop() error {
+	log.Trace("MemStore::Stop : Stopping component %s", ms.Name())
+	return nil
+}
+
+// inject : Add the configured latency to the call and fail it if it is picked for a failure
+func (ms *MemStore) inject(op string) error {
+	if ms.latency > 0 {
+		time.Sleep(ms.latency)
+	}
+
+	if ms.failureRate == 0 || (len(ms.failOps) > 0 && !ms.failOps[op]) {
+		return nil
+	}
+
+	ms.randLock.Lock()
+	fail := ms.rand.Float64()*100 < ms.failureRate
+	ms.randLock.Unlock()
+
+	if fail {
+		log.Warn("MemStore::inject : Failing %s", op)
+		return syscall.EIO
+	}
+	return nil
+}
+
+// checkETag : With optimistic concurrency a change is allowed only if the blob still has the etag it was seen with
+func (ms *MemStore) checkETag(blob *memBlob, etag *string) error {
+	if !ms.optimisticConcurrency || etag == nil || *etag == "" {
+		return nil
+	}
+	if blob == nil || blob.etag != *etag {
+		return syscall.ESTALE
+	}
+	return nil
+}
+
+// touch : A metadata change gives the blob a new etag, which the caller gets if it knew the blob before the change
+func (ms *MemStore) touch(blob *memBlob, etag *string) {
+	before := blob.etag
+	blob.etag = ms.store.nextETag()
+	if etag != nil && *etag != "" && *etag == before {
+		*etag = blob.etag
+	}
+}
+
+// ------------------------- Core Operations -------------------------------------------
+
+// Directory operations
+func (ms *MemStore) CreateDir(options internal.CreateDirOptions) error {
+	log.Trace("MemStore::CreateDir : %s", options.Name)
+	if err := ms.inject("CreateDir"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, err := ms.store.put(internal.TruncateDirName(options.Name), nil, nil)
+	if err != nil {
+		return err
+	}
+	blob.isDir = true
+	return nil
+}
+
+// DeleteDir : Delete every blob under the directory along with its marker
+func (ms *MemStore) DeleteDir(options internal.DeleteDirOptions) error {
+	log.Trace("MemStore::DeleteDir : %s", options.Name)
+	if err := ms.inject("DeleteDir"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	name := internal.TruncateDirName(options.Name)
+	for _, child := range ms.store.list(dirPrefix(name)) {
+		ms.store.remove(child)
+	}
+	ms.store.remove(name)
+	return nil
+}
+
+func (ms *MemStore) IsDirEmpty(options internal.IsDirEmptyOptions) bool {
+	log.Trace("MemStore::IsDirEmpty : %s", options.Name)
+	if err := ms.inject("IsDirEmpty"); err != nil {
+		return false
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+	return !ms.store.hasChildren(internal.TruncateDirName(options.Name))
+}
+
+func (ms *MemStore) ReadDir(options internal.ReadDirOptions) ([]*internal.ObjAttr, error) {
+	log.Trace("MemStore::ReadDir : %s", options.Name)
+	attrs, _, err := ms.StreamDir(internal.StreamDirOptions{Name: options.Name})
+	return attrs, err
+}
+
+// StreamDir : List the children of a directory, the token is the index of the next child to return
+func (ms *MemStore) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
+	log.Trace("MemStore::StreamDir : %s, token %s, count %d", options.Name, options.Token, options.Count)
+	if err := ms.inject("StreamDir"); err != nil {
+		return nil, "", err
+	}
+
+	start := 0
+	if options.Token != "" {
+		var err error
+		start, err = strconv.Atoi(options.Token)
+		if err != nil || start < 0 {
+			return nil, "", syscall.EINVAL
+		}
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	name := internal.TruncateDirName(options.Name)
+	if name != "" {
+		blob, found := ms.store.blobs[name]
+		if (found && !blob.isDir) || (!found && !ms.store.hasChildren(name)) {
+			return nil, "", syscall.ENOENT
+		}
+	}
+
+	// Children are the blobs right under the directory and the first level of names in deeper paths,
+	// a directory with a marker blob and other blobs under it is listed once
+	prefix := dirPrefix(name)
+	attrs := make([]*internal.ObjAttr, 0)
+	seen := make(map[string]bool)
+	for _, blobName := range ms.store.list(prefix) {
+		child := strings.SplitN(blobName[len(prefix):], "/", 2)[0]
+		path := prefix + child
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		if blob, found := ms.store.blobs[path]; found {
+			attrs = append(attrs, newAttr(path, blob))
+		} else {
+			attrs = append(attrs, newDirAttr(path))
+		}
+	}
+
+	if start >= len(attrs) {
+		return []*internal.ObjAttr{}, "", nil
+	}
+
+	end := len(attrs)
+	if options.Count > 0 && start+int(options.Count) < end {
+		end = start + int(options.Count)
+	}
+
+	token := ""
+	if end < len(attrs) {
+		token = strconv.Itoa(end)
+	}
+	return attrs[start:end], token, nil
+}
+
+func (ms *MemStore) RenameDir(options internal.RenameDirOptions) error {
+	log.Trace("MemStore::RenameDir : %s to %s", options.Src, options.Dst)
+	if err := ms.inject("RenameDir"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	src := internal.TruncateDirName(options.Src)
+	dst := internal.TruncateDirName(options.Dst)
+
+	names := ms.store.list(dirPrefix(src))
+	if _, found := ms.store.blobs[src]; found {
+		names = append(names, src)
+	}
+	if len(names) == 0 {
+		return syscall.ENOENT
+	}
+
+	for _, name := range names {
+		ms.move(name, dst+strings.TrimPrefix(name, src))
+	}
+	return nil
+}
+
+// move : Rename a blob, it gets a new etag like a copy in the service would
+func (ms *MemStore) move(src string, dst string) {
+	if src == dst {
+		return
+	}
+
+	blob := ms.store.blobs[src]
+	ms.store.remove(dst)
+	delete(ms.store.blobs, src)
+	ms.store.discardStaged(src)
+
+	blob.etag = ms.store.nextETag()
+	ms.store.blobs[dst] = blob
+}
+
+// File operations
+func (ms *MemStore) CreateFile(options internal.CreateFileOptions) (*handlemap.Handle, error) {
+	log.Trace("MemStore::CreateFile : %s", options.Name)
+	if err := ms.inject("CreateFile"); err != nil {
+		return nil, err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.store.discardStaged(options.Name)
+	_, err := ms.store.put(options.Name, []byte{}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// This handle will be added to handlemap by the first component in pipeline
+	handle := handlemap.NewHandle(options.Name)
+	handle.Mtime = time.Now()
+	return handle, nil
+}
+
+func (ms *MemStore) DeleteFile(options internal.DeleteFileOptions) error {
+	log.Trace("MemStore::DeleteFile : %s", options.Name)
+	if err := ms.inject("DeleteFile"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return syscall.ENOENT
+	}
+	if err := ms.checkETag(blob, &options.ETag); err != nil {
+		return err
+	}
+
+	ms.store.remove(options.Name)
+	return nil
+}
+
+func (ms *MemStore) OpenFile(options internal.OpenFileOptions) (*handlemap.Handle, error) {
+	log.Trace("MemStore::OpenFile : %s", options.Name)
+	if err := ms.inject("OpenFile"); err != nil {
+		return nil, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return nil, syscall.ENOENT
+	}
+
+	// This handle will be added to handlemap by the first component in pipeline
+	handle := handlemap.NewHandle(options.Name)
+	handle.Size = int64(len(blob.data))
+	handle.Mtime = blob.mtime
+	return handle, nil
+}
+
+func (ms *MemStore) RenameFile(options internal.RenameFileOptions) error {
+	log.Trace("MemStore::RenameFile : %s to %s", options.Src, options.Dst)
+	if err := ms.inject("RenameFile"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	if _, found := ms.store.blobs[options.Src]; !found {
+		return syscall.ENOENT
+	}
+
+	ms.move(options.Src, options.Dst)
+	return nil
+}
+
+func (ms *MemStore) ReadFile(options internal.ReadFileOptions) ([]byte, error) {
+	log.Trace("MemStore::ReadFile : %s", options.Handle.Path)
+	if err := ms.inject("ReadFile"); err != nil {
+		return nil, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Handle.Path]
+	if !found {
+		return nil, syscall.ENOENT
+	}
+	return append([]byte(nil), blob.data...), nil
+}
+
+func (ms *MemStore) ReadInBuffer(options internal.ReadInBufferOptions) (int, error) {
+	if err := ms.inject("ReadInBuffer"); err != nil {
+		return 0, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Handle.Path]
+	if !found {
+		return 0, syscall.ENOENT
+	}
+
+	// Reads pin the version they read whether or not writes are checked
+	if options.ETag != "" && blob.etag != options.ETag {
+		return 0, syscall.ESTALE
+	}
+
+	if options.Offset > int64(len(blob.data)) {
+		return 0, syscall.ERANGE
+	}
+
+	n := copy(options.Data, blob.data[options.Offset:])
+	if options.Checksums != nil {
+		*options.Checksums = common.GetRangeChecksums(blob.data[options.Offset : options.Offset+int64(n)])
+	}
+	return n, nil
+}
+
+// WriteFile : Writes in the middle of a blob upload it again as a whole, so the blob loses its block list
+func (ms *MemStore) WriteFile(options internal.WriteFileOptions) (int, error) {
+	if err := ms.inject("WriteFile"); err != nil {
+		return 0, err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Handle.Path]
+	if !found {
+		return 0, syscall.ENOENT
+	}
+
+	size := max(int64(len(blob.data)), options.Offset+int64(len(options.Data)))
+	data := make([]byte, size)
+	copy(data, blob.data)
+	copy(data[options.Offset:], options.Data)
+
+	metadata := blob.metadata
+	if options.Metadata != nil {
+		metadata = options.Metadata
+	}
+
+	_, err := ms.store.put(options.Handle.Path, data, metadata)
+	if err != nil {
+		return 0, err
+	}
+	return len(options.Data), nil
+}
+
+func (ms *MemStore) TruncateFile(options internal.TruncateFileOptions) error {
+	log.Trace("MemStore::TruncateFile : %s to %d bytes", options.Name, options.Size)
+	if err := ms.inject("TruncateFile"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return syscall.ENOENT
+	}
+
+	data := make([]byte, options.Size)
+	copy(data, blob.data)
+	_, err := ms.store.put(options.Name, data, blob.metadata)
+	return err
+}
+
+func (ms *MemStore) CopyToFile(options internal.CopyToFileOptions) error {
+	log.Trace("MemStore::CopyToFile : Read file %s", options.Name)
+	if err := ms.inject("CopyToFile"); err != nil {
+		return err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return syscall.ENOENT
+	}
+
+	if options.ETag != "" && blob.etag != options.ETag {
+		return syscall.ESTALE
+	}
+
+	if options.Offset > int64(len(blob.data)) {
+		return syscall.ERANGE
+	}
+	data := blob.data[options.Offset:]
+	if options.Count > 0 && options.Count < int64(len(data)) {
+		data = data[:options.Count]
+	}
+
+	_, err := options.File.WriteAt(data, 0)
+	return err
+}
+
+// CopyFromFile : Upload the file in a single request. Like azstorage, the metadata of the blob is kept unless
+// the caller gives the metadata to upload with.
+func (ms *MemStore) CopyFromFile(options internal.CopyFromFileOptions) error {
+	log.Trace("MemStore::CopyFromFile : Upload file %s", options.Name)
+	if err := ms.inject("CopyFromFile"); err != nil {
+		return err
+	}
+
+	stat, err := options.File.Stat()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, stat.Size())
+	_, err = options.File.ReadAt(data, 0)
+	if err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if found || (options.ETag != nil && *options.ETag != "") {
+		if err = ms.checkETag(blob, options.ETag); err != nil {
+			return err
+		}
+	}
+
+	metadata := options.Metadata
+	if metadata == nil && blob != nil {
+		metadata = blob.metadata
+	}
+
+	ms.store.discardStaged(options.Name)
+	blob, err = ms.store.put(options.Name, data, metadata)
+	if err != nil {
+		return err
+	}
+
+	if options.ETag != nil {
+		*options.ETag = blob.etag
+	}
+	return nil
+}
+
+// Symlink operations
+func (ms *MemStore) CreateLink(options internal.CreateLinkOptions) error {
+	log.Trace("MemStore::CreateLink : Create symlink %s -> %s", options.Name, options.Target)
+	if err := ms.inject("CreateLink"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, err := ms.store.put(options.Name, []byte(options.Target), nil)
+	if err != nil {
+		return err
+	}
+	blob.isSymlink = true
+	return nil
+}
+
+func (ms *MemStore) ReadLink(options internal.ReadLinkOptions) (string, error) {
+	log.Trace("MemStore::ReadLink : Read symlink %s", options.Name)
+	if err := ms.inject("ReadLink"); err != nil {
+		return "", err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return "", syscall.ENOENT
+	}
+	return string(blob.data), nil
+}
+
+// Attribute operations
+func (ms *MemStore) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr, error) {
+	if err := ms.inject("GetAttr"); err != nil {
+		return nil, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	name := internal.TruncateDirName(options.Name)
+	if name == "" {
+		return newDirAttr(""), nil
+	}
+
+	if blob, found := ms.store.blobs[name]; found {
+		return newAttr(name, blob), nil
+	}
+
+	// A directory need not have a marker blob, blobs under its path are enough
+	if ms.store.hasChildren(name) {
+		return newDirAttr(name), nil
+	}
+	return nil, syscall.ENOENT
+}
+
+// Blobs carry no posix permissions, so like a block blob account mode and ownership changes are ignored
+func (ms *MemStore) Chmod(options internal.ChmodOptions) error {
+	log.Trace("MemStore::Chmod : Change mod of file %s", options.Name)
+	return nil
+}
+
+func (ms *MemStore) Chown(options internal.ChownOptions) error {
+	log.Trace("MemStore::Chown : Change ownership of file %s to %d-%d", options.Name, options.Owner, options.Group)
+	return nil
+}
+
+func (ms *MemStore) SetAttr(options internal.SetAttrOptions) error {
+	log.Trace("MemStore::SetAttr : Change times of %s to %v-%v", options.Name, options.Attr.Atime, options.Attr.Mtime)
+	if err := ms.inject("SetAttr"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return syscall.ENOENT
+	}
+
+	if !options.Attr.Atime.IsZero() {
+		blob.atime = options.Attr.Atime
+	}
+	if !options.Attr.Mtime.IsZero() {
+		blob.mtime = options.Attr.Mtime
+	}
+	ms.touch(blob, options.ETag)
+	return nil
+}
+
+// Extended attributes in user namespace are kept as metadata of the blob
+func (ms *MemStore) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	log.Trace("MemStore::GetXattr : Get %s of %s", options.Attr, options.Name)
+	if err := ms.inject("GetXattr"); err != nil {
+		return nil, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return nil, syscall.ENOENT
+	}
+
+	key, ok := metadataKey(options.Attr)
+	if !ok || blob.metadata[key] == nil {
+		return nil, syscall.ENODATA
+	}
+	return []byte(*blob.metadata[key]), nil
+}
+
+func (ms *MemStore) SetXattr(options internal.SetXattrOptions) error {
+	log.Trace("MemStore::SetXattr : Set %s of %s", options.Attr, options.Name)
+	if err := ms.inject("SetXattr"); err != nil {
+		return err
+	}
+
+	key, ok := metadataKey(options.Attr)
+	if !ok {
+		return syscall.ENOTSUP
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return syscall.ENOENT
+	}
+
+	_, exists := blob.metadata[key]
+	if exists && options.Flags&internal.XattrCreate != 0 {
+		return syscall.EEXIST
+	} else if !exists && options.Flags&internal.XattrReplace != 0 {
+		return syscall.ENODATA
+	}
+
+	value := string(options.Value)
+	blob.metadata[key] = &value
+	ms.touch(blob, options.ETag)
+	return nil
+}
+
+func (ms *MemStore) ListXattr(options internal.ListXattrOptions) ([]string, error) {
+	log.Trace("MemStore::ListXattr : List attributes of %s", options.Name)
+	if err := ms.inject("ListXattr"); err != nil {
+		return nil, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return nil, syscall.ENOENT
+	}
+
+	names := make([]string, 0, len(blob.metadata))
+	for k := range blob.metadata {
+		names = append(names, xattrPrefix+k)
+	}
+	return names, nil
+}
+
+func (ms *MemStore) RemoveXattr(options internal.RemoveXattrOptions) error {
+	log.Trace("MemStore::RemoveXattr : Remove %s of %s", options.Attr, options.Name)
+	if err := ms.inject("RemoveXattr"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return syscall.ENOENT
+	}
+
+	key, ok := metadataKey(options.Attr)
+	if _, exists := blob.metadata[key]; !ok || !exists {
+		return syscall.ENODATA
+	}
+
+	delete(blob.metadata, key)
+	ms.touch(blob, options.ETag)
+	return nil
+}
+
+// Only this mount can see the store, so a lease is always granted
+func (ms *MemStore) AcquireLease(options internal.AcquireLeaseOptions) error {
+	return nil
+}
+
+func (ms *MemStore) RenewLease(options internal.RenewLeaseOptions) error {
+	return nil
+}
+
+func (ms *MemStore) ReleaseLease(options internal.ReleaseLeaseOptions) error {
+	return nil
+}
+
+// Block operations
+func (ms *MemStore) GetFileBlockOffsets(options internal.GetFileBlockOffsetsOptions) (*common.BlockOffsetList, error) {
+	if err := ms.inject("GetFileBlockOffsets"); err != nil {
+		return &common.BlockOffsetList{}, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[options.Name]
+	if !found {
+		return &common.BlockOffsetList{}, syscall.ENOENT
+	}
+
+	blockList := common.BlockOffsetList{ETag: blob.etag}
+
+	// if block list empty its a small file
+	if len(blob.blocks) == 0 {
+		blockList.Flags.Set(common.SmallFile)
+		return &blockList, nil
+	}
+
+	offset := int64(0)
+	for _, block := range blob.blocks {
+		blockList.BlockList = append(blockList.BlockList, &common.Block{
+			Id:         block.id,
+			StartIndex: offset,
+			EndIndex:   offset + block.size,
+		})
+		offset += block.size
+	}
+	blockList.BlockIdLength = common.GetIdLength(blockList.BlockList[0].Id)
+	return &blockList, nil
+}
+
+func (ms *MemStore) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
+	if err := ms.inject("GetCommittedBlockList"); err != nil {
+		return nil, err
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, found := ms.store.blobs[name]
+	if !found {
+		return nil, syscall.ENOENT
+	}
+
+	// if block list empty its a small file
+	if len(blob.blocks) == 0 {
+		return nil, nil
+	}
+
+	blockList := make(internal.CommittedBlockList, 0, len(blob.blocks))
+	offset := int64(0)
+	for _, block := range blob.blocks {
+		blockList = append(blockList, internal.CommittedBlock{
+			Id:     block.id,
+			Offset: offset,
+			Size:   uint64(block.size),
+		})
+		offset += block.size
+	}
+	return &blockList, nil
+}
+
+func (ms *MemStore) StageData(opt internal.StageDataOptions) error {
+	log.Trace("MemStore::StageData : %s, id %s, length %d", opt.Name, opt.Id, len(opt.Data))
+	if err := ms.inject("StageData"); err != nil {
+		return err
+	}
+
+	if opt.Checksum != nil {
+		sum := md5.Sum(opt.Data)
+		if !bytes.Equal(sum[:], opt.Checksum) {
+			log.Err("MemStore::StageData : Checksum of block %s of %s does not match its data", opt.Id, opt.Name)
+			return syscall.EIO
+		}
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	err := ms.store.stage(opt.Name, opt.Id, opt.Data)
+	if err != nil {
+		log.Err("MemStore::StageData : Failed to stage block %s of %s [%s]", opt.Id, opt.Name, err.Error())
+	}
+	return err
+}
+
+func (ms *MemStore) CommitData(opt internal.CommitDataOptions) error {
+	log.Trace("MemStore::CommitData : %s, %d blocks", opt.Name, len(opt.List))
+	if err := ms.inject("CommitData"); err != nil {
+		return err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+
+	if blob, found := ms.store.blobs[opt.Name]; found || (opt.ETag != nil && *opt.ETag != "") {
+		if err := ms.checkETag(blob, opt.ETag); err != nil {
+			log.Warn("MemStore::CommitData : %s was modified by someone else, can not commit", opt.Name)
+			return err
+		}
+	}
+
+	blob, err := ms.store.commit(opt.Name, opt.List)
+	if err != nil {
+		log.Err("MemStore::CommitData : Failed to commit blocks of %s [%s]", opt.Name, err.Error())
+		return err
+	}
+
+	if opt.ETag != nil {
+		*opt.ETag = blob.etag
+	}
+	return nil
+}
+
+// StatFs : Report the size limit of the store as the capacity of the file system
+func (ms *MemStore) StatFs() (*syscall.Statfs_t, bool, error) {
+	if ms.store.maxSize == 0 {
+		return nil, false, nil
+	}
+
+	ms.RLock()
+	defer ms.RUnlock()
+
+	const blockSize = 4096
+	free := uint64(max(ms.store.maxSize-ms.store.used, 0)) / blockSize
+	return &syscall.Statfs_t{
+		Bsize:   blockSize,
+		Frsize:  blockSize,
+		Blocks:  uint64(ms.store.maxSize) / blockSize,
+		Bfree:   free,
+		Bavail:  free,
+		Files:   1e9,
+		Ffree:   1e9,
+		Namelen: 255,
+	}, true, nil
+}
+
+// ------------------------- Helper methods -------------------------------------------
+
+const xattrPrefix = "user."
+
+// metadataKey : Metadata key holding the given extended attribute, only the user namespace is supported
+func metadataKey(attr string) (string, bool) {
+	if !strings.HasPrefix(attr, xattrPrefix) || len(attr) == len(xattrPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(attr, xattrPrefix), true
+}
+
+func newAttr(name string, blob *memBlob) *internal.ObjAttr {
+	attr := &internal.ObjAttr{
+		Path:     name,
+		Name:     filepath.Base(name),
+		Size:     int64(len(blob.data)),
+		Mode:     0,
+		Mtime:    blob.mtime,
+		Atime:    blob.atime,
+		Ctime:    blob.mtime,
+		Crtime:   blob.crtime,
+		Flags:    internal.NewFileBitMap(),
+		ETag:     blob.etag,
+		Metadata: copyMetadata(blob.metadata),
+	}
+	if blob.isDir {
+		attr.Flags = internal.NewDirBitMap()
+		attr.Mode = os.ModeDir
+		attr.Size = 4096
+	} else if blob.isSymlink {
+		attr.Flags = internal.NewSymlinkBitMap()
+		attr.Mode = os.ModeSymlink
+	}
+	attr.Flags.Set(internal.PropFlagModeDefault)
+	return attr
+}
+
+// newDirAttr : Attributes of a directory which has no marker blob
+func newDirAttr(name string) *internal.ObjAttr {
+	return newAttr(name, &memBlob{isDir: true, mtime: time.Now(), atime: time.Now(), crtime: time.Now()})
+}
+
+// ------------------------- Factory methods to create objects -------------------------------------------
+
+// Constructor to create object of this component
+func NewMemStoreComponent() internal.Component {
+	ms := &MemStore{
+		store: newMemStore(0),
+	}
+	ms.SetName(compName)
+	return ms
+}
+
+// On init register this component to pipeline and supply your constructor
+func init() {
+	internal.AddComponent(compName, NewMemStoreComponent)
+}
@@ -0,0 +1,495 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/block_cache"
+	"github.com/Azure/azure-storage-fuse/v2/component/file_cache"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type memStoreTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	ms     *MemStore
+}
+
+func newTestMemStore(configuration string) (*MemStore, error) {
+	_ = config.ReadConfigFromReader(strings.NewReader(configuration))
+	ms := NewMemStoreComponent()
+	err := ms.Configure(true)
+	return ms.(*MemStore), err
+}
+
+func (suite *memStoreTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+	suite.setupTestHelper("")
+}
+
+func (suite *memStoreTestSuite) setupTestHelper(configuration string) {
+	suite.assert = assert.New(suite.T())
+
+	var err error
+	suite.ms, err = newTestMemStore(configuration)
+	suite.assert.NoError(err)
+	_ = suite.ms.Start(context.Background())
+}
+
+func (suite *memStoreTestSuite) cleanupTest() {
+	_ = suite.ms.Stop()
+}
+
+func blockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%016d", i)))
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	return data
+}
+
+func (suite *memStoreTestSuite) createFile(name string, data []byte) {
+	handle, err := suite.ms.CreateFile(internal.CreateFileOptions{Name: name})
+	suite.assert.NoError(err)
+	_, err = suite.ms.WriteFile(internal.WriteFileOptions{Handle: handle, Data: data})
+	suite.assert.NoError(err)
+}
+
+func (suite *memStoreTestSuite) TestDefault() {
+	defer suite.cleanupTest()
+	suite.assert.Equal("memstore", suite.ms.Name())
+	suite.assert.EqualValues(0, suite.ms.store.maxSize)
+	suite.assert.EqualValues(0, suite.ms.latency)
+	suite.assert.EqualValues(0, suite.ms.failureRate)
+	suite.assert.False(suite.ms.optimisticConcurrency)
+}
+
+func (suite *memStoreTestSuite) TestConfig() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("memstore:\n  max-size-mb: 10\n  latency-ms: 5\n  failure-rate: 50\n  fail-ops:\n    - stagedata\n    - CommitData\n  optimistic-concurrency: true\n")
+	suite.assert.EqualValues(10*common.MbToBytes, suite.ms.store.maxSize)
+	suite.assert.Equal(5*time.Millisecond, suite.ms.latency)
+	suite.assert.EqualValues(50, suite.ms.failureRate)
+	suite.assert.Equal(map[string]bool{"StageData": true, "CommitData": true}, suite.ms.failOps)
+	suite.assert.True(suite.ms.optimisticConcurrency)
+
+	_, err := newTestMemStore("memstore:\n  failure-rate: 101\n")
+	suite.assert.ErrorContains(err, "failure-rate must be between 0 and 100")
+
+	_, err = newTestMemStore("memstore:\n  fail-ops:\n    - Mount\n")
+	suite.assert.ErrorContains(err, "unknown operation Mount")
+}
+
+func (suite *memStoreTestSuite) TestDirectories() {
+	defer suite.cleanupTest()
+	suite.assert.NoError(suite.ms.CreateDir(internal.CreateDirOptions{Name: "dir"}))
+	suite.assert.True(suite.ms.IsDirEmpty(internal.IsDirEmptyOptions{Name: "dir"}))
+
+	suite.createFile("dir/a", []byte("a"))
+	suite.createFile("dir/sub/b", []byte("b"))
+	suite.createFile("dirx", []byte("x"))
+	suite.assert.False(suite.ms.IsDirEmpty(internal.IsDirEmptyOptions{Name: "dir"}))
+
+	// sub has no marker blob but exists through the blob under it
+	attr, err := suite.ms.GetAttr(internal.GetAttrOptions{Name: "dir/sub"})
+	suite.assert.NoError(err)
+	suite.assert.True(attr.IsDir())
+
+	entries, err := suite.ms.ReadDir(internal.ReadDirOptions{Name: ""})
+	suite.assert.NoError(err)
+	suite.assert.Len(entries, 2)
+	suite.assert.Equal("dir", entries[0].Path)
+	suite.assert.True(entries[0].IsDir())
+	suite.assert.Equal("dirx", entries[1].Path)
+
+	entries, token, err := suite.ms.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 1})
+	suite.assert.NoError(err)
+	suite.assert.Len(entries, 1)
+	suite.assert.Equal("dir/a", entries[0].Path)
+	entries, token, err = suite.ms.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 1, Token: token})
+	suite.assert.NoError(err)
+	suite.assert.Empty(token)
+	suite.assert.Equal("dir/sub", entries[0].Path)
+
+	_, _, err = suite.ms.StreamDir(internal.StreamDirOptions{Name: "dirx"})
+	suite.assert.Equal(syscall.ENOENT, err)
+
+	suite.assert.NoError(suite.ms.RenameDir(internal.RenameDirOptions{Src: "dir", Dst: "new"}))
+	_, err = suite.ms.GetAttr(internal.GetAttrOptions{Name: "new/sub/b"})
+	suite.assert.NoError(err)
+	_, err = suite.ms.GetAttr(internal.GetAttrOptions{Name: "dir"})
+	suite.assert.Equal(syscall.ENOENT, err)
+
+	suite.assert.NoError(suite.ms.DeleteDir(internal.DeleteDirOptions{Name: "new"}))
+	_, err = suite.ms.GetAttr(internal.GetAttrOptions{Name: "new"})
+	suite.assert.Equal(syscall.ENOENT, err)
+	_, err = suite.ms.GetAttr(internal.GetAttrOptions{Name: "dirx"})
+	suite.assert.NoError(err)
+}
+
+func (suite *memStoreTestSuite) TestFiles() {
+	defer suite.cleanupTest()
+	suite.createFile("file", []byte("hello world"))
+
+	handle, err := suite.ms.OpenFile(internal.OpenFileOptions{Name: "file"})
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(11, handle.Size)
+
+	data := make([]byte, 5)
+	n, err := suite.ms.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: 6, Data: data})
+	suite.assert.NoError(err)
+	suite.assert.Equal(5, n)
+	suite.assert.Equal([]byte("world"), data)
+
+	suite.assert.NoError(suite.ms.TruncateFile(internal.TruncateFileOptions{Name: "file", Size: 5}))
+	all, err := suite.ms.ReadFile(internal.ReadFileOptions{Handle: handle})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("hello"), all)
+
+	suite.assert.NoError(suite.ms.RenameFile(internal.RenameFileOptions{Src: "file", Dst: "moved"}))
+	_, err = suite.ms.OpenFile(internal.OpenFileOptions{Name: "file"})
+	suite.assert.Equal(syscall.ENOENT, err)
+
+	suite.assert.NoError(suite.ms.DeleteFile(internal.DeleteFileOptions{Name: "moved"}))
+	suite.assert.Equal(syscall.ENOENT, suite.ms.DeleteFile(internal.DeleteFileOptions{Name: "moved"}))
+
+	suite.assert.NoError(suite.ms.CreateLink(internal.CreateLinkOptions{Name: "link", Target: "target"}))
+	attr, err := suite.ms.GetAttr(internal.GetAttrOptions{Name: "link"})
+	suite.assert.NoError(err)
+	suite.assert.True(attr.IsSymlink())
+	target, err := suite.ms.ReadLink(internal.ReadLinkOptions{Name: "link"})
+	suite.assert.NoError(err)
+	suite.assert.Equal("target", target)
+}
+
+func (suite *memStoreTestSuite) TestCopyFromToFile() {
+	defer suite.cleanupTest()
+	data := randomData(1000)
+
+	f, err := os.CreateTemp("", "memstore")
+	suite.assert.NoError(err)
+	defer os.Remove(f.Name())
+	_, _ = f.Write(data)
+
+	value := "value"
+	etag := ""
+	err = suite.ms.CopyFromFile(internal.CopyFromFileOptions{Name: "file", File: f, Metadata: map[string]*string{"key": &value}, ETag: &etag})
+	suite.assert.NoError(err)
+	suite.assert.NotEmpty(etag)
+
+	attr, err := suite.ms.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.NoError(err)
+	suite.assert.Equal(etag, attr.ETag)
+	suite.assert.Equal("value", *attr.Metadata["key"])
+
+	_ = f.Truncate(0)
+	err = suite.ms.CopyToFile(internal.CopyToFileOptions{Name: "file", Offset: 100, Count: 50, File: f})
+	suite.assert.NoError(err)
+	downloaded, _ := os.ReadFile(f.Name())
+	suite.assert.Equal(data[100:150], downloaded)
+}
+
+func (suite *memStoreTestSuite) TestXattr() {
+	defer suite.cleanupTest()
+	suite.createFile("file", nil)
+
+	err := suite.ms.SetXattr(internal.SetXattrOptions{Name: "file", Attr: "user.a", Value: []byte("1")})
+	suite.assert.NoError(err)
+	err = suite.ms.SetXattr(internal.SetXattrOptions{Name: "file", Attr: "user.a", Value: []byte("2"), Flags: internal.XattrCreate})
+	suite.assert.Equal(syscall.EEXIST, err)
+	err = suite.ms.SetXattr(internal.SetXattrOptions{Name: "file", Attr: "user.b", Value: []byte("2"), Flags: internal.XattrReplace})
+	suite.assert.Equal(syscall.ENODATA, err)
+	err = suite.ms.SetXattr(internal.SetXattrOptions{Name: "file", Attr: "security.a", Value: []byte("1")})
+	suite.assert.Equal(syscall.ENOTSUP, err)
+
+	value, err := suite.ms.GetXattr(internal.GetXattrOptions{Name: "file", Attr: "user.a"})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("1"), value)
+
+	names, err := suite.ms.ListXattr(internal.ListXattrOptions{Name: "file"})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]string{"user.a"}, names)
+
+	suite.assert.NoError(suite.ms.RemoveXattr(internal.RemoveXattrOptions{Name: "file", Attr: "user.a"}))
+	_, err = suite.ms.GetXattr(internal.GetXattrOptions{Name: "file", Attr: "user.a"})
+	suite.assert.Equal(syscall.ENODATA, err)
+}
+
+func (suite *memStoreTestSuite) TestStageAndCommit() {
+	defer suite.cleanupTest()
+	blocks := [][]byte{randomData(100), randomData(100), randomData(50)}
+	for i, data := range blocks {
+		suite.assert.NoError(suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(i), Data: data}))
+	}
+
+	// Staged blocks are not visible till they are committed
+	_, err := suite.ms.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.Equal(syscall.ENOENT, err)
+
+	etag := ""
+	err = suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(0), blockID(1), blockID(2)}, ETag: &etag})
+	suite.assert.NoError(err)
+	suite.assert.NotEmpty(etag)
+
+	list, err := suite.ms.GetCommittedBlockList("file")
+	suite.assert.NoError(err)
+	suite.assert.Len(*list, 3)
+	suite.assert.Equal(blockID(1), (*list)[1].Id)
+	suite.assert.EqualValues(100, (*list)[1].Offset)
+	suite.assert.EqualValues(50, (*list)[2].Size)
+
+	offsets, err := suite.ms.GetFileBlockOffsets(internal.GetFileBlockOffsetsOptions{Name: "file"})
+	suite.assert.NoError(err)
+	suite.assert.False(offsets.SmallFile())
+	suite.assert.EqualValues(16, offsets.BlockIdLength)
+	suite.assert.EqualValues(250, offsets.BlockList[2].EndIndex)
+
+	// Replace the middle block, the others are taken from the committed list
+	blocks[1] = randomData(100)
+	suite.assert.NoError(suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(3), Data: blocks[1]}))
+	err = suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(0), blockID(3), blockID(2)}})
+	suite.assert.NoError(err)
+
+	handle := handlemap.NewHandle("file")
+	data, err := suite.ms.ReadFile(internal.ReadFileOptions{Handle: handle})
+	suite.assert.NoError(err)
+	suite.assert.Equal(bytes.Join(blocks, nil), data)
+
+	// Blocks which are neither staged nor committed, and ids of a different length are rejected
+	err = suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(1)}})
+	suite.assert.Equal(syscall.EINVAL, err)
+	err = suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: "short", Data: []byte("x")})
+	suite.assert.Equal(syscall.EINVAL, err)
+
+	// Uploading in a single request leaves the blob with no block list
+	suite.createFile("small", []byte("small"))
+	list, err = suite.ms.GetCommittedBlockList("small")
+	suite.assert.NoError(err)
+	suite.assert.Nil(list)
+	offsets, err = suite.ms.GetFileBlockOffsets(internal.GetFileBlockOffsetsOptions{Name: "small"})
+	suite.assert.NoError(err)
+	suite.assert.True(offsets.SmallFile())
+}
+
+func (suite *memStoreTestSuite) TestOptimisticConcurrency() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("memstore:\n  optimistic-concurrency: true\n")
+	suite.createFile("file", []byte("data"))
+
+	attr, err := suite.ms.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.NoError(err)
+	etag := attr.ETag
+
+	// Someone else changes the blob
+	suite.assert.NoError(suite.ms.SetAttr(internal.SetAttrOptions{Name: "file", Attr: &internal.ObjAttr{Mtime: time.Now()}}))
+
+	suite.assert.NoError(suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(0), Data: []byte("new")}))
+	err = suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(0)}, ETag: &etag})
+	suite.assert.Equal(syscall.ESTALE, err)
+	suite.assert.Equal(syscall.ESTALE, suite.ms.DeleteFile(internal.DeleteFileOptions{Name: "file", ETag: etag}))
+
+	attr, _ = suite.ms.GetAttr(internal.GetAttrOptions{Name: "file"})
+	etag = attr.ETag
+	suite.assert.NoError(suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(0)}, ETag: &etag}))
+	suite.assert.NotEqual(attr.ETag, etag)
+}
+
+func (suite *memStoreTestSuite) TestMaxSize() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("memstore:\n  max-size-mb: 1\n")
+
+	st, populated, err := suite.ms.StatFs()
+	suite.assert.NoError(err)
+	suite.assert.True(populated)
+	suite.assert.EqualValues(256, st.Blocks)
+
+	suite.assert.NoError(suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(0), Data: make([]byte, 600*1024)}))
+	err = suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(1), Data: make([]byte, 600*1024)})
+	suite.assert.Equal(syscall.ENOSPC, err)
+
+	st, _, _ = suite.ms.StatFs()
+	suite.assert.EqualValues(106, st.Bfree)
+
+	// Space held by staged blocks is given back once the blob is deleted
+	suite.assert.NoError(suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(0)}}))
+	suite.assert.NoError(suite.ms.DeleteFile(internal.DeleteFileOptions{Name: "file"}))
+	suite.assert.EqualValues(0, suite.ms.store.used)
+}
+
+func (suite *memStoreTestSuite) TestChecksum() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("memstore:\n")
+
+	data := []byte("block data")
+	sum := md5.Sum(data)
+
+	// Block which does not match its checksum is rejected
+	suite.assert.NoError(suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(0), Data: data, Checksum: sum[:]}))
+	err := suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(1), Data: []byte("other data"), Checksum: sum[:]})
+	suite.assert.Equal(syscall.EIO, err)
+	suite.assert.NoError(suite.ms.CommitData(internal.CommitDataOptions{Name: "file", List: []string{blockID(0)}}))
+
+	var sums [][]byte
+	buf := make([]byte, 64)
+	n, err := suite.ms.ReadInBuffer(internal.ReadInBufferOptions{Handle: handlemap.NewHandle("file"), Data: buf, Checksums: &sums})
+	suite.assert.NoError(err)
+	suite.assert.Equal(data, buf[:n])
+	suite.assert.Equal([][]byte{sum[:]}, sums)
+}
+
+func (suite *memStoreTestSuite) TestFailureInjection() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("memstore:\n  failure-rate: 100\n  fail-ops:\n    - StageData\n")
+
+	suite.assert.NoError(suite.ms.CreateDir(internal.CreateDirOptions{Name: "dir"}))
+	err := suite.ms.StageData(internal.StageDataOptions{Name: "file", Id: blockID(0), Data: []byte("data")})
+	suite.assert.Equal(syscall.EIO, err)
+
+	// Failures are picked from a seeded sequence, so the same seed fails the same calls
+	failures := func() []bool {
+		ms, err := newTestMemStore("memstore:\n  failure-rate: 50\n  failure-seed: 7\n")
+		suite.assert.NoError(err)
+		res := make([]bool, 20)
+		for i := range res {
+			res[i] = ms.CreateDir(internal.CreateDirOptions{Name: "dir"}) != nil
+		}
+		return res
+	}
+	first := failures()
+	suite.assert.Equal(first, failures())
+	suite.assert.Contains(first, true)
+	suite.assert.Contains(first, false)
+}
+
+func (suite *memStoreTestSuite) TestLatency() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("memstore:\n  latency-ms: 20\n")
+
+	start := time.Now()
+	_, _ = suite.ms.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+}
+
+// Write through block cache, which stages and commits blocks, and modify the file again in place
+func (suite *memStoreTestSuite) TestBlockCache() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper("block_cache:\n  block-size-mb: 1\n  mem-size-mb: 20\n  prefetch: 12\n  parallelism: 10\n")
+
+	bc := block_cache.NewBlockCacheComponent()
+	bc.SetNextComponent(suite.ms)
+	suite.assert.NoError(bc.Configure(true))
+	suite.assert.NoError(bc.Start(context.Background()))
+	defer func() { _ = bc.Stop() }()
+
+	data := randomData(int(2*common.MbToBytes + 100))
+	handle, err := bc.CreateFile(internal.CreateFileOptions{Name: "file", Mode: 0777})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: data})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	list, err := suite.ms.GetCommittedBlockList("file")
+	suite.assert.NoError(err)
+	suite.assert.Len(*list, 3)
+
+	handle, err = bc.OpenFile(internal.OpenFileOptions{Name: "file", Flags: os.O_RDWR})
+	suite.assert.NoError(err)
+	_, err = bc.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: common.MbToBytes + 10, Data: []byte("changed")})
+	suite.assert.NoError(err)
+	suite.assert.NoError(bc.CloseFile(internal.CloseFileOptions{Handle: handle}))
+	copy(data[common.MbToBytes+10:], "changed")
+
+	stored, err := suite.ms.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle("file")})
+	suite.assert.NoError(err)
+	suite.assert.True(bytes.Equal(data, stored))
+
+	// Blocks which were not written keep their ids
+	newList, err := suite.ms.GetCommittedBlockList("file")
+	suite.assert.NoError(err)
+	suite.assert.Equal((*list)[0].Id, (*newList)[0].Id)
+	suite.assert.NotEqual((*list)[1].Id, (*newList)[1].Id)
+}
+
+// Write through file cache, which uploads the whole file when it is closed
+func (suite *memStoreTestSuite) TestFileCache() {
+	defer suite.cleanupTest()
+	cachePath := filepath.Join(os.TempDir(), "memstore_file_cache")
+	defer os.RemoveAll(cachePath)
+	suite.setupTestHelper(fmt.Sprintf("file_cache:\n  path: %s\n  timeout-sec: 120\n", cachePath))
+
+	fc := file_cache.NewFileCacheComponent()
+	fc.SetNextComponent(suite.ms)
+	suite.assert.NoError(fc.Configure(true))
+	suite.assert.NoError(fc.Start(context.Background()))
+	defer func() { _ = fc.Stop() }()
+
+	handle, err := fc.CreateFile(internal.CreateFileOptions{Name: "file", Mode: 0777})
+	suite.assert.NoError(err)
+	_, err = fc.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 0, Data: []byte("hello world")})
+	suite.assert.NoError(err)
+	suite.assert.NoError(fc.FlushFile(internal.FlushFileOptions{Handle: handle}))
+	suite.assert.NoError(fc.CloseFile(internal.CloseFileOptions{Handle: handle}))
+
+	stored, err := suite.ms.ReadFile(internal.ReadFileOptions{Handle: handlemap.NewHandle("file")})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("hello world"), stored)
+}
+
+func TestMemStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(memStoreTestSuite))
+}
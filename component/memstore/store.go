@@ -0,0 +1,274 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package memstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+)
+
+// Limits of a block blob which are checked by the store
+const (
+	maxBlocks      = 50000
+	maxBlockSize   = 4000 * common.MbToBytes
+	maxBlockIDSize = 64
+)
+
+// memBlock : Entry of the committed block list of a blob
+type memBlock struct {
+	id   string
+	size int64
+}
+
+// memBlob : A blob held in memory. Directories are blobs with no data and the directory flag set,
+// the same way a marker blob is used for directories in a block blob account.
+type memBlob struct {
+	data      []byte
+	blocks    []memBlock // committed block list, empty for a blob uploaded in a single request
+	metadata  map[string]*string
+	etag      string
+	mtime     time.Time
+	atime     time.Time
+	crtime    time.Time
+	isDir     bool
+	isSymlink bool
+}
+
+// memStore : Blobs of the container along with the blocks staged for them
+type memStore struct {
+	blobs   map[string]*memBlob
+	staged  map[string]map[string][]byte // uncommitted blocks of each blob, a blob need not exist to stage blocks for it
+	used    int64                        // bytes held by blobs and uncommitted blocks
+	maxSize int64                        // limit on used bytes, 0 for no limit
+	etagSeq uint64
+}
+
+func newMemStore(maxSize int64) *memStore {
+	return &memStore{
+		blobs:   make(map[string]*memBlob),
+		staged:  make(map[string]map[string][]byte),
+		maxSize: maxSize,
+	}
+}
+
+// nextETag : Every change to a blob gives it a new etag
+func (st *memStore) nextETag() string {
+	st.etagSeq++
+	return fmt.Sprintf("\"0x%016X\"", st.etagSeq)
+}
+
+// reserve : Account for size more bytes being held, failing when it goes over the limit
+func (st *memStore) reserve(size int64) error {
+	if st.maxSize > 0 && size > 0 && st.used+size > st.maxSize {
+		return syscall.ENOSPC
+	}
+	st.used += size
+	return nil
+}
+
+// put : Store data as the content of the blob, replacing any existing blob along with its block list
+func (st *memStore) put(name string, data []byte, metadata map[string]*string) (*memBlob, error) {
+	old := st.blobs[name]
+	oldSize := int64(0)
+	if old != nil {
+		oldSize = int64(len(old.data))
+	}
+
+	if err := st.reserve(int64(len(data)) - oldSize); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	blob := &memBlob{
+		data:     data,
+		metadata: copyMetadata(metadata),
+		etag:     st.nextETag(),
+		mtime:    now,
+		atime:    now,
+		crtime:   now,
+	}
+	if old != nil {
+		blob.crtime = old.crtime
+	}
+
+	st.blobs[name] = blob
+	return blob, nil
+}
+
+// remove : Delete the blob and the blocks staged for it
+func (st *memStore) remove(name string) {
+	if blob, found := st.blobs[name]; found {
+		st.used -= int64(len(blob.data))
+		delete(st.blobs, name)
+	}
+	st.discardStaged(name)
+}
+
+func (st *memStore) discardStaged(name string) {
+	for _, data := range st.staged[name] {
+		st.used -= int64(len(data))
+	}
+	delete(st.staged, name)
+}
+
+// list : Names of all blobs under the given prefix in sorted order
+func (st *memStore) list(prefix string) []string {
+	names := make([]string, 0)
+	for name := range st.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasChildren : Whether any blob exists under the given directory
+func (st *memStore) hasChildren(dir string) bool {
+	prefix := dirPrefix(dir)
+	for name := range st.blobs {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stage : Hold a block to be committed later. Like the service, all blocks of a blob must have ids of the same length.
+func (st *memStore) stage(name string, id string, data []byte) error {
+	if len(id) == 0 || len(id) > maxBlockIDSize || int64(len(data)) > maxBlockSize {
+		return syscall.EINVAL
+	}
+
+	blocks := st.staged[name]
+	for existing := range blocks {
+		if len(existing) != len(id) {
+			return syscall.EINVAL
+		}
+		break
+	}
+	if blob, found := st.blobs[name]; found && len(blob.blocks) > 0 && len(blob.blocks[0].id) != len(id) {
+		return syscall.EINVAL
+	}
+
+	old := int64(len(blocks[id]))
+	if err := st.reserve(int64(len(data)) - old); err != nil {
+		return err
+	}
+
+	if blocks == nil {
+		blocks = make(map[string][]byte)
+		st.staged[name] = blocks
+	}
+	blocks[id] = append([]byte(nil), data...)
+	return nil
+}
+
+// commit : Make the given blocks the content of the blob. Each id is looked up among the uncommitted blocks first
+// and then in the committed block list. All uncommitted blocks of the blob are discarded after the commit.
+func (st *memStore) commit(name string, ids []string) (*memBlob, error) {
+	if len(ids) > maxBlocks {
+		return nil, syscall.EINVAL
+	}
+
+	old := st.blobs[name]
+	committed := make(map[string][]byte)
+	if old != nil {
+		offset := int64(0)
+		for _, block := range old.blocks {
+			committed[block.id] = old.data[offset : offset+block.size]
+			offset += block.size
+		}
+	}
+
+	data := make([]byte, 0)
+	blocks := make([]memBlock, 0, len(ids))
+	for _, id := range ids {
+		block, found := st.staged[name][id]
+		if !found {
+			block, found = committed[id]
+		}
+		if !found {
+			return nil, syscall.EINVAL
+		}
+		data = append(data, block...)
+		blocks = append(blocks, memBlock{id: id, size: int64(len(block))})
+	}
+
+	// Staged blocks are released as part of the commit, so their space can hold the new blob
+	stagedSize := int64(0)
+	for _, block := range st.staged[name] {
+		stagedSize += int64(len(block))
+	}
+	st.used -= stagedSize
+
+	// Committing a block list replaces the metadata of the blob, keep it as azstorage does
+	var metadata map[string]*string
+	if old != nil {
+		metadata = old.metadata
+	}
+
+	blob, err := st.put(name, data, metadata)
+	if err != nil {
+		st.used += stagedSize
+		return nil, err
+	}
+	blob.blocks = blocks
+	delete(st.staged, name)
+	return blob, nil
+}
+
+func copyMetadata(metadata map[string]*string) map[string]*string {
+	res := make(map[string]*string)
+	for k, v := range metadata {
+		if v != nil {
+			val := *v
+			res[k] = &val
+		}
+	}
+	return res
+}
+
+// dirPrefix : Prefix of every blob under the given directory, empty for the root
+func dirPrefix(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return dir + "/"
+}
@@ -0,0 +1,431 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package s3storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// s3Error : Error returned by the service
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("%d %s : %s", e.StatusCode, e.Code, e.Message)
+}
+
+// storeErrToErr : Map an error returned by the service to the errno reported to the file system
+func storeErrToErr(err error) error {
+	serr, ok := err.(*s3Error)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case serr.StatusCode == http.StatusNotFound:
+		return syscall.ENOENT
+	case serr.StatusCode == http.StatusForbidden:
+		return syscall.EACCES
+	case serr.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return syscall.ERANGE
+	case serr.StatusCode == http.StatusPreconditionFailed:
+		return syscall.ESTALE
+	}
+	return err
+}
+
+// objectInfo : Properties of an object as returned by HEAD or a listing
+type objectInfo struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+type listResult struct {
+	XMLName        xml.Name     `xml:"ListBucketResult"`
+	Contents       []objectInfo `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type initiateMultipartResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type copyResult struct {
+	ETag string `xml:"ETag"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// s3Client : Minimal client for the subset of the S3 REST API used by this component
+type s3Client struct {
+	config     *S3StorageConfig
+	httpClient *http.Client
+}
+
+func newS3Client(cfg *S3StorageConfig) *s3Client {
+	return &s3Client{
+		config: cfg,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: int(cfg.maxConcurrency) * 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+// objectKey : Key of the object backing the given path
+func (c *s3Client) objectKey(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if c.config.prefixPath == "" {
+		return name
+	}
+	return c.config.prefixPath + "/" + name
+}
+
+// objectName : Path relative to the mount for the given object key
+func (c *s3Client) objectName(key string) string {
+	if c.config.prefixPath == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, c.config.prefixPath), "/")
+}
+
+// requestURL : URL of the given object, the bucket goes either in the path or in the host name
+func (c *s3Client) requestURL(key string, query url.Values) *url.URL {
+	u := *c.config.endpoint
+	path := u.Path + "/"
+	if c.config.usePathStyle {
+		path += c.config.bucket + "/"
+	} else {
+		u.Host = c.config.bucket + "." + u.Host
+	}
+	path += key
+
+	u.Path = path
+	u.RawPath = uriEncode(path, true)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do : Sign and send a request, retrying on throttling, server errors and network failures.
+// Any response other than 2xx is returned as an s3Error.
+func (c *s3Client) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	payloadHash := hashPayload(body)
+
+	var resp *http.Response
+	var err error
+	for attempt := int32(0); ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<(attempt-1)) * 500 * time.Millisecond)
+		}
+
+		var req *http.Request
+		req, err = http.NewRequest(method, c.requestURL(key, query).String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.ContentLength = int64(len(body))
+		signRequest(req, payloadHash, c.config.cred, c.config.region, time.Now())
+
+		resp, err = c.httpClient.Do(req)
+		if err != nil {
+			log.Warn("s3Client::do : %s %s failed, attempt %d [%s]", method, key, attempt, err.Error())
+			if attempt < c.config.maxRetries {
+				continue
+			}
+			return nil, err
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		err = parseError(resp)
+		if (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests) && attempt < c.config.maxRetries {
+			log.Warn("s3Client::do : %s %s failed, attempt %d [%s]", method, key, attempt, err.Error())
+			continue
+		}
+		return nil, err
+	}
+}
+
+// parseError : Read the error returned by the service from the response body
+func parseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	serr := &s3Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(resp.Body)
+	if len(data) > 0 {
+		_ = xml.Unmarshal(data, serr)
+	}
+	if serr.Code == "" {
+		serr.Code = http.StatusText(resp.StatusCode)
+	}
+	return serr
+}
+
+// decodeResponse : Decode the xml body of a successful response.
+// Copy and complete calls can fail after the service has already sent a 200, in which case the body holds an error.
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(data[:min(len(data), 128)], []byte("<Error>")) {
+		serr := &s3Error{StatusCode: http.StatusInternalServerError}
+		_ = xml.Unmarshal(data, serr)
+		return serr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// drain : Discard the body of a response so the connection can be reused
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// headObject : Get the properties of an object
+func (c *s3Client) headObject(key string) (*objectInfo, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	drain(resp)
+
+	info := &objectInfo{
+		Key:  key,
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// getObject : Read count bytes of an object starting at offset in to the given buffer, count of 0 reads the whole object
+func (c *s3Client) getObject(key string, offset int64, count int64, data []byte) ([]byte, error) {
+	header := http.Header{}
+	if offset != 0 || count != 0 {
+		rng := fmt.Sprintf("bytes=%d-", offset)
+		if count != 0 {
+			rng += strconv.FormatInt(offset+count-1, 10)
+		}
+		header.Set("Range", rng)
+	}
+
+	resp, err := c.do(http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if data == nil {
+		return io.ReadAll(resp.Body)
+	}
+
+	n, err := io.ReadFull(resp.Body, data[:count])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// putObject : Upload an object in a single request
+func (c *s3Client) putObject(key string, data []byte) (string, error) {
+	resp, err := c.do(http.MethodPut, key, nil, nil, data)
+	if err != nil {
+		return "", err
+	}
+	drain(resp)
+	return resp.Header.Get("ETag"), nil
+}
+
+// copyObject : Server side copy of an object of at most 5GiB
+func (c *s3Client) copyObject(src string, dst string) (string, error) {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", c.copySource(src))
+
+	resp, err := c.do(http.MethodPut, dst, nil, header, nil)
+	if err != nil {
+		return "", err
+	}
+
+	result := copyResult{}
+	err = decodeResponse(resp, &result)
+	return result.ETag, err
+}
+
+func (c *s3Client) copySource(key string) string {
+	return uriEncode("/"+c.config.bucket+"/"+key, true)
+}
+
+// deleteObject : Delete an object, deleting an object that does not exist is not an error in S3
+func (c *s3Client) deleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// listObjects : List one page of objects under the given prefix
+func (c *s3Client) listObjects(prefix string, delimiter string, token string, maxKeys int32) (*listResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(int(maxKeys)))
+	}
+
+	resp, err := c.do(http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &listResult{}
+	err = decodeResponse(resp, result)
+	return result, err
+}
+
+// createMultipartUpload : Start a multipart upload and get its id
+func (c *s3Client) createMultipartUpload(key string) (string, error) {
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	result := initiateMultipartResult{}
+	err = decodeResponse(resp, &result)
+	return result.UploadID, err
+}
+
+// uploadPart : Upload one part of a multipart upload
+func (c *s3Client) uploadPart(key string, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := c.do(http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	drain(resp)
+	return resp.Header.Get("ETag"), nil
+}
+
+// uploadPartCopy : Fill one part of a multipart upload with a range of an existing object
+func (c *s3Client) uploadPartCopy(key string, uploadID string, number int, src string, offset int64, count int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	header := http.Header{}
+	header.Set("x-amz-copy-source", c.copySource(src))
+	header.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", offset, offset+count-1))
+
+	resp, err := c.do(http.MethodPut, key, query, header, nil)
+	if err != nil {
+		return "", err
+	}
+
+	result := copyResult{}
+	err = decodeResponse(resp, &result)
+	return result.ETag, err
+}
+
+// completeMultipartUpload : Commit the given parts, in order of their numbers, as the content of the object
+func (c *s3Client) completeMultipartUpload(key string, uploadID string, parts []completedPart) (string, error) {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return "", err
+	}
+
+	result := copyResult{}
+	err = decodeResponse(resp, &result)
+	return result.ETag, err
+}
+
+// abortMultipartUpload : Discard a multipart upload along with all parts uploaded so far
+func (c *s3Client) abortMultipartUpload(key string, uploadID string) error {
+	resp, err := c.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
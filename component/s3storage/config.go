@@ -0,0 +1,184 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package s3storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// S3StorageOptions : Config options of this component
+type S3StorageOptions struct {
+	BucketName        string `config:"bucket-name" yaml:"bucket-name,omitempty"`
+	Endpoint          string `config:"endpoint" yaml:"endpoint,omitempty"`
+	Region            string `config:"region" yaml:"region,omitempty"`
+	KeyID             string `config:"key-id" yaml:"key-id,omitempty"`
+	SecretKey         string `config:"secret-key" yaml:"secret-key,omitempty"`
+	SessionToken      string `config:"session-token" yaml:"session-token,omitempty"`
+	Subdirectory      string `config:"subdirectory" yaml:"subdirectory,omitempty"`
+	UsePathStyle      bool   `config:"use-path-style" yaml:"use-path-style,omitempty"`
+	PartSizeMB        uint64 `config:"block-size-mb" yaml:"block-size-mb,omitempty"`
+	MaxConcurrency    uint16 `config:"max-concurrency" yaml:"max-concurrency,omitempty"`
+	MaxRetries        int32  `config:"max-retries" yaml:"max-retries,omitempty"`
+	FailUnsupportedOp bool   `config:"fail-unsupported-op" yaml:"fail-unsupported-op,omitempty"`
+}
+
+const (
+	defaultRegion         = "us-east-1"
+	defaultPartSizeMB     = 16
+	defaultMaxConcurrency = 8
+	defaultMaxRetries     = 3
+
+	// S3 rejects multipart uploads where any part other than the last one is smaller than this
+	minPartSize = 5 * common.MbToBytes
+	// Largest object that can be copied with a single CopyObject call
+	maxCopySize = 5 * common.GbToBytes
+	// Highest part number allowed in a multipart upload
+	maxParts = 10000
+)
+
+// S3StorageConfig : Parsed config of this component
+type S3StorageConfig struct {
+	bucket            string
+	endpoint          *url.URL
+	region            string
+	cred              credentials
+	prefixPath        string
+	usePathStyle      bool
+	partSize          int64
+	maxConcurrency    uint16
+	maxRetries        int32
+	failUnsupportedOp bool
+}
+
+// ParseAndValidateConfig : Fill the component config from the options read from the config file
+func ParseAndValidateConfig(s3 *S3Storage, opt S3StorageOptions) error {
+	if opt.BucketName == "" {
+		return errors.New("bucket name not provided")
+	}
+	s3.stConfig.bucket = opt.BucketName
+
+	s3.stConfig.region = opt.Region
+	if s3.stConfig.region == "" {
+		s3.stConfig.region = os.Getenv("AWS_REGION")
+	}
+	if s3.stConfig.region == "" {
+		s3.stConfig.region = defaultRegion
+	}
+
+	// Custom endpoints are mostly self hosted stores like MinIO or Ceph which expect path style addressing,
+	// while AWS itself prefers the bucket in the host name
+	s3.stConfig.usePathStyle = opt.UsePathStyle
+	if opt.Endpoint == "" {
+		opt.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s3.stConfig.region)
+	} else if !config.IsSet(compName + ".use-path-style") {
+		s3.stConfig.usePathStyle = true
+	}
+
+	if !strings.HasPrefix(opt.Endpoint, "http") {
+		opt.Endpoint = "https://" + opt.Endpoint
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(opt.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return fmt.Errorf("invalid endpoint %s", opt.Endpoint)
+	}
+	s3.stConfig.endpoint = endpoint
+
+	s3.stConfig.cred = credentials{
+		keyID:        opt.KeyID,
+		secretKey:    opt.SecretKey,
+		sessionToken: opt.SessionToken,
+	}
+	if s3.stConfig.cred.keyID == "" && s3.stConfig.cred.secretKey == "" {
+		s3.stConfig.cred.keyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s3.stConfig.cred.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if s3.stConfig.cred.sessionToken == "" {
+			s3.stConfig.cred.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+	}
+	if s3.stConfig.cred.keyID == "" || s3.stConfig.cred.secretKey == "" {
+		return errors.New("key id and secret key are required")
+	}
+
+	s3.stConfig.prefixPath = strings.Trim(opt.Subdirectory, "/")
+
+	// Parts uploaded by block cache are full blocks, so by default the part size follows the block size of block cache
+	// Blocks staged by block cache become parts of a multipart upload, so they can not be smaller than what S3 allows
+	blockSize := int64(defaultPartSizeMB * common.MbToBytes)
+	if config.IsSet("block_cache.block-size-mb") {
+		var blockSizeMB float64
+		err = config.UnmarshalKey("block_cache.block-size-mb", &blockSizeMB)
+		if err != nil {
+			log.Err("ParseAndValidateConfig : Failed to unmarshal block_cache.block-size-mb")
+		}
+		blockSize = int64(blockSizeMB * float64(common.MbToBytes))
+		if blockSize < minPartSize {
+			return fmt.Errorf("block_cache.block-size-mb must be at least %d", minPartSize/common.MbToBytes)
+		}
+	}
+
+	// By default the part size follows the block size of block cache, so blocks listed for an existing object line up with its parts
+	s3.stConfig.partSize = blockSize
+	if opt.PartSizeMB != 0 {
+		s3.stConfig.partSize = int64(opt.PartSizeMB * common.MbToBytes)
+	}
+	if s3.stConfig.partSize < minPartSize {
+		return fmt.Errorf("block-size-mb must be at least %d", minPartSize/common.MbToBytes)
+	}
+
+	s3.stConfig.maxConcurrency = opt.MaxConcurrency
+	if s3.stConfig.maxConcurrency == 0 {
+		s3.stConfig.maxConcurrency = defaultMaxConcurrency
+	}
+
+	s3.stConfig.maxRetries = opt.MaxRetries
+	if !config.IsSet(compName + ".max-retries") {
+		s3.stConfig.maxRetries = defaultMaxRetries
+	}
+
+	s3.stConfig.failUnsupportedOp = opt.FailUnsupportedOp
+
+	log.Crit("ParseAndValidateConfig : bucket %s, endpoint %s, region %s, path-style %v, subdirectory %s, part-size %v, max-concurrency %v, max-retries %v",
+		s3.stConfig.bucket, s3.stConfig.endpoint.String(), s3.stConfig.region, s3.stConfig.usePathStyle, s3.stConfig.prefixPath,
+		s3.stConfig.partSize, s3.stConfig.maxConcurrency, s3.stConfig.maxRetries)
+
+	return nil
+}
@@ -0,0 +1,440 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package s3storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeS3Server : In memory stand in for an S3 compatible service, serving the path style requests used by this component
+type fakeS3Server struct {
+	sync.Mutex
+	*httptest.Server
+
+	bucket   string
+	objects  map[string]*fakeObject
+	uploads  map[string]*fakeUpload
+	uploadID int
+
+	requests map[string]int // count of requests seen per operation
+	failNext int            // status to fail the next request with, 0 to serve it
+}
+
+type fakeObject struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+type fakeUpload struct {
+	key   string
+	parts map[int]*fakeObject
+}
+
+func newFakeS3Server(bucket string) *fakeS3Server {
+	s := &fakeS3Server{
+		bucket:   bucket,
+		objects:  make(map[string]*fakeObject),
+		uploads:  make(map[string]*fakeUpload),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func newFakeObject(data []byte) *fakeObject {
+	sum := md5.Sum(data)
+	return &fakeObject{data: data, etag: "\"" + hex.EncodeToString(sum[:]) + "\"", modTime: time.Now().UTC()}
+}
+
+func (s *fakeS3Server) putObject(key string, data []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.objects[key] = newFakeObject(data)
+}
+
+func (s *fakeS3Server) getObject(key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+	obj, found := s.objects[key]
+	if !found {
+		return nil, false
+	}
+	return obj.data, true
+}
+
+func (s *fakeS3Server) count(op string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests[op]
+}
+
+func (s *fakeS3Server) pendingUploads() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.uploads)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, _ := xml.Marshal(v)
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(data)
+}
+
+// parseRange : Parse a "bytes=start-end" header against an object of the given size
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	rng = strings.TrimPrefix(rng, "bytes=")
+	parts := strings.SplitN(rng, "-", 2)
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if len(parts) == 2 && parts[1] != "" {
+		end, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end + 1, true
+}
+
+func (s *fakeS3Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signAlgorithm) || r.Header.Get("x-amz-date") == "" {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	if s.failNext != 0 {
+		status := s.failNext
+		s.failNext = 0
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path != s.bucket && !strings.HasPrefix(path, s.bucket+"/") {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, s.bucket), "/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		s.requests["list"]++
+		s.list(w, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.requests["create"]++
+		s.uploadID++
+		id := strconv.Itoa(s.uploadID)
+		s.uploads[id] = &fakeUpload{key: key, parts: make(map[int]*fakeObject)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, key, query, body)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.requests["complete"]++
+		s.complete(w, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.requests["abort"]++
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead:
+		s.requests["head"]++
+		obj, found := s.objects[key]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+	case r.Method == http.MethodGet:
+		s.requests["get"]++
+		s.get(w, r, key)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		s.requests["copy"]++
+		src, found := s.copySource(r)
+		if !found {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		obj := newFakeObject(append([]byte{}, src.data...))
+		s.objects[key] = obj
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: obj.etag})
+	case r.Method == http.MethodPut:
+		s.requests["put"]++
+		obj := newFakeObject(body)
+		s.objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+	case r.Method == http.MethodDelete:
+		s.requests["delete"]++
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeS3Server) copySource(r *http.Request) (*fakeObject, bool) {
+	src, _ := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	src = strings.TrimPrefix(strings.TrimPrefix(src, "/"), s.bucket+"/")
+	obj, found := s.objects[src]
+	return obj, found
+}
+
+func (s *fakeS3Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	obj, found := s.objects[key]
+	if !found {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		data = data[start:end]
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+func (s *fakeS3Server) uploadPart(w http.ResponseWriter, r *http.Request, key string, query url.Values, body []byte) {
+	upload, found := s.uploads[query.Get("uploadId")]
+	if !found || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || number < 1 || number > maxParts {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	if r.Header.Get("x-amz-copy-source") == "" {
+		s.requests["part"]++
+		part := newFakeObject(body)
+		upload.parts[number] = part
+		w.Header().Set("ETag", part.etag)
+		return
+	}
+
+	s.requests["partcopy"]++
+	src, found := s.copySource(r)
+	if !found {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	data := src.data
+	if rng := r.Header.Get("x-amz-copy-source-range"); rng != "" {
+		start, end, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		data = data[start:end]
+	}
+
+	part := newFakeObject(append([]byte{}, data...))
+	upload.parts[number] = part
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: part.etag})
+}
+
+func (s *fakeS3Server) complete(w http.ResponseWriter, key string, uploadID string, body []byte) {
+	upload, found := s.uploads[uploadID]
+	if !found || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	request := completeMultipartUpload{}
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	data := make([]byte, 0)
+	for i, p := range request.Parts {
+		part, found := upload.parts[p.PartNumber]
+		if !found || part.etag != p.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		if i > 0 && p.PartNumber <= request.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder")
+			return
+		}
+		if i < len(request.Parts)-1 && len(part.data) < minPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall")
+			return
+		}
+		data = append(data, part.data...)
+	}
+
+	obj := newFakeObject(data)
+	s.objects[key] = obj
+	delete(s.uploads, uploadID)
+
+	// Service sends the status before the result, so errors at this point come with a 200
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: obj.etag})
+}
+
+func (s *fakeS3Server) list(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := 1000
+	if query.Has("max-keys") {
+		maxKeys, _ = strconv.Atoi(query.Get("max-keys"))
+	}
+
+	keys := make([]string, 0)
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type entry struct {
+		key    string
+		prefix bool
+	}
+	entries := make([]entry, 0)
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if delimiter != "" {
+			if idx := strings.Index(k[len(prefix):], delimiter); idx >= 0 {
+				p := k[:len(prefix)+idx+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					entries = append(entries, entry{key: p, prefix: true})
+				}
+				continue
+			}
+		}
+		entries = append(entries, entry{key: k})
+	}
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+
+	type content struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int    `xml:"Size"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	result := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		KeyCount              int            `xml:"KeyCount"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	}{Name: s.bucket, Prefix: prefix}
+
+	end := min(start+maxKeys, len(entries))
+	for _, e := range entries[start:end] {
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: e.key})
+		} else {
+			obj := s.objects[e.key]
+			result.Contents = append(result.Contents, content{
+				Key:          e.key,
+				LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"),
+				ETag:         obj.etag,
+				Size:         len(obj.data),
+			})
+		}
+	}
+	result.KeyCount = end - start
+	if end < len(entries) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	writeXML(w, result)
+}
@@ -0,0 +1,871 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package s3storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+)
+
+// S3Storage component Config specifications:
+//
+//	s3storage:
+//		bucket-name: <name of the bucket>
+//		endpoint: <url of the service, defaults to AWS>
+//		region: <region of the bucket>
+//		key-id: <access key id>
+//		secret-key: <secret access key>
+//
+// S3 has no directories, a directory exists as long as some object has its path as a prefix.
+// Empty directories are kept alive by a zero length marker object named "<path>/".
+
+const compName = "s3storage"
+
+// Most objects S3 returns in one page of a listing
+const maxListResults = 1000
+
+type S3Storage struct {
+	internal.BaseComponent
+	stConfig S3StorageConfig
+	client   *s3Client
+
+	// Multipart uploads holding blocks staged by block cache, keyed on file name
+	uploads sync.Map
+}
+
+var _ internal.Component = &S3Storage{}
+
+func (s3 *S3Storage) Name() string {
+	return compName
+}
+
+func (s3 *S3Storage) SetName(name string) {
+	s3.BaseComponent.SetName(name)
+}
+
+func (s3 *S3Storage) SetNextComponent(c internal.Component) {
+	s3.BaseComponent.SetNextComponent(c)
+}
+
+func (s3 *S3Storage) Priority() internal.ComponentPriority {
+	return internal.EComponentPriority.Consumer()
+}
+
+// Configure : Pipeline will call this method after constructor so that you can read config and initialize yourself
+func (s3 *S3Storage) Configure(isParent bool) error {
+	log.Trace("S3Storage::Configure : %s", s3.Name())
+
+	conf := S3StorageOptions{}
+	err := config.UnmarshalKey(s3.Name(), &conf)
+	if err != nil {
+		log.Err("S3Storage::Configure : config error [invalid config attributes]")
+		return fmt.Errorf("config error in %s [%s]", s3.Name(), err.Error())
+	}
+
+	err = ParseAndValidateConfig(s3, conf)
+	if err != nil {
+		log.Err("S3Storage::Configure : Config validation failed [%s]", err.Error())
+		return fmt.Errorf("config error in %s [%s]", s3.Name(), err.Error())
+	}
+
+	s3.client = newS3Client(&s3.stConfig)
+
+	// The daemon runs all pipeline Configure code twice, so validate credentials only in parent mode
+	if isParent {
+		_, err = s3.client.listObjects(s3.client.objectKey(""), "/", "", 1)
+		if err != nil {
+			log.Err("S3Storage::Configure : Failed to list bucket %s [%s]", s3.stConfig.bucket, err.Error())
+			return fmt.Errorf("failed to authenticate credentials for %s", s3.Name())
+		}
+	}
+
+	return nil
+}
+
+// Start : Initialize the component here
+func (s3 *S3Storage) Start(ctx context.Context) error {
+	log.Trace("S3Storage::Start : Starting component %s", s3.Name())
+	return nil
+}
+
+// Stop : Discard multipart uploads that were never committed
+func (s3 *S3Storage) Stop() error {
+	log.Trace("S3Storage::Stop : Stopping component %s", s3.Name())
+	s3.uploads.Range(func(key, _ interface{}) bool {
+		s3.discardStaged(key.(string))
+		return true
+	})
+	return nil
+}
+
+// ------------------------- Core Operations -------------------------------------------
+
+// Directory operations
+func (s3 *S3Storage) CreateDir(options internal.CreateDirOptions) error {
+	log.Trace("S3Storage::CreateDir : %s", options.Name)
+
+	_, err := s3.client.putObject(s3.client.objectKey(internal.ExtendDirName(options.Name)), nil)
+	if err != nil {
+		log.Err("S3Storage::CreateDir : Failed to create marker for %s [%s]", options.Name, err.Error())
+		return storeErrToErr(err)
+	}
+	return nil
+}
+
+// DeleteDir : Delete every object under the directory along with its marker
+func (s3 *S3Storage) DeleteDir(options internal.DeleteDirOptions) error {
+	log.Trace("S3Storage::DeleteDir : %s", options.Name)
+
+	prefix := s3.client.objectKey(internal.ExtendDirName(options.Name))
+	err := s3.forEachObject(prefix, func(obj *objectInfo) error {
+		err := s3.client.deleteObject(obj.Key)
+		if err != nil {
+			log.Err("S3Storage::DeleteDir : Failed to delete %s [%s]", obj.Key, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err("S3Storage::DeleteDir : Failed to list %s [%s]", options.Name, err.Error())
+		return storeErrToErr(err)
+	}
+
+	// libfuse deletes the files in the directory before this method is called, so the marker alone may be left
+	err = s3.client.deleteObject(prefix)
+	return storeErrToErr(err)
+}
+
+func formatListDirName(path string) string {
+	// If we check the root directory, make sure we pass "" instead of "/"
+	// If we aren't checking the root directory, then we want to extend the directory name so List returns all children and does not include the path itself.
+	if path == "/" {
+		path = ""
+	} else if path != "" {
+		path = internal.ExtendDirName(path)
+	}
+	return path
+}
+
+func (s3 *S3Storage) IsDirEmpty(options internal.IsDirEmptyOptions) bool {
+	log.Trace("S3Storage::IsDirEmpty : %s", options.Name)
+
+	prefix := s3.client.objectKey(formatListDirName(options.Name))
+	result, err := s3.client.listObjects(prefix, "/", "", 2)
+	if err != nil {
+		log.Err("S3Storage::IsDirEmpty : Failed to list %s [%s]", options.Name, err.Error())
+		return false
+	}
+
+	if len(result.CommonPrefixes) > 0 {
+		return false
+	}
+	for _, obj := range result.Contents {
+		if obj.Key != prefix {
+			return false
+		}
+	}
+	return true
+}
+
+func (s3 *S3Storage) ReadDir(options internal.ReadDirOptions) ([]*internal.ObjAttr, error) {
+	log.Trace("S3Storage::ReadDir : %s", options.Name)
+
+	attrList := make([]*internal.ObjAttr, 0)
+	token := ""
+	for {
+		attrs, nextToken, err := s3.StreamDir(internal.StreamDirOptions{Name: options.Name, Token: token})
+		if err != nil {
+			return attrList, err
+		}
+		attrList = append(attrList, attrs...)
+
+		token = nextToken
+		if token == "" {
+			break
+		}
+	}
+
+	return attrList, nil
+}
+
+func (s3 *S3Storage) StreamDir(options internal.StreamDirOptions) ([]*internal.ObjAttr, string, error) {
+	log.Trace("S3Storage::StreamDir : %s, token %s, count %d", options.Name, options.Token, options.Count)
+
+	count := options.Count
+	if count <= 0 || count > maxListResults {
+		count = maxListResults
+	}
+
+	prefix := s3.client.objectKey(formatListDirName(options.Name))
+	attrList := make([]*internal.ObjAttr, 0)
+	token := options.Token
+
+	// A page may hold nothing but the marker of the directory, in which case move on to the next one
+	for {
+		result, err := s3.client.listObjects(prefix, "/", token, count)
+		if err != nil {
+			log.Err("S3Storage::StreamDir : Failed to list %s [%s]", options.Name, err.Error())
+			return attrList, "", storeErrToErr(err)
+		}
+
+		for i := range result.Contents {
+			if result.Contents[i].Key == prefix {
+				continue
+			}
+			attrList = append(attrList, s3.newFileAttr(s3.client.objectName(result.Contents[i].Key), &result.Contents[i]))
+		}
+		for _, p := range result.CommonPrefixes {
+			attrList = append(attrList, newDirAttr(internal.TruncateDirName(s3.client.objectName(p.Prefix)), time.Now()))
+		}
+
+		token = ""
+		if result.IsTruncated {
+			token = result.NextContinuationToken
+		}
+		if len(attrList) > 0 || token == "" {
+			break
+		}
+	}
+
+	log.Debug("S3Storage::StreamDir : Retrieved %d objects with token %s for %s", len(attrList), token, options.Name)
+	return attrList, token, nil
+}
+
+// RenameDir : S3 can not rename, so every object under the directory is copied to the new path and then deleted
+func (s3 *S3Storage) RenameDir(options internal.RenameDirOptions) error {
+	log.Trace("S3Storage::RenameDir : %s to %s", options.Src, options.Dst)
+
+	srcPrefix := s3.client.objectKey(internal.ExtendDirName(options.Src))
+	dstPrefix := s3.client.objectKey(internal.ExtendDirName(options.Dst))
+
+	found := false
+	err := s3.forEachObject(srcPrefix, func(obj *objectInfo) error {
+		found = true
+		dst := dstPrefix + strings.TrimPrefix(obj.Key, srcPrefix)
+		err := s3.copyObject(obj.Key, dst, obj.Size)
+		if err != nil {
+			log.Err("S3Storage::RenameDir : Failed to copy %s to %s [%s]", obj.Key, dst, err.Error())
+			return err
+		}
+		return s3.client.deleteObject(obj.Key)
+	})
+	if err != nil {
+		log.Err("S3Storage::RenameDir : Failed to rename %s to %s [%s]", options.Src, options.Dst, err.Error())
+		return storeErrToErr(err)
+	}
+
+	if !found {
+		return syscall.ENOENT
+	}
+	return nil
+}
+
+// File operations
+func (s3 *S3Storage) CreateFile(options internal.CreateFileOptions) (*handlemap.Handle, error) {
+	log.Trace("S3Storage::CreateFile : %s", options.Name)
+
+	s3.discardStaged(options.Name)
+
+	_, err := s3.client.putObject(s3.client.objectKey(options.Name), nil)
+	if err != nil {
+		log.Err("S3Storage::CreateFile : Failed to create %s [%s]", options.Name, err.Error())
+		return nil, storeErrToErr(err)
+	}
+
+	// Create a handle object for the file being created
+	// This handle will be added to handlemap by the first component in pipeline
+	handle := handlemap.NewHandle(options.Name)
+	handle.Mtime = time.Now()
+	return handle, nil
+}
+
+func (s3 *S3Storage) DeleteFile(options internal.DeleteFileOptions) error {
+	log.Trace("S3Storage::DeleteFile : %s", options.Name)
+
+	key := s3.client.objectKey(options.Name)
+
+	// Deleting an object which does not exist succeeds in S3, so check for it first
+	_, err := s3.client.headObject(key)
+	if err != nil {
+		return storeErrToErr(err)
+	}
+
+	s3.discardStaged(options.Name)
+
+	err = s3.client.deleteObject(key)
+	if err != nil {
+		log.Err("S3Storage::DeleteFile : Failed to delete %s [%s]", options.Name, err.Error())
+		return storeErrToErr(err)
+	}
+	return nil
+}
+
+func (s3 *S3Storage) OpenFile(options internal.OpenFileOptions) (*handlemap.Handle, error) {
+	log.Trace("S3Storage::OpenFile : %s", options.Name)
+
+	attr, err := s3.getAttr(options.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a handle object for the file being opened
+	// This handle will be added to handlemap by the first component in pipeline
+	handle := handlemap.NewHandle(options.Name)
+	handle.Size = attr.Size
+	handle.Mtime = attr.Mtime
+	return handle, nil
+}
+
+func (s3 *S3Storage) RenameFile(options internal.RenameFileOptions) error {
+	log.Trace("S3Storage::RenameFile : %s to %s", options.Src, options.Dst)
+
+	src := s3.client.objectKey(options.Src)
+	info, err := s3.client.headObject(src)
+	if err != nil {
+		return storeErrToErr(err)
+	}
+
+	s3.discardStaged(options.Src)
+	s3.discardStaged(options.Dst)
+
+	err = s3.copyObject(src, s3.client.objectKey(options.Dst), info.Size)
+	if err != nil {
+		log.Err("S3Storage::RenameFile : Failed to copy %s to %s [%s]", options.Src, options.Dst, err.Error())
+		return storeErrToErr(err)
+	}
+
+	err = s3.client.deleteObject(src)
+	if err != nil {
+		log.Err("S3Storage::RenameFile : Failed to delete %s [%s]", options.Src, err.Error())
+		return storeErrToErr(err)
+	}
+	return nil
+}
+
+func (s3 *S3Storage) ReadFile(options internal.ReadFileOptions) ([]byte, error) {
+	log.Trace("S3Storage::ReadFile : %s", options.Handle.Path)
+
+	data, err := s3.client.getObject(s3.client.objectKey(options.Handle.Path), 0, 0, nil)
+	if err != nil {
+		log.Err("S3Storage::ReadFile : Failed to read %s [%s]", options.Handle.Path, err.Error())
+		return nil, storeErrToErr(err)
+	}
+	return data, nil
+}
+
+func (s3 *S3Storage) ReadInBuffer(options internal.ReadInBufferOptions) (int, error) {
+	size := options.Handle.Size
+	if options.Offset > size {
+		return 0, syscall.ERANGE
+	}
+
+	dataLen := int64(len(options.Data))
+	if size < options.Offset+dataLen {
+		dataLen = size - options.Offset
+	}
+	if dataLen == 0 {
+		return 0, nil
+	}
+
+	data, err := s3.client.getObject(s3.client.objectKey(options.Handle.Path), options.Offset, dataLen, options.Data)
+	if err != nil {
+		log.Err("S3Storage::ReadInBuffer : Failed to read %s [%s]", options.Handle.Path, err.Error())
+		return 0, storeErrToErr(err)
+	}
+	return len(data), nil
+}
+
+// WriteFile : Objects can not be modified in place, so the object is rewritten with the data spliced in.
+// Parts untouched by the write are copied on the server side.
+func (s3 *S3Storage) WriteFile(options internal.WriteFileOptions) (int, error) {
+	log.Trace("S3Storage::WriteFile : %s, offset %d, length %d", options.Handle.Path, options.Offset, len(options.Data))
+
+	key := s3.client.objectKey(options.Handle.Path)
+	info, err := s3.client.headObject(key)
+	if err != nil {
+		return 0, storeErrToErr(err)
+	}
+
+	newSize := max(info.Size, options.Offset+int64(len(options.Data)))
+	_, err = s3.rewriteObject(key, info.Size, newSize, options.Offset, options.Data)
+	if err != nil {
+		log.Err("S3Storage::WriteFile : Failed to write %s [%s]", options.Handle.Path, err.Error())
+		return 0, storeErrToErr(err)
+	}
+	return len(options.Data), nil
+}
+
+func (s3 *S3Storage) TruncateFile(options internal.TruncateFileOptions) error {
+	log.Trace("S3Storage::TruncateFile : %s to %d", options.Name, options.Size)
+
+	key := s3.client.objectKey(options.Name)
+	info, err := s3.client.headObject(key)
+	if err != nil {
+		return storeErrToErr(err)
+	}
+
+	if info.Size == options.Size {
+		return nil
+	}
+
+	_, err = s3.rewriteObject(key, info.Size, options.Size, 0, nil)
+	if err != nil {
+		log.Err("S3Storage::TruncateFile : Failed to truncate %s [%s]", options.Name, err.Error())
+		return storeErrToErr(err)
+	}
+	return nil
+}
+
+// CopyToFile : Download the object in to the given file, large objects are fetched with parallel ranged reads
+func (s3 *S3Storage) CopyToFile(options internal.CopyToFileOptions) error {
+	log.Trace("S3Storage::CopyToFile : Read file %s", options.Name)
+
+	key := s3.client.objectKey(options.Name)
+	count := options.Count
+	if count == 0 {
+		info, err := s3.client.headObject(key)
+		if err != nil {
+			return storeErrToErr(err)
+		}
+		count = info.Size - options.Offset
+	}
+
+	err := s3.runParallel(partCount(count, s3.stConfig.partSize), func(i int) error {
+		start := int64(i) * s3.stConfig.partSize
+		length := min(s3.stConfig.partSize, count-start)
+
+		data, err := s3.client.getObject(key, options.Offset+start, length, make([]byte, length))
+		if err != nil {
+			return err
+		}
+		_, err = options.File.WriteAt(data, start)
+		return err
+	})
+	if err != nil {
+		log.Err("S3Storage::CopyToFile : Failed to download %s [%s]", options.Name, err.Error())
+		return storeErrToErr(err)
+	}
+	return nil
+}
+
+// CopyFromFile : Upload the given file as the object, files larger than a part go as a multipart upload
+func (s3 *S3Storage) CopyFromFile(options internal.CopyFromFileOptions) error {
+	log.Trace("S3Storage::CopyFromFile : Upload file %s", options.Name)
+
+	stat, err := options.File.Stat()
+	if err != nil {
+		log.Err("S3Storage::CopyFromFile : Failed to stat local file of %s [%s]", options.Name, err.Error())
+		return err
+	}
+
+	s3.discardStaged(options.Name)
+
+	key := s3.client.objectKey(options.Name)
+	size := stat.Size()
+	readPart := func(start int64, end int64) ([]byte, error) {
+		data := make([]byte, end-start)
+		_, err := options.File.ReadAt(data, start)
+		return data, err
+	}
+
+	etag, err := s3.uploadObject(key, size, readPart)
+	if err != nil {
+		log.Err("S3Storage::CopyFromFile : Failed to upload %s [%s]", options.Name, err.Error())
+		return storeErrToErr(err)
+	}
+
+	if options.ETag != nil {
+		*options.ETag = etag
+	}
+	return nil
+}
+
+// Symlinks are not supported
+func (s3 *S3Storage) CreateLink(options internal.CreateLinkOptions) error {
+	log.Trace("S3Storage::CreateLink : Create symlink %s -> %s", options.Name, options.Target)
+	return syscall.ENOTSUP
+}
+
+// Attribute operations
+func (s3 *S3Storage) GetAttr(options internal.GetAttrOptions) (*internal.ObjAttr, error) {
+	return s3.getAttr(options.Name)
+}
+
+// Objects carry no posix permissions, so mode and ownership changes are either ignored or rejected
+func (s3 *S3Storage) Chmod(options internal.ChmodOptions) error {
+	log.Trace("S3Storage::Chmod : Change mod of file %s", options.Name)
+	if s3.stConfig.failUnsupportedOp {
+		return syscall.ENOTSUP
+	}
+	return nil
+}
+
+func (s3 *S3Storage) Chown(options internal.ChownOptions) error {
+	log.Trace("S3Storage::Chown : Change ownership of file %s to %d-%d", options.Name, options.Owner, options.Group)
+	if s3.stConfig.failUnsupportedOp {
+		return syscall.ENOTSUP
+	}
+	return nil
+}
+
+// Extended attributes are not supported
+func (s3 *S3Storage) GetXattr(options internal.GetXattrOptions) ([]byte, error) {
+	return nil, syscall.ENODATA
+}
+
+func (s3 *S3Storage) SetXattr(options internal.SetXattrOptions) error {
+	return syscall.ENOTSUP
+}
+
+func (s3 *S3Storage) ListXattr(options internal.ListXattrOptions) ([]string, error) {
+	return []string{}, nil
+}
+
+func (s3 *S3Storage) RemoveXattr(options internal.RemoveXattrOptions) error {
+	return syscall.ENOTSUP
+}
+
+// Leases are not supported
+func (s3 *S3Storage) AcquireLease(options internal.AcquireLeaseOptions) error {
+	return syscall.ENOTSUP
+}
+
+func (s3 *S3Storage) RenewLease(options internal.RenewLeaseOptions) error {
+	return syscall.ENOTSUP
+}
+
+func (s3 *S3Storage) ReleaseLease(options internal.ReleaseLeaseOptions) error {
+	return syscall.ENOTSUP
+}
+
+// ------------------------- Helper methods -------------------------------------------
+
+// getAttr : Attributes of the object at the given path. A path with no object but with objects under it is a directory.
+func (s3 *S3Storage) getAttr(name string) (*internal.ObjAttr, error) {
+	if name == "" || name == "/" {
+		return newDirAttr("", time.Now()), nil
+	}
+
+	key := s3.client.objectKey(name)
+	info, err := s3.client.headObject(key)
+	if err == nil {
+		return s3.newFileAttr(name, info), nil
+	}
+
+	err = storeErrToErr(err)
+	if err != syscall.ENOENT {
+		log.Err("S3Storage::getAttr : Failed to get properties of %s [%s]", name, err.Error())
+		return nil, err
+	}
+
+	result, err := s3.client.listObjects(key+"/", "", "", 1)
+	if err != nil {
+		log.Err("S3Storage::getAttr : Failed to list %s [%s]", name, err.Error())
+		return nil, storeErrToErr(err)
+	}
+
+	if len(result.Contents) == 0 {
+		return nil, syscall.ENOENT
+	}
+
+	// Use the time of the marker if the directory has one
+	mtime := time.Now()
+	if result.Contents[0].Key == key+"/" {
+		mtime = result.Contents[0].LastModified
+	}
+	return newDirAttr(name, mtime), nil
+}
+
+// newFileAttr : Objects carry no permissions, so mode is left to the default of the fuse layer
+func (s3 *S3Storage) newFileAttr(name string, info *objectInfo) *internal.ObjAttr {
+	attr := &internal.ObjAttr{
+		Path:   name,
+		Name:   filepath.Base(name),
+		Size:   info.Size,
+		Mode:   0,
+		Mtime:  info.LastModified,
+		Atime:  info.LastModified,
+		Ctime:  info.LastModified,
+		Crtime: info.LastModified,
+		Flags:  internal.NewFileBitMap(),
+		ETag:   info.ETag,
+	}
+	attr.Flags.Set(internal.PropFlagModeDefault)
+	return attr
+}
+
+func newDirAttr(name string, mtime time.Time) *internal.ObjAttr {
+	attr := &internal.ObjAttr{
+		Path:   name,
+		Name:   filepath.Base(name),
+		Size:   4096,
+		Mode:   os.ModeDir,
+		Mtime:  mtime,
+		Atime:  mtime,
+		Ctime:  mtime,
+		Crtime: mtime,
+		Flags:  internal.NewDirBitMap(),
+	}
+	attr.Flags.Set(internal.PropFlagModeDefault)
+	return attr
+}
+
+// forEachObject : Call fn for every object under the given prefix, stopping at the first error
+func (s3 *S3Storage) forEachObject(prefix string, fn func(*objectInfo) error) error {
+	token := ""
+	for {
+		result, err := s3.client.listObjects(prefix, "", token, maxListResults)
+		if err != nil {
+			return err
+		}
+
+		for i := range result.Contents {
+			err = fn(&result.Contents[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// runParallel : Call fn for 0 to count-1 using at most max-concurrency goroutines, returning the first error seen
+func (s3 *S3Storage) runParallel(count int, fn func(int) error) error {
+	if count == 1 {
+		return fn(0)
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, s3.stConfig.maxConcurrency)
+
+	for i := 0; i < count; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(i); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// partCount : Number of parts needed to hold size bytes
+func partCount(size int64, partSize int64) int {
+	return int((size + partSize - 1) / partSize)
+}
+
+// uploadObject : Upload size bytes as the object, reading each part through readPart.
+// Objects up to the part size go in a single request and larger ones as a multipart upload.
+func (s3 *S3Storage) uploadObject(key string, size int64, readPart func(start int64, end int64) ([]byte, error)) (string, error) {
+	if size <= s3.stConfig.partSize {
+		data, err := readPart(0, size)
+		if err != nil {
+			return "", err
+		}
+		return s3.client.putObject(key, data)
+	}
+
+	partSize := s3.stConfig.partSize
+	if partCount(size, partSize) > maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+
+	uploadID, err := s3.client.createMultipartUpload(key)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]completedPart, partCount(size, partSize))
+	err = s3.runParallel(len(parts), func(i int) error {
+		start := int64(i) * partSize
+		data, err := readPart(start, min(start+partSize, size))
+		if err != nil {
+			return err
+		}
+		parts[i].PartNumber = i + 1
+		parts[i].ETag, err = s3.client.uploadPart(key, uploadID, i+1, data)
+		return err
+	})
+
+	etag := ""
+	if err == nil {
+		etag, err = s3.client.completeMultipartUpload(key, uploadID, parts)
+	}
+	if err != nil {
+		_ = s3.client.abortMultipartUpload(key, uploadID)
+		return "", err
+	}
+	return etag, nil
+}
+
+// rewriteObject : Write the object again with newSize bytes, where data is placed at offset and
+// the rest comes from the current content of the object padded with zeros.
+// Parts which only hold current content are copied on the server side.
+func (s3 *S3Storage) rewriteObject(key string, size int64, newSize int64, offset int64, data []byte) (string, error) {
+	dataEnd := offset + int64(len(data))
+	touched := func(start int64, end int64) bool {
+		return end > size || (len(data) > 0 && offset < end && dataEnd > start)
+	}
+
+	readPart := func(start int64, end int64) ([]byte, error) {
+		buf := make([]byte, end-start)
+		if start < size {
+			_, err := s3.client.getObject(key, start, min(end, size)-start, buf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(data) > 0 && offset < end && dataEnd > start {
+			from := max(offset, start)
+			copy(buf[from-start:], data[from-offset:min(dataEnd, end)-offset])
+		}
+		return buf, nil
+	}
+
+	if newSize <= s3.stConfig.partSize {
+		return s3.uploadObject(key, newSize, readPart)
+	}
+
+	partSize := s3.stConfig.partSize
+	if partCount(newSize, partSize) > maxParts {
+		return "", syscall.EFBIG
+	}
+
+	uploadID, err := s3.client.createMultipartUpload(key)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]completedPart, partCount(newSize, partSize))
+	err = s3.runParallel(len(parts), func(i int) error {
+		start := int64(i) * partSize
+		end := min(start+partSize, newSize)
+		parts[i].PartNumber = i + 1
+
+		var err error
+		if !touched(start, end) {
+			parts[i].ETag, err = s3.client.uploadPartCopy(key, uploadID, i+1, key, start, end-start)
+			return err
+		}
+
+		buf, err := readPart(start, end)
+		if err != nil {
+			return err
+		}
+		parts[i].ETag, err = s3.client.uploadPart(key, uploadID, i+1, buf)
+		return err
+	})
+
+	etag := ""
+	if err == nil {
+		etag, err = s3.client.completeMultipartUpload(key, uploadID, parts)
+	}
+	if err != nil {
+		_ = s3.client.abortMultipartUpload(key, uploadID)
+		return "", err
+	}
+	return etag, nil
+}
+
+// copyObject : Server side copy, objects over 5GiB have to be copied part by part
+func (s3 *S3Storage) copyObject(src string, dst string, size int64) error {
+	if size <= maxCopySize {
+		_, err := s3.client.copyObject(src, dst)
+		return err
+	}
+
+	partSize := max(s3.stConfig.partSize, (size+maxParts-1)/maxParts)
+
+	uploadID, err := s3.client.createMultipartUpload(dst)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]completedPart, partCount(size, partSize))
+	err = s3.runParallel(len(parts), func(i int) error {
+		start := int64(i) * partSize
+		var err error
+		parts[i].PartNumber = i + 1
+		parts[i].ETag, err = s3.client.uploadPartCopy(dst, uploadID, i+1, src, start, min(partSize, size-start))
+		return err
+	})
+
+	if err == nil {
+		_, err = s3.client.completeMultipartUpload(dst, uploadID, parts)
+	}
+	if err != nil {
+		_ = s3.client.abortMultipartUpload(dst, uploadID)
+	}
+	return err
+}
+
+// ------------------------- Factory methods to create objects -------------------------------------------
+
+// Constructor to create object of this component
+func NewS3StorageComponent() internal.Component {
+	s3 := &S3Storage{}
+	s3.SetName(compName)
+	return s3
+}
+
+// On init register this component to pipeline and supply your constructor
+func init() {
+	internal.AddComponent(compName, NewS3StorageComponent)
+
+	bucketName := config.AddStringFlag("s3-bucket", "", "Name of the S3 bucket to be mounted")
+	config.BindPFlag(compName+".bucket-name", bucketName)
+}
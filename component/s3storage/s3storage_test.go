@@ -0,0 +1,546 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/handlemap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const testBucket = "testbucket"
+const partSize = 5 * common.MbToBytes
+
+type s3StorageTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	server *fakeS3Server
+	s3     *S3Storage
+}
+
+func newTestS3Storage(configuration string) (*S3Storage, error) {
+	_ = config.ReadConfigFromReader(strings.NewReader(configuration))
+	s3 := NewS3StorageComponent()
+	err := s3.Configure(true)
+
+	return s3.(*S3Storage), err
+}
+
+func (suite *s3StorageTestSuite) SetupTest() {
+	err := log.SetDefaultLogger("silent", common.LogConfig{})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+
+	suite.assert = assert.New(suite.T())
+	suite.server = newFakeS3Server(testBucket)
+	suite.setupTestHelper(fmt.Sprintf("s3storage:\n  bucket-name: %s\n  endpoint: %s\n  key-id: id\n  secret-key: key\n  block-size-mb: 5\n  max-retries: 1\n",
+		testBucket, suite.server.URL))
+}
+
+func (suite *s3StorageTestSuite) setupTestHelper(configuration string) {
+	var err error
+	suite.s3, err = newTestS3Storage(configuration)
+	suite.assert.NoError(err)
+	_ = suite.s3.Start(context.Background())
+}
+
+func (suite *s3StorageTestSuite) cleanupTest() {
+	_ = suite.s3.Stop()
+	suite.server.Close()
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	return data
+}
+
+func (suite *s3StorageTestSuite) TestDefault() {
+	defer suite.cleanupTest()
+	suite.assert.Equal("s3storage", suite.s3.Name())
+	suite.assert.Equal(testBucket, suite.s3.stConfig.bucket)
+	suite.assert.Equal("us-east-1", suite.s3.stConfig.region)
+	suite.assert.True(suite.s3.stConfig.usePathStyle)
+	suite.assert.EqualValues(partSize, suite.s3.stConfig.partSize)
+	suite.assert.EqualValues(defaultMaxConcurrency, suite.s3.stConfig.maxConcurrency)
+	suite.assert.EqualValues(1, suite.s3.stConfig.maxRetries)
+}
+
+func (suite *s3StorageTestSuite) TestConfigAWSEndpoint() {
+	defer suite.cleanupTest()
+	// Credentials are validated only in the parent, skip it as there is no such service to talk to
+	_ = config.ReadConfigFromReader(strings.NewReader("s3storage:\n  bucket-name: mybucket\n  region: eu-west-1\n  key-id: id\n  secret-key: key\n"))
+	suite.s3 = NewS3StorageComponent().(*S3Storage)
+	suite.assert.NoError(suite.s3.Configure(false))
+
+	suite.assert.Equal("https://s3.eu-west-1.amazonaws.com", suite.s3.stConfig.endpoint.String())
+	suite.assert.False(suite.s3.stConfig.usePathStyle)
+	suite.assert.EqualValues(defaultPartSizeMB*common.MbToBytes, suite.s3.stConfig.partSize)
+	suite.assert.Equal("https://mybucket.s3.eu-west-1.amazonaws.com/dir/a%20b", suite.s3.client.requestURL("dir/a b", nil).String())
+}
+
+func (suite *s3StorageTestSuite) TestConfigErrors() {
+	defer suite.cleanupTest()
+
+	_, err := newTestS3Storage("s3storage:\n  key-id: id\n  secret-key: key\n")
+	suite.assert.ErrorContains(err, "bucket name not provided")
+
+	_, err = newTestS3Storage("s3storage:\n  bucket-name: b\n  block-size-mb: 1\n  key-id: id\n  secret-key: key\n")
+	suite.assert.ErrorContains(err, "block-size-mb must be at least 5")
+
+	_, err = newTestS3Storage("block_cache:\n  block-size-mb: 2\ns3storage:\n  bucket-name: b\n  key-id: id\n  secret-key: key\n")
+	suite.assert.ErrorContains(err, "block_cache.block-size-mb must be at least 5")
+}
+
+func (suite *s3StorageTestSuite) TestConfigBlockCacheBlockSize() {
+	defer suite.cleanupTest()
+	s3, err := newTestS3Storage(fmt.Sprintf("block_cache:\n  block-size-mb: 8\ns3storage:\n  bucket-name: %s\n  endpoint: %s\n  key-id: id\n  secret-key: key\n",
+		testBucket, suite.server.URL))
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(8*common.MbToBytes, s3.stConfig.partSize)
+}
+
+func (suite *s3StorageTestSuite) TestConfigAuthFailure() {
+	defer suite.cleanupTest()
+	suite.server.failNext = http.StatusForbidden
+	_, err := newTestS3Storage(fmt.Sprintf("s3storage:\n  bucket-name: %s\n  endpoint: %s\n  key-id: id\n  secret-key: key\n", testBucket, suite.server.URL))
+	suite.assert.ErrorContains(err, "failed to authenticate credentials")
+}
+
+func (suite *s3StorageTestSuite) TestSubdirectory() {
+	defer suite.cleanupTest()
+	suite.setupTestHelper(fmt.Sprintf("s3storage:\n  bucket-name: %s\n  endpoint: %s\n  key-id: id\n  secret-key: key\n  subdirectory: /sub/\n",
+		testBucket, suite.server.URL))
+
+	_, err := suite.s3.CreateFile(internal.CreateFileOptions{Name: "file"})
+	suite.assert.NoError(err)
+	_, found := suite.server.getObject("sub/file")
+	suite.assert.True(found)
+
+	entries, err := suite.s3.ReadDir(internal.ReadDirOptions{Name: ""})
+	suite.assert.NoError(err)
+	suite.assert.Len(entries, 1)
+	suite.assert.Equal("file", entries[0].Path)
+}
+
+func (suite *s3StorageTestSuite) TestCreateDir() {
+	defer suite.cleanupTest()
+	err := suite.s3.CreateDir(internal.CreateDirOptions{Name: "dir"})
+	suite.assert.NoError(err)
+
+	_, found := suite.server.getObject("dir/")
+	suite.assert.True(found)
+
+	attr, err := suite.s3.GetAttr(internal.GetAttrOptions{Name: "dir"})
+	suite.assert.NoError(err)
+	suite.assert.True(attr.IsDir())
+	suite.assert.True(suite.s3.IsDirEmpty(internal.IsDirEmptyOptions{Name: "dir"}))
+}
+
+func (suite *s3StorageTestSuite) TestImplicitDir() {
+	defer suite.cleanupTest()
+	suite.server.putObject("a/b/c.txt", []byte("data"))
+
+	attr, err := suite.s3.GetAttr(internal.GetAttrOptions{Name: "a/b"})
+	suite.assert.NoError(err)
+	suite.assert.True(attr.IsDir())
+	suite.assert.False(suite.s3.IsDirEmpty(internal.IsDirEmptyOptions{Name: "a"}))
+
+	_, err = suite.s3.GetAttr(internal.GetAttrOptions{Name: "a/c"})
+	suite.assert.Equal(syscall.ENOENT, err)
+}
+
+func (suite *s3StorageTestSuite) TestStreamDir() {
+	defer suite.cleanupTest()
+	suite.server.putObject("dir/", nil)
+	suite.server.putObject("dir/sub/file", nil)
+	for i := 0; i < 5; i++ {
+		suite.server.putObject(fmt.Sprintf("dir/file%d", i), []byte("data"))
+	}
+
+	entries, token, err := suite.s3.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 4})
+	suite.assert.NoError(err)
+	suite.assert.NotEmpty(token)
+	// The marker of the directory takes a slot in the first page but is not returned
+	suite.assert.Len(entries, 3)
+	suite.assert.Equal("dir/file0", entries[0].Path)
+	suite.assert.Equal("file0", entries[0].Name)
+	suite.assert.EqualValues(4, entries[0].Size)
+
+	entries, token, err = suite.s3.StreamDir(internal.StreamDirOptions{Name: "dir", Count: 4, Token: token})
+	suite.assert.NoError(err)
+	suite.assert.Empty(token)
+	suite.assert.Len(entries, 3)
+	suite.assert.Equal("dir/sub", entries[2].Path)
+	suite.assert.True(entries[2].IsDir())
+
+	entries, err = suite.s3.ReadDir(internal.ReadDirOptions{Name: "dir"})
+	suite.assert.NoError(err)
+	suite.assert.Len(entries, 6)
+}
+
+func (suite *s3StorageTestSuite) TestDeleteDir() {
+	defer suite.cleanupTest()
+	suite.server.putObject("dir/", nil)
+	suite.server.putObject("dir/a", []byte("a"))
+	suite.server.putObject("dir/sub/b", []byte("b"))
+	suite.server.putObject("dirx", []byte("x"))
+
+	err := suite.s3.DeleteDir(internal.DeleteDirOptions{Name: "dir"})
+	suite.assert.NoError(err)
+
+	suite.server.Lock()
+	suite.assert.Len(suite.server.objects, 1)
+	suite.assert.Contains(suite.server.objects, "dirx")
+	suite.server.Unlock()
+}
+
+func (suite *s3StorageTestSuite) TestRenameDir() {
+	defer suite.cleanupTest()
+	suite.server.putObject("src/", nil)
+	suite.server.putObject("src/a", []byte("a"))
+	suite.server.putObject("src/sub/b", []byte("b"))
+
+	err := suite.s3.RenameDir(internal.RenameDirOptions{Src: "src", Dst: "dst"})
+	suite.assert.NoError(err)
+
+	data, found := suite.server.getObject("dst/sub/b")
+	suite.assert.True(found)
+	suite.assert.Equal([]byte("b"), data)
+	_, found = suite.server.getObject("dst/")
+	suite.assert.True(found)
+	_, found = suite.server.getObject("src/a")
+	suite.assert.False(found)
+
+	err = suite.s3.RenameDir(internal.RenameDirOptions{Src: "src", Dst: "dst"})
+	suite.assert.Equal(syscall.ENOENT, err)
+}
+
+func (suite *s3StorageTestSuite) TestCreateOpenDeleteFile() {
+	defer suite.cleanupTest()
+	handle, err := suite.s3.CreateFile(internal.CreateFileOptions{Name: "dir/file"})
+	suite.assert.NoError(err)
+	suite.assert.Equal("dir/file", handle.Path)
+
+	suite.server.putObject("dir/file", []byte("hello"))
+	handle, err = suite.s3.OpenFile(internal.OpenFileOptions{Name: "dir/file", Flags: os.O_RDONLY})
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(5, handle.Size)
+
+	attr, err := suite.s3.GetAttr(internal.GetAttrOptions{Name: "dir/file"})
+	suite.assert.NoError(err)
+	suite.assert.False(attr.IsDir())
+	suite.assert.Equal("file", attr.Name)
+	suite.assert.NotEmpty(attr.ETag)
+
+	err = suite.s3.DeleteFile(internal.DeleteFileOptions{Name: "dir/file"})
+	suite.assert.NoError(err)
+
+	err = suite.s3.DeleteFile(internal.DeleteFileOptions{Name: "dir/file"})
+	suite.assert.Equal(syscall.ENOENT, err)
+
+	_, err = suite.s3.OpenFile(internal.OpenFileOptions{Name: "dir/file"})
+	suite.assert.Equal(syscall.ENOENT, err)
+}
+
+func (suite *s3StorageTestSuite) TestRenameFile() {
+	defer suite.cleanupTest()
+	suite.server.putObject("a b+c", []byte("data"))
+
+	err := suite.s3.RenameFile(internal.RenameFileOptions{Src: "a b+c", Dst: "dir/new"})
+	suite.assert.NoError(err)
+
+	data, found := suite.server.getObject("dir/new")
+	suite.assert.True(found)
+	suite.assert.Equal([]byte("data"), data)
+	_, found = suite.server.getObject("a b+c")
+	suite.assert.False(found)
+
+	err = suite.s3.RenameFile(internal.RenameFileOptions{Src: "a b+c", Dst: "dir/new"})
+	suite.assert.Equal(syscall.ENOENT, err)
+}
+
+func (suite *s3StorageTestSuite) TestReadInBuffer() {
+	defer suite.cleanupTest()
+	suite.server.putObject("file", []byte("hello world"))
+
+	handle := handlemap.NewHandle("file")
+	handle.Size = 11
+
+	data := make([]byte, 5)
+	n, err := suite.s3.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: 6, Data: data})
+	suite.assert.NoError(err)
+	suite.assert.Equal(5, n)
+	suite.assert.Equal([]byte("world"), data)
+
+	data = make([]byte, 10)
+	n, err = suite.s3.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: 8, Data: data})
+	suite.assert.NoError(err)
+	suite.assert.Equal(3, n)
+	suite.assert.Equal([]byte("rld"), data[:n])
+
+	_, err = suite.s3.ReadInBuffer(internal.ReadInBufferOptions{Handle: handle, Offset: 12, Data: data})
+	suite.assert.Equal(syscall.ERANGE, err)
+
+	all, err := suite.s3.ReadFile(internal.ReadFileOptions{Handle: handle})
+	suite.assert.NoError(err)
+	suite.assert.Equal([]byte("hello world"), all)
+}
+
+func (suite *s3StorageTestSuite) TestCopyFromFileToFile() {
+	defer suite.cleanupTest()
+	data := randomData(2*partSize + 100)
+
+	src, err := os.CreateTemp("", "s3src")
+	suite.assert.NoError(err)
+	defer os.Remove(src.Name())
+	_, _ = src.Write(data)
+
+	etag := ""
+	err = suite.s3.CopyFromFile(internal.CopyFromFileOptions{Name: "big", File: src, ETag: &etag})
+	suite.assert.NoError(err)
+	suite.assert.NotEmpty(etag)
+	suite.assert.Equal(3, suite.server.count("part"))
+	suite.assert.Equal(0, suite.server.pendingUploads())
+
+	stored, _ := suite.server.getObject("big")
+	suite.assert.True(bytes.Equal(data, stored))
+
+	dst, err := os.CreateTemp("", "s3dst")
+	suite.assert.NoError(err)
+	defer os.Remove(dst.Name())
+
+	err = suite.s3.CopyToFile(internal.CopyToFileOptions{Name: "big", File: dst})
+	suite.assert.NoError(err)
+	downloaded, _ := os.ReadFile(dst.Name())
+	suite.assert.True(bytes.Equal(data, downloaded))
+	suite.assert.Equal(3, suite.server.count("get"))
+
+	// Small files go in a single request
+	_ = src.Truncate(10)
+	err = suite.s3.CopyFromFile(internal.CopyFromFileOptions{Name: "small", File: src})
+	suite.assert.NoError(err)
+	suite.assert.Equal(1, suite.server.count("put"))
+}
+
+func (suite *s3StorageTestSuite) TestTruncateFile() {
+	defer suite.cleanupTest()
+	data := randomData(partSize + 10)
+	suite.server.putObject("file", data)
+
+	// Growing keeps the first part on the server and pads the rest with zeros
+	err := suite.s3.TruncateFile(internal.TruncateFileOptions{Name: "file", Size: 3 * partSize})
+	suite.assert.NoError(err)
+	stored, _ := suite.server.getObject("file")
+	suite.assert.Len(stored, 3*partSize)
+	suite.assert.True(bytes.Equal(data, stored[:len(data)]))
+	suite.assert.True(isZero(stored[len(data):]))
+	suite.assert.Equal(1, suite.server.count("partcopy"))
+
+	err = suite.s3.TruncateFile(internal.TruncateFileOptions{Name: "file", Size: 5})
+	suite.assert.NoError(err)
+	stored, _ = suite.server.getObject("file")
+	suite.assert.Equal(data[:5], stored)
+
+	err = suite.s3.TruncateFile(internal.TruncateFileOptions{Name: "missing", Size: 5})
+	suite.assert.Equal(syscall.ENOENT, err)
+}
+
+func (suite *s3StorageTestSuite) TestWriteFile() {
+	defer suite.cleanupTest()
+	suite.server.putObject("file", []byte("hello world"))
+
+	handle := handlemap.NewHandle("file")
+	n, err := suite.s3.WriteFile(internal.WriteFileOptions{Handle: handle, Offset: 6, Data: []byte("there!")})
+	suite.assert.NoError(err)
+	suite.assert.Equal(6, n)
+
+	stored, _ := suite.server.getObject("file")
+	suite.assert.Equal([]byte("hello there!"), stored)
+}
+
+func (suite *s3StorageTestSuite) TestStageAndCommit() {
+	defer suite.cleanupTest()
+	_, err := suite.s3.CreateFile(internal.CreateFileOptions{Name: "file"})
+	suite.assert.NoError(err)
+
+	blocks := [][]byte{randomData(partSize), randomData(partSize), randomData(100)}
+	ids := []string{"id0", "id1", "id2"}
+	for i := range blocks {
+		err = suite.s3.StageData(internal.StageDataOptions{Name: "file", Id: ids[i], Data: blocks[i], Offset: uint64(i * partSize)})
+		suite.assert.NoError(err)
+	}
+	// Only the full block at an aligned offset other than the first goes up straight away
+	suite.assert.Equal(1, suite.server.count("part"))
+
+	etag := ""
+	err = suite.s3.CommitData(internal.CommitDataOptions{Name: "file", List: ids, BlockSize: partSize, ETag: &etag})
+	suite.assert.NoError(err)
+	suite.assert.Equal(3, suite.server.count("part"))
+	suite.assert.Equal(0, suite.server.pendingUploads())
+
+	stored, _ := suite.server.getObject("file")
+	suite.assert.True(bytes.Equal(bytes.Join(blocks, nil), stored))
+	attr, _ := suite.s3.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.Equal(attr.ETag, etag)
+
+	// Committing again from the same handle reuses the blocks already in the object
+	blocks[1] = randomData(partSize)
+	err = suite.s3.StageData(internal.StageDataOptions{Name: "file", Id: "id3", Data: blocks[1], Offset: partSize})
+	suite.assert.NoError(err)
+	err = suite.s3.CommitData(internal.CommitDataOptions{Name: "file", List: []string{"id0", "id3", "id2"}, BlockSize: partSize})
+	suite.assert.NoError(err)
+	suite.assert.Equal(2, suite.server.count("partcopy"))
+
+	stored, _ = suite.server.getObject("file")
+	suite.assert.True(bytes.Equal(bytes.Join(blocks, nil), stored))
+}
+
+func (suite *s3StorageTestSuite) TestCommitCommittedBlocks() {
+	defer suite.cleanupTest()
+	data := randomData(2*partSize + 10)
+	suite.server.putObject("file", data)
+
+	list, err := suite.s3.GetCommittedBlockList("file")
+	suite.assert.NoError(err)
+	suite.assert.Len(*list, 3)
+	suite.assert.EqualValues(partSize, (*list)[0].Size)
+	suite.assert.EqualValues(10, (*list)[2].Size)
+	suite.assert.EqualValues(partSize, (*list)[1].Offset)
+
+	// Replace the middle block and append a new one with a gap of zeros before it
+	middle := randomData(partSize)
+	last := randomData(partSize)
+	copy(data[partSize:], middle)
+	err = suite.s3.StageData(internal.StageDataOptions{Name: "file", Id: "new", Data: middle, Offset: partSize})
+	suite.assert.NoError(err)
+	err = suite.s3.StageData(internal.StageDataOptions{Name: "file", Id: "zero", Data: make([]byte, partSize)})
+	suite.assert.NoError(err)
+	err = suite.s3.StageData(internal.StageDataOptions{Name: "file", Id: "last", Data: last, Offset: 4 * partSize})
+	suite.assert.NoError(err)
+
+	// The short last block of the object gives way to zeros and a new last block
+	ids := []string{(*list)[0].Id, "new", "zero", "zero", "last"}
+	err = suite.s3.CommitData(internal.CommitDataOptions{Name: "file", List: ids, BlockSize: partSize})
+	suite.assert.NoError(err)
+
+	expected := append(append(data[:2*partSize], make([]byte, 2*partSize)...), last...)
+	stored, _ := suite.server.getObject("file")
+	suite.assert.True(bytes.Equal(expected, stored))
+	suite.assert.Equal(0, suite.server.pendingUploads())
+
+	err = suite.s3.CommitData(internal.CommitDataOptions{Name: "file", List: []string{"unknown"}, BlockSize: partSize})
+	suite.assert.Equal(syscall.EINVAL, err)
+}
+
+func (suite *s3StorageTestSuite) TestCommitEmptyAndSmall() {
+	defer suite.cleanupTest()
+	err := suite.s3.CommitData(internal.CommitDataOptions{Name: "empty", List: []string{}, BlockSize: partSize})
+	suite.assert.NoError(err)
+	stored, found := suite.server.getObject("empty")
+	suite.assert.True(found)
+	suite.assert.Empty(stored)
+
+	err = suite.s3.StageData(internal.StageDataOptions{Name: "small", Id: "id", Data: []byte("small")})
+	suite.assert.NoError(err)
+	err = suite.s3.CommitData(internal.CommitDataOptions{Name: "small", List: []string{"id"}, BlockSize: partSize})
+	suite.assert.NoError(err)
+	stored, _ = suite.server.getObject("small")
+	suite.assert.Equal([]byte("small"), stored)
+	suite.assert.Equal(0, suite.server.count("create"))
+}
+
+func (suite *s3StorageTestSuite) TestDiscardStaged() {
+	defer suite.cleanupTest()
+	suite.server.putObject("file", nil)
+
+	err := suite.s3.StageData(internal.StageDataOptions{Name: "file", Id: "id", Data: randomData(partSize), Offset: partSize})
+	suite.assert.NoError(err)
+	suite.assert.Equal(1, suite.server.pendingUploads())
+
+	err = suite.s3.DeleteFile(internal.DeleteFileOptions{Name: "file"})
+	suite.assert.NoError(err)
+	suite.assert.Equal(0, suite.server.pendingUploads())
+
+	err = suite.s3.StageData(internal.StageDataOptions{Name: "other", Id: "id", Data: randomData(partSize), Offset: partSize})
+	suite.assert.NoError(err)
+	_ = suite.s3.Stop()
+	suite.assert.Equal(0, suite.server.pendingUploads())
+}
+
+func (suite *s3StorageTestSuite) TestRetry() {
+	defer suite.cleanupTest()
+	suite.server.putObject("file", []byte("data"))
+
+	suite.server.failNext = http.StatusServiceUnavailable
+	attr, err := suite.s3.GetAttr(internal.GetAttrOptions{Name: "file"})
+	suite.assert.NoError(err)
+	suite.assert.EqualValues(4, attr.Size)
+	suite.assert.Equal(0, suite.server.failNext)
+	suite.assert.Equal(1, suite.server.count("head"))
+}
+
+func (suite *s3StorageTestSuite) TestUnsupported() {
+	defer suite.cleanupTest()
+	suite.assert.NoError(suite.s3.Chmod(internal.ChmodOptions{Name: "file", Mode: 0777}))
+	suite.assert.Equal(syscall.ENOTSUP, suite.s3.CreateLink(internal.CreateLinkOptions{Name: "link", Target: "file"}))
+	suite.assert.Equal(syscall.ENOTSUP, suite.s3.AcquireLease(internal.AcquireLeaseOptions{Name: "file"}))
+
+	_, err := suite.s3.GetXattr(internal.GetXattrOptions{Name: "file", Attr: "user.a"})
+	suite.assert.Equal(syscall.ENODATA, err)
+
+	suite.s3.stConfig.failUnsupportedOp = true
+	suite.assert.Equal(syscall.ENOTSUP, suite.s3.Chmod(internal.ChmodOptions{Name: "file", Mode: 0777}))
+	suite.assert.Equal(syscall.ENOTSUP, suite.s3.Chown(internal.ChownOptions{Name: "file", Owner: 1, Group: 1}))
+}
+
+func TestS3StorageTestSuite(t *testing.T) {
+	suite.Run(t, new(s3StorageTestSuite))
+}
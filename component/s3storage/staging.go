@@ -0,0 +1,327 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package s3storage
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"sync"
+	"syscall"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+)
+
+// Block cache stages blocks of a file and later commits them as an ordered list of block ids.
+// S3 has no block ids, only multipart uploads where parts are put together in order of their part numbers.
+//
+// A full block staged at an offset aligned to its size is uploaded straight away as part (offset / size) + 1 of a
+// multipart upload on the object, which is where it lands when the blocks are committed in order of their offsets.
+// The first block, short blocks and blocks of zeros are kept in memory and uploaded at commit, as their position
+// in the final list is not known yet or the same block is used at many positions.
+//
+// Blocks of an existing object are reported as ranges of it, encoded in the block id, and copied on the server side
+// when committed. Once committed, staged blocks turn in to ranges of the new object so the same handle can commit again.
+
+// stagedPart : A block staged by block cache
+type stagedPart struct {
+	number    int    // part number the block was uploaded as, 0 if it is held in memory
+	etag      string // etag of the uploaded part
+	size      int64
+	data      []byte // content of a block held in memory
+	zero      bool   // block holds nothing but zeros
+	committed bool   // block is the range [offset, offset+size) of the object
+	offset    int64
+}
+
+// stagedUpload : Blocks staged for one file and the multipart upload they were uploaded to
+type stagedUpload struct {
+	sync.Mutex
+	uploadID string
+	parts    map[string]*stagedPart
+}
+
+// getStaged : Get the staged blocks of a file, creating an empty set if there are none
+func (s3 *S3Storage) getStaged(name string) *stagedUpload {
+	upload, _ := s3.uploads.LoadOrStore(name, &stagedUpload{parts: make(map[string]*stagedPart)})
+	return upload.(*stagedUpload)
+}
+
+// discardStaged : Forget the staged blocks of a file and abort its multipart upload, if any
+func (s3 *S3Storage) discardStaged(name string) {
+	val, found := s3.uploads.LoadAndDelete(name)
+	if !found {
+		return
+	}
+
+	upload := val.(*stagedUpload)
+	upload.Lock()
+	defer upload.Unlock()
+
+	if upload.uploadID != "" {
+		err := s3.client.abortMultipartUpload(s3.client.objectKey(name), upload.uploadID)
+		if err != nil {
+			log.Err("S3Storage::discardStaged : Failed to abort upload of %s [%s]", name, err.Error())
+		}
+		upload.uploadID = ""
+	}
+}
+
+// startUpload : Id of the multipart upload of the file, starting one if needed
+func (s3 *S3Storage) startUpload(key string, upload *stagedUpload) (string, error) {
+	upload.Lock()
+	defer upload.Unlock()
+
+	if upload.uploadID == "" {
+		uploadID, err := s3.client.createMultipartUpload(key)
+		if err != nil {
+			return "", err
+		}
+		upload.uploadID = uploadID
+	}
+	return upload.uploadID, nil
+}
+
+// committedBlockID : Id of the block covering the given range of the object
+func committedBlockID(offset int64, size int64) string {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(id[8:16], uint64(size))
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+// parseCommittedBlockID : Range of the object covered by the given block id
+func parseCommittedBlockID(id string) (*stagedPart, bool) {
+	data, err := base64.StdEncoding.DecodeString(id)
+	if err != nil || len(data) != 16 {
+		return nil, false
+	}
+
+	return &stagedPart{
+		committed: true,
+		offset:    int64(binary.BigEndian.Uint64(data[0:8])),
+		size:      int64(binary.BigEndian.Uint64(data[8:16])),
+	}, true
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCommittedBlockList : Split the object in to blocks of the part size
+func (s3 *S3Storage) GetCommittedBlockList(name string) (*internal.CommittedBlockList, error) {
+	log.Trace("S3Storage::GetCommittedBlockList : %s", name)
+
+	info, err := s3.client.headObject(s3.client.objectKey(name))
+	if err != nil {
+		return nil, storeErrToErr(err)
+	}
+
+	blockList := make(internal.CommittedBlockList, 0, partCount(info.Size, s3.stConfig.partSize))
+	for offset := int64(0); offset < info.Size; offset += s3.stConfig.partSize {
+		size := min(s3.stConfig.partSize, info.Size-offset)
+		blockList = append(blockList, internal.CommittedBlock{
+			Id:     committedBlockID(offset, size),
+			Offset: offset,
+			Size:   uint64(size),
+		})
+	}
+
+	return &blockList, nil
+}
+
+func (s3 *S3Storage) StageData(opt internal.StageDataOptions) error {
+	upload := s3.getStaged(opt.Name)
+	size := int64(len(opt.Data))
+	part := &stagedPart{size: size}
+
+	if opt.Offset == 0 || size < minPartSize || int64(opt.Offset)%size != 0 || int64(opt.Offset)/size >= maxParts {
+		if isZero(opt.Data) {
+			part.zero = true
+		} else {
+			part.data = make([]byte, size)
+			copy(part.data, opt.Data)
+		}
+
+		upload.Lock()
+		upload.parts[opt.Id] = part
+		upload.Unlock()
+		return nil
+	}
+
+	key := s3.client.objectKey(opt.Name)
+	uploadID, err := s3.startUpload(key, upload)
+	if err != nil {
+		log.Err("S3Storage::StageData : Failed to start upload of %s [%s]", opt.Name, err.Error())
+		return storeErrToErr(err)
+	}
+
+	part.number = int(int64(opt.Offset)/size) + 1
+	part.etag, err = s3.client.uploadPart(key, uploadID, part.number, opt.Data)
+	if err != nil {
+		log.Err("S3Storage::StageData : Failed to upload part %d of %s [%s]", part.number, opt.Name, err.Error())
+		return storeErrToErr(err)
+	}
+
+	upload.Lock()
+	defer upload.Unlock()
+
+	// A block staged again at the same offset replaced the part uploaded earlier
+	for id, p := range upload.parts {
+		if p.number == part.number {
+			delete(upload.parts, id)
+		}
+	}
+	upload.parts[opt.Id] = part
+	return nil
+}
+
+func (s3 *S3Storage) CommitData(opt internal.CommitDataOptions) error {
+	log.Trace("S3Storage::CommitData : %s, %d blocks", opt.Name, len(opt.List))
+
+	if len(opt.List) > maxParts {
+		log.Err("S3Storage::CommitData : %s has %d blocks, S3 allows at most %d", opt.Name, len(opt.List), maxParts)
+		return syscall.EFBIG
+	}
+
+	key := s3.client.objectKey(opt.Name)
+	upload := s3.getStaged(opt.Name)
+
+	// Resolve the blocks while holding the lock, uploads happen outside of it
+	upload.Lock()
+	blocks := make([]*stagedPart, len(opt.List))
+	for i, id := range opt.List {
+		part, found := upload.parts[id]
+		if !found {
+			part, found = parseCommittedBlockID(id)
+		}
+		if !found || (part.number != 0 && part.number != i+1) {
+			upload.Unlock()
+			log.Err("S3Storage::CommitData : Block %s of %s can not be placed at %d", id, opt.Name, i)
+			return syscall.EINVAL
+		}
+		blocks[i] = part
+	}
+	upload.Unlock()
+
+	etag, err := s3.commitBlocks(key, upload, blocks)
+	if err != nil {
+		log.Err("S3Storage::CommitData : Failed to commit blocks of %s [%s]", opt.Name, err.Error())
+		return storeErrToErr(err)
+	}
+
+	// Staged blocks are now part of the object
+	upload.Lock()
+	upload.uploadID = ""
+	parts := make(map[string]*stagedPart)
+	offset := int64(0)
+	for i, id := range opt.List {
+		if blocks[i].zero {
+			parts[id] = blocks[i]
+		} else {
+			parts[id] = &stagedPart{committed: true, offset: offset, size: blocks[i].size}
+		}
+		offset += blocks[i].size
+	}
+	upload.parts = parts
+	upload.Unlock()
+
+	if opt.ETag != nil {
+		*opt.ETag = etag
+	}
+	return nil
+}
+
+// commitBlocks : Complete the multipart upload of the file with the given blocks in order
+func (s3 *S3Storage) commitBlocks(key string, upload *stagedUpload, blocks []*stagedPart) (string, error) {
+	// An empty or a single block file is written in one request
+	if len(blocks) == 0 || (len(blocks) == 1 && blocks[0].number == 0 && !blocks[0].committed) {
+		data := []byte{}
+		if len(blocks) == 1 {
+			data = blocks[0].content()
+		}
+
+		etag, err := s3.client.putObject(key, data)
+		if err != nil {
+			return "", err
+		}
+
+		upload.Lock()
+		if upload.uploadID != "" {
+			_ = s3.client.abortMultipartUpload(key, upload.uploadID)
+		}
+		upload.Unlock()
+		return etag, nil
+	}
+
+	uploadID, err := s3.startUpload(key, upload)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]completedPart, len(blocks))
+	err = s3.runParallel(len(blocks), func(i int) error {
+		block := blocks[i]
+		parts[i].PartNumber = i + 1
+
+		var err error
+		switch {
+		case block.number != 0:
+			parts[i].ETag = block.etag
+		case block.committed:
+			parts[i].ETag, err = s3.client.uploadPartCopy(key, uploadID, i+1, key, block.offset, block.size)
+		default:
+			parts[i].ETag, err = s3.client.uploadPart(key, uploadID, i+1, block.content())
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s3.client.completeMultipartUpload(key, uploadID, parts)
+}
+
+// content : Data of a block held in memory
+func (p *stagedPart) content() []byte {
+	if p.zero {
+		return make([]byte, p.size)
+	}
+	return p.data
+}
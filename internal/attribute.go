@@ -65,6 +65,7 @@ const (
 	PropFlagEmptyDir
 	PropFlagSymlink
 	PropFlagModeDefault // TODO: Does this sound better as ModeDefault or DefaultMode? The getter would be IsModeDefault or IsDefaultMode
+	PropFlagOwnerSet
 )
 
 // ObjAttr : Attributes of any file/directory
@@ -75,10 +76,13 @@ type ObjAttr struct {
 	Crtime   time.Time       // creation time
 	Size     int64           // size of the file/directory
 	Mode     os.FileMode     // permissions in 0xxx format
+	Uid      uint32          // owner of the file, valid only if PropFlagOwnerSet is set
+	Gid      uint32          // group of the file, valid only if PropFlagOwnerSet is set
 	Flags    common.BitMap16 // flags
 	Path     string          // full path
 	Name     string          // base name of the path
 	MD5      []byte
+	ETag     string             // version of the object in storage, used for conditional requests
 	Metadata map[string]*string // extra information to preserve
 }
 
@@ -97,3 +101,9 @@ func (attr *ObjAttr) IsSymlink() bool {
 func (attr *ObjAttr) IsModeDefault() bool {
 	return attr.Flags.IsSet(PropFlagModeDefault)
 }
+
+// IsOwnerSet : Whether storage returned the owner and group of the object.
+// If not set the fuse layer shall return the default owner and group.
+func (attr *ObjAttr) IsOwnerSet() bool {
+	return attr.Flags.IsSet(PropFlagOwnerSet)
+}
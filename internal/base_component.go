@@ -321,6 +321,55 @@ func (base *BaseComponent) Chown(options ChownOptions) error {
 	return nil
 }
 
+func (base *BaseComponent) GetXattr(options GetXattrOptions) ([]byte, error) {
+	if base.next != nil {
+		return base.next.GetXattr(options)
+	}
+	return nil, nil
+}
+
+func (base *BaseComponent) SetXattr(options SetXattrOptions) error {
+	if base.next != nil {
+		return base.next.SetXattr(options)
+	}
+	return nil
+}
+
+func (base *BaseComponent) ListXattr(options ListXattrOptions) ([]string, error) {
+	if base.next != nil {
+		return base.next.ListXattr(options)
+	}
+	return nil, nil
+}
+
+func (base *BaseComponent) RemoveXattr(options RemoveXattrOptions) error {
+	if base.next != nil {
+		return base.next.RemoveXattr(options)
+	}
+	return nil
+}
+
+func (base *BaseComponent) AcquireLease(options AcquireLeaseOptions) error {
+	if base.next != nil {
+		return base.next.AcquireLease(options)
+	}
+	return nil
+}
+
+func (base *BaseComponent) RenewLease(options RenewLeaseOptions) error {
+	if base.next != nil {
+		return base.next.RenewLease(options)
+	}
+	return nil
+}
+
+func (base *BaseComponent) ReleaseLease(options ReleaseLeaseOptions) error {
+	if base.next != nil {
+		return base.next.ReleaseLease(options)
+	}
+	return nil
+}
+
 func (base *BaseComponent) FileUsed(name string) error {
 	if base.next != nil {
 		return base.next.FileUsed(name)
@@ -0,0 +1,203 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cache_class
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+)
+
+// Config key listing the cache classes of paths, read by file_cache and block_cache
+const ConfigKey = "cache-classes"
+
+// Class : Priority of a path in the local cache
+type Class int
+
+const (
+	Normal     Class = iota // Evicted as per the cache policy
+	Pin                     // Never evicted, removed only when the file is deleted or changed
+	EvictFirst              // Evicted before any other file when cache is running out of space
+	NoCache                 // Not kept in the local cache once it is not in use
+)
+
+var classNames = [...]string{"normal", "pin", "evict-first", "no-cache"}
+
+func (c Class) String() string {
+	if c < 0 || int(c) >= len(classNames) {
+		return fmt.Sprintf("class(%d)", int(c))
+	}
+	return classNames[c]
+}
+
+// Parse : Class by its name
+func Parse(name string) (Class, error) {
+	for i, n := range classNames {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return Class(i), nil
+		}
+	}
+	return Normal, fmt.Errorf("invalid cache class %s, valid classes are %s", name, strings.Join(classNames[:], ", "))
+}
+
+// RuleOptions : Class of the paths matching a glob
+type RuleOptions struct {
+	Path  string `config:"path" yaml:"path,omitempty"`
+	Class string `config:"class" yaml:"class,omitempty"`
+}
+
+type rule struct {
+	path  string
+	class Class
+}
+
+// Classifier : Decides the class of a path from the configured rules and the classes set at runtime.
+// A class set at runtime on a path or the nearest directory above it wins over the rules, otherwise the
+// first rule whose glob matches the path or a directory above it decides. Paths matching nothing are Normal.
+// A nil Classifier treats every path as Normal.
+type Classifier struct {
+	rules []rule
+
+	sync.RWMutex
+	overrides map[string]Class
+}
+
+// New : Validate the rules and create a classifier out of them
+func New(opts []RuleOptions) (*Classifier, error) {
+	c := &Classifier{
+		rules:     make([]rule, 0, len(opts)),
+		overrides: make(map[string]Class),
+	}
+
+	for i, opt := range opts {
+		r := rule{path: strings.Trim(opt.Path, "/")}
+		if r.path == "" {
+			return nil, fmt.Errorf("rule %d has no path", i)
+		}
+
+		if _, err := path.Match(r.path, ""); err != nil {
+			return nil, fmt.Errorf("rule %d has invalid path %s [%s]", i, opt.Path, err.Error())
+		}
+
+		class, err := Parse(opt.Class)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err.Error())
+		}
+		r.class = class
+
+		c.rules = append(c.rules, r)
+	}
+
+	return c, nil
+}
+
+// NewFromConfig : Create a classifier from the rules given in config
+func NewFromConfig() (*Classifier, error) {
+	opts := make([]RuleOptions, 0)
+	err := config.UnmarshalKey(ConfigKey, &opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s [%s]", ConfigKey, err.Error())
+	}
+
+	c, err := New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s [%s]", ConfigKey, err.Error())
+	}
+
+	return c, nil
+}
+
+// Classify : Class of the path
+func (c *Classifier) Classify(name string) Class {
+	if c == nil {
+		return Normal
+	}
+
+	name = strings.Trim(name, "/")
+
+	c.RLock()
+	if len(c.overrides) > 0 {
+		for p := name; ; p = parent(p) {
+			if class, found := c.overrides[p]; found {
+				c.RUnlock()
+				return class
+			}
+			if p == "" {
+				break
+			}
+		}
+	}
+	c.RUnlock()
+
+	for _, r := range c.rules {
+		for p := name; p != ""; p = parent(p) {
+			if ok, _ := path.Match(r.path, p); ok {
+				return r.class
+			}
+		}
+	}
+
+	return Normal
+}
+
+// Set : Set the class of a path at runtime, for a directory it applies to everything under it
+func (c *Classifier) Set(name string, class Class) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.overrides[strings.Trim(name, "/")] = class
+}
+
+// Clear : Remove the class set at runtime on a path, the rules decide its class again
+func (c *Classifier) Clear(name string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	name = strings.Trim(name, "/")
+	_, found := c.overrides[name]
+	delete(c.overrides, name)
+	return found
+}
+
+// parent : Directory holding the path, "" for paths at the root
+func parent(name string) string {
+	idx := strings.LastIndex(name, "/")
+	if idx < 0 {
+		return ""
+	}
+	return name[:idx]
+}
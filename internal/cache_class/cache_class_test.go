@@ -0,0 +1,152 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package cache_class
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type cacheClassTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func (suite *cacheClassTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+}
+
+func (suite *cacheClassTestSuite) TestParse() {
+	for name, expected := range map[string]Class{"pin": Pin, "Normal": Normal, "evict-first": EvictFirst, " no-cache ": NoCache} {
+		class, err := Parse(name)
+		suite.assert.NoError(err)
+		suite.assert.Equal(expected, class)
+	}
+
+	_, err := Parse("evict")
+	suite.assert.Error(err)
+	suite.assert.Equal("evict-first", EvictFirst.String())
+	suite.assert.Equal("class(9)", Class(9).String())
+}
+
+func (suite *cacheClassTestSuite) TestInvalidRules() {
+	for _, opts := range [][]RuleOptions{
+		{{Path: "", Class: "pin"}},
+		{{Path: "a[", Class: "pin"}},
+		{{Path: "models", Class: "keep"}},
+	} {
+		_, err := New(opts)
+		suite.assert.Error(err)
+	}
+}
+
+func (suite *cacheClassTestSuite) TestClassify() {
+	c, err := New([]RuleOptions{
+		{Path: "/models/**/", Class: "pin"},
+		{Path: "models/tmp", Class: "no-cache"},
+		{Path: "models", Class: "pin"},
+		{Path: "*.tmp", Class: "evict-first"},
+		{Path: "scratch/*/out", Class: "evict-first"},
+	})
+	suite.assert.NoError(err)
+
+	suite.assert.Equal(Pin, c.Classify("models"))
+	suite.assert.Equal(Pin, c.Classify("/models/weights.bin"))
+
+	// First matching rule decides, even if a later one matches a nearer directory
+	suite.assert.Equal(Pin, c.Classify("models/tmp/a"))
+	suite.assert.Equal(EvictFirst, c.Classify("a.tmp"))
+	suite.assert.Equal(Normal, c.Classify("dir/a.tmp"))
+	suite.assert.Equal(EvictFirst, c.Classify("scratch/job1/out/part-0"))
+	suite.assert.Equal(Normal, c.Classify("scratch/job1/in"))
+	suite.assert.Equal(Normal, c.Classify("data/file"))
+}
+
+func (suite *cacheClassTestSuite) TestOverrides() {
+	c, err := New([]RuleOptions{{Path: "models", Class: "pin"}})
+	suite.assert.NoError(err)
+
+	c.Set("/models/old", EvictFirst)
+	c.Set("data", Pin)
+	suite.assert.Equal(EvictFirst, c.Classify("models/old/weights.bin"))
+	suite.assert.Equal(Pin, c.Classify("models/new/weights.bin"))
+	suite.assert.Equal(Pin, c.Classify("data/a/b"))
+
+	// Nearest path with a class set wins
+	c.Set("data/a", NoCache)
+	suite.assert.Equal(NoCache, c.Classify("data/a/b"))
+
+	suite.assert.True(c.Clear("models/old/"))
+	suite.assert.False(c.Clear("models/old"))
+	suite.assert.Equal(Pin, c.Classify("models/old/weights.bin"))
+
+	// Class set on the root applies to everything not set otherwise
+	c.Set("/", EvictFirst)
+	suite.assert.Equal(EvictFirst, c.Classify("other"))
+	suite.assert.Equal(NoCache, c.Classify("data/a/b"))
+}
+
+func (suite *cacheClassTestSuite) TestNil() {
+	var c *Classifier
+	suite.assert.Equal(Normal, c.Classify("any"))
+}
+
+func (suite *cacheClassTestSuite) TestFromConfig() {
+	config.ResetConfig()
+	suite.assert.NoError(config.ReadConfigFromReader(strings.NewReader("cache-classes:\n  - path: models\n    class: pin\n  - path: scratch\n    class: evict-first\n")))
+
+	c, err := NewFromConfig()
+	suite.assert.NoError(err)
+	suite.assert.Equal(Pin, c.Classify("models/a"))
+	suite.assert.Equal(EvictFirst, c.Classify("scratch/a"))
+
+	config.ResetConfig()
+	c, err = NewFromConfig()
+	suite.assert.NoError(err)
+	suite.assert.Equal(Normal, c.Classify("models/a"))
+
+	config.ResetConfig()
+	suite.assert.NoError(config.ReadConfigFromReader(strings.NewReader("cache-classes:\n  - path: models\n    class: forever\n")))
+	_, err = NewFromConfig()
+	suite.assert.Error(err)
+}
+
+func TestCacheClassTestSuite(t *testing.T) {
+	suite.Run(t, new(cacheClassTestSuite))
+}
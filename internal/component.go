@@ -65,6 +65,11 @@ func (ComponentPriority) LevelTwo() ComponentPriority {
 	return ComponentPriority(300)
 }
 
+// Any : Pass-through components which can be placed anywhere in the pipeline, they are skipped in order checks
+func (ComponentPriority) Any() ComponentPriority {
+	return ComponentPriority(-1)
+}
+
 // Component : Base internal for every component to participate in pipeline
 type Component interface {
 	// Pipeline participation related methods
@@ -135,6 +140,18 @@ type Component interface {
 
 	Chmod(ChmodOptions) error
 	Chown(ChownOptions) error
+
+	// Extended attribute operations
+	GetXattr(GetXattrOptions) ([]byte, error)
+	SetXattr(SetXattrOptions) error
+	ListXattr(ListXattrOptions) ([]string, error)
+	RemoveXattr(RemoveXattrOptions) error
+
+	// Lease operations to lock a file across mounts
+	AcquireLease(AcquireLeaseOptions) error
+	RenewLease(RenewLeaseOptions) error
+	ReleaseLease(ReleaseLeaseOptions) error
+
 	GetFileBlockOffsets(options GetFileBlockOffsetsOptions) (*common.BlockOffsetList, error)
 
 	FileUsed(name string) error
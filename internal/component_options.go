@@ -76,13 +76,18 @@ type RenameDirOptions struct {
 	Dst string
 }
 
+// CreateFileOptions : Uid and Gid are the owner of the new file and are valid only when OwnerSet is true
 type CreateFileOptions struct {
-	Name string
-	Mode os.FileMode
+	Name     string
+	Mode     os.FileMode
+	Uid      uint32
+	Gid      uint32
+	OwnerSet bool
 }
 
 type DeleteFileOptions struct {
 	Name string
+	ETag string // If set, delete only if blob still has this ETag
 }
 
 type OpenFileOptions struct {
@@ -105,9 +110,11 @@ type ReadFileOptions struct {
 }
 
 type ReadInBufferOptions struct {
-	Handle *handlemap.Handle
-	Offset int64
-	Data   []byte
+	Handle    *handlemap.Handle
+	Offset    int64
+	Data      []byte
+	Checksums *[][]byte // If set, filled with MD5 of the data read as computed by storage, one for each common.MaxChecksumRange bytes
+	ETag      string    // If set, read only if blob still has this ETag, fails with ESTALE otherwise
 }
 
 type WriteFileOptions struct {
@@ -131,12 +138,14 @@ type CopyToFileOptions struct {
 	Offset int64
 	Count  int64
 	File   *os.File
+	ETag   string // If set, download only if blob still has this ETag, fails with ESTALE otherwise
 }
 
 type CopyFromFileOptions struct {
 	Name     string
 	File     *os.File
 	Metadata map[string]*string
+	ETag     *string // If set, upload only if blob still has this ETag and update it with the new one
 }
 
 type FlushFileOptions struct {
@@ -175,33 +184,81 @@ type GetAttrOptions struct {
 	RetrieveMetadata bool
 }
 
+// SetAttrOptions : only Atime and Mtime of Attr are applied, zero value of either means that time shall be left unchanged
 type SetAttrOptions struct {
 	Name string
 	Attr *ObjAttr
+	ETag *string // If set to the ETag of the blob before the change, it is updated with the new one
 }
 
 type ChmodOptions struct {
 	Name string
 	Mode os.FileMode
+	ETag *string // If set to the ETag of the blob before the change, it is updated with the new one
 }
 
 type ChownOptions struct {
 	Name  string
 	Owner int
 	Group int
+	ETag  *string // If set to the ETag of the blob before the change, it is updated with the new one
+}
+
+type GetXattrOptions struct {
+	Name string
+	Attr string
+}
+
+// Flags for SetXattrOptions, these carry the same values as the setxattr(2) flags
+const (
+	XattrCreate  = 0x1 // fail if the attribute already exists
+	XattrReplace = 0x2 // fail if the attribute does not exist
+)
+
+type SetXattrOptions struct {
+	Name  string
+	Attr  string
+	Value []byte
+	Flags int
+	ETag  *string // If set to the ETag of the blob before the change, it is updated with the new one
+}
+
+type ListXattrOptions struct {
+	Name string
+}
+
+type RemoveXattrOptions struct {
+	Name string
+	Attr string
+	ETag *string // If set to the ETag of the blob before the change, it is updated with the new one
+}
+
+type AcquireLeaseOptions struct {
+	Name     string
+	Duration int32 // in seconds, -1 for a lease that never expires
+}
+
+type RenewLeaseOptions struct {
+	Name string
+}
+
+type ReleaseLeaseOptions struct {
+	Name string
 }
 
 type StageDataOptions struct {
-	Name   string
-	Id     string
-	Data   []byte
-	Offset uint64
+	Name     string
+	Id       string
+	Data     []byte
+	Offset   uint64
+	Checksum []byte // If set, MD5 of the data, storage rejects the block if the data it received does not match it
 }
 
 type CommitDataOptions struct {
 	Name      string
 	List      []string
 	BlockSize uint64
+	ETag      *string // If set, commit only if blob still has this ETag and update it with the new one
 }
 
 type CommittedBlock struct {
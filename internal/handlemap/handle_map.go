@@ -55,6 +55,8 @@ const (
 	HandleFlagDirty          // File has been modified with write operation or is a new file
 	HandleFlagFSynced        // User has called fsync on the file explicitly
 	HandleFlagCached         // File is cached in the local system by blobfuse2
+	HandleFlagLeased         // Handle holds a lease on the blob to keep other mounts from writing to it
+	HandleFlagLocked         // Application holds a flock or fcntl lock on the file through this handle
 )
 
 // Structure to hold in memory cache for streaming layer
@@ -115,6 +117,16 @@ func (handle *Handle) Cached() bool {
 	return handle.Flags.IsSet(HandleFlagCached)
 }
 
+// Leased : Handle holds a lease on the blob or not
+func (handle *Handle) Leased() bool {
+	return handle.Flags.IsSet(HandleFlagLeased)
+}
+
+// Locked : Application has locked the file through this handle or not
+func (handle *Handle) Locked() bool {
+	return handle.Flags.IsSet(HandleFlagLocked)
+}
+
 // GetFileObject : Get the OS.File handle stored within
 func (handle *Handle) GetFileObject() *os.File {
 	return handle.FObj
@@ -0,0 +1,164 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package invalidation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Subject of blob events is /blobServices/default/containers/<container>/blobs/<path>
+const (
+	subjectPrefix = "/blobServices/default/containers/"
+	subjectBlobs  = "/blobs/"
+)
+
+// gridEvent : Blob storage event in Event Grid or CloudEvents schema, only the fields needed to find the changed paths
+type gridEvent struct {
+	EventType string `json:"eventType"`
+	Type      string `json:"type"`
+	Subject   string `json:"subject"`
+	Data      struct {
+		SourceURL string `json:"sourceUrl"`
+	} `json:"data"`
+}
+
+// pathMapper : Maps blob paths in the container to paths in the mount, dropping the ones not under the mount
+type pathMapper struct {
+	container string
+	prefix    string
+}
+
+func newPathMapper(container string, subdirectory string) *pathMapper {
+	return &pathMapper{
+		container: container,
+		prefix:    strings.Trim(subdirectory, "/"),
+	}
+}
+
+// local : Path of the blob in the mount and whether it is in the mount at all
+func (m *pathMapper) local(container string, name string) (string, bool) {
+	if m.container != "" && container != m.container {
+		return "", false
+	}
+
+	name = strings.Trim(name, "/")
+	if m.prefix != "" {
+		if !strings.HasPrefix(name, m.prefix+"/") {
+			return "", false
+		}
+		name = name[len(m.prefix)+1:]
+	}
+
+	return name, name != ""
+}
+
+// parseEvents : Paths changed by a single event or a batch of events in Event Grid or CloudEvents schema
+func parseEvents(data []byte, m *pathMapper) ([]Event, error) {
+	data = bytes.TrimSpace(data)
+
+	var batch []gridEvent
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, err
+		}
+	} else {
+		var e gridEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		batch = append(batch, e)
+	}
+
+	events := make([]Event, 0, len(batch))
+	for _, e := range batch {
+		events = append(events, e.events(m)...)
+	}
+	return events, nil
+}
+
+// events : Paths in the mount changed by the event
+func (e *gridEvent) events(m *pathMapper) []Event {
+	if !strings.HasPrefix(e.Subject, subjectPrefix) {
+		return nil
+	}
+
+	container, name, found := strings.Cut(e.Subject[len(subjectPrefix):], subjectBlobs)
+	if !found {
+		return nil
+	}
+
+	eventType := e.EventType
+	if eventType == "" {
+		eventType = e.Type
+	}
+
+	// Created, deleted and renamed directories are reported only by accounts with hierarchical namespace,
+	// any other event on a blob means its data or properties may have changed
+	dir := strings.HasPrefix(eventType, "Microsoft.Storage.Directory")
+
+	events := make([]Event, 0, 2)
+	if path, ok := m.local(container, name); ok {
+		events = append(events, Event{Path: path, Dir: dir})
+	}
+
+	if strings.HasSuffix(eventType, "Renamed") && e.Data.SourceURL != "" {
+		// Subject is the destination of the rename, the source is given only as url
+		if path, ok := m.local(container, urlPath(e.Data.SourceURL, container)); ok {
+			events = append(events, Event{Path: path, Dir: dir})
+		}
+	}
+
+	return events
+}
+
+// urlPath : Path of the blob in the url of a blob or dfs endpoint, empty if the url is not of the container
+func urlPath(raw string, container string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	// Account is part of the path for emulators and custom endpoints
+	segments := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 3)
+	for i := 0; i < len(segments)-1 && i < 2; i++ {
+		if segments[i] == container {
+			return strings.Join(segments[i+1:], "/")
+		}
+	}
+
+	return ""
+}
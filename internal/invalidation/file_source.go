@@ -0,0 +1,119 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package invalidation
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// fileSource : Events appended to a local file, one event or batch of events per line.
+// Stand-in for a queue, e.g. for tools relaying events from elsewhere and for testing.
+// Only the events appended after the mount are read, the file can be truncated to start over.
+type fileSource struct {
+	path   string
+	mapper *pathMapper
+	offset int64
+}
+
+func newFileSource(path string, m *pathMapper) *fileSource {
+	s := &fileSource{
+		path:   path,
+		mapper: m,
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		s.offset = info.Size()
+	}
+
+	return s
+}
+
+func (s *fileSource) Next(_ context.Context) ([]Event, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.offset = 0
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() < s.offset {
+		// File was truncated or replaced
+		s.offset = 0
+	}
+
+	data := make([]byte, info.Size()-s.offset)
+	_, err = f.ReadAt(data, s.offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// Line still being written is read next time
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	s.offset += int64(end + 1)
+
+	events := make([]Event, 0)
+	for _, line := range bytes.Split(data[:end], []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		e, err := parseEvents(line, s.mapper)
+		if err != nil {
+			log.Warn("fileSource::Next : Skipping invalid event in %s [%s]", s.path, err.Error())
+			continue
+		}
+		events = append(events, e...)
+	}
+
+	return events, nil
+}
+
+func (s *fileSource) Close() error {
+	return nil
+}
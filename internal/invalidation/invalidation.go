@@ -0,0 +1,261 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package invalidation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// Config key of the source of change events, read by attr_cache and file_cache
+const ConfigKey = "invalidation"
+
+const defaultPollInterval = 10
+
+// Event : Change to a path in the container, the path is relative to the mount
+type Event struct {
+	Path string
+	Dir  bool // Path is a directory, everything under it may have changed
+}
+
+// Handler : Invalidates whatever a component has cached for the path of the event
+type Handler func(Event)
+
+// Source : Provider of change events
+type Source interface {
+	// Next : Events which arrived since the last call, none if there are no new ones.
+	// Events returned by the last call are handled by the time Next is called again, or the source is closed,
+	// so a source can acknowledge them only then and not lose any if the mount goes away in between.
+	Next(ctx context.Context) ([]Event, error)
+
+	// Close : Release the source
+	Close() error
+}
+
+// Options : Source of change events and the part of the account it is mounted from
+type Options struct {
+	Source       string `config:"source" yaml:"source,omitempty"`
+	FilePath     string `config:"file-path" yaml:"file-path,omitempty"`
+	QueueURL     string `config:"queue-url" yaml:"queue-url,omitempty"`
+	PollInterval uint32 `config:"poll-interval-sec" yaml:"poll-interval-sec,omitempty"`
+	Container    string `config:"container" yaml:"container,omitempty"`
+	Subdirectory string `config:"subdirectory" yaml:"subdirectory,omitempty"`
+}
+
+// NewSource : Create the source of events described by the options
+func NewSource(opts Options) (Source, error) {
+	m := newPathMapper(opts.Container, opts.Subdirectory)
+
+	switch opts.Source {
+	case "file":
+		if opts.FilePath == "" {
+			return nil, fmt.Errorf("file-path not set for file source")
+		}
+		return newFileSource(opts.FilePath, m), nil
+	case "queue":
+		if opts.QueueURL == "" {
+			return nil, fmt.Errorf("queue-url not set for queue source")
+		}
+		return newQueueSource(opts.QueueURL, m)
+	default:
+		return nil, fmt.Errorf("invalid source %s, valid sources are file, queue", opts.Source)
+	}
+}
+
+// Feed : Reads events from a source and hands them to the handlers of the components subscribed to it
+type Feed struct {
+	source   Source
+	interval time.Duration
+
+	sync.RWMutex
+	handlers map[string]Handler
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewFeed(source Source, interval time.Duration) *Feed {
+	return &Feed{
+		source:   source,
+		interval: interval,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Start : Start reading events in background
+func (f *Feed) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.wg.Add(1)
+	go f.reader(ctx)
+}
+
+// Stop : Stop reading events and close the source
+func (f *Feed) Stop() {
+	f.cancel()
+	f.wg.Wait()
+	_ = f.source.Close()
+}
+
+// Subscribe : Register the handler of a component, it replaces any handler already registered by the same name
+func (f *Feed) Subscribe(name string, handler Handler) {
+	f.Lock()
+	defer f.Unlock()
+	f.handlers[name] = handler
+}
+
+// Unsubscribe : Remove the handler of a component, returns the number of handlers left
+func (f *Feed) Unsubscribe(name string) int {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.handlers, name)
+	return len(f.handlers)
+}
+
+// reader : Read events at regular interval. First read is after an interval so that components starting
+// after the first subscriber do not miss the events waiting in the source.
+func (f *Feed) reader(ctx context.Context) {
+	defer f.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.interval):
+			f.read(ctx)
+		}
+	}
+}
+
+// read : Read and dispatch events till the source has no more
+func (f *Feed) read(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := f.source.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				// Caches fall back on their timeouts till the source is back
+				log.Err("Feed::read : Failed to read change events [%s]", err.Error())
+			}
+			return
+		}
+
+		if len(events) == 0 {
+			return
+		}
+
+		for _, event := range events {
+			f.dispatch(event)
+		}
+	}
+}
+
+func (f *Feed) dispatch(event Event) {
+	log.Debug("Feed::dispatch : %s changed (dir %t)", event.Path, event.Dir)
+
+	f.RLock()
+	for _, handler := range f.handlers {
+		handler(event)
+	}
+	f.RUnlock()
+
+	// Kernel is told only after the caches dropped the path, else it could read the old entries again
+	Notify(event)
+}
+
+// Feed configured for the mount, shared by all the components so that each event is read once
+var (
+	feedLock sync.Mutex
+	feed     *Feed
+)
+
+// Subscribe : Register the handler of a component with the feed configured for the mount.
+// The feed is started on first subscription, nothing is done if no source is configured.
+func Subscribe(name string, handler Handler) error {
+	if !config.IsSet(ConfigKey) {
+		return nil
+	}
+
+	feedLock.Lock()
+	defer feedLock.Unlock()
+
+	if feed == nil {
+		opts := Options{}
+		err := config.UnmarshalKey(ConfigKey, &opts)
+		if err != nil {
+			return fmt.Errorf("invalid %s [%s]", ConfigKey, err.Error())
+		}
+
+		// Blob paths in events are relative to the container, default to what the mount is of
+		if opts.Container == "" {
+			_ = config.UnmarshalKey("azstorage.container", &opts.Container)
+		}
+		if opts.Subdirectory == "" {
+			_ = config.UnmarshalKey("azstorage.subdirectory", &opts.Subdirectory)
+		}
+
+		source, err := NewSource(opts)
+		if err != nil {
+			return fmt.Errorf("invalid %s [%s]", ConfigKey, err.Error())
+		}
+
+		if opts.PollInterval == 0 {
+			opts.PollInterval = defaultPollInterval
+		}
+
+		log.Info("invalidation::Subscribe : Reading change events from %s source, container %s, subdirectory %s, poll interval %v",
+			opts.Source, opts.Container, opts.Subdirectory, opts.PollInterval)
+
+		feed = NewFeed(source, time.Duration(opts.PollInterval)*time.Second)
+		feed.Start()
+	}
+
+	feed.Subscribe(name, handler)
+	return nil
+}
+
+// Unsubscribe : Remove the handler of a component, the feed is stopped once no one is subscribed to it
+func Unsubscribe(name string) {
+	feedLock.Lock()
+	defer feedLock.Unlock()
+
+	if feed != nil && feed.Unsubscribe(name) == 0 {
+		feed.Stop()
+		feed = nil
+	}
+}
@@ -0,0 +1,418 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package invalidation
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type invalidationTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func (suite *invalidationTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+}
+
+func gridEventJSON(eventType string, container string, name string, sourceURL string) string {
+	return fmt.Sprintf(`{"topic":"/subscriptions/x/resourceGroups/y/providers/Microsoft.Storage/storageAccounts/acct",`+
+		`"subject":"/blobServices/default/containers/%s/blobs/%s","eventType":"%s",`+
+		`"data":{"api":"PutBlob","url":"https://acct.blob.core.windows.net/%s/%s","sourceUrl":"%s"}}`,
+		container, name, eventType, container, name, sourceURL)
+}
+
+func (suite *invalidationTestSuite) TestParseEvents() {
+	m := newPathMapper("cont", "")
+
+	events, err := parseEvents([]byte(gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "a/b.txt", "")), m)
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "a/b.txt"}}, events)
+
+	// Other containers are not of the mount
+	events, err = parseEvents([]byte(gridEventJSON("Microsoft.Storage.BlobDeleted", "other", "a/b.txt", "")), m)
+	suite.assert.NoError(err)
+	suite.assert.Empty(events)
+
+	// Renames change both the source and the destination
+	events, err = parseEvents([]byte(gridEventJSON("Microsoft.Storage.DirectoryRenamed", "cont", "new",
+		"https://acct.dfs.core.windows.net/cont/old%20dir")), m)
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "new", Dir: true}, {Path: "old dir", Dir: true}}, events)
+
+	// Batch of events, CloudEvents schema has the type in a different field
+	batch := "[" + gridEventJSON("Microsoft.Storage.BlobTierChanged", "cont", "x", "") + "," +
+		`{"type":"Microsoft.Storage.DirectoryDeleted","subject":"/blobServices/default/containers/cont/blobs/y"},` +
+		`{"eventType":"Microsoft.Resources.ResourceWriteSuccess","subject":"/subscriptions/x"}]`
+	events, err = parseEvents([]byte(batch), m)
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "x"}, {Path: "y", Dir: true}}, events)
+
+	_, err = parseEvents([]byte(`{"subject":`), m)
+	suite.assert.Error(err)
+}
+
+func (suite *invalidationTestSuite) TestPathMapper() {
+	m := newPathMapper("", "/data/")
+
+	for _, tc := range []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"data/a/b", "a/b", true},
+		{"data", "", false},
+		{"database/a", "", false},
+		{"other/a", "", false},
+	} {
+		local, ok := m.local("any", tc.name)
+		suite.assert.Equal(tc.ok, ok, tc.name)
+		suite.assert.Equal(tc.expected, local, tc.name)
+	}
+
+	suite.assert.Equal("a/b", urlPath("https://acct.blob.core.windows.net/cont/a/b", "cont"))
+	suite.assert.Equal("a/b", urlPath("http://127.0.0.1:10000/devstoreaccount1/cont/a/b", "cont"))
+	suite.assert.Equal("", urlPath("https://acct.blob.core.windows.net/other/a/b", "cont"))
+	suite.assert.Equal("", urlPath("https://acct.blob.core.windows.net/cont", "cont"))
+}
+
+func (suite *invalidationTestSuite) TestNewSource() {
+	for _, opts := range []Options{
+		{Source: "kafka"},
+		{Source: "file"},
+		{Source: "queue"},
+		{Source: "queue", QueueURL: "ftp://acct.queue.core.windows.net/q"},
+	} {
+		_, err := NewSource(opts)
+		suite.assert.Error(err, opts.Source)
+	}
+}
+
+func (suite *invalidationTestSuite) TestFileSource() {
+	path := filepath.Join(suite.T().TempDir(), "events")
+	suite.assert.NoError(os.WriteFile(path, []byte(gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "before", "")+"\n"), 0644))
+
+	s, err := NewSource(Options{Source: "file", FilePath: path})
+	suite.assert.NoError(err)
+	defer s.Close()
+
+	// Events from before the mount are skipped
+	events, err := s.Next(context.Background())
+	suite.assert.NoError(err)
+	suite.assert.Empty(events)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	suite.assert.NoError(err)
+	_, _ = f.WriteString(gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "a", "") + "\n\nnot json\n")
+	_, _ = f.WriteString(gridEventJSON("Microsoft.Storage.BlobDeleted", "cont", "b", ""))
+	f.Close()
+
+	// Partial line is left for later
+	events, err = s.Next(context.Background())
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "a"}}, events)
+
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	suite.assert.NoError(err)
+	_, _ = f.WriteString("\n")
+	f.Close()
+
+	events, err = s.Next(context.Background())
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "b"}}, events)
+
+	// Truncated file is read from the start
+	suite.assert.NoError(os.WriteFile(path, []byte(gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "c", "")+"\n"), 0644))
+	events, err = s.Next(context.Background())
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "c"}}, events)
+}
+
+// queueServer : Storage queue holding messages till they are deleted, messages once read are not given out again
+type queueServer struct {
+	sync.Mutex
+	messages map[string]string
+	read     map[string]bool
+	fail     bool
+}
+
+func (q *queueServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.fail || r.URL.Query().Get("sig") != "secret" || r.Header.Get("x-ms-version") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/q/messages":
+		var sb strings.Builder
+		sb.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?><QueueMessagesList>")
+		for id, text := range q.messages {
+			if !q.read[id] {
+				q.read[id] = true
+				sb.WriteString(fmt.Sprintf("<QueueMessage><MessageId>%s</MessageId><PopReceipt>pop-%s</PopReceipt><MessageText>%s</MessageText></QueueMessage>", id, id, text))
+			}
+		}
+		sb.WriteString("</QueueMessagesList>")
+		_, _ = w.Write([]byte(sb.String()))
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/q/messages/"):
+		id := strings.TrimPrefix(r.URL.Path, "/q/messages/")
+		if r.URL.Query().Get("popreceipt") != "pop-"+id {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(q.messages, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (q *queueServer) count() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.messages)
+}
+
+func (suite *invalidationTestSuite) TestQueueSource() {
+	q := &queueServer{
+		messages: map[string]string{
+			"m1": base64.StdEncoding.EncodeToString([]byte(gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "dir/a", ""))),
+			"m2": base64.StdEncoding.EncodeToString([]byte("garbage")),
+		},
+		read: make(map[string]bool),
+	}
+	server := httptest.NewServer(q)
+	defer server.Close()
+
+	s, err := NewSource(Options{Source: "queue", QueueURL: server.URL + "/q?sv=2021-12-02&sig=secret", Container: "cont", Subdirectory: "dir"})
+	suite.assert.NoError(err)
+
+	events, err := s.Next(context.Background())
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "a"}}, events)
+
+	// Messages are deleted only once their events are handled
+	suite.assert.Equal(2, q.count())
+
+	q.Lock()
+	q.messages["m3"] = gridEventJSON("Microsoft.Storage.BlobDeleted", "cont", "dir/b", "")
+	q.Unlock()
+
+	events, err = s.Next(context.Background())
+	suite.assert.NoError(err)
+	suite.assert.Equal([]Event{{Path: "b"}}, events)
+	suite.assert.Equal(1, q.count())
+
+	suite.assert.NoError(s.Close())
+	suite.assert.Equal(0, q.count())
+
+	// Errors do not give away the SAS
+	q.Lock()
+	q.fail = true
+	q.Unlock()
+	s, err = NewSource(Options{Source: "queue", QueueURL: server.URL + "/q?sig=secret"})
+	suite.assert.NoError(err)
+	_, err = s.Next(context.Background())
+	suite.assert.Error(err)
+	suite.assert.NotContains(err.Error(), "secret")
+
+	server.Close()
+	_, err = s.Next(context.Background())
+	suite.assert.Error(err)
+	suite.assert.NotContains(err.Error(), "secret")
+}
+
+// testSource : Source handing out the batches given to it
+type testSource struct {
+	sync.Mutex
+	batches [][]Event
+	closed  bool
+}
+
+func (s *testSource) Next(_ context.Context) ([]Event, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.batches) == 0 {
+		return nil, nil
+	}
+	batch := s.batches[0]
+	s.batches = s.batches[1:]
+	return batch, nil
+}
+
+func (s *testSource) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	return nil
+}
+
+// recorder : Handler keeping the events it got
+type recorder struct {
+	sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(e Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) get() []Event {
+	r.Lock()
+	defer r.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+func (suite *invalidationTestSuite) TestFeed() {
+	source := &testSource{batches: [][]Event{{{Path: "a"}, {Path: "b", Dir: true}}, {{Path: "c"}}}}
+	r1, r2 := &recorder{}, &recorder{}
+
+	f := NewFeed(source, 10*time.Millisecond)
+	f.Subscribe("one", r1.handle)
+	f.Subscribe("two", r2.handle)
+	f.Start()
+
+	expected := []Event{{Path: "a"}, {Path: "b", Dir: true}, {Path: "c"}}
+	suite.assert.Eventually(func() bool { return len(r2.get()) == 3 }, time.Second, 10*time.Millisecond)
+	suite.assert.Equal(expected, r1.get())
+	suite.assert.Equal(expected, r2.get())
+
+	suite.assert.Equal(1, f.Unsubscribe("two"))
+	source.Lock()
+	source.batches = append(source.batches, []Event{{Path: "d"}})
+	source.Unlock()
+
+	suite.assert.Eventually(func() bool { return len(r1.get()) == 4 }, time.Second, 10*time.Millisecond)
+	suite.assert.Len(r2.get(), 3)
+
+	f.Stop()
+	suite.assert.True(source.closed)
+}
+
+func (suite *invalidationTestSuite) TestNotify() {
+	// Nothing to do without a notifier
+	Notify(Event{Path: "a"})
+
+	notified := &recorder{}
+	SetNotifier(notified.handle)
+	defer SetNotifier(nil)
+
+	Notify(Event{Path: "a"})
+	suite.assert.Equal([]Event{{Path: "a"}}, notified.get())
+
+	// Events of the feed reach the notifier after every subscriber handled them
+	r := &recorder{}
+	source := &testSource{batches: [][]Event{{{Path: "b", Dir: true}}}}
+	f := NewFeed(source, 10*time.Millisecond)
+	f.Subscribe("one", func(e Event) {
+		suite.assert.Len(notified.get(), 1)
+		r.handle(e)
+	})
+	f.Start()
+
+	suite.assert.Eventually(func() bool { return len(notified.get()) == 2 }, time.Second, 10*time.Millisecond)
+	suite.assert.Equal([]Event{{Path: "b", Dir: true}}, r.get())
+	suite.assert.Equal(Event{Path: "b", Dir: true}, notified.get()[1])
+	f.Stop()
+
+	SetNotifier(nil)
+	Notify(Event{Path: "c"})
+	suite.assert.Len(notified.get(), 2)
+}
+
+func (suite *invalidationTestSuite) TestSubscribe() {
+	// Nothing to do without a source
+	config.ResetConfig()
+	suite.assert.NoError(Subscribe("test", func(Event) {}))
+	suite.assert.Nil(feed)
+	Unsubscribe("test")
+
+	config.ResetConfig()
+	suite.assert.NoError(config.ReadConfigFromReader(strings.NewReader("invalidation:\n  source: file\n")))
+	suite.assert.Error(Subscribe("test", func(Event) {}))
+	suite.assert.Nil(feed)
+
+	path := filepath.Join(suite.T().TempDir(), "events")
+	cfg := fmt.Sprintf("azstorage:\n  container: cont\n  subdirectory: data\ninvalidation:\n  source: file\n  file-path: %s\n  poll-interval-sec: 1\n", path)
+	config.ResetConfig()
+	suite.assert.NoError(config.ReadConfigFromReader(strings.NewReader(cfg)))
+
+	r1, r2 := &recorder{}, &recorder{}
+	suite.assert.NoError(Subscribe("one", r1.handle))
+	suite.assert.NoError(Subscribe("two", r2.handle))
+	suite.assert.NotNil(feed)
+
+	events := gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "data/a", "") + "\n" +
+		gridEventJSON("Microsoft.Storage.BlobCreated", "other", "data/b", "") + "\n" +
+		gridEventJSON("Microsoft.Storage.BlobCreated", "cont", "c", "") + "\n"
+	suite.assert.NoError(os.WriteFile(path, []byte(events), 0644))
+
+	// Container and subdirectory of the mount are used to map the paths
+	suite.assert.Eventually(func() bool { return len(r2.get()) == 1 }, 5*time.Second, 50*time.Millisecond)
+	suite.assert.Equal([]Event{{Path: "a"}}, r1.get())
+	suite.assert.Equal([]Event{{Path: "a"}}, r2.get())
+
+	Unsubscribe("one")
+	suite.assert.NotNil(feed)
+	Unsubscribe("two")
+	suite.assert.Nil(feed)
+	config.ResetConfig()
+}
+
+func TestInvalidationTestSuite(t *testing.T) {
+	suite.Run(t, new(invalidationTestSuite))
+}
@@ -0,0 +1,63 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package invalidation
+
+import (
+	"sync"
+)
+
+// Changes found by the components, e.g. a cache refreshing an entry which turns out to be modified in the container,
+// and the ones read from the feed are reported to a notifier. libfuse registers one to have the kernel drop
+// what it has cached for the path, so the kernel does not wait for its own timeouts to see the change.
+var (
+	notifierLock sync.RWMutex
+	notifier     Handler
+)
+
+// SetNotifier : Register the handler changes are reported to, nil removes it
+func SetNotifier(handler Handler) {
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	notifier = handler
+}
+
+// Notify : Report a path found changed in the container. Caches below the caller have to be invalidated
+// by the time this is called, as the kernel asks for the path again once notified.
+func Notify(event Event) {
+	notifierLock.RLock()
+	defer notifierLock.RUnlock()
+	if notifier != nil {
+		notifier(event)
+	}
+}
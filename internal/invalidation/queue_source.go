@@ -0,0 +1,186 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package invalidation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+const (
+	queueAPIVersion     = "2021-12-02"
+	queueBatchSize      = 32
+	queueVisibilitySec  = 60
+	queueRequestTimeout = 30 * time.Second
+)
+
+// queueSource : Events delivered by Event Grid to an Azure storage queue, the queue url carries a SAS to access it.
+// Messages are deleted from the queue once their events are handled, the ones that can not be handled are
+// visible again after a while and go to the poison queue of Event Grid after repeated failures.
+type queueSource struct {
+	url     *url.URL
+	mapper  *pathMapper
+	client  *http.Client
+	pending []queueMessage // Messages read by the last call, deleted on next call
+}
+
+type queueMessage struct {
+	MessageID  string `xml:"MessageId"`
+	PopReceipt string `xml:"PopReceipt"`
+	Text       string `xml:"MessageText"`
+}
+
+type queueMessageList struct {
+	Messages []queueMessage `xml:"QueueMessage"`
+}
+
+func newQueueSource(queueURL string, m *pathMapper) (*queueSource, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid queue-url [%s]", err.Error())
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid queue-url scheme %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	return &queueSource{
+		url:    u,
+		mapper: m,
+		client: &http.Client{Timeout: queueRequestTimeout},
+	}, nil
+}
+
+func (s *queueSource) Next(ctx context.Context) ([]Event, error) {
+	s.deletePending(ctx)
+
+	query := s.url.Query()
+	query.Set("numofmessages", fmt.Sprint(queueBatchSize))
+	query.Set("visibilitytimeout", fmt.Sprint(queueVisibilitySec))
+
+	body, err := s.do(ctx, http.MethodGet, s.url.Path+"/messages", query, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	list := queueMessageList{}
+	err = xml.Unmarshal(body, &list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response from queue [%s]", err.Error())
+	}
+
+	events := make([]Event, 0, len(list.Messages))
+	for _, msg := range list.Messages {
+		// Event Grid encodes the messages in base64 by default
+		data, err := base64.StdEncoding.DecodeString(msg.Text)
+		if err != nil {
+			data = []byte(msg.Text)
+		}
+
+		e, err := parseEvents(data, s.mapper)
+		if err != nil {
+			log.Warn("queueSource::Next : Dropping invalid message %s [%s]", msg.MessageID, err.Error())
+		} else {
+			events = append(events, e...)
+		}
+		s.pending = append(s.pending, msg)
+	}
+
+	return events, nil
+}
+
+func (s *queueSource) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueRequestTimeout)
+	defer cancel()
+
+	s.deletePending(ctx)
+	return nil
+}
+
+// deletePending : Delete the messages whose events have been handled
+func (s *queueSource) deletePending(ctx context.Context) {
+	for _, msg := range s.pending {
+		query := s.url.Query()
+		query.Set("popreceipt", msg.PopReceipt)
+
+		_, err := s.do(ctx, http.MethodDelete, s.url.Path+"/messages/"+url.PathEscape(msg.MessageID), query, http.StatusNoContent)
+		if err != nil {
+			// Events of the message are handled again once it is visible, which is harmless
+			log.Warn("queueSource::deletePending : Failed to delete message %s [%s]", msg.MessageID, err.Error())
+		}
+	}
+	s.pending = s.pending[:0]
+}
+
+func (s *queueSource) do(ctx context.Context, method string, path string, query url.Values, status int) ([]byte, error) {
+	u := *s.url
+	u.Path = path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", queueAPIVersion)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Do not let the SAS in the url make its way to the logs
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != status {
+		return nil, fmt.Errorf("queue returned %s", resp.Status)
+	}
+
+	return body, nil
+}
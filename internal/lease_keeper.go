@@ -0,0 +1,178 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package internal
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// Duration of the leases taken to lock files across mounts, they are renewed well before they expire.
+// If the mount goes away without releasing them others can take over once they expire.
+const LeaseDuration int32 = 60
+
+// LeaseKeeper : Holds leases on files on behalf of a component and keeps renewing them till they are released
+type LeaseKeeper struct {
+	sync.Mutex
+	next     Component
+	interval time.Duration
+	leases   map[string]uint32 // Number of holders of the lease on each file
+	stop     chan bool
+	wg       sync.WaitGroup
+}
+
+func NewLeaseKeeper(next Component) *LeaseKeeper {
+	return &LeaseKeeper{
+		next:     next,
+		interval: time.Duration(LeaseDuration/3) * time.Second,
+		leases:   make(map[string]uint32),
+	}
+}
+
+// Start : Start renewing the leases in background
+func (lk *LeaseKeeper) Start() {
+	lk.stop = make(chan bool)
+	lk.wg.Add(1)
+	go lk.renewer()
+}
+
+// Stop : Stop renewing and release all the leases still held
+func (lk *LeaseKeeper) Stop() {
+	close(lk.stop)
+	lk.wg.Wait()
+
+	lk.Lock()
+	defer lk.Unlock()
+
+	for name := range lk.leases {
+		_ = lk.next.ReleaseLease(ReleaseLeaseOptions{Name: name})
+	}
+	lk.leases = make(map[string]uint32)
+}
+
+// Acquire : Take the lease on the file, or add one more holder if this mount already has it
+func (lk *LeaseKeeper) Acquire(name string) error {
+	lk.Lock()
+	defer lk.Unlock()
+
+	if count, found := lk.leases[name]; found {
+		lk.leases[name] = count + 1
+		return nil
+	}
+
+	err := lk.next.AcquireLease(AcquireLeaseOptions{Name: name, Duration: LeaseDuration})
+	if err != nil {
+		return err
+	}
+
+	lk.leases[name] = 1
+	return nil
+}
+
+// Release : Remove one holder of the lease, the lease is released once there are no holders left
+func (lk *LeaseKeeper) Release(name string) error {
+	lk.Lock()
+	defer lk.Unlock()
+
+	count, found := lk.leases[name]
+	if !found {
+		return nil
+	}
+
+	if count > 1 {
+		lk.leases[name] = count - 1
+		return nil
+	}
+
+	delete(lk.leases, name)
+	return lk.next.ReleaseLease(ReleaseLeaseOptions{Name: name})
+}
+
+// Forget : Drop the lease without releasing it, e.g. when the file is deleted or renamed
+func (lk *LeaseKeeper) Forget(name string) {
+	lk.Lock()
+	defer lk.Unlock()
+
+	delete(lk.leases, name)
+}
+
+// IsHeld : Whether this mount holds the lease on the file
+func (lk *LeaseKeeper) IsHeld(name string) bool {
+	lk.Lock()
+	defer lk.Unlock()
+
+	_, found := lk.leases[name]
+	return found
+}
+
+// renewer : Renew all the leases held at regular interval
+func (lk *LeaseKeeper) renewer() {
+	defer lk.wg.Done()
+
+	ticker := time.NewTicker(lk.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-ticker.C:
+			lk.renewAll()
+		}
+	}
+}
+
+func (lk *LeaseKeeper) renewAll() {
+	lk.Lock()
+	names := make([]string, 0, len(lk.leases))
+	for name := range lk.leases {
+		names = append(names, name)
+	}
+	lk.Unlock()
+
+	for _, name := range names {
+		err := lk.next.RenewLease(RenewLeaseOptions{Name: name})
+		if err == syscall.EBUSY || err == syscall.ENOENT {
+			// Lease can not be kept anymore, writes to the file may now fail or race with others
+			log.Err("LeaseKeeper::renewAll : Lease on %s is lost [%s]", name, err.Error())
+			lk.Forget(name)
+		} else if err != nil {
+			// Try again on next interval, lease is still valid for a while
+			log.Warn("LeaseKeeper::renewAll : Failed to renew lease on %s [%s]", name, err.Error())
+		}
+	}
+}
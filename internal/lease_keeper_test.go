@@ -0,0 +1,127 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package internal
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type leaseKeeperTestSuite struct {
+	suite.Suite
+	assert   *assert.Assertions
+	mockCtrl *gomock.Controller
+	mock     *MockComponent
+	keeper   *LeaseKeeper
+}
+
+func (s *leaseKeeperTestSuite) SetupTest() {
+	s.assert = assert.New(s.T())
+	s.mockCtrl = gomock.NewController(s.T())
+	s.mock = NewMockComponent(s.mockCtrl)
+	s.keeper = NewLeaseKeeper(s.mock)
+}
+
+func (s *leaseKeeperTestSuite) TearDownTest() {
+	s.mockCtrl.Finish()
+}
+
+func (s *leaseKeeperTestSuite) TestAcquireRelease() {
+	s.mock.EXPECT().AcquireLease(AcquireLeaseOptions{Name: "a", Duration: LeaseDuration}).Return(nil)
+
+	// Second holder shares the lease taken by the first one
+	s.assert.Nil(s.keeper.Acquire("a"))
+	s.assert.Nil(s.keeper.Acquire("a"))
+	s.assert.True(s.keeper.IsHeld("a"))
+
+	s.assert.Nil(s.keeper.Release("a"))
+	s.assert.True(s.keeper.IsHeld("a"))
+
+	s.mock.EXPECT().ReleaseLease(ReleaseLeaseOptions{Name: "a"}).Return(nil)
+	s.assert.Nil(s.keeper.Release("a"))
+	s.assert.False(s.keeper.IsHeld("a"))
+
+	// Releasing a lease not held is a no-op
+	s.assert.Nil(s.keeper.Release("a"))
+}
+
+func (s *leaseKeeperTestSuite) TestAcquireBusy() {
+	s.mock.EXPECT().AcquireLease(AcquireLeaseOptions{Name: "a", Duration: LeaseDuration}).Return(syscall.EBUSY)
+
+	s.assert.Equal(syscall.EBUSY, s.keeper.Acquire("a"))
+	s.assert.False(s.keeper.IsHeld("a"))
+}
+
+func (s *leaseKeeperTestSuite) TestRenew() {
+	s.mock.EXPECT().AcquireLease(gomock.Any()).Return(nil).Times(3)
+	s.assert.Nil(s.keeper.Acquire("a"))
+	s.assert.Nil(s.keeper.Acquire("b"))
+	s.assert.Nil(s.keeper.Acquire("c"))
+
+	s.mock.EXPECT().RenewLease(RenewLeaseOptions{Name: "a"}).Return(nil)
+	s.mock.EXPECT().RenewLease(RenewLeaseOptions{Name: "b"}).Return(syscall.EBUSY)
+	s.mock.EXPECT().RenewLease(RenewLeaseOptions{Name: "c"}).Return(errors.New("timeout"))
+	s.keeper.renewAll()
+
+	// Lost lease is dropped, others are kept
+	s.assert.True(s.keeper.IsHeld("a"))
+	s.assert.False(s.keeper.IsHeld("b"))
+	s.assert.True(s.keeper.IsHeld("c"))
+}
+
+func (s *leaseKeeperTestSuite) TestStopReleasesAll() {
+	s.keeper.interval = time.Hour
+	s.keeper.Start()
+
+	s.mock.EXPECT().AcquireLease(gomock.Any()).Return(nil).Times(2)
+	s.assert.Nil(s.keeper.Acquire("a"))
+	s.assert.Nil(s.keeper.Acquire("b"))
+
+	s.mock.EXPECT().ReleaseLease(ReleaseLeaseOptions{Name: "a"}).Return(nil)
+	s.mock.EXPECT().ReleaseLease(ReleaseLeaseOptions{Name: "b"}).Return(nil)
+	s.keeper.Stop()
+
+	s.assert.False(s.keeper.IsHeld("a"))
+	s.assert.False(s.keeper.IsHeld("b"))
+}
+
+func TestLeaseKeeperTestSuite(t *testing.T) {
+	suite.Run(t, new(leaseKeeperTestSuite))
+}
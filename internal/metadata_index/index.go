@@ -0,0 +1,488 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package metadata_index
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+)
+
+const formatVersion = 1
+
+// ErrSourceMismatch : Index on disk was built from a different container
+var ErrSourceMismatch = errors.New("index is of a different container")
+
+// Entry : Attributes of a file or directory kept in the index
+type Entry struct {
+	Name  string
+	Size  int64
+	Mtime int64 // Unix time in nanoseconds
+	Mode  uint32
+	Flags uint16
+	ETag  string
+}
+
+// dirListing : Entries right under a directory sorted by name, never changed once created so it can be read without locks
+type dirListing struct {
+	entries  []Entry
+	listedAt time.Time
+}
+
+// Index : Snapshot of the paths in a container, held as the complete listing of each directory.
+// Paths under directories not in the index are unknown, the caller has to go to storage for them.
+// A nil Index knows about no path.
+type Index struct {
+	source string
+
+	sync.RWMutex
+	dirs    map[string]*dirListing
+	pending map[string]time.Time // Directories whose listing was dropped as something in them changed, by the time it was dropped
+	dropped chan struct{}        // Wakes up the refresher once a listing is dropped
+}
+
+// fileHeader : First record of the index on disk, followed by one dirRecord per directory
+type fileHeader struct {
+	Version int
+	Source  string
+	Dirs    int
+}
+
+type dirRecord struct {
+	Path     string
+	ListedAt time.Time
+	Entries  []Entry
+}
+
+// New : Empty index of the given container
+func New(source string) *Index {
+	return &Index{
+		source:  source,
+		dirs:    make(map[string]*dirListing),
+		pending: make(map[string]time.Time),
+		dropped: make(chan struct{}, 1),
+	}
+}
+
+// Source : Container the index is of
+func (idx *Index) Source() string {
+	return idx.source
+}
+
+// Len : Number of directories and entries in the index
+func (idx *Index) Len() (int, int) {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	entries := 0
+	for _, d := range idx.dirs {
+		entries += len(d.entries)
+	}
+	return len(idx.dirs), entries
+}
+
+// BuiltAt : Time of the oldest listing in the index
+func (idx *Index) BuiltAt() time.Time {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	oldest := time.Time{}
+	for _, d := range idx.dirs {
+		if oldest.IsZero() || d.listedAt.Before(oldest) {
+			oldest = d.listedAt
+		}
+	}
+	return oldest
+}
+
+// GetAttr : Attributes of the path and the time they were listed at. The last value tells whether the index knows
+// about the path at all, when it does and attributes are nil the path does not exist.
+func (idx *Index) GetAttr(name string) (*internal.ObjAttr, time.Time, bool) {
+	name = internal.TruncateDirName(strings.TrimPrefix(name, "/"))
+	if idx == nil || name == "" {
+		return nil, time.Time{}, false
+	}
+
+	dir, base := split(name)
+
+	idx.RLock()
+	d, found := idx.dirs[dir]
+	idx.RUnlock()
+
+	if !found {
+		return nil, time.Time{}, false
+	}
+
+	i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].Name >= base })
+	if i < len(d.entries) && d.entries[i].Name == base {
+		return d.entries[i].attr(name), d.listedAt, true
+	}
+
+	return nil, d.listedAt, true
+}
+
+// List : Entries right under the directory and the time they were listed at, false if the directory is not in the index
+func (idx *Index) List(name string) ([]*internal.ObjAttr, time.Time, bool) {
+	if idx == nil {
+		return nil, time.Time{}, false
+	}
+	name = internal.TruncateDirName(strings.TrimPrefix(name, "/"))
+
+	idx.RLock()
+	d, found := idx.dirs[name]
+	idx.RUnlock()
+
+	if !found {
+		return nil, time.Time{}, false
+	}
+
+	attrs := make([]*internal.ObjAttr, 0, len(d.entries))
+	for i := range d.entries {
+		attrs = append(attrs, d.entries[i].attr(join(name, d.entries[i].Name)))
+	}
+	return attrs, d.listedAt, true
+}
+
+// Forget : Drop the listing of the directory holding the path, and of the path itself if it is a directory.
+// Entries under them are unknown till they are listed again by the refresher of the mount.
+func (idx *Index) Forget(name string) {
+	if idx == nil {
+		return
+	}
+	name = internal.TruncateDirName(strings.TrimPrefix(name, "/"))
+	dir, _ := split(name)
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	idx.dropDir(dir)
+	delete(idx.dirs, name)
+}
+
+// ForgetDir : Drop the listings of the directory holding the path and of everything under the path
+func (idx *Index) ForgetDir(name string) {
+	if idx == nil {
+		return
+	}
+	name = internal.TruncateDirName(strings.TrimPrefix(name, "/"))
+	dir, _ := split(name)
+	prefix := internal.ExtendDirName(name)
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	idx.dropDir(dir)
+	for path := range idx.dirs {
+		if path == name || name == "" || strings.HasPrefix(path, prefix) {
+			delete(idx.dirs, path)
+		}
+	}
+}
+
+// dropDir : Drop the listing of a directory which changed, it stays pending till it is listed again. Caller holds the lock.
+func (idx *Index) dropDir(name string) {
+	_, listed := idx.dirs[name]
+	_, pending := idx.pending[name]
+	if !listed && !pending {
+		return
+	}
+
+	delete(idx.dirs, name)
+	idx.pending[name] = time.Now()
+
+	select {
+	case idx.dropped <- struct{}{}:
+	default:
+	}
+}
+
+// stale : Directories pending to be listed again along with the ones listed before the given time
+func (idx *Index) stale(listedBefore time.Time) []string {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	dirs := make([]string, 0, len(idx.pending))
+	for name := range idx.pending {
+		dirs = append(dirs, name)
+	}
+	for name, d := range idx.dirs {
+		if d.listedAt.Before(listedBefore) {
+			dirs = append(dirs, name)
+		}
+	}
+	return dirs
+}
+
+// known : Whether the listing of the directory is in the index, or it is pending to be listed again
+func (idx *Index) known(name string) bool {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	_, listed := idx.dirs[name]
+	_, pending := idx.pending[name]
+	return listed || pending
+}
+
+// orphan : Whether the directory is gone from the listing of its parent, so that its own listing is of no use.
+// Listings of an orphan directory and everything under it are dropped, and it is pending no more.
+func (idx *Index) orphan(name string) bool {
+	if name == "" {
+		return false
+	}
+	parent, base := split(name)
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	d, found := idx.dirs[parent]
+	if !found {
+		return false
+	}
+
+	i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].Name >= base })
+	if i < len(d.entries) && d.entries[i].Name == base && d.entries[i].isDir() {
+		return false
+	}
+
+	delete(idx.pending, name)
+	idx.dropTree(name)
+	return true
+}
+
+// setDir : Replace the listing of a directory, listings of sub directories which are gone are dropped.
+// Listing is not kept if the directory was dropped after it was listed, it stays pending instead.
+func (idx *Index) setDir(name string, attrs []*internal.ObjAttr, listedAt time.Time) []string {
+	entries := make([]Entry, 0, len(attrs))
+	subdirs := make([]string, 0)
+	for _, attr := range attrs {
+		entries = append(entries, newEntry(attr))
+		if attr.IsDir() {
+			subdirs = append(subdirs, join(name, attr.Name))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	if droppedAt, found := idx.pending[name]; found {
+		if droppedAt.After(listedAt) {
+			return subdirs
+		}
+		delete(idx.pending, name)
+	}
+
+	if old, found := idx.dirs[name]; found {
+		current := make(map[string]bool, len(subdirs))
+		for _, sub := range subdirs {
+			current[sub] = true
+		}
+		for _, e := range old.entries {
+			sub := join(name, e.Name)
+			if e.isDir() && !current[sub] {
+				idx.dropTree(sub)
+			}
+		}
+	}
+
+	idx.dirs[name] = &dirListing{entries: entries, listedAt: listedAt}
+
+	return subdirs
+}
+
+// dropTree : Drop the listings of a directory and everything under it, caller holds the lock
+func (idx *Index) dropTree(name string) {
+	prefix := internal.ExtendDirName(name)
+	for path := range idx.dirs {
+		if path == name || strings.HasPrefix(path, prefix) {
+			delete(idx.dirs, path)
+		}
+	}
+}
+
+// Save : Write the index to the file, the file is replaced only once the whole index is written
+func (idx *Index) Save(path string) error {
+	idx.RLock()
+	dirs := make([]dirRecord, 0, len(idx.dirs))
+	for name, d := range idx.dirs {
+		dirs = append(dirs, dirRecord{Path: name, ListedAt: d.listedAt, Entries: d.entries})
+	}
+	header := fileHeader{Version: formatVersion, Source: idx.source, Dirs: len(dirs)}
+	idx.RUnlock()
+
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	err = writeIndex(f, header, dirs)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func writeIndex(w io.Writer, header fileHeader, dirs []dirRecord) error {
+	zw := gzip.NewWriter(w)
+	enc := gob.NewEncoder(zw)
+
+	err := enc.Encode(header)
+	if err != nil {
+		return err
+	}
+
+	for i := range dirs {
+		err = enc.Encode(&dirs[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// Load : Read the index of the container from the file
+func Load(path string, source string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index file [%s]", err.Error())
+	}
+	dec := gob.NewDecoder(zr)
+
+	header := fileHeader{}
+	err = dec.Decode(&header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index file [%s]", err.Error())
+	}
+
+	if header.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported index version %d", header.Version)
+	}
+	if header.Source != source {
+		return nil, ErrSourceMismatch
+	}
+
+	idx := New(source)
+	for i := 0; i < header.Dirs; i++ {
+		record := dirRecord{}
+		err = dec.Decode(&record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index file [%s]", err.Error())
+		}
+		idx.dirs[record.Path] = &dirListing{entries: record.Entries, listedAt: record.ListedAt}
+	}
+
+	return idx, nil
+}
+
+func newEntry(attr *internal.ObjAttr) Entry {
+	return Entry{
+		Name:  attr.Name,
+		Size:  attr.Size,
+		Mtime: attr.Mtime.UnixNano(),
+		Mode:  uint32(attr.Mode),
+		Flags: uint16(attr.Flags),
+		ETag:  attr.ETag,
+	}
+}
+
+func (e *Entry) isDir() bool {
+	return common.BitMap16(e.Flags).IsSet(internal.PropFlagIsDir)
+}
+
+// attr : Attributes of the entry, times other than modified time are not kept so they are all the same
+func (e *Entry) attr(path string) *internal.ObjAttr {
+	mtime := time.Unix(0, e.Mtime)
+	return &internal.ObjAttr{
+		Path:   path,
+		Name:   e.Name,
+		Size:   e.Size,
+		Mode:   os.FileMode(e.Mode),
+		Flags:  common.BitMap16(e.Flags),
+		Mtime:  mtime,
+		Atime:  mtime,
+		Ctime:  mtime,
+		Crtime: mtime,
+		ETag:   e.ETag,
+	}
+}
+
+// split : Directory holding the path and the name of the path in it
+func split(name string) (string, string) {
+	idx := strings.LastIndex(name, "/")
+	if idx < 0 {
+		return "", name
+	}
+	return name[:idx], name[idx+1:]
+}
+
+func join(dir string, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
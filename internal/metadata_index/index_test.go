@@ -0,0 +1,415 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package metadata_index
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/component/loopback"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type indexTestSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	dir     string
+	storage internal.Component
+}
+
+func (suite *indexTestSuite) SetupTest() {
+	suite.assert = assert.New(suite.T())
+	err := log.SetDefaultLogger("silent", common.LogConfig{Level: common.ELogLevel.LOG_DEBUG()})
+	if err != nil {
+		panic("Unable to set silent logger as default.")
+	}
+
+	suite.dir = suite.T().TempDir()
+	suite.configure("")
+}
+
+func (suite *indexTestSuite) configure(extra string) {
+	config.ResetConfig()
+	cfg := "components:\n  - loopbackfs\nloopbackfs:\n  path: " + suite.dir + "\n" + extra
+	suite.assert.NoError(config.ReadConfigFromReader(strings.NewReader(cfg)))
+
+	suite.storage = loopback.NewLoopbackFSComponent()
+	suite.assert.NoError(suite.storage.Configure(true))
+}
+
+func TestIndex(t *testing.T) {
+	suite.Run(t, new(indexTestSuite))
+}
+
+func (suite *indexTestSuite) createFile(name string, size int) {
+	path := filepath.Join(suite.dir, name)
+	suite.assert.NoError(os.MkdirAll(filepath.Dir(path), 0777))
+	suite.assert.NoError(os.WriteFile(path, make([]byte, size), 0666))
+}
+
+func (suite *indexTestSuite) build() *Index {
+	idx := New(SourceID())
+	suite.assert.NoError(idx.Refresh(context.Background(), suite.storage, "", 4))
+	return idx
+}
+
+func names(attrs []*internal.ObjAttr) []string {
+	list := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		list = append(list, attr.Path)
+	}
+	return list
+}
+
+func (suite *indexTestSuite) TestRefresh() {
+	suite.createFile("a.txt", 10)
+	suite.createFile("dir/b.txt", 20)
+	suite.createFile("dir/sub/c.txt", 30)
+	suite.assert.NoError(os.Mkdir(filepath.Join(suite.dir, "empty"), 0777))
+
+	idx := suite.build()
+	dirs, entries := idx.Len()
+	suite.assert.Equal(4, dirs)
+	suite.assert.Equal(6, entries)
+
+	attr, _, known := idx.GetAttr("dir/b.txt")
+	suite.assert.True(known)
+	suite.assert.NotNil(attr)
+	suite.assert.Equal(int64(20), attr.Size)
+	suite.assert.Equal("b.txt", attr.Name)
+	suite.assert.False(attr.IsDir())
+
+	attr, _, known = idx.GetAttr("/dir/sub/")
+	suite.assert.True(known)
+	suite.assert.True(attr.IsDir())
+
+	// Paths missing from a listed directory do not exist
+	attr, _, known = idx.GetAttr("dir/missing.txt")
+	suite.assert.True(known)
+	suite.assert.Nil(attr)
+
+	_, _, known = idx.GetAttr("missing/file.txt")
+	suite.assert.False(known)
+
+	list, _, found := idx.List("")
+	suite.assert.True(found)
+	suite.assert.Equal([]string{"a.txt", "dir", "empty"}, names(list))
+
+	list, _, found = idx.List("empty")
+	suite.assert.True(found)
+	suite.assert.Empty(list)
+
+	list, _, found = idx.List("dir/sub")
+	suite.assert.True(found)
+	suite.assert.Equal([]string{"dir/sub/c.txt"}, names(list))
+
+	// Directories which are gone are dropped with everything under them
+	suite.assert.NoError(os.RemoveAll(filepath.Join(suite.dir, "dir")))
+	suite.assert.NoError(idx.Refresh(context.Background(), suite.storage, "", 4))
+
+	_, _, found = idx.List("dir/sub")
+	suite.assert.False(found)
+	dirs, entries = idx.Len()
+	suite.assert.Equal(2, dirs)
+	suite.assert.Equal(2, entries)
+}
+
+func (suite *indexTestSuite) TestRefreshSubtree() {
+	suite.createFile("dir/a.txt", 10)
+	suite.createFile("other/b.txt", 10)
+	idx := suite.build()
+	before := idx.BuiltAt()
+
+	suite.createFile("dir/c.txt", 10)
+	suite.createFile("other/d.txt", 10)
+	suite.assert.NoError(idx.Refresh(context.Background(), suite.storage, "dir/", 2))
+
+	list, _, _ := idx.List("dir")
+	suite.assert.Equal([]string{"dir/a.txt", "dir/c.txt"}, names(list))
+	list, _, _ = idx.List("other")
+	suite.assert.Equal([]string{"other/b.txt"}, names(list))
+	suite.assert.Equal(before, idx.BuiltAt())
+}
+
+func (suite *indexTestSuite) TestRefreshFailed() {
+	suite.createFile("dir/a.txt", 10)
+	idx := suite.build()
+
+	// Listings which fail are left as they were
+	suite.assert.NoError(os.RemoveAll(filepath.Join(suite.dir, "dir")))
+	err := idx.Refresh(context.Background(), suite.storage, "dir", 2)
+	suite.assert.Error(err)
+
+	list, _, found := idx.List("dir")
+	suite.assert.True(found)
+	suite.assert.Equal([]string{"dir/a.txt"}, names(list))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = idx.Refresh(ctx, suite.storage, "", 2)
+	suite.assert.ErrorIs(err, context.Canceled)
+}
+
+func (suite *indexTestSuite) TestRefreshStale() {
+	suite.createFile("dir/a.txt", 10)
+	suite.createFile("dir/sub/b.txt", 10)
+	suite.createFile("other/c.txt", 10)
+
+	// Empty index is built in full
+	idx := New(SourceID())
+	listed, err := idx.RefreshStale(context.Background(), suite.storage, time.Time{}, 2)
+	suite.assert.NoError(err)
+	suite.assert.Equal(4, listed)
+	before := idx.BuiltAt()
+
+	// Only directories dropped as something in them changed are listed, along with new directories found in them
+	suite.createFile("dir/d.txt", 10)
+	suite.createFile("dir/new/e.txt", 10)
+	suite.createFile("other/f.txt", 10)
+	idx.Forget("dir/d.txt")
+
+	listed, err = idx.RefreshStale(context.Background(), suite.storage, time.Time{}, 2)
+	suite.assert.NoError(err)
+	suite.assert.Equal(2, listed)
+
+	list, listedAt, found := idx.List("dir")
+	suite.assert.True(found)
+	suite.assert.True(listedAt.After(before))
+	suite.assert.Equal([]string{"dir/a.txt", "dir/d.txt", "dir/new", "dir/sub"}, names(list))
+	list, _, _ = idx.List("dir/new")
+	suite.assert.Equal([]string{"dir/new/e.txt"}, names(list))
+	list, _, _ = idx.List("other")
+	suite.assert.Equal([]string{"other/c.txt"}, names(list))
+
+	listed, err = idx.RefreshStale(context.Background(), suite.storage, time.Time{}, 2)
+	suite.assert.NoError(err)
+	suite.assert.Equal(0, listed)
+
+	// Directories removed from the container are dropped once their parent is listed again
+	suite.assert.NoError(os.RemoveAll(filepath.Join(suite.dir, "dir", "sub")))
+	idx.ForgetDir("dir/sub")
+	_, err = idx.RefreshStale(context.Background(), suite.storage, time.Time{}, 2)
+	suite.assert.NoError(err)
+	list, _, _ = idx.List("dir")
+	suite.assert.Equal([]string{"dir/a.txt", "dir/d.txt", "dir/new"}, names(list))
+	_, _, found = idx.List("dir/sub")
+	suite.assert.False(found)
+
+	// Directories listed before the given time are listed again
+	listed, err = idx.RefreshStale(context.Background(), suite.storage, time.Now(), 2)
+	suite.assert.NoError(err)
+	suite.assert.Equal(4, listed)
+	list, _, _ = idx.List("other")
+	suite.assert.Equal([]string{"other/c.txt", "other/f.txt"}, names(list))
+}
+
+// Tests that a directory dropped while it is being listed is not put back with the listing started before the change
+func (suite *indexTestSuite) TestDroppedWhileListing() {
+	suite.createFile("dir/a.txt", 10)
+	idx := suite.build()
+
+	listedAt := time.Now()
+	idx.Forget("dir/a.txt")
+	idx.setDir("dir", nil, listedAt)
+
+	_, _, found := idx.List("dir")
+	suite.assert.False(found)
+	suite.assert.Equal([]string{"dir"}, idx.stale(time.Time{}))
+}
+
+func (suite *indexTestSuite) TestForget() {
+	suite.createFile("a.txt", 10)
+	suite.createFile("dir/b.txt", 10)
+	suite.createFile("dir/sub/c.txt", 10)
+	idx := suite.build()
+
+	idx.Forget("dir/b.txt")
+	_, _, known := idx.GetAttr("dir/b.txt")
+	suite.assert.False(known)
+	_, _, known = idx.GetAttr("dir/sub/c.txt")
+	suite.assert.True(known)
+
+	idx.ForgetDir("dir")
+	_, _, known = idx.GetAttr("dir/sub/c.txt")
+	suite.assert.False(known)
+	_, _, known = idx.GetAttr("a.txt")
+	suite.assert.False(known)
+
+	dirs, _ := idx.Len()
+	suite.assert.Equal(0, dirs)
+
+	// A nil index knows about no path
+	var none *Index
+	none.Forget("a.txt")
+	none.ForgetDir("dir")
+	_, _, known = none.GetAttr("a.txt")
+	suite.assert.False(known)
+	_, _, found := none.List("")
+	suite.assert.False(found)
+}
+
+func (suite *indexTestSuite) TestSaveLoad() {
+	suite.createFile("a.txt", 10)
+	suite.createFile("dir/b.txt", 20)
+	idx := suite.build()
+
+	path := filepath.Join(suite.T().TempDir(), "index", "container.idx")
+	suite.assert.NoError(idx.Save(path))
+	_, err := os.Stat(path + ".tmp")
+	suite.assert.True(os.IsNotExist(err))
+
+	loaded, err := Load(path, idx.Source())
+	suite.assert.NoError(err)
+	suite.assert.Equal(idx.BuiltAt().UnixNano(), loaded.BuiltAt().UnixNano())
+
+	expected, _, _ := idx.GetAttr("dir/b.txt")
+	attr, _, known := loaded.GetAttr("dir/b.txt")
+	suite.assert.True(known)
+	suite.assert.Equal(expected.Size, attr.Size)
+	suite.assert.True(expected.Mtime.Equal(attr.Mtime))
+	suite.assert.Equal(expected.Mode, attr.Mode)
+	suite.assert.Equal(expected.Flags, attr.Flags)
+
+	_, err = Load(path, "azstorage,account-name=other")
+	suite.assert.True(errors.Is(err, ErrSourceMismatch))
+
+	suite.assert.NoError(os.WriteFile(path, []byte("not an index"), 0644))
+	_, err = Load(path, idx.Source())
+	suite.assert.Error(err)
+	suite.assert.Contains(err.Error(), "invalid index file")
+
+	// Invalid or missing files give an empty index
+	opts := Options{Path: path}
+	dirs, _ := Open(opts, idx.Source()).Len()
+	suite.assert.Equal(0, dirs)
+	opts.Path = filepath.Join(suite.T().TempDir(), "missing.idx")
+	dirs, _ = Open(opts, idx.Source()).Len()
+	suite.assert.Equal(0, dirs)
+}
+
+func (suite *indexTestSuite) TestReadOptions() {
+	_, found, err := ReadOptions()
+	suite.assert.NoError(err)
+	suite.assert.False(found)
+
+	suite.configure("metadata-index:\n  parallelism: 4\n")
+	_, _, err = ReadOptions()
+	suite.assert.Error(err)
+	suite.assert.Contains(err.Error(), "path not set")
+
+	suite.configure("metadata-index:\n  path: /tmp/index.idx\n")
+	opts, found, err := ReadOptions()
+	suite.assert.NoError(err)
+	suite.assert.True(found)
+	suite.assert.Equal(uint32(defaultRefreshSec), opts.RefreshSec)
+	suite.assert.Equal(uint32(defaultParallelism), opts.Parallelism)
+
+	suite.configure("metadata-index:\n  path: /tmp/index.idx\n  refresh-sec: 0\n")
+	opts, _, _ = ReadOptions()
+	suite.assert.Equal(uint32(0), opts.RefreshSec)
+}
+
+func (suite *indexTestSuite) TestSourceID() {
+	config.ResetConfig()
+	cfg := "components:\n  - libfuse\n  - azstorage\nazstorage:\n  account-name: acc\n  container: cont\n  subdirectory: data\n"
+	suite.assert.NoError(config.ReadConfigFromReader(strings.NewReader(cfg)))
+	suite.assert.Equal("azstorage,account-name=acc,container=cont,subdirectory=data", SourceID())
+}
+
+func (suite *indexTestSuite) TestAcquire() {
+	path := filepath.Join(suite.T().TempDir(), "container.idx")
+	idx, err := Acquire("attr_cache", suite.storage)
+	suite.assert.NoError(err)
+	suite.assert.Nil(idx)
+
+	suite.createFile("dir/a.txt", 10)
+	suite.configure("metadata-index:\n  path: " + path + "\n")
+
+	// Index is shared by its users and built right away as there is none on disk yet
+	idx, err = Acquire("entry_cache", suite.storage)
+	suite.assert.NoError(err)
+	suite.assert.NotNil(idx)
+	other, err := Acquire("attr_cache", suite.storage)
+	suite.assert.NoError(err)
+	suite.assert.Same(idx, other)
+
+	suite.assert.Eventually(func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	list, _, found := idx.List("dir")
+	suite.assert.True(found)
+	suite.assert.Equal([]string{"dir/a.txt"}, names(list))
+
+	// Changed paths are not served from the index till their directory is listed again
+	suite.createFile("dir/c.txt", 10)
+	idx.onChange(invalidation.Event{Path: "dir/c.txt"})
+	_, _, found = idx.List("dir")
+	suite.assert.False(found)
+
+	suite.assert.Eventually(func() bool {
+		list, _, found := idx.List("dir")
+		return found && len(list) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	Release("entry_cache")
+	Release("attr_cache")
+
+	// Index saved on release is loaded by the next mount and not refreshed till it is due
+	suite.createFile("b.txt", 10)
+	idx, err = Acquire("attr_cache", suite.storage)
+	suite.assert.NoError(err)
+	attr, _, known := idx.GetAttr("dir")
+	suite.assert.True(known)
+	suite.assert.True(attr.IsDir())
+	_, _, known = idx.GetAttr("dir/c.txt")
+	suite.assert.True(known)
+	attr, _, known = idx.GetAttr("b.txt")
+	suite.assert.True(known)
+	suite.assert.Nil(attr)
+	Release("attr_cache")
+}
@@ -0,0 +1,291 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package metadata_index
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common"
+	"github.com/Azure/azure-storage-fuse/v2/common/config"
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+	"github.com/Azure/azure-storage-fuse/v2/internal/invalidation"
+)
+
+// Config key of the metadata index, read by attr_cache and entry_cache
+const ConfigKey = "metadata-index"
+
+const (
+	defaultRefreshSec  = 3600
+	defaultParallelism = 16
+
+	minRefreshWait   = time.Second // Shortest time between two refreshes of the mount
+	refreshRetryWait = time.Minute // Time after which directories which failed to list are tried again
+)
+
+// Options : Where the index is kept and how often it is refreshed
+type Options struct {
+	Path        string `config:"path" yaml:"path,omitempty"`
+	RefreshSec  uint32 `config:"refresh-sec" yaml:"refresh-sec,omitempty"`
+	Parallelism uint32 `config:"parallelism" yaml:"parallelism,omitempty"`
+}
+
+// ReadOptions : Options of the index given in config, false if no index is configured
+func ReadOptions() (Options, bool, error) {
+	opts := Options{}
+	if !config.IsSet(ConfigKey) {
+		return opts, false, nil
+	}
+
+	err := config.UnmarshalKey(ConfigKey, &opts)
+	if err != nil {
+		return opts, false, fmt.Errorf("invalid %s [%s]", ConfigKey, err.Error())
+	}
+
+	opts.Path = common.ExpandPath(opts.Path)
+	if opts.Path == "" {
+		return opts, false, fmt.Errorf("invalid %s [path not set]", ConfigKey)
+	}
+
+	if !config.IsSet(ConfigKey + ".refresh-sec") {
+		opts.RefreshSec = defaultRefreshSec
+	}
+	if opts.Parallelism == 0 {
+		opts.Parallelism = defaultParallelism
+	}
+
+	return opts, true, nil
+}
+
+// SourceID : Identity of the container the mount is of, an index is used only with the container it was built from
+func SourceID() string {
+	components := make([]string, 0)
+	_ = config.UnmarshalKey("components", &components)
+	if len(components) == 0 {
+		return ""
+	}
+
+	storage := components[len(components)-1]
+	parts := []string{storage}
+	for _, key := range []string{"account-name", "container", "bucket-name", "subdirectory"} {
+		value := ""
+		_ = config.UnmarshalKey(storage+"."+key, &value)
+		if value != "" {
+			parts = append(parts, key+"="+value)
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Open : Load the index from disk, an empty index is returned if there is none for the container yet
+func Open(opts Options, source string) *Index {
+	idx, err := Load(opts.Path, source)
+	if err == nil {
+		dirs, entries := idx.Len()
+		log.Info("metadata_index::Open : Loaded %d entries of %d directories from %s, built at %v",
+			entries, dirs, opts.Path, idx.BuiltAt())
+		return idx
+	}
+
+	if !os.IsNotExist(err) {
+		log.Warn("metadata_index::Open : Ignoring index %s [%s]", opts.Path, err.Error())
+	}
+	return New(source)
+}
+
+// mountIndex : Index of the mount along with the components using it and its refresher
+type mountIndex struct {
+	idx   *Index
+	opts  Options
+	users map[string]bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Index configured for the mount, shared by the components so that it is loaded and refreshed once
+var (
+	mountLock sync.Mutex
+	mounted   *mountIndex
+)
+
+// Acquire : Index configured for the mount, nil if there is none. It is loaded on first call and refreshed in
+// background by listing through the storage component behind the given one, and saved back on disk once the last user releases it.
+func Acquire(name string, next internal.Component) (*Index, error) {
+	mountLock.Lock()
+	defer mountLock.Unlock()
+
+	if mounted == nil {
+		opts, found, err := ReadOptions()
+		if err != nil || !found {
+			return nil, err
+		}
+
+		m := &mountIndex{
+			idx:   Open(opts, SourceID()),
+			opts:  opts,
+			users: make(map[string]bool),
+		}
+
+		err = invalidation.Subscribe(ConfigKey, m.idx.onChange)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.RefreshSec > 0 && next != nil {
+			// Listing goes straight to storage so that a refresh does not churn the caches in between
+			for next.NextComponent() != nil {
+				next = next.NextComponent()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			m.cancel = cancel
+			m.wg.Add(1)
+			go m.refresher(ctx, next)
+		}
+
+		mounted = m
+	}
+
+	mounted.users[name] = true
+	return mounted.idx, nil
+}
+
+// Release : Done using the index, the last user stops its refresher
+func Release(name string) {
+	mountLock.Lock()
+	defer mountLock.Unlock()
+
+	if mounted == nil {
+		return
+	}
+
+	delete(mounted.users, name)
+	if len(mounted.users) > 0 {
+		return
+	}
+
+	invalidation.Unsubscribe(ConfigKey)
+
+	if mounted.cancel != nil {
+		mounted.cancel()
+		mounted.wg.Wait()
+
+		// Without a refresher the file is managed by 'blobfuse2 index' commands only
+		mounted.save()
+	}
+
+	mounted = nil
+}
+
+// refresher : List again the directories of the index which are older than the refresh interval, and the ones dropped
+// from it as something in them changed. Whole container is listed if the index is empty.
+func (m *mountIndex) refresher(ctx context.Context, next internal.Component) {
+	defer m.wg.Done()
+
+	interval := time.Duration(m.opts.RefreshSec) * time.Second
+	savedAt := time.Time{}
+	wait := m.nextRefresh(interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.idx.dropped:
+			// Let a burst of changes settle so that their directories are listed together
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(minRefreshWait):
+			}
+		case <-time.After(wait):
+		}
+
+		listed, err := m.idx.RefreshStale(ctx, next, time.Now().Add(-interval), int(m.opts.Parallelism))
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait = m.nextRefresh(interval)
+		if err != nil {
+			log.Err("mountIndex::refresher : Index %s is partially refreshed [%s]", m.opts.Path, err.Error())
+			wait = min(wait, refreshRetryWait)
+		}
+
+		if listed > 0 {
+			log.Debug("mountIndex::refresher : Listed %d directories of index %s", listed, m.opts.Path)
+			if time.Since(savedAt) >= interval {
+				m.save()
+				savedAt = time.Now()
+			}
+		}
+	}
+}
+
+// nextRefresh : Time till the oldest listing in the index is due to be listed again
+func (m *mountIndex) nextRefresh(interval time.Duration) time.Duration {
+	if dirs, _ := m.idx.Len(); dirs == 0 {
+		return 0
+	}
+	return max(time.Until(m.idx.BuiltAt().Add(interval)), minRefreshWait)
+}
+
+func (m *mountIndex) save() {
+	dirs, entries := m.idx.Len()
+	if dirs == 0 {
+		return
+	}
+
+	err := m.idx.Save(m.opts.Path)
+	if err != nil {
+		log.Err("mountIndex::save : Failed to save index %s [%s]", m.opts.Path, err.Error())
+		return
+	}
+	log.Info("mountIndex::save : Saved %d entries of %d directories to %s", entries, dirs, m.opts.Path)
+}
+
+// onChange : Paths changed in the container are unknown till they are listed again
+func (idx *Index) onChange(event invalidation.Event) {
+	if event.Dir {
+		idx.ForgetDir(event.Path)
+	} else {
+		idx.Forget(event.Path)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package metadata_index
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+	"github.com/Azure/azure-storage-fuse/v2/internal"
+)
+
+// Number of entries asked for in each page of a listing
+const listPageSize = 5000
+
+// walker : Lists a tree of directories with a pool of workers, each directory is put in the index as soon as it is listed
+type walker struct {
+	idx         *Index
+	comp        internal.Component
+	ctx         context.Context
+	incremental bool // Sub directories already in the index are not listed again
+
+	sync.Mutex
+	cond   *sync.Cond
+	queue  []string
+	active int
+	listed int
+	failed int
+	err    error
+}
+
+// Refresh : List the directory and everything under it, replacing their listings in the index one directory at a time.
+// Listings which fail are left as they were, the first failure is returned once the walk is over.
+func (idx *Index) Refresh(ctx context.Context, comp internal.Component, root string, parallelism int) error {
+	w := &walker{
+		idx:   idx,
+		comp:  comp,
+		ctx:   ctx,
+		queue: []string{internal.TruncateDirName(root)},
+	}
+	return w.walk(root, parallelism)
+}
+
+// RefreshStale : List again the directories dropped from the index as something in them changed, and the ones listed
+// before the given time. Sub directories found which are not in the index yet are listed as well, everything else is left as it is.
+// Returns the number of directories listed, the whole container is listed if the index is empty.
+func (idx *Index) RefreshStale(ctx context.Context, comp internal.Component, listedBefore time.Time, parallelism int) (int, error) {
+	dirs := idx.stale(listedBefore)
+	if n, _ := idx.Len(); n == 0 && len(dirs) == 0 {
+		dirs = append(dirs, "")
+	}
+	if len(dirs) == 0 {
+		return 0, nil
+	}
+
+	w := &walker{
+		idx:         idx,
+		comp:        comp,
+		ctx:         ctx,
+		incremental: true,
+		queue:       dirs,
+	}
+	err := w.walk("stale directories", parallelism)
+	return w.listed, err
+}
+
+// walk : List everything in the queue with the given number of workers
+func (w *walker) walk(root string, parallelism int) error {
+	w.cond = sync.NewCond(&w.Mutex)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(parallelism, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+
+	if w.failed > 0 {
+		log.Warn("Index::Refresh : Failed to list %d directories under %s", w.failed, root)
+	}
+
+	if w.err == nil {
+		w.err = w.ctx.Err()
+	}
+	return w.err
+}
+
+func (w *walker) work() {
+	for {
+		w.Lock()
+		for len(w.queue) == 0 && w.active > 0 {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 || w.ctx.Err() != nil {
+			// Nothing left to list, wake up the others so that they exit too
+			w.cond.Broadcast()
+			w.Unlock()
+			return
+		}
+
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.active++
+		w.Unlock()
+
+		subdirs, err := w.list(dir)
+
+		w.Lock()
+		w.active--
+		w.listed++
+		if err != nil {
+			log.Err("Index::Refresh : Failed to list %s [%s]", dir, err.Error())
+			w.failed++
+			if w.err == nil {
+				w.err = err
+			}
+		}
+		w.queue = append(w.queue, subdirs...)
+		w.cond.Broadcast()
+		w.Unlock()
+	}
+}
+
+// list : List the directory page by page and put it in the index, returns its sub directories
+func (w *walker) list(dir string) ([]string, error) {
+	listedAt := time.Now()
+	attrs := make([]*internal.ObjAttr, 0)
+	token := ""
+
+	for {
+		if w.ctx.Err() != nil {
+			return nil, w.ctx.Err()
+		}
+
+		page, next, err := w.comp.StreamDir(internal.StreamDirOptions{Name: dir, Token: token, Count: listPageSize})
+		if err != nil {
+			// Directory may have been removed since it was dropped from the index
+			if w.incremental && w.idx.orphan(dir) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		attrs = append(attrs, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	if !w.incremental {
+		return w.idx.setDir(dir, attrs, listedAt), nil
+	}
+
+	if w.idx.orphan(dir) {
+		return nil, nil
+	}
+
+	subdirs := make([]string, 0)
+	for _, sub := range w.idx.setDir(dir, attrs, listedAt) {
+		if !w.idx.known(sub) {
+			subdirs = append(subdirs, sub)
+		}
+	}
+	return subdirs, nil
+}
@@ -72,6 +72,20 @@ func (m *MockComponent) EXPECT() *MockComponentMockRecorder {
 	return m.recorder
 }
 
+// AcquireLease mocks base method.
+func (m *MockComponent) AcquireLease(arg0 AcquireLeaseOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AcquireLease", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AcquireLease indicates an expected call of AcquireLease.
+func (mr *MockComponentMockRecorder) AcquireLease(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcquireLease", reflect.TypeOf((*MockComponent)(nil).AcquireLease), arg0)
+}
+
 // Chmod mocks base method.
 func (m *MockComponent) Chmod(arg0 ChmodOptions) error {
 	m.ctrl.T.Helper()
@@ -245,6 +259,92 @@ func (mr *MockComponentMockRecorder) DeleteFile(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFile", reflect.TypeOf((*MockComponent)(nil).DeleteFile), arg0)
 }
 
+// GetXattr mocks base method.
+func (m *MockComponent) GetXattr(arg0 GetXattrOptions) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetXattr", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetXattr indicates an expected call of GetXattr.
+func (mr *MockComponentMockRecorder) GetXattr(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetXattr", reflect.TypeOf((*MockComponent)(nil).GetXattr), arg0)
+}
+
+// ListXattr mocks base method.
+func (m *MockComponent) ListXattr(arg0 ListXattrOptions) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListXattr", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListXattr indicates an expected call of ListXattr.
+func (mr *MockComponentMockRecorder) ListXattr(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListXattr", reflect.TypeOf((*MockComponent)(nil).ListXattr), arg0)
+}
+
+// ReleaseLease mocks base method.
+func (m *MockComponent) ReleaseLease(arg0 ReleaseLeaseOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseLease", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseLease indicates an expected call of ReleaseLease.
+func (mr *MockComponentMockRecorder) ReleaseLease(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseLease", reflect.TypeOf((*MockComponent)(nil).ReleaseLease), arg0)
+}
+
+// RemoveXattr mocks base method.
+func (m *MockComponent) RemoveXattr(arg0 RemoveXattrOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveXattr", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveXattr indicates an expected call of RemoveXattr.
+func (mr *MockComponentMockRecorder) RemoveXattr(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveXattr", reflect.TypeOf((*MockComponent)(nil).RemoveXattr), arg0)
+}
+
+// RenewLease mocks base method.
+func (m *MockComponent) RenewLease(arg0 RenewLeaseOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenewLease", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenewLease indicates an expected call of RenewLease.
+func (mr *MockComponentMockRecorder) RenewLease(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenewLease", reflect.TypeOf((*MockComponent)(nil).RenewLease), arg0)
+}
+
+// SetXattr mocks base method.
+func (m *MockComponent) SetXattr(arg0 SetXattrOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetXattr", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetXattr indicates an expected call of SetXattr.
+func (mr *MockComponentMockRecorder) SetXattr(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetXattr", reflect.TypeOf((*MockComponent)(nil).SetXattr), arg0)
+}
+
 // SyncFile mocks base method.
 func (m *MockComponent) SyncDir(arg0 SyncDirOptions) error {
 	m.ctrl.T.Helper()
@@ -83,7 +83,10 @@ func NewPipeline(components []string, isParent bool) (*Pipeline, error) {
 				return nil, err
 			}
 
-			if !(comp.Priority() <= lastPriority) {
+			// pass-through components can sit anywhere, order is checked only between the components around them
+			if comp.Priority() == EComponentPriority.Any() {
+				log.Debug("Pipeline::NewPipeline : Component %s can be placed anywhere", comp.Name())
+			} else if !(comp.Priority() <= lastPriority) {
 				log.Err("Pipeline::NewPipeline : Invalid Component order [priority of %s higher than above components]", comp.Name())
 				return nil, fmt.Errorf("config error in Pipeline [component %s is out of order]", name)
 			} else {
@@ -77,6 +77,18 @@ func NewComponentC() Component {
 	return &ComponentC{}
 }
 
+type ComponentAny struct {
+	BaseComponent
+}
+
+func (ac *ComponentAny) Priority() ComponentPriority {
+	return EComponentPriority.Any()
+}
+
+func NewComponentAny() Component {
+	return &ComponentAny{}
+}
+
 type ComponentStream struct {
 	BaseComponent
 }
@@ -108,6 +120,7 @@ func (suite *pipelineTestSuite) SetupTest() {
 	AddComponent("ComponentA", NewComponentA)
 	AddComponent("ComponentB", NewComponentB)
 	AddComponent("ComponentC", NewComponentC)
+	AddComponent("ComponentAny", NewComponentAny)
 	AddComponent("stream", NewComponentStream)
 	AddComponent("block_cache", NewComponentBlockCache)
 	suite.assert = assert.New(suite.T())
@@ -125,6 +138,16 @@ func (s *pipelineTestSuite) TestCreateInvalidPipeline() {
 
 }
 
+func (s *pipelineTestSuite) TestAnyPriorityPipeline() {
+	_, err := NewPipeline([]string{"ComponentAny", "ComponentA", "ComponentAny", "ComponentB", "ComponentAny", "ComponentC", "ComponentAny"}, false)
+	s.assert.Nil(err)
+
+	// Components around a pass-through one are still checked against each other
+	_, err = NewPipeline([]string{"ComponentC", "ComponentAny", "ComponentA"}, false)
+	s.assert.NotNil(err)
+	s.assert.Contains(err.Error(), "is out of order")
+}
+
 func (s *pipelineTestSuite) TestInvalidComponent() {
 	_, err := NewPipeline([]string{"ComponentD"}, false)
 	s.assert.NotNil(err)
@@ -0,0 +1,211 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package shared_cache
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	retryInterval  = 30 * time.Second
+)
+
+// Client : Connection of a mount to the shared cache.
+// Shared cache is only an optimization, so any failure to talk to it is reported as a miss and the
+// caller goes to storage. Once it fails, it is not tried again till retryInterval has passed.
+type Client struct {
+	sync.Mutex
+	path    string
+	conns   chan net.Conn
+	puts    chan struct{} // Puts running in background
+	wg      sync.WaitGroup
+	retryAt time.Time
+}
+
+func NewClient(path string, maxConns uint32) *Client {
+	return &Client{
+		path:  path,
+		conns: make(chan net.Conn, max(maxConns, 1)),
+		puts:  make(chan struct{}, max(maxConns, 1)),
+	}
+}
+
+// Close : Wait for the puts in background and close all the idle connections
+func (c *Client) Close() {
+	c.wg.Wait()
+
+	for {
+		select {
+		case conn := <-c.conns:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Get : Read the block in to data, returns number of bytes read and whether the block was found
+func (c *Client) Get(key string, data []byte) (int, bool) {
+	status, n, err := c.request(opGet, key, nil, data)
+	if err != nil {
+		log.Debug("SharedCache::Get : Failed to get %s [%s]", key, err.Error())
+		return 0, false
+	}
+
+	return n, status == statusOK
+}
+
+// Put : Add the block to the cache
+func (c *Client) Put(key string, data []byte) {
+	_, _, err := c.request(opPut, key, data, nil)
+	if err != nil {
+		log.Debug("SharedCache::Put : Failed to put %s [%s]", key, err.Error())
+	}
+}
+
+// PutAsync : Add a copy of the block to the cache in background, data can be reused as soon as this returns.
+// If as many puts as connections are already running the block is not cached.
+func (c *Client) PutAsync(key string, data []byte) {
+	select {
+	case c.puts <- struct{}{}:
+	default:
+		log.Debug("SharedCache::PutAsync : Too many puts in progress, skipping %s", key)
+		return
+	}
+
+	block := make([]byte, len(data))
+	copy(block, data)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.Put(key, block)
+		<-c.puts
+	}()
+}
+
+// Stats : Get usage of the cache
+func (c *Client) Stats() (Stats, error) {
+	stats := Stats{}
+	buf := make([]byte, 4096)
+
+	_, n, err := c.request(opStats, "", nil, buf)
+	if err != nil {
+		return stats, err
+	}
+
+	err = json.Unmarshal(buf[:n], &stats)
+	return stats, err
+}
+
+func (c *Client) request(op byte, key string, data []byte, buf []byte) (byte, int, error) {
+	conn, err := c.get()
+	if err != nil {
+		return statusError, 0, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	err = writeRequest(conn, op, key, data)
+	if err != nil {
+		c.fail(conn, err)
+		return statusError, 0, err
+	}
+
+	status, n, err := readResponse(conn, buf)
+	if err != nil {
+		c.fail(conn, err)
+		return statusError, 0, err
+	}
+
+	c.put(conn)
+	if status == statusError {
+		return status, 0, errors.New("request failed in shared cache")
+	}
+	return status, n, nil
+}
+
+// get : Get an idle connection or open a new one
+func (c *Client) get() (net.Conn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	default:
+	}
+
+	c.Lock()
+	retryAt := c.retryAt
+	c.Unlock()
+
+	if time.Now().Before(retryAt) {
+		return nil, errors.New("shared cache unavailable")
+	}
+
+	conn, err := net.DialTimeout("unix", c.path, requestTimeout)
+	if err != nil {
+		c.fail(nil, err)
+	}
+	return conn, err
+}
+
+// put : Keep the connection for next request, unless there are enough idle connections already
+func (c *Client) put(conn net.Conn) {
+	select {
+	case c.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// fail : Drop the connection which may have a partial frame and hold off new ones for a while
+func (c *Client) fail(conn net.Conn, err error) {
+	if conn != nil {
+		conn.Close()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if time.Now().After(c.retryAt) {
+		log.Warn("SharedCache::fail : Shared cache on %s failed, not using it for %v [%s]", c.path, retryInterval, err.Error())
+	}
+	c.retryAt = time.Now().Add(retryInterval)
+}
@@ -0,0 +1,150 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package shared_cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Requests and responses exchanged over the unix socket are length prefixed frames
+//
+//	request  : op (1 byte) | key length (2 bytes) | key | data length (4 bytes) | data
+//	response : status (1 byte) | data length (4 bytes) | data
+//
+// A connection carries one request at a time, the client keeps a pool of connections for parallel requests.
+
+const (
+	opGet   byte = 'G' // Get a block, response carries the data on a hit
+	opPut   byte = 'P' // Put a block in the cache
+	opStats byte = 'S' // Get usage of the cache, response carries the stats as json
+
+	statusOK    byte = 0
+	statusMiss  byte = 1
+	statusError byte = 2
+)
+
+const (
+	maxKeyLen  = 4096
+	maxDataLen = 256 * 1024 * 1024
+)
+
+var errFrameTooLarge = errors.New("frame too large")
+
+func writeRequest(w io.Writer, op byte, key string, data []byte) error {
+	if len(key) > maxKeyLen || len(data) > maxDataLen {
+		return errFrameTooLarge
+	}
+
+	hdr := make([]byte, 3, 3+len(key)+4)
+	hdr[0] = op
+	binary.BigEndian.PutUint16(hdr[1:], uint16(len(key)))
+	hdr = append(hdr, key...)
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(len(data)))
+
+	_, err := w.Write(hdr)
+	if err == nil && len(data) > 0 {
+		_, err = w.Write(data)
+	}
+	return err
+}
+
+// readRequest : Read a request, data is read in a newly allocated buffer
+func readRequest(r io.Reader) (op byte, key string, data []byte, err error) {
+	var hdr [3]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	op = hdr[0]
+
+	kb := make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+	if _, err = io.ReadFull(r, kb); err != nil {
+		return
+	}
+	key = string(kb)
+
+	length, err := readLength(r)
+	if err != nil {
+		return
+	}
+
+	if length > 0 {
+		data = make([]byte, length)
+		_, err = io.ReadFull(r, data)
+	}
+	return
+}
+
+func writeResponse(w io.Writer, status byte, data []byte) error {
+	hdr := make([]byte, 1, 5+len(data))
+	hdr[0] = status
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(len(data)))
+	_, err := w.Write(append(hdr, data...))
+	return err
+}
+
+// readResponse : Read a response, data is read in to the given buffer which has to be large enough to hold it
+func readResponse(r io.Reader, buf []byte) (status byte, n int, err error) {
+	var hdr [1]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	status = hdr[0]
+
+	length, err := readLength(r)
+	if err != nil {
+		return
+	}
+
+	if int(length) > len(buf) {
+		return status, 0, errFrameTooLarge
+	}
+
+	n, err = io.ReadFull(r, buf[:length])
+	return
+}
+
+func readLength(r io.Reader) (uint32, error) {
+	var lb [4]byte
+	if _, err := io.ReadFull(r, lb[:]); err != nil {
+		return 0, err
+	}
+
+	length := binary.BigEndian.Uint32(lb[:])
+	if length > maxDataLen {
+		return 0, errFrameTooLarge
+	}
+	return length, nil
+}
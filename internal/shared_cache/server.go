@@ -0,0 +1,278 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package shared_cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/azure-storage-fuse/v2/common/log"
+)
+
+// Server : Host level cache of blocks shared by all the mounts on the host.
+// Mounts reading the same block of the same blob get it from here, so it is held in memory only once and
+// all mounts together use at most the configured capacity instead of each holding its own copy.
+type Server struct {
+	sync.Mutex
+	path     string
+	capacity uint64
+
+	used   uint64
+	blocks map[string]*list.Element
+	lru    *list.List
+
+	stats Stats
+
+	listener net.Listener
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+// Stats : Usage of the shared cache
+type Stats struct {
+	Capacity  uint64 `json:"capacity"`
+	Used      uint64 `json:"used"`
+	Blocks    int    `json:"blocks"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Puts      uint64 `json:"puts"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type cachedBlock struct {
+	key  string
+	data []byte
+}
+
+func NewServer(path string, capacity uint64) *Server {
+	return &Server{
+		path:     path,
+		capacity: capacity,
+		blocks:   make(map[string]*list.Element),
+		lru:      list.New(),
+		conns:    make(map[net.Conn]bool),
+	}
+}
+
+// Start : Start listening on the unix socket for mounts to attach
+func (s *Server) Start() error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Socket may be left behind by a daemon which did not exit cleanly
+	if conn, err := net.Dial("unix", s.path); err == nil {
+		conn.Close()
+		return errors.New("shared cache is already running on " + s.path)
+	}
+	_ = os.Remove(s.path)
+
+	s.listener, err = net.Listen("unix", s.path)
+	if err != nil {
+		return err
+	}
+
+	// Blocks of one mount are served to others, so only the user running the daemon can attach to it
+	err = os.Chmod(s.path, 0600)
+	if err != nil {
+		s.listener.Close()
+		return err
+	}
+
+	log.Info("SharedCache::Start : Listening on %s, capacity %v bytes", s.path, s.capacity)
+
+	s.wg.Add(1)
+	go s.accept()
+	return nil
+}
+
+// Stop : Stop listening, disconnect all the mounts and drop the cached blocks
+func (s *Server) Stop() {
+	s.listener.Close()
+
+	s.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+
+	s.Lock()
+	defer s.Unlock()
+
+	log.Info("SharedCache::Stop : Stopped, %v hits, %v misses, %v evictions", s.stats.Hits, s.stats.Misses, s.stats.Evictions)
+	s.blocks = make(map[string]*list.Element)
+	s.lru.Init()
+	s.used = 0
+}
+
+// Stats : Current usage of the cache
+func (s *Server) Stats() Stats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	stats.Capacity = s.capacity
+	stats.Used = s.used
+	stats.Blocks = len(s.blocks)
+	return stats
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Err("SharedCache::accept : Failed to accept connection [%s]", err.Error())
+			}
+			return
+		}
+
+		s.Lock()
+		s.conns[conn] = true
+		s.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve : Serve requests of one connection till it is closed
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		op, key, data, err := readRequest(conn)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Err("SharedCache::serve : Failed to read request [%s]", err.Error())
+			}
+			return
+		}
+
+		switch op {
+		case opGet:
+			data, found := s.get(key)
+			if found {
+				err = writeResponse(conn, statusOK, data)
+			} else {
+				err = writeResponse(conn, statusMiss, nil)
+			}
+
+		case opPut:
+			s.put(key, data)
+			err = writeResponse(conn, statusOK, nil)
+
+		case opStats:
+			stats, _ := json.Marshal(s.Stats())
+			err = writeResponse(conn, statusOK, stats)
+
+		default:
+			log.Err("SharedCache::serve : Invalid operation %v", op)
+			_ = writeResponse(conn, statusError, nil)
+			return
+		}
+
+		if err != nil {
+			log.Err("SharedCache::serve : Failed to write response [%s]", err.Error())
+			return
+		}
+	}
+}
+
+// get : Get data of the block, data is never modified once cached so it can be sent without holding the lock
+func (s *Server) get(key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	elem, found := s.blocks[key]
+	if !found {
+		s.stats.Misses++
+		return nil, false
+	}
+
+	s.stats.Hits++
+	s.lru.MoveToFront(elem)
+	return elem.Value.(*cachedBlock).data, true
+}
+
+// put : Cache the block, least recently used blocks are evicted to keep the usage within capacity
+func (s *Server) put(key string, data []byte) {
+	if uint64(len(data)) > s.capacity {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.Puts++
+	if elem, found := s.blocks[key]; found {
+		// Block is already cached by another mount
+		s.lru.MoveToFront(elem)
+		return
+	}
+
+	for s.used+uint64(len(data)) > s.capacity {
+		s.evict()
+	}
+
+	s.blocks[key] = s.lru.PushFront(&cachedBlock{key: key, data: data})
+	s.used += uint64(len(data))
+}
+
+func (s *Server) evict() {
+	elem := s.lru.Back()
+	block := elem.Value.(*cachedBlock)
+
+	s.lru.Remove(elem)
+	delete(s.blocks, block.key)
+	s.used -= uint64(len(block.data))
+	s.stats.Evictions++
+}
@@ -0,0 +1,215 @@
+/*
+    _____           _____   _____   ____          ______  _____  ------
+   |     |  |      |     | |     | |     |     | |       |            |
+   |     |  |      |     | |     | |     |     | |       |            |
+   | --- |  |      |     | |-----| |---- |     | |-----| |-----  ------
+   |     |  |      |     | |     | |     |     |       | |       |
+   | ____|  |_____ | ____| | ____| |     |_____|  _____| |_____  |_____
+
+
+   Licensed under the MIT License <http://opensource.org/licenses/MIT>.
+
+   Copyright © 2020-2024 Microsoft Corporation. All rights reserved.
+   Author : <blobfusedev@microsoft.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a copy
+   of this software and associated documentation files (the "Software"), to deal
+   in the Software without restriction, including without limitation the rights
+   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+   copies of the Software, and to permit persons to whom the Software is
+   furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in all
+   copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+   SOFTWARE
+*/
+
+package shared_cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type sharedCacheTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	dir    string
+	server *Server
+	client *Client
+}
+
+func (s *sharedCacheTestSuite) SetupTest() {
+	s.assert = assert.New(s.T())
+
+	// Unix socket paths are limited in length so keep it short
+	var err error
+	s.dir, err = os.MkdirTemp("", "sc")
+	s.assert.NoError(err)
+
+	s.server = NewServer(filepath.Join(s.dir, "cache.sock"), 1024)
+	s.assert.NoError(s.server.Start())
+	s.client = NewClient(filepath.Join(s.dir, "cache.sock"), 4)
+}
+
+func (s *sharedCacheTestSuite) TearDownTest() {
+	s.client.Close()
+	s.server.Stop()
+	_ = os.RemoveAll(s.dir)
+}
+
+func (s *sharedCacheTestSuite) TestGetPut() {
+	buf := make([]byte, 512)
+
+	_, found := s.client.Get("a", buf)
+	s.assert.False(found)
+
+	s.client.Put("a", []byte("hello"))
+	n, found := s.client.Get("a", buf)
+	s.assert.True(found)
+	s.assert.Equal("hello", string(buf[:n]))
+
+	// Block put by another mount is served from the copy already cached
+	other := NewClient(filepath.Join(s.dir, "cache.sock"), 1)
+	defer other.Close()
+	other.Put("a", []byte("hello"))
+	n, found = other.Get("a", buf)
+	s.assert.True(found)
+	s.assert.Equal("hello", string(buf[:n]))
+
+	stats, err := s.client.Stats()
+	s.assert.NoError(err)
+	s.assert.Equal(1, stats.Blocks)
+	s.assert.EqualValues(5, stats.Used)
+	s.assert.EqualValues(2, stats.Hits)
+	s.assert.EqualValues(1, stats.Misses)
+}
+
+func (s *sharedCacheTestSuite) TestPutAsync() {
+	buf := make([]byte, 512)
+
+	// Buffer is copied, so it can be reused right away
+	data := []byte("hello")
+	s.client.PutAsync("a", data)
+	copy(data, "world")
+
+	s.client.wg.Wait()
+	n, found := s.client.Get("a", buf)
+	s.assert.True(found)
+	s.assert.Equal("hello", string(buf[:n]))
+
+	// Blocks are not cached when too many puts are in progress
+	for i := 0; i < cap(s.client.puts); i++ {
+		s.client.puts <- struct{}{}
+	}
+	s.client.PutAsync("b", data)
+	for i := 0; i < cap(s.client.puts); i++ {
+		<-s.client.puts
+	}
+
+	s.client.wg.Wait()
+	_, found = s.client.Get("b", buf)
+	s.assert.False(found)
+}
+
+func (s *sharedCacheTestSuite) TestEviction() {
+	block := bytes.Repeat([]byte{'x'}, 300)
+	buf := make([]byte, 512)
+
+	s.client.Put("a", block)
+	s.client.Put("b", block)
+	s.client.Put("c", block)
+
+	// a is used recently so b is the one to go
+	_, found := s.client.Get("a", buf)
+	s.assert.True(found)
+	s.client.Put("d", block)
+
+	_, found = s.client.Get("b", buf)
+	s.assert.False(found)
+	for _, key := range []string{"a", "c", "d"} {
+		_, found = s.client.Get(key, buf)
+		s.assert.True(found, key)
+	}
+
+	// Block larger than the cache is not kept
+	s.client.Put("e", make([]byte, 2048))
+	_, found = s.client.Get("e", buf)
+	s.assert.False(found)
+
+	stats := s.server.Stats()
+	s.assert.EqualValues(900, stats.Used)
+	s.assert.EqualValues(1, stats.Evictions)
+}
+
+func (s *sharedCacheTestSuite) TestParallel() {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buf := make([]byte, 64)
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("%v_%v", i, j)
+				s.client.Put(key, []byte(key))
+				n, found := s.client.Get(key, buf)
+				if found {
+					s.assert.Equal(key, string(buf[:n]))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s.assert.LessOrEqual(s.server.Stats().Used, uint64(1024))
+}
+
+func (s *sharedCacheTestSuite) TestSmallBuffer() {
+	s.client.Put("a", []byte("hello"))
+
+	_, found := s.client.Get("a", make([]byte, 2))
+	s.assert.False(found)
+}
+
+func (s *sharedCacheTestSuite) TestUnavailable() {
+	client := NewClient(filepath.Join(s.dir, "none.sock"), 1)
+	defer client.Close()
+
+	_, found := client.Get("a", make([]byte, 8))
+	s.assert.False(found)
+	s.assert.True(client.retryAt.After(time.Now()))
+
+	_, err := client.Stats()
+	s.assert.Error(err)
+}
+
+func (s *sharedCacheTestSuite) TestAlreadyRunning() {
+	server := NewServer(filepath.Join(s.dir, "cache.sock"), 1024)
+	s.assert.Error(server.Start())
+
+	// Socket of a daemon which is gone is replaced
+	stale := filepath.Join(s.dir, "stale.sock")
+	s.assert.NoError(os.WriteFile(stale, nil, 0600))
+	server = NewServer(stale, 1024)
+	s.assert.NoError(server.Start())
+	server.Stop()
+}
+
+func TestSharedCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(sharedCacheTestSuite))
+}